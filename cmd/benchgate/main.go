@@ -0,0 +1,131 @@
+package main
+
+/*
+  Compares `go test -bench` output against a stored baseline and fails if any
+  benchmark got slower (or allocates more) than the allowed threshold.
+
+  go test -run '^$' -bench . -benchmem ./validateBankAccount | go run ./cmd/benchgate -baseline benchmarks/baseline.txt
+
+  Pass -update to rewrite the baseline with the current results instead.
+*/
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Result struct {
+	NsPerOp     float64
+	AllocsPerOp float64
+}
+
+// BenchmarkCheckProviders/providers=10-8   	    3456	    345678 ns/op	   12345 B/op	     123 allocs/op
+var benchLine = regexp.MustCompile(`^(Benchmark\S+?)(?:-\d+)?\s+\d+\s+(.*)$`)
+
+// Parses benchmark output, ignoring anything that isn't a result line. When a
+// benchmark appears more than once (-count) the fastest run is kept.
+func parse(r io.Reader) (map[string]Result, error) {
+	results := map[string]Result{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		match := benchLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		var result Result
+		fields := strings.Fields(match[2])
+		for i := 0; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad value in %q: %w", line, err)
+			}
+			switch fields[i+1] {
+			case "ns/op":
+				result.NsPerOp = value
+			case "allocs/op":
+				result.AllocsPerOp = value
+			}
+		}
+		if existing, exists := results[match[1]]; exists && existing.NsPerOp < result.NsPerOp {
+			continue
+		}
+		results[match[1]] = result
+	}
+	return results, scanner.Err()
+}
+
+// Returns a line per benchmark that regressed by more than threshold percent.
+// Benchmarks missing from either side are ignored so adding one doesn't break the gate.
+func compare(baseline, current map[string]Result, threshold float64) []string {
+	regressions := []string{}
+	limit := 1 + threshold/100
+	for name, now := range current {
+		before, exists := baseline[name]
+		if !exists {
+			continue
+		}
+		if before.NsPerOp > 0 && now.NsPerOp > before.NsPerOp*limit {
+			regressions = append(regressions, fmt.Sprintf("%s: %.0f ns/op -> %.0f ns/op (+%.1f%%)",
+				name, before.NsPerOp, now.NsPerOp, (now.NsPerOp/before.NsPerOp-1)*100))
+		}
+		if now.AllocsPerOp > before.AllocsPerOp*limit && now.AllocsPerOp-before.AllocsPerOp >= 1 {
+			regressions = append(regressions, fmt.Sprintf("%s: %.0f allocs/op -> %.0f allocs/op",
+				name, before.AllocsPerOp, now.AllocsPerOp))
+		}
+	}
+	sort.Strings(regressions)
+	return regressions
+}
+
+func main() {
+	baselinePath := flag.String("baseline", "benchmarks/baseline.txt", "stored benchmark output to compare against")
+	threshold := flag.Float64("threshold", 20, "allowed regression in percent")
+	update := flag.Bool("update", false, "overwrite the baseline with stdin instead of comparing")
+	flag.Parse()
+
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *update {
+		if err := os.WriteFile(*baselinePath, input, 0644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("baseline written to %s", *baselinePath)
+		return
+	}
+
+	current, err := parse(strings.NewReader(string(input)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(current) == 0 {
+		log.Fatal("no benchmark results on stdin")
+	}
+	file, err := os.Open(*baselinePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	baseline, err := parse(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	regressions := compare(baseline, current, *threshold)
+	if len(regressions) > 0 {
+		for _, regression := range regressions {
+			fmt.Println(regression)
+		}
+		os.Exit(1)
+	}
+	fmt.Printf("%d benchmarks within %.0f%% of baseline\n", len(current), *threshold)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parse(t *testing.T) {
+	input := `goos: linux
+goarch: amd64
+pkg: accountvalidator/validateBankAccount
+BenchmarkCheckProviders/providers=1-8         	    1000	    200000 ns/op	    6000 B/op	      70 allocs/op
+BenchmarkCheckProviders/providers=1-8         	    1000	    100000 ns/op	    6000 B/op	      70 allocs/op
+BenchmarkMarshalResponse/providers=10         	  500000	      2500 ns/op	     900 B/op	       4 allocs/op
+PASS
+ok  	accountvalidator/validateBankAccount	3.21s
+`
+	got, err := parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Result{
+		"BenchmarkCheckProviders/providers=1":   {NsPerOp: 100000, AllocsPerOp: 70},
+		"BenchmarkMarshalResponse/providers=10": {NsPerOp: 2500, AllocsPerOp: 4},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parse() = %v, want %v", got, want)
+	}
+}
+
+func Test_compare(t *testing.T) {
+	baseline := map[string]Result{
+		"BenchmarkA": {NsPerOp: 1000, AllocsPerOp: 10},
+		"BenchmarkB": {NsPerOp: 1000, AllocsPerOp: 0},
+	}
+	tests := []struct {
+		name    string
+		current map[string]Result
+		want    []string
+	}{
+		{name: "withinThreshold",
+			current: map[string]Result{"BenchmarkA": {NsPerOp: 1150, AllocsPerOp: 11}},
+			want:    []string{},
+		},
+		{name: "slower",
+			current: map[string]Result{"BenchmarkA": {NsPerOp: 1300, AllocsPerOp: 10}},
+			want:    []string{"BenchmarkA: 1000 ns/op -> 1300 ns/op (+30.0%)"},
+		},
+		{name: "moreAllocs",
+			current: map[string]Result{"BenchmarkB": {NsPerOp: 1000, AllocsPerOp: 2}},
+			want:    []string{"BenchmarkB: 0 allocs/op -> 2 allocs/op"},
+		},
+		{name: "newBenchmark",
+			current: map[string]Result{"BenchmarkC": {NsPerOp: 99999}},
+			want:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compare(baseline, tt.current, 20); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compare() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
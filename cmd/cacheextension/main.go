@@ -0,0 +1,327 @@
+package main
+
+/*
+  An external Lambda extension that caches secrets and SSM parameters for the handler. Without it every cold start
+  fetches every secret from Secrets Manager and every container rechecks them on its own. With it the fetches happen
+  once per execution environment, in a process that outlives the handler's restarts, and stale values are refreshed
+  between requests rather than while a caller waits.
+
+  It listens on localhost, on CACHE_EXTENSION_PORT (default 2775), with the same paths as AWS's own parameters and
+  secrets extension so either can sit behind the handler:
+
+    GET /secretsmanager/get?secretId=accountvalidator/provider2
+    GET /systemsmanager/parameters/get?name=/accountvalidator/flags&withDecryption=true
+
+  Every request needs the function's session token in X-Aws-Parameters-Secrets-Token, so nothing that can make the
+  function send a request to localhost can read them. Values are kept for CACHE_TTL_SECONDS (default 300), after
+  which the cached value is still served and a refresh goes on in the background, and everything stale is refreshed
+  each time the function is invoked. A value that can't be refreshed keeps being served until it can.
+
+  make build-extension puts it in bin/extension/extensions, the layout a layer needs, see serverless.yml.
+*/
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+const (
+	defaultPort  = "2775"
+	defaultTTL   = 5 * time.Minute
+	fetchTimeout = 2 * time.Second
+	tokenHeader  = "X-Aws-Parameters-Secrets-Token"
+)
+
+// What the cache fetches a value with, a secret or a parameter
+type fetcher func(ctx context.Context, key string) (json.RawMessage, error)
+
+type entry struct {
+	value      json.RawMessage
+	fetchedAt  time.Time
+	refreshing bool
+}
+
+type cache struct {
+	mu      sync.Mutex
+	fetch   fetcher
+	entries map[string]*entry
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+func newCache(fetch fetcher, ttl time.Duration) *cache {
+	return &cache{fetch: fetch, entries: map[string]*entry{}, ttl: ttl, now: time.Now}
+}
+
+// The cached value, fetched when we've never had it and refreshed in the background when it's stale
+func (cache *cache) get(ctx context.Context, key string) (json.RawMessage, error) {
+	cache.mu.Lock()
+	if cached, exists := cache.entries[key]; exists {
+		if !cached.refreshing && cache.now().Sub(cached.fetchedAt) >= cache.ttl {
+			cached.refreshing = true
+			go cache.refresh(key)
+		}
+		cache.mu.Unlock()
+		return cached.value, nil
+	}
+	cache.mu.Unlock()
+
+	value, err := cache.fetch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	cache.entries[key] = &entry{value: value, fetchedAt: cache.now()}
+	cache.mu.Unlock()
+	return value, nil
+}
+
+// Failures keep the old value until the next try
+func (cache *cache) refresh(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	value, err := cache.fetch(ctx, key)
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cached := cache.entries[key]
+	cached.refreshing = false
+	if err != nil {
+		log.Printf("unable to refresh %s, keeping the cached value: %v", key, err)
+		return
+	}
+	cached.value, cached.fetchedAt = value, cache.now()
+}
+
+// Refreshes everything that's gone stale and waits for it, for between invocations
+func (cache *cache) refreshStale() {
+	cache.mu.Lock()
+	stale := []string{}
+	for key, cached := range cache.entries {
+		if !cached.refreshing && cache.now().Sub(cached.fetchedAt) >= cache.ttl {
+			cached.refreshing = true
+			stale = append(stale, key)
+		}
+	}
+	cache.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, key := range stale {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			cache.refresh(key)
+		}(key)
+	}
+	wg.Wait()
+}
+
+type server struct {
+	secrets    *cache
+	parameters *cache
+	token      string
+}
+
+func (server *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if server.token == "" || r.Header.Get(tokenHeader) != server.token {
+		http.Error(w, "missing or wrong "+tokenHeader, http.StatusForbidden)
+		return
+	}
+	var values *cache
+	var key string
+	switch r.URL.Path {
+	case "/secretsmanager/get":
+		values, key = server.secrets, r.URL.Query().Get("secretId")
+	case "/systemsmanager/parameters/get":
+		values, key = server.parameters, r.URL.Query().Get("name")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if key == "" {
+		http.Error(w, "secretId or name is required", http.StatusBadRequest)
+		return
+	}
+	value, err := values.get(r.Context(), key)
+	if err != nil {
+		log.Printf("unable to fetch %s: %v", key, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(value)
+}
+
+// Secrets Manager's GetSecretValue output, as the SDK gives it
+func secretFetcher(client *secretsmanager.Client) fetcher {
+	return func(ctx context.Context, secretID string) (json.RawMessage, error) {
+		output, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(secretID)})
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(map[string]interface{}{
+			"ARN":           output.ARN,
+			"Name":          output.Name,
+			"SecretString":  output.SecretString,
+			"VersionId":     output.VersionId,
+			"VersionStages": output.VersionStages,
+		})
+	}
+}
+
+// SSM isn't in our SDK, GetParameter is signed by hand. Decrypted, SecureStrings are only ever asked for that way.
+func parameterFetcher(cfg aws.Config) fetcher {
+	signer := v4.NewSigner()
+	client := &http.Client{Timeout: fetchTimeout}
+	endpoint := "https://ssm." + cfg.Region + ".amazonaws.com/"
+	return func(ctx context.Context, name string) (json.RawMessage, error) {
+		data, err := json.Marshal(map[string]interface{}{"Name": name, "WithDecryption": true})
+		if err != nil {
+			return nil, err
+		}
+		request, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/x-amz-json-1.1")
+		request.Header.Set("X-Amz-Target", "AmazonSSM.GetParameter")
+		credentials, err := cfg.Credentials.Retrieve(ctx)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(data)
+		if err := signer.SignHTTP(ctx, credentials, request, hex.EncodeToString(hash[:]), "ssm", cfg.Region, time.Now()); err != nil {
+			return nil, err
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			return nil, fmt.Errorf("ssm GetParameter failed: %s %s", response.Status, body)
+		}
+		return body, nil
+	}
+}
+
+// The extensions API, https://docs.aws.amazon.com/lambda/latest/dg/runtimes-extensions-api.html
+type extensionsAPI struct {
+	base   string
+	client *http.Client
+	id     string
+}
+
+func register(runtimeAPI, name string) (*extensionsAPI, error) {
+	api := &extensionsAPI{base: "http://" + runtimeAPI + "/2020-01-01/extension", client: &http.Client{}}
+	request, err := http.NewRequest("POST", api.base+"/register", bytes.NewReader([]byte(`{"events": ["INVOKE", "SHUTDOWN"]}`)))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Lambda-Extension-Name", name)
+	response, err := api.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("unable to register the extension: %s", response.Status)
+	}
+	api.id = response.Header.Get("Lambda-Extension-Identifier")
+	return api, nil
+}
+
+// Blocks until the next invocation or shutdown, returning its type
+func (api *extensionsAPI) next() (string, error) {
+	request, err := http.NewRequest("GET", api.base+"/event/next", nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Lambda-Extension-Identifier", api.id)
+	response, err := api.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	var event struct {
+		EventType string `json:"eventType"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&event); err != nil {
+		return "", err
+	}
+	return event.EventType, nil
+}
+
+func ttl() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("CACHE_TTL_SECONDS"))
+	if err != nil || seconds <= 0 {
+		return defaultTTL
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func main() {
+	runtimeAPI, exists := os.LookupEnv("AWS_LAMBDA_RUNTIME_API")
+	if !exists {
+		log.Fatal("AWS_LAMBDA_RUNTIME_API isn't set, this only runs as a Lambda extension")
+	}
+	api, err := register(runtimeAPI, filepath.Base(os.Args[0]))
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	port := os.Getenv("CACHE_EXTENSION_PORT")
+	if port == "" {
+		port = defaultPort
+	}
+	server := &server{
+		secrets:    newCache(secretFetcher(secretsmanager.NewFromConfig(cfg)), ttl()),
+		parameters: newCache(parameterFetcher(cfg), ttl()),
+		token:      os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	go func() {
+		if err := http.ListenAndServe("127.0.0.1:"+port, server); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	for {
+		event, err := api.next()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if event == "SHUTDOWN" {
+			return
+		}
+		// The handler's running too, so this doesn't hold it up, and we're not frozen until both are done
+		go server.secrets.refreshStale()
+		go server.parameters.refreshStale()
+	}
+}
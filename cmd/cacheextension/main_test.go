@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countingFetcher struct {
+	mu    sync.Mutex
+	calls int
+	fail  bool
+}
+
+func (fetcher *countingFetcher) fetch(ctx context.Context, key string) (json.RawMessage, error) {
+	fetcher.mu.Lock()
+	defer fetcher.mu.Unlock()
+	fetcher.calls++
+	if fetcher.fail {
+		return nil, errors.New("throttled")
+	}
+	return json.Marshal(map[string]interface{}{"Name": key, "Version": fetcher.calls})
+}
+
+func (fetcher *countingFetcher) count() int {
+	fetcher.mu.Lock()
+	defer fetcher.mu.Unlock()
+	return fetcher.calls
+}
+
+func Test_cache(t *testing.T) {
+	fetcher := &countingFetcher{}
+	now := time.Unix(0, 0)
+	values := newCache(fetcher.fetch, time.Minute)
+	values.now = func() time.Time { return now }
+
+	first, err := values.get(context.Background(), "accountvalidator/provider2")
+	if err != nil || string(first) != `{"Name":"accountvalidator/provider2","Version":1}` {
+		t.Fatalf("get() = %s, %v", first, err)
+	}
+	if again, _ := values.get(context.Background(), "accountvalidator/provider2"); string(again) != string(first) || fetcher.count() != 1 {
+		t.Errorf("get() fetched again within the ttl")
+	}
+
+	// Stale, a failed refresh keeps the old value and a good one replaces it
+	now = now.Add(2 * time.Minute)
+	fetcher.fail = true
+	values.refreshStale()
+	if stale := values.entries["accountvalidator/provider2"].value; string(stale) != string(first) {
+		t.Errorf("value after a failed refresh = %s, want the cached value", stale)
+	}
+	fetcher.fail = false
+	values.refreshStale()
+	if refreshed := values.entries["accountvalidator/provider2"].value; string(refreshed) != `{"Name":"accountvalidator/provider2","Version":3}` {
+		t.Errorf("value after refreshing = %s, want the refreshed value", refreshed)
+	}
+}
+
+func Test_server(t *testing.T) {
+	secrets, parameters := &countingFetcher{}, &countingFetcher{}
+	handler := &server{secrets: newCache(secrets.fetch, time.Minute), parameters: newCache(parameters.fetch, time.Minute), token: "session"}
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		wantStatus int
+		wantBody   string
+	}{
+		{"secret", "GET", "/secretsmanager/get?secretId=accountvalidator/provider2", "session", 200, `{"Name":"accountvalidator/provider2","Version":1}`},
+		{"parameter", "GET", "/systemsmanager/parameters/get?name=/accountvalidator/flags&withDecryption=true", "session", 200, `{"Name":"/accountvalidator/flags","Version":1}`},
+		{"noToken", "GET", "/secretsmanager/get?secretId=accountvalidator/provider2", "", 403, ""},
+		{"wrongToken", "GET", "/secretsmanager/get?secretId=accountvalidator/provider2", "other", 403, ""},
+		{"noKey", "GET", "/secretsmanager/get", "session", 400, ""},
+		{"unknownPath", "GET", "/secrets", "session", 404, ""},
+		{"post", "POST", "/secretsmanager/get?secretId=accountvalidator/provider2", "session", 405, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				request.Header.Set(tokenHeader, tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != tt.wantStatus || (tt.wantBody != "" && recorder.Body.String() != tt.wantBody) {
+				t.Errorf("ServeHTTP() = %d %s, want %d %s", recorder.Code, recorder.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_register(t *testing.T) {
+	runtime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2020-01-01/extension/register":
+			if r.Header.Get("Lambda-Extension-Name") != "accountvalidator-cache" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Lambda-Extension-Identifier", "ext-1")
+			w.Write([]byte("{}"))
+		case "/2020-01-01/extension/event/next":
+			if r.Header.Get("Lambda-Extension-Identifier") != "ext-1" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"eventType": "SHUTDOWN"}`))
+		}
+	}))
+	defer runtime.Close()
+
+	api, err := register(runtime.Listener.Addr().String(), "accountvalidator-cache")
+	if err != nil {
+		t.Fatalf("register() = %v", err)
+	}
+	if event, err := api.next(); err != nil || event != "SHUTDOWN" {
+		t.Errorf("next() = %s, %v, want SHUTDOWN", event, err)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+/*
+  Fires validation requests at a fixed rate against the local server mode or a deployed endpoint, then reports
+  latency percentiles and a breakdown of provider errors. Used to size provisioned concurrency.
+
+  SERVER_ADDR=:8080 PROVIDERS="$(cat providers.yaml)" go run ./validateBankAccount &
+  go run ./cmd/loadtest -url http://localhost:8080/application -rps 50 -duration 30s
+*/
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type validationRequest struct {
+	AccountNumber string    `json:"accountNumber"`
+	Providers     *[]string `json:"providers,omitempty"`
+}
+
+type validationResponse struct {
+	Result []struct {
+		Provider string `json:"provider"`
+		IsValid  bool   `json:"isValid"`
+		Error    string `json:"error"`
+	} `json:"result"`
+}
+
+// Outcome of a single request
+type Sample struct {
+	Latency        time.Duration
+	StatusCode     int
+	Err            error
+	ProviderErrors map[string]string
+}
+
+type Report struct {
+	Requests       int
+	Failed         int
+	Elapsed        time.Duration
+	StatusCodes    map[int]int
+	P50, P95, P99  time.Duration
+	Max            time.Duration
+	ProviderErrors map[string]map[string]int
+}
+
+// Synthetic 8 digit account number
+func accountNumber(rng *rand.Rand) string {
+	return fmt.Sprintf("%08d", rng.Intn(100000000))
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
+
+func summarise(samples []Sample, elapsed time.Duration) Report {
+	report := Report{
+		Requests:       len(samples),
+		Elapsed:        elapsed,
+		StatusCodes:    map[int]int{},
+		ProviderErrors: map[string]map[string]int{},
+	}
+	latencies := make([]time.Duration, 0, len(samples))
+	for _, sample := range samples {
+		latencies = append(latencies, sample.Latency)
+		if sample.Err != nil {
+			report.Failed++
+			continue
+		}
+		report.StatusCodes[sample.StatusCode]++
+		for provider, code := range sample.ProviderErrors {
+			if report.ProviderErrors[provider] == nil {
+				report.ProviderErrors[provider] = map[string]int{}
+			}
+			report.ProviderErrors[provider][code]++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P50 = percentile(latencies, 0.50)
+	report.P95 = percentile(latencies, 0.95)
+	report.P99 = percentile(latencies, 0.99)
+	if len(latencies) > 0 {
+		report.Max = latencies[len(latencies)-1]
+	}
+	return report
+}
+
+func (report Report) Print(w io.Writer) {
+	rate := float64(report.Requests) / report.Elapsed.Seconds()
+	fmt.Fprintf(w, "requests: %d (%.1f/s), transport failures: %d\n", report.Requests, rate, report.Failed)
+	codes := make([]int, 0, len(report.StatusCodes))
+	for code := range report.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	fmt.Fprint(w, "status:")
+	for _, code := range codes {
+		fmt.Fprintf(w, " %d=%d", code, report.StatusCodes[code])
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "latency: p50=%v p95=%v p99=%v max=%v\n", report.P50, report.P95, report.P99, report.Max)
+	if len(report.ProviderErrors) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "provider errors:")
+	providers := make([]string, 0, len(report.ProviderErrors))
+	for provider := range report.ProviderErrors {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+	for _, provider := range providers {
+		codes := []string{}
+		for code, count := range report.ProviderErrors[provider] {
+			codes = append(codes, fmt.Sprintf("%s=%d", code, count))
+		}
+		sort.Strings(codes)
+		fmt.Fprintf(w, "  %s %s\n", provider, strings.Join(codes, " "))
+	}
+}
+
+func fire(client *http.Client, url string, headers map[string]string, payload []byte) Sample {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return Sample{Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	start := time.Now()
+	response, err := client.Do(req)
+	if err != nil {
+		return Sample{Latency: time.Since(start), Err: err}
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	sample := Sample{Latency: time.Since(start), StatusCode: response.StatusCode, Err: err}
+	if err != nil {
+		return sample
+	}
+
+	var parsed validationResponse
+	if json.Unmarshal(body, &parsed) == nil {
+		for _, result := range parsed.Result {
+			if result.Error != "" {
+				if sample.ProviderErrors == nil {
+					sample.ProviderErrors = map[string]string{}
+				}
+				sample.ProviderErrors[result.Provider] = result.Error
+			}
+		}
+	}
+	return sample
+}
+
+type headerFlags map[string]string
+
+func (h headerFlags) String() string { return fmt.Sprint(map[string]string(h)) }
+
+func (h headerFlags) Set(value string) error {
+	name, headerValue, found := strings.Cut(value, ":")
+	if !found {
+		return fmt.Errorf("header %q should look like Name: value", value)
+	}
+	h[strings.TrimSpace(name)] = strings.TrimSpace(headerValue)
+	return nil
+}
+
+func main() {
+	url := flag.String("url", "http://localhost:8080/application", "validation endpoint")
+	rps := flag.Int("rps", 10, "requests per second")
+	duration := flag.Duration("duration", 10*time.Second, "how long to fire for")
+	timeout := flag.Duration("timeout", 5*time.Second, "per request timeout")
+	providers := flag.String("providers", "", "comma separated providers filter, all providers when empty")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "seed for the synthetic account numbers")
+	headers := headerFlags{}
+	flag.Var(headers, "H", "extra request header, e.g. -H 'x-api-key: abc' (repeatable)")
+	flag.Parse()
+
+	if *rps <= 0 {
+		log.Fatal("-rps must be positive")
+	}
+	var filter *[]string
+	if *providers != "" {
+		names := strings.Split(*providers, ",")
+		filter = &names
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	rng := rand.New(rand.NewSource(*seed))
+	ticker := time.NewTicker(time.Second / time.Duration(*rps))
+	defer ticker.Stop()
+	deadline := time.After(*duration)
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		samples []Sample
+	)
+	start := time.Now()
+fire:
+	for {
+		select {
+		case <-deadline:
+			break fire
+		case <-ticker.C:
+			payload, _ := json.Marshal(validationRequest{AccountNumber: accountNumber(rng), Providers: filter})
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sample := fire(client, *url, headers, payload)
+				mu.Lock()
+				samples = append(samples, sample)
+				mu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+
+	summarise(samples, time.Since(start)).Print(os.Stdout)
+}
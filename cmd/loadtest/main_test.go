@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_percentile(t *testing.T) {
+	sorted := []time.Duration{}
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{name: "p50", sorted: sorted, p: 0.50, want: 50 * time.Millisecond},
+		{name: "p99", sorted: sorted, p: 0.99, want: 99 * time.Millisecond},
+		{name: "single", sorted: sorted[:1], p: 0.95, want: time.Millisecond},
+		{name: "empty", sorted: nil, p: 0.5, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); got != tt.want {
+				t.Errorf("percentile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_summarise(t *testing.T) {
+	samples := []Sample{
+		{Latency: 10 * time.Millisecond, StatusCode: 200},
+		{Latency: 30 * time.Millisecond, StatusCode: 200, ProviderErrors: map[string]string{"provider1": "timeout"}},
+		{Latency: 20 * time.Millisecond, StatusCode: 500},
+		{Latency: 40 * time.Millisecond, Err: errors.New("connection refused")},
+	}
+	got := summarise(samples, time.Second)
+	want := Report{
+		Requests:       4,
+		Failed:         1,
+		Elapsed:        time.Second,
+		StatusCodes:    map[int]int{200: 2, 500: 1},
+		P50:            20 * time.Millisecond,
+		P95:            40 * time.Millisecond,
+		P99:            40 * time.Millisecond,
+		Max:            40 * time.Millisecond,
+		ProviderErrors: map[string]map[string]int{"provider1": {"timeout": 1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summarise() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_fire(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(403)
+			return
+		}
+		w.Write([]byte("{\"result\":[{\"provider\":\"provider1\",\"isValid\":true},{\"provider\":\"provider2\",\"isValid\":false,\"error\":\"timeout\"}]}"))
+	}))
+	defer server.Close()
+
+	got := fire(server.Client(), server.URL, map[string]string{"X-Api-Key": "secret"}, []byte("{}"))
+	if got.Err != nil || got.StatusCode != 200 {
+		t.Fatalf("fire() = %+v", got)
+	}
+	if want := map[string]string{"provider2": "timeout"}; !reflect.DeepEqual(got.ProviderErrors, want) {
+		t.Errorf("fire() provider errors = %v, want %v", got.ProviderErrors, want)
+	}
+}
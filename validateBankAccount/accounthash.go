@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+)
+
+/*
+  Account hashes. Wherever an account has to be recognisable without the number being readable (SNS attributes, the
+  audit, verdict and feedback tables, receipts, webhook events, body log tokens) it goes by an HMAC-SHA256 of the
+  number, hex. A plain hash of an 8 digit number is undone by hashing all hundred million of them, so the key is a
+  secret, normally from Secrets Manager:
+
+    accountHashing:
+      key:
+        id: "2024-06"
+        secretId: accountvalidator/account-hash
+
+  The key is read once when the container starts, and in Lambda a container that can't read it, or has none
+  configured, doesn't start. Hashes key the verdict, audit and feedback tables, so a new key starts their histories
+  over and it shouldn't be rotated like the signing keys are. The standalone server and tests can run without one,
+  hashing with an empty key, which is no better than a plain hash and logged as such.
+*/
+
+type AccountHashingConfig struct {
+	Key *SigningKey `yaml:"key"`
+}
+
+// Set up at init by setupAccountHashing
+var accountHashKey []byte
+
+func accountHash(accountNumber string) string {
+	return keyedHash([]byte(accountNumber))
+}
+
+// HMAC-SHA256 with the account hashing key, hex. Anything that hashes an account number along with other things (the
+// cache key) goes through this too.
+func keyedHash(data []byte) string {
+	mac := hmac.New(sha256.New, accountHashKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (hashing AccountHashingConfig) validate() error {
+	if hashing.Key == nil {
+		return nil
+	}
+	return hashing.Key.validate()
+}
+
+// Reads the key, an error when the container shouldn't serve without it
+func (config *Config) setupAccountHashing(ctx context.Context) error {
+	key := config.AccountHashing.Key
+	if key == nil {
+		if _, inLambda := os.LookupEnv("AWS_LAMBDA_FUNCTION_NAME"); inLambda {
+			return errors.New("accountHashing key is required")
+		}
+		log.Print("accountHashing has no key, account hashes can be reversed")
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, secretFetchTimeout)
+	defer cancel()
+	secret, err := key.secret(ctx)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return errors.New("accountHashing key has no secret")
+	}
+	accountHashKey = []byte(secret)
+	return nil
+}
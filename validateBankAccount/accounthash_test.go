@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestConfig_setupAccountHashing(t *testing.T) {
+	defer func(key []byte) { accountHashKey = key }(accountHashKey)
+	unkeyed := accountHash("12345670")
+
+	config := &Config{AccountHashing: AccountHashingConfig{Key: &SigningKey{ID: "1", Secret: "s3cret"}}}
+	if err := config.setupAccountHashing(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte("12345670"))
+	if got := accountHash("12345670"); got != hex.EncodeToString(mac.Sum(nil)) || got == unkeyed {
+		t.Errorf("accountHash() = %s, want the HMAC with the key", got)
+	}
+
+	config = &Config{}
+	if err := config.setupAccountHashing(context.Background()); err != nil {
+		t.Errorf("setupAccountHashing() = %v, want it to carry on outside Lambda", err)
+	}
+	t.Setenv("AWS_LAMBDA_FUNCTION_NAME", "validateBankAccount")
+	if err := config.setupAccountHashing(context.Background()); err == nil {
+		t.Error("setupAccountHashing() should fail in Lambda without a key")
+	}
+	config = &Config{AccountHashing: AccountHashingConfig{Key: &SigningKey{ID: "1", SecretID: "accountvalidator/account-hash"}}}
+	if err := config.setupAccountHashing(context.Background()); err == nil {
+		t.Error("setupAccountHashing() should fail when the key can't be read")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+/*
+  Account type and currency. A request can say what kind of account it is and what currency it holds:
+
+    {"accountNumber": "12345678", "accountType": "savings", "currency": "GBP"}
+
+  accountType is current, savings or business and currency is an ISO 4217 code. Providers say what they can handle:
+
+    - name: provider1
+      accountTypes: [current, business]
+      currencies: [GBP, EUR]
+
+  A provider that lists account types isn't called for a request with a type it doesn't list, the same for
+  currencies, and it gets the field in the default request body. Providers that don't list them are called for
+  anything and never sent the field. Request templates can use .AccountType and .Currency, which are empty when the
+  request didn't say.
+*/
+
+const (
+	AccountTypeCurrent  = "current"
+	AccountTypeSavings  = "savings"
+	AccountTypeBusiness = "business"
+)
+
+var accountTypes = []string{AccountTypeCurrent, AccountTypeSavings, AccountTypeBusiness}
+
+// Why a provider was routed away from, in dry runs
+const ProviderSkippedUnsupported = "unsupported_account"
+
+// What the request says about the account beyond its number, carried to the provider calls on the context
+type AccountDetails struct {
+	AccountType string
+	Currency    string
+	// For sepa providers, see sepa.go
+	BIC string
+}
+
+type accountDetailsKey struct{}
+
+func validateAccountType(accountType string) error {
+	for _, known := range accountTypes {
+		if accountType == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown accountType %s", accountType)
+}
+
+func validateCurrency(currency string) error {
+	if len(currency) != 3 {
+		return fmt.Errorf("currency %s should be a three letter ISO 4217 code", currency)
+	}
+	for _, r := range currency {
+		if r < 'A' || r > 'Z' {
+			return fmt.Errorf("currency %s should be a three letter ISO 4217 code", currency)
+		}
+	}
+	return nil
+}
+
+func (validationRequest *BankAccountValidationRequest) accountDetails() AccountDetails {
+	details := AccountDetails{}
+	if validationRequest.AccountType != nil {
+		details.AccountType = *validationRequest.AccountType
+	}
+	if validationRequest.Currency != nil {
+		details.Currency = *validationRequest.Currency
+	}
+	if validationRequest.BIC != nil {
+		details.BIC = strings.ToUpper(strings.TrimSpace(*validationRequest.BIC))
+	}
+	return details
+}
+
+func withAccountDetails(ctx context.Context, details AccountDetails) context.Context {
+	return context.WithValue(ctx, accountDetailsKey{}, details)
+}
+
+func accountDetailsFrom(ctx context.Context) AccountDetails {
+	details, _ := ctx.Value(accountDetailsKey{}).(AccountDetails)
+	return details
+}
+
+func (provider Provider) validateAccountSupport() error {
+	for _, accountType := range provider.AccountTypes {
+		if err := validateAccountType(accountType); err != nil {
+			return fmt.Errorf("provider %s: %w", provider.Name, err)
+		}
+	}
+	for _, currency := range provider.Currencies {
+		if err := validateCurrency(currency); err != nil {
+			return fmt.Errorf("provider %s: %w", provider.Name, err)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the provider can validate the account, providers that don't list types or currencies take anything
+func (provider Provider) supports(details AccountDetails) bool {
+	if details.AccountType != "" && len(provider.AccountTypes) > 0 && !contains(provider.AccountTypes, details.AccountType) {
+		return false
+	}
+	if details.Currency != "" && len(provider.Currencies) > 0 && !contains(provider.Currencies, details.Currency) {
+		return false
+	}
+	return true
+}
+
+// The providers that can validate the account, the same slice when they all can
+func supportingProviders(providers []Provider, details AccountDetails) []Provider {
+	if details == (AccountDetails{}) {
+		return providers
+	}
+	supporting := []Provider{}
+	for _, provider := range providers {
+		if provider.supports(details) {
+			supporting = append(supporting, provider)
+		}
+	}
+	return supporting
+}
+
+// The default request body only carries what the provider says it understands
+func (provider Provider) defaultRequest(data TemplateData) DataProviderRequest {
+	request := DataProviderRequest{AccountNumber: data.AccountNumber}
+	if len(provider.AccountTypes) > 0 {
+		request.AccountType = data.AccountType
+	}
+	if len(provider.Currencies) > 0 {
+		request.Currency = data.Currency
+	}
+	return request
+}
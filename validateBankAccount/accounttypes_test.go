@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_validateCurrency(t *testing.T) {
+	for currency, wantErr := range map[string]bool{"GBP": false, "EUR": false, "gbp": true, "GB": true, "GBPX": true, "G1P": true} {
+		if err := validateCurrency(currency); (err != nil) != wantErr {
+			t.Errorf("validateCurrency(%q) error = %v, wantErr %v", currency, err, wantErr)
+		}
+	}
+	if err := validateAccountType("savings"); err != nil {
+		t.Errorf("validateAccountType() = %v", err)
+	}
+	if _, message, _ := decodeRequest(Request{Body: "{\"accountNumber\": \"12345678\", \"accountType\": \"isa\"}"}); message != "unknown accountType isa" {
+		t.Errorf("decodeRequest() message = %v", message)
+	}
+}
+
+func Test_supportingProviders(t *testing.T) {
+	providers := []Provider{
+		{Name: "anything"},
+		{Name: "personal", AccountTypes: []string{AccountTypeCurrent, AccountTypeSavings}},
+		{Name: "sterling", Currencies: []string{"GBP"}},
+	}
+	tests := []struct {
+		name    string
+		details AccountDetails
+		want    []string
+	}{
+		{"unspecified", AccountDetails{}, []string{"anything", "personal", "sterling"}},
+		{"savings", AccountDetails{AccountType: AccountTypeSavings}, []string{"anything", "personal", "sterling"}},
+		{"business", AccountDetails{AccountType: AccountTypeBusiness}, []string{"anything", "sterling"}},
+		{"euros", AccountDetails{Currency: "EUR"}, []string{"anything", "personal"}},
+		{"businessEuros", AccountDetails{AccountType: AccountTypeBusiness, Currency: "EUR"}, []string{"anything"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := providerNames(supportingProviders(providers, tt.details)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("supportingProviders() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_validate_accountDetails(t *testing.T) {
+	bodies := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+		w.Write([]byte(`{"isValid": true}`))
+	}))
+	defer server.Close()
+	config := &Config{Providers: []Provider{
+		{Name: "typed", URL: server.URL, AccountTypes: []string{AccountTypeSavings}},
+		{Name: "untyped", URL: server.URL},
+		{Name: "business", URL: server.URL, AccountTypes: []string{AccountTypeBusiness}},
+	}}
+	validationRequest, _, err := decodeRequest(Request{Body: "{\"accountNumber\": \"12345678\", \"accountType\": \"savings\", \"currency\": \"GBP\"}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := config.validate(context.Background(), Request{}, validationRequest, nil)
+	close(bodies)
+	if got := len(response.Result); got != 2 {
+		t.Errorf("expected the business provider to be skipped, got %+v", response.Result)
+	}
+	got := map[string]bool{}
+	for body := range bodies {
+		got[body] = true
+	}
+	want := map[string]bool{
+		"{\"accountNumber\":\"12345678\",\"accountType\":\"savings\"}": true,
+		"{\"accountNumber\":\"12345678\"}":                             true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("provider bodies = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*
+  Provider adapters. A partner whose API doesn't fit the request template and validField (OAuth dance, a GET with
+  the account in the path, a verdict spread over three fields...) gets an adapter of its own, in a file of its own,
+  that registers itself under a provider type:
+
+    func init() {
+        RegisterAdapter("acme", ProviderAdapter{Check: checkAcme, Validate: validateAcmeOptions})
+    }
+
+    - name: acme-uk
+      type: acme
+      url: https://api.acme.example/v2/accounts
+      options:           # handed to the adapter as they are
+        tenant: uk
+
+  An adapter is called where an http provider would be, so retries, breakers, endpoints, rollouts, stats, SLAs,
+  quotas and billing all apply as usual. It gets a client with the provider's timeout that sets the provider's
+  headers and the trace headers on every request. Request templates, validField and request signing are left to the
+  adapter. A reason it gives is mapped through the provider's reasons like any other, and an error is reported as
+  request_failed, or timeout if that's what it was.
+
+  The standalone server (server.go) can also load adapters from Go plugins, listed in PROVIDER_PLUGINS:
+
+    PROVIDER_PLUGINS=/opt/adapters/acme.so SERVER_ADDR=:8080 ./bin/server
+
+  A plugin can't import this package, so it exports a Name string, a Check func with AdapterCheck's signature and,
+  if it has options to check, a Validate func with AdapterValidate's. Plugins have to be built with the same Go
+  version and dependencies as the server (go build -buildmode=plugin). Loading them needs cgo, so it's only in
+  binaries built with the plugins tag, which make build-server does and the Lambda build doesn't.
+*/
+
+// Asks the provider at url about the account, whether it's valid and if not the provider's reason
+type AdapterCheck func(ctx context.Context, client *http.Client, url, accountNumber string, options map[string]interface{}) (bool, string, error)
+
+// Checks a provider's options when the config loads
+type AdapterValidate func(options map[string]interface{}) error
+
+type ProviderAdapter struct {
+	Check AdapterCheck
+	// Optional
+	Validate AdapterValidate
+}
+
+var (
+	adaptersMu sync.RWMutex
+	adapters   = map[string]ProviderAdapter{}
+)
+
+// Makes the adapter the provider type's, panicking if the type's taken since that's a build mistake
+func RegisterAdapter(providerType string, adapter ProviderAdapter) {
+	adaptersMu.Lock()
+	defer adaptersMu.Unlock()
+	if builtInType(providerType) {
+		panic("provider type " + providerType + " is built in")
+	}
+	if _, exists := adapters[providerType]; exists {
+		panic("provider type " + providerType + " already has an adapter")
+	}
+	if adapter.Check == nil {
+		panic("the adapter for " + providerType + " has no Check")
+	}
+	adapters[providerType] = adapter
+}
+
+func builtInType(providerType string) bool {
+	switch providerType {
+	case "", ProviderTypeHTTP, ProviderTypeSimulated, ProviderTypeSEPA:
+		return true
+	}
+	return false
+}
+
+// The adapter registered for the type, false for the built in types and unknown ones
+func adapterFor(providerType string) (ProviderAdapter, bool) {
+	adaptersMu.RLock()
+	defer adaptersMu.RUnlock()
+	adapter, exists := adapters[providerType]
+	return adapter, exists
+}
+
+func (adapter ProviderAdapter) validate(provider Provider) error {
+	if adapter.Validate == nil {
+		return nil
+	}
+	if err := adapter.Validate(provider.Options); err != nil {
+		return fmt.Errorf("provider %s has invalid options: %w", provider.Name, err)
+	}
+	return nil
+}
+
+// Sets what the core would on a request to the provider
+type adapterTransport struct {
+	provider Provider
+	base     http.RoundTripper
+}
+
+func (transport adapterTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	transport.provider.setHeaders(request)
+	setTraceHeaders(request.Context(), request)
+	return transport.base.RoundTrip(request)
+}
+
+// Calls the provider through its adapter
+func callAdapter(ctx context.Context, adapter ProviderAdapter, accountNumber string, provider Provider, url string) BankAccountValidationResult {
+	client := &http.Client{
+		Timeout:   provider.timeout(),
+		Transport: adapterTransport{provider: provider, base: captureFrom(ctx).transport(providerTransport, provider)},
+	}
+	result := BankAccountValidationResult{Provider: provider.Name}
+	isValid, rawReason, err := adapter.Check(ctx, client, url, accountNumber, provider.Options)
+	if err != nil {
+		log.Printf("provider %s: %v", provider.Name, err)
+		result.Error = requestError(err)
+		return result
+	}
+	result.IsValid = isValid
+	if !isValid {
+		result.Reason, result.RawReason = provider.normalizeReason(rawReason)
+	}
+	return result
+}
+
+// Registers the adapters in the plugins listed in PROVIDER_PLUGINS, comma separated
+func loadAdapterPlugins() error {
+	paths := os.Getenv("PROVIDER_PLUGINS")
+	if paths == "" {
+		return nil
+	}
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if err := loadAdapterPlugin(path); err != nil {
+			return fmt.Errorf("provider plugin %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// The adapter a plugin exports, given its symbols
+func pluginAdapter(lookup func(string) (interface{}, error)) (ProviderAdapter, string, error) {
+	symbol, err := lookup("Name")
+	if err != nil {
+		return ProviderAdapter{}, "", err
+	}
+	name, ok := symbol.(*string)
+	if !ok || *name == "" {
+		return ProviderAdapter{}, "", errors.New("Name should be a non-empty string")
+	}
+	if _, exists := adapterFor(*name); exists || builtInType(*name) {
+		return ProviderAdapter{}, "", fmt.Errorf("provider type %s is taken", *name)
+	}
+	symbol, err = lookup("Check")
+	if err != nil {
+		return ProviderAdapter{}, "", err
+	}
+	check, ok := symbol.(func(context.Context, *http.Client, string, string, map[string]interface{}) (bool, string, error))
+	if !ok {
+		return ProviderAdapter{}, "", fmt.Errorf("Check is a %T, not an AdapterCheck", symbol)
+	}
+	adapter := ProviderAdapter{Check: check}
+	if symbol, err := lookup("Validate"); err == nil {
+		validate, ok := symbol.(func(map[string]interface{}) error)
+		if !ok {
+			return ProviderAdapter{}, "", fmt.Errorf("Validate is a %T, not an AdapterValidate", symbol)
+		}
+		adapter.Validate = validate
+	}
+	return adapter, *name, nil
+}
@@ -0,0 +1,10 @@
+//go:build !plugins
+
+package main
+
+import "errors"
+
+// Loading plugins needs cgo, so it's only built with the plugins tag (see adapters.go)
+func loadAdapterPlugin(path string) error {
+	return errors.New("this binary was built without the plugins tag")
+}
@@ -0,0 +1,22 @@
+//go:build plugins
+
+package main
+
+import (
+	"log"
+	"plugin"
+)
+
+func loadAdapterPlugin(path string) error {
+	loaded, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	adapter, name, err := pluginAdapter(func(symbol string) (interface{}, error) { return loaded.Lookup(symbol) })
+	if err != nil {
+		return err
+	}
+	RegisterAdapter(name, adapter)
+	log.Printf("loaded the %s adapter from %s", name, path)
+	return nil
+}
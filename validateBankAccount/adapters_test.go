@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A partner that answers GET /accounts/<number> with {"state": "open"} and wants a tenant
+func checkTestAdapter(ctx context.Context, client *http.Client, url, accountNumber string, options map[string]interface{}) (bool, string, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", url+"/accounts/"+accountNumber+"?tenant="+fmt.Sprint(options["tenant"]), nil)
+	if err != nil {
+		return false, "", err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return false, "", err
+	}
+	defer response.Body.Close()
+	var body struct{ State string }
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return false, "", err
+	}
+	return body.State == "open", body.State, nil
+}
+
+func init() {
+	RegisterAdapter("test-adapter", ProviderAdapter{
+		Check: checkTestAdapter,
+		Validate: func(options map[string]interface{}) error {
+			if options["tenant"] == nil {
+				return errors.New("tenant is required")
+			}
+			return nil
+		},
+	})
+}
+
+func TestConfig_Router_adapter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "k" || r.URL.Query().Get("tenant") != "uk" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/accounts/12345678":
+			w.Write([]byte(`{"state": "open"}`))
+		case "/accounts/12345679":
+			w.Write([]byte(`{"state": "shut"}`))
+		default:
+			w.Write([]byte(`nonsense`))
+		}
+	}))
+	defer server.Close()
+	config := &Config{Providers: []Provider{{
+		Name:    "acme",
+		Type:    "test-adapter",
+		URL:     server.URL,
+		Headers: map[string]string{"X-Api-Key": "k"},
+		Reasons: map[string]string{"shut": ReasonAccountClosed},
+		Options: map[string]interface{}{"tenant": "uk"},
+	}}}
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		accountNumber string
+		want          string
+	}{
+		{"12345678", `"isValid":true`},
+		{"12345679", `"reason":"ACCOUNT_CLOSED"`},
+		{"12345670", `"error":"request_failed"`},
+	}
+	for _, tt := range tests {
+		request := Request{HTTPMethod: "POST", Path: "/application", Body: `{"accountNumber": "` + tt.accountNumber + `"}`}
+		if got, err := config.Router(context.Background(), request); err != nil || !strings.Contains(got.Body, tt.want) {
+			t.Errorf("Router(%s) = %s, %v, want %s", tt.accountNumber, got.Body, err, tt.want)
+		}
+	}
+}
+
+func TestConfig_compile_adapterOptions(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "acme", Type: "test-adapter", URL: "https://acme.example"}}}
+	if err := config.compile(); err == nil || !strings.Contains(err.Error(), "tenant is required") {
+		t.Errorf("compile() = %v, want the adapter's complaint", err)
+	}
+	config = &Config{Providers: []Provider{{Name: "acme", Type: "unregistered", URL: "https://acme.example"}}}
+	if err := config.compile(); err == nil {
+		t.Error("compile() should fail for a type without an adapter")
+	}
+}
+
+func TestRegisterAdapter(t *testing.T) {
+	check := func(ctx context.Context, client *http.Client, url, accountNumber string, options map[string]interface{}) (bool, string, error) {
+		return true, "", nil
+	}
+	for name, register := range map[string]func(){
+		"built in": func() { RegisterAdapter(ProviderTypeSimulated, ProviderAdapter{Check: check}) },
+		"taken":    func() { RegisterAdapter("test-adapter", ProviderAdapter{Check: check}) },
+		"no check": func() { RegisterAdapter("no-check", ProviderAdapter{}) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("RegisterAdapter() should panic")
+				}
+			}()
+			register()
+		})
+	}
+}
+
+func Test_pluginAdapter(t *testing.T) {
+	name := "acme"
+	taken := "test-adapter"
+	check := func(ctx context.Context, client *http.Client, url, accountNumber string, options map[string]interface{}) (bool, string, error) {
+		return true, "", nil
+	}
+	validate := func(options map[string]interface{}) error { return nil }
+	tests := []struct {
+		name    string
+		symbols map[string]interface{}
+		wantErr bool
+	}{
+		{"adapter", map[string]interface{}{"Name": &name, "Check": check, "Validate": validate}, false},
+		{"no validate", map[string]interface{}{"Name": &name, "Check": check}, false},
+		{"no name", map[string]interface{}{"Check": check}, true},
+		{"taken", map[string]interface{}{"Name": &taken, "Check": check}, true},
+		{"wrong check", map[string]interface{}{"Name": &name, "Check": func(string) bool { return true }}, true},
+		{"wrong validate", map[string]interface{}{"Name": &name, "Check": check, "Validate": func() error { return nil }}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookup := func(symbol string) (interface{}, error) {
+				if value, exists := tt.symbols[symbol]; exists {
+					return value, nil
+				}
+				return nil, errors.New("symbol " + symbol + " not found")
+			}
+			adapter, got, err := pluginAdapter(lookup)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pluginAdapter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got != name || adapter.Check == nil) {
+				t.Errorf("pluginAdapter() = %v, %s", adapter, got)
+			}
+		})
+	}
+}
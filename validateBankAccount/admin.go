@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+  The admin plane. Operational controls live under /admin/* and are let in by their own checks rather than the data
+  plane's (allowlist, request signatures, claims), so a partner that can validate accounts can't touch them:
+
+    admin:
+      principals:                                         # IAM callers, from API Gateway's AWS_IAM auth
+        - arn:aws:iam::123456789012:role/oncall
+        - arn:aws:sts::123456789012:assumed-role/oncall/*   # a * at the end matches anything after it
+      keys:                                               # or a key in X-Admin-Key, as in requestSignatures
+        - id: oncall
+          secretId: accountvalidator/admin
+          secretKey: adminKey
+
+  Without an admin block every /admin route is a 404. A caller that's neither a principal nor has a key gets a 403
+  and an auth_failure security event (see security.go). serverless.yml puts the admin routes behind AWS_IAM.
+
+  Every admin request, let in or not, is audited with one JSON line on stdout and an AdminAction event on the
+  EventBridge bus in EVENT_BUS_NAME, so there's a record of who did what that's apart from the application logs:
+
+    {"adminAudit": {"action": "DELETE /admin/cache", "caller": "arn:aws:sts::123456789012:assumed-role/oncall/jo",
+                    "sourceIp": "203.0.113.7", "requestId": "c6af9ac6-...", "status": 200, "at": "2024-06-01T09:00:00Z"}}
+*/
+
+const (
+	AdminActionDetailType = "AdminAction"
+	adminKeyHeader        = "X-Admin-Key"
+	adminPathPrefix       = "/admin/"
+)
+
+type AdminConfig struct {
+	Principals []string      `yaml:"principals"`
+	Keys       []*SigningKey `yaml:"keys"`
+}
+
+type AdminAuditEntry struct {
+	Action    string    `json:"action"`
+	Caller    string    `json:"caller"`
+	SourceIP  string    `json:"sourceIp,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+	Status    int       `json:"status"`
+	Detail    string    `json:"detail,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+func (admin *AdminConfig) validate() error {
+	if admin == nil {
+		return nil
+	}
+	if len(admin.Principals) == 0 && len(admin.Keys) == 0 {
+		return errors.New("admin needs principals or keys")
+	}
+	for _, principal := range admin.Principals {
+		if principal == "" || principal == "*" {
+			return fmt.Errorf("admin principal %q would let anyone in", principal)
+		}
+	}
+	ids := map[string]bool{}
+	for _, key := range admin.Keys {
+		if err := key.validate(); err != nil {
+			return err
+		}
+		if ids[key.ID] {
+			return fmt.Errorf("admin has two keys with the id %s", key.ID)
+		}
+		ids[key.ID] = true
+	}
+	return nil
+}
+
+func (route *route) admin() bool {
+	return strings.HasPrefix(route.path, adminPathPrefix)
+}
+
+func principalMatches(principal, caller string) bool {
+	if prefix, wildcard := strings.CutSuffix(principal, "*"); wildcard {
+		return strings.HasPrefix(caller, prefix)
+	}
+	return principal == caller
+}
+
+// Who the admin caller is, an error when they aren't one
+func (admin *AdminConfig) authenticate(ctx context.Context, request Request) (string, error) {
+	if given := requestHeader(request.Headers, adminKeyHeader); given != "" {
+		for _, key := range admin.Keys {
+			secret, err := key.secret(ctx)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+			if secret != "" && hmac.Equal([]byte(given), []byte(secret)) {
+				return "key:" + key.ID, nil
+			}
+		}
+		return "", errors.New("admin key doesn't match")
+	}
+	caller := request.RequestContext.Identity.UserArn
+	if caller == "" {
+		return "", errors.New("admin requests need IAM auth or an admin key")
+	}
+	for _, principal := range admin.Principals {
+		if principalMatches(principal, caller) {
+			return caller, nil
+		}
+	}
+	return "", fmt.Errorf("%s isn't an admin", caller)
+}
+
+// Handles an /admin request, none of the data plane's checks apply
+func (config *Config) serveAdmin(ctx context.Context, request Request, route *route) (Response, error) {
+	if config.Admin == nil {
+		return jsonResponse(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	authenticated := timerFrom(ctx).stage(StageAuth)
+	caller, err := config.Admin.authenticate(ctx, request)
+	authenticated()
+	if err != nil {
+		config.security.report(ctx, request, SecurityAuthFailure, err.Error())
+		response, _ := jsonResponse(http.StatusForbidden, map[string]string{"error": "forbidden"})
+		config.auditAdmin(ctx, request, callerIdentity(request), response.StatusCode, err.Error())
+		return response, nil
+	}
+	response, err := route.handler(config, withAdminCaller(ctx, caller), request)
+	config.auditAdmin(ctx, request, caller, response.StatusCode, "")
+	return response, err
+}
+
+// Records the admin request. Auditing never fails the request, a bus that's down is logged.
+func (config *Config) auditAdmin(ctx context.Context, request Request, caller string, status int, detail string) {
+	entry := AdminAuditEntry{
+		Action:    request.HTTPMethod + " " + request.Path,
+		Caller:    caller,
+		SourceIP:  request.RequestContext.Identity.SourceIP,
+		RequestID: request.RequestContext.RequestID,
+		Status:    status,
+		Detail:    detail,
+		At:        time.Now().UTC(),
+	}
+	if config.adminAudit != nil {
+		if line, err := marshalJSON(map[string]AdminAuditEntry{"adminAudit": entry}); err != nil {
+			log.Print(err)
+		} else {
+			fmt.Fprintln(config.adminAudit, string(line))
+		}
+	}
+	if config.events == nil {
+		return
+	}
+	if err := config.events.PutEvent(ctx, AdminActionDetailType, entry); err != nil {
+		log.Print(err)
+	}
+}
+
+type adminCallerKey struct{}
+
+func withAdminCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, adminCallerKey{}, caller)
+}
+
+// Who's making the admin request, for the handlers that record it
+func adminCallerFrom(ctx context.Context) string {
+	caller, _ := ctx.Value(adminCallerKey{}).(string)
+	return caller
+}
+
+func (config *Config) setupAdmin() {
+	config.adminAudit = os.Stdout
+}
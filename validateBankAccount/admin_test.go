@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type fakeAdminEvents struct {
+	entries []AdminAuditEntry
+}
+
+func (bus *fakeAdminEvents) PutEvent(ctx context.Context, detailType string, detail interface{}) error {
+	bus.entries = append(bus.entries, detail.(AdminAuditEntry))
+	return nil
+}
+
+func adminRequest(method, path, userArn, key string) Request {
+	request := Request{HTTPMethod: method, Path: path, Headers: map[string]string{}}
+	request.RequestContext.Identity = events.APIGatewayRequestIdentity{UserArn: userArn, SourceIP: "203.0.113.7"}
+	if key != "" {
+		request.Headers[adminKeyHeader] = key
+	}
+	return request
+}
+
+func TestConfig_Router_admin(t *testing.T) {
+	admin := &AdminConfig{
+		Principals: []string{"arn:aws:sts::123456789012:assumed-role/oncall/*"},
+		Keys:       []*SigningKey{{ID: "oncall", Secret: "admin-secret"}},
+	}
+	tests := []struct {
+		name       string
+		admin      *AdminConfig
+		request    Request
+		wantStatus int
+		wantCaller string
+	}{
+		{"principal", admin, adminRequest("DELETE", "/dev/admin/cache", "arn:aws:sts::123456789012:assumed-role/oncall/jo", ""), 200, "arn:aws:sts::123456789012:assumed-role/oncall/jo"},
+		{"key", admin, adminRequest("DELETE", "/admin/cache", "", "admin-secret"), 200, "key:oncall"},
+		{"wrongKey", admin, adminRequest("DELETE", "/admin/cache", "arn:aws:sts::123456789012:assumed-role/oncall/jo", "guess"), 403, "arn:aws:sts::123456789012:assumed-role/oncall/jo"},
+		{"otherRole", admin, adminRequest("DELETE", "/admin/cache", "arn:aws:sts::123456789012:assumed-role/partner/jo", ""), 403, "arn:aws:sts::123456789012:assumed-role/partner/jo"},
+		{"anonymous", admin, adminRequest("DELETE", "/admin/cache", "", ""), 403, "anonymous"},
+		{"noAdmin", nil, adminRequest("DELETE", "/admin/cache", "arn:aws:sts::123456789012:assumed-role/oncall/jo", ""), 404, ""},
+		{"unknownAdminRoute", admin, adminRequest("POST", "/admin/anything", "arn:aws:sts::123456789012:assumed-role/oncall/jo", ""), 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audit, securityEvents := &bytes.Buffer{}, &bytes.Buffer{}
+			// The data plane's checks don't apply to the admin plane
+			allowlist, _ := parseAllowlist([]string{"10.0.0.0/8"})
+			bus := &fakeAdminEvents{}
+			config := &Config{
+				Providers:  []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+				Admin:      tt.admin,
+				events:     bus,
+				adminAudit: audit,
+				security:   newSecurityLog(SecurityConfig{}, nil, securityEvents),
+				allowlist:  allowlist,
+			}
+			got, err := config.Router(context.Background(), tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.StatusCode != tt.wantStatus {
+				t.Fatalf("Router() = %d %s, want %d", got.StatusCode, got.Body, tt.wantStatus)
+			}
+			if tt.wantCaller == "" {
+				if audit.Len() != 0 || len(bus.entries) != 0 {
+					t.Errorf("audited %s", audit.String())
+				}
+				return
+			}
+			var line map[string]AdminAuditEntry
+			if err := json.Unmarshal(audit.Bytes(), &line); err != nil {
+				t.Fatal(err)
+			}
+			entry := line["adminAudit"]
+			if entry.Caller != tt.wantCaller || entry.Status != tt.wantStatus || entry.Action != tt.request.HTTPMethod+" "+tt.request.Path || entry.SourceIP != "203.0.113.7" {
+				t.Errorf("audited %+v", entry)
+			}
+			if len(bus.entries) != 1 || bus.entries[0].Caller != tt.wantCaller {
+				t.Errorf("published %+v", bus.entries)
+			}
+			if denied := strings.Contains(securityEvents.String(), SecurityAuthFailure); denied != (tt.wantStatus == 403) {
+				t.Errorf("security events %s", securityEvents.String())
+			}
+		})
+	}
+}
+
+func TestAdminConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		admin   *AdminConfig
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"principals", &AdminConfig{Principals: []string{"arn:aws:iam::123456789012:role/oncall"}}, false},
+		{"empty", &AdminConfig{}, true},
+		{"everyone", &AdminConfig{Principals: []string{"*"}}, true},
+		{"keyWithoutId", &AdminConfig{Keys: []*SigningKey{{Secret: "admin-secret"}}}, true},
+		{"duplicateKeys", &AdminConfig{Keys: []*SigningKey{{ID: "oncall", Secret: "a"}, {ID: "oncall", Secret: "b"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.admin.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/cel-go/cel"
+)
+
+/*
+  Admission policies. Rules that are too particular for the authorization policies (see authorization.go) can be
+  written in CEL (see script.go) and changed with the config:
+
+    admission:
+      - name: bulk
+        rule: 'batch.size <= 100 || "validate/bulk" in caller.scopes'
+        message: batches over 100 need the validate/bulk scope
+      - name: everything-strategy
+        rule: '!has(request.strategy) || request.strategy != "all" || caller.id.startsWith("arn:aws:iam::1234:")'
+
+  Each rule has to come out true for a validation to go ahead. It sees
+
+    request   the validation request as it was sent, {"accountNumber": "...", "providers": [...], ...}
+    caller    {"id": "...", "scopes": [...], "claims": {...}}, the id as security events have it and the scopes as
+              authorization reads them
+    batch     {"size": 250}, the number of requests in the batch or matrix, 0 for a single validation
+
+  Rules are checked in order after the caller's entitlement. A single validation that breaks one gets a 403 with its
+  message, and an auth_failure security event (see security.go). In a batch or matrix the request gets the message as
+  its error, so a rule on the batch size turns every request in it away, and a graphql query gets it as a query
+  error. A rule that fails to evaluate turns the
+  request away too. Like authorization, the streaming modes aren't behind API Gateway so rules aren't checked there,
+  though WebSocket messages are (see websocket.go).
+*/
+
+type AdmissionPolicy struct {
+	Name string `yaml:"name"`
+	Rule string `yaml:"rule"`
+	// What the caller's told, "not admitted by <name>" when empty
+	Message string `yaml:"message"`
+}
+
+type admissionRule struct {
+	name    string
+	message string
+	program cel.Program
+}
+
+func compileAdmission(policies []AdmissionPolicy) ([]admissionRule, error) {
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	env, err := cel.NewEnv(
+		cel.Variable("request", cel.DynType),
+		cel.Variable("caller", cel.DynType),
+		cel.Variable("batch", cel.DynType),
+		cel.CrossTypeNumericComparisons(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	rules := []admissionRule{}
+	for _, policy := range policies {
+		if policy.Name == "" {
+			return nil, errors.New("admission policies need a name")
+		}
+		if names[policy.Name] {
+			return nil, fmt.Errorf("admission policy %s is defined twice", policy.Name)
+		}
+		names[policy.Name] = true
+		if policy.Rule == "" {
+			return nil, fmt.Errorf("admission policy %s needs a rule", policy.Name)
+		}
+		program, err := compileExpression(env, policy.Rule, cel.BoolType)
+		if err != nil {
+			return nil, fmt.Errorf("admission policy %s: %w", policy.Name, err)
+		}
+		message := policy.Message
+		if message == "" {
+			message = "not admitted by " + policy.Name
+		}
+		rules = append(rules, admissionRule{name: policy.Name, message: message, program: program})
+	}
+	return rules, nil
+}
+
+type batchSizeKey struct{}
+
+type unrestrictedKey struct{}
+
+// Says how many requests the batch the validations in ctx are part of has
+func withBatchSize(ctx context.Context, size int) context.Context {
+	return context.WithValue(ctx, batchSizeKey{}, size)
+}
+
+// What the rules see
+func admissionVars(ctx context.Context, config *Config, request Request, validationRequest *BankAccountValidationRequest) (map[string]interface{}, error) {
+	data, err := json.Marshal(validationRequest)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	// Whatever wasn't sent isn't there, so has() works
+	for name, value := range fields {
+		if value == nil {
+			delete(fields, name)
+		}
+	}
+	claim := config.Authorization.Claim
+	if claim == "" {
+		claim = defaultScopeClaim
+	}
+	claims := requestClaims(request)
+	if claims == nil {
+		claims = map[string]interface{}{}
+	}
+	scopes := claimScopes(claims, claim)
+	if scopes == nil {
+		scopes = []string{}
+	}
+	size, _ := ctx.Value(batchSizeKey{}).(int)
+	return map[string]interface{}{
+		"request": fields,
+		"caller":  map[string]interface{}{"id": callerIdentity(request), "scopes": scopes, "claims": claims},
+		"batch":   map[string]interface{}{"size": size},
+	}, nil
+}
+
+// Why the validation isn't admitted, nil when every rule lets it through
+func (config *Config) admissionError(ctx context.Context, request Request, validationRequest *BankAccountValidationRequest) error {
+	if len(config.admission) == 0 || ctx.Value(unrestrictedKey{}) != nil {
+		return nil
+	}
+	vars, err := admissionVars(ctx, config, request, validationRequest)
+	if err != nil {
+		log.Print(err)
+		return errors.New("unable to check admission")
+	}
+	for _, rule := range config.admission {
+		admitted, err := evalBool(ctx, rule.program, vars)
+		if err != nil {
+			log.Printf("admission policy %s failed: %v", rule.name, err)
+			return errors.New(rule.message)
+		}
+		if !admitted {
+			return errors.New(rule.message)
+		}
+	}
+	return nil
+}
+
+// The 403 for a validation the rules don't admit, nil when it can go ahead
+func (config *Config) enforceAdmission(ctx context.Context, request Request, validationRequest *BankAccountValidationRequest) *Response {
+	err := config.admissionError(ctx, request, validationRequest)
+	if err == nil {
+		return nil
+	}
+	config.security.report(ctx, request, SecurityAuthFailure, err.Error())
+	return forbidden(err.Error())
+}
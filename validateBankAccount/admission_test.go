@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCompileAdmission(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []AdmissionPolicy
+		wantErr  bool
+	}{
+		{"none", nil, false},
+		{"valid", []AdmissionPolicy{{Name: "bulk", Rule: `batch.size <= 100 || "validate/bulk" in caller.scopes`}}, false},
+		{"noName", []AdmissionPolicy{{Rule: "true"}}, true},
+		{"duplicate", []AdmissionPolicy{{Name: "a", Rule: "true"}, {Name: "a", Rule: "true"}}, true},
+		{"noRule", []AdmissionPolicy{{Name: "a"}}, true},
+		{"syntax", []AdmissionPolicy{{Name: "a", Rule: "batch.size <="}}, true},
+		{"unknownVariable", []AdmissionPolicy{{Name: "a", Rule: "response.size > 1"}}, true},
+		{"notABool", []AdmissionPolicy{{Name: "a", Rule: `"yes"`}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileAdmission(tt.policies); (err != nil) != tt.wantErr {
+				t.Errorf("compileAdmission() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func admittedConfig(t *testing.T, policies ...AdmissionPolicy) *Config {
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		Batch:     BatchConfig{MaxRequests: 100},
+		Admission: policies,
+	}
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestConfig_Router_admission(t *testing.T) {
+	config := admittedConfig(t,
+		AdmissionPolicy{Name: "everything", Rule: `!has(request.strategy) || request.strategy != "all" || "validate/all" in caller.scopes`, Message: "strategy all needs validate/all"},
+		AdmissionPolicy{Name: "tenant", Rule: `!has(caller.claims.tenant) || caller.claims.tenant != "blocked"`},
+	)
+	tests := []struct {
+		name       string
+		scope      interface{}
+		body       string
+		wantStatus int
+		want       string
+	}{
+		{"admitted", "validate/basic", `{"accountNumber": "12345670"}`, 200, `"isValid":true`},
+		{"any", "validate/basic", `{"accountNumber": "12345670", "strategy": "any"}`, 200, `"isValid":true`},
+		{"denied", "validate/basic", `{"accountNumber": "12345670", "strategy": "all"}`, 403, "strategy all needs validate/all"},
+		{"scoped", "validate/basic validate/all", `{"accountNumber": "12345670", "strategy": "all"}`, 200, `"isValid":true`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := scopedRequest(tt.scope, tt.body)
+			request.Path = "/application"
+			response, _ := config.Router(context.Background(), request)
+			if response.StatusCode != tt.wantStatus || !strings.Contains(response.Body, tt.want) {
+				t.Errorf("Router() = %d %s, want %d %s", response.StatusCode, response.Body, tt.wantStatus, tt.want)
+			}
+		})
+	}
+
+	request := scopedRequest("validate/basic", `{"accountNumber": "12345670"}`)
+	request.Path = "/application"
+	request.RequestContext.Authorizer["claims"].(map[string]interface{})["tenant"] = "blocked"
+	if response, _ := config.Router(context.Background(), request); response.StatusCode != 403 || !strings.Contains(response.Body, "not admitted by tenant") {
+		t.Errorf("Router() = %d %s, want the default message", response.StatusCode, response.Body)
+	}
+}
+
+func TestConfig_Router_admissionFailsClosed(t *testing.T) {
+	// Comparing a string with a number doesn't evaluate
+	config := admittedConfig(t, AdmissionPolicy{Name: "broken", Rule: "request.accountNumber > 5"})
+	request := Request{HTTPMethod: "POST", Path: "/application", Body: `{"accountNumber": "12345670"}`}
+	if response, _ := config.Router(context.Background(), request); response.StatusCode != 403 {
+		t.Errorf("Router() = %d %s, want a 403", response.StatusCode, response.Body)
+	}
+}
+
+func TestConfig_BatchHandler_admission(t *testing.T) {
+	config := admittedConfig(t, AdmissionPolicy{Name: "bulk", Rule: `batch.size <= 2 || "validate/bulk" in caller.scopes`, Message: "big batches need validate/bulk"})
+	body := `{"requests": [{"accountNumber": "12345670"}, {"accountNumber": "12345672"}, {"accountNumber": "12345674"}]}`
+	request := scopedRequest("validate/basic", body)
+	request.Path = "/validate-batch"
+	response, _ := config.Router(context.Background(), request)
+	if response.StatusCode != 200 || strings.Count(response.Body, "big batches need validate/bulk") != 3 {
+		t.Errorf("Router() = %d %s, want every request turned away", response.StatusCode, response.Body)
+	}
+
+	request = scopedRequest("validate/basic validate/bulk", body)
+	request.Path = "/validate-batch"
+	response, _ = config.Router(context.Background(), request)
+	if response.StatusCode != 200 || strings.Contains(response.Body, "error") {
+		t.Errorf("Router() = %d %s, want the batch admitted", response.StatusCode, response.Body)
+	}
+}
+
+func TestConfig_Router_admissionGraphQL(t *testing.T) {
+	config := admittedConfig(t, AdmissionPolicy{Name: "blocked", Rule: `request.accountNumber != "12345670"`, Message: "account is blocked"})
+	body := "{\"query\": \"{ validateAccount(accountNumber: \\\"12345670\\\") { aggregate { isValid } } }\"}"
+	response, _ := config.Router(context.Background(), Request{HTTPMethod: "POST", Path: "/graphql", Body: body})
+	if !strings.Contains(response.Body, "\"message\":\"account is blocked\"") || !strings.Contains(response.Body, "\"validateAccount\":null") {
+		t.Errorf("Router() = %d %s, want the query turned away", response.StatusCode, response.Body)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+/*
+  Provider outage alerts. When a provider's error rate over the stats window crosses the threshold we publish a
+  structured alert to the SNS topic in ALERT_TOPIC_ARN, which on-call subscribes to. Each container alerts at most
+  once per cooldown per provider.
+
+    alerting:
+      errorRateThreshold: 0.5
+      minCalls: 10          # don't alert off a handful of calls
+      windowSeconds: 60
+      cooldownSeconds: 300
+
+  Every provider call checks, so the check is cheap until an alert is due: the cooldown first, then the call and error
+  counts, and only then the latencies and recent errors that go in the alert. Alerts go out with the telemetry flush
+  (see telemetry.go), after the invocation's response and before Lambda freezes the container, so a publish isn't
+  left running in a frozen container. One that can't be published doesn't start the cooldown.
+*/
+
+type AlertingConfig struct {
+	ErrorRateThreshold float64 `yaml:"errorRateThreshold"`
+	MinCalls           int     `yaml:"minCalls"`
+	WindowSeconds      int     `yaml:"windowSeconds"`
+	CooldownSeconds    int     `yaml:"cooldownSeconds"`
+}
+
+const (
+	defaultErrorRateThreshold = 0.5
+	defaultAlertMinCalls      = 10
+	defaultAlertCooldown      = 5 * time.Minute
+	alertRecentErrors         = 5
+	alertPublishTimeout       = 2 * time.Second
+)
+
+type AlertSample struct {
+	At        time.Time `json:"at"`
+	Error     string    `json:"error"`
+	LatencyMs int64     `json:"latencyMs"`
+}
+
+type ProviderAlert struct {
+	Provider      string        `json:"provider"`
+	ErrorRate     float64       `json:"errorRate"`
+	Threshold     float64       `json:"threshold"`
+	Calls         int           `json:"calls"`
+	Errors        int           `json:"errors"`
+	WindowSeconds int           `json:"windowSeconds"`
+	LatencyP50Ms  int64         `json:"latencyP50Ms"`
+	LatencyP95Ms  int64         `json:"latencyP95Ms"`
+	LatencyMaxMs  int64         `json:"latencyMaxMs"`
+	RecentErrors  []AlertSample `json:"recentErrors"`
+	RaisedAt      time.Time     `json:"raisedAt"`
+}
+
+type AlertPublisher interface {
+	PublishAlert(ctx context.Context, alert ProviderAlert) error
+}
+
+func (alerting AlertingConfig) window() time.Duration {
+	return time.Duration(alerting.WindowSeconds) * time.Second
+}
+
+type providerAlerter struct {
+	mu        sync.Mutex
+	provider  string
+	threshold float64
+	minCalls  int
+	cooldown  time.Duration
+	lastAlert time.Time
+	// Raised but waiting for the flush
+	pending   []ProviderAlert
+	publisher AlertPublisher
+	now       func() time.Time
+}
+
+func newProviderAlerter(provider string, config AlertingConfig, publisher AlertPublisher) *providerAlerter {
+	alerter := &providerAlerter{
+		provider:  provider,
+		threshold: config.ErrorRateThreshold,
+		minCalls:  config.MinCalls,
+		cooldown:  time.Duration(config.CooldownSeconds) * time.Second,
+		publisher: publisher,
+		now:       time.Now,
+	}
+	if alerter.threshold <= 0 {
+		alerter.threshold = defaultErrorRateThreshold
+	}
+	if alerter.minCalls <= 0 {
+		alerter.minCalls = defaultAlertMinCalls
+	}
+	if alerter.cooldown <= 0 {
+		alerter.cooldown = defaultAlertCooldown
+	}
+	return alerter
+}
+
+// Returns the alert to raise if the provider has crossed the threshold and we haven't alerted recently
+func (alerter *providerAlerter) due(stats *providerStats) *ProviderAlert {
+	if alerter == nil || stats == nil {
+		return nil
+	}
+	alerter.mu.Lock()
+	defer alerter.mu.Unlock()
+	now := alerter.now()
+	if !alerter.lastAlert.IsZero() && now.Sub(alerter.lastAlert) < alerter.cooldown {
+		return nil
+	}
+	calls, errors := stats.counts()
+	if calls < alerter.minCalls || float64(errors)/float64(calls) < alerter.threshold {
+		return nil
+	}
+	snapshot := stats.snapshot(alertRecentErrors)
+	alerter.lastAlert = now
+
+	alert := &ProviderAlert{
+		Provider:      alerter.provider,
+		ErrorRate:     snapshot.ErrorRate,
+		Threshold:     alerter.threshold,
+		Calls:         snapshot.Calls,
+		Errors:        snapshot.Errors,
+		WindowSeconds: int(stats.window.Seconds()),
+		LatencyP50Ms:  snapshot.P50.Milliseconds(),
+		LatencyP95Ms:  snapshot.P95.Milliseconds(),
+		LatencyMaxMs:  snapshot.Max.Milliseconds(),
+		RecentErrors:  []AlertSample{},
+		RaisedAt:      now,
+	}
+	for _, sample := range snapshot.RecentErrors {
+		alert.RecentErrors = append(alert.RecentErrors, AlertSample{At: sample.At, Error: sample.Error, LatencyMs: sample.Latency.Milliseconds()})
+	}
+	return alert
+}
+
+// Raises an alert if one is due. It's published by the next flush so the request that tipped the provider over the
+// threshold isn't held up talking to SNS.
+func (alerter *providerAlerter) check(stats *providerStats) {
+	alert := alerter.due(stats)
+	if alert == nil {
+		return
+	}
+	log.Printf("provider %s error rate %.2f over %d calls, raising alert", alert.Provider, alert.ErrorRate, alert.Calls)
+	alerter.mu.Lock()
+	defer alerter.mu.Unlock()
+	alerter.pending = append(alerter.pending, *alert)
+}
+
+// Publishes the alerts raised since the last flush. When one fails the cooldown is lifted so the next call over the
+// threshold raises it again.
+func (alerter *providerAlerter) flush(ctx context.Context) {
+	alerter.mu.Lock()
+	pending := alerter.pending
+	alerter.pending = nil
+	alerter.mu.Unlock()
+
+	for _, alert := range pending {
+		publishCtx, cancel := context.WithTimeout(ctx, alertPublishTimeout)
+		err := alerter.publisher.PublishAlert(publishCtx, alert)
+		cancel()
+		if err == nil {
+			continue
+		}
+		log.Printf("unable to publish alert for %s: %v", alert.Provider, err)
+		alerter.mu.Lock()
+		if alerter.lastAlert.Equal(alert.RaisedAt) {
+			alerter.lastAlert = time.Time{}
+		}
+		alerter.mu.Unlock()
+	}
+}
+
+type snsAPI interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+type snsAlertPublisher struct {
+	client   snsAPI
+	topicArn string
+}
+
+func (publisher *snsAlertPublisher) PublishAlert(ctx context.Context, alert ProviderAlert) error {
+	message, err := marshalJSON(alert)
+	if err != nil {
+		return err
+	}
+	_, err = publisher.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(publisher.topicArn),
+		Subject:  aws.String(fmt.Sprintf("Account validator: provider %s error rate %.0f%%", alert.Provider, alert.ErrorRate*100)),
+		Message:  aws.String(string(message)),
+	})
+	return err
+}
+
+// Hooks up outage alerts if ALERT_TOPIC_ARN is set
+func (config *Config) setupAlerts(ctx context.Context) {
+	topicArn, exists := os.LookupEnv("ALERT_TOPIC_ARN")
+	if !exists {
+		return
+	}
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	config.attachAlerter(&snsAlertPublisher{client: sns.NewFromConfig(cfg), topicArn: topicArn})
+}
+
+func (config *Config) attachAlerter(publisher AlertPublisher) {
+	alerters := make([]*providerAlerter, len(config.Providers))
+	for i := range config.Providers {
+		alerters[i] = newProviderAlerter(config.Providers[i].Name, config.Alerting, publisher)
+		config.Providers[i].alerter = alerters[i]
+	}
+	config.telemetry.onFlush(func(ctx context.Context) {
+		for _, alerter := range alerters {
+			alerter.flush(ctx)
+		}
+	})
+}
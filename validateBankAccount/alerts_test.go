@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+func Test_providerAlerter_due(t *testing.T) {
+	stats, now := newTestStats(time.Minute)
+	alerter := newProviderAlerter("provider1", AlertingConfig{ErrorRateThreshold: 0.5, MinCalls: 4, CooldownSeconds: 300}, nil)
+	alerter.now = func() time.Time { return *now }
+
+	for i := 0; i < 3; i++ {
+		stats.record(time.Second, ProviderErrorTimeout)
+	}
+	if alert := alerter.due(stats); alert != nil {
+		t.Fatalf("due() alerted below minCalls")
+	}
+
+	stats.record(10*time.Millisecond, "")
+	alert := alerter.due(stats)
+	if alert == nil {
+		t.Fatalf("due() should alert at 75%% errors")
+	}
+	if alert.Provider != "provider1" || alert.Calls != 4 || alert.Errors != 3 || len(alert.RecentErrors) != 3 || alert.LatencyMaxMs != 1000 {
+		t.Errorf("due() = %+v", alert)
+	}
+
+	stats.record(time.Second, ProviderErrorTimeout)
+	if alerter.due(stats) != nil {
+		t.Errorf("due() should not alert again inside the cooldown")
+	}
+	*now = now.Add(6 * time.Minute)
+	for i := 0; i < 4; i++ {
+		stats.record(time.Second, ProviderErrorTimeout)
+	}
+	if alerter.due(stats) == nil {
+		t.Errorf("due() should alert again after the cooldown")
+	}
+}
+
+func Test_providerAlerter_healthy(t *testing.T) {
+	stats, _ := newTestStats(time.Minute)
+	alerter := newProviderAlerter("provider1", AlertingConfig{}, nil)
+	for i := 0; i < 20; i++ {
+		errorCode := ""
+		if i%5 == 0 {
+			errorCode = ProviderErrorTimeout
+		}
+		stats.record(time.Millisecond, errorCode)
+	}
+	if alerter.due(stats) != nil {
+		t.Errorf("due() should not alert at 20%% errors with the default threshold")
+	}
+}
+
+type fakeAlertPublisher struct {
+	failing   bool
+	published []ProviderAlert
+}
+
+func (publisher *fakeAlertPublisher) PublishAlert(ctx context.Context, alert ProviderAlert) error {
+	if publisher.failing {
+		return errors.New("throttled")
+	}
+	publisher.published = append(publisher.published, alert)
+	return nil
+}
+
+func TestConfig_attachAlerter_flush(t *testing.T) {
+	publisher := &fakeAlertPublisher{failing: true}
+	config := &Config{
+		Providers: []Provider{{Name: "provider1"}},
+		Alerting:  AlertingConfig{MinCalls: 2},
+		telemetry: newTelemetry(nil, io.Discard),
+	}
+	config.attachAlerter(publisher)
+	alerter := config.Providers[0].alerter
+	stats, _ := newTestStats(time.Minute)
+	stats.record(time.Second, ProviderErrorTimeout)
+	stats.record(time.Second, ProviderErrorTimeout)
+
+	// Nothing goes out until the flush, and a failed publish can be raised again straight away
+	alerter.check(stats)
+	if len(alerter.pending) != 1 || len(publisher.published) != 0 {
+		t.Fatalf("pending %v, published %v, want the alert held for the flush", alerter.pending, publisher.published)
+	}
+	config.telemetry.flush(context.Background())
+	if len(alerter.pending) != 0 || !alerter.lastAlert.IsZero() {
+		t.Fatalf("a failed publish should lift the cooldown, last alert %v", alerter.lastAlert)
+	}
+
+	publisher.failing = false
+	alerter.check(stats)
+	config.telemetry.flush(context.Background())
+	if len(publisher.published) != 1 || publisher.published[0].Provider != "provider1" {
+		t.Fatalf("published %v, want the alert", publisher.published)
+	}
+	alerter.check(stats)
+	if len(alerter.pending) != 0 {
+		t.Errorf("a published alert should start the cooldown")
+	}
+}
+
+type fakeSNS struct {
+	published []*sns.PublishInput
+}
+
+func (client *fakeSNS) Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	client.published = append(client.published, params)
+	return &sns.PublishOutput{}, nil
+}
+
+func Test_snsAlertPublisher(t *testing.T) {
+	client := &fakeSNS{}
+	publisher := &snsAlertPublisher{client: client, topicArn: "arn:aws:sns:eu-west-1:123456789012:alerts"}
+	alert := ProviderAlert{Provider: "provider1", ErrorRate: 0.75, Calls: 4, Errors: 3}
+	if err := publisher.PublishAlert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.published) != 1 {
+		t.Fatalf("expected one message, got %d", len(client.published))
+	}
+	published := client.published[0]
+	if *published.TopicArn != publisher.topicArn || !strings.Contains(*published.Subject, "provider1 error rate 75%") {
+		t.Errorf("unexpected publish input %v / %v", *published.TopicArn, *published.Subject)
+	}
+	var got ProviderAlert
+	if err := json.Unmarshal([]byte(*published.Message), &got); err != nil || got.Provider != "provider1" || got.Errors != 3 {
+		t.Errorf("message = %v (%v)", *published.Message, err)
+	}
+}
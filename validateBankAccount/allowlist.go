@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+/*
+  IP allowlist. Where an API Gateway resource policy can't be used, say a custom domain shared with other APIs, the
+  function can turn away callers itself:
+
+    security:
+      allowlist: [10.0.0.0/8, 203.0.113.7, "2001:db8::/32"]
+
+  The source IP API Gateway saw has to be in one of the ranges, a bare address is a range of one. Anyone else gets a
+  403 and a not_allowlisted security event (see security.go) with who they are and where they came from. No allowlist
+  lets everyone in. It covers every route, the streaming modes included.
+*/
+
+// Parses the allowlist once at load
+func parseAllowlist(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("allowlist entry %s isn't an IP address or CIDR range", entry)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("allowlist entry %s isn't an IP address or CIDR range", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Whether the source IP is in the allowlist, everything is when there isn't one
+func (config *Config) allowed(sourceIP string) bool {
+	if len(config.allowlist) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(sourceIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range config.allowlist {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// The 403 for a caller outside the allowlist, nil when it can go ahead
+func (config *Config) enforceAllowlist(ctx context.Context, request Request) *Response {
+	sourceIP := request.RequestContext.Identity.SourceIP
+	if config.allowed(sourceIP) {
+		return nil
+	}
+	config.security.report(ctx, request, SecurityNotAllowlisted, "source ip "+sourceIP+" is outside the allowlist")
+	response, _ := jsonResponse(http.StatusForbidden, map[string]string{"error": "forbidden"})
+	return &response
+}
+
+// The allowlist for the streaming modes, which don't all go through the router
+func (config *Config) allowlisted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if response := config.enforceAllowlist(r.Context(), requestHead(r)); response != nil {
+			writeResponse(w, *response)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
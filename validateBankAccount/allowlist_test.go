@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdaurl"
+)
+
+func Test_parseAllowlist(t *testing.T) {
+	if _, err := parseAllowlist([]string{"10.0.0.0/8", "203.0.113.7", "2001:db8::/32"}); err != nil {
+		t.Errorf("parseAllowlist() error = %v", err)
+	}
+	for _, entry := range []string{"10.0.0.0/33", "example.com", ""} {
+		if _, err := parseAllowlist([]string{entry}); err == nil {
+			t.Errorf("parseAllowlist(%q) should fail", entry)
+		}
+	}
+}
+
+func TestConfig_allowed(t *testing.T) {
+	allowlist, err := parseAllowlist([]string{"10.0.0.0/8", "203.0.113.7", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{allowlist: allowlist}
+	tests := []struct {
+		sourceIP string
+		want     bool
+	}{
+		{"10.1.2.3", true},
+		{"203.0.113.7", true},
+		{"203.0.113.8", false},
+		{"::ffff:10.1.2.3", true},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"", false},
+		{"not an ip", false},
+	}
+	for _, tt := range tests {
+		if got := config.allowed(tt.sourceIP); got != tt.want {
+			t.Errorf("allowed(%q) = %v, want %v", tt.sourceIP, got, tt.want)
+		}
+	}
+	if !(&Config{}).allowed("") {
+		t.Error("no allowlist should let everyone in")
+	}
+}
+
+func TestConfig_Router_allowlist(t *testing.T) {
+	allowlist, _ := parseAllowlist([]string{"10.0.0.0/8"})
+	out := &bytes.Buffer{}
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		allowlist: allowlist,
+		security:  newSecurityLog(SecurityConfig{}, nil, out),
+	}
+	request := Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\"}"}
+	request.RequestContext.Identity.SourceIP = "203.0.113.7"
+	response, err := config.Router(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 403 || response.Body != "{\"error\":\"forbidden\"}" {
+		t.Errorf("Router() = %d %s, want a 403", response.StatusCode, response.Body)
+	}
+	if !strings.Contains(out.String(), "\"type\":\"not_allowlisted\"") || !strings.Contains(out.String(), "\"sourceIp\":\"203.0.113.7\"") {
+		t.Errorf("log = %q, want a not_allowlisted event", out.String())
+	}
+
+	request.RequestContext.Identity.SourceIP = "10.1.2.3"
+	if response, _ := config.Router(context.Background(), request); response.StatusCode != 200 {
+		t.Errorf("Router() = %d %s, want a 200", response.StatusCode, response.Body)
+	}
+}
+
+func TestConfig_streamingHandler_allowlist(t *testing.T) {
+	allowlist, _ := parseAllowlist([]string{"10.0.0.0/8"})
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}, allowlist: allowlist}
+	server := httptest.NewServer(config.streamingHandler())
+	defer server.Close()
+
+	// The test client comes from 127.0.0.1
+	response, err := http.Post(server.URL+"/batch", "application/json", strings.NewReader("[]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != 403 {
+		t.Errorf("status = %d, want 403", response.StatusCode)
+	}
+}
+
+// Function URL requests come through lambdaurl, which has no RemoteAddr to give
+func TestConfig_streamingHandler_allowlistFunctionURL(t *testing.T) {
+	allowlist, _ := parseAllowlist([]string{"10.0.0.0/8"})
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}, allowlist: allowlist}
+	tests := []struct {
+		sourceIP   string
+		wantStatus int
+	}{
+		{"10.1.2.3", 200},
+		{"203.0.113.7", 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sourceIP, func(t *testing.T) {
+			request := &events.LambdaFunctionURLRequest{RawPath: "/validate-batch", Body: testBatch}
+			request.RequestContext.HTTP.Method = "POST"
+			request.RequestContext.HTTP.SourceIP = tt.sourceIP
+			response, err := lambdaurl.Wrap(config.streamingHandler())(context.Background(), request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", response.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import "context"
+
+/*
+  Authoritative providers. Some providers are the bank's own records, and once one of them says an account is
+  invalid nothing the others say will change our mind, so there's no point waiting for them:
+
+    - name: provider1
+      url: https://provider1.com/v1/api/account/validate
+      authoritative: true
+
+  A definitive invalid (no error) from an authoritative provider cancels the calls still in flight. They come back
+  with the error "cancelled", which doesn't count against the provider's breaker, stats or SLA, and isn't billed.
+  The aggregate is invalid whatever the strategy, and the response isn't partial for want of the cancelled answers.
+  A valid answer from an authoritative provider doesn't stop anything. Simulated and SEPA providers answer
+  straight away so there's nothing to cancel, and the sequential strategy stops at the first answer anyway.
+*/
+
+const ProviderErrorCancelled = "cancelled"
+
+// The authoritative provider that said the account is invalid, empty when none did
+func (config *Config) authoritativeInvalid(results []BankAccountValidationResult) string {
+	authoritative := map[string]bool{}
+	for _, provider := range config.Providers {
+		if provider.Authoritative {
+			authoritative[provider.Name] = true
+		}
+	}
+	for _, result := range results {
+		if authoritative[result.Provider] && !result.Local && result.Error == "" && !result.IsValid {
+			return result.Provider
+		}
+	}
+	return ""
+}
+
+// Passes results through, calling cancel once an authoritative provider says invalid
+func shortCircuit(results <-chan BankAccountValidationResult, providers []Provider, cancel context.CancelFunc) <-chan BankAccountValidationResult {
+	authoritative := map[string]bool{}
+	for _, provider := range providers {
+		if provider.Authoritative {
+			authoritative[provider.Name] = true
+		}
+	}
+	if len(authoritative) == 0 {
+		return results
+	}
+	passed := make(chan BankAccountValidationResult, len(providers))
+	go func() {
+		defer close(passed)
+		for result := range results {
+			if authoritative[result.Provider] && result.Error == "" && !result.IsValid {
+				cancel()
+			}
+			passed <- result
+		}
+	}()
+	return passed
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConfig_authoritativeShortCircuit(t *testing.T) {
+	invalid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"isValid\": false}"))
+	}))
+	defer invalid.Close()
+	// Only answers once the call's given up on
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The connection is only watched once the body's been read
+		io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer hanging.Close()
+
+	stats := newProviderStats(time.Minute)
+	config := &Config{Providers: []Provider{
+		{Name: "provider1", URL: invalid.URL, Authoritative: true},
+		{Name: "provider2", URL: hanging.URL, TimeoutMs: 5000, stats: stats},
+		{Name: "provider3", Type: ProviderTypeSimulated},
+	}, MinProviders: 3}
+	start := time.Now()
+	response, err := config.Handler(context.Background(), Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\", \"strategy\": \"any\"}"})
+
+	want := "{\"result\":[{\"provider\":\"provider1\",\"isValid\":false},{\"provider\":\"provider2\",\"isValid\":false,\"error\":\"cancelled\"}," +
+		"{\"provider\":\"provider3\",\"isValid\":true}],\"aggregate\":{\"strategy\":\"any\",\"isValid\":false}}"
+	if err != nil || response.StatusCode != 200 || response.Body != want {
+		t.Errorf("Handler() = %d %s, want %s", response.StatusCode, response.Body, want)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Handler() took %v, the hanging call wasn't cancelled", elapsed)
+	}
+	if calls := stats.snapshot(0).Calls; calls != 0 {
+		t.Errorf("the cancelled call was recorded in the stats %d times", calls)
+	}
+}
+
+func TestConfig_authoritativeInvalid(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Authoritative: true}, {Name: "provider2"}}}
+	tests := []struct {
+		name    string
+		results []BankAccountValidationResult
+		want    string
+	}{
+		{"invalid", []BankAccountValidationResult{{Provider: "provider2", IsValid: true}, {Provider: "provider1"}}, "provider1"},
+		{"valid", []BankAccountValidationResult{{Provider: "provider1", IsValid: true}}, ""},
+		{"errored", []BankAccountValidationResult{{Provider: "provider1", Error: ProviderErrorTimeout}}, ""},
+		{"notAuthoritative", []BankAccountValidationResult{{Provider: "provider2"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := config.authoritativeInvalid(tt.results); got != tt.want {
+				t.Errorf("authoritativeInvalid() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
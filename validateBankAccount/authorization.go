@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+  Claims based authorization. When API Gateway has a Cognito or JWT authorizer in front of us, the scopes in the
+  caller's claims decide what they're entitled to, so one API can serve callers on different plans:
+
+    authorization:
+      claim: scope                   # the claim holding the caller's scopes, default scope
+      policies:
+        - scope: validate/basic
+          providers: [tag:cheap]     # provider selectors as in requests, all providers when there are none
+          strategies: [any]          # all strategies when there are none
+          maxBatchSize: 10           # batch.maxRequests when 0
+        - scope: validate/full
+
+  A caller gets everything any of its scopes' policies allow. With policies configured, a caller whose claims don't
+  name one of their scopes gets a 403 and an auth_failure security event (see security.go), and so does a request
+  that names a provider or strategy the caller isn't entitled to. Providers the request doesn't name are quietly
+  limited to the caller's. The claims come from the authorizer's claims, or the authorizer context itself for a
+  Lambda authorizer; the scope claim can be a space or comma separated string or a list.
+
+  Batch streams through the function URL and the standalone server aren't behind API Gateway, IAM and the network
+  decide who gets in there, so they aren't restricted. The WebSocket API is, and its messages are (see websocket.go).
+*/
+
+const defaultScopeClaim = "scope"
+
+type AuthorizationConfig struct {
+	Claim    string        `yaml:"claim"`
+	Policies []ScopePolicy `yaml:"policies"`
+}
+
+type ScopePolicy struct {
+	Scope        string   `yaml:"scope"`
+	Providers    []string `yaml:"providers"`
+	Strategies   []string `yaml:"strategies"`
+	MaxBatchSize int      `yaml:"maxBatchSize"`
+}
+
+func (authorization AuthorizationConfig) validate(providers []Provider) error {
+	scopes := map[string]bool{}
+	index := providerIndex(providers)
+	for _, policy := range authorization.Policies {
+		if policy.Scope == "" {
+			return errors.New("authorization policies need a scope")
+		}
+		if scopes[policy.Scope] {
+			return fmt.Errorf("authorization has two policies for %s", policy.Scope)
+		}
+		scopes[policy.Scope] = true
+		for _, selector := range policy.Providers {
+			if len(selectProviders(providers, index, selector)) == 0 {
+				return fmt.Errorf("authorization policy %s: %s", policy.Scope, selectorWarning(selector))
+			}
+		}
+		for _, strategy := range policy.Strategies {
+			if err := validateStrategy(strategy); err != nil {
+				return fmt.Errorf("authorization policy %s: %w", policy.Scope, err)
+			}
+		}
+		if policy.MaxBatchSize < 0 {
+			return fmt.Errorf("authorization policy %s: maxBatchSize can't be negative", policy.Scope)
+		}
+	}
+	return nil
+}
+
+// What a caller may do, nil is anything
+type entitlement struct {
+	// By provider name, nil is every provider
+	providers map[string]bool
+	// nil is every strategy
+	strategies map[string]bool
+	// 0 is batch.maxRequests
+	maxBatchSize int
+}
+
+type entitlementKey struct{}
+
+// The authorizer's claims
+func requestClaims(request Request) map[string]interface{} {
+	if claims, ok := request.RequestContext.Authorizer["claims"].(map[string]interface{}); ok {
+		return claims
+	}
+	return request.RequestContext.Authorizer
+}
+
+func claimScopes(claims map[string]interface{}, claim string) []string {
+	switch value := claims[claim].(type) {
+	case string:
+		return strings.Fields(strings.ReplaceAll(value, ",", " "))
+	case []interface{}:
+		scopes := []string{}
+		for _, scope := range value {
+			if scope, ok := scope.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+		return scopes
+	}
+	return nil
+}
+
+// What the caller is entitled to, false when none of its scopes has a policy
+func (config *Config) entitlement(request Request) (*entitlement, bool) {
+	if len(config.Authorization.Policies) == 0 {
+		return nil, true
+	}
+	claim := config.Authorization.Claim
+	if claim == "" {
+		claim = defaultScopeClaim
+	}
+	scopes := map[string]bool{}
+	for _, scope := range claimScopes(requestClaims(request), claim) {
+		scopes[scope] = true
+	}
+	merged := &entitlement{providers: map[string]bool{}, strategies: map[string]bool{}}
+	allProviders, allStrategies, unlimited, matched := false, false, false, false
+	index := providerIndex(config.Providers)
+	for _, policy := range config.Authorization.Policies {
+		if !scopes[policy.Scope] {
+			continue
+		}
+		matched = true
+		allProviders = allProviders || len(policy.Providers) == 0
+		for _, selector := range policy.Providers {
+			for _, i := range selectProviders(config.Providers, index, selector) {
+				merged.providers[config.Providers[i].Name] = true
+			}
+		}
+		allStrategies = allStrategies || len(policy.Strategies) == 0
+		for _, strategy := range policy.Strategies {
+			merged.strategies[strategy] = true
+		}
+		unlimited = unlimited || policy.MaxBatchSize == 0
+		if policy.MaxBatchSize > merged.maxBatchSize {
+			merged.maxBatchSize = policy.MaxBatchSize
+		}
+	}
+	if !matched {
+		return nil, false
+	}
+	if allProviders {
+		merged.providers = nil
+	}
+	if allStrategies {
+		merged.strategies = nil
+	}
+	if unlimited {
+		merged.maxBatchSize = 0
+	}
+	return merged, true
+}
+
+func withEntitlement(ctx context.Context, entitled *entitlement) context.Context {
+	return context.WithValue(ctx, entitlementKey{}, entitled)
+}
+
+func entitlementFrom(ctx context.Context) *entitlement {
+	entitled, _ := ctx.Value(entitlementKey{}).(*entitlement)
+	return entitled
+}
+
+// Why the request asks for more than the caller is entitled to, nil when it doesn't
+func (entitled *entitlement) check(ctx context.Context, providers []Provider, validationRequest *BankAccountValidationRequest) error {
+	if entitled == nil {
+		return nil
+	}
+	if strategy := validationRequest.Strategy; strategy != nil && entitled.strategies != nil && !entitled.strategies[*strategy] {
+		return fmt.Errorf("not entitled to the %s strategy", *strategy)
+	}
+	if validationRequest.Providers == nil || entitled.providers == nil {
+		return nil
+	}
+	for _, provider := range providersToCall(ctx, providers, validationRequest.Providers) {
+		if !entitled.providers[provider.Name] {
+			return fmt.Errorf("not entitled to provider %s", provider.Name)
+		}
+	}
+	return nil
+}
+
+// The providers the caller is entitled to, the same slice when that's all of them
+func (entitled *entitlement) restrict(providers []Provider) []Provider {
+	if entitled == nil || entitled.providers == nil {
+		return providers
+	}
+	restricted := []Provider{}
+	for _, provider := range providers {
+		if entitled.providers[provider.Name] {
+			restricted = append(restricted, provider)
+		}
+	}
+	return restricted
+}
+
+// The most requests the caller can batch
+func (entitled *entitlement) batchLimit(max int) int {
+	if entitled == nil || entitled.maxBatchSize == 0 || entitled.maxBatchSize > max {
+		return max
+	}
+	return entitled.maxBatchSize
+}
+
+func forbidden(message string) *Response {
+	response, _ := jsonResponse(http.StatusForbidden, map[string]string{"error": message})
+	return &response
+}
+
+// Works out the caller's entitlement for the rest of the request, the 403 when it has none. Requests the streaming
+// modes have already let in keep what they were given.
+func (config *Config) authorize(ctx context.Context, request Request) (context.Context, *Response) {
+	if _, decided := ctx.Value(entitlementKey{}).(*entitlement); decided {
+		return ctx, nil
+	}
+	entitled, allowed := config.entitlement(request)
+	if !allowed {
+		config.security.report(ctx, request, SecurityAuthFailure, "no authorization policy for the caller's scopes")
+		return ctx, forbidden("not entitled to this API")
+	}
+	return withEntitlement(ctx, entitled), nil
+}
+
+// The 403 for a request asking for more than the caller is entitled to, nil when it can go ahead
+func (config *Config) enforceEntitlement(ctx context.Context, request Request, validationRequest *BankAccountValidationRequest) *Response {
+	err := entitlementFrom(ctx).check(ctx, config.Providers, validationRequest)
+	if err == nil {
+		return nil
+	}
+	config.security.report(ctx, request, SecurityAuthFailure, err.Error())
+	return forbidden(err.Error())
+}
+
+// The streaming modes aren't behind API Gateway's authorizer, so they aren't restricted, nor do admission policies
+// apply (see admission.go)
+func unrestricted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(withEntitlement(r.Context(), nil), unrestrictedKey{}, true)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
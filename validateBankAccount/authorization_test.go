@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func authorizedConfig() *Config {
+	return &Config{
+		Providers: []Provider{
+			{Name: "provider1", Type: ProviderTypeSimulated, Tags: []string{"cheap"}},
+			{Name: "provider2", Type: ProviderTypeSimulated},
+		},
+		Batch: BatchConfig{MaxRequests: 100},
+		Authorization: AuthorizationConfig{Policies: []ScopePolicy{
+			{Scope: "validate/basic", Providers: []string{"tag:cheap"}, Strategies: []string{StrategyAny}, MaxBatchSize: 2},
+			{Scope: "validate/full"},
+		}},
+	}
+}
+
+func scopedRequest(scope interface{}, body string) Request {
+	request := Request{HTTPMethod: "POST", Body: body}
+	request.RequestContext.Authorizer = map[string]interface{}{"claims": map[string]interface{}{"scope": scope}}
+	return request
+}
+
+func TestConfig_entitlement(t *testing.T) {
+	config := authorizedConfig()
+	tests := []struct {
+		name        string
+		scope       interface{}
+		want        *entitlement
+		wantAllowed bool
+	}{
+		{"basic", "openid validate/basic", &entitlement{providers: map[string]bool{"provider1": true}, strategies: map[string]bool{StrategyAny: true}, maxBatchSize: 2}, true},
+		{"full", "validate/full", &entitlement{}, true},
+		{"both", []interface{}{"validate/basic", "validate/full"}, &entitlement{}, true},
+		{"commas", "openid,validate/basic", &entitlement{providers: map[string]bool{"provider1": true}, strategies: map[string]bool{StrategyAny: true}, maxBatchSize: 2}, true},
+		{"none", "openid", nil, false},
+		{"missing", nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, allowed := config.entitlement(scopedRequest(tt.scope, ""))
+			if allowed != tt.wantAllowed || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entitlement() = %+v, %v, want %+v, %v", got, allowed, tt.want, tt.wantAllowed)
+			}
+		})
+	}
+	if got, allowed := (&Config{}).entitlement(Request{}); got != nil || !allowed {
+		t.Error("no policies should let everyone do anything")
+	}
+}
+
+func TestConfig_Router_authorization(t *testing.T) {
+	config := authorizedConfig()
+	tests := []struct {
+		name     string
+		request  Request
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "restrictedToEntitledProviders",
+			request:  scopedRequest("validate/basic", "{\"accountNumber\": \"12345670\"}"),
+			wantCode: 200,
+			wantBody: "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}]}",
+		},
+		{
+			name:     "full",
+			request:  scopedRequest("validate/full", "{\"accountNumber\": \"12345670\", \"strategy\": \"all\"}"),
+			wantCode: 200,
+			wantBody: "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true},{\"provider\":\"provider2\",\"isValid\":true}],\"aggregate\":{\"strategy\":\"all\",\"isValid\":true}}",
+		},
+		{
+			name:     "provider",
+			request:  scopedRequest("validate/basic", "{\"accountNumber\": \"12345670\", \"providers\": [\"provider2\"]}"),
+			wantCode: 403,
+			wantBody: "{\"error\":\"not entitled to provider provider2\"}",
+		},
+		{
+			name:     "strategy",
+			request:  scopedRequest("validate/basic", "{\"accountNumber\": \"12345670\", \"strategy\": \"all\"}"),
+			wantCode: 403,
+			wantBody: "{\"error\":\"not entitled to the all strategy\"}",
+		},
+		{
+			name:     "noPolicy",
+			request:  scopedRequest("openid", "{\"accountNumber\": \"12345670\"}"),
+			wantCode: 403,
+			wantBody: "{\"error\":\"not entitled to this API\"}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := config.Router(context.Background(), tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != tt.wantCode || response.Body != tt.wantBody {
+				t.Errorf("Router() = %d %s, want %d %s", response.StatusCode, response.Body, tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestConfig_BatchHandler_authorization(t *testing.T) {
+	config := authorizedConfig()
+	request := scopedRequest("validate/basic", "{\"requests\": [{\"accountNumber\": \"12345670\"}, {\"accountNumber\": \"12345670\", \"providers\": [\"provider2\"]}]}")
+	request.Path = "/validate-batch"
+	response, _ := config.Router(context.Background(), request)
+	if response.StatusCode != 200 || !strings.Contains(response.Body, "{\"index\":1,\"error\":\"not entitled to provider provider2\"}") {
+		t.Errorf("Router() = %d %s", response.StatusCode, response.Body)
+	}
+
+	request.Body = "{\"requests\": [{\"accountNumber\": \"12345670\"}, {\"accountNumber\": \"12345670\"}, {\"accountNumber\": \"12345670\"}]}"
+	response, _ = config.Router(context.Background(), request)
+	if !strings.Contains(response.Body, "too many requests, the most in one batch is 2") {
+		t.Errorf("Router() = %d %s, want the caller's batch limit", response.StatusCode, response.Body)
+	}
+}
+
+func TestAuthorizationConfig_validate(t *testing.T) {
+	providers := []Provider{{Name: "provider1"}}
+	tests := []struct {
+		name     string
+		policies []ScopePolicy
+		wantErr  bool
+	}{
+		{"valid", []ScopePolicy{{Scope: "a", Providers: []string{"provider1"}, Strategies: []string{StrategyAll}, MaxBatchSize: 5}}, false},
+		{"noScope", []ScopePolicy{{}}, true},
+		{"duplicate", []ScopePolicy{{Scope: "a"}, {Scope: "a"}}, true},
+		{"unknownProvider", []ScopePolicy{{Scope: "a", Providers: []string{"provider9"}}}, true},
+		{"unknownStrategy", []ScopePolicy{{Scope: "a", Strategies: []string{"most"}}}, true},
+		{"negativeBatch", []ScopePolicy{{Scope: "a", MaxBatchSize: -1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (AuthorizationConfig{Policies: tt.policies}).validate(providers); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
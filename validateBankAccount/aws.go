@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// AWS SDK config is loaded once per container and shared by every client we create
+var (
+	awsConfigOnce   sync.Once
+	sharedAWSConfig aws.Config
+	awsConfigErr    error
+)
+
+func loadAWSConfig(ctx context.Context) (aws.Config, error) {
+	awsConfigOnce.Do(func() {
+		sharedAWSConfig, awsConfigErr = awsconfig.LoadDefaultConfig(ctx)
+	})
+	return sharedAWSConfig, awsConfigErr
+}
+
+// The eventbridge, apigatewaymanagementapi and cloudwatchlogs SDK modules aren't in our module set yet, so EventBridge,
+// the websocket management API and CloudWatch Logs go through this instead of their SDK clients. It signs like the
+// SDK does, retries with the SDK's standard retryer, and fails with the SDK's error types, so errors.As for a
+// smithy.APIError works the same. Swap each for its NewFromConfig client once the module is added.
+type signedAWSClient struct {
+	// The SigV4 signing name
+	service     string
+	region      string
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	client      *http.Client
+	retryer     aws.Retryer
+}
+
+func newSignedAWSClient(cfg aws.Config, service string, timeout time.Duration) *signedAWSClient {
+	return &signedAWSClient{
+		service:     service,
+		region:      cfg.Region,
+		credentials: cfg.Credentials,
+		signer:      v4.NewSigner(),
+		client:      &http.Client{Timeout: timeout},
+		retryer:     retry.NewStandard(),
+	}
+}
+
+// Calls an action of a JSON protocol service (X-Amz-Target), decoding the reply into output when there is one
+func (client *signedAWSClient) callJSON(ctx context.Context, endpoint, target, version string, input, output interface{}) error {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"Content-Type": "application/x-amz-json-" + version, "X-Amz-Target": target}
+	body, err := client.send(ctx, "POST", endpoint+"/", headers, data)
+	if err != nil || output == nil {
+		return err
+	}
+	return json.Unmarshal(body, output)
+}
+
+// Sends a signed request, trying again whenever the SDK would, and returns the body of a 2xx
+func (client *signedAWSClient) send(ctx context.Context, method, url string, headers map[string]string, data []byte) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		body, err := client.attempt(ctx, method, url, headers, data)
+		if err == nil || attempt >= client.retryer.MaxAttempts() || !client.retryer.IsErrorRetryable(err) {
+			return body, err
+		}
+		delay, delayErr := client.retryer.RetryDelay(attempt, err)
+		if delayErr != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (client *signedAWSClient) attempt(ctx context.Context, method, url string, headers map[string]string, data []byte) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	credentials, err := client.credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	if err := client.signer.SignHTTP(ctx, credentials, request, hex.EncodeToString(hash[:]), client.service, client.region, time.Now()); err != nil {
+		return nil, err
+	}
+	response, err := client.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, responseError(response, body)
+	}
+	return body, nil
+}
+
+// An error response as the SDK reports it: the status and request id, wrapping the service's error code and message
+func responseError(response *http.Response, body []byte) error {
+	// message is Message in some services, encoding/json matches either
+	var reply struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(body, &reply)
+	code := response.Header.Get("X-Amzn-Errortype")
+	if code == "" {
+		code = reply.Type
+	}
+	// Codes can come namespaced (com.amazonaws.events#ThrottlingException) or with details after a colon
+	code = code[strings.LastIndex(code, "#")+1:]
+	if i := strings.Index(code, ":"); i >= 0 {
+		code = code[:i]
+	}
+	apiErr := &smithy.GenericAPIError{Code: code, Message: reply.Message}
+	if apiErr.Code == "" {
+		apiErr.Code = response.Status
+	}
+	return &awshttp.ResponseError{
+		ResponseError: &smithyhttp.ResponseError{Response: &smithyhttp.Response{Response: response}, Err: apiErr},
+		RequestID:     response.Header.Get("X-Amzn-Requestid"),
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/smithy-go"
+)
+
+// A signed client for a test server, in eu-west-1 with static credentials and retries that don't wait
+func testAWSClient(service string) *signedAWSClient {
+	client := newSignedAWSClient(aws.Config{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	}, service, 2*time.Second)
+	client.retryer = retry.NewStandard(func(o *retry.StandardOptions) {
+		o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+	})
+	return client
+}
+
+func Test_signedAWSClient_send(t *testing.T) {
+	replies := []int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := replies[0]
+		replies = replies[1:]
+		if status == 200 {
+			w.Write([]byte(`{"ok": true}`))
+			return
+		}
+		w.Header().Set("X-Amzn-Errortype", "ThrottlingException:http://internal.amazon.com/")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"message": "slow down"}`))
+	}))
+	defer server.Close()
+	client := testAWSClient("events")
+
+	// Throttling is tried again, as the SDK would
+	replies = []int{400, 503, 200}
+	body, err := client.send(context.Background(), "POST", server.URL, nil, []byte("{}"))
+	if err != nil || string(body) != `{"ok": true}` || len(replies) != 0 {
+		t.Errorf("send() = %s, %v with %d replies left, want the third to answer", body, err, len(replies))
+	}
+
+	replies = []int{400, 400, 400}
+	_, err = client.send(context.Background(), "POST", server.URL, nil, []byte("{}"))
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "ThrottlingException" || apiErr.ErrorMessage() != "slow down" {
+		t.Errorf("send() = %v, want the SDK's API error", err)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+/*
+  POST /validate-batch validates many accounts in one call. The body is a list of normal validation requests:
+
+    {"requests": [{"accountNumber": "12345678"}, {"accountNumber": "87654321", "strategy": "all"}]}
+
+  and the response is newline delimited JSON, a line per request in the order they finish, with the index of the
+  request it answers:
+
+    {"index":1,"response":{"result":[...],"aggregate":{"strategy":"all","isValid":true}}}
+    {"index":0,"error":"account number missing from payload"}
+
+  Through API Gateway the lines are buffered into one response, which is fine for small batches. Large ones should go
+  to validateBankAccountBatch (MODE=stream) behind a Lambda function URL with response streaming, where each line is
+  sent as soon as it's ready so the client isn't waiting on the slowest account and the 6MB payload limit doesn't
+  apply. The standalone server streams too.
+
+  The body can also be one request per line, with a Content-Type of application/x-ndjson (or application/jsonl):
+
+    {"accountNumber": "12345678"}
+    {"accountNumber": "87654321", "strategy": "all"}
+
+  When streaming, the body is read a request at a time as there's room to validate it rather than all at once, so
+  memory stays bounded by the concurrency however big the batch. A body that goes bad part way through, or has more
+  than maxRequests, gets an error line for the index it failed at and nothing after it is read. Through API Gateway
+  the body is already in memory and is checked as a whole before anything is validated.
+
+    batch:
+      maxRequests: 1000   # default 1000
+      concurrency: 10     # requests validated at once, default 10
+*/
+
+type BatchConfig struct {
+	MaxRequests int `yaml:"maxRequests"`
+	Concurrency int `yaml:"concurrency"`
+	// See itemdeadlines.go
+	BudgetMs int `yaml:"budgetMs"`
+}
+
+const (
+	defaultBatchMaxRequests = 1000
+	defaultBatchConcurrency = 10
+	ndjsonContentType       = "application/x-ndjson"
+	jsonlContentType        = "application/jsonl"
+	batchMissing            = "requests missing from payload"
+)
+
+// A line of the batch response
+type BatchResult struct {
+	Index    int                            `json:"index"`
+	Response *BankAccountValidationResponse `json:"response,omitempty"`
+	Error    string                         `json:"error,omitempty"`
+}
+
+func (batch BatchConfig) maxRequests() int {
+	if batch.MaxRequests <= 0 {
+		return defaultBatchMaxRequests
+	}
+	return batch.MaxRequests
+}
+
+func (batch BatchConfig) concurrency() int {
+	if batch.Concurrency <= 0 {
+		return defaultBatchConcurrency
+	}
+	return batch.Concurrency
+}
+
+func isBatchRequest(method, path string) bool {
+	return method == "POST" && strings.HasSuffix(path, "/validate-batch")
+}
+
+// A source of batch requests, returning io.EOF after the last. The message is what we tell the caller when it fails.
+type batchSource func() (json.RawMessage, string, error)
+
+// Reads the requests out of a batch body one at a time, so the body is never held as a whole
+type batchReader struct {
+	decoder *json.Decoder
+	lines   bool
+	max     int
+	count   int
+	// Read ahead so an empty or broken batch is caught before anything is sent
+	first json.RawMessage
+}
+
+// Whether the body is one request per line
+func isLinesBody(headers map[string]string) bool {
+	mediaType, _, _ := mime.ParseMediaType(requestHeader(headers, "Content-Type"))
+	return mediaType == ndjsonContentType || mediaType == jsonlContentType
+}
+
+// max is the most requests the batch can have
+func (config *Config) newBatchReader(body io.Reader, lines bool, max int) (*batchReader, string, error) {
+	reader := &batchReader{decoder: json.NewDecoder(body), lines: lines, max: max}
+	if !lines {
+		if message, err := reader.openRequests(); err != nil {
+			return nil, message, err
+		}
+	}
+	first, message, err := reader.read()
+	if err == io.EOF {
+		return nil, batchMissing, errors.New(batchMissing)
+	}
+	if err != nil {
+		return nil, message, err
+	}
+	reader.first = first
+	return reader, "", nil
+}
+
+func (reader *batchReader) expect(delim json.Delim) error {
+	token, err := reader.decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+// Reads up to the first request in {"requests": [...]}
+func (reader *batchReader) openRequests() (string, error) {
+	if err := reader.expect(json.Delim('{')); err != nil {
+		return "invalid json payload", err
+	}
+	if !reader.decoder.More() {
+		return batchMissing, errors.New(batchMissing)
+	}
+	key, err := reader.decoder.Token()
+	if err != nil {
+		return "invalid json payload", err
+	}
+	if key != "requests" {
+		err := fmt.Errorf("unknown field %q", key)
+		return err.Error() + " in payload", err
+	}
+	token, err := reader.decoder.Token()
+	if err != nil {
+		return "invalid json payload", err
+	}
+	if token == nil {
+		return batchMissing, errors.New(batchMissing)
+	}
+	if token != json.Delim('[') {
+		return "invalid json payload", fmt.Errorf("requests should be a list, got %v", token)
+	}
+	return "", nil
+}
+
+// Reads past the end of the requests, making sure nothing follows them
+func (reader *batchReader) closeRequests() error {
+	if err := reader.expect(json.Delim(']')); err != nil {
+		return err
+	}
+	if err := reader.expect(json.Delim('}')); err != nil {
+		return err
+	}
+	if _, err := reader.decoder.Token(); err != io.EOF {
+		return errors.New("unexpected data after the json payload")
+	}
+	return nil
+}
+
+func (reader *batchReader) read() (json.RawMessage, string, error) {
+	if !reader.decoder.More() {
+		if !reader.lines {
+			if err := reader.closeRequests(); err != nil {
+				return nil, "invalid json payload", err
+			}
+		}
+		return nil, "", io.EOF
+	}
+	if reader.count == reader.max {
+		message := fmt.Sprintf("too many requests, the most in one batch is %d", reader.max)
+		return nil, message, errors.New(message)
+	}
+	var body json.RawMessage
+	if err := reader.decoder.Decode(&body); err != nil {
+		return nil, "invalid json payload", err
+	}
+	reader.count++
+	return body, "", nil
+}
+
+// The next request, a batchSource
+func (reader *batchReader) next() (json.RawMessage, string, error) {
+	if first := reader.first; first != nil {
+		reader.first = nil
+		return first, "", nil
+	}
+	return reader.read()
+}
+
+// Reads the whole batch up front, for API Gateway where the body is in memory anyway, returning how many requests
+// there are
+func (config *Config) decodeBatch(request Request, max int) (batchSource, int, string, error) {
+	reader, message, err := config.newBatchReader(strings.NewReader(request.Body), isLinesBody(request.Headers), max)
+	if err != nil {
+		return nil, 0, message, err
+	}
+	requests := []json.RawMessage{}
+	for {
+		body, message, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, message, err
+		}
+		requests = append(requests, body)
+	}
+	total := len(requests)
+	return func() (json.RawMessage, string, error) {
+		if len(requests) == 0 {
+			return nil, "", io.EOF
+		}
+		body := requests[0]
+		requests = requests[1:]
+		return body, "", nil
+	}, total, "", nil
+}
+
+// Validates every request from the source, at most concurrency at a time, calling write once for each as it
+// finishes. Writes never overlap. The next request is only read once there's room for it. If the source fails, the
+// index it failed at gets an error line and nothing more is read. Each request gets its deadline from the schedule.
+func (config *Config) validateBatch(ctx context.Context, request Request, next batchSource, schedule *batchSchedule, write func(BatchResult)) {
+	var mu sync.Mutex
+	slots := make(chan struct{}, config.Batch.concurrency())
+	var wg sync.WaitGroup
+	for i := 0; ; i++ {
+		slots <- struct{}{}
+		body, message, err := next()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("bad batch at index %d: %v", i, err)
+				mu.Lock()
+				write(BatchResult{Index: i, Error: message})
+				mu.Unlock()
+			}
+			break
+		}
+		wg.Add(1)
+		go func(i int, body json.RawMessage) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result := BatchResult{Index: i}
+			if validationRequest, message, err := decodeRequest(Request{Body: string(body)}); err != nil {
+				log.Printf("bad request at index %d: %v", i, err)
+				result.Error = message
+			} else if message := config.itemError(ctx, request, validationRequest); message != "" {
+				result.Error = message
+			} else {
+				itemCtx, cancel, warning := config.scheduleItem(ctx, schedule, validationRequest)
+				response := config.validate(itemCtx, request, validationRequest, nil)
+				cancel()
+				if warning != "" {
+					response.Warnings = append(response.Warnings, warning)
+				}
+				response = config.signResponse(ctx, validationRequest, filterResponse(config.visibleFields(request), response))
+				result.Response = &response
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			write(result)
+		}(i, body)
+	}
+	wg.Wait()
+}
+
+// Why an item of a batch or matrix can't be validated, empty when it can
+func (config *Config) itemError(ctx context.Context, request Request, validationRequest *BankAccountValidationRequest) string {
+	if err := entitlementFrom(ctx).check(ctx, config.Providers, validationRequest); err != nil {
+		return err.Error()
+	}
+	if err := config.admissionError(ctx, request, validationRequest); err != nil {
+		return err.Error()
+	}
+	if rejection := config.noProvidersError(ctx, validationRequest); rejection != nil {
+		return rejection.Error
+	}
+	if err := config.fanOutError(request, config.selectedProviders(ctx, validationRequest)); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// Appends the result's line to buf
+func writeBatchResult(buf *bytes.Buffer, result BatchResult) {
+	mark := buf.Len()
+	if err := writeJSON(buf, result); err != nil {
+		log.Print(err)
+		buf.Truncate(mark)
+		writeJSON(buf, BatchResult{Index: result.Index, Error: "unable to serialise response"})
+	}
+	buf.WriteByte('\n')
+}
+
+// Handler for POST /validate-batch through API Gateway, where the whole response has to be buffered
+func (config *Config) BatchHandler(ctx context.Context, request Request) (Response, error) {
+	parsed := timerFrom(ctx).stage(StageParse)
+	next, total, message, err := config.decodeBatch(request, entitlementFrom(ctx).batchLimit(config.Batch.maxRequests()))
+	parsed()
+	if err != nil {
+		return *handleError(err, message), nil
+	}
+	if shedResponse := config.shed(request, &BankAccountValidationRequest{}); shedResponse != nil {
+		return *shedResponse, nil
+	}
+	ctx = withBatchSize(ctx, total)
+	var body bytes.Buffer
+	config.validateBatch(ctx, request, next, config.newBatchSchedule(ctx, total), func(result BatchResult) {
+		writeBatchResult(&body, result)
+	})
+	return Response{
+		StatusCode: 200,
+		Body:       body.String(),
+		Headers: map[string]string{
+			"Content-Type": ndjsonContentType,
+		},
+	}, nil
+}
+
+// Wraps the server's handler, taking over batch requests so each line goes out as soon as it's ready
+func (config *Config) batchStream(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isBatchRequest(r.Method, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The body is left to the batch reader
+		request := requestHead(r)
+		if shedResponse := config.shed(request, &BankAccountValidationRequest{}); shedResponse != nil {
+			writeResponse(w, *shedResponse)
+			return
+		}
+		reader, message, err := config.newBatchReader(r.Body, isLinesBody(request.Headers), config.Batch.maxRequests())
+		if err != nil {
+			writeResponse(w, *handleError(err, message))
+			return
+		}
+
+		w.Header().Set("Content-Type", ndjsonContentType)
+		w.WriteHeader(http.StatusOK)
+		line := getBuffer()
+		defer putBuffer(line)
+		config.validateBatch(r.Context(), request, reader.next, config.newBatchSchedule(r.Context(), 0), func(result BatchResult) {
+			line.Reset()
+			writeBatchResult(line, result)
+			w.Write(line.Bytes())
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		})
+	})
+}
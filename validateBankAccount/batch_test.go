@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdaurl"
+)
+
+const testBatch = "{\"requests\": [{\"accountNumber\": \"12345670\"}, {}, {\"accountNumber\": \"12345671\", \"strategy\": \"all\"}]}"
+
+func testBatchResults() []BatchResult {
+	return []BatchResult{
+		{Index: 0, Response: &BankAccountValidationResponse{
+			Result: []BankAccountValidationResult{{Provider: "provider1", IsValid: true}},
+		}},
+		{Index: 1, Error: "account number missing from payload"},
+		{Index: 2, Response: &BankAccountValidationResponse{
+			Result:    []BankAccountValidationResult{{Provider: "provider1", IsValid: false}},
+			Aggregate: &AggregateResult{Strategy: StrategyAll, IsValid: false},
+		}},
+	}
+}
+
+// Lines come out in the order they finish, so sort them before comparing
+func parseBatchResults(t *testing.T, body string) []BatchResult {
+	t.Helper()
+	results := []BatchResult{}
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		var result BatchResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatalf("bad line %q: %v", line, err)
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+	return results
+}
+
+func TestConfig_BatchHandler(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}}
+	response, err := config.Router(context.Background(), Request{HTTPMethod: "POST", Path: "/validate-batch", Body: testBatch})
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("Router() = %v, %v", response, err)
+	}
+	if got := response.Headers["Content-Type"]; got != ndjsonContentType {
+		t.Errorf("Content-Type = %v", got)
+	}
+	if got := parseBatchResults(t, response.Body); !reflect.DeepEqual(got, testBatchResults()) {
+		t.Errorf("results = %+v, want %+v", got, testBatchResults())
+	}
+}
+
+func TestConfig_BatchHandler_badBatch(t *testing.T) {
+	config := &Config{Batch: BatchConfig{MaxRequests: 1}}
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "notJson", body: "[", want: "{\"error\":\"invalid json payload\"}"},
+		{name: "empty", body: "{\"requests\": []}", want: "{\"error\":\"requests missing from payload\"}"},
+		{name: "tooMany", body: testBatch, want: "{\"error\":\"too many requests, the most in one batch is 1\"}"},
+		{name: "unknownField", body: "{\"request\": []}", want: "{\"error\":\"unknown field \\\"request\\\" in payload\"}"},
+		{name: "nullRequests", body: "{\"requests\": null}", want: "{\"error\":\"requests missing from payload\"}"},
+		{name: "trailingData", body: "{\"requests\": [{}]} {}", want: "{\"error\":\"invalid json payload\"}"},
+		{name: "brokenRequest", body: "{\"requests\": [{]}", want: "{\"error\":\"invalid json payload\"}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, _ := config.BatchHandler(context.Background(), Request{Body: tt.body})
+			if response.StatusCode != 500 || response.Body != tt.want {
+				t.Errorf("BatchHandler() = %v %v, want %v", response.StatusCode, response.Body, tt.want)
+			}
+		})
+	}
+}
+
+// The function URL path, which streams the lines rather than buffering them
+func TestConfig_streamingHandler_batch(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}}
+	request := &events.LambdaFunctionURLRequest{RawPath: "/validate-batch", Body: testBatch}
+	request.RequestContext.HTTP.Method = "POST"
+
+	response, err := lambdaurl.Wrap(config.streamingHandler())(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 || response.Headers["Content-Type"] != ndjsonContentType {
+		t.Errorf("response = %v %v", response.StatusCode, response.Headers)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parseBatchResults(t, string(body)); !reflect.DeepEqual(got, testBatchResults()) {
+		t.Errorf("results = %+v, want %+v", got, testBatchResults())
+	}
+}
+
+func TestConfig_BatchHandler_lines(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}}
+	request := Request{
+		Headers: map[string]string{"content-type": "application/x-ndjson; charset=utf-8"},
+		Body:    "{\"accountNumber\": \"12345670\"}\n{}\n{\"accountNumber\": \"12345671\", \"strategy\": \"all\"}\n",
+	}
+	response, err := config.BatchHandler(context.Background(), request)
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("BatchHandler() = %v, %v", response, err)
+	}
+	if got := parseBatchResults(t, response.Body); !reflect.DeepEqual(got, testBatchResults()) {
+		t.Errorf("results = %+v, want %+v", got, testBatchResults())
+	}
+}
+
+// Once streaming has started a bad body can only be reported on a line of its own
+func TestConfig_batchStream_failsPartWay(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxRequests int
+		body        string
+		want        BatchResult
+	}{
+		{name: "tooMany", maxRequests: 2, body: testBatch, want: BatchResult{Index: 2, Error: "too many requests, the most in one batch is 2"}},
+		{name: "broken", maxRequests: 10, body: "{\"requests\": [{\"accountNumber\": \"12345670\"}, {}, {\"acc", want: BatchResult{Index: 2, Error: "invalid json payload"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+				Batch:     BatchConfig{MaxRequests: tt.maxRequests},
+			}
+			recorder := httptest.NewRecorder()
+			config.streamingHandler().ServeHTTP(recorder, httptest.NewRequest("POST", "/validate-batch", strings.NewReader(tt.body)))
+			if recorder.Code != 200 {
+				t.Fatalf("status = %v", recorder.Code)
+			}
+			want := append(testBatchResults()[:2], tt.want)
+			if got := parseBatchResults(t, recorder.Body.String()); !reflect.DeepEqual(got, want) {
+				t.Errorf("results = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// Only as many requests as can be validated at once are read ahead of the validations
+func TestConfig_validateBatch_readsLazily(t *testing.T) {
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		Batch:     BatchConfig{Concurrency: 2},
+	}
+	reader, _, err := config.newBatchReader(strings.NewReader(strings.Repeat("{\"accountNumber\": \"12345670\"}\n", 50)), true, config.Batch.maxRequests())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	read, written, most := 0, 0, 0
+	next := func() (json.RawMessage, string, error) {
+		body, message, err := reader.next()
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil {
+			read++
+		}
+		if read-written > most {
+			most = read - written
+		}
+		return body, message, err
+	}
+	config.validateBatch(context.Background(), Request{}, next, nil, func(BatchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		written++
+	})
+	if written != 50 || most > 2 {
+		t.Errorf("wrote %d, at most %d requests in hand, want 50 and 2", written, most)
+	}
+}
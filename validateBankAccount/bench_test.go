@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Provider counts we benchmark against. 50 is well past anything we run in
+// production but shows how the fan-out scales.
+var benchProviderCounts = []int{1, 10, 50}
+
+// Spins up a local provider that always answers valid and returns n providers
+// pointing at it.
+func newBenchProviders(b *testing.B, n int) []Provider {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	b.Cleanup(server.Close)
+
+	providers := make([]Provider, n)
+	for i := range providers {
+		providers[i] = Provider{Name: fmt.Sprintf("provider%d", i+1), URL: server.URL}
+	}
+	return providers
+}
+
+func newBenchResponse(n int) BankAccountValidationResponse {
+	results := make([]BankAccountValidationResult, n)
+	for i := range results {
+		results[i] = BankAccountValidationResult{Provider: fmt.Sprintf("provider%d", i+1), IsValid: i%2 == 0}
+	}
+	return BankAccountValidationResponse{Result: results}
+}
+
+func BenchmarkCheckProviders(b *testing.B) {
+	for _, n := range benchProviderCounts {
+		b.Run(fmt.Sprintf("providers=%d", n), func(b *testing.B) {
+			providers := newBenchProviders(b, n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				checkProviders(context.Background(), "12345678", providers)
+			}
+		})
+	}
+}
+
+func BenchmarkMarshalResponse(b *testing.B) {
+	for _, n := range benchProviderCounts {
+		b.Run(fmt.Sprintf("providers=%d", n), func(b *testing.B) {
+			response := newBenchResponse(n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := marshalResponse(response); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAggregateResults(b *testing.B) {
+	for _, n := range benchProviderCounts {
+		b.Run(fmt.Sprintf("providers=%d", n), func(b *testing.B) {
+			providers := make([]Provider, n)
+			for i := range providers {
+				providers[i] = Provider{Name: fmt.Sprintf("provider%d", i+1)}
+			}
+			results := newBenchResponse(n).Result
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				channel := make(chan BankAccountValidationResult, n)
+				for _, result := range results {
+					channel <- result
+				}
+				close(channel)
+				aggregateResults(providers, channel)
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeRequest(b *testing.B) {
+	request := Request{Body: "{\"accountNumber\": \"12345678\", \"providers\": [\"provider1\", \"provider2\"], \"strategy\": \"all\"}"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := decodeRequest(request); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteBatchResult(b *testing.B) {
+	for _, n := range benchProviderCounts {
+		b.Run(fmt.Sprintf("providers=%d", n), func(b *testing.B) {
+			response := newBenchResponse(n)
+			result := BatchResult{Index: 1, Response: &response}
+			var buf bytes.Buffer
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				writeBatchResult(&buf, result)
+			}
+		})
+	}
+}
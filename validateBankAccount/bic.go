@@ -0,0 +1,84 @@
+package main
+
+import "strings"
+
+/*
+  BIC checks. A request can carry the bank's BIC (SWIFT code) and we check it ourselves, no provider involved:
+
+    {"accountNumber": "DE89370400440532013000", "bic": "COBADEFFXXX"}
+    {"result": [{"provider": "provider1", "isValid": true},
+                {"provider": "local:bic", "isValid": true, "local": true},
+                {"provider": "local:bic_country", "isValid": true, "local": true}]}
+
+  local:bic says whether it's a well formed ISO 9362 code: 4 letters for the institution, an ISO 3166 country, 2
+  letters or digits for the location and optionally 3 for the branch. When the account number is an IBAN,
+  local:bic_country says whether the BIC's country is the IBAN's. Local results are marked local and never count
+  towards the aggregate, a good BIC doesn't make an account valid.
+*/
+
+const (
+	LocalBIC        = "local:bic"
+	LocalBICCountry = "local:bic_country"
+)
+
+// ISO 3166-1 alpha-2, plus XK which SWIFT uses for Kosovo
+var countryCodes = strings.Fields(`
+	AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ
+	CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ DE DJ DK DM DO DZ EC EE EG EH ER ES ET FI FJ FK FM FO FR
+	GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY HK HM HN HR HT HU ID IE IL IM IN IO IQ IR IS IT JE JM JO
+	JP KE KG KH KI KM KN KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR
+	MS MT MU MV MW MX MY MZ NA NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF PG PH PK PL PM PN PR PS PT PW PY QA RE RO
+	RS RU RW SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ TC TD TF TG TH TJ TK TL TM TN TO TR TT TV
+	TW TZ UA UG UM US UY UZ VA VC VE VG VI VN VU WF WS XK YE YT ZA ZM ZW`)
+
+var knownCountries = func() map[string]bool {
+	known := map[string]bool{}
+	for _, code := range countryCodes {
+		known[code] = true
+	}
+	return known
+}()
+
+func isUpperLetter(r byte) bool { return r >= 'A' && r <= 'Z' }
+
+func isDigit(r byte) bool { return r >= '0' && r <= '9' }
+
+// Whether the BIC is a well formed ISO 9362 code
+func validBIC(bic string) bool {
+	if len(bic) != 8 && len(bic) != 11 {
+		return false
+	}
+	for i := 0; i < len(bic); i++ {
+		c := bic[i]
+		switch {
+		case i < 6 && !isUpperLetter(c):
+			return false
+		case i >= 6 && !isUpperLetter(c) && !isDigit(c):
+			return false
+		}
+	}
+	return knownCountries[bic[4:6]]
+}
+
+// The country of an account number that looks like an IBAN, a country and two check digits up front
+func ibanCountry(accountNumber string) (string, bool) {
+	iban := strings.ToUpper(strings.ReplaceAll(accountNumber, " ", ""))
+	if len(iban) < 15 || !isUpperLetter(iban[0]) || !isUpperLetter(iban[1]) || !isDigit(iban[2]) || !isDigit(iban[3]) {
+		return "", false
+	}
+	return iban[:2], knownCountries[iban[:2]]
+}
+
+// The local results for the request's BIC, none without one
+func bicChecks(validationRequest *BankAccountValidationRequest) []BankAccountValidationResult {
+	if validationRequest.BIC == nil {
+		return nil
+	}
+	bic := strings.ToUpper(strings.TrimSpace(*validationRequest.BIC))
+	results := []BankAccountValidationResult{{Provider: LocalBIC, IsValid: validBIC(bic), Local: true}}
+	if country, isIBAN := ibanCountry(*validationRequest.AccountNumber); isIBAN {
+		matches := len(bic) >= 6 && bic[4:6] == country
+		results = append(results, BankAccountValidationResult{Provider: LocalBICCountry, IsValid: matches, Local: true})
+	}
+	return results
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func Test_validBIC(t *testing.T) {
+	tests := []struct {
+		bic  string
+		want bool
+	}{
+		{"COBADEFF", true},
+		{"COBADEFFXXX", true},
+		{"NWBKGB2L", true},
+		{"DEUTDEDB101", true},
+		{"COBADEF", false},
+		{"COBADEFFXX", false},
+		{"C0BADEFF", false},
+		{"COBAZZFF", false},
+		{"cobadeff", false},
+		{"COBADEF-", false},
+	}
+	for _, tt := range tests {
+		if got := validBIC(tt.bic); got != tt.want {
+			t.Errorf("validBIC(%q) = %v, want %v", tt.bic, got, tt.want)
+		}
+	}
+}
+
+func Test_bicChecks(t *testing.T) {
+	request := func(accountNumber, bic string) *BankAccountValidationRequest {
+		return &BankAccountValidationRequest{AccountNumber: &accountNumber, BIC: &bic}
+	}
+	tests := []struct {
+		name    string
+		request *BankAccountValidationRequest
+		want    []BankAccountValidationResult
+	}{
+		{"noBIC", &BankAccountValidationRequest{AccountNumber: new(string)}, nil},
+		{"domestic", request("12345678", "nwbkgb2l"), []BankAccountValidationResult{{Provider: LocalBIC, IsValid: true, Local: true}}},
+		{"ibanMatches", request("DE89 3704 0044 0532 0130 00", "COBADEFFXXX"), []BankAccountValidationResult{
+			{Provider: LocalBIC, IsValid: true, Local: true},
+			{Provider: LocalBICCountry, IsValid: true, Local: true},
+		}},
+		{"ibanDiffers", request("DE89370400440532013000", "NWBKGB2L"), []BankAccountValidationResult{
+			{Provider: LocalBIC, IsValid: true, Local: true},
+			{Provider: LocalBICCountry, IsValid: false, Local: true},
+		}},
+		{"malformed", request("DE89370400440532013000", "X"), []BankAccountValidationResult{
+			{Provider: LocalBIC, IsValid: false, Local: true},
+			{Provider: LocalBICCountry, IsValid: false, Local: true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bicChecks(tt.request); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bicChecks() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_validate_bicNeverCounts(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}}
+	accountNumber, bic, strategy := "12345671", "NWBKGB2L", StrategyAny
+	response := config.validate(context.Background(), Request{}, &BankAccountValidationRequest{AccountNumber: &accountNumber, BIC: &bic, Strategy: &strategy}, nil)
+	if len(response.Result) != 2 || !response.Result[1].IsValid {
+		t.Fatalf("expected the provider's answer and a valid bic, got %+v", response.Result)
+	}
+	if response.Aggregate.IsValid {
+		t.Error("a valid bic shouldn't make the account valid")
+	}
+	if got := outcome(&BankAccountValidationRequest{}, BankAccountValidationResponse{Result: []BankAccountValidationResult{
+		{Provider: "provider1", Error: ProviderErrorTimeout}, {Provider: LocalBIC, IsValid: true, Local: true},
+	}}); got != ResultStatusError {
+		t.Errorf("outcome() = %v, a bic check isn't an answer", got)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+/*
+  Billing events. Every chargeable provider call puts a ProviderCallBilled event on the bus in EVENT_BUS_NAME, so
+  finance can reconcile partner invoices against what we actually sent:
+
+    {"provider": "provider1", "unitCost": 0.05, "caller": "client-42", "tenant": "acme", "requestId": "...",
+     "at": "2024-06-01T09:00:00Z"}
+
+    billing:
+      tenantClaim: custom:tenant   # the authorizer claim naming the caller's tenant, default tenant
+
+  A call is chargeable when the provider has a unitCost and it answered, each retry that gets an answer is billed
+  too since partners bill us for those. Calls that never got a response, and simulated, SEPA and test account
+  lookups, aren't. Events are buffered with the telemetry and published when it's flushed, after the response has
+  gone, so billing never adds latency. A flush that can't publish drops them with a log line, the audit table and
+  the usage report are the backstop.
+*/
+
+const (
+	BillingEventDetailType = "ProviderCallBilled"
+	defaultTenantClaim     = "tenant"
+)
+
+type BillingConfig struct {
+	TenantClaim string `yaml:"tenantClaim"`
+}
+
+type BillingEvent struct {
+	Provider  string    `json:"provider"`
+	UnitCost  float64   `json:"unitCost"`
+	Caller    string    `json:"caller"`
+	Tenant    string    `json:"tenant,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// Who's paying for the provider calls a validation makes
+type billingParty struct {
+	telemetry *telemetry
+	caller    string
+	tenant    string
+	requestID string
+}
+
+type billingKey struct{}
+
+// Whether the provider charges for the answer it gave
+func chargeable(provider Provider, result BankAccountValidationResult) bool {
+	return provider.UnitCost > 0 && (result.Error == "" || result.Error == ProviderErrorResponse)
+}
+
+// Who the request's provider calls are billed to, nothing is billed without a bus to put the events on
+func (config *Config) withBilling(ctx context.Context, request Request) context.Context {
+	if config.telemetry == nil || config.telemetry.events == nil {
+		return ctx
+	}
+	party := &billingParty{telemetry: config.telemetry, caller: callerIdentity(request), requestID: requestID(ctx, request), tenant: config.tenant(request)}
+	return context.WithValue(ctx, billingKey{}, party)
+}
+
+// The tenant in the caller's claims, empty when there isn't one
+func (config *Config) tenant(request Request) string {
+	claim := config.Billing.TenantClaim
+	if claim == "" {
+		claim = defaultTenantClaim
+	}
+	tenant, _ := requestClaims(request)[claim].(string)
+	return tenant
+}
+
+// Bills the call to the request's party, if it has one
+func bill(ctx context.Context, provider Provider) {
+	party, _ := ctx.Value(billingKey{}).(*billingParty)
+	if party == nil {
+		return
+	}
+	party.telemetry.bill(BillingEvent{
+		Provider:  provider.Name,
+		UnitCost:  provider.UnitCost,
+		Caller:    party.caller,
+		Tenant:    party.tenant,
+		RequestID: party.requestID,
+		At:        party.telemetry.now().UTC(),
+	})
+}
+
+func (telemetry *telemetry) bill(event BillingEvent) {
+	telemetry.mu.Lock()
+	defer telemetry.mu.Unlock()
+	telemetry.billing = append(telemetry.billing, event)
+}
+
+// Publishers that can put several events in one call
+type batchEventPublisher interface {
+	PutEvents(ctx context.Context, detailType string, details []interface{}) error
+}
+
+func (telemetry *telemetry) publishBilling(ctx context.Context, billing []BillingEvent) {
+	if len(billing) == 0 {
+		return
+	}
+	details := make([]interface{}, len(billing))
+	for i, event := range billing {
+		details[i] = event
+	}
+	if batch, ok := telemetry.events.(batchEventPublisher); ok {
+		if err := batch.PutEvents(ctx, BillingEventDetailType, details); err != nil {
+			log.Printf("dropped %d billing events: %v", len(billing), err)
+		}
+		return
+	}
+	for _, detail := range details {
+		if err := telemetry.events.PutEvent(ctx, BillingEventDetailType, detail); err != nil {
+			log.Printf("dropped a billing event: %v", err)
+		}
+	}
+}
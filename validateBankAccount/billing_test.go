@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeBillingEvents struct {
+	detailType string
+	events     []BillingEvent
+}
+
+func (bus *fakeBillingEvents) PutEvent(ctx context.Context, detailType string, detail interface{}) error {
+	return bus.PutEvents(ctx, detailType, []interface{}{detail})
+}
+
+func (bus *fakeBillingEvents) PutEvents(ctx context.Context, detailType string, details []interface{}) error {
+	bus.detailType = detailType
+	for _, detail := range details {
+		bus.events = append(bus.events, detail.(BillingEvent))
+	}
+	return nil
+}
+
+func Test_chargeable(t *testing.T) {
+	priced := Provider{Name: "provider1", UnitCost: 0.05}
+	tests := []struct {
+		name     string
+		provider Provider
+		result   BankAccountValidationResult
+		want     bool
+	}{
+		{"answered", priced, BankAccountValidationResult{IsValid: true}, true},
+		{"badResponse", priced, BankAccountValidationResult{Error: ProviderErrorResponse}, true},
+		{"timeout", priced, BankAccountValidationResult{Error: ProviderErrorTimeout}, false},
+		{"free", Provider{Name: "provider2"}, BankAccountValidationResult{IsValid: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chargeable(tt.provider, tt.result); got != tt.want {
+				t.Errorf("chargeable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Handler_billing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer server.Close()
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	bus := &fakeBillingEvents{}
+	config := &Config{
+		Providers: []Provider{
+			{Name: "provider1", URL: server.URL, UnitCost: 0.05},
+			{Name: "provider2", URL: server.URL},
+			{Name: "provider3", Type: ProviderTypeSimulated, UnitCost: 0.05},
+		},
+		Billing:   BillingConfig{TenantClaim: "custom:tenant"},
+		telemetry: newTelemetry(nil, &bytes.Buffer{}),
+	}
+	config.telemetry.events = bus
+	config.telemetry.now = func() time.Time { return now }
+	config.setupProviders()
+
+	request := Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\", \"strategy\": \"all\"}"}
+	request.RequestContext.RequestID = "req-1"
+	request.RequestContext.Authorizer = map[string]interface{}{"principalId": "client-42", "custom:tenant": "acme"}
+	if _, err := config.Handler(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if len(bus.events) != 0 {
+		t.Error("billing events should wait for the flush")
+	}
+	config.telemetry.flush(context.Background())
+
+	want := []BillingEvent{{Provider: "provider1", UnitCost: 0.05, Caller: "client-42", Tenant: "acme", RequestID: "req-1", At: now}}
+	if !reflect.DeepEqual(bus.events, want) || bus.detailType != BillingEventDetailType {
+		t.Errorf("events = %+v, want %+v", bus.events, want)
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+/*
+  Request body logging. When an incident needs the exact requests a caller sent we can switch on logging of every
+  request body, with the account numbers tokenized, into its own log group (BODY_LOG_GROUP) that only keeps a few
+  days, so nothing lingers after the investigation:
+
+    bodyLogging:
+      flag: /applications/accountvalidator/environments/dev/configurations/flags   # the AppConfig extension's path
+      refreshSeconds: 30   # default 45, the extension's own poll interval
+
+  It's switched with the requestBodyLogging flag in that AppConfig profile, so it can go on and off without a deploy.
+  until turns it off again by itself, in case nobody remembers to:
+
+    {"requestBodyLogging": {"enabled": true, "until": "2024-06-02T00:00:00Z"}}
+
+    {"requestId": "c6af9ac6-...", "at": "2024-06-01T09:12:01Z", "caller": "client-42", "method": "POST",
+     "path": "/application", "body": {"accountNumber": "tok_5e88...", "providers": ["provider1"]}}
+
+  A token is tok_ and the account hash the audit record keys on, keyed so it can't be reversed (see accounthash.go),
+  so a logged request can be matched to its audit record without the number itself being written anywhere. accountNumber and iban are tokenized wherever they are in
+  the body or query string, and so is any other run of 6 or more digits, an account number in a graphql query for
+  instance. A body that isn't JSON is logged as a tokenized string. A request with what looks like a card number
+  anywhere in its body or query string (see cards.go) has neither logged, only "redacted": "card number", since a
+  card number is never to be written down even as a token. Flag lookups that fail keep the last answer, and
+  nothing is logged until the first one succeeds. Entries go out with the rest of the telemetry when the invocation
+  finishes.
+*/
+
+const (
+	bodyLoggingFlagName     = "requestBodyLogging"
+	defaultBodyLoggingPoll  = 45 * time.Second
+	bodyLoggingFetchTimeout = time.Second
+	// PutLogEvents takes at most 1MB, counting 26 bytes for each event
+	maxLogBatchBytes = 1000000
+	maxLogBatchCount = 10000
+	logEventOverhead = 26
+)
+
+var (
+	tokenizedFields = map[string]bool{"accountnumber": true, "iban": true}
+	digitRuns       = regexp.MustCompile(`[0-9]{6,}`)
+	// Digits in groups as people type them, 4111 1111 1111 1111 or 4111-1111-1111-1111
+	digitGroups = regexp.MustCompile(`[0-9]+(?:[ -][0-9]+)*`)
+)
+
+type BodyLoggingConfig struct {
+	Flag           string `yaml:"flag"`
+	RefreshSeconds int    `yaml:"refreshSeconds"`
+}
+
+func (bodyLogging BodyLoggingConfig) validate() error {
+	if bodyLogging.Flag != "" && !strings.HasPrefix(bodyLogging.Flag, "/applications/") {
+		return fmt.Errorf("bodyLogging flag %s should be an AppConfig path like /applications/<app>/environments/<env>/configurations/<profile>", bodyLogging.Flag)
+	}
+	if bodyLogging.RefreshSeconds < 0 {
+		return errors.New("bodyLogging refreshSeconds can't be negative")
+	}
+	return nil
+}
+
+func (bodyLogging BodyLoggingConfig) refresh() time.Duration {
+	if bodyLogging.RefreshSeconds == 0 {
+		return defaultBodyLoggingPoll
+	}
+	return time.Duration(bodyLogging.RefreshSeconds) * time.Second
+}
+
+func tokenize(accountNumber string) string {
+	return "tok_" + accountHash(accountNumber)
+}
+
+func tokenizeDigits(value string) string {
+	return digitRuns.ReplaceAllStringFunc(value, tokenize)
+}
+
+// A copy of the decoded JSON with the account numbers tokenized
+func tokenizeJSON(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		tokenized := make(map[string]interface{}, len(value))
+		for key, field := range value {
+			if text, isString := field.(string); isString && tokenizedFields[strings.ToLower(key)] {
+				tokenized[key] = tokenize(text)
+			} else {
+				tokenized[key] = tokenizeJSON(field)
+			}
+		}
+		return tokenized
+	case []interface{}:
+		tokenized := make([]interface{}, len(value))
+		for i, item := range value {
+			tokenized[i] = tokenizeJSON(item)
+		}
+		return tokenized
+	case string:
+		return tokenizeDigits(value)
+	default:
+		return value
+	}
+}
+
+// The body as JSON if it is, otherwise as a string, account numbers tokenized either way
+func tokenizeBody(body string) interface{} {
+	if body == "" {
+		return nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+		return tokenizeDigits(body)
+	}
+	return tokenizeJSON(decoded)
+}
+
+// Whether any run of digits in the text, spaced or not, is a card number
+func containsCard(text string) bool {
+	for _, run := range digitGroups.FindAllString(text, -1) {
+		groups := strings.FieldsFunc(run, func(r rune) bool { return r == ' ' || r == '-' })
+		for i := range groups {
+			digits := ""
+			for _, group := range groups[i:] {
+				if digits += group; len(digits) > 16 {
+					break
+				}
+				if looksLikeCard(digits) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func requestHasCard(request Request) bool {
+	for _, value := range request.QueryStringParameters {
+		if containsCard(value) {
+			return true
+		}
+	}
+	return containsCard(request.Body)
+}
+
+func tokenizeQuery(query map[string]string) map[string]string {
+	if len(query) == 0 {
+		return nil
+	}
+	tokenized := make(map[string]string, len(query))
+	for name, value := range query {
+		if tokenizedFields[strings.ToLower(name)] {
+			tokenized[name] = tokenize(value)
+		} else {
+			tokenized[name] = tokenizeDigits(value)
+		}
+	}
+	return tokenized
+}
+
+type bodyLogEntry struct {
+	RequestID string            `json:"requestId,omitempty"`
+	At        time.Time         `json:"at"`
+	Caller    string            `json:"caller,omitempty"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Query     map[string]string `json:"query,omitempty"`
+	Body      interface{}       `json:"body,omitempty"`
+	// Why the body and query aren't there
+	Redacted string `json:"redacted,omitempty"`
+}
+
+// The requestBodyLogging flag as the AppConfig extension hands it back
+type bodyLoggingFlag struct {
+	Enabled bool       `json:"enabled"`
+	Until   *time.Time `json:"until"`
+}
+
+func (flag bodyLoggingFlag) on(now time.Time) bool {
+	return flag.Enabled && (flag.Until == nil || now.Before(*flag.Until))
+}
+
+// Reads the flags profile from the AppConfig Lambda extension
+func fetchBodyLoggingFlag(ctx context.Context, client *http.Client, url string) (bodyLoggingFlag, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return bodyLoggingFlag{}, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return bodyLoggingFlag{}, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return bodyLoggingFlag{}, err
+	}
+	if response.StatusCode != 200 {
+		return bodyLoggingFlag{}, fmt.Errorf("AppConfig flags fetch failed: %s %s", response.Status, body)
+	}
+	var flags map[string]bodyLoggingFlag
+	if err := json.Unmarshal(body, &flags); err != nil {
+		return bodyLoggingFlag{}, err
+	}
+	return flags[bodyLoggingFlagName], nil
+}
+
+// Where body log entries end up
+type logSink interface {
+	PutLogEvents(ctx context.Context, events []logEvent) error
+}
+
+type logEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+type bodyLogger struct {
+	mu         sync.Mutex
+	sink       logSink
+	fetch      func(ctx context.Context) (bodyLoggingFlag, error)
+	refresh    time.Duration
+	flag       bodyLoggingFlag
+	loadedAt   time.Time
+	refreshing bool
+	pending    []logEvent
+	now        func() time.Time
+}
+
+func newBodyLogger(sink logSink, fetch func(ctx context.Context) (bodyLoggingFlag, error), refresh time.Duration) *bodyLogger {
+	return &bodyLogger{sink: sink, fetch: fetch, refresh: refresh, now: time.Now}
+}
+
+// Whether bodies are being logged, checking the flag again in the background once it's stale
+func (logger *bodyLogger) enabled() bool {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if !logger.refreshing && logger.now().Sub(logger.loadedAt) >= logger.refresh {
+		logger.refreshing = true
+		go logger.reload()
+	}
+	return logger.flag.on(logger.now())
+}
+
+// Failures keep the flag we have until the next refresh
+func (logger *bodyLogger) reload() {
+	ctx, cancel := context.WithTimeout(context.Background(), bodyLoggingFetchTimeout)
+	defer cancel()
+	flag, err := logger.fetch(ctx)
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.refreshing = false
+	logger.loadedAt = logger.now()
+	if err != nil {
+		log.Printf("unable to read the %s flag, keeping it as it was: %v", bodyLoggingFlagName, err)
+		return
+	}
+	logger.flag = flag
+}
+
+func (logger *bodyLogger) log(entry bodyLogEntry) {
+	message, err := json.Marshal(entry)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.pending = append(logger.pending, logEvent{Timestamp: entry.At.UnixMilli(), Message: string(message)})
+}
+
+// Writes out what's been logged, as few PutLogEvents as the limits allow
+func (logger *bodyLogger) flush(ctx context.Context) {
+	logger.mu.Lock()
+	events := logger.pending
+	logger.pending = nil
+	logger.mu.Unlock()
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp < events[j].Timestamp })
+	for len(events) > 0 {
+		size, count := 0, 0
+		for count < len(events) && count < maxLogBatchCount && size+len(events[count].Message)+logEventOverhead <= maxLogBatchBytes {
+			size += len(events[count].Message) + logEventOverhead
+			count++
+		}
+		if count == 0 {
+			// Too big for a batch on its own, CloudWatch would refuse it anyway
+			log.Printf("dropped a %d byte request body log entry", len(events[0].Message))
+			events = events[1:]
+			continue
+		}
+		if err := logger.sink.PutLogEvents(ctx, events[:count]); err != nil {
+			log.Printf("dropped %d request body log entries: %v", count, err)
+		}
+		events = events[count:]
+	}
+}
+
+// Logs the request's body when body logging is switched on
+func (config *Config) logRequestBody(ctx context.Context, request Request) {
+	if config.bodyLogger == nil || !config.bodyLogger.enabled() {
+		return
+	}
+	entry := bodyLogEntry{
+		RequestID: requestID(ctx, request),
+		At:        config.bodyLogger.now().UTC(),
+		Caller:    callerIdentity(request),
+		Method:    request.HTTPMethod,
+		Path:      request.Path,
+	}
+	if requestHasCard(request) {
+		entry.Redacted = "card number"
+	} else {
+		entry.Query = tokenizeQuery(request.QueryStringParameters)
+		entry.Body = tokenizeBody(request.Body)
+	}
+	config.bodyLogger.log(entry)
+}
+
+// CreateLogStream and PutLogEvents through signedAWSClient, see aws.go
+type cloudWatchLogs struct {
+	api      *signedAWSClient
+	endpoint string
+	group    string
+	stream   string
+	// The stream is made on the first put
+	streamCreated bool
+}
+
+func newCloudWatchLogs(ctx context.Context, group string) (*cloudWatchLogs, error) {
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &cloudWatchLogs{
+		api:      newSignedAWSClient(cfg, "logs", 2*time.Second),
+		endpoint: "https://logs." + cfg.Region + ".amazonaws.com",
+		group:    group,
+		// One stream per container, PutLogEvents on a stream can't run concurrently
+		stream: time.Now().UTC().Format("2006/01/02") + "/" + newCallID(),
+	}, nil
+}
+
+func (logs *cloudWatchLogs) call(ctx context.Context, action string, input interface{}) error {
+	if err := logs.api.callJSON(ctx, logs.endpoint, "Logs_20140328."+action, "1.1", input, nil); err != nil {
+		return fmt.Errorf("%s failed: %w", action, err)
+	}
+	return nil
+}
+
+func (logs *cloudWatchLogs) PutLogEvents(ctx context.Context, events []logEvent) error {
+	if !logs.streamCreated {
+		err := logs.call(ctx, "CreateLogStream", map[string]string{"logGroupName": logs.group, "logStreamName": logs.stream})
+		// A retried CreateLogStream can find the stream made by the attempt that timed out
+		var apiErr smithy.APIError
+		if err != nil && !(errors.As(err, &apiErr) && apiErr.ErrorCode() == "ResourceAlreadyExistsException") {
+			return err
+		}
+		logs.streamCreated = true
+	}
+	return logs.call(ctx, "PutLogEvents", map[string]interface{}{"logGroupName": logs.group, "logStreamName": logs.stream, "logEvents": events})
+}
+
+// Starts watching the flag when there's a log group for bodies and a flag to switch them on with
+func (config *Config) setupBodyLogging(ctx context.Context) {
+	group, exists := os.LookupEnv("BODY_LOG_GROUP")
+	if !exists || config.BodyLogging.Flag == "" {
+		return
+	}
+	sink, err := newCloudWatchLogs(ctx, group)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	port := os.Getenv("AWS_APPCONFIG_EXTENSION_HTTP_PORT")
+	if port == "" {
+		port = "2772"
+	}
+	url := "http://localhost:" + port + config.BodyLogging.Flag
+	client := &http.Client{Timeout: bodyLoggingFetchTimeout}
+	config.bodyLogger = newBodyLogger(sink, func(ctx context.Context) (bodyLoggingFlag, error) {
+		return fetchBodyLoggingFlag(ctx, client, url)
+	}, config.BodyLogging.refresh())
+	config.bodyLogger.enabled()
+	config.telemetry.onFlush(config.bodyLogger.flush)
+}
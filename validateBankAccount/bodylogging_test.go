@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type fakeLogSink struct {
+	batches [][]logEvent
+}
+
+func (sink *fakeLogSink) PutLogEvents(ctx context.Context, events []logEvent) error {
+	sink.batches = append(sink.batches, events)
+	return nil
+}
+
+func Test_tokenizeBody(t *testing.T) {
+	token := tokenize("12345678")
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"validation", "{\"accountNumber\": \"12345678\", \"providers\": [\"provider1\"]}",
+			"{\"accountNumber\":\"" + token + "\",\"providers\":[\"provider1\"]}"},
+		{"batch", "{\"requests\": [{\"accountNumber\": \"12345678\"}, {\"IBAN\": \"GB29NWBK60161331926819\"}]}",
+			"{\"requests\":[{\"accountNumber\":\"" + token + "\"},{\"IBAN\":\"" + tokenize("GB29NWBK60161331926819") + "\"}]}"},
+		{"graphql", "{\"query\": \"{ validate(accountNumber: \\\"12345678\\\") { isValid } }\"}",
+			"{\"query\":\"{ validate(accountNumber: \\\"" + token + "\\\") { isValid } }\"}"},
+		{"notJSON", "accountNumber=12345678", "\"accountNumber=" + token + "\""},
+		{"shortNumbersKept", "{\"minProviders\": 2, \"bankCode\": \"6016\"}", "{\"bankCode\":\"6016\",\"minProviders\":2}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := json.Marshal(tokenizeBody(tt.body))
+			if string(got) != tt.want {
+				t.Errorf("tokenizeBody() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_logRequestBody(t *testing.T) {
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	until := now.Add(time.Hour)
+	sink := &fakeLogSink{}
+	flag := bodyLoggingFlag{Enabled: true, Until: &until}
+	logger := newBodyLogger(sink, func(ctx context.Context) (bodyLoggingFlag, error) { return flag, nil }, time.Minute)
+	logger.now = func() time.Time { return now }
+	logger.reload()
+	config := &Config{bodyLogger: logger}
+	request := Request{
+		HTTPMethod:            "GET",
+		Path:                  "/application",
+		QueryStringParameters: map[string]string{"accountNumber": "12345678", "currency": "GBP"},
+		RequestContext:        events.APIGatewayProxyRequestContext{RequestID: "req-1"},
+	}
+
+	config.logRequestBody(context.Background(), request)
+	// Past until nothing's logged, even with the flag still on
+	now = until
+	config.logRequestBody(context.Background(), request)
+	logger.flush(context.Background())
+
+	if len(sink.batches) != 1 || len(sink.batches[0]) != 1 {
+		t.Fatalf("logged %v, want one entry", sink.batches)
+	}
+	want := "{\"requestId\":\"req-1\",\"at\":\"2024-06-01T09:00:00Z\",\"caller\":\"anonymous\",\"method\":\"GET\",\"path\":\"/application\"," +
+		"\"query\":{\"accountNumber\":\"" + tokenize("12345678") + "\",\"currency\":\"GBP\"}}"
+	if got := sink.batches[0][0].Message; got != want {
+		t.Errorf("logged %s, want %s", got, want)
+	}
+	if strings.Contains(sink.batches[0][0].Message, "12345678") {
+		t.Error("the account number was logged")
+	}
+}
+
+func Test_requestHasCard(t *testing.T) {
+	tests := []struct {
+		name    string
+		request Request
+		want    bool
+	}{
+		{"account", Request{Body: `{"accountNumber": "12345678"}`}, false},
+		{"card", Request{Body: `{"accountNumber": "4111111111111111"}`}, true},
+		{"spaced", Request{Body: `{"accountNumber": "4111 1111 1111 1111"}`}, true},
+		{"dashed in text", Request{Body: `{"query": "{ validate(accountNumber: \"12345678 4111-1111-1111-1111\") }"}`}, true},
+		{"query", Request{QueryStringParameters: map[string]string{"accountNumber": "4111111111111111"}}, true},
+		{"failsLuhn", Request{Body: `{"accountNumber": "4111111111111112"}`}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestHasCard(tt.request); got != tt.want {
+				t.Errorf("requestHasCard() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_logRequestBody_card(t *testing.T) {
+	sink := &fakeLogSink{}
+	logger := newBodyLogger(sink, func(ctx context.Context) (bodyLoggingFlag, error) { return bodyLoggingFlag{Enabled: true}, nil }, time.Minute)
+	logger.reload()
+	config := &Config{bodyLogger: logger}
+	config.logRequestBody(context.Background(), Request{HTTPMethod: "POST", Path: "/application", Body: `{"accountNumber": "4111 1111 1111 1111"}`})
+	logger.flush(context.Background())
+	if len(sink.batches) != 1 || !strings.Contains(sink.batches[0][0].Message, "\"redacted\":\"card number\"") || strings.Contains(sink.batches[0][0].Message, "body") {
+		t.Errorf("logged %v, want the body redacted", sink.batches)
+	}
+}
+
+func Test_bodyLogger_flagErrors(t *testing.T) {
+	var err error
+	logger := newBodyLogger(&fakeLogSink{}, func(ctx context.Context) (bodyLoggingFlag, error) {
+		return bodyLoggingFlag{Enabled: true}, err
+	}, time.Minute)
+	// Off until the flag has been read
+	if logger.flag.on(time.Now()) {
+		t.Error("on before the flag was read")
+	}
+	logger.reload()
+	err = errors.New("extension not running")
+	logger.reload()
+	if !logger.flag.on(time.Now()) {
+		t.Error("a failed read switched logging off")
+	}
+}
+
+func Test_bodyLogger_flushBatches(t *testing.T) {
+	sink := &fakeLogSink{}
+	logger := newBodyLogger(sink, nil, time.Minute)
+	body := strings.Repeat("a", 300000)
+	for i := 0; i < 5; i++ {
+		logger.log(bodyLogEntry{At: time.Unix(int64(5-i), 0), Body: body})
+	}
+	logger.log(bodyLogEntry{At: time.Unix(0, 0), Body: strings.Repeat("a", maxLogBatchBytes)})
+	logger.flush(context.Background())
+
+	// 3 fit in a batch, the one that's too big alone is dropped
+	if len(sink.batches) != 2 || len(sink.batches[0]) != 3 || len(sink.batches[1]) != 2 {
+		t.Fatalf("flushed %d batches, want 3 then 2 entries", len(sink.batches))
+	}
+	if sink.batches[0][0].Timestamp > sink.batches[0][1].Timestamp {
+		t.Error("entries weren't put oldest first")
+	}
+}
+
+func Test_fetchBodyLoggingFlag(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/applications/accountvalidator/environments/dev/configurations/flags" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("{\"requestBodyLogging\": {\"enabled\": true, \"until\": \"2024-06-02T00:00:00Z\"}, \"other\": {\"enabled\": false}}"))
+	}))
+	defer server.Close()
+
+	flag, err := fetchBodyLoggingFlag(context.Background(), server.Client(), server.URL+"/applications/accountvalidator/environments/dev/configurations/flags")
+	if err != nil || !flag.Enabled || flag.Until == nil || !flag.Until.Equal(time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("fetchBodyLoggingFlag() = %+v, %v", flag, err)
+	}
+	if _, err := fetchBodyLoggingFlag(context.Background(), server.Client(), server.URL+"/missing"); err == nil {
+		t.Error("fetchBodyLoggingFlag() didn't fail on a 404")
+	}
+}
+
+func Test_cloudWatchLogs_PutLogEvents(t *testing.T) {
+	targets := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets = append(targets, r.Header.Get("X-Amz-Target"))
+		if !strings.Contains(r.Header.Get("Authorization"), "eu-west-1/logs/aws4_request") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		// The stream is already there, from an attempt that timed out
+		if r.Header.Get("X-Amz-Target") == "Logs_20140328.CreateLogStream" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "ResourceAlreadyExistsException", "message": "The specified log stream already exists"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	logs := &cloudWatchLogs{api: testAWSClient("logs"), endpoint: server.URL, group: "bodies", stream: "2024/06/01/abc"}
+
+	for i := 0; i < 2; i++ {
+		if err := logs.PutLogEvents(context.Background(), []logEvent{{Timestamp: 1717232400000, Message: "{}"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"Logs_20140328.CreateLogStream", "Logs_20140328.PutLogEvents", "Logs_20140328.PutLogEvents"}
+	if strings.Join(targets, " ") != strings.Join(want, " ") {
+		t.Errorf("targets = %v, want %v", targets, want)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+  Per provider circuit breaker. After FailureThreshold consecutive errors (timeouts, connection failures, garbage
+  responses - not "invalid" verdicts) the breaker opens and we stop calling the provider for Cooldown. After that
+  a single trial call is let through; if it works the breaker closes again, otherwise it re-opens.
+
+  Breakers live for the life of the container, so each warm Lambda learns about outages on its own. The scheduled
+  probe (probe.go) gives cold containers a head start. On call can force a breaker open or closed in every
+  container, see breakercontrols.go.
+*/
+
+const ProviderErrorCircuitOpen = "circuit_open"
+
+type BreakerConfig struct {
+	FailureThreshold int `yaml:"failureThreshold"`
+	CooldownSeconds  int `yaml:"cooldownSeconds"`
+}
+
+const (
+	defaultFailureThreshold = 5
+	defaultCooldown         = 30 * time.Second
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type circuitBreaker struct {
+	mu sync.Mutex
+	// For forced states, see breakercontrols.go
+	provider         string
+	state            breakerState
+	failures         int
+	openedAt         time.Time
+	failureThreshold int
+	cooldown         time.Duration
+	now              func() time.Time
+}
+
+func newCircuitBreaker(config BreakerConfig) *circuitBreaker {
+	breaker := &circuitBreaker{
+		failureThreshold: config.FailureThreshold,
+		cooldown:         time.Duration(config.CooldownSeconds) * time.Second,
+		now:              time.Now,
+	}
+	if breaker.failureThreshold <= 0 {
+		breaker.failureThreshold = defaultFailureThreshold
+	}
+	if breaker.cooldown <= 0 {
+		breaker.cooldown = defaultCooldown
+	}
+	return breaker
+}
+
+// Whether we should call the provider. A nil breaker always allows the call.
+func (breaker *circuitBreaker) allow() bool {
+	return breaker.allowFor(context.Background())
+}
+
+// Whether we should call the provider for the request, which sees forced breakers still on canary when it's on the
+// canary, see configcanary.go
+func (breaker *circuitBreaker) allowFor(ctx context.Context) bool {
+	if breaker == nil {
+		return true
+	}
+	if forced := forcedBreakers.valueFor(ctx, breaker.provider); forced != "" {
+		return forced == breakerForcedClosed
+	}
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	switch breaker.state {
+	case breakerOpen:
+		if breaker.now().Sub(breaker.openedAt) < breaker.cooldown {
+			return false
+		}
+		// Let one trial call through
+		breaker.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// Trial call already in flight
+		return false
+	}
+	return true
+}
+
+// Records the outcome of a call we made
+func (breaker *circuitBreaker) record(success bool) {
+	if breaker == nil {
+		return
+	}
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if success {
+		breaker.state = breakerClosed
+		breaker.failures = 0
+		return
+	}
+	breaker.failures++
+	if breaker.state == breakerHalfOpen || breaker.failures >= breaker.failureThreshold {
+		breaker.state = breakerOpen
+		breaker.openedAt = breaker.now()
+	}
+}
+
+// Opens the breaker as if it had tripped at the given time, used to seed state from the probe table
+func (breaker *circuitBreaker) openSince(at time.Time) {
+	if breaker == nil {
+		return
+	}
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.state = breakerOpen
+	breaker.failures = breaker.failureThreshold
+	breaker.openedAt = at
+}
+
+// Whether allow would let a call through right now, without starting a trial call
+func (breaker *circuitBreaker) wouldAllow() bool {
+	return breaker.wouldAllowFor(context.Background())
+}
+
+func (breaker *circuitBreaker) wouldAllowFor(ctx context.Context) bool {
+	if breaker == nil {
+		return true
+	}
+	if forced := forcedBreakers.valueFor(ctx, breaker.provider); forced != "" {
+		return forced == breakerForcedClosed
+	}
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	switch breaker.state {
+	case breakerOpen:
+		return breaker.now().Sub(breaker.openedAt) >= breaker.cooldown
+	case breakerHalfOpen:
+		return false
+	}
+	return true
+}
+
+// The breaker's state for the operators, a nil breaker is always closed
+func (breaker *circuitBreaker) stateName() string {
+	if breaker == nil {
+		return "closed"
+	}
+	switch forcedBreakers.get(breaker.provider) {
+	case breakerForcedOpen:
+		return "forcedOpen"
+	case breakerForcedClosed:
+		return "forcedClosed"
+	}
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	switch breaker.state {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "halfOpen"
+	}
+	return "closed"
+}
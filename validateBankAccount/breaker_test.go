@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestBreaker(threshold int, cooldown time.Duration) (*circuitBreaker, *time.Time) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	breaker := newCircuitBreaker(BreakerConfig{FailureThreshold: threshold, CooldownSeconds: int(cooldown.Seconds())})
+	breaker.now = func() time.Time { return now }
+	return breaker, &now
+}
+
+func Test_circuitBreaker(t *testing.T) {
+	breaker, now := newTestBreaker(2, 30*time.Second)
+
+	breaker.record(false)
+	if !breaker.allow() {
+		t.Fatalf("breaker opened before the threshold")
+	}
+	breaker.record(false)
+	if breaker.allow() {
+		t.Fatalf("breaker should be open after 2 failures")
+	}
+
+	*now = now.Add(31 * time.Second)
+	if !breaker.allow() {
+		t.Fatalf("breaker should allow a trial call after the cooldown")
+	}
+	if breaker.allow() {
+		t.Fatalf("breaker should only allow one trial call")
+	}
+	breaker.record(false)
+	if breaker.allow() {
+		t.Fatalf("failed trial call should re-open the breaker")
+	}
+
+	*now = now.Add(31 * time.Second)
+	breaker.allow()
+	breaker.record(true)
+	if !breaker.allow() || breaker.state != breakerClosed {
+		t.Fatalf("successful trial call should close the breaker")
+	}
+}
+
+func Test_circuitBreaker_successResetsFailures(t *testing.T) {
+	breaker, _ := newTestBreaker(2, 30*time.Second)
+	breaker.record(false)
+	breaker.record(true)
+	breaker.record(false)
+	if !breaker.allow() {
+		t.Errorf("failures should be consecutive")
+	}
+}
+
+func Test_circuitBreaker_openSince(t *testing.T) {
+	breaker, now := newTestBreaker(5, 30*time.Second)
+	breaker.openSince(now.Add(-20 * time.Second))
+	if breaker.allow() {
+		t.Fatalf("seeded breaker should be open")
+	}
+	*now = now.Add(11 * time.Second)
+	if !breaker.allow() {
+		t.Errorf("seeded breaker should allow a trial once the cooldown from the probe time has passed")
+	}
+}
+
+func Test_circuitBreaker_nil(t *testing.T) {
+	var breaker *circuitBreaker
+	breaker.record(false)
+	breaker.openSince(time.Now())
+	if !breaker.allow() {
+		t.Errorf("nil breaker should always allow")
+	}
+}
+
+func Test_circuitBreaker_defaults(t *testing.T) {
+	breaker := newCircuitBreaker(BreakerConfig{})
+	if breaker.failureThreshold != defaultFailureThreshold || breaker.cooldown != defaultCooldown {
+		t.Errorf("newCircuitBreaker() = %v/%v, want defaults", breaker.failureThreshold, breaker.cooldown)
+	}
+}
+
+func Test_circuitBreaker_concurrent(t *testing.T) {
+	breaker := newCircuitBreaker(BreakerConfig{FailureThreshold: 1000})
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			breaker.allow()
+			breaker.record(i%2 == 0)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func Test_checkProviders_circuitOpen(t *testing.T) {
+	breaker, _ := newTestBreaker(1, time.Minute)
+	breaker.record(false)
+	got := checkProviders(context.Background(), "12345678", []Provider{{Name: "provider1", URL: "http://127.0.0.1:0", breaker: breaker}})
+	want := []BankAccountValidationResult{{Provider: "provider1", Error: ProviderErrorCircuitOpen}}
+	if len(got.Result) != 1 || got.Result[0] != want[0] {
+		t.Errorf("checkProviders() = %v, want %v", got.Result, want)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+/*
+  Manual circuit breaker controls, for failing over on purpose when a partner has planned maintenance:
+
+    POST   /admin/providers/provider2/circuit/open    # never called until it's released
+    POST   /admin/providers/provider2/circuit/close   # always called, whatever its errors
+    DELETE /admin/providers/provider2/circuit         # back to the breaker deciding
+    GET    /admin/circuits                            # every provider's breaker in this container
+
+  They're admin routes (see admin.go). A forced state is a runtime override (see runtimeoverrides.go) in the table in
+  BREAKER_TABLE, so every container has it within seconds. While it's forced the breaker still counts errors, so
+  when it's released it carries on from what it's seen. A forced open breaker answers circuit_open like a tripped
+  one does, and /providers and /admin/circuits show forcedOpen or forcedClosed.
+*/
+
+const (
+	breakerForcedOpen   = "open"
+	breakerForcedClosed = "closed"
+)
+
+// Set up at init by setupBreakerControls when there's a breaker table, nil means nothing's ever forced
+var forcedBreakers *runtimeOverrides
+
+func (config *Config) setupBreakerControls(ctx context.Context) {
+	forcedBreakers = setupRuntimeOverrides(ctx, OverrideKindBreakers, "BREAKER_TABLE")
+}
+
+type CircuitState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+}
+
+// The breaker's consecutive failures
+func (breaker *circuitBreaker) failureCount() int {
+	if breaker == nil {
+		return 0
+	}
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	return breaker.failures
+}
+
+// Handler for GET /admin/circuits
+func (config *Config) CircuitsHandler(ctx context.Context, request Request) (Response, error) {
+	states := []CircuitState{}
+	for _, provider := range config.Providers {
+		states = append(states, CircuitState{Provider: provider.Name, State: provider.breaker.stateName(), Failures: provider.breaker.failureCount()})
+	}
+	return jsonResponse(http.StatusOK, states)
+}
+
+// Handler for POST /admin/providers/{name}/circuit/open
+func (config *Config) OpenCircuitHandler(ctx context.Context, request Request) (Response, error) {
+	return config.forceCircuit(ctx, request, breakerForcedOpen)
+}
+
+// Handler for POST /admin/providers/{name}/circuit/close
+func (config *Config) CloseCircuitHandler(ctx context.Context, request Request) (Response, error) {
+	return config.forceCircuit(ctx, request, breakerForcedClosed)
+}
+
+// Handler for DELETE /admin/providers/{name}/circuit
+func (config *Config) ReleaseCircuitHandler(ctx context.Context, request Request) (Response, error) {
+	return config.forceCircuit(ctx, request, "")
+}
+
+func (config *Config) forceCircuit(ctx context.Context, request Request, state string) (Response, error) {
+	provider, response := config.pathProvider(request)
+	if response != nil {
+		return *response, nil
+	}
+	if forcedBreakers == nil {
+		return jsonResponse(http.StatusServiceUnavailable, map[string]string{"error": "circuit breaker controls aren't set up"})
+	}
+	if err := forcedBreakers.set(ctx, provider.Name, state, adminCallerFrom(ctx), ""); err != nil {
+		return *handleError(err, "unable to save the circuit breaker state"), nil
+	}
+	if state == "" {
+		log.Printf("provider %s circuit released by %s", provider.Name, adminCallerFrom(ctx))
+	} else {
+		log.Printf("provider %s circuit forced %s by %s", provider.Name, state, adminCallerFrom(ctx))
+	}
+	return jsonResponse(http.StatusOK, CircuitState{Provider: provider.Name, State: provider.breaker.stateName(), Failures: provider.breaker.failureCount()})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConfig_Router_forceCircuit(t *testing.T) {
+	store := newFakeOverrides(map[string]string{})
+	forcedBreakers = freshOverrides(store)
+	defer func() { forcedBreakers = nil }()
+	config := &Config{
+		Providers: []Provider{
+			{Name: "provider1", Type: ProviderTypeSimulated},
+			{Name: "provider2", Type: "rest", URL: "http://127.0.0.1:1/validate"},
+		},
+		Admin:      &AdminConfig{Keys: []*SigningKey{{ID: "oncall", Secret: "admin-secret"}}},
+		adminAudit: &bytes.Buffer{},
+	}
+	config.setupProviders()
+	admin := func(method, path string) Response {
+		response, err := config.Router(context.Background(), adminRequest(method, path, "", "admin-secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	provider2 := config.Providers[1]
+
+	if got := admin("POST", "/admin/providers/provider2/circuit/open"); got.StatusCode != 200 || got.Body != `{"provider":"provider2","state":"forcedOpen","failures":0}` {
+		t.Errorf("open = %d %s", got.StatusCode, got.Body)
+	}
+	if provider2.breaker.allow() || provider2.breaker.wouldAllow() {
+		t.Errorf("a forced open breaker let a call through")
+	}
+	results := checkProviders(context.Background(), "12345670", []Provider{provider2})
+	if results.Result[0].Error != ProviderErrorCircuitOpen {
+		t.Errorf("checkProviders() = %+v, want circuit_open", results.Result)
+	}
+
+	// Forced closed it's called whatever its errors, which are still counted
+	provider2.breaker.openSince(time.Now())
+	if got := admin("POST", "/admin/providers/provider2/circuit/close"); got.StatusCode != 200 || !provider2.breaker.allow() {
+		t.Errorf("close = %d %s", got.StatusCode, got.Body)
+	}
+	if got := admin("GET", "/admin/circuits"); got.Body != `[{"provider":"provider1","state":"closed","failures":0},{"provider":"provider2","state":"forcedClosed","failures":5}]` {
+		t.Errorf("circuits = %s", got.Body)
+	}
+
+	// Released, the breaker's own state is back
+	if got := admin("DELETE", "/admin/providers/provider2/circuit"); got.StatusCode != 200 || got.Body != `{"provider":"provider2","state":"open","failures":5}` || len(store.values) != 0 {
+		t.Errorf("release = %d %s, stored %v", got.StatusCode, got.Body, store.values)
+	}
+	if got := admin("POST", "/admin/providers/provider9/circuit/open"); got.StatusCode != 404 {
+		t.Errorf("unknown provider = %d %s, want 404", got.StatusCode, got.Body)
+	}
+}
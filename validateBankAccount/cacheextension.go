@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+/*
+  Secrets through the cache extension. When the function has the extension in cmd/cacheextension as a layer and
+  CACHE_EXTENSION_PORT set, secrets come from it over localhost instead of from Secrets Manager, so a cold start
+  costs a local call rather than a round trip per secret:
+
+    environment:
+      CACHE_EXTENSION_PORT: "2775"
+
+  The secret cache in secrets.go still sits in front of it. Its rotation recheck asks the extension which version it
+  has, which is as current as the extension's own refresh (CACHE_TTL_SECONDS), rather than calling DescribeSecret.
+*/
+
+const cacheExtensionTimeout = time.Second
+
+type extensionSecrets struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func newExtensionSecrets(port string) *extensionSecrets {
+	return &extensionSecrets{
+		base:   "http://localhost:" + port,
+		token:  os.Getenv("AWS_SESSION_TOKEN"),
+		client: &http.Client{Timeout: cacheExtensionTimeout},
+	}
+}
+
+func (extension *extensionSecrets) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", extension.base+"/secretsmanager/get?secretId="+url.QueryEscape(aws.ToString(params.SecretId)), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("X-Aws-Parameters-Secrets-Token", extension.token)
+	response, err := extension.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("cache extension: %s %s", response.Status, body)
+	}
+	var output secretsmanager.GetSecretValueOutput
+	if err := json.Unmarshal(body, &output); err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+// The version the extension has is the current one as far as we're concerned
+func (extension *extensionSecrets) DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+	output, err := extension.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: params.SecretId})
+	if err != nil {
+		return nil, err
+	}
+	return &secretsmanager.DescribeSecretOutput{
+		VersionIdsToStages: map[string][]string{aws.ToString(output.VersionId): {currentVersionStage}},
+	}, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+func TestExtensionSecrets(t *testing.T) {
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+	extension := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Aws-Parameters-Secrets-Token") != "session" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/secretsmanager/get" || r.URL.Query().Get("secretId") != "accountvalidator/provider2" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"Name": "accountvalidator/provider2", "SecretString": "{\"hmacKey\": \"s3cret\"}", "VersionId": "v2", "VersionStages": ["AWSCURRENT"]}`))
+	}))
+	defer extension.Close()
+
+	client := newExtensionSecrets(extension.URL[strings.LastIndex(extension.URL, ":")+1:])
+	cache := newSecretCache(client, SecretsConfig{})
+	value, err := cache.get(context.Background(), "accountvalidator/provider2")
+	if err != nil || value != "{\"hmacKey\": \"s3cret\"}" {
+		t.Fatalf("get() = %q, %v", value, err)
+	}
+	described, err := client.DescribeSecret(context.Background(), &secretsmanager.DescribeSecretInput{SecretId: aws.String("accountvalidator/provider2")})
+	if err != nil || currentVersion(described.VersionIdsToStages) != "v2" {
+		t.Errorf("DescribeSecret() = %+v, %v, want v2 current", described, err)
+	}
+	if _, err := client.GetSecretValue(context.Background(), &secretsmanager.GetSecretValueInput{SecretId: aws.String("accountvalidator/other")}); err == nil {
+		t.Errorf("GetSecretValue() for a secret the extension couldn't fetch succeeded")
+	}
+}
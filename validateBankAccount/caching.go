@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+/*
+  Response caching. A validation is idempotent for a while, so a client can hold on to the answer and re-validate
+  cheaply. Validations are only ever POSTed: API Gateway's stage cache keys on the query string but never the body,
+  and an account number in a URL ends up in access logs, CDN and proxy logs and browser history, so there's no GET
+  form to stage cache. A GET to the validate route is turned away (see decodeRequest).
+
+  With caching on, definitive responses get a Cache-Control with the max age. Responses where any provider failed to
+  answer get no-store so a blip isn't cached for the whole max age. Each response has a cache key, a hash of the
+  request's fields and the response profile. With response filters or templates (see responsefilters.go and
+  responsetemplates.go) the body also depends on who's calling, so the caller's identity goes into the hash too. The
+  fields include the account number, so the hash is keyed like account hashes are (see accounthash.go), and the key
+  itself is never sent back.
+
+    caching:
+      maxAgeSeconds: 300   # 0 or missing for no caching headers
+
+  With caching on, responses also carry an ETag, a hash of the cache key and the results and aggregate, so it only
+  changes when the verdict does. A client that re-validates with If-None-Match gets a 304 with no body when nothing
+  changed, which saves mobile clients the download. If this container handed out that ETag for the same request within
+  the max age, and every provider answered, the 304 comes straight back without calling the providers. Otherwise we
+  validate as usual and still answer 304 if the new ETag matches. Each container keeps its own ETags, so a request
+  landing on a new one just costs a validation.
+*/
+
+type CachingConfig struct {
+	MaxAgeSeconds int `yaml:"maxAgeSeconds"`
+}
+
+// Bounds the ETags a container remembers
+const maxETags = 10000
+
+// The ETags handed out recently, keyed on the cache key, in the store's cache (see store.go)
+type etagCache struct {
+	backend CacheStore
+	maxAge  time.Duration
+	now     func() time.Time
+}
+
+// Keyed hash of everything the response body depends on, the caller is empty when responses don't depend on who asked
+func cacheKey(validationRequest *BankAccountValidationRequest, profile, caller string) string {
+	fields, _ := marshalJSON(validationRequest)
+	return keyedHash(append(append(append(append(fields, 0), profile...), 0), caller...))
+}
+
+// The caller as far as the response body is concerned, nobody in particular unless filters or templates tailor it
+func (config *Config) cacheCaller(request Request) string {
+	if config.responseFields == nil && config.responseTemplates == nil {
+		return ""
+	}
+	return callerIdentity(request)
+}
+
+// The caching headers for a response under its cache key, none when caching is off
+func (caching CachingConfig) headers(key string, response BankAccountValidationResponse) map[string]string {
+	if caching.MaxAgeSeconds <= 0 {
+		return nil
+	}
+	headers := map[string]string{
+		"Cache-Control": fmt.Sprintf("private, max-age=%d", caching.MaxAgeSeconds),
+		"ETag":          responseETag(key, response),
+	}
+	for _, result := range response.Result {
+		if result.Error != "" || response.Partial {
+			headers["Cache-Control"] = "no-store"
+		}
+	}
+	return headers
+}
+
+// Quoted hash of the cache key and the verdict, the metadata and call ids aren't part of it
+func responseETag(key string, response BankAccountValidationResponse) string {
+	results := make([]BankAccountValidationResult, len(response.Result))
+	for i, result := range response.Result {
+		result.CallID = ""
+		results[i] = result
+	}
+	verdict, _ := marshalJSON(struct {
+		Result    []BankAccountValidationResult `json:"result"`
+		Aggregate *AggregateResult              `json:"aggregate"`
+	}{results, response.Aggregate})
+	hash := sha256.Sum256(append([]byte(key+"\x00"), verdict...))
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// Whether an If-None-Match header names the ETag, weak or not
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || (candidate != "" && candidate == etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Remembers ETags when caching is on
+func (config *Config) setupCaching() {
+	if config.Caching.MaxAgeSeconds > 0 {
+		config.etags = newETagCache(time.Duration(config.Caching.MaxAgeSeconds)*time.Second, config.store)
+	}
+}
+
+// A nil backend keeps the ETags in memory
+func newETagCache(maxAge time.Duration, backend CacheStore) *etagCache {
+	if backend == nil {
+		backend = newMemoryETags()
+	}
+	return &etagCache{backend: backend, maxAge: maxAge, now: time.Now}
+}
+
+// The ETag handed out for the key if it's still fresh and matches the If-None-Match header. A nil cache never has
+// one, and nor does one the store can't answer.
+func (cache *etagCache) fresh(ctx context.Context, key, ifNoneMatch string) (string, bool) {
+	if cache == nil || ifNoneMatch == "" {
+		return "", false
+	}
+	etag, err := cache.backend.ETag(ctx, key, cache.now())
+	if err != nil {
+		log.Printf("unable to look up the etag: %v", err)
+		return "", false
+	}
+	return etag, etag != "" && etagMatches(ifNoneMatch, etag)
+}
+
+// A 304 for a client that already has the response, with its caching headers but no body
+func notModified(headers map[string]string) Response {
+	kept := map[string]string{}
+	for name, value := range headers {
+		if name != "Content-Type" {
+			kept[name] = value
+		}
+	}
+	return Response{StatusCode: 304, Headers: kept}
+}
+
+// Remembers the ETag for the key for the max age
+func (cache *etagCache) store(ctx context.Context, key, etag string) {
+	if cache == nil {
+		return
+	}
+	now := cache.now()
+	if err := cache.backend.PutETag(ctx, key, etag, now, now.Add(cache.maxAge)); err != nil {
+		log.Printf("unable to store the etag: %v", err)
+	}
+}
+
+// Forgets every ETag, the next request for each is validated as usual
+func (cache *etagCache) clear(ctx context.Context) {
+	if cache == nil {
+		return
+	}
+	if err := cache.backend.ClearETags(ctx); err != nil {
+		log.Printf("unable to clear the etags: %v", err)
+	}
+}
+
+// Handler for DELETE /admin/cache, empties this container's ETags and secrets so they're fetched again. Other containers
+// keep theirs.
+func (config *Config) CacheHandler(ctx context.Context, request Request) (Response, error) {
+	config.etags.clear(ctx)
+	providerSecrets.clear()
+	log.Print("caches cleared")
+	return jsonResponse(200, map[string][]string{"cleared": {"etags", "secrets"}})
+}
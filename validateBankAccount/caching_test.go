@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_cacheKey(t *testing.T) {
+	account, other := "12345678", "87654321"
+	all := StrategyAll
+	some := []string{"provider1"}
+	base := cacheKey(&BankAccountValidationRequest{AccountNumber: &account}, ProfileV1, "")
+	if base != cacheKey(&BankAccountValidationRequest{AccountNumber: &account}, ProfileV1, "") {
+		t.Error("cacheKey() should be deterministic")
+	}
+	for name, key := range map[string]string{
+		"account":   cacheKey(&BankAccountValidationRequest{AccountNumber: &other}, ProfileV1, ""),
+		"providers": cacheKey(&BankAccountValidationRequest{AccountNumber: &account, Providers: &some}, ProfileV1, ""),
+		"strategy":  cacheKey(&BankAccountValidationRequest{AccountNumber: &account, Strategy: &all}, ProfileV1, ""),
+		"profile":   cacheKey(&BankAccountValidationRequest{AccountNumber: &account}, ProfileV2, ""),
+		"caller":    cacheKey(&BankAccountValidationRequest{AccountNumber: &account}, ProfileV1, "legacy-crm"),
+	} {
+		if key == base {
+			t.Errorf("changing the %s should change the key", name)
+		}
+	}
+
+	// Without the account hashing key the account number can't be worked back from the hash
+	defer func(key []byte) { accountHashKey = key }(accountHashKey)
+	accountHashKey = []byte("s3cret")
+	if cacheKey(&BankAccountValidationRequest{AccountNumber: &account}, ProfileV1, "") == base {
+		t.Error("cacheKey() should depend on the account hashing key")
+	}
+}
+
+func TestConfig_Handler_caching(t *testing.T) {
+	tests := []struct {
+		name        string
+		caching     CachingConfig
+		request     Request
+		wantControl string
+		wantETag    bool
+	}{
+		{
+			name:    "off",
+			request: Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\"}"},
+		},
+		{
+			name:        "post",
+			caching:     CachingConfig{MaxAgeSeconds: 300},
+			request:     Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\"}"},
+			wantControl: "private, max-age=300",
+			wantETag:    true,
+		},
+		{
+			name:        "providers",
+			caching:     CachingConfig{MaxAgeSeconds: 60},
+			request:     Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\", \"providers\": [\"provider1\"]}"},
+			wantControl: "private, max-age=60",
+			wantETag:    true,
+		},
+		{
+			name:        "providerFailed",
+			caching:     CachingConfig{MaxAgeSeconds: 60},
+			request:     Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\"}"},
+			wantControl: "no-store",
+			wantETag:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+				Caching:   tt.caching,
+			}
+			if tt.name == "providerFailed" {
+				config.Providers = append(config.Providers, Provider{Name: "down", URL: "http://127.0.0.1:1"})
+			}
+			response, err := config.Handler(context.Background(), tt.request)
+			if err != nil || response.StatusCode != 200 {
+				t.Fatalf("Handler() = %v, %v", response, err)
+			}
+			if got := response.Headers["Cache-Control"]; got != tt.wantControl {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.wantControl)
+			}
+			if _, got := response.Headers["ETag"]; got != tt.wantETag {
+				t.Errorf("ETag present = %v, want %v", got, tt.wantETag)
+			}
+			if _, got := response.Headers["X-Cache-Key"]; got {
+				t.Error("the cache key shouldn't be sent back")
+			}
+		})
+	}
+}
+
+func TestConfig_Handler_cachingPerCaller(t *testing.T) {
+	request := func(caller string) Request {
+		request := Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\"}"}
+		request.RequestContext.Authorizer = map[string]interface{}{"principalId": caller}
+		return request
+	}
+	keys := func(config *Config) (string, string) {
+		if err := config.compile(); err != nil {
+			t.Fatal(err)
+		}
+		config.setupCaching()
+		config.Handler(context.Background(), request("client-7"))
+		config.Handler(context.Background(), request("legacy-crm"))
+		stored := []string{}
+		for key := range config.etags.backend.(*memoryETags).entries {
+			stored = append(stored, key)
+		}
+		switch len(stored) {
+		case 0:
+			return "", ""
+		case 1:
+			return stored[0], stored[0]
+		}
+		return stored[0], stored[1]
+	}
+
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}, Caching: CachingConfig{MaxAgeSeconds: 60}}
+	if first, second := keys(config); first == "" || first != second {
+		t.Errorf("cache keys = %q, %q, want the same key for every caller", first, second)
+	}
+	config = &Config{
+		Providers:       []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		Caching:         CachingConfig{MaxAgeSeconds: 60},
+		ResponseFilters: []ResponseFilter{{Callers: []string{"legacy-crm"}, Fields: []string{"aggregate"}}},
+	}
+	if first, second := keys(config); first == "" || first == second {
+		t.Errorf("cache keys = %q, %q, want a key per caller with response filters", first, second)
+	}
+}
+
+func TestConfig_Router_get(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}}
+	request := Request{HTTPMethod: "GET", Path: "/application", QueryStringParameters: map[string]string{"accountNumber": "12345670"}}
+	response, _ := config.Router(context.Background(), request)
+	if response.StatusCode == 200 || !strings.Contains(response.Body, "the account number doesn't belong in the URL") {
+		t.Errorf("Router() = %d %s, want a GET turned away", response.StatusCode, response.Body)
+	}
+}
+
+func Test_etagMatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        bool
+	}{
+		{"empty", "", false},
+		{"same", `"abc"`, true},
+		{"different", `"abd"`, false},
+		{"list", `"x", "abc"`, true},
+		{"weak", `W/"abc"`, true},
+		{"any", "*", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.ifNoneMatch, `"abc"`); got != tt.want {
+				t.Errorf("etagMatches(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_etagCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ctx := context.Background()
+	cache := newETagCache(time.Minute, nil)
+	cache.now = func() time.Time { return now }
+	cache.store(ctx, "key", `"abc"`)
+	if etag, fresh := cache.fresh(ctx, "key", `"abc"`); !fresh || etag != `"abc"` {
+		t.Errorf("fresh() = %v, %v, want the stored etag", etag, fresh)
+	}
+	if _, fresh := cache.fresh(ctx, "key", `"abd"`); fresh {
+		t.Error("fresh() should not match a different etag")
+	}
+	if _, fresh := cache.fresh(ctx, "other", `"abc"`); fresh {
+		t.Error("fresh() should not match another key")
+	}
+	now = now.Add(time.Minute)
+	if _, fresh := cache.fresh(ctx, "key", `"abc"`); fresh {
+		t.Error("fresh() should not match once the max age has passed")
+	}
+	var none *etagCache
+	none.store(ctx, "key", `"abc"`)
+	if _, fresh := none.fresh(ctx, "key", `"abc"`); fresh {
+		t.Error("a nil cache should never be fresh")
+	}
+}
+
+func TestConfig_Handler_notModified(t *testing.T) {
+	var calls int32
+	var valid atomic.Bool
+	valid.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if valid.Load() {
+			w.Write([]byte(`{"isValid": true}`))
+		} else {
+			w.Write([]byte(`{"isValid": false}`))
+		}
+	}))
+	defer server.Close()
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", URL: server.URL}},
+		Caching:   CachingConfig{MaxAgeSeconds: 60},
+	}
+	config.setupCaching()
+	body := "{\"accountNumber\": \"12345678\"}"
+
+	first, err := config.Handler(context.Background(), Request{HTTPMethod: "POST", Body: body})
+	etag := first.Headers["ETag"]
+	if err != nil || first.StatusCode != 200 || etag == "" {
+		t.Fatalf("Handler() = %v, %v, want a 200 with an etag", first, err)
+	}
+
+	// The container handed out that etag, so the providers aren't called again
+	again := Request{HTTPMethod: "POST", Body: body, Headers: map[string]string{"if-none-match": etag}}
+	second, err := config.Handler(context.Background(), again)
+	if err != nil || second.StatusCode != 304 || second.Body != "" || second.Headers["ETag"] != etag {
+		t.Errorf("Handler() with a fresh etag = %v, %v, want an empty 304", second, err)
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("provider called %d times, want once", calls)
+	}
+
+	// A new container validates, and still says nothing changed
+	config.setupCaching()
+	third, err := config.Handler(context.Background(), again)
+	if err != nil || third.StatusCode != 304 || third.Headers["ETag"] != etag {
+		t.Errorf("Handler() with an unchanged verdict = %v, %v, want a 304", third, err)
+	}
+
+	// Once the verdict changes the client gets the new response
+	config.setupCaching()
+	valid.Store(false)
+	fourth, err := config.Handler(context.Background(), again)
+	if err != nil || fourth.StatusCode != 200 || fourth.Headers["ETag"] == etag {
+		t.Errorf("Handler() with a changed verdict = %v, %v, want a 200 with a new etag", fourth, err)
+	}
+}
+
+func TestConfig_CacheHandler(t *testing.T) {
+	config := &Config{etags: newETagCache(time.Minute, nil)}
+	config.etags.store(context.Background(), "key", "\"etag\"")
+	got, _ := config.CacheHandler(context.Background(), Request{HTTPMethod: "DELETE", Path: "/cache"})
+	if got.StatusCode != 200 || got.Body != `{"cleared":["etags","secrets"]}` {
+		t.Errorf("CacheHandler() = %d %s", got.StatusCode, got.Body)
+	}
+	if _, exists := config.etags.backend.(*memoryETags).entries["key"]; exists {
+		t.Errorf("the etag is still cached")
+	}
+}
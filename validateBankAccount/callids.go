@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+  Outbound call ids. When a partner disputes a lookup they want their own correlation id, not ours, so a provider
+  can be given a header to send a fresh id in on every call we make to it, retries included:
+
+    - name: provider1
+      url: https://provider1.com/v1/api/account/validate
+      callIdHeader: X-Correlation-Id
+
+  The id of the call that gave the answer goes on the provider's result as "callId" and into the audit record
+  (callIds, by provider), so it can be quoted back to them. Health probes don't send one.
+*/
+
+// A random (version 4) uuid
+func newCallID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return ""
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+func (provider Provider) validateCallIDHeader() error {
+	if provider.CallIDHeader == "" {
+		return nil
+	}
+	if strings.ContainsAny(provider.CallIDHeader, " \t\r\n:") {
+		return fmt.Errorf("provider %s has an invalid callIdHeader %q", provider.Name, provider.CallIDHeader)
+	}
+	if reservedHeaders[http.CanonicalHeaderKey(provider.CallIDHeader)] {
+		return fmt.Errorf("provider %s can't send its call id in %s", provider.Name, http.CanonicalHeaderKey(provider.CallIDHeader))
+	}
+	return nil
+}
+
+// Sends a new call id when the provider wants one, returning it
+func (provider Provider) setCallID(request *http.Request) string {
+	if provider.CallIDHeader == "" {
+		return ""
+	}
+	id := newCallID()
+	request.Header.Set(provider.CallIDHeader, id)
+	return id
+}
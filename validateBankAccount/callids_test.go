@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+func Test_checkProvider_callIDs(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-Correlation-Id"))
+		if len(received) == 1 {
+			// Drop the first attempt so it's retried
+			connection, _, _ := w.(http.Hijacker).Hijack()
+			connection.Close()
+			return
+		}
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer server.Close()
+
+	provider := Provider{Name: "provider1", URL: server.URL, Retries: 1, CallIDHeader: "X-Correlation-Id"}
+	c := make(chan BankAccountValidationResult, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	checkProvider(context.Background(), "12345678", provider, c, &wg)
+	result := <-c
+
+	if result.Error != "" || len(received) != 2 {
+		t.Fatalf("checkProvider() = %+v after %d calls, want an answer after 2", result, len(received))
+	}
+	if received[0] == received[1] {
+		t.Errorf("retry sent the same call id %s", received[0])
+	}
+	if result.CallID != received[1] {
+		t.Errorf("callId = %s, want %s", result.CallID, received[1])
+	}
+}
+
+func Test_callProvider_noCallID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer server.Close()
+
+	provider := Provider{Name: "provider1", URL: server.URL}
+	if result := callProvider(context.Background(), "12345678", provider, provider.URL); result.CallID != "" {
+		t.Errorf("callId = %s, want none", result.CallID)
+	}
+}
+
+func Test_newCallID(t *testing.T) {
+	uuid := regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$")
+	first, second := newCallID(), newCallID()
+	if !uuid.MatchString(first) || first == second {
+		t.Errorf("newCallID() = %s then %s, want different v4 uuids", first, second)
+	}
+}
+
+func Test_responseETag_ignoresCallIDs(t *testing.T) {
+	first := BankAccountValidationResponse{Result: []BankAccountValidationResult{{Provider: "provider1", IsValid: true, CallID: newCallID()}}}
+	second := BankAccountValidationResponse{Result: []BankAccountValidationResult{{Provider: "provider1", IsValid: true, CallID: newCallID()}}}
+	if responseETag("12345678", first) != responseETag("12345678", second) {
+		t.Error("responseETag() changed with the call ids")
+	}
+	if first.Result[0].CallID == "" {
+		t.Error("responseETag() cleared the response's call id")
+	}
+}
+
+func TestProvider_validateCallIDHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		wantErr  bool
+	}{
+		{name: "none", provider: Provider{Name: "provider1"}},
+		{name: "valid", provider: Provider{Name: "provider1", CallIDHeader: "X-Correlation-Id"}},
+		{name: "badName", provider: Provider{Name: "provider1", CallIDHeader: "X Correlation"}, wantErr: true},
+		{name: "colon", provider: Provider{Name: "provider1", CallIDHeader: "X-Correlation-Id:"}, wantErr: true},
+		{name: "reserved", provider: Provider{Name: "provider1", CallIDHeader: "content-type"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.provider.validateCallIDHeader(); (err != nil) != tt.wantErr {
+				t.Errorf("validateCallIDHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
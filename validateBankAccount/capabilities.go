@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+  GET /capabilities describes what this deployment supports so client teams can feature detect rather than hard code
+  differences between environments. Request fields come straight off the request type so they can't drift; countries
+  are whatever the providers declare they cover, and tags are the ones requests can pick providers by:
+
+    - name: provider1
+      url: https://provider1.com/v1/api/account/validate
+      countries: [GB, IE]
+      tags: [uk]
+*/
+
+type Capabilities struct {
+	RequestFields []string          `json:"requestFields"`
+	Strategies    []string          `json:"strategies"`
+	Providers     []string          `json:"providers"`
+	Countries     []string          `json:"countries"`
+	Tags          []string          `json:"tags,omitempty"`
+	Priorities    []string          `json:"priorities,omitempty"`
+	Limits        CapabilityLimits  `json:"limits"`
+	Metadata      *ResponseMetadata `json:"metadata,omitempty"`
+}
+
+type CapabilityLimits struct {
+	MaxProviders      int   `json:"maxProviders"`
+	ProviderTimeoutMs int64 `json:"providerTimeoutMs"`
+}
+
+// The json names of every field a validation request can have
+func requestFields() []string {
+	fields := []string{}
+	requestType := reflect.TypeOf(BankAccountValidationRequest{})
+	for i := 0; i < requestType.NumField(); i++ {
+		name := strings.Split(requestType.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+func (config *Config) capabilities() Capabilities {
+	capabilities := Capabilities{
+		RequestFields: requestFields(),
+		Strategies:    strategies,
+		Providers:     []string{},
+		Countries:     []string{},
+		Limits: CapabilityLimits{
+			MaxProviders:      len(enabledProviders(config.Providers)),
+			ProviderTimeoutMs: config.longestTimeout().Milliseconds(),
+		},
+		Metadata: config.metadata,
+	}
+	if len(config.Priority.Lanes) > 0 {
+		capabilities.Priorities = config.Priority.names()
+	}
+	if max := config.FanOut.MaxProviders; max > 0 && max < capabilities.Limits.MaxProviders {
+		capabilities.Limits.MaxProviders = max
+	}
+	seen := map[string]bool{}
+	for _, provider := range enabledProviders(config.Providers) {
+		capabilities.Providers = append(capabilities.Providers, provider.Name)
+		for _, country := range provider.Countries {
+			country = strings.ToUpper(country)
+			if !seen[country] {
+				seen[country] = true
+				capabilities.Countries = append(capabilities.Countries, country)
+			}
+		}
+	}
+	sort.Strings(capabilities.Countries)
+	if tags := providerTags(enabledProviders(config.Providers)); len(tags) > 0 {
+		capabilities.Tags = tags
+	}
+	return capabilities
+}
+
+// Handler for GET /capabilities
+func (config *Config) CapabilitiesHandler(ctx context.Context, request Request) (Response, error) {
+	return jsonResponse(200, config.capabilities())
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestConfig_capabilities(t *testing.T) {
+	config := &Config{Providers: []Provider{
+		{Name: "provider1", Countries: []string{"gb", "IE"}},
+		{Name: "provider2", Countries: []string{"GB", "DE"}},
+	}}
+	want := Capabilities{
+		RequestFields: []string{"accountNumber", "providers", "strategy", "priority", "clientReference", "accountType", "currency", "bic", "country", "bankCode", "minProviders"},
+		Strategies:    []string{StrategyAny, StrategyAll, StrategyMajority, StrategySequential},
+		Providers:     []string{"provider1", "provider2"},
+		Countries:     []string{"DE", "GB", "IE"},
+		Limits:        CapabilityLimits{MaxProviders: 2, ProviderTimeoutMs: 1000},
+	}
+	if got := config.capabilities(); !reflect.DeepEqual(got, want) {
+		t.Errorf("capabilities() = %+v, want %+v", got, want)
+	}
+}
+
+func TestConfig_Router(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}}
+	tests := []struct {
+		name    string
+		request Request
+		want    string
+	}{
+		{name: "capabilities",
+			request: Request{HTTPMethod: "GET", Path: "/dev/capabilities"},
+			want:    "{\"requestFields\":[\"accountNumber\",\"providers\",\"strategy\",\"priority\",\"clientReference\",\"accountType\",\"currency\",\"bic\",\"country\",\"bankCode\",\"minProviders\"],\"strategies\":[\"any\",\"all\",\"majority\",\"sequential\"],\"providers\":[\"provider1\"],\"countries\":[],\"limits\":{\"maxProviders\":1,\"providerTimeoutMs\":1000}}",
+		},
+		{name: "validate",
+			request: Request{HTTPMethod: "POST", Path: "/application", Body: "{\"accountNumber\": \"12345670\", \"strategy\": \"all\"}"},
+			want:    "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}],\"aggregate\":{\"strategy\":\"all\",\"isValid\":true}}",
+		},
+		{name: "base64",
+			request: Request{HTTPMethod: "POST", Path: "/application", IsBase64Encoded: true, Body: "eyJhY2NvdW50TnVtYmVyIjogIjEyMzQ1NjcwIiwgInN0cmF0ZWd5IjogImFsbCJ9"},
+			want:    "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}],\"aggregate\":{\"strategy\":\"all\",\"isValid\":true}}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := config.Router(context.Background(), tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.StatusCode != 200 || got.Body != tt.want {
+				t.Errorf("Router() = %v %v, want 200 %v", got.StatusCode, got.Body, tt.want)
+			}
+		})
+	}
+}
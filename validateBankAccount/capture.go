@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+  Compliance capture. Regulated partners have to be able to show exactly what went over the wire for a lookup, years
+  after the fact, so with capture on the full outbound request and the response that came back (or the transport
+  error) for every provider call is written to S3, one object per call, keyed by the request id:
+
+    capture:
+      location: s3://accountvalidator-dev-exchange-capture/exchanges   # <location>/<requestId>/<provider>-<n>.json
+      redactHeaders: [X-Api-Key]   # Authorization and Proxy-Authorization always are
+
+    {"requestId": "c6af9ac6-...", "provider": "provider1", "attempt": 1, "callId": "0b7c...",
+     "startedAt": "2024-06-01T09:12:01.123Z", "durationMs": 212,
+     "request": {"method": "POST", "url": "https://provider1.com/v1/api/account/validate", "headers": {...}, "body": "..."},
+     "response": {"status": 200, "headers": {...}, "body": "{\"isValid\": true}"}}
+
+  The bucket (ExchangeCaptureBucket in serverless.yml) is KMS encrypted and has object lock in compliance mode, so an
+  object can't be changed or deleted by anyone until its retention runs out. That's why credentials are redacted on
+  the way in, there'd be no taking them back out. A body that isn't UTF-8 is kept as bodyBase64 instead.
+
+  Each attempt, retries included, is its own object. The puts happen alongside the provider calls and the response
+  waits for them, so capture costs roughly a put's latency. A put that fails is logged and counted
+  (CaptureFailures), it doesn't fail the validation. Test accounts, simulated and SEPA providers make no calls so
+  there's nothing to capture.
+*/
+
+const captureTimeout = 5 * time.Second
+
+type CaptureConfig struct {
+	Location      string   `yaml:"location"`
+	RedactHeaders []string `yaml:"redactHeaders"`
+}
+
+func (capture CaptureConfig) validate() error {
+	if capture.Location == "" {
+		return nil
+	}
+	if _, err := parseS3Location(capture.Location); err != nil {
+		return fmt.Errorf("capture location: %w", err)
+	}
+	return nil
+}
+
+// Whether the header's value is kept out of captures
+func (capture CaptureConfig) redacted(name string) bool {
+	if name == "Authorization" || name == "Proxy-Authorization" {
+		return true
+	}
+	for _, redacted := range capture.RedactHeaders {
+		if http.CanonicalHeaderKey(redacted) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (capture CaptureConfig) headers(headers http.Header) http.Header {
+	captured := http.Header{}
+	for name, values := range headers {
+		if capture.redacted(name) {
+			captured[name] = []string{"[redacted]"}
+		} else {
+			captured[name] = values
+		}
+	}
+	return captured
+}
+
+type providerExchange struct {
+	RequestID  string            `json:"requestId"`
+	Provider   string            `json:"provider"`
+	Attempt    int               `json:"attempt"`
+	CallID     string            `json:"callId,omitempty"`
+	StartedAt  time.Time         `json:"startedAt"`
+	DurationMs int64             `json:"durationMs"`
+	Request    capturedRequest   `json:"request"`
+	Response   *capturedResponse `json:"response,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+type capturedBody struct {
+	Body       string `json:"body,omitempty"`
+	BodyBase64 string `json:"bodyBase64,omitempty"`
+}
+
+type capturedRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	capturedBody
+}
+
+type capturedResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+	capturedBody
+}
+
+func captureBody(body []byte) capturedBody {
+	if utf8.Valid(body) {
+		return capturedBody{Body: string(body)}
+	}
+	return capturedBody{BodyBase64: base64.StdEncoding.EncodeToString(body)}
+}
+
+// The calls one validation makes, and the puts still in flight for them
+type exchangeCapture struct {
+	config    CaptureConfig
+	location  s3Location
+	objects   *s3Client
+	telemetry *telemetry
+	requestID string
+	mutex     sync.Mutex
+	attempts  map[string]int
+	pending   sync.WaitGroup
+}
+
+type captureKey struct{}
+
+// Captures the request's provider calls when capture is on
+func (config *Config) withCapture(ctx context.Context, request Request) context.Context {
+	if config.captureObjects == nil {
+		return ctx
+	}
+	location, _ := parseS3Location(config.Capture.Location)
+	id := requestID(ctx, request)
+	if id == "" {
+		// Running locally, keep the calls together anyway
+		id = newCallID()
+	}
+	return context.WithValue(ctx, captureKey{}, &exchangeCapture{
+		config:    config.Capture,
+		location:  location,
+		objects:   config.captureObjects,
+		telemetry: config.telemetry,
+		requestID: id,
+		attempts:  map[string]int{},
+	})
+}
+
+func captureFrom(ctx context.Context) *exchangeCapture {
+	capture, _ := ctx.Value(captureKey{}).(*exchangeCapture)
+	return capture
+}
+
+// The transport for the provider's calls, capturing them when there's a capture
+func (capture *exchangeCapture) transport(next http.RoundTripper, provider Provider) http.RoundTripper {
+	if capture == nil {
+		return next
+	}
+	return &captureTransport{next: next, capture: capture, provider: provider}
+}
+
+// Waits for the request's captures to be written
+func (capture *exchangeCapture) wait() {
+	if capture != nil {
+		capture.pending.Wait()
+	}
+}
+
+func (capture *exchangeCapture) nextAttempt(provider string) int {
+	capture.mutex.Lock()
+	defer capture.mutex.Unlock()
+	capture.attempts[provider]++
+	return capture.attempts[provider]
+}
+
+// Puts the exchange in the background, see wait
+func (capture *exchangeCapture) write(exchange providerExchange) {
+	capture.pending.Add(1)
+	go func() {
+		defer capture.pending.Done()
+		// Not the request's context, the put should finish even when the provider call was cut short
+		ctx, cancel := context.WithTimeout(context.Background(), captureTimeout)
+		defer cancel()
+		if err := capture.put(ctx, exchange); err != nil {
+			log.Printf("unable to capture the %s call for %s: %v", exchange.Provider, exchange.RequestID, err)
+			capture.telemetry.count("CaptureFailures", 1)
+		}
+	}()
+}
+
+func (capture *exchangeCapture) put(ctx context.Context, exchange providerExchange) error {
+	body, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+	location := capture.location
+	location.Key = strings.TrimSuffix(location.Key, "/") + "/" + exchange.RequestID + "/" + exchange.Provider + "-" + strconv.Itoa(exchange.Attempt) + ".json"
+	return capture.objects.putObject(ctx, location, body, "application/json")
+}
+
+type captureTransport struct {
+	next     http.RoundTripper
+	capture  *exchangeCapture
+	provider Provider
+}
+
+func (transport *captureTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	exchange := providerExchange{
+		RequestID: transport.capture.requestID,
+		Provider:  transport.provider.Name,
+		Attempt:   transport.capture.nextAttempt(transport.provider.Name),
+		StartedAt: time.Now().UTC(),
+		Request: capturedRequest{
+			Method:  request.Method,
+			URL:     request.URL.String(),
+			Headers: transport.capture.config.headers(request.Header),
+		},
+	}
+	if transport.provider.CallIDHeader != "" {
+		exchange.CallID = request.Header.Get(transport.provider.CallIDHeader)
+	}
+	if request.GetBody != nil {
+		if body, err := request.GetBody(); err == nil {
+			data, _ := io.ReadAll(body)
+			exchange.Request.capturedBody = captureBody(data)
+		}
+	}
+	defer func() {
+		exchange.DurationMs = time.Since(exchange.StartedAt).Milliseconds()
+		transport.capture.write(exchange)
+	}()
+
+	response, err := transport.next.RoundTrip(request)
+	if err != nil {
+		exchange.Error = err.Error()
+		return nil, err
+	}
+	// Read the body here so it's in the capture, the caller reads it from memory
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	exchange.Response = &capturedResponse{Status: response.StatusCode, Headers: transport.capture.config.headers(response.Header), capturedBody: captureBody(data)}
+	if err != nil {
+		exchange.Error = err.Error()
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(data))
+	return response, nil
+}
+
+// Connects to S3 for captures when capture is on
+func (config *Config) setupCapture(ctx context.Context) {
+	if config.Capture.Location == "" {
+		return
+	}
+	objects, err := newS3Client(ctx)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	config.captureObjects = objects
+}
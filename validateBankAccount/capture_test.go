@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// An S3 that keeps what's put in it by path
+func captureBucket(t *testing.T) (*s3Client, map[string][]byte) {
+	var mutex sync.Mutex
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.Header.Get("Content-Md5") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		objects[r.URL.Path] = body
+		mutex.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return testS3Client(server.URL), objects
+}
+
+func TestConfig_capture(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Provider-Ref", "abc")
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer provider.Close()
+	objects, captured := captureBucket(t)
+	config := &Config{
+		Providers: []Provider{{
+			Name:         "provider1",
+			URL:          provider.URL,
+			CallIDHeader: "X-Correlation-Id",
+			Headers:      map[string]string{"Authorization": "Bearer secret", "X-Api-Key": "key", "X-Partner-Id": "1234"},
+		}},
+		Capture:        CaptureConfig{Location: "s3://accountvalidator-dev-exchange-capture/exchanges", RedactHeaders: []string{"x-api-key"}},
+		captureObjects: objects,
+	}
+	request := Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345678\"}", RequestContext: events.APIGatewayProxyRequestContext{RequestID: "req-1"}}
+
+	response, err := config.Handler(context.Background(), request)
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("Handler() = %d %s, %v", response.StatusCode, response.Body, err)
+	}
+	// The response waits for the put, so it's there already
+	object, exists := captured["/accountvalidator-dev-exchange-capture/exchanges/req-1/provider1-1.json"]
+	if !exists {
+		t.Fatalf("nothing captured at /accountvalidator-dev-exchange-capture/exchanges/req-1/provider1-1.json, got %v", captured)
+	}
+	var exchange providerExchange
+	if err := json.Unmarshal(object, &exchange); err != nil {
+		t.Fatal(err)
+	}
+	if exchange.RequestID != "req-1" || exchange.Provider != "provider1" || exchange.Attempt != 1 || exchange.CallID == "" {
+		t.Errorf("captured %+v", exchange)
+	}
+	if exchange.Request.Method != "POST" || exchange.Request.URL != provider.URL || exchange.Request.Body != "{\"accountNumber\":\"12345678\"}" {
+		t.Errorf("captured request %+v", exchange.Request)
+	}
+	wantHeaders := map[string]string{"Authorization": "[redacted]", "X-Api-Key": "[redacted]", "X-Partner-Id": "1234", "X-Correlation-Id": exchange.CallID}
+	for name, value := range wantHeaders {
+		if got := exchange.Request.Headers.Get(name); got != value {
+			t.Errorf("captured header %s = %q, want %q", name, got, value)
+		}
+	}
+	if exchange.Response == nil || exchange.Response.Status != 200 || exchange.Response.Body != "{\"isValid\": true}" || exchange.Response.Headers.Get("X-Provider-Ref") != "abc" {
+		t.Errorf("captured response %+v", exchange.Response)
+	}
+}
+
+func Test_captureTransport_error(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := provider.URL
+	provider.Close()
+	objects, captured := captureBucket(t)
+	config := &Config{Capture: CaptureConfig{Location: "s3://accountvalidator-dev-exchange-capture/exchanges"}, captureObjects: objects}
+	ctx := config.withCapture(context.Background(), Request{RequestContext: events.APIGatewayProxyRequestContext{RequestID: "req-2"}})
+
+	result := callProvider(ctx, "12345678", Provider{Name: "provider1", URL: url}, url)
+	captureFrom(ctx).wait()
+	if result.Error != ProviderErrorRequest {
+		t.Fatalf("callProvider() error = %s, want %s", result.Error, ProviderErrorRequest)
+	}
+	var exchange providerExchange
+	if err := json.Unmarshal(captured["/accountvalidator-dev-exchange-capture/exchanges/req-2/provider1-1.json"], &exchange); err != nil {
+		t.Fatal(err)
+	}
+	if exchange.Error == "" || exchange.Response != nil {
+		t.Errorf("captured %+v, want the transport error and no response", exchange)
+	}
+}
+
+func Test_captureBody(t *testing.T) {
+	if got := captureBody([]byte("<ok/>")); got.Body != "<ok/>" || got.BodyBase64 != "" {
+		t.Errorf("captureBody() = %+v", got)
+	}
+	if got := captureBody([]byte{0xff, 0xfe}); got.Body != "" || got.BodyBase64 != "//4=" {
+		t.Errorf("captureBody() = %+v", got)
+	}
+}
+
+func TestCaptureConfig_validate(t *testing.T) {
+	if err := (CaptureConfig{Location: "accountvalidator-dev-exchange-capture"}).validate(); err == nil {
+		t.Error("validate() accepted a location that isn't s3://bucket/key")
+	}
+}
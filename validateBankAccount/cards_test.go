@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func Test_looksLikeCard(t *testing.T) {
+	tests := []struct {
+		name          string
+		accountNumber string
+		want          bool
+	}{
+		{"visa", "4111111111111111", true},
+		{"visaSpaced", "4111 1111 1111 1111", true},
+		{"mastercardDashed", "5500-0000-0000-0004", true},
+		{"mastercard2Series", "2223000048400011", true},
+		{"amex", "378282246310005", true},
+		{"discover", "6011111111111117", true},
+		{"luhnFails", "4111111111111112", false},
+		{"noIssuer", "9111111111111115", false},
+		{"amexWrongLength", "3782822463100005", false},
+		{"ukAccount", "31926819", false},
+		{"iban", "GB29NWBK60161331926819", false},
+		{"tooLong", "41111111111111110", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := looksLikeCard(tt.accountNumber); got != tt.want {
+				t.Errorf("looksLikeCard(%s) = %v, want %v", tt.accountNumber, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Handler_cardNumber(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", URL: "http://127.0.0.1:1/never-called"}}}
+	response, err := config.Handler(context.Background(), Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"4111 1111 1111 1111\"}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"error\":\"card_number_rejected\"}"; response.Body != want {
+		t.Errorf("Handler() body = %s, want %s", response.Body, want)
+	}
+	if strings.Contains(response.Body, "4111") {
+		t.Errorf("Handler() echoed the card number: %s", response.Body)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"unicode"
+)
+
+/*
+  Client references. A request can carry an opaque clientReference, usually the caller's own transaction id, and we
+  hand it back everywhere the validation shows up so callers can match them up without a lookup table of their own:
+
+    {"accountNumber": "12345678", "clientReference": "txn-0042"}
+    {"result": [...], "clientReference": "txn-0042"}
+
+  It's in the response in every profile and mode, the audit record (clientReference), the SNS result's message
+  attributes and the Kafka and websocket results, which carry the response. We never look inside it, but it's limited
+  to 128 printable characters so it can't be used to smuggle a payload into the audit table or a log line.
+*/
+
+const maxClientReferenceLength = 128
+
+func validateClientReference(reference string) error {
+	if len(reference) > maxClientReferenceLength {
+		return fmt.Errorf("clientReference is longer than %d characters", maxClientReferenceLength)
+	}
+	for _, r := range reference {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("clientReference should only have printable characters")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func Test_validateClientReference(t *testing.T) {
+	tests := []struct {
+		name      string
+		reference string
+		wantErr   bool
+	}{
+		{"empty", "", false},
+		{"transaction", "txn-0042/ü", false},
+		{"longest", strings.Repeat("a", maxClientReferenceLength), false},
+		{"tooLong", strings.Repeat("a", maxClientReferenceLength+1), true},
+		{"newline", "txn\n0042", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateClientReference(tt.reference); (err != nil) != tt.wantErr {
+				t.Errorf("validateClientReference() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_Handler_clientReference(t *testing.T) {
+	sink := &fakeAuditSink{}
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		telemetry: newTelemetry(sink, &bytes.Buffer{}),
+	}
+	tests := []struct {
+		name    string
+		request Request
+		want    string
+	}{
+		{
+			name:    "v1",
+			request: Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\", \"clientReference\": \"txn-1\"}"},
+			want:    "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}],\"clientReference\":\"txn-1\"}",
+		},
+		{
+			name: "v2",
+			request: Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\", \"clientReference\": \"txn-2\"}",
+				Headers: map[string]string{"Accept": "application/json; profile=v2"}},
+			want: "{\"version\":\"v2\",\"results\":[{\"provider\":\"provider1\",\"status\":\"valid\"}],\"summary\":{\"called\":1,\"answered\":1,\"valid\":1},\"clientReference\":\"txn-2\"}",
+		},
+		{
+			name:    "invalid",
+			request: Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\", \"clientReference\": \"txn\\u0000\"}"},
+			want:    "{\"error\":\"clientReference should only have printable characters\"}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, _ := config.Handler(context.Background(), tt.request)
+			if response.Body != tt.want {
+				t.Errorf("Handler() body = %v, want %v", response.Body, tt.want)
+			}
+		})
+	}
+
+	config.telemetry.flush(context.Background())
+	if len(sink.batches) != 1 || len(sink.batches[0]) != 2 {
+		t.Fatalf("expected one batch of 2 audit records, got %+v", sink.batches)
+	}
+	if got := sink.batches[0][0].ClientReference; got != "txn-1" {
+		t.Errorf("audit record clientReference = %q, want txn-1", got)
+	}
+}
+
+func TestConfig_SNSHandler_clientReference(t *testing.T) {
+	client := &fakeSNS{}
+	config := &Config{
+		Providers:  []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		snsResults: &snsResultPublisher{client: client, topicArn: "arn:aws:sns:eu-west-1:123456789012:results"},
+	}
+	event := events.SNSEvent{Records: []events.SNSEventRecord{{}, {}}}
+	event.Records[0].SNS.Message = "{\"accountNumber\": \"12345670\", \"clientReference\": \"txn-1\"}"
+	event.Records[1].SNS.Message = "{\"accountNumber\": \"12345670\"}"
+	if err := config.SNSHandler(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.published[0].MessageAttributes["clientReference"].StringValue; got == nil || *got != "txn-1" {
+		t.Errorf("clientReference attribute = %v, want txn-1", got)
+	}
+	if _, exists := client.published[1].MessageAttributes["clientReference"]; exists {
+		t.Error("a request without a clientReference shouldn't have the attribute")
+	}
+}
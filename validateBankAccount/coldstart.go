@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+  Cold starts. Whether provisioned concurrency is worth paying for comes down to how often callers wait for a
+  container to start and how long for, so init is timed by phase and the first request a container serves says it
+  was a cold start.
+
+  Init writes one JSON line on stdout, and the same numbers go out as metrics (InitDuration, InitConfigLoad,
+  InitSecretsFetch and InitWarmUp, in milliseconds) with the first flush:
+
+    {"init": {"initializationType": "on-demand", "totalMs": 182.4,
+              "phases": {"configLoad": 3.1, "secretsFetch": 141.7, "warmUp": 0}}}
+
+  configLoad is reading and compiling PROVIDERS, secretsFetch getting the providers' secrets and warmUp the warm path
+  (see warm.go), which only runs at init for provisioned concurrency. Whatever's left of totalMs is the rest of
+  setup. The first request after an on-demand init is counted in the ColdStarts metric and has "coldStart": true in
+  its timings line (see timings.go). A provisioned container was started before anyone was waiting, so it never has a
+  cold start, and a warmer that gets there first takes it. With
+
+    debug: true
+
+  the response metadata says so too, "metadata": {..., "coldStart": true}. It's off by default as it's of no use to
+  callers and makes otherwise identical responses differ.
+*/
+
+const (
+	InitPhaseConfigLoad   = "configLoad"
+	InitPhaseSecretsFetch = "secretsFetch"
+	InitPhaseWarmUp       = "warmUp"
+)
+
+type InitTimings struct {
+	InitializationType string             `json:"initializationType"`
+	TotalMs            float64            `json:"totalMs"`
+	Phases             map[string]float64 `json:"phases"`
+}
+
+type initTimer struct {
+	mu     sync.Mutex
+	start  time.Time
+	phases map[string]time.Duration
+	// The first invocation hasn't come yet
+	pending bool
+}
+
+// The container's init, started with the process
+var containerInit = newInitTimer()
+
+func newInitTimer() *initTimer {
+	return &initTimer{start: time.Now(), phases: map[string]time.Duration{}, pending: true}
+}
+
+// Starts timing a phase of init, the time counts once the returned func is called
+func (timer *initTimer) phase(name string) func() {
+	start := time.Now()
+	return func() {
+		timer.mu.Lock()
+		defer timer.mu.Unlock()
+		timer.phases[name] += time.Since(start)
+	}
+}
+
+// Whether this is the first invocation after an on-demand init, true only once
+func (timer *initTimer) takeColdStart() bool {
+	timer.mu.Lock()
+	defer timer.mu.Unlock()
+	first := timer.pending
+	timer.pending = false
+	return first && !provisionedConcurrency()
+}
+
+func (timer *initTimer) timings() InitTimings {
+	timer.mu.Lock()
+	defer timer.mu.Unlock()
+	initializationType := os.Getenv("AWS_LAMBDA_INITIALIZATION_TYPE")
+	if initializationType == "" {
+		initializationType = "on-demand"
+	}
+	timings := InitTimings{
+		InitializationType: initializationType,
+		TotalMs:            milliseconds(time.Since(timer.start)),
+		Phases:             map[string]float64{},
+	}
+	for _, name := range []string{InitPhaseConfigLoad, InitPhaseSecretsFetch, InitPhaseWarmUp} {
+		timings.Phases[name] = milliseconds(timer.phases[name])
+	}
+	return timings
+}
+
+// Logs how long init took and queues the metrics, once setup is done
+func (config *Config) recordInit(timer *initTimer) {
+	timings := timer.timings()
+	config.telemetry.observe("InitDuration", timings.TotalMs, "Milliseconds")
+	config.telemetry.observe("InitConfigLoad", timings.Phases[InitPhaseConfigLoad], "Milliseconds")
+	config.telemetry.observe("InitSecretsFetch", timings.Phases[InitPhaseSecretsFetch], "Milliseconds")
+	config.telemetry.observe("InitWarmUp", timings.Phases[InitPhaseWarmUp], "Milliseconds")
+	if config.timingLog == nil {
+		return
+	}
+	line, err := marshalJSON(map[string]InitTimings{"init": timings})
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	fmt.Fprintln(config.timingLog, string(line))
+}
+
+// Whether the request is the container's cold start, counting it when it is
+func (config *Config) coldStart() bool {
+	if !containerInit.takeColdStart() {
+		return false
+	}
+	config.telemetry.count("ColdStarts", 1)
+	return true
+}
+
+// The cold start for the response's metadata
+func withColdStart(metadata *ResponseMetadata) *ResponseMetadata {
+	copied := copyMetadata(metadata)
+	copied.ColdStart = true
+	return copied
+}
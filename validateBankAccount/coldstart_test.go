@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestConfig_coldStart(t *testing.T) {
+	defer func(previous *initTimer) { containerInit = previous }(containerInit)
+	tests := []struct {
+		name               string
+		initializationType string
+		debug              bool
+		warmerFirst        bool
+		wantCold           bool
+		wantMetadata       bool
+	}{
+		{"onDemand", "on-demand", false, false, true, false},
+		{"debug", "", true, false, true, true},
+		{"provisioned", "provisioned-concurrency", true, false, false, false},
+		{"afterWarmer", "on-demand", true, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_LAMBDA_INITIALIZATION_TYPE", tt.initializationType)
+			containerInit = newInitTimer()
+			var out bytes.Buffer
+			config := &Config{
+				Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+				Debug:     tt.debug,
+				metadata:  &ResponseMetadata{ConfigHash: "9f86d081884c"},
+				timingLog: &out,
+				telemetry: newTelemetry(nil, &bytes.Buffer{}),
+			}
+			if tt.warmerFirst {
+				config.LambdaHandler(context.Background(), LambdaEvent{Warmer: true})
+			}
+			for i, wantCold := range []bool{tt.wantCold, false} {
+				out.Reset()
+				response, _ := config.LambdaHandler(context.Background(), LambdaEvent{Request: Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345678\"}"}})
+				var logged struct {
+					Timings RequestTimings `json:"timings"`
+				}
+				if err := json.Unmarshal(out.Bytes(), &logged); err != nil || logged.Timings.ColdStart != wantCold {
+					t.Errorf("request %d timings = %s, want coldStart %v", i, out.String(), wantCold)
+				}
+				if got := strings.Contains(response.Body, "\"coldStart\":true"); got != (wantCold && tt.wantMetadata) {
+					t.Errorf("request %d response = %s, want coldStart in the metadata %v", i, response.Body, wantCold && tt.wantMetadata)
+				}
+			}
+			if got := config.telemetry.counts["ColdStarts"]; got != map[bool]float64{true: 1}[tt.wantCold] {
+				t.Errorf("ColdStarts = %v", got)
+			}
+		})
+	}
+}
+
+func TestConfig_recordInit(t *testing.T) {
+	t.Setenv("AWS_LAMBDA_INITIALIZATION_TYPE", "")
+	timer := newInitTimer()
+	timer.phase(InitPhaseConfigLoad)()
+	var out bytes.Buffer
+	config := &Config{timingLog: &out, telemetry: newTelemetry(nil, &bytes.Buffer{})}
+	config.recordInit(timer)
+
+	var logged struct {
+		Init InitTimings `json:"init"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &logged); err != nil {
+		t.Fatalf("init line isn't json: %v", err)
+	}
+	if logged.Init.InitializationType != "on-demand" || len(logged.Init.Phases) != 3 {
+		t.Errorf("init = %+v, want on-demand with all three phases", logged.Init)
+	}
+	for _, name := range []string{"InitDuration", "InitConfigLoad", "InitSecretsFetch", "InitWarmUp"} {
+		if metric := config.telemetry.metrics[name]; metric == nil || len(metric.values) != 1 {
+			t.Errorf("%s wasn't observed", name)
+		}
+	}
+}
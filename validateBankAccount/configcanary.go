@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+  Canaried config changes. A runtime override (see runtimeoverrides.go) normally goes to every request the moment a
+  container sees it. With
+
+    configCanary:
+      percent: 10                 # of requests that see a change first, by a hash of the request id
+      healthWindowSeconds: 300    # how long before it goes to everyone, default 300
+      maxErrorRateIncrease: 0.05  # how much worse the canary's provider error rate can be, default 0.05
+      minCalls: 20                # provider calls the canary needs before we judge it, default 20
+
+  a change a container picks up is pending at first: only the requests whose id falls in the percent see it and the
+  rest carry on with what we had. Their provider calls are counted apart, and once the canary has made minCalls of
+  them with an error rate more than maxErrorRateIncrease over the others', the change is undone, the override
+  written back how it was and the rollback recorded in the config history. If the health window goes by without
+  that, it goes to everyone. Another change while one's pending joins it and starts the window again.
+
+  Every container runs its own canary on its own traffic. One that rolls a change back writes it back, so the others
+  drop it at their next read. The first read at init isn't a change, it applies straight away.
+*/
+
+const (
+	defaultCanaryHealthWindow     = 5 * time.Minute
+	defaultCanaryMaxErrorIncrease = 0.05
+	defaultCanaryMinCalls         = 20
+)
+
+const configCanaryCaller = "configCanary"
+
+type ConfigCanaryConfig struct {
+	Percent              int     `yaml:"percent"`
+	HealthWindowSeconds  int     `yaml:"healthWindowSeconds"`
+	MaxErrorRateIncrease float64 `yaml:"maxErrorRateIncrease"`
+	MinCalls             int     `yaml:"minCalls"`
+}
+
+func (canary *ConfigCanaryConfig) validate() error {
+	if canary == nil {
+		return nil
+	}
+	if canary.Percent < 1 || canary.Percent > 99 {
+		return errors.New("configCanary percent must be between 1 and 99")
+	}
+	if canary.HealthWindowSeconds < 0 || canary.MinCalls < 0 {
+		return errors.New("configCanary healthWindowSeconds and minCalls can't be negative")
+	}
+	if canary.MaxErrorRateIncrease < 0 || canary.MaxErrorRateIncrease > 1 {
+		return errors.New("configCanary maxErrorRateIncrease must be between 0 and 1")
+	}
+	return nil
+}
+
+func (canary *ConfigCanaryConfig) healthWindow() time.Duration {
+	if canary.HealthWindowSeconds == 0 {
+		return defaultCanaryHealthWindow
+	}
+	return time.Duration(canary.HealthWindowSeconds) * time.Second
+}
+
+func (canary *ConfigCanaryConfig) maxErrorIncrease() float64 {
+	if canary.MaxErrorRateIncrease == 0 {
+		return defaultCanaryMaxErrorIncrease
+	}
+	return canary.MaxErrorRateIncrease
+}
+
+func (canary *ConfigCanaryConfig) minCalls() int {
+	if canary.MinCalls == 0 {
+		return defaultCanaryMinCalls
+	}
+	return canary.MinCalls
+}
+
+// Whether the request sees pending changes
+func (canary *ConfigCanaryConfig) takes(requestID string) bool {
+	hash := sha256.Sum256([]byte(requestID))
+	return binary.BigEndian.Uint64(hash[:8])%100 < uint64(canary.Percent)
+}
+
+type canaryCalls struct {
+	calls, errors int
+}
+
+func (counts canaryCalls) errorRate() float64 {
+	if counts.calls == 0 {
+		return 0
+	}
+	return float64(counts.errors) / float64(counts.calls)
+}
+
+type canaryVerdict int
+
+const (
+	canaryWait canaryVerdict = iota
+	canaryPromote
+	canaryRollBack
+)
+
+type configCanaryRollout struct {
+	mu     sync.Mutex
+	config ConfigCanaryConfig
+	// When what's pending went on canary, zero when nothing is
+	since   time.Time
+	canary  canaryCalls
+	control canaryCalls
+	now     func() time.Time
+	// Rollbacks still being written
+	rollingBack sync.WaitGroup
+}
+
+// Set up at init by setupConfigCanary when there's a configCanary, nil means changes go to everyone straight away
+var configCanary *configCanaryRollout
+
+func (config *Config) setupConfigCanary() {
+	if config.ConfigCanary == nil {
+		configCanary = nil
+		return
+	}
+	configCanary = &configCanaryRollout{config: *config.ConfigCanary, now: time.Now}
+}
+
+type configCanaryKey struct{}
+
+// Puts the request on the canary or not, by its id
+func withConfigCanary(ctx context.Context, request Request) context.Context {
+	if configCanary == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, configCanaryKey{}, configCanary.config.takes(requestID(ctx, request)))
+}
+
+// Whether the request sees pending changes
+func onConfigCanary(ctx context.Context) bool {
+	canary, _ := ctx.Value(configCanaryKey{}).(bool)
+	return canary
+}
+
+// Starts the health window again, when a change goes on canary
+func (rollout *configCanaryRollout) begin() {
+	rollout.mu.Lock()
+	defer rollout.mu.Unlock()
+	rollout.since = rollout.now()
+	rollout.canary, rollout.control = canaryCalls{}, canaryCalls{}
+}
+
+// Whether nothing's on canary
+func (rollout *configCanaryRollout) idle() bool {
+	rollout.mu.Lock()
+	defer rollout.mu.Unlock()
+	return rollout.since.IsZero()
+}
+
+// Counts a provider call on the canary or off it, whichever the request is
+func (rollout *configCanaryRollout) record(ctx context.Context, failed bool) {
+	if rollout == nil {
+		return
+	}
+	rollout.mu.Lock()
+	if rollout.since.IsZero() {
+		rollout.mu.Unlock()
+		return
+	}
+	counts := &rollout.control
+	if onConfigCanary(ctx) {
+		counts = &rollout.canary
+	}
+	counts.calls++
+	if failed {
+		counts.errors++
+	}
+	rollout.mu.Unlock()
+	rollout.settle()
+}
+
+// Must be called with the lock held
+func (rollout *configCanaryRollout) verdict() canaryVerdict {
+	if rollout.since.IsZero() {
+		return canaryWait
+	}
+	if rollout.canary.calls >= rollout.config.minCalls() &&
+		rollout.canary.errorRate() > rollout.control.errorRate()+rollout.config.maxErrorIncrease() {
+		return canaryRollBack
+	}
+	if rollout.now().Sub(rollout.since) >= rollout.config.healthWindow() {
+		return canaryPromote
+	}
+	return canaryWait
+}
+
+// Promotes or rolls back what's pending once there's a verdict
+func (rollout *configCanaryRollout) settle() {
+	if rollout == nil {
+		return
+	}
+	rollout.mu.Lock()
+	verdict := rollout.verdict()
+	canary, control := rollout.canary, rollout.control
+	if verdict != canaryWait {
+		rollout.since = time.Time{}
+	}
+	rollout.mu.Unlock()
+	switch verdict {
+	case canaryPromote:
+		for _, kind := range []string{OverrideKindToggles, OverrideKindBreakers} {
+			overridesOf(kind).promote()
+		}
+		log.Printf("config canary promoted after %d canary calls, %.1f%% errors", canary.calls, 100*canary.errorRate())
+	case canaryRollBack:
+		detail := fmt.Sprintf("canary rolled back, %.1f%% errors on %d calls against %.1f%% on %d",
+			100*canary.errorRate(), canary.calls, 100*control.errorRate(), control.calls)
+		log.Print("config " + detail)
+		// It writes to the override tables, which the request that tipped it over shouldn't wait for
+		rollout.rollingBack.Add(1)
+		go func() {
+			defer rollout.rollingBack.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), overrideFetchTimeout)
+			defer cancel()
+			for _, kind := range []string{OverrideKindToggles, OverrideKindBreakers} {
+				overridesOf(kind).rollBack(ctx, detail)
+			}
+		}()
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConfigCanaryConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		canary  *ConfigCanaryConfig
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"percent", &ConfigCanaryConfig{Percent: 10}, false},
+		{"everything set", &ConfigCanaryConfig{Percent: 5, HealthWindowSeconds: 60, MaxErrorRateIncrease: 0.1, MinCalls: 50}, false},
+		{"no percent", &ConfigCanaryConfig{}, true},
+		{"everyone", &ConfigCanaryConfig{Percent: 100}, true},
+		{"negative window", &ConfigCanaryConfig{Percent: 10, HealthWindowSeconds: -1}, true},
+		{"negative min calls", &ConfigCanaryConfig{Percent: 10, MinCalls: -1}, true},
+		{"increase over 1", &ConfigCanaryConfig{Percent: 10, MaxErrorRateIncrease: 2}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.canary.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigCanaryConfig_takes(t *testing.T) {
+	canary := &ConfigCanaryConfig{Percent: 20}
+	taken := 0
+	for i := 0; i < 1000; i++ {
+		id := time.Unix(int64(i), 0).String()
+		if canary.takes(id) != canary.takes(id) {
+			t.Fatalf("takes(%q) isn't stable", id)
+		}
+		if canary.takes(id) {
+			taken++
+		}
+	}
+	if taken < 150 || taken > 250 {
+		t.Errorf("took %d of 1000 requests, want about 200", taken)
+	}
+}
+
+// A canary whose clock the test moves, and toggles that see it
+func testConfigCanary(t *testing.T, store OverrideStore) (*configCanaryRollout, *runtimeOverrides, func(time.Duration)) {
+	var mu sync.Mutex
+	now := time.Unix(0, 0)
+	rollout := &configCanaryRollout{config: ConfigCanaryConfig{Percent: 10, MinCalls: 10}, now: func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}}
+	overrides := freshOverrides(store)
+	savedCanary, savedToggles := configCanary, runtimeToggles
+	configCanary, runtimeToggles = rollout, overrides
+	t.Cleanup(func() { configCanary, runtimeToggles = savedCanary, savedToggles })
+	return rollout, overrides, func(by time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(by)
+	}
+}
+
+var (
+	canaryCtx  = context.WithValue(context.Background(), configCanaryKey{}, true)
+	controlCtx = context.WithValue(context.Background(), configCanaryKey{}, false)
+)
+
+func Test_configCanary_promote(t *testing.T) {
+	store := newFakeOverrides(map[string]string{})
+	rollout, overrides, advance := testConfigCanary(t, store)
+	if err := overrides.set(context.Background(), "provider2", toggleDisabled, "key:oncall", ""); err != nil {
+		t.Fatal(err)
+	}
+	if store.values["provider2"] != toggleDisabled {
+		t.Errorf("stored %v, want provider2 disabled", store.values)
+	}
+	if !toggledOff(canaryCtx, "provider2") || toggledOff(controlCtx, "provider2") {
+		t.Fatalf("only the canary should see provider2 disabled, pending %v", overrides.pending)
+	}
+
+	// Errors like everyone else's are fine, and the window going by gives it to everyone
+	for i := 0; i < 20; i++ {
+		rollout.record(controlCtx, i%10 == 0)
+		rollout.record(canaryCtx, i%10 == 0)
+	}
+	if !toggledOff(canaryCtx, "provider2") || toggledOff(controlCtx, "provider2") {
+		t.Fatalf("promoted before the health window went by")
+	}
+	advance(defaultCanaryHealthWindow)
+	rollout.record(controlCtx, false)
+	if !toggledOff(controlCtx, "provider2") || len(overrides.pending) != 0 || !rollout.idle() {
+		t.Errorf("not promoted after the health window, values %v pending %v", overrides.values, overrides.pending)
+	}
+
+	// Calls with nothing on canary don't count
+	counted := rollout.canary
+	rollout.record(canaryCtx, true)
+	if rollout.canary != counted {
+		t.Errorf("counted a call with nothing on canary")
+	}
+}
+
+func Test_configCanary_rollBack(t *testing.T) {
+	store := newFakeOverrides(map[string]string{})
+	rollout, overrides, _ := testConfigCanary(t, store)
+
+	// Another container disables provider2, we pick it up on canary
+	store.Set(context.Background(), "provider2", toggleDisabled, "key:oncall", time.Unix(0, 0))
+	overrides.reload(context.Background())
+	if !toggledOff(canaryCtx, "provider2") || toggledOff(controlCtx, "provider2") || rollout.idle() {
+		t.Fatalf("the read didn't put provider2 on canary, pending %v", overrides.pending)
+	}
+
+	// Too few calls to judge it, however bad they are
+	for i := 0; i < 9; i++ {
+		rollout.record(canaryCtx, true)
+		rollout.record(controlCtx, false)
+	}
+	if rollout.idle() {
+		t.Fatalf("judged on %d calls", rollout.canary.calls)
+	}
+	rollout.record(canaryCtx, true)
+	rollout.rollingBack.Wait()
+	if _, stored := store.values["provider2"]; stored || len(overrides.pending) != 0 {
+		t.Fatalf("not rolled back, stored %v, pending %v", store.values, overrides.pending)
+	}
+	if toggledOff(canaryCtx, "provider2") || toggledOff(controlCtx, "provider2") {
+		t.Errorf("provider2 still disabled after the rollback")
+	}
+}
+
+func TestConfig_setupConfigCanary_none(t *testing.T) {
+	saved := configCanary
+	defer func() { configCanary = saved }()
+	(&Config{}).setupConfigCanary()
+	store := newFakeOverrides(map[string]string{})
+	overrides := freshOverrides(store)
+	if err := overrides.set(context.Background(), "provider2", toggleDisabled, "key:oncall", ""); err != nil {
+		t.Fatal(err)
+	}
+	if overrides.valueFor(withConfigCanary(context.Background(), Request{}), "provider2") != toggleDisabled || len(overrides.pending) != 0 {
+		t.Errorf("without a configCanary a change should go to everyone, values %v pending %v", overrides.values, overrides.pending)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+/*
+  Config linting for deploy pipelines, so a bad PROVIDERS blob is caught before the Lambda ever loads it:
+
+    bin/bootstrap config validate [-env prod] [-check-urls] [-check-secrets] providers.yaml
+
+  Runs the same steps as a cold start, overlay, schema, env overrides and compile, and prints a line per check.
+  -check-urls makes a HEAD request to every provider endpoint and health url, any http response counts as reachable.
+  -check-secrets fetches every Secrets Manager secret a signing key names, with the pipeline's AWS credentials, and
+  makes sure the key it wants is in there. Secrets from environment variables are checked by compile, so run it with
+  them set. Exits 1 if anything failed.
+
+    ok    read providers.yaml
+    ok    overlay prod
+    ok    schema: version 2, 3 providers
+    ok    overrides
+    ok    compile
+    FAIL  url provider2 https://provider2.com/v2/api/account/validate: dial tcp: lookup provider2.com: no such host
+    ok    secret accountvalidator/provider1 for provider1 key 2024-06
+    1 check failed
+*/
+
+type configCheckOptions struct {
+	Environment  string
+	CheckURLs    bool
+	CheckSecrets bool
+	// Made from the default AWS config when nil
+	secrets secretsManagerAPI
+	client  *http.Client
+}
+
+type configReport struct {
+	out    io.Writer
+	failed int
+}
+
+func (report *configReport) check(name string, err error) bool {
+	if err != nil {
+		report.failed++
+		fmt.Fprintf(report.out, "FAIL  %s: %v\n", name, err)
+		return false
+	}
+	fmt.Fprintf(report.out, "ok    %s\n", name)
+	return true
+}
+
+// Handles `config <subcommand>` from the command line, returning the exit code
+func configCommand(args []string, out io.Writer) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintln(out, "usage: config validate [-env name] [-check-urls] [-check-secrets] <file>")
+		return 2
+	}
+	flags := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	flags.SetOutput(out)
+	options := configCheckOptions{}
+	flags.StringVar(&options.Environment, "env", os.Getenv("ENVIRONMENT"), "environment overlay to apply")
+	flags.BoolVar(&options.CheckURLs, "check-urls", false, "check every provider url is reachable")
+	flags.BoolVar(&options.CheckSecrets, "check-secrets", false, "check every Secrets Manager secret can be read")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(out, "usage: config validate [-env name] [-check-urls] [-check-secrets] <file>")
+		return 2
+	}
+	if !validateConfigFile(context.Background(), flags.Arg(0), options, out) {
+		return 1
+	}
+	return 0
+}
+
+// Runs every check on the config file, printing a line for each, true when they all passed
+func validateConfigFile(ctx context.Context, path string, options configCheckOptions, out io.Writer) bool {
+	report := &configReport{out: out}
+	defer func() {
+		switch report.failed {
+		case 0:
+			fmt.Fprintln(out, "config is valid")
+		case 1:
+			fmt.Fprintln(out, "1 check failed")
+		default:
+			fmt.Fprintf(out, "%d checks failed\n", report.failed)
+		}
+	}()
+
+	data, err := os.ReadFile(path)
+	if !report.check("read "+path, err) {
+		return false
+	}
+	overlay := "overlay"
+	if options.Environment != "" {
+		overlay += " " + options.Environment
+	}
+	data, err = applyOverlay(data, options.Environment)
+	if !report.check(overlay, err) {
+		return false
+	}
+	config, err := parseConfig(data)
+	if err == nil && config == nil {
+		err = errors.New("config is empty")
+	}
+	if err != nil {
+		report.check("schema", err)
+		return false
+	}
+	report.check(fmt.Sprintf("schema: version %d, %d providers", max(config.Version, 1), len(config.Providers)), nil)
+	if !report.check("overrides", config.applyOverrides(os.LookupEnv)) {
+		return false
+	}
+	if !report.check("compile", config.compile()) {
+		return false
+	}
+
+	if options.CheckURLs {
+		client := options.client
+		if client == nil {
+			client = &http.Client{Timeout: config.longestTimeout()}
+		}
+		for _, check := range config.checkURLs(ctx, client) {
+			report.check(check.name, check.err)
+		}
+	}
+	if options.CheckSecrets {
+		client := options.secrets
+		if client == nil {
+			cfg, err := loadAWSConfig(ctx)
+			if !report.check("aws credentials", err) {
+				return false
+			}
+			client = secretsmanager.NewFromConfig(cfg)
+		}
+		for _, check := range config.checkSecrets(ctx, client) {
+			report.check(check.name, check.err)
+		}
+	}
+	return report.failed == 0
+}
+
+type namedCheck struct {
+	name string
+	url  string
+	err  error
+}
+
+// Makes a HEAD request to every url the enabled providers use, in parallel
+func (config *Config) checkURLs(ctx context.Context, client *http.Client) []namedCheck {
+	checks := []namedCheck{}
+	for _, provider := range enabledProviders(config.Providers) {
+		if provider.Type == ProviderTypeSimulated || provider.Type == ProviderTypeSEPA {
+			continue
+		}
+		urls := provider.endpointURLs()
+		if provider.HealthURL != "" {
+			urls = append(urls, provider.HealthURL)
+		}
+		if provider.Rollout != nil {
+			urls = append(urls, provider.Rollout.URL)
+		}
+		for _, url := range urls {
+			checks = append(checks, namedCheck{name: "url " + provider.Name + " " + url, url: url})
+		}
+	}
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(check *namedCheck) {
+			defer wg.Done()
+			request, err := http.NewRequestWithContext(ctx, http.MethodHead, check.url, nil)
+			if err != nil {
+				check.err = err
+				return
+			}
+			response, err := client.Do(request)
+			if err != nil {
+				check.err = err
+				return
+			}
+			response.Body.Close()
+		}(&checks[i])
+	}
+	wg.Wait()
+	return checks
+}
+
+// Fetches every Secrets Manager secret a signing key names and checks the key it wants is there
+func (config *Config) checkSecrets(ctx context.Context, client secretsManagerAPI) []namedCheck {
+	checks := []namedCheck{}
+	for _, provider := range config.Providers {
+		if provider.Signing == nil {
+			continue
+		}
+		for _, key := range []*SigningKey{provider.Signing.Primary, provider.Signing.Secondary} {
+			if key == nil || key.SecretID == "" {
+				continue
+			}
+			check := namedCheck{name: fmt.Sprintf("secret %s for %s key %s", key.SecretID, provider.Name, key.ID)}
+			output, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+				SecretId:     aws.String(key.SecretID),
+				VersionStage: aws.String(currentVersionStage),
+			})
+			if err != nil {
+				check.err = err
+			} else if value, err := secretField(aws.ToString(output.SecretString), key.SecretKey); err != nil {
+				check.err = err
+			} else if value == "" {
+				check.err = errors.New("secret is empty")
+			}
+			checks = append(checks, check)
+		}
+	}
+	sort.SliceStable(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+	return checks
+}
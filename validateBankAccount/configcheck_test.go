@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "providers.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_validateConfigFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer server.Close()
+
+	signed := "version: 2\nproviders:\n- name: provider1\n  endpoints: [" + server.URL + "]\n  health: {url: " + server.URL + "/health}\n" +
+		"  auth:\n    signing:\n      primary: {id: k1, secretId: accountvalidator/provider1, secretKey: hmacKey}\n"
+	tests := []struct {
+		name    string
+		yaml    string
+		options configCheckOptions
+		secret  string
+		want    bool
+		wantOut []string
+	}{
+		{
+			name:    "valid",
+			yaml:    signed,
+			options: configCheckOptions{CheckURLs: true, CheckSecrets: true},
+			secret:  "{\"hmacKey\": \"k\"}",
+			want:    true,
+			wantOut: []string{"ok    schema: version 2, 1 providers", "ok    url provider1 " + server.URL + "/health", "ok    secret accountvalidator/provider1 for provider1 key k1", "config is valid"},
+		},
+		{
+			name:    "missingSecretKey",
+			yaml:    signed,
+			options: configCheckOptions{CheckSecrets: true},
+			secret:  "{\"other\": \"k\"}",
+			wantOut: []string{"FAIL  secret accountvalidator/provider1 for provider1 key k1: secret has no string key hmacKey", "1 check failed"},
+		},
+		{
+			name:    "unreachable",
+			yaml:    "providers:\n- name: provider1\n  url: http://127.0.0.1:1/validate\n",
+			options: configCheckOptions{CheckURLs: true},
+			wantOut: []string{"FAIL  url provider1 http://127.0.0.1:1/validate", "1 check failed"},
+		},
+		{
+			name:    "badSchema",
+			yaml:    "version: 2\nproviders:\n- name: provider1\n  url: https://provider1.com\n",
+			wantOut: []string{"FAIL  schema", "field url not found"},
+		},
+		{
+			name:    "doesNotCompile",
+			yaml:    "providers:\n- name: provider1\n  type: carrier-pigeon\n",
+			wantOut: []string{"FAIL  compile: provider provider1 has unknown type carrier-pigeon"},
+		},
+		{
+			name:    "overlay",
+			yaml:    "providers:\n- name: provider1\n  type: simulated\nenvironments:\n  prod:\n    providers:\n    - name: provider1\n      type: nope\n",
+			options: configCheckOptions{Environment: "prod"},
+			wantOut: []string{"ok    overlay prod", "FAIL  compile"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.secrets = &fakeSecretsManager{value: tt.secret}
+			var out bytes.Buffer
+			got := validateConfigFile(context.Background(), writeTestConfig(t, tt.yaml), tt.options, &out)
+			if got != tt.want {
+				t.Errorf("validateConfigFile() = %v, want %v\n%s", got, tt.want, out.String())
+			}
+			for _, want := range tt.wantOut {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("report is missing %q\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
+
+func Test_configCommand_usage(t *testing.T) {
+	var out bytes.Buffer
+	if code := configCommand([]string{"lint"}, &out); code != 2 || !strings.Contains(out.String(), "usage") {
+		t.Errorf("configCommand() = %v, %v", code, out.String())
+	}
+	if code := configCommand([]string{"validate", writeTestConfig(t, "providers:\n- name: p\n  type: simulated\n")}, &out); code != 0 {
+		t.Errorf("configCommand() = %v, want 0\n%s", code, out.String())
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+/*
+  Config history. Every runtime config change (the toggles and forced breakers in runtimeoverrides.go) is recorded
+  in the table in CONFIG_HISTORY_TABLE, as a revision saying who changed what from what to what and when:
+
+    revision (S, hash key) | kind (S) | provider (S) | oldValue (S) | newValue (S) | changedBy (S) | changedAt (S) | detail (S)
+
+  An empty value is no override. Two admin routes (see admin.go) read it and undo it:
+
+    GET  /admin/config/history                          # every revision, newest first
+    POST /admin/config/rollback/01717232400000000000    # back to how things were straight after that revision
+
+  A rollback puts every override changed since the revision back to the value it had then, and each of those is a
+  new revision itself, by whoever asked for the rollback with "rollback to <revision>" as its detail, so rollbacks
+  can be rolled back too. A change whose history can't be written is still applied, and logged.
+*/
+
+type ConfigChange struct {
+	Revision  string    `json:"revision"`
+	Kind      string    `json:"kind"`
+	Provider  string    `json:"provider"`
+	OldValue  string    `json:"oldValue"`
+	NewValue  string    `json:"newValue"`
+	ChangedBy string    `json:"changedBy"`
+	ChangedAt time.Time `json:"changedAt"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+type ConfigHistoryStore interface {
+	PutChange(ctx context.Context, change ConfigChange) error
+	Changes(ctx context.Context) ([]ConfigChange, error)
+}
+
+type dynamoConfigHistory struct {
+	client dynamoDBAPI
+	table  string
+}
+
+func (store *dynamoConfigHistory) PutChange(ctx context.Context, change ConfigChange) error {
+	item := map[string]types.AttributeValue{
+		"revision":  &types.AttributeValueMemberS{Value: change.Revision},
+		"kind":      &types.AttributeValueMemberS{Value: change.Kind},
+		"provider":  &types.AttributeValueMemberS{Value: change.Provider},
+		"oldValue":  &types.AttributeValueMemberS{Value: change.OldValue},
+		"newValue":  &types.AttributeValueMemberS{Value: change.NewValue},
+		"changedBy": &types.AttributeValueMemberS{Value: change.ChangedBy},
+		"changedAt": &types.AttributeValueMemberS{Value: change.ChangedAt.UTC().Format(time.RFC3339Nano)},
+	}
+	if change.Detail != "" {
+		item["detail"] = &types.AttributeValueMemberS{Value: change.Detail}
+	}
+	_, err := store.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(store.table), Item: item})
+	return err
+}
+
+// Runtime changes are rare enough for a scan
+func (store *dynamoConfigHistory) Changes(ctx context.Context) ([]ConfigChange, error) {
+	changes := []ConfigChange{}
+	paginator := dynamodb.NewScanPaginator(store.client, &dynamodb.ScanInput{TableName: aws.String(store.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			changes = append(changes, changeFromItem(item))
+		}
+	}
+	return changes, nil
+}
+
+func changeFromItem(item map[string]types.AttributeValue) ConfigChange {
+	value := func(name string) string {
+		if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	change := ConfigChange{
+		Revision:  value("revision"),
+		Kind:      value("kind"),
+		Provider:  value("provider"),
+		OldValue:  value("oldValue"),
+		NewValue:  value("newValue"),
+		ChangedBy: value("changedBy"),
+		Detail:    value("detail"),
+	}
+	change.ChangedAt, _ = time.Parse(time.RFC3339Nano, value("changedAt"))
+	return change
+}
+
+// Set up at init by setupConfigHistory when there's a history table, nil records nothing
+var configHistory *configHistoryLog
+
+type configHistoryLog struct {
+	store ConfigHistoryStore
+}
+
+// Revisions sort in the order they were made
+func revisionAt(at time.Time) string {
+	return fmt.Sprintf("%020d", at.UnixNano())
+}
+
+func (history *configHistoryLog) record(ctx context.Context, change ConfigChange) {
+	if history == nil {
+		return
+	}
+	change.Revision = revisionAt(change.ChangedAt)
+	if err := history.store.PutChange(ctx, change); err != nil {
+		log.Printf("unable to record config change %+v: %v", change, err)
+	}
+}
+
+// Every revision, newest first
+func (history *configHistoryLog) changes(ctx context.Context) ([]ConfigChange, error) {
+	changes, err := history.store.Changes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Revision > changes[j].Revision })
+	return changes, nil
+}
+
+// The overrides of a kind of change
+func overridesOf(kind string) *runtimeOverrides {
+	switch kind {
+	case OverrideKindToggles:
+		return runtimeToggles
+	case OverrideKindBreakers:
+		return forcedBreakers
+	}
+	return nil
+}
+
+// Puts everything changed since the revision back how it was, returning the changes that made
+func (history *configHistoryLog) rollback(ctx context.Context, revision, by string) ([]ConfigChange, error) {
+	changes, err := history.changes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, change := range changes {
+		found = found || change.Revision == revision
+	}
+	if !found {
+		return nil, errUnknownRevision
+	}
+	// Newest first, so the last old value we see for an override is the one it had straight after the revision
+	restore := map[[2]string]string{}
+	order := [][2]string{}
+	for _, change := range changes {
+		if change.Revision <= revision {
+			break
+		}
+		key := [2]string{change.Kind, change.Provider}
+		if _, seen := restore[key]; !seen {
+			order = append(order, key)
+		}
+		restore[key] = change.OldValue
+	}
+	applied := []ConfigChange{}
+	for _, key := range order {
+		overrides := overridesOf(key[0])
+		if overrides == nil {
+			return applied, fmt.Errorf("%s overrides aren't set up", key[0])
+		}
+		if overrides.get(key[1]) == restore[key] {
+			continue
+		}
+		if err := overrides.set(ctx, key[1], restore[key], by, "rollback to "+revision); err != nil {
+			return applied, err
+		}
+		applied = append(applied, ConfigChange{Kind: key[0], Provider: key[1], NewValue: restore[key]})
+	}
+	return applied, nil
+}
+
+var errUnknownRevision = errors.New("unknown revision")
+
+func (config *Config) setupConfigHistory(ctx context.Context) {
+	table, exists := os.LookupEnv("CONFIG_HISTORY_TABLE")
+	if !exists {
+		return
+	}
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	configHistory = &configHistoryLog{store: &dynamoConfigHistory{client: dynamodb.NewFromConfig(cfg), table: table}}
+}
+
+// Handler for GET /admin/config/history
+func (config *Config) ConfigHistoryHandler(ctx context.Context, request Request) (Response, error) {
+	if configHistory == nil {
+		return jsonResponse(http.StatusServiceUnavailable, map[string]string{"error": "config history isn't set up"})
+	}
+	changes, err := configHistory.changes(ctx)
+	if err != nil {
+		return *handleError(err, "unable to read the config history"), nil
+	}
+	return jsonResponse(http.StatusOK, changes)
+}
+
+// Handler for POST /admin/config/rollback/{revision}
+func (config *Config) ConfigRollbackHandler(ctx context.Context, request Request) (Response, error) {
+	if configHistory == nil {
+		return jsonResponse(http.StatusServiceUnavailable, map[string]string{"error": "config history isn't set up"})
+	}
+	revision := request.PathParameters["revision"]
+	applied, err := configHistory.rollback(ctx, revision, adminCallerFrom(ctx))
+	if errors.Is(err, errUnknownRevision) {
+		return jsonResponse(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown revision %s", revision)})
+	}
+	if err != nil {
+		return *handleError(err, "unable to roll back, some overrides may have been restored"), nil
+	}
+	log.Printf("config rolled back to %s by %s, %d overrides restored", revision, adminCallerFrom(ctx), len(applied))
+	return jsonResponse(http.StatusOK, map[string]interface{}{"revision": revision, "restored": applied})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeConfigHistory struct {
+	mu      sync.Mutex
+	changes []ConfigChange
+}
+
+func (store *fakeConfigHistory) PutChange(ctx context.Context, change ConfigChange) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.changes = append(store.changes, change)
+	return nil
+}
+
+func (store *fakeConfigHistory) Changes(ctx context.Context) ([]ConfigChange, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return append([]ConfigChange(nil), store.changes...), nil
+}
+
+func TestConfig_Router_configRollback(t *testing.T) {
+	history := &fakeConfigHistory{}
+	configHistory = &configHistoryLog{store: history}
+	toggles, breakers := newFakeOverrides(map[string]string{}), newFakeOverrides(map[string]string{})
+	runtimeToggles, forcedBreakers = freshOverrides(toggles), freshOverrides(breakers)
+	runtimeToggles.kind, forcedBreakers.kind = OverrideKindToggles, OverrideKindBreakers
+	defer func() { configHistory, runtimeToggles, forcedBreakers = nil, nil, nil }()
+	// Every change a nanosecond after the last, so each is its own revision
+	var clock sync.Mutex
+	now := time.Unix(1717232400, 0)
+	tick := func() time.Time {
+		clock.Lock()
+		defer clock.Unlock()
+		now = now.Add(time.Nanosecond)
+		return now
+	}
+	runtimeToggles.now, forcedBreakers.now = tick, tick
+	config := &Config{
+		Providers:  []Provider{{Name: "provider1", Type: ProviderTypeSimulated}, {Name: "provider2", Type: ProviderTypeSimulated}},
+		Admin:      &AdminConfig{Keys: []*SigningKey{{ID: "oncall", Secret: "admin-secret"}}},
+		adminAudit: &bytes.Buffer{},
+	}
+	config.setupProviders()
+	admin := func(method, path string) Response {
+		response, err := config.Router(context.Background(), adminRequest(method, path, "", "admin-secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	admin("POST", "/admin/providers/provider1/disable")
+	var changes []ConfigChange
+	json.Unmarshal([]byte(admin("GET", "/admin/config/history").Body), &changes)
+	if len(changes) != 1 || changes[0].Kind != OverrideKindToggles || changes[0].Provider != "provider1" || changes[0].OldValue != "" || changes[0].NewValue != toggleDisabled || changes[0].ChangedBy != "key:oncall" {
+		t.Fatalf("history = %+v", changes)
+	}
+	target := changes[0].Revision
+
+	admin("POST", "/admin/providers/provider2/circuit/open")
+	admin("POST", "/admin/providers/provider2/disable")
+	admin("POST", "/admin/providers/provider1/enable")
+	admin("POST", "/admin/providers/provider1/disable")
+
+	got := admin("POST", "/admin/config/rollback/"+target)
+	if got.StatusCode != 200 {
+		t.Fatalf("rollback = %d %s", got.StatusCode, got.Body)
+	}
+	// provider1 is disabled again already, provider2's toggle and breaker go back
+	if toggles.values["provider1"] != toggleDisabled || toggles.values["provider2"] != "" || breakers.values["provider2"] != "" {
+		t.Errorf("after rolling back toggles = %v, breakers = %v", toggles.values, breakers.values)
+	}
+	json.Unmarshal([]byte(admin("GET", "/admin/config/history").Body), &changes)
+	if len(changes) != 7 || changes[0].Detail != "rollback to "+target || changes[1].Detail != "rollback to "+target || changes[2].Revision <= changes[3].Revision {
+		t.Errorf("history after rolling back = %+v", changes)
+	}
+
+	if got := admin("POST", "/admin/config/rollback/00000000000000000001"); got.StatusCode != 404 {
+		t.Errorf("unknown revision = %d %s, want 404", got.StatusCode, got.Body)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+/*
+  Contract tests. Every http provider configured in serverless.yml must have a contract in testdata/contracts/<name>.yaml
+  holding the request schema (an OpenAPI schema object), example requests and example responses published by the
+  partner. We check the request our template renders still matches what they accept and that we still pull the
+  right verdict out of their responses, so a partner schema change fails the build instead of production.
+*/
+
+const contractAccountNumber = "12345678"
+
+// The subset of an OpenAPI schema object we check against
+type Schema struct {
+	Type                 string
+	Required             []string
+	Properties           map[string]*Schema
+	Items                *Schema
+	Enum                 []interface{}
+	AdditionalProperties *bool `yaml:"additionalProperties"`
+}
+
+type Contract struct {
+	Request struct {
+		Schema   *Schema
+		Examples []string
+	}
+	Responses []struct {
+		Name    string
+		Body    string
+		IsValid bool `yaml:"isValid"`
+	}
+}
+
+// Checks a decoded json value against the schema, returning every violation found
+func (schema *Schema) check(path string, value interface{}) []string {
+	if schema == nil {
+		return nil
+	}
+	problems := []string{}
+	if schema.Type != "" && jsonType(value) != schema.Type && !(schema.Type == "number" && jsonType(value) == "integer") {
+		return append(problems, fmt.Sprintf("%s: expected %s, got %s", path, schema.Type, jsonType(value)))
+	}
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, allowed := range schema.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: %v is not one of %v", path, value, schema.Enum))
+		}
+	}
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for _, name := range schema.Required {
+			if _, exists := typed[name]; !exists {
+				problems = append(problems, fmt.Sprintf("%s: missing required field %s", path, name))
+			}
+		}
+		names := make([]string, 0, len(typed))
+		for name := range typed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, known := schema.Properties[name]
+			if !known && schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+				problems = append(problems, fmt.Sprintf("%s: unexpected field %s", path, name))
+			}
+			problems = append(problems, property.check(path+"."+name, typed[name])...)
+		}
+	case []interface{}:
+		for i, item := range typed {
+			problems = append(problems, schema.Items.check(fmt.Sprintf("%s[%d]", path, i), item)...)
+		}
+	}
+	return problems
+}
+
+// Like jsonType but doesn't care whether a number is whole, examples rarely do
+func shapeOf(value interface{}) string {
+	if jsonType(value) == "integer" {
+		return "number"
+	}
+	return jsonType(value)
+}
+
+func jsonType(value interface{}) string {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if typed == float64(int64(typed)) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "null"
+}
+
+// Checks a rendered request has the same fields (and field types) as a published example
+func sameShape(path string, got, example interface{}) []string {
+	if shapeOf(got) != shapeOf(example) {
+		return []string{fmt.Sprintf("%s: example is %s, we send %s", path, shapeOf(example), shapeOf(got))}
+	}
+	gotObject, ok := got.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	exampleObject := example.(map[string]interface{})
+	problems := []string{}
+	for name := range exampleObject {
+		if _, exists := gotObject[name]; !exists {
+			problems = append(problems, fmt.Sprintf("%s: example has %s, we don't send it", path, name))
+		}
+	}
+	for name, value := range gotObject {
+		exampleValue, exists := exampleObject[name]
+		if !exists {
+			problems = append(problems, fmt.Sprintf("%s: we send %s, example doesn't have it", path, name))
+			continue
+		}
+		problems = append(problems, sameShape(path+"."+name, value, exampleValue)...)
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// Reads the providers config out of serverless.yml so we test what actually gets deployed
+func deployedConfig(t *testing.T) *Config {
+	raw, err := os.ReadFile(filepath.Join("..", "serverless.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var serverless struct {
+		Provider struct {
+			Environment map[string]interface{}
+		}
+	}
+	if err := yaml.Unmarshal(raw, &serverless); err != nil {
+		t.Fatal(err)
+	}
+	providers, _ := serverless.Provider.Environment["PROVIDERS"].(string)
+	var config *Config
+	if err := yaml.Unmarshal([]byte(providers), &config); err != nil || config == nil {
+		t.Fatalf("PROVIDERS in serverless.yml is invalid: %v", err)
+	}
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func loadContract(t *testing.T, name string) *Contract {
+	raw, err := os.ReadFile(filepath.Join("testdata", "contracts", name+".yaml"))
+	if err != nil {
+		t.Fatalf("no contract for provider %s: %v", name, err)
+	}
+	var contract *Contract
+	if err := yaml.Unmarshal(raw, &contract); err != nil {
+		t.Fatalf("contract for %s is invalid yaml: %v", name, err)
+	}
+	return contract
+}
+
+func TestProviderContracts(t *testing.T) {
+	for _, provider := range deployedConfig(t).Providers {
+		provider := provider
+		if provider.Type == ProviderTypeSimulated {
+			continue
+		}
+		t.Run(provider.Name, func(t *testing.T) {
+			contract := loadContract(t, provider.Name)
+
+			body, err := provider.requestBody(TemplateData{AccountNumber: contractAccountNumber})
+			if err != nil {
+				t.Fatalf("unable to render request: %v", err)
+			}
+			var request interface{}
+			if err := json.Unmarshal(body, &request); err != nil {
+				t.Fatal(err)
+			}
+			for _, problem := range contract.Request.Schema.check("request", request) {
+				t.Errorf("request does not match schema: %s", problem)
+			}
+			for i, raw := range contract.Request.Examples {
+				var example interface{}
+				if err := json.Unmarshal([]byte(raw), &example); err != nil {
+					t.Fatalf("request example %d is invalid json: %v", i, err)
+				}
+				for _, problem := range sameShape("request", request, example) {
+					t.Errorf("request does not match example %d: %s", i, problem)
+				}
+			}
+
+			for _, response := range contract.Responses {
+				got, err := provider.extractValidity([]byte(response.Body))
+				if err != nil {
+					t.Errorf("response %s: %v", response.Name, err)
+				} else if got != response.IsValid {
+					t.Errorf("response %s: got isValid %v, want %v", response.Name, got, response.IsValid)
+				}
+			}
+		})
+	}
+}
+
+func TestSchema_check(t *testing.T) {
+	closed := false
+	schema := &Schema{
+		Type:                 "object",
+		Required:             []string{"account"},
+		AdditionalProperties: &closed,
+		Properties: map[string]*Schema{
+			"account": {Type: "string"},
+			"type":    {Type: "string", Enum: []interface{}{"current", "savings"}},
+		},
+	}
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{name: "ok", body: "{\"account\": \"1\", \"type\": \"current\"}", want: []string{}},
+		{name: "missing", body: "{}", want: []string{"request: missing required field account"}},
+		{name: "wrongType", body: "{\"account\": 1}", want: []string{"request.account: expected string, got integer"}},
+		{name: "extra", body: "{\"account\": \"1\", \"sortCode\": \"1\"}", want: []string{"request: unexpected field sortCode"}},
+		{name: "enum", body: "{\"account\": \"1\", \"type\": \"isa\"}", want: []string{"request.type: isa is not one of [current savings]"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.body), &value); err != nil {
+				t.Fatal(err)
+			}
+			got := schema.check("request", value)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+/*
+  Cost estimates. With unitCost set on providers (see usage.go) the dry run says what the request would cost, so
+  product teams can see what enabling another provider does to the bill before they do it:
+
+    {"valid": true, "providers": ["provider1", "provider2"], ...,
+     "estimatedCost": {"total": 0.08, "providers": [{"provider": "provider1", "cost": 0.05}, {"provider": "provider2", "cost": 0.03}]}}
+
+  Real responses can carry it too, for the providers that were called and billed us (see billing.go):
+
+    costs:
+      inResponses: true   # default false
+
+  Without a unitCost on any provider there's no estimate. Only http providers cost anything, simulated and SEPA
+  lookups and test accounts are free. Retries aren't known up front so the estimate is one call per provider.
+*/
+
+type CostsConfig struct {
+	InResponses bool `yaml:"inResponses"`
+}
+
+type CostEstimate struct {
+	Total     float64        `json:"total"`
+	Providers []ProviderCost `json:"providers"`
+}
+
+type ProviderCost struct {
+	Provider string  `json:"provider"`
+	Cost     float64 `json:"cost"`
+}
+
+// Whether calling the provider costs anything
+func billable(provider Provider) bool {
+	if provider.UnitCost <= 0 {
+		return false
+	}
+	_, adapter := adapterFor(provider.Type)
+	return adapter || provider.Type == "" || provider.Type == ProviderTypeHTTP
+}
+
+func (estimate *CostEstimate) add(provider Provider) {
+	estimate.Providers = append(estimate.Providers, ProviderCost{Provider: provider.Name, Cost: provider.UnitCost})
+	estimate.Total += provider.UnitCost
+}
+
+// Whether any provider has a unitCost, there's nothing to estimate otherwise
+func (config *Config) priced() bool {
+	for _, provider := range config.Providers {
+		if provider.UnitCost > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// What one call to each of the providers would cost
+func estimateCost(providers []Provider) *CostEstimate {
+	estimate := &CostEstimate{Providers: []ProviderCost{}}
+	for _, provider := range providers {
+		if billable(provider) {
+			estimate.add(provider)
+		}
+	}
+	return estimate
+}
+
+// What the providers that answered will bill for the response, nil unless costs.inResponses is on
+func (config *Config) responseCost(response BankAccountValidationResponse, testAccount bool) *CostEstimate {
+	if !config.Costs.InResponses {
+		return nil
+	}
+	estimate := &CostEstimate{Providers: []ProviderCost{}}
+	if testAccount {
+		return estimate
+	}
+	index := providerIndex(config.Providers)
+	for _, result := range response.Result {
+		i, exists := index[providerKey(result.Provider)]
+		if result.Local || !exists {
+			continue
+		}
+		if provider := config.Providers[i]; billable(provider) && chargeable(provider, result) {
+			estimate.add(provider)
+		}
+	}
+	return estimate
+}
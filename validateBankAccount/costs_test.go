@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestConfig_dryRun_estimatedCost(t *testing.T) {
+	config := &Config{
+		Providers: []Provider{
+			{Name: "provider1", URL: "https://provider1.com", UnitCost: 0.05},
+			{Name: "provider2", URL: "https://provider2.com", UnitCost: 0.25},
+			{Name: "provider3", Type: ProviderTypeSimulated, UnitCost: 1},
+		},
+		TestAccounts: []TestAccount{{AccountNumber: "00000001", IsValid: true}},
+	}
+	tests := []struct {
+		name string
+		body string
+		want *CostEstimate
+	}{
+		{"all", "{\"accountNumber\": \"12345678\"}", &CostEstimate{Total: 0.3, Providers: []ProviderCost{{"provider1", 0.05}, {"provider2", 0.25}}}},
+		{"filtered", "{\"accountNumber\": \"12345678\", \"providers\": [\"provider2\", \"provider3\"]}", &CostEstimate{Total: 0.25, Providers: []ProviderCost{{"provider2", 0.25}}}},
+		{"testAccount", "{\"accountNumber\": \"00000001\"}", &CostEstimate{Providers: []ProviderCost{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := config.dryRun(context.Background(), Request{Body: tt.body}).EstimatedCost; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dryRun() estimatedCost = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Handler_estimatedCost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer server.Close()
+	config := &Config{
+		Providers: []Provider{
+			{Name: "provider1", URL: server.URL, UnitCost: 0.05},
+			// Nothing listening, so it never answers or bills
+			{Name: "provider2", URL: "http://127.0.0.1:1", UnitCost: 0.25},
+		},
+		Costs: CostsConfig{InResponses: true},
+	}
+	config.setupProviders()
+	response, err := config.Handler(context.Background(), Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\"}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true},{\"provider\":\"provider2\",\"isValid\":false,\"error\":\"request_failed\"}]," +
+		"\"estimatedCost\":{\"total\":0.05,\"providers\":[{\"provider\":\"provider1\",\"cost\":0.05}]}}"
+	if response.Body != want {
+		t.Errorf("Handler() = %s, want %s", response.Body, want)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+/*
+  Caching DNS resolver for provider hostnames. Cold start lookups inside a VPC have blown the latency budget more than
+  once, so we resolve every provider host during init, keep the answers for refreshSeconds and bound each lookup by
+  timeoutMs. If a refresh fails or times out we keep using the last good answer rather than failing the call.
+
+    dns:
+      refreshSeconds: 60
+      timeoutMs: 200
+*/
+
+type DNSConfig struct {
+	RefreshSeconds int `yaml:"refreshSeconds"`
+	TimeoutMs      int `yaml:"timeoutMs"`
+}
+
+const (
+	defaultDNSRefresh = 60 * time.Second
+	defaultDNSTimeout = 500 * time.Millisecond
+	dialTimeout       = 500 * time.Millisecond
+)
+
+// Shared by every provider call so connections get reused. Replaced at init by setupTransport.
+var providerTransport http.RoundTripper = http.DefaultTransport
+
+type dnsEntry struct {
+	addrs      []string
+	resolvedAt time.Time
+}
+
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+	refresh time.Duration
+	timeout time.Duration
+	lookup  func(ctx context.Context, host string) ([]string, error)
+	now     func() time.Time
+}
+
+func newDNSCache(config DNSConfig) *dnsCache {
+	cache := &dnsCache{
+		entries: map[string]dnsEntry{},
+		refresh: time.Duration(config.RefreshSeconds) * time.Second,
+		timeout: time.Duration(config.TimeoutMs) * time.Millisecond,
+		lookup:  net.DefaultResolver.LookupHost,
+		now:     time.Now,
+	}
+	if cache.refresh <= 0 {
+		cache.refresh = defaultDNSRefresh
+	}
+	if cache.timeout <= 0 {
+		cache.timeout = defaultDNSTimeout
+	}
+	return cache
+}
+
+func (cache *dnsCache) resolve(ctx context.Context, host string) ([]string, error) {
+	cache.mu.Lock()
+	entry, cached := cache.entries[host]
+	cache.mu.Unlock()
+	if cached && cache.now().Sub(entry.resolvedAt) < cache.refresh {
+		return entry.addrs, nil
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, cache.timeout)
+	defer cancel()
+	addrs, err := cache.lookup(lookupCtx, host)
+	if err != nil || len(addrs) == 0 {
+		if cached {
+			log.Printf("dns refresh for %s failed, using cached answer: %v", host, err)
+			return entry.addrs, nil
+		}
+		if err == nil {
+			err = errors.New("no addresses for " + host)
+		}
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	cache.entries[host] = dnsEntry{addrs: addrs, resolvedAt: cache.now()}
+	cache.mu.Unlock()
+	return addrs, nil
+}
+
+// Resolves every host up front, in parallel, so the first real request doesn't pay for it
+func (cache *dnsCache) warm(hosts []string) {
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			if _, err := cache.resolve(context.Background(), host); err != nil {
+				log.Printf("unable to resolve %s: %v", host, err)
+			}
+		}(host)
+	}
+	wg.Wait()
+}
+
+// Dials using the cache, trying each address in turn
+func (cache *dnsCache) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		addrs, err := cache.resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// Every hostname we might call
+func (config *Config) providerHosts() []string {
+	seen := map[string]bool{}
+	hosts := []string{}
+	for _, provider := range config.Providers {
+		for _, raw := range append(provider.endpointURLs(), provider.HealthURL) {
+			parsed, err := url.Parse(raw)
+			if err != nil || parsed.Hostname() == "" || net.ParseIP(parsed.Hostname()) != nil || seen[parsed.Hostname()] {
+				continue
+			}
+			seen[parsed.Hostname()] = true
+			hosts = append(hosts, parsed.Hostname())
+		}
+	}
+	return hosts
+}
+
+// Builds the shared provider transport on top of the dns cache and tls policy, then warms the cache
+func (config *Config) setupTransport() {
+	cache := newDNSCache(config.DNS)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = cache.dialContext(&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second})
+	transport.MaxIdleConnsPerHost = idleConnsPerHost()
+	transport.TLSClientConfig = config.tlsClientConfig()
+	// Lets the warm path's handshakes be resumed by real calls
+	transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	providerTransport = transport
+	cache.warm(config.providerHosts())
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeResolver struct {
+	answers map[string][]string
+	fail    bool
+	calls   int
+}
+
+func (resolver *fakeResolver) lookup(ctx context.Context, host string) ([]string, error) {
+	resolver.calls++
+	if resolver.fail {
+		return nil, errors.New("i/o timeout")
+	}
+	return resolver.answers[host], nil
+}
+
+func newTestDNSCache(resolver *fakeResolver) (*dnsCache, *time.Time) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := newDNSCache(DNSConfig{RefreshSeconds: 60})
+	cache.lookup = resolver.lookup
+	cache.now = func() time.Time { return now }
+	return cache, &now
+}
+
+func Test_dnsCache_resolve(t *testing.T) {
+	resolver := &fakeResolver{answers: map[string][]string{"provider1.com": {"10.0.0.1"}}}
+	cache, now := newTestDNSCache(resolver)
+
+	for i := 0; i < 3; i++ {
+		got, err := cache.resolve(context.Background(), "provider1.com")
+		if err != nil || !reflect.DeepEqual(got, []string{"10.0.0.1"}) {
+			t.Fatalf("resolve() = %v, %v", got, err)
+		}
+	}
+	if resolver.calls != 1 {
+		t.Errorf("expected one lookup inside the refresh interval, got %d", resolver.calls)
+	}
+
+	*now = now.Add(61 * time.Second)
+	resolver.answers["provider1.com"] = []string{"10.0.0.2"}
+	if got, _ := cache.resolve(context.Background(), "provider1.com"); !reflect.DeepEqual(got, []string{"10.0.0.2"}) {
+		t.Errorf("resolve() = %v, want the refreshed answer", got)
+	}
+
+	*now = now.Add(61 * time.Second)
+	resolver.fail = true
+	if got, err := cache.resolve(context.Background(), "provider1.com"); err != nil || !reflect.DeepEqual(got, []string{"10.0.0.2"}) {
+		t.Errorf("resolve() = %v, %v, want the stale answer when refresh fails", got, err)
+	}
+	if _, err := cache.resolve(context.Background(), "provider2.com"); err == nil {
+		t.Errorf("resolve() should fail for an uncached host when lookup fails")
+	}
+}
+
+func Test_dnsCache_dialContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	resolver := &fakeResolver{answers: map[string][]string{"provider1.internal": {"127.0.0.2", "127.0.0.1"}}}
+	cache, _ := newTestDNSCache(resolver)
+	transport := &http.Transport{DialContext: cache.dialContext(&net.Dialer{Timeout: 100 * time.Millisecond})}
+	client := http.Client{Transport: transport, Timeout: time.Second}
+
+	response, err := client.Get("http://provider1.internal:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if resolver.calls != 1 {
+		t.Errorf("expected the dial to go through the cache")
+	}
+}
+
+func TestConfig_providerHosts(t *testing.T) {
+	config := &Config{Providers: []Provider{
+		{Name: "provider1", URL: "https://provider1.com/validate", HealthURL: "https://provider1.com/health", Endpoints: []string{"https://us.provider1.com/validate"}},
+		{Name: "provider2", URL: "http://127.0.0.1:8080/validate"},
+		{Name: "sim", Type: ProviderTypeSimulated},
+	}}
+	if got, want := config.providerHosts(), []string{"provider1.com", "us.provider1.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("providerHosts() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+)
+
+/*
+  POST /validate-request takes the same body as a validation request and says what we would do with it without
+  calling anything: whether it parses, whether the caller may make it (entitlement, admission, no providers and
+  fan-out, the same as a batch item, see itemError), which providers would be called for this caller (and which of
+  the names asked for don't exist), which would be skipped because their breaker is open, the strategy, whether the
+  account is a test account, what the calls would cost (see costs.go) and where the caller stands against its rate
+  limit. Client teams can integrate against it before they have provider credentials.
+
+    {"valid": true, "providers": ["provider1"], "unknownProviders": ["provider9"], "skipped": [{"provider": "provider2", "reason": "circuit_open"}], "strategy": "majority",
+     "rateLimit": {"limit": 600, "remaining": 597, "reset": "2024-06-01T09:01:00Z"}}
+
+  The dry run goes through the router's checks like anything else, so it counts against the rate limit it reports
+  and a caller over its limit gets the 429 rather than a dry run.
+*/
+
+type DryRunResult struct {
+	Valid            bool                          `json:"valid"`
+	Error            string                        `json:"error,omitempty"`
+	Request          *BankAccountValidationRequest `json:"request,omitempty"`
+	Providers        []string                      `json:"providers"`
+	UnknownProviders []string                      `json:"unknownProviders,omitempty"`
+	Skipped          []SkippedProvider             `json:"skipped,omitempty"`
+	Strategy         string                        `json:"strategy,omitempty"`
+	TestAccount      bool                          `json:"testAccount"`
+	// One call to each of the providers, see costs.go
+	EstimatedCost *CostEstimate `json:"estimatedCost,omitempty"`
+	// Where the caller stands after this request, when it has a limit
+	RateLimit *RateLimitStatus `json:"rateLimit,omitempty"`
+}
+
+type SkippedProvider struct {
+	Provider string `json:"provider"`
+	Reason   string `json:"reason"`
+}
+
+// Entries in the filter that don't match a configured provider
+func unknownProviders(providers []Provider, filter *[]string) []string {
+	if filter == nil {
+		return nil
+	}
+	index := providerIndex(providers)
+	unknown := []string{}
+	for _, name := range *filter {
+		if len(selectProviders(providers, index, name)) == 0 {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	return unknown
+}
+
+func (config *Config) dryRun(ctx context.Context, request Request) DryRunResult {
+	validationRequest, message, err := decodeRequest(request)
+	if err != nil {
+		return DryRunResult{Error: message, Providers: []string{}, RateLimit: rateLimitFrom(ctx)}
+	}
+	result := DryRunResult{
+		Valid:            true,
+		Request:          validationRequest,
+		Providers:        []string{},
+		UnknownProviders: unknownProviders(config.Providers, validationRequest.Providers),
+		RateLimit:        rateLimitFrom(ctx),
+	}
+	if message := config.itemError(ctx, request, validationRequest); message != "" {
+		result.Valid, result.Error = false, message
+	}
+	if validationRequest.Strategy != nil {
+		result.Strategy = *validationRequest.Strategy
+	}
+	_, result.TestAccount = config.testAccount(*validationRequest.AccountNumber)
+	details := validationRequest.accountDetails()
+	called := []Provider{}
+	providers, _ := config.capFanOut(request, config.selectedProviders(ctx, validationRequest))
+	for _, provider := range providers {
+		if !provider.supports(details) {
+			result.Skipped = append(result.Skipped, SkippedProvider{Provider: provider.Name, Reason: ProviderSkippedUnsupported})
+			continue
+		}
+		if !result.TestAccount && provider.Type != ProviderTypeSimulated && provider.Type != ProviderTypeSEPA && !provider.breaker.wouldAllow() {
+			result.Skipped = append(result.Skipped, SkippedProvider{Provider: provider.Name, Reason: ProviderErrorCircuitOpen})
+			continue
+		}
+		result.Providers = append(result.Providers, provider.Name)
+		called = append(called, provider)
+	}
+	if result.TestAccount {
+		called = nil
+	}
+	if config.priced() {
+		result.EstimatedCost = estimateCost(called)
+	}
+	return result
+}
+
+// Handler for POST /validate-request, always a 200 with valid saying whether the request would be accepted
+func (config *Config) DryRunHandler(ctx context.Context, request Request) (Response, error) {
+	return jsonResponse(200, config.dryRun(ctx, request))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfig_dryRun(t *testing.T) {
+	open := newCircuitBreaker(BreakerConfig{})
+	open.openSince(time.Now())
+	config := &Config{
+		Providers: []Provider{
+			{Name: "provider1", URL: "https://provider1.com"},
+			{Name: "provider2", URL: "https://provider2.com", breaker: open},
+		},
+		TestAccounts: []TestAccount{{AccountNumber: "00000001", IsValid: true}},
+	}
+	accountNumber := "12345678"
+	testAccountNumber := "00000001"
+	filter := []string{"provider1", "provider9"}
+	strategy := StrategyAny
+
+	tests := []struct {
+		name string
+		body string
+		want DryRunResult
+	}{
+		{name: "all",
+			body: "{\"accountNumber\": \"12345678\"}",
+			want: DryRunResult{
+				Valid:     true,
+				Request:   &BankAccountValidationRequest{AccountNumber: &accountNumber},
+				Providers: []string{"provider1"},
+				Skipped:   []SkippedProvider{{Provider: "provider2", Reason: ProviderErrorCircuitOpen}},
+			},
+		},
+		{name: "filtered",
+			body: "{\"accountNumber\": \"12345678\", \"providers\": [\"provider1\", \"provider9\"], \"strategy\": \"any\"}",
+			want: DryRunResult{
+				Valid:            true,
+				Request:          &BankAccountValidationRequest{AccountNumber: &accountNumber, Providers: &filter, Strategy: &strategy},
+				Providers:        []string{"provider1"},
+				UnknownProviders: []string{"provider9"},
+				Strategy:         StrategyAny,
+			},
+		},
+		{name: "testAccount",
+			body: "{\"accountNumber\": \"00000001\"}",
+			want: DryRunResult{
+				Valid:       true,
+				Request:     &BankAccountValidationRequest{AccountNumber: &testAccountNumber},
+				Providers:   []string{"provider1", "provider2"},
+				TestAccount: true,
+			},
+		},
+		{name: "missingAccount",
+			body: "{}",
+			want: DryRunResult{Error: "account number missing from payload", Providers: []string{}},
+		},
+		{name: "badStrategy",
+			body: "{\"accountNumber\": \"12345678\", \"strategy\": \"most\"}",
+			want: DryRunResult{Error: "unknown strategy most", Providers: []string{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := config.dryRun(context.Background(), Request{Body: tt.body}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dryRun() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if open.wouldAllow() || open.allow() {
+		t.Errorf("dryRun() shouldn't have touched the open breaker")
+	}
+}
+
+func TestConfig_Router_dryRun(t *testing.T) {
+	now := time.Unix(1717232430, 0)
+	config := authorizedConfig()
+	config.RateLimit = &RateLimitConfig{RequestsPerMinute: 10}
+	config.rateLimiter = &rateLimiter{limits: *config.RateLimit, counter: newLocalCounter(), now: func() time.Time { return now }}
+	dryRun := func(scope, body string) DryRunResult {
+		request := scopedRequest(scope, body)
+		request.Path = "/validate-request"
+		response, err := config.Router(context.Background(), request)
+		if err != nil || response.StatusCode != 200 {
+			t.Fatalf("Router() = %v, %v", response, err)
+		}
+		var result DryRunResult
+		if err := json.Unmarshal([]byte(response.Body), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// What this caller would get, not everything configured
+	result := dryRun("validate/basic", `{"accountNumber": "12345670"}`)
+	if !result.Valid || !reflect.DeepEqual(result.Providers, []string{"provider1"}) {
+		t.Errorf("dryRun() = %+v, want provider1", result)
+	}
+	if limit := result.RateLimit; limit == nil || limit.Limit != 10 || limit.Remaining != 9 || !limit.Reset.Equal(time.Unix(1717232460, 0)) {
+		t.Errorf("dryRun() rateLimit = %+v, want 9 of 10 left until the next minute", limit)
+	}
+	result = dryRun("validate/basic", `{"accountNumber": "12345670", "strategy": "all"}`)
+	if result.Valid || result.Error == "" || result.RateLimit.Remaining != 8 {
+		t.Errorf("dryRun() = %+v, want the strategy turned away", result)
+	}
+}
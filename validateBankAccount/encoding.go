@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+/*
+  JSON on the hot path. Batches push thousands of requests and responses through here per invocation, so rather than
+  json.Marshal followed by json.HTMLEscape (two allocated copies of every body) responses are encoded straight into a
+  buffer from a pool.
+
+  Everything we send, response bodies, batch lines, events and the messages we publish, goes through the encoder here
+  so the settings are the same everywhere. Nothing we return is rendered as HTML, so escaping is off unless asked for,
+  and a provider called "Smith & Co" comes back as that rather than "Smith \u0026 Co". Pretty printing is for
+  debugging, it only applies to response bodies, never to the line based formats (batch lines, SSE, metrics) it
+  would break.
+
+    encoding:
+      escapeHtml: true   # escape <, > and & as \u003c etc, default false
+      pretty: true       # indent response bodies, default false
+
+  Requests are decoded with a json.Decoder that rejects fields we don't know, so a typo like "acountNumber" is a 400
+  style error rather than being quietly ignored. With binary media types turned on in API Gateway a body can arrive
+  base64 encoded (IsBase64Encoded), it's decoded before anything looks at it.
+*/
+
+type EncodingConfig struct {
+	EscapeHTML bool `yaml:"escapeHtml"`
+	Pretty     bool `yaml:"pretty"`
+}
+
+// Settings every encoder uses, set from the config at startup
+var jsonEncoding EncodingConfig
+
+func (config *Config) setupEncoding() {
+	jsonEncoding = config.Encoding
+}
+
+// Buffers bigger than this aren't kept, one huge response shouldn't pin its memory for the life of the container
+const maxPooledBuffer = 64 << 10
+
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+// Encoders are pooled with their settings applied, turning HTML escaping off allocates in encoding/json and this runs
+// for every line of a batch. Each writes to whatever buffer it's lent to.
+type pooledEncoder struct {
+	out      *bytes.Buffer
+	settings EncodingConfig
+	line     *json.Encoder
+	body     *json.Encoder
+}
+
+func (encoder *pooledEncoder) Write(p []byte) (int, error) {
+	return encoder.out.Write(p)
+}
+
+var encoderPool = sync.Pool{New: func() interface{} { return new(pooledEncoder) }}
+
+// The one place encoders are made. Only response bodies can be indented.
+func (encoder *pooledEncoder) setup() {
+	encoder.settings = jsonEncoding
+	encoder.line, encoder.body = json.NewEncoder(encoder), json.NewEncoder(encoder)
+	encoder.line.SetEscapeHTML(jsonEncoding.EscapeHTML)
+	encoder.body.SetEscapeHTML(jsonEncoding.EscapeHTML)
+	if jsonEncoding.Pretty {
+		encoder.body.SetIndent("", "  ")
+	}
+}
+
+func encodeInto(buf *bytes.Buffer, value interface{}, body bool) error {
+	pooled := encoderPool.Get().(*pooledEncoder)
+	if pooled.line == nil || pooled.settings != jsonEncoding {
+		pooled.setup()
+	}
+	encoder := pooled.line
+	if body {
+		encoder = pooled.body
+	}
+	pooled.out = buf
+	err := encoder.Encode(value)
+	pooled.out = nil
+	encoderPool.Put(pooled)
+	if err != nil {
+		return err
+	}
+	// Drop the newline the encoder adds
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// Encodes the value into buf on one line
+func writeJSON(buf *bytes.Buffer, value interface{}) error {
+	return encodeInto(buf, value, false)
+}
+
+// Serialises a response body
+func encodeJSON(value interface{}) (string, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := encodeInto(buf, value, true); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Serialises a message or event on one line
+func marshalJSON(value interface{}) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := writeJSON(buf, value); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// The request with its body as the caller sent it, decoded if API Gateway base64 encoded it
+func decodeBody(request Request) (Request, error) {
+	if !request.IsBase64Encoded {
+		return request, nil
+	}
+	body, err := base64.StdEncoding.DecodeString(request.Body)
+	if err != nil {
+		return request, fmt.Errorf("base64 encoded body didn't decode: %w", err)
+	}
+	request.Body, request.IsBase64Encoded = string(body), false
+	return request, nil
+}
+
+// Decodes a body into value, failing on unknown fields or anything after the value
+func decodeJSON(body string, value interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("unexpected data after the json payload")
+	}
+	return nil
+}
+
+// What we tell the caller about a body that didn't decode
+func decodeMessage(err error) string {
+	if message := strings.TrimPrefix(err.Error(), "json: "); strings.HasPrefix(message, "unknown field ") {
+		return message + " in payload"
+	}
+	return "invalid json payload"
+}
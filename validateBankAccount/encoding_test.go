@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_encodeJSON(t *testing.T) {
+	defer func() { jsonEncoding = EncodingConfig{} }()
+	value := map[string]interface{}{"provider": "Smith & Co <ltd>", "ok": []bool{true}}
+	tests := []struct {
+		name     string
+		encoding EncodingConfig
+		want     string
+	}{
+		{name: "default", want: "{\"ok\":[true],\"provider\":\"Smith & Co <ltd>\"}"},
+		{name: "escapeHtml", encoding: EncodingConfig{EscapeHTML: true}, want: "{\"ok\":[true],\"provider\":\"Smith \\u0026 Co \\u003cltd\\u003e\"}"},
+		{name: "pretty", encoding: EncodingConfig{Pretty: true}, want: "{\n  \"ok\": [\n    true\n  ],\n  \"provider\": \"Smith & Co <ltd>\"\n}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonEncoding = tt.encoding
+			// Twice so the second one gets a buffer back from the pool
+			for i := 0; i < 2; i++ {
+				if got, err := encodeJSON(value); err != nil || got != tt.want {
+					t.Errorf("encodeJSON() = %v, %v, want %v", got, err, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_marshalJSON_neverPretty(t *testing.T) {
+	jsonEncoding = EncodingConfig{Pretty: true, EscapeHTML: true}
+	defer func() { jsonEncoding = EncodingConfig{} }()
+	got, err := marshalJSON(map[string]string{"a": "<b>"})
+	if err != nil || string(got) != "{\"a\":\"\\u003cb\\u003e\"}" {
+		t.Errorf("marshalJSON() = %s, %v", got, err)
+	}
+}
+
+func Test_encodeJSON_error(t *testing.T) {
+	if _, err := encodeJSON(map[string]interface{}{"bad": make(chan int)}); err == nil {
+		t.Error("expected an error for a value json can't encode")
+	}
+}
+
+func Test_writeBatchResult(t *testing.T) {
+	var buf bytes.Buffer
+	writeBatchResult(&buf, BatchResult{Index: 0, Error: "a < b"})
+	writeBatchResult(&buf, BatchResult{Index: 1, Response: &BankAccountValidationResponse{}})
+	want := "{\"index\":0,\"error\":\"a < b\"}\n{\"index\":1,\"response\":{\"result\":null}}\n"
+	if buf.String() != want {
+		t.Errorf("writeBatchResult() = %q, want %q", buf.String(), want)
+	}
+}
+
+func Test_decodeJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantErr     bool
+		wantMessage string
+	}{
+		{name: "valid", body: "{\"accountNumber\": \"12345678\"}"},
+		{name: "whitespaceAfter", body: "{\"accountNumber\": \"12345678\"}\n  "},
+		{name: "unknownField", body: "{\"acountNumber\": \"12345678\"}", wantErr: true, wantMessage: "unknown field \"acountNumber\" in payload"},
+		{name: "trailingData", body: "{\"accountNumber\": \"12345678\"}x", wantErr: true, wantMessage: "invalid json payload"},
+		{name: "broken", body: "{\"accountNumber\": ", wantErr: true, wantMessage: "invalid json payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request *BankAccountValidationRequest
+			err := decodeJSON(tt.body, &request)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && decodeMessage(err) != tt.wantMessage {
+				t.Errorf("decodeMessage() = %v, want %v", decodeMessage(err), tt.wantMessage)
+			}
+		})
+	}
+}
+
+func Test_decodeBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		request Request
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", request: Request{Body: "{\"accountNumber\": \"12345678\"}"}, want: "{\"accountNumber\": \"12345678\"}"},
+		{name: "base64", request: Request{IsBase64Encoded: true, Body: "eyJhY2NvdW50TnVtYmVyIjogIjEyMzQ1Njc4In0="}, want: "{\"accountNumber\": \"12345678\"}"},
+		{name: "empty", request: Request{IsBase64Encoded: true}, want: ""},
+		{name: "notBase64", request: Request{IsBase64Encoded: true, Body: "{\"accountNumber\": \"12345678\"}"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBody(tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeBody() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got.Body != tt.want || got.IsBase64Encoded) {
+				t.Errorf("decodeBody() = %q base64 %v, want %q", got.Body, got.IsBase64Encoded, tt.want)
+			}
+		})
+	}
+}
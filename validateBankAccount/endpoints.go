@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+  Latency based endpoint selection for providers with more than one endpoint:
+
+    - name: provider1
+      url: https://eu.provider1.com/validate
+      endpoints:
+      - https://us.provider1.com/validate
+      reprobeSeconds: 30
+
+  We keep a moving average of latency per endpoint and send each call to the fastest healthy one. Endpoints we haven't
+  used for reprobeSeconds get the next call, so a slow endpoint that recovers gets noticed.
+*/
+
+const (
+	defaultReprobeInterval = 30 * time.Second
+	// Weight of the newest sample in the moving average
+	latencyAlpha = 0.3
+	// Consecutive failures before an endpoint is considered unhealthy
+	endpointUnhealthyAfter = 3
+)
+
+type endpointStats struct {
+	url      string
+	latency  time.Duration
+	sampled  bool
+	failures int
+	lastUsed time.Time
+}
+
+type endpointSelector struct {
+	mu              sync.Mutex
+	endpoints       []*endpointStats
+	reprobeInterval time.Duration
+	now             func() time.Time
+}
+
+func newEndpointSelector(urls []string, reprobeInterval time.Duration) *endpointSelector {
+	if reprobeInterval <= 0 {
+		reprobeInterval = defaultReprobeInterval
+	}
+	selector := &endpointSelector{reprobeInterval: reprobeInterval, now: time.Now}
+	for _, url := range urls {
+		selector.endpoints = append(selector.endpoints, &endpointStats{url: url})
+	}
+	return selector
+}
+
+// Every url the provider can be reached on, the primary first
+func (provider Provider) endpointURLs() []string {
+	urls := []string{}
+	if provider.URL != "" {
+		urls = append(urls, provider.URL)
+	}
+	for _, url := range provider.Endpoints {
+		if url != provider.URL {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// The url to use for the next call
+func (provider Provider) endpoint() string {
+	if provider.endpoints == nil {
+		return provider.URL
+	}
+	return provider.endpoints.pick()
+}
+
+func (selector *endpointSelector) pick() string {
+	selector.mu.Lock()
+	defer selector.mu.Unlock()
+	now := selector.now()
+
+	var best *endpointStats
+	for _, endpoint := range selector.endpoints {
+		// Never tried, try it so we have something to compare
+		if !endpoint.sampled {
+			endpoint.lastUsed = now
+			return endpoint.url
+		}
+		if best == nil || endpoint.better(best) {
+			best = endpoint
+		}
+	}
+	for _, endpoint := range selector.endpoints {
+		if endpoint != best && now.Sub(endpoint.lastUsed) >= selector.reprobeInterval {
+			endpoint.lastUsed = now
+			return endpoint.url
+		}
+	}
+	best.lastUsed = now
+	return best.url
+}
+
+// Healthy beats unhealthy, then the lowest average latency wins
+func (endpoint *endpointStats) better(other *endpointStats) bool {
+	healthy := endpoint.failures < endpointUnhealthyAfter
+	otherHealthy := other.failures < endpointUnhealthyAfter
+	if healthy != otherHealthy {
+		return healthy
+	}
+	if !healthy {
+		return endpoint.failures < other.failures
+	}
+	return endpoint.latency < other.latency
+}
+
+// A failed call counts towards latency too, a timing out endpoint should look slow
+func (selector *endpointSelector) record(url string, latency time.Duration, failed bool) {
+	if selector == nil {
+		return
+	}
+	selector.mu.Lock()
+	defer selector.mu.Unlock()
+	for _, endpoint := range selector.endpoints {
+		if endpoint.url != url {
+			continue
+		}
+		if !endpoint.sampled {
+			endpoint.latency = latency
+			endpoint.sampled = true
+		} else {
+			endpoint.latency = time.Duration(latencyAlpha*float64(latency) + (1-latencyAlpha)*float64(endpoint.latency))
+		}
+		if failed {
+			endpoint.failures++
+		} else {
+			endpoint.failures = 0
+		}
+		return
+	}
+}
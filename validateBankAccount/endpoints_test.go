@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestSelector(urls ...string) (*endpointSelector, *time.Time) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	selector := newEndpointSelector(urls, 30*time.Second)
+	selector.now = func() time.Time { return now }
+	return selector, &now
+}
+
+func Test_endpointSelector_prefersFastest(t *testing.T) {
+	selector, now := newTestSelector("https://a", "https://b")
+
+	// Both get tried once before we have anything to compare
+	if got := selector.pick(); got != "https://a" {
+		t.Fatalf("pick() = %v, want https://a", got)
+	}
+	selector.record("https://a", 300*time.Millisecond, false)
+	if got := selector.pick(); got != "https://b" {
+		t.Fatalf("pick() = %v, want https://b", got)
+	}
+	selector.record("https://b", 50*time.Millisecond, false)
+
+	for i := 0; i < 3; i++ {
+		*now = now.Add(time.Second)
+		if got := selector.pick(); got != "https://b" {
+			t.Fatalf("pick() = %v, want the fastest endpoint", got)
+		}
+		selector.record("https://b", 50*time.Millisecond, false)
+	}
+
+	// a hasn't been used for 30s, it gets a reprobe
+	*now = now.Add(30 * time.Second)
+	if got := selector.pick(); got != "https://a" {
+		t.Fatalf("pick() = %v, want a reprobe of https://a", got)
+	}
+	for i := 0; i < 6; i++ {
+		selector.record("https://a", 10*time.Millisecond, false)
+	}
+	// b is now the slower one and is also due a reprobe
+	if got := selector.pick(); got != "https://b" {
+		t.Fatalf("pick() = %v, want a reprobe of https://b", got)
+	}
+	selector.record("https://b", 50*time.Millisecond, false)
+	if got := selector.pick(); got != "https://a" {
+		t.Errorf("pick() = %v, want https://a once it has recovered", got)
+	}
+}
+
+func Test_endpointSelector_avoidsUnhealthy(t *testing.T) {
+	selector, _ := newTestSelector("https://a", "https://b")
+	selector.pick()
+	selector.pick()
+	selector.record("https://b", 200*time.Millisecond, false)
+	for i := 0; i < endpointUnhealthyAfter; i++ {
+		selector.record("https://a", 5*time.Millisecond, true)
+	}
+	if got := selector.pick(); got != "https://b" {
+		t.Errorf("pick() = %v, want the healthy endpoint even though it is slower", got)
+	}
+}
+
+func TestProvider_endpointURLs(t *testing.T) {
+	provider := Provider{URL: "https://a", Endpoints: []string{"https://a", "https://b"}}
+	if got, want := provider.endpointURLs(), []string{"https://a", "https://b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("endpointURLs() = %v, want %v", got, want)
+	}
+	if got := (Provider{URL: "https://a"}).endpoint(); got != "https://a" {
+		t.Errorf("endpoint() = %v, want the url for a single endpoint provider", got)
+	}
+}
+
+func Test_checkProviders_multipleEndpoints(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer up.Close()
+
+	config := &Config{
+		Providers:      []Provider{{Name: "provider1", URL: down.URL, Endpoints: []string{up.URL}}},
+		CircuitBreaker: BreakerConfig{FailureThreshold: 100},
+	}
+	config.setupProviders()
+
+	var last BankAccountValidationResponse
+	for i := 0; i < endpointUnhealthyAfter+2; i++ {
+		last = checkProviders(context.Background(), "12345678", config.Providers)
+	}
+	want := BankAccountValidationResponse{Result: []BankAccountValidationResult{{Provider: "provider1", IsValid: true}}}
+	if !reflect.DeepEqual(last, want) {
+		t.Errorf("checkProviders() = %v, want %v", last, want)
+	}
+}
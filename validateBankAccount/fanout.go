@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+/*
+  Fan-out cap. Every provider a request goes to costs latency budget and partner quota, so with 30 providers
+  configured nobody should be able to ask all of them at once:
+
+    fanOut:
+      maxProviders: 5      # the most providers one request goes to
+      overflow: truncate   # reject (the default) or truncate
+      tenants:             # by the tenant claim, see billing.go, these replace maxProviders for the tenant
+        acme: 10
+
+  Rejecting is a 422 {"error": "request fans out to 8 providers, the most is 5"}, and a batch item gets the same
+  error. The routes that can't reject (graphql, websocket, the streaming modes) truncate. Truncating calls the
+  first maxProviders in the order they're configured and adds a warning. It's counted after the request's
+  providers, the account details and the caller's entitlement have narrowed things down, so a request that doesn't
+  name providers is capped too.
+*/
+
+const (
+	FanOutReject   = "reject"
+	FanOutTruncate = "truncate"
+)
+
+type FanOutConfig struct {
+	MaxProviders int            `yaml:"maxProviders"`
+	Overflow     string         `yaml:"overflow"`
+	Tenants      map[string]int `yaml:"tenants"`
+}
+
+func (fanOut FanOutConfig) validate() error {
+	if fanOut.MaxProviders < 0 {
+		return errors.New("fanOut maxProviders can't be negative")
+	}
+	if fanOut.Overflow != "" && fanOut.Overflow != FanOutReject && fanOut.Overflow != FanOutTruncate {
+		return fmt.Errorf("fanOut overflow %s isn't reject or truncate", fanOut.Overflow)
+	}
+	for tenant, max := range fanOut.Tenants {
+		if max < 1 {
+			return fmt.Errorf("fanOut for tenant %s should be at least 1", tenant)
+		}
+	}
+	return nil
+}
+
+// The most providers the tenant's requests go to, 0 for no limit
+func (fanOut FanOutConfig) limit(tenant string) int {
+	if max, exists := fanOut.Tenants[tenant]; exists && tenant != "" {
+		return max
+	}
+	return fanOut.MaxProviders
+}
+
+// Why the request goes to too many providers, nil when it doesn't or they'll be truncated
+func (config *Config) fanOutError(request Request, providers []Provider) error {
+	max := config.FanOut.limit(config.tenant(request))
+	if max == 0 || len(providers) <= max || config.FanOut.Overflow == FanOutTruncate {
+		return nil
+	}
+	return fmt.Errorf("request fans out to %d providers, the most is %d", len(providers), max)
+}
+
+// The providers to call once truncated, with the warning when any were dropped
+func (config *Config) capFanOut(request Request, providers []Provider) ([]Provider, string) {
+	max := config.FanOut.limit(config.tenant(request))
+	if max == 0 || len(providers) <= max {
+		return providers, ""
+	}
+	config.telemetry.count("FanOutTruncated", 1)
+	return providers[:max], fmt.Sprintf("only the first %d of %d providers were called", max, len(providers))
+}
+
+// The 422 for a request that goes to too many providers, nil when it can go ahead
+func (config *Config) rejectFanOut(request Request, providers []Provider) *Response {
+	err := config.fanOutError(request, providers)
+	if err == nil {
+		return nil
+	}
+	config.telemetry.count("FanOutRejected", 1)
+	response, _ := jsonResponse(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	return &response
+}
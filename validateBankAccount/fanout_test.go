@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestConfig_fanOut(t *testing.T) {
+	providers := []Provider{
+		{Name: "provider1", Type: ProviderTypeSimulated},
+		{Name: "provider2", Type: ProviderTypeSimulated},
+		{Name: "provider3", Type: ProviderTypeSimulated},
+	}
+	tests := []struct {
+		name       string
+		fanOut     FanOutConfig
+		tenant     string
+		wantStatus int
+		wantBody   string
+	}{
+		{"unlimited", FanOutConfig{}, "", 200, "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true},{\"provider\":\"provider2\",\"isValid\":true},{\"provider\":\"provider3\",\"isValid\":true}]}"},
+		{"rejected", FanOutConfig{MaxProviders: 2}, "", 422, "{\"error\":\"request fans out to 3 providers, the most is 2\"}"},
+		{"truncated", FanOutConfig{MaxProviders: 2, Overflow: FanOutTruncate}, "", 200,
+			"{\"result\":[{\"provider\":\"provider1\",\"isValid\":true},{\"provider\":\"provider2\",\"isValid\":true}],\"warnings\":[\"only the first 2 of 3 providers were called\"]}"},
+		{"tenant", FanOutConfig{MaxProviders: 2, Tenants: map[string]int{"acme": 3}}, "acme", 200, ""},
+		{"otherTenant", FanOutConfig{MaxProviders: 2, Tenants: map[string]int{"acme": 3}}, "globex", 422, ""},
+		{"tenantLower", FanOutConfig{Tenants: map[string]int{"acme": 1}}, "acme", 422, "{\"error\":\"request fans out to 3 providers, the most is 1\"}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Providers: providers, FanOut: tt.fanOut}
+			request := Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\"}"}
+			request.RequestContext.Authorizer = map[string]interface{}{"tenant": tt.tenant}
+			response, err := config.Handler(context.Background(), request)
+			if err != nil || response.StatusCode != tt.wantStatus || (tt.wantBody != "" && response.Body != tt.wantBody) {
+				t.Errorf("Handler() = %d %s, want %d %s", response.StatusCode, response.Body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestConfig_fanOut_batch(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}, {Name: "provider2", Type: ProviderTypeSimulated}}, FanOut: FanOutConfig{MaxProviders: 1}}
+	body := "{\"requests\": [{\"accountNumber\": \"12345670\", \"providers\": [\"provider1\"]}, {\"accountNumber\": \"12345670\"}]}"
+	response, _ := config.BatchHandler(context.Background(), Request{Body: body})
+	if !strings.Contains(response.Body, "{\"index\":1,\"error\":\"request fans out to 2 providers, the most is 1\"}") || strings.Count(response.Body, "\"error\"") != 1 {
+		t.Errorf("BatchHandler() = %s, want the second item rejected", response.Body)
+	}
+}
+
+func TestFanOutConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		fanOut  FanOutConfig
+		wantErr bool
+	}{
+		{"none", FanOutConfig{}, false},
+		{"valid", FanOutConfig{MaxProviders: 5, Overflow: FanOutTruncate, Tenants: map[string]int{"acme": 10}}, false},
+		{"negative", FanOutConfig{MaxProviders: -1}, true},
+		{"badOverflow", FanOutConfig{Overflow: "drop"}, true},
+		{"zeroTenant", FanOutConfig{Tenants: map[string]int{"acme": 0}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fanOut.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+/*
+  Accuracy feedback. Callers find out later whether an account really was valid (the payment went through or
+  bounced) and tell us, with the x-amzn-RequestId of the validation:
+
+    POST /feedback {"requestId": "c6af9ac6-...", "actualOutcome": "invalid", "reason": "payment_bounced"}
+
+  The audit record for the request (see telemetry.go) says what each provider answered, every one that answered
+  gets a point for agreeing with the outcome or a miss for not, added up across containers in the DynamoDB table
+  in ACCURACY_TABLE:
+
+    provider (S, hash key) | agreed (N) | total (N)
+
+  The feedback is kept on the audit record itself (actualOutcome, feedbackReason, feedbackCaller and feedbackAt),
+  so it's joined to what we answered, and a second go for the same request gets a 409 rather than counting twice.
+  The reason is free text of up to 100 characters for the data team, see feedbackexport.go.
+
+  With feedback configured the accuracy moves the weights the majority strategy uses:
+
+    feedback:
+      minSamples: 100       # feedback a provider needs before its weight moves, default 100
+      minWeight: 0.5        # bounds on the adjusted weight, default 0.5 and 2
+      maxWeight: 2
+      refreshSeconds: 300   # how often the accuracy table is read again, default 300
+
+  A provider's weight is its configured weight (1 without one) times its accuracy over the average accuracy of
+  the providers with enough samples, kept within the bounds. So a provider that's right as often as the rest keeps
+  its weight, one that's wrong more often counts for less. Without the feedback section feedback is still recorded,
+  the weights just stay as configured.
+*/
+
+const (
+	ActualOutcomeValid   = "valid"
+	ActualOutcomeInvalid = "invalid"
+
+	defaultFeedbackMinSamples = 100
+	defaultFeedbackMinWeight  = 0.5
+	defaultFeedbackMaxWeight  = 2
+	defaultFeedbackRefresh    = 5 * time.Minute
+	feedbackFetchTimeout      = 2 * time.Second
+	// The audit table's index on requestId
+	auditRequestIndex = "requestId-index"
+	maxFeedbackReason = 100
+)
+
+var (
+	errFeedbackUnknownRequest = errors.New("no validation with that requestId")
+	errFeedbackDuplicate      = errors.New("feedback has already been given for that requestId")
+)
+
+type FeedbackConfig struct {
+	MinSamples     int     `yaml:"minSamples"`
+	MinWeight      float64 `yaml:"minWeight"`
+	MaxWeight      float64 `yaml:"maxWeight"`
+	RefreshSeconds int     `yaml:"refreshSeconds"`
+}
+
+type FeedbackRequest struct {
+	RequestID     string `json:"requestId"`
+	ActualOutcome string `json:"actualOutcome"`
+	Reason        string `json:"reason"`
+}
+
+// What we were told about a validation, kept on its audit record
+type AuditFeedback struct {
+	ActualOutcome string    `json:"actualOutcome"`
+	Reason        string    `json:"reason,omitempty"`
+	Caller        string    `json:"caller,omitempty"`
+	At            time.Time `json:"at"`
+}
+
+type FeedbackResponse struct {
+	RequestID string `json:"requestId"`
+	// Whether each provider that answered got it right
+	Providers map[string]bool `json:"providers"`
+}
+
+type providerAccuracy struct {
+	Agreed int64
+	Total  int64
+}
+
+func (feedback *FeedbackConfig) validate() error {
+	if feedback == nil {
+		return nil
+	}
+	if feedback.MinSamples < 0 || feedback.RefreshSeconds < 0 || feedback.MinWeight < 0 || feedback.MaxWeight < 0 {
+		return errors.New("feedback settings can't be negative")
+	}
+	if feedback.MaxWeight > 0 && feedback.minWeight() > feedback.MaxWeight {
+		return errors.New("feedback minWeight is above maxWeight")
+	}
+	return nil
+}
+
+func (feedback *FeedbackConfig) minSamples() int64 {
+	if feedback.MinSamples == 0 {
+		return defaultFeedbackMinSamples
+	}
+	return int64(feedback.MinSamples)
+}
+
+func (feedback *FeedbackConfig) minWeight() float64 {
+	if feedback.MinWeight == 0 {
+		return defaultFeedbackMinWeight
+	}
+	return feedback.MinWeight
+}
+
+func (feedback *FeedbackConfig) maxWeight() float64 {
+	if feedback.MaxWeight == 0 {
+		return defaultFeedbackMaxWeight
+	}
+	return feedback.MaxWeight
+}
+
+func (feedback *FeedbackConfig) refresh() time.Duration {
+	if feedback.RefreshSeconds == 0 {
+		return defaultFeedbackRefresh
+	}
+	return time.Duration(feedback.RefreshSeconds) * time.Second
+}
+
+// The weights with accuracy taken into account, providers without enough feedback keep the configured one
+func (feedback *FeedbackConfig) adjustWeights(providers []Provider, accuracy map[string]providerAccuracy) map[string]float64 {
+	total, counted := 0.0, 0
+	for _, provider := range providers {
+		if stats := accuracy[provider.Name]; stats.Total >= feedback.minSamples() {
+			total += float64(stats.Agreed) / float64(stats.Total)
+			counted++
+		}
+	}
+	weights := map[string]float64{}
+	for _, provider := range providers {
+		weight := provider.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		if stats := accuracy[provider.Name]; counted > 0 && total > 0 && stats.Total >= feedback.minSamples() {
+			weight *= float64(stats.Agreed) / float64(stats.Total) / (total / float64(counted))
+			weight = math.Min(math.Max(weight, feedback.minWeight()), feedback.maxWeight())
+		}
+		weights[provider.Name] = weight
+	}
+	return weights
+}
+
+type FeedbackStore interface {
+	// Adds the feedback to the request's audit record, returning what each provider said
+	MarkOutcome(ctx context.Context, requestID string, feedback AuditFeedback) (map[string]bool, error)
+	AddAccuracy(ctx context.Context, provider string, agreed bool) error
+	Accuracy(ctx context.Context) (map[string]providerAccuracy, error)
+}
+
+type feedbackDynamoAPI interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+type dynamoFeedbackStore struct {
+	client        feedbackDynamoAPI
+	auditTable    string
+	accuracyTable string
+}
+
+func (store *dynamoFeedbackStore) MarkOutcome(ctx context.Context, requestID string, feedback AuditFeedback) (map[string]bool, error) {
+	output, err := store.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(store.auditTable),
+		IndexName:                 aws.String(auditRequestIndex),
+		KeyConditionExpression:    aws.String("requestId = :requestId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":requestId": &types.AttributeValueMemberS{Value: requestID}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Items) == 0 {
+		return nil, errFeedbackUnknownRequest
+	}
+	item := output.Items[0]
+	_, err = store.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(store.auditTable),
+		Key:                 map[string]types.AttributeValue{"accountHash": item["accountHash"], "validatedAt": item["validatedAt"]},
+		UpdateExpression:    aws.String("SET actualOutcome = :outcome, feedbackReason = :reason, feedbackCaller = :caller, feedbackAt = :at"),
+		ConditionExpression: aws.String("attribute_not_exists(actualOutcome)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":outcome": &types.AttributeValueMemberS{Value: feedback.ActualOutcome},
+			":reason":  &types.AttributeValueMemberS{Value: feedback.Reason},
+			":caller":  &types.AttributeValueMemberS{Value: feedback.Caller},
+			":at":      &types.AttributeValueMemberS{Value: feedback.At.Format(time.RFC3339Nano)},
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil, errFeedbackDuplicate
+	}
+	if err != nil {
+		return nil, err
+	}
+	said := map[string]bool{}
+	if verdicts, ok := item["verdicts"].(*types.AttributeValueMemberM); ok {
+		for provider, value := range verdicts.Value {
+			if isValid, ok := value.(*types.AttributeValueMemberBOOL); ok {
+				said[provider] = isValid.Value
+			}
+		}
+	}
+	return said, nil
+}
+
+func (store *dynamoFeedbackStore) AddAccuracy(ctx context.Context, provider string, agreed bool) error {
+	point := "0"
+	if agreed {
+		point = "1"
+	}
+	_, err := store.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(store.accuracyTable),
+		Key:              map[string]types.AttributeValue{"provider": &types.AttributeValueMemberS{Value: provider}},
+		UpdateExpression: aws.String("ADD agreed :agreed, total :one"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":agreed": &types.AttributeValueMemberN{Value: point},
+			":one":    &types.AttributeValueMemberN{Value: "1"},
+		},
+	})
+	return err
+}
+
+func (store *dynamoFeedbackStore) Accuracy(ctx context.Context) (map[string]providerAccuracy, error) {
+	accuracy := map[string]providerAccuracy{}
+	paginator := dynamodb.NewScanPaginator(store.client, &dynamodb.ScanInput{TableName: aws.String(store.accuracyTable)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			provider, _ := item["provider"].(*types.AttributeValueMemberS)
+			if provider == nil {
+				continue
+			}
+			stats := providerAccuracy{}
+			if agreed, ok := item["agreed"].(*types.AttributeValueMemberN); ok {
+				stats.Agreed, _ = strconv.ParseInt(agreed.Value, 10, 64)
+			}
+			if total, ok := item["total"].(*types.AttributeValueMemberN); ok {
+				stats.Total, _ = strconv.ParseInt(total.Value, 10, 64)
+			}
+			accuracy[provider.Value] = stats
+		}
+	}
+	return accuracy, nil
+}
+
+// The adjusted weights, read again in the background every refresh like secrets are
+type feedbackWeights struct {
+	mu         sync.Mutex
+	store      FeedbackStore
+	config     *FeedbackConfig
+	providers  []Provider
+	weights    map[string]float64
+	loadedAt   time.Time
+	refreshing bool
+	now        func() time.Time
+}
+
+// Keeps the configured weights until the accuracy has been read
+func newFeedbackWeights(store FeedbackStore, config *FeedbackConfig, providers []Provider, configured map[string]float64) *feedbackWeights {
+	return &feedbackWeights{store: store, config: config, providers: providers, weights: configured, now: time.Now}
+}
+
+func (weights *feedbackWeights) get() map[string]float64 {
+	weights.mu.Lock()
+	defer weights.mu.Unlock()
+	if !weights.refreshing && weights.now().Sub(weights.loadedAt) >= weights.config.refresh() {
+		weights.refreshing = true
+		go weights.reload()
+	}
+	return weights.weights
+}
+
+// Failures keep the weights we have until the next refresh
+func (weights *feedbackWeights) reload() {
+	ctx, cancel := context.WithTimeout(context.Background(), feedbackFetchTimeout)
+	defer cancel()
+	accuracy, err := weights.store.Accuracy(ctx)
+	weights.mu.Lock()
+	defer weights.mu.Unlock()
+	weights.refreshing = false
+	weights.loadedAt = weights.now()
+	if err != nil {
+		log.Printf("unable to read provider accuracy, keeping the current weights: %v", err)
+		return
+	}
+	weights.weights = weights.config.adjustWeights(weights.providers, accuracy)
+}
+
+// The weights the majority strategy uses
+func (config *Config) currentWeights() map[string]float64 {
+	if config.feedbackWeights == nil {
+		return config.weights
+	}
+	return config.feedbackWeights.get()
+}
+
+func (config *Config) recordFeedback(ctx context.Context, request Request) (int, interface{}) {
+	if config.feedback == nil {
+		return 503, map[string]string{"error": "feedback isn't set up"}
+	}
+	feedbackRequest := FeedbackRequest{}
+	if err := decodeJSON(request.Body, &feedbackRequest); err != nil {
+		return 400, map[string]string{"error": decodeMessage(err)}
+	}
+	if feedbackRequest.RequestID == "" {
+		return 400, map[string]string{"error": "requestId is required"}
+	}
+	if feedbackRequest.ActualOutcome != ActualOutcomeValid && feedbackRequest.ActualOutcome != ActualOutcomeInvalid {
+		return 400, map[string]string{"error": fmt.Sprintf("actualOutcome should be %s or %s", ActualOutcomeValid, ActualOutcomeInvalid)}
+	}
+	if len(feedbackRequest.Reason) > maxFeedbackReason {
+		return 400, map[string]string{"error": fmt.Sprintf("reason can't be longer than %d characters", maxFeedbackReason)}
+	}
+	said, err := config.feedback.MarkOutcome(ctx, feedbackRequest.RequestID, AuditFeedback{
+		ActualOutcome: feedbackRequest.ActualOutcome,
+		Reason:        feedbackRequest.Reason,
+		Caller:        callerIdentity(request),
+		At:            time.Now().UTC(),
+	})
+	switch {
+	case errors.Is(err, errFeedbackUnknownRequest):
+		return 404, map[string]string{"error": err.Error()}
+	case errors.Is(err, errFeedbackDuplicate):
+		return 409, map[string]string{"error": err.Error()}
+	case err != nil:
+		log.Printf("unable to record feedback: %v", err)
+		return 500, map[string]string{"error": "unable to record feedback"}
+	}
+	response := FeedbackResponse{RequestID: feedbackRequest.RequestID, Providers: map[string]bool{}}
+	for provider, isValid := range said {
+		agreed := isValid == (feedbackRequest.ActualOutcome == ActualOutcomeValid)
+		response.Providers[provider] = agreed
+		if err := config.feedback.AddAccuracy(ctx, provider, agreed); err != nil {
+			log.Printf("unable to add feedback for %s: %v", provider, err)
+		}
+	}
+	config.telemetry.count("Feedback", 1)
+	return 200, response
+}
+
+// Handler for POST /feedback
+func (config *Config) FeedbackHandler(ctx context.Context, request Request) (Response, error) {
+	return jsonResponse(config.recordFeedback(ctx, request))
+}
+
+// Connects to the audit and accuracy tables when both are there
+func (config *Config) setupFeedback(ctx context.Context) {
+	auditTable, auditExists := os.LookupEnv("AUDIT_TABLE")
+	accuracyTable, accuracyExists := os.LookupEnv("ACCURACY_TABLE")
+	if !auditExists || !accuracyExists {
+		return
+	}
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	config.feedback = &dynamoFeedbackStore{client: dynamodb.NewFromConfig(cfg), auditTable: auditTable, accuracyTable: accuracyTable}
+	if config.Feedback != nil {
+		config.feedbackWeights = newFeedbackWeights(config.feedback, config.Feedback, config.Providers, config.weights)
+		config.feedbackWeights.get()
+	}
+}
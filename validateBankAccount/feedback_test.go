@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeFeedbackStore struct {
+	audit    map[string]map[string]bool
+	marked   map[string]AuditFeedback
+	accuracy map[string]providerAccuracy
+}
+
+func (store *fakeFeedbackStore) MarkOutcome(ctx context.Context, requestID string, feedback AuditFeedback) (map[string]bool, error) {
+	said, exists := store.audit[requestID]
+	if !exists {
+		return nil, errFeedbackUnknownRequest
+	}
+	if _, marked := store.marked[requestID]; marked {
+		return nil, errFeedbackDuplicate
+	}
+	store.marked[requestID] = feedback
+	return said, nil
+}
+
+func (store *fakeFeedbackStore) AddAccuracy(ctx context.Context, provider string, agreed bool) error {
+	stats := store.accuracy[provider]
+	stats.Total++
+	if agreed {
+		stats.Agreed++
+	}
+	store.accuracy[provider] = stats
+	return nil
+}
+
+func (store *fakeFeedbackStore) Accuracy(ctx context.Context) (map[string]providerAccuracy, error) {
+	return store.accuracy, nil
+}
+
+func TestConfig_recordFeedback(t *testing.T) {
+	store := &fakeFeedbackStore{
+		audit:    map[string]map[string]bool{"req-1": {"provider1": true, "provider2": false}},
+		marked:   map[string]AuditFeedback{},
+		accuracy: map[string]providerAccuracy{},
+	}
+	config := &Config{feedback: store}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"recorded", "{\"requestId\": \"req-1\", \"actualOutcome\": \"invalid\", \"reason\": \"payment_bounced\"}", 200, "{\"requestId\":\"req-1\",\"providers\":{\"provider1\":false,\"provider2\":true}}"},
+		{"twice", "{\"requestId\": \"req-1\", \"actualOutcome\": \"valid\"}", 409, "{\"error\":\"feedback has already been given for that requestId\"}"},
+		{"unknown", "{\"requestId\": \"req-2\", \"actualOutcome\": \"valid\"}", 404, "{\"error\":\"no validation with that requestId\"}"},
+		{"badOutcome", "{\"requestId\": \"req-1\", \"actualOutcome\": \"bounced\"}", 400, "{\"error\":\"actualOutcome should be valid or invalid\"}"},
+		{"noRequestId", "{\"actualOutcome\": \"valid\"}", 400, "{\"error\":\"requestId is required\"}"},
+		{"longReason", "{\"requestId\": \"req-1\", \"actualOutcome\": \"valid\", \"reason\": \"" + strings.Repeat("x", 101) + "\"}", 400, "{\"error\":\"reason can't be longer than 100 characters\"}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := config.Router(context.Background(), Request{HTTPMethod: "POST", Path: "/feedback", Body: tt.body})
+			if err != nil || response.StatusCode != tt.wantStatus || response.Body != tt.wantBody {
+				t.Errorf("Router() = %d %s, want %d %s", response.StatusCode, response.Body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+
+	want := map[string]providerAccuracy{"provider1": {Agreed: 0, Total: 1}, "provider2": {Agreed: 1, Total: 1}}
+	if !reflect.DeepEqual(store.accuracy, want) {
+		t.Errorf("accuracy = %v, want %v", store.accuracy, want)
+	}
+	if marked := store.marked["req-1"]; marked.ActualOutcome != ActualOutcomeInvalid || marked.Reason != "payment_bounced" || marked.At.IsZero() {
+		t.Errorf("MarkOutcome() got %+v", marked)
+	}
+
+	config.feedback = nil
+	if response, _ := config.FeedbackHandler(context.Background(), Request{Body: tests[0].body}); response.StatusCode != 503 {
+		t.Errorf("FeedbackHandler() without tables = %d, want 503", response.StatusCode)
+	}
+}
+
+func TestFeedbackConfig_adjustWeights(t *testing.T) {
+	providers := []Provider{{Name: "provider1"}, {Name: "provider2", Weight: 2}, {Name: "provider3"}, {Name: "provider4"}}
+	accuracy := map[string]providerAccuracy{
+		"provider1": {Agreed: 90, Total: 100},
+		"provider2": {Agreed: 100, Total: 100},
+		"provider3": {Agreed: 20, Total: 100},
+		// Not enough to go on
+		"provider4": {Agreed: 0, Total: 5},
+	}
+	feedback := &FeedbackConfig{MinSamples: 10, MaxWeight: 3}
+	// The average accuracy is 0.7
+	want := map[string]float64{"provider1": 0.9 / 0.7, "provider2": 2 / 0.7, "provider3": 0.5, "provider4": 1}
+	got := feedback.adjustWeights(providers, accuracy)
+	for name, weight := range want {
+		if diff := got[name] - weight; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("adjustWeights()[%s] = %v, want %v", name, got[name], weight)
+		}
+	}
+
+	// No feedback leaves the configured weights
+	want = map[string]float64{"provider1": 1, "provider2": 2, "provider3": 1, "provider4": 1}
+	if got := feedback.adjustWeights(providers, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("adjustWeights() without feedback = %v, want %v", got, want)
+	}
+}
+
+func TestConfig_feedbackWeights(t *testing.T) {
+	store := &fakeFeedbackStore{accuracy: map[string]providerAccuracy{
+		"provider1": {Agreed: 10, Total: 100},
+		"provider2": {Agreed: 100, Total: 100},
+	}}
+	config := &Config{
+		Providers: []Provider{{Name: "provider1"}, {Name: "provider2"}},
+		Feedback:  &FeedbackConfig{MinSamples: 10},
+	}
+	config.feedbackWeights = newFeedbackWeights(store, config.Feedback, config.Providers, nil)
+	config.feedbackWeights.reload()
+	config.feedbackWeights.now = func() time.Time { return config.feedbackWeights.loadedAt }
+
+	want := map[string]float64{"provider1": defaultFeedbackMinWeight, "provider2": 1 / 0.55}
+	got := config.currentWeights()
+	for name, weight := range want {
+		if diff := got[name] - weight; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("currentWeights()[%s] = %v, want %v", name, got[name], weight)
+		}
+	}
+
+	// A tie without feedback, the more accurate provider wins it with
+	results := []BankAccountValidationResult{{Provider: "provider1", IsValid: false}, {Provider: "provider2", IsValid: true}}
+	strategy := StrategyMajority
+	if aggregate := aggregateWeighted(&strategy, results, nil); aggregate.IsValid {
+		t.Errorf("aggregateWeighted() unweighted = %+v, want invalid", aggregate)
+	}
+	if aggregate := aggregateWeighted(&strategy, results, got); !aggregate.IsValid {
+		t.Errorf("aggregateWeighted() = %+v, want valid", aggregate)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+/*
+  Daily feedback export. The data team evaluates providers offline, so shortly after midnight UTC
+  (MODE=feedback-export) every audit record that got feedback the day before (see feedback.go) is written out with
+  what we answered next to what really happened:
+
+    feedbackExport:
+      location: s3://accountvalidator-data/feedback   # each day goes to <location>/<yyyy-mm-dd>.jsonl
+
+    {"requestId": "c6af9ac6-...", "accountHash": "5e88...", "validatedAt": "2024-05-30T09:12:01Z", "caller": "client-42",
+     "outcome": "valid", "verdicts": {"provider1": true, "provider2": false}, "actualOutcome": "invalid",
+     "reason": "payment_bounced", "feedbackAt": "2024-06-01T14:03:22Z"}
+
+  It's JSON lines rather than CSV since each record has a different set of providers. A day is the day the feedback
+  came in, not the day of the validation, so each record is exported once.
+*/
+
+type FeedbackExportConfig struct {
+	Location string `yaml:"location"`
+}
+
+type feedbackExportRow struct {
+	RequestID       string          `json:"requestId"`
+	AccountHash     string          `json:"accountHash"`
+	ValidatedAt     time.Time       `json:"validatedAt"`
+	Caller          string          `json:"caller,omitempty"`
+	ClientReference string          `json:"clientReference,omitempty"`
+	Outcome         string          `json:"outcome"`
+	TestAccount     bool            `json:"testAccount,omitempty"`
+	Verdicts        map[string]bool `json:"verdicts"`
+	ActualOutcome   string          `json:"actualOutcome"`
+	Reason          string          `json:"reason,omitempty"`
+	FeedbackCaller  string          `json:"feedbackCaller,omitempty"`
+	FeedbackAt      time.Time       `json:"feedbackAt"`
+}
+
+// One line per record with feedback, oldest feedback first
+func feedbackJSONLines(records []AuditRecord) ([]byte, error) {
+	rows := []feedbackExportRow{}
+	for _, record := range records {
+		if record.Feedback == nil {
+			continue
+		}
+		verdicts := record.Verdicts
+		if verdicts == nil {
+			verdicts = map[string]bool{}
+		}
+		rows = append(rows, feedbackExportRow{
+			RequestID:       record.RequestID,
+			AccountHash:     record.AccountHash,
+			ValidatedAt:     record.ValidatedAt,
+			Caller:          record.Caller,
+			ClientReference: record.ClientReference,
+			Outcome:         record.Outcome,
+			TestAccount:     record.TestAccount,
+			Verdicts:        verdicts,
+			ActualOutcome:   record.Feedback.ActualOutcome,
+			Reason:          record.Feedback.Reason,
+			FeedbackCaller:  record.Feedback.Caller,
+			FeedbackAt:      record.Feedback.At,
+		})
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].FeedbackAt.Before(rows[j].FeedbackAt) })
+	var buf bytes.Buffer
+	for _, row := range rows {
+		line, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// Exports the feedback from the day before the event to S3
+func (config *Config) writeFeedbackExport(ctx context.Context, audit auditScanAPI, table string, objects *s3Client, eventTime time.Time) error {
+	location, err := parseS3Location(config.FeedbackExport.Location)
+	if err != nil {
+		return err
+	}
+	to := time.Date(eventTime.Year(), eventTime.Month(), eventTime.Day(), 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, 0, -1)
+	records, err := scanAuditBetween(ctx, audit, table, "feedbackAt", from, to)
+	if err != nil {
+		return fmt.Errorf("unable to read the audit table: %w", err)
+	}
+	export, err := feedbackJSONLines(records)
+	if err != nil {
+		return err
+	}
+	location.Key = strings.TrimSuffix(location.Key, "/") + "/" + from.Format("2006-01-02") + ".jsonl"
+	return objects.putObject(ctx, location, export, "application/x-ndjson")
+}
+
+// Lambda handler for the daily schedule
+func (config *Config) FeedbackExportHandler(ctx context.Context, event events.CloudWatchEvent) error {
+	table, exists := os.LookupEnv("AUDIT_TABLE")
+	if !exists || config.FeedbackExport.Location == "" {
+		return errors.New("ENVVAR AUDIT_TABLE and feedbackExport.location are required for the feedback export")
+	}
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		return err
+	}
+	objects, err := newS3Client(ctx)
+	if err != nil {
+		return err
+	}
+	return config.writeFeedbackExport(ctx, dynamodb.NewFromConfig(cfg), table, objects, event.Time.UTC())
+}
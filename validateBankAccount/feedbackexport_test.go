@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func feedbackItem(requestID, actualOutcome, feedbackAt string) map[string]types.AttributeValue {
+	item := auditItem("client-42", ResultStatusValid, 100, "provider1", "provider2")
+	item["requestId"] = &types.AttributeValueMemberS{Value: requestID}
+	item["accountHash"] = &types.AttributeValueMemberS{Value: "5e88"}
+	item["verdicts"] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+		"provider1": &types.AttributeValueMemberBOOL{Value: true},
+		"provider2": &types.AttributeValueMemberBOOL{Value: false},
+	}}
+	item["actualOutcome"] = &types.AttributeValueMemberS{Value: actualOutcome}
+	item["feedbackReason"] = &types.AttributeValueMemberS{Value: "payment_bounced"}
+	item["feedbackAt"] = &types.AttributeValueMemberS{Value: feedbackAt}
+	return item
+}
+
+func TestConfig_writeFeedbackExport(t *testing.T) {
+	var uploaded, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		uploaded, path = string(body), r.URL.Path
+	}))
+	defer server.Close()
+	objects := testS3Client(server.URL)
+	audit := &fakeAuditScanner{pages: [][]map[string]types.AttributeValue{
+		{feedbackItem("req-2", ActualOutcomeValid, "2024-06-01T18:00:00Z")},
+		{feedbackItem("req-1", ActualOutcomeInvalid, "2024-06-01T09:00:00Z")},
+	}}
+	config := &Config{FeedbackExport: FeedbackExportConfig{Location: "s3://accountvalidator-data/feedback"}}
+
+	if err := config.writeFeedbackExport(context.Background(), audit, "audit", objects, time.Date(2024, 6, 2, 0, 30, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"requestId\":\"req-1\",\"accountHash\":\"5e88\",\"validatedAt\":\"2024-06-01T10:00:00Z\",\"caller\":\"client-42\"," +
+		"\"outcome\":\"valid\",\"verdicts\":{\"provider1\":true,\"provider2\":false},\"actualOutcome\":\"invalid\"," +
+		"\"reason\":\"payment_bounced\",\"feedbackAt\":\"2024-06-01T09:00:00Z\"}\n" +
+		"{\"requestId\":\"req-2\",\"accountHash\":\"5e88\",\"validatedAt\":\"2024-06-01T10:00:00Z\",\"caller\":\"client-42\"," +
+		"\"outcome\":\"valid\",\"verdicts\":{\"provider1\":true,\"provider2\":false},\"actualOutcome\":\"valid\"," +
+		"\"reason\":\"payment_bounced\",\"feedbackAt\":\"2024-06-01T18:00:00Z\"}\n"
+	if uploaded != want || path != "/accountvalidator-data/feedback/2024-06-01.jsonl" {
+		t.Errorf("uploaded %q to %s, want %q", uploaded, path, want)
+	}
+}
+
+func Test_feedbackJSONLines(t *testing.T) {
+	// Records without feedback are left out
+	got, err := feedbackJSONLines([]AuditRecord{{RequestID: "req-1", Outcome: ResultStatusValid}})
+	if err != nil || len(got) != 0 {
+		t.Errorf("feedbackJSONLines() = %q, %v, want nothing", got, err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+/*
+  POST /graphql for internal tooling that only wants some of the response. One query:
+
+    query {
+      validateAccount(accountNumber: "12345678", providers: ["provider1"], strategy: "any") {
+        results { provider status error }
+        aggregate { isValid }
+        metadata { configHash }
+        timing { totalMs }
+      }
+    }
+
+  Same validation as POST /application, including test accounts and trace propagation, so the two never disagree.
+*/
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// What validateAccount resolves to, fields are picked up by their json names
+type graphQLValidation struct {
+	Results         []BankAccountValidationResult `json:"results"`
+	Aggregate       *AggregateResult              `json:"aggregate"`
+	Metadata        *ResponseMetadata             `json:"metadata"`
+	Timing          graphQLTiming                 `json:"timing"`
+	ClientReference *string                       `json:"clientReference"`
+}
+
+type graphQLTiming struct {
+	TotalMs int64 `json:"totalMs"`
+}
+
+var graphQLResultType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ProviderResult",
+	Fields: graphql.Fields{
+		"provider": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"isValid":  &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"error":    &graphql.Field{Type: graphql.String},
+		"status": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "valid, invalid or error",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				result := p.Source.(BankAccountValidationResult)
+				switch {
+				case result.Error != "":
+					return ResultStatusError, nil
+				case result.IsValid:
+					return ResultStatusValid, nil
+				}
+				return ResultStatusInvalid, nil
+			},
+		},
+	},
+})
+
+var graphQLAggregateType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Aggregate",
+	Fields: graphql.Fields{
+		"strategy": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"isValid":  &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+	},
+})
+
+var graphQLMetadataType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Metadata",
+	Fields: graphql.Fields{
+		"functionVersion": &graphql.Field{Type: graphql.String},
+		"configHash":      &graphql.Field{Type: graphql.String},
+		"configRevision":  &graphql.Field{Type: graphql.String},
+		"configUpdatedAt": &graphql.Field{Type: graphql.String},
+	},
+})
+
+var graphQLTimingType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Timing",
+	Fields: graphql.Fields{
+		"totalMs": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+var graphQLValidationType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Validation",
+	Fields: graphql.Fields{
+		"results":         &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphQLResultType)))},
+		"aggregate":       &graphql.Field{Type: graphQLAggregateType},
+		"metadata":        &graphql.Field{Type: graphQLMetadataType},
+		"timing":          &graphql.Field{Type: graphql.NewNonNull(graphQLTimingType)},
+		"clientReference": &graphql.Field{Type: graphql.String},
+	},
+})
+
+func resolveValidateAccount(p graphql.ResolveParams) (interface{}, error) {
+	root, _ := p.Info.RootValue.(map[string]interface{})
+	config, ok := root["config"].(*Config)
+	if !ok {
+		return nil, errors.New("graphql root is missing the config")
+	}
+	request, _ := root["request"].(Request)
+	accountNumber := p.Args["accountNumber"].(string)
+	if err := rejectCardNumber(accountNumber); err != nil {
+		return nil, err
+	}
+	validationRequest := &BankAccountValidationRequest{AccountNumber: &accountNumber}
+	if names, exists := p.Args["providers"].([]interface{}); exists {
+		providers := make([]string, 0, len(names))
+		for _, name := range names {
+			providers = append(providers, name.(string))
+		}
+		validationRequest.Providers = &providers
+	}
+	if strategy, exists := p.Args["strategy"].(string); exists {
+		if err := validateStrategy(strategy); err != nil {
+			return nil, err
+		}
+		validationRequest.Strategy = &strategy
+	}
+	if reference, exists := p.Args["clientReference"].(string); exists {
+		if err := validateClientReference(reference); err != nil {
+			return nil, err
+		}
+		validationRequest.ClientReference = &reference
+	}
+
+	if message := config.itemError(p.Context, request, validationRequest); message != "" {
+		return nil, errors.New(message)
+	}
+
+	start := time.Now()
+	response := filterResponse(config.visibleFields(request), config.validate(p.Context, request, validationRequest, nil))
+	return graphQLValidation{
+		Results:         response.Result,
+		Aggregate:       response.Aggregate,
+		Metadata:        response.Metadata,
+		Timing:          graphQLTiming{TotalMs: time.Since(start).Milliseconds()},
+		ClientReference: validationRequest.ClientReference,
+	}, nil
+}
+
+var graphQLSchema = mustGraphQLSchema()
+
+func mustGraphQLSchema() graphql.Schema {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"validateAccount": &graphql.Field{
+					Type: graphQLValidationType,
+					Args: graphql.FieldConfigArgument{
+						"accountNumber":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+						"providers":       &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+						"strategy":        &graphql.ArgumentConfig{Type: graphql.String},
+						"clientReference": &graphql.ArgumentConfig{Type: graphql.String},
+					},
+					Resolve: resolveValidateAccount,
+				},
+			},
+		}),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// Handler for POST /graphql. Query errors come back in the errors array with a 200, as graphql clients expect.
+func (config *Config) GraphQLHandler(ctx context.Context, request Request) (Response, error) {
+	var graphQLRequest GraphQLRequest
+	if err := json.Unmarshal([]byte(request.Body), &graphQLRequest); err != nil {
+		return *handleError(err, "invalid json payload"), nil
+	}
+	result := graphql.Do(graphql.Params{
+		Schema:         graphQLSchema,
+		RequestString:  graphQLRequest.Query,
+		VariableValues: graphQLRequest.Variables,
+		OperationName:  graphQLRequest.OperationName,
+		RootObject:     map[string]interface{}{"config": config, "request": request},
+		Context:        ctx,
+	})
+	return jsonResponse(200, result)
+}
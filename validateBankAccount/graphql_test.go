@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConfig_GraphQLHandler(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}, {Name: "provider2", Type: ProviderTypeSimulated}}}
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "selectedFields",
+			body: "{\"query\": \"{ validateAccount(accountNumber: \\\"12345670\\\", providers: [\\\"provider2\\\"], strategy: \\\"any\\\") { results { provider status } aggregate { isValid } } }\"}",
+			want: "{\"data\":{\"validateAccount\":{\"aggregate\":{\"isValid\":true},\"results\":[{\"provider\":\"provider2\",\"status\":\"valid\"}]}}}",
+		},
+		{name: "variables",
+			body: "{\"query\": \"query($account: String!) { validateAccount(accountNumber: $account) { results { provider isValid error } } }\", \"variables\": {\"account\": \"12349999\"}}",
+			want: "{\"data\":{\"validateAccount\":{\"results\":[{\"error\":\"request_failed\",\"isValid\":false,\"provider\":\"provider1\"},{\"error\":\"request_failed\",\"isValid\":false,\"provider\":\"provider2\"}]}}}",
+		},
+		{name: "badStrategy",
+			body: "{\"query\": \"{ validateAccount(accountNumber: \\\"12345670\\\", strategy: \\\"most\\\") { aggregate { isValid } } }\"}",
+			want: "{\"data\":{\"validateAccount\":null},\"errors\":[{\"message\":\"unknown strategy most\",\"locations\":[{\"line\":1,\"column\":3}],\"path\":[\"validateAccount\"]}]}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := config.Router(context.Background(), Request{HTTPMethod: "POST", Path: "/graphql", Body: tt.body})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.StatusCode != 200 || got.Body != tt.want {
+				t.Errorf("GraphQLHandler() = %v %v, want 200 %v", got.StatusCode, got.Body, tt.want)
+			}
+		})
+	}
+}
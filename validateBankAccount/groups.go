@@ -0,0 +1,150 @@
+package main
+
+import "fmt"
+
+/*
+  Provider groups, for when some providers are really one opinion. Three bureaus that all buy the same data shouldn't
+  outvote everyone else three to one, so they can be grouped with a quorum and the group votes once:
+
+    groups:
+    - name: bureaus
+      providers: [bureau1, bureau2, bureau3]
+      quorum: 2        # how many have to agree, defaults to more than half
+      weight: 2        # the group's vote in a majority, defaults to 1
+
+  When a request asks for a strategy, each group with any provider called is settled first. It says valid when at
+  least quorum of its providers said valid, invalid when at least quorum said invalid, and otherwise it has no
+  quorum, which counts the same as a provider that didn't answer. With a quorum of half the group or less both can
+  be reached, and valid wins. The strategy then runs over the groups and the providers that aren't in one:
+
+    {"result": [...], "aggregate": {"strategy": "majority", "isValid": true,
+      "groups": [{"group": "bureaus", "status": "valid", "valid": 2, "invalid": 1, "quorum": 2}]}}
+
+  A provider can only be in one group and a group can't share a name with a provider.
+*/
+
+// A group without quorum, in place of a provider error
+const GroupNoQuorum = "no_quorum"
+
+type ProviderGroup struct {
+	Name      string   `yaml:"name"`
+	Providers []string `yaml:"providers"`
+	Quorum    int      `yaml:"quorum"`
+	Weight    float64  `yaml:"weight"`
+}
+
+type GroupResult struct {
+	Group   string `json:"group"`
+	Status  string `json:"status"`
+	Valid   int    `json:"valid"`
+	Invalid int    `json:"invalid"`
+	Quorum  int    `json:"quorum"`
+}
+
+// Quorum defaults to more than half of the group
+func (group ProviderGroup) quorum() int {
+	if group.Quorum > 0 {
+		return group.Quorum
+	}
+	return len(group.Providers)/2 + 1
+}
+
+func validateGroups(groups []ProviderGroup, providers []Provider) error {
+	known := map[string]bool{}
+	for _, provider := range providers {
+		known[provider.Name] = true
+	}
+	grouped := map[string]string{}
+	names := map[string]bool{}
+	for _, group := range groups {
+		switch {
+		case group.Name == "":
+			return fmt.Errorf("a provider group is missing its name")
+		case names[group.Name]:
+			return fmt.Errorf("provider group %s is defined more than once", group.Name)
+		case known[group.Name]:
+			return fmt.Errorf("provider group %s has the same name as a provider", group.Name)
+		case len(group.Providers) == 0:
+			return fmt.Errorf("provider group %s has no providers", group.Name)
+		case group.Quorum < 0 || group.Quorum > len(group.Providers):
+			return fmt.Errorf("provider group %s quorum %d should be between 1 and %d", group.Name, group.Quorum, len(group.Providers))
+		case group.Weight < 0:
+			return fmt.Errorf("provider group %s has a negative weight", group.Name)
+		}
+		names[group.Name] = true
+		for _, name := range group.Providers {
+			if !known[name] {
+				return fmt.Errorf("provider group %s has unknown provider %s", group.Name, name)
+			}
+			if other, exists := grouped[name]; exists {
+				return fmt.Errorf("provider %s is in groups %s and %s", name, other, group.Name)
+			}
+			grouped[name] = group.Name
+		}
+	}
+	return nil
+}
+
+// As aggregateWeighted, with each group's providers settled into a single vote first
+func aggregateGroups(strategy *string, results []BankAccountValidationResult, weights map[string]float64, groups []ProviderGroup) *AggregateResult {
+	if strategy == nil || len(groups) == 0 || isSequential(strategy) {
+		return aggregateWeighted(strategy, results, weights)
+	}
+	member := map[string]int{}
+	for i, group := range groups {
+		for _, name := range group.Providers {
+			member[name] = i
+		}
+	}
+	settled := make([]*GroupResult, len(groups))
+	votes := []BankAccountValidationResult{}
+	for _, result := range results {
+		i, grouped := member[result.Provider]
+		if !grouped {
+			votes = append(votes, result)
+			continue
+		}
+		if settled[i] == nil {
+			settled[i] = &GroupResult{Group: groups[i].Name, Quorum: groups[i].quorum()}
+		}
+		if result.Error == "" {
+			if result.IsValid {
+				settled[i].Valid++
+			} else {
+				settled[i].Invalid++
+			}
+		}
+	}
+
+	voteWeights := map[string]float64{}
+	for name, weight := range weights {
+		voteWeights[name] = weight
+	}
+	groupResults := []GroupResult{}
+	for i, group := range settled {
+		if group == nil {
+			continue
+		}
+		vote := BankAccountValidationResult{Provider: group.Group}
+		switch {
+		case group.Valid >= group.Quorum:
+			group.Status = ResultStatusValid
+			vote.IsValid = true
+		case group.Invalid >= group.Quorum:
+			group.Status = ResultStatusInvalid
+		default:
+			group.Status = GroupNoQuorum
+			vote.Error = GroupNoQuorum
+		}
+		if groups[i].Weight > 0 {
+			voteWeights[group.Group] = groups[i].Weight
+		}
+		votes = append(votes, vote)
+		groupResults = append(groupResults, *group)
+	}
+	aggregate := aggregateWeighted(strategy, votes, voteWeights)
+	if len(groupResults) > 0 {
+		aggregate.Groups = groupResults
+	}
+	return aggregate
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_validateGroups(t *testing.T) {
+	providers := []Provider{{Name: "bureau1"}, {Name: "bureau2"}, {Name: "bureau3"}}
+	tests := []struct {
+		name    string
+		groups  []ProviderGroup
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"group", []ProviderGroup{{Name: "bureaus", Providers: []string{"bureau1", "bureau2", "bureau3"}, Quorum: 2}}, false},
+		{"unnamed", []ProviderGroup{{Providers: []string{"bureau1"}}}, true},
+		{"empty", []ProviderGroup{{Name: "bureaus"}}, true},
+		{"providerName", []ProviderGroup{{Name: "bureau1", Providers: []string{"bureau2"}}}, true},
+		{"unknownProvider", []ProviderGroup{{Name: "bureaus", Providers: []string{"bureau9"}}}, true},
+		{"quorumTooHigh", []ProviderGroup{{Name: "bureaus", Providers: []string{"bureau1"}, Quorum: 2}}, true},
+		{"twoGroups", []ProviderGroup{{Name: "a", Providers: []string{"bureau1"}}, {Name: "b", Providers: []string{"bureau1"}}}, true},
+		{"duplicate", []ProviderGroup{{Name: "a", Providers: []string{"bureau1"}}, {Name: "a", Providers: []string{"bureau2"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateGroups(tt.groups, providers); (err != nil) != tt.wantErr {
+				t.Errorf("validateGroups() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_aggregateGroups(t *testing.T) {
+	strategy := func(s string) *string { return &s }
+	groups := []ProviderGroup{{Name: "bureaus", Providers: []string{"bureau1", "bureau2", "bureau3"}}}
+	valid := func(name string) BankAccountValidationResult {
+		return BankAccountValidationResult{Provider: name, IsValid: true}
+	}
+	invalid := func(name string) BankAccountValidationResult { return BankAccountValidationResult{Provider: name} }
+	failed := func(name string) BankAccountValidationResult {
+		return BankAccountValidationResult{Provider: name, Error: ProviderErrorTimeout}
+	}
+	tests := []struct {
+		name     string
+		strategy *string
+		results  []BankAccountValidationResult
+		groups   []ProviderGroup
+		want     *AggregateResult
+	}{
+		{
+			name:    "noStrategy",
+			results: []BankAccountValidationResult{valid("bureau1")},
+			groups:  groups,
+			want:    nil,
+		},
+		{
+			// Ungrouped the three bureaus would outvote provider1 and provider2
+			name:     "groupVotesOnce",
+			strategy: strategy(StrategyMajority),
+			results:  []BankAccountValidationResult{valid("bureau1"), valid("bureau2"), valid("bureau3"), invalid("provider1"), invalid("provider2")},
+			groups:   groups,
+			want: &AggregateResult{Strategy: StrategyMajority, IsValid: false, Groups: []GroupResult{
+				{Group: "bureaus", Status: ResultStatusValid, Valid: 3, Quorum: 2},
+			}},
+		},
+		{
+			name:     "invalidQuorum",
+			strategy: strategy(StrategyAny),
+			results:  []BankAccountValidationResult{invalid("bureau1"), invalid("bureau2"), valid("bureau3")},
+			groups:   groups,
+			want: &AggregateResult{Strategy: StrategyAny, IsValid: false, Groups: []GroupResult{
+				{Group: "bureaus", Status: ResultStatusInvalid, Valid: 1, Invalid: 2, Quorum: 2},
+			}},
+		},
+		{
+			name:     "noQuorum",
+			strategy: strategy(StrategyAll),
+			results:  []BankAccountValidationResult{valid("bureau1"), failed("bureau2"), valid("provider1")},
+			groups:   groups,
+			want: &AggregateResult{Strategy: StrategyAll, IsValid: false, Groups: []GroupResult{
+				{Group: "bureaus", Status: GroupNoQuorum, Valid: 1, Quorum: 2},
+			}},
+		},
+		{
+			name:     "weightedGroup",
+			strategy: strategy(StrategyMajority),
+			results:  []BankAccountValidationResult{valid("bureau1"), valid("bureau2"), invalid("provider1")},
+			groups:   []ProviderGroup{{Name: "bureaus", Providers: []string{"bureau1", "bureau2"}, Quorum: 1, Weight: 2}},
+			want: &AggregateResult{Strategy: StrategyMajority, IsValid: true, Groups: []GroupResult{
+				{Group: "bureaus", Status: ResultStatusValid, Valid: 2, Quorum: 1},
+			}},
+		},
+		{
+			name:     "groupNotCalled",
+			strategy: strategy(StrategyAll),
+			results:  []BankAccountValidationResult{valid("provider1")},
+			groups:   groups,
+			want:     &AggregateResult{Strategy: StrategyAll, IsValid: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := aggregateGroups(tt.strategy, tt.results, nil, tt.groups); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("aggregateGroups() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+  Static headers per provider. Some partners want to know who is calling (X-Partner-Id, a particular User-Agent) and
+  reject anything that doesn't identify itself. userAgent is shorthand for the User-Agent header; the headers are sent
+  on validation calls and health probes alike.
+
+    - name: provider1
+      url: https://provider1.com/v1/api/account/validate
+      userAgent: accountvalidator/1.0 (partner 1234)
+      headers:
+        X-Partner-Id: "1234"
+*/
+
+// We set these ourselves, a provider can't override them
+var reservedHeaders = map[string]bool{
+	"Content-Type":      true,
+	"Content-Length":    true,
+	"Host":              true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+// Checks header names and values are something we can actually send, called from compile
+func (provider Provider) validateHeaders() error {
+	for name, value := range provider.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("provider %s has an invalid header name %q", provider.Name, name)
+		}
+		if reservedHeaders[http.CanonicalHeaderKey(name)] {
+			return fmt.Errorf("provider %s can't set the %s header", provider.Name, http.CanonicalHeaderKey(name))
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("provider %s header %s has a newline in its value", provider.Name, name)
+		}
+	}
+	if strings.ContainsAny(provider.UserAgent, "\r\n") {
+		return fmt.Errorf("provider %s userAgent has a newline in it", provider.Name)
+	}
+	return nil
+}
+
+// Adds the provider's static headers to an outbound request
+func (provider Provider) setHeaders(request *http.Request) {
+	for name, value := range provider.Headers {
+		request.Header.Set(name, value)
+	}
+	if provider.UserAgent != "" {
+		request.Header.Set("User-Agent", provider.UserAgent)
+	}
+}
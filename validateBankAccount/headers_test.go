@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_callProvider_headers(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer server.Close()
+
+	provider := Provider{
+		Name:      "provider1",
+		URL:       server.URL,
+		UserAgent: "accountvalidator/1.0 (partner 1234)",
+		Headers:   map[string]string{"x-partner-id": "1234"},
+	}
+	if result := callProvider(context.Background(), "12345678", provider, provider.URL); result.Error != "" {
+		t.Fatalf("callProvider() error = %v", result.Error)
+	}
+	want := map[string]string{
+		"X-Partner-Id": "1234",
+		"User-Agent":   "accountvalidator/1.0 (partner 1234)",
+		"Content-Type": "application/json",
+	}
+	for name, value := range want {
+		if got.Get(name) != value {
+			t.Errorf("header %s = %q, want %q", name, got.Get(name), value)
+		}
+	}
+}
+
+func TestProvider_validateHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		wantErr  bool
+	}{
+		{name: "none", provider: Provider{Name: "provider1"}},
+		{name: "valid", provider: Provider{Name: "provider1", UserAgent: "partner/1.0", Headers: map[string]string{"X-Partner-Id": "1234"}}},
+		{name: "badName", provider: Provider{Name: "provider1", Headers: map[string]string{"X Partner": "1234"}}, wantErr: true},
+		{name: "reserved", provider: Provider{Name: "provider1", Headers: map[string]string{"content-type": "text/xml"}}, wantErr: true},
+		{name: "newlineValue", provider: Provider{Name: "provider1", Headers: map[string]string{"X-Partner-Id": "1234\r\nX-Admin: 1"}}, wantErr: true},
+		{name: "newlineUserAgent", provider: Provider{Name: "provider1", UserAgent: "partner\n"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.provider.validateHeaders(); (err != nil) != tt.wantErr {
+				t.Errorf("validateHeaders() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+)
+
+/*
+  Health and the providers, for load balancers, dashboards and whoever's on call:
+
+    GET /health      {"status": "ok"}, or a 503 with "unavailable" when no provider would be called
+    GET /providers   [{"name": "provider1", "type": "rest", "enabled": true, "countries": ["GB"], "circuit": "closed"}]
+
+  Both are about this container. Breakers live per container (see breaker.go), so another container can have a
+  provider's circuit open while this one has it closed.
+*/
+
+type ProviderSummary struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type,omitempty"`
+	Enabled   bool     `json:"enabled"`
+	Countries []string `json:"countries,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	// closed, open or halfOpen, or forcedOpen or forcedClosed (see breakercontrols.go)
+	Circuit string `json:"circuit"`
+}
+
+func (config *Config) providerSummaries() []ProviderSummary {
+	summaries := []ProviderSummary{}
+	for _, provider := range config.Providers {
+		summaries = append(summaries, provider.summary())
+	}
+	return summaries
+}
+
+func (provider Provider) summary() ProviderSummary {
+	return ProviderSummary{
+		Name:      provider.Name,
+		Type:      provider.Type,
+		Enabled:   !provider.disabled(),
+		Countries: provider.Countries,
+		Tags:      provider.Tags,
+		Circuit:   provider.breaker.stateName(),
+	}
+}
+
+// Whether any provider would be called right now
+func (config *Config) healthy() bool {
+	for _, provider := range enabledProviders(config.Providers) {
+		if provider.breaker.wouldAllow() {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler for GET /health
+func (config *Config) HealthHandler(ctx context.Context, request Request) (Response, error) {
+	if !config.healthy() {
+		return jsonResponse(503, map[string]string{"status": "unavailable"})
+	}
+	return jsonResponse(200, map[string]string{"status": "ok"})
+}
+
+// Handler for GET /providers
+func (config *Config) ProvidersHandler(ctx context.Context, request Request) (Response, error) {
+	return jsonResponse(200, config.providerSummaries())
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConfig_HealthHandler(t *testing.T) {
+	open := newCircuitBreaker(BreakerConfig{})
+	open.openSince(time.Now())
+	disabled := false
+	tests := []struct {
+		name      string
+		providers []Provider
+		want      int
+	}{
+		{"ok", []Provider{{Name: "provider1", breaker: open}, {Name: "provider2", breaker: newCircuitBreaker(BreakerConfig{})}}, 200},
+		{"allOpen", []Provider{{Name: "provider1", breaker: open}, {Name: "provider2", Enabled: &disabled}}, 503},
+		{"none", nil, 503},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Providers: tt.providers}
+			if got, _ := config.HealthHandler(context.Background(), Request{}); got.StatusCode != tt.want {
+				t.Errorf("HealthHandler() = %d %s, want %d", got.StatusCode, got.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_ProvidersHandler(t *testing.T) {
+	open := newCircuitBreaker(BreakerConfig{})
+	open.openSince(time.Now())
+	disabled := false
+	config := &Config{Providers: []Provider{
+		{Name: "provider1", Type: ProviderTypeSimulated, Countries: []string{"GB"}, breaker: open},
+		{Name: "provider2", Enabled: &disabled},
+	}}
+	got, _ := config.ProvidersHandler(context.Background(), Request{})
+	want := `[{"name":"provider1","type":"simulated","enabled":true,"countries":["GB"],"circuit":"open"},{"name":"provider2","enabled":false,"circuit":"closed"}]`
+	if got.StatusCode != 200 || got.Body != want {
+		t.Errorf("ProvidersHandler() = %d %s, want 200 %s", got.StatusCode, got.Body, want)
+	}
+}
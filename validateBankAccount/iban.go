@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+  IBAN construction. For countries where the IBAN follows from the domestic details, a request that says the country
+  and bank code gets the IBAN back in the response metadata, so payment systems downstream don't need a second
+  service to build it:
+
+    {"accountNumber": "31926819", "country": "GB", "bankCode": "60-16-13", "bic": "NWBKGB2L"}
+    "metadata": {..., "iban": "GB29NWBK60161331926819"}
+
+    GB, IE  bankCode is the sort code, the account number is 8 digits and the bank's letters come from the bic
+    DE      bankCode is the 8 digit Bankleitzahl, the account number up to 10 digits
+    AT      bankCode is the 5 digit Bankleitzahl, the account number up to 11 digits
+    NL      bankCode is the 4 letter bank code, or the bic's, the account number up to 10 digits
+    BE      the account number is the 12 digit domestic number, no bankCode
+    ES      the account number is the 20 digit CCC, no bankCode
+
+  Spaces and dashes are ignored. Anywhere else, or when the details don't fit, there's no IBAN and the response says
+  why in its warnings. Nothing is checked with a provider, a well formed IBAN can still be for an account that doesn't
+  exist.
+*/
+
+// Builds the IBAN for the request's domestic details. Without a country there's nothing to build and no error.
+func constructIBAN(validationRequest *BankAccountValidationRequest) (string, error) {
+	if validationRequest.Country == nil {
+		return "", nil
+	}
+	country := strings.ToUpper(strings.TrimSpace(*validationRequest.Country))
+	account := domesticDigits(*validationRequest.AccountNumber)
+	bankCode := ""
+	if validationRequest.BankCode != nil {
+		bankCode = strings.ToUpper(domesticDigits(*validationRequest.BankCode))
+	}
+	bicBank := ""
+	if validationRequest.BIC != nil {
+		if bic := strings.ToUpper(strings.TrimSpace(*validationRequest.BIC)); validBIC(bic) {
+			bicBank = bic[:4]
+		}
+	}
+
+	var bban string
+	switch country {
+	case "GB", "IE":
+		if bicBank == "" {
+			return "", fmt.Errorf("%s IBANs need a valid bic for the bank code", country)
+		}
+		if !allDigits(bankCode, 6, 6) || !allDigits(account, 8, 8) {
+			return "", fmt.Errorf("%s IBANs need a 6 digit sort code and an 8 digit account number", country)
+		}
+		bban = bicBank + bankCode + account
+	case "DE":
+		if !allDigits(bankCode, 8, 8) || !allDigits(account, 1, 10) {
+			return "", fmt.Errorf("DE IBANs need an 8 digit bank code and an account number of up to 10 digits")
+		}
+		bban = bankCode + leftPad(account, 10)
+	case "AT":
+		if !allDigits(bankCode, 5, 5) || !allDigits(account, 1, 11) {
+			return "", fmt.Errorf("AT IBANs need a 5 digit bank code and an account number of up to 11 digits")
+		}
+		bban = bankCode + leftPad(account, 11)
+	case "NL":
+		if bankCode == "" {
+			bankCode = bicBank
+		}
+		if len(bankCode) != 4 || strings.Trim(bankCode, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" || !allDigits(account, 1, 10) {
+			return "", fmt.Errorf("NL IBANs need a 4 letter bank code and an account number of up to 10 digits")
+		}
+		bban = bankCode + leftPad(account, 10)
+	case "BE":
+		if !allDigits(account, 12, 12) {
+			return "", fmt.Errorf("BE IBANs need a 12 digit account number")
+		}
+		bban = account
+	case "ES":
+		if !allDigits(account, 20, 20) {
+			return "", fmt.Errorf("ES IBANs need a 20 digit account number")
+		}
+		bban = account
+	default:
+		return "", fmt.Errorf("IBANs can't be built for %s", country)
+	}
+	return fmt.Sprintf("%s%02d%s", country, 98-ibanMod97(bban+country+"00"), bban), nil
+}
+
+// The account details with the spaces and dashes people type taken out
+func domesticDigits(value string) string {
+	// strings.Map hands back the same string, without allocating, when there's nothing to take out
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(value))
+}
+
+func allDigits(value string, minLength, maxLength int) bool {
+	if len(value) < minLength || len(value) > maxLength {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if !isDigit(value[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func leftPad(digits string, length int) string {
+	return strings.Repeat("0", length-len(digits)) + digits
+}
+
+// ISO 7064 mod 97 over the IBAN's digits, letters count as 10 to 35. Done a digit at a time to stay in an int.
+func ibanMod97(value string) int {
+	remainder := 0
+	for i := 0; i < len(value); i++ {
+		digits := string(value[i])
+		if isUpperLetter(value[i]) {
+			digits = strconv.Itoa(int(value[i]-'A') + 10)
+		}
+		for j := 0; j < len(digits); j++ {
+			remainder = (remainder*10 + int(digits[j]-'0')) % 97
+		}
+	}
+	return remainder
+}
+
+// The IBAN for the response's metadata
+func withIBAN(metadata *ResponseMetadata, iban string) *ResponseMetadata {
+	copied := copyMetadata(metadata)
+	copied.IBAN = iban
+	return copied
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_constructIBAN(t *testing.T) {
+	optional := func(value string) *string {
+		if value == "" {
+			return nil
+		}
+		return &value
+	}
+	tests := []struct {
+		name          string
+		accountNumber string
+		country       string
+		bankCode      string
+		bic           string
+		want          string
+		wantErr       bool
+	}{
+		{name: "noCountry", accountNumber: "31926819"},
+		{name: "gb", accountNumber: "31926819", country: "GB", bankCode: "60-16-13", bic: "NWBKGB2L", want: "GB29NWBK60161331926819"},
+		{name: "gbWithoutBIC", accountNumber: "31926819", country: "GB", bankCode: "601613", wantErr: true},
+		{name: "gbShortAccount", accountNumber: "3192681", country: "gb", bankCode: "601613", bic: "NWBKGB2L", wantErr: true},
+		{name: "ie", accountNumber: "12345678", country: "IE", bankCode: "931152", bic: "AIBKIE2D", want: "IE29AIBK93115212345678"},
+		{name: "de", accountNumber: "532013000", country: "DE", bankCode: "37040044", want: "DE89370400440532013000"},
+		{name: "at", accountNumber: "234573201", country: "AT", bankCode: "19043", want: "AT611904300234573201"},
+		{name: "nl", accountNumber: "0417164300", country: "NL", bankCode: "abna", want: "NL91ABNA0417164300"},
+		{name: "nlFromBIC", accountNumber: "417164300", country: "NL", bic: "ABNANL2A", want: "NL91ABNA0417164300"},
+		{name: "be", accountNumber: "539-0075470-34", country: "BE", want: "BE68539007547034"},
+		{name: "es", accountNumber: "2100 0418 4502 0005 1332", country: "ES", want: "ES9121000418450200051332"},
+		{name: "unsupported", accountNumber: "12345678", country: "FR", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validationRequest := &BankAccountValidationRequest{
+				AccountNumber: &tt.accountNumber,
+				Country:       optional(tt.country),
+				BankCode:      optional(tt.bankCode),
+				BIC:           optional(tt.bic),
+			}
+			got, err := constructIBAN(validationRequest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("constructIBAN() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("constructIBAN() = %v, want %v", got, tt.want)
+			}
+			if got != "" && ibanMod97(got[4:]+got[:4]) != 1 {
+				t.Errorf("constructIBAN() = %v, which doesn't check", got)
+			}
+		})
+	}
+}
+
+func TestConfig_validate_iban(t *testing.T) {
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		metadata:  &ResponseMetadata{ConfigHash: "9f86d081884c"},
+	}
+	accountNumber, country, bankCode := "532013000", "DE", "37040044"
+	response := config.validate(context.Background(), Request{}, &BankAccountValidationRequest{AccountNumber: &accountNumber, Country: &country, BankCode: &bankCode}, nil)
+	if response.Metadata.IBAN != "DE89370400440532013000" || response.Metadata.ConfigHash != "9f86d081884c" {
+		t.Errorf("metadata = %+v", response.Metadata)
+	}
+	if config.metadata.IBAN != "" {
+		t.Error("the config's metadata is shared and shouldn't be written to")
+	}
+
+	country = "FR"
+	response = config.validate(context.Background(), Request{}, &BankAccountValidationRequest{AccountNumber: &accountNumber, Country: &country}, nil)
+	if response.Metadata.IBAN != "" || len(response.Warnings) != 1 || response.Warnings[0] != "IBANs can't be built for FR" {
+		t.Errorf("metadata = %+v, warnings = %v", response.Metadata, response.Warnings)
+	}
+}
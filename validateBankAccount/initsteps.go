@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+  Init in parallel. Most of init is waiting on AWS: reseeding the breakers from the status table, fetching secrets,
+  loading SEPA datasets, building clients. None of that depends on the rest, so it runs at the same time, in waves
+  where one step needs another (telemetry needs the event bus, alerts, the SLA recorder, body logging, quotas,
+  webhooks and the outbox need telemetry):
+
+    1. statusStore, toggles, breakerControls, configHistory, secrets, snsResults, verdicts, sepa,
+       responseSigning, capture, rateLimits
+    2. security, telemetry
+    3. alerts, sla, feedback, bodyLogging, quotas, webhooks, outbox
+
+  Every step gets a deadline, so one slow dependency can't hold up the container:
+
+    init:
+      timeoutMs: 2000   # how long any one step gets, default 2000
+
+  A step that runs out of time gives up, logs that it did and the container starts serving without it. Each of them
+  already copes with that, a failure at init was never fatal: secrets are fetched by the first request that needs
+  them, the breakers start closed and a SEPA dataset is loaded on its next refresh.
+*/
+
+const defaultInitStepTimeout = 2 * time.Second
+
+type InitConfig struct {
+	TimeoutMs int `yaml:"timeoutMs"`
+}
+
+func (init InitConfig) validate() error {
+	if init.TimeoutMs < 0 {
+		return errors.New("init timeoutMs can't be negative")
+	}
+	return nil
+}
+
+func (init InitConfig) timeout() time.Duration {
+	if init.TimeoutMs == 0 {
+		return defaultInitStepTimeout
+	}
+	return time.Duration(init.TimeoutMs) * time.Millisecond
+}
+
+type initStep struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+// Runs the steps of each wave at the same time, the next wave starting when they've all returned
+func (config *Config) runInit(ctx context.Context, waves ...[]initStep) {
+	for _, wave := range waves {
+		var wg sync.WaitGroup
+		for _, step := range wave {
+			wg.Add(1)
+			go func(step initStep) {
+				defer wg.Done()
+				stepCtx, cancel := context.WithTimeout(ctx, config.Init.timeout())
+				defer cancel()
+				start := time.Now()
+				step.run(stepCtx)
+				if errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+					log.Printf("init step %s ran out of time after %s, starting without it", step.name, time.Since(start))
+				}
+			}(step)
+		}
+		wg.Wait()
+	}
+}
+
+// Everything init does after the config is loaded
+func (config *Config) initSteps() [][]initStep {
+	return [][]initStep{{
+		{"statusStore", config.setupStatusStore},
+		{"toggles", config.setupToggles},
+		{"breakerControls", config.setupBreakerControls},
+		{"configHistory", config.setupConfigHistory},
+		{"secrets", func(ctx context.Context) {
+			fetched := containerInit.phase(InitPhaseSecretsFetch)
+			config.setupSecrets(ctx)
+			fetched()
+		}},
+		{"snsResults", config.setupSNSResults},
+		{"verdicts", config.setupVerdicts},
+		{"sepa", config.setupSEPA},
+		{"responseSigning", config.setupResponseSigning},
+		{"capture", config.setupCapture},
+		{"rateLimits", config.setupRateLimits},
+	}, {
+		{"telemetry", config.setupTelemetry},
+	}, {
+		{"security", func(ctx context.Context) { config.setupSecurity() }},
+		{"alerts", config.setupAlerts},
+		{"sla", config.setupSLA},
+		{"feedback", config.setupFeedback},
+		{"bodyLogging", config.setupBodyLogging},
+		{"quotas", config.setupQuotas},
+		{"webhooks", config.setupWebhooks},
+		{"outbox", config.setupOutbox},
+	}}
+}
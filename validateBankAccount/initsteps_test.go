@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConfig_runInit(t *testing.T) {
+	config := &Config{Init: InitConfig{TimeoutMs: 100}}
+	var mu sync.Mutex
+	order := []string{}
+	done := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, name)
+	}
+	sleep := func(name string, duration time.Duration) initStep {
+		return initStep{name, func(ctx context.Context) {
+			select {
+			case <-time.After(duration):
+				done(name)
+			case <-ctx.Done():
+				done(name + " gave up")
+			}
+		}}
+	}
+
+	start := time.Now()
+	config.runInit(context.Background(),
+		[]initStep{sleep("secrets", 50*time.Millisecond), sleep("statusStore", 50*time.Millisecond), sleep("sepa", time.Minute)},
+		[]initStep{sleep("telemetry", 0)},
+	)
+	elapsed := time.Since(start)
+
+	// The first wave's steps ran together and the slow one was cut off
+	if elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("runInit() took %v, want the step timeout of 100ms", elapsed)
+	}
+	if len(order) != 4 || order[2] != "sepa gave up" || order[3] != "telemetry" {
+		t.Errorf("steps finished %v, want the first wave then telemetry", order)
+	}
+}
+
+func TestInitConfig(t *testing.T) {
+	if got := (InitConfig{}).timeout(); got != defaultInitStepTimeout {
+		t.Errorf("timeout() = %v, want %v", got, defaultInitStepTimeout)
+	}
+	if got := (InitConfig{TimeoutMs: 500}).timeout(); got != 500*time.Millisecond {
+		t.Errorf("timeout() = %v, want 500ms", got)
+	}
+	if err := (InitConfig{TimeoutMs: -1}).validate(); err == nil {
+		t.Errorf("validate() accepted a negative timeout")
+	}
+}
+
+func TestConfig_initSteps(t *testing.T) {
+	// Nothing configured in the environment, so every step returns straight away
+	config := &Config{}
+	config.runInit(context.Background(), config.initSteps()...)
+	if config.telemetry == nil || config.security == nil {
+		t.Errorf("runInit() didn't set up telemetry and security")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+  Per-item deadlines for batches and matrices. Left alone, the first items of a big batch take as long as their
+  slowest provider and the last ones run into the Lambda timeout with nothing at all. So each item gets its own
+  deadline when it starts: what's left of the budget shared by the waves of items still to run, concurrency at a
+  time:
+
+    batch:
+      budgetMs: 25000   # default the invocation's remaining time less deadlineMarginMs, no limit without one
+
+  When an item's share is less than its providers need (their p95 latency over the last minute, or the timeout when
+  they haven't been called lately) it goes to only the one that needs least, with a warning, rather than to all of
+  them. Every item gets at least one provider, breadth before depth. The count is only known for API Gateway
+  batches and matrices, a streamed batch is read as it goes so its items just share the deadline.
+*/
+
+// Time kept back for writing the response out
+const deadlineMarginMs = 500
+
+type batchSchedule struct {
+	mu          sync.Mutex
+	deadline    time.Time
+	remaining   int
+	concurrency int
+	now         func() time.Time
+}
+
+// The schedule for total items, 0 when the count isn't known, nil when there's no budget to share
+func (config *Config) newBatchSchedule(ctx context.Context, total int) *batchSchedule {
+	now := time.Now()
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		deadline = deadline.Add(-deadlineMarginMs * time.Millisecond)
+	}
+	if config.Batch.BudgetMs > 0 {
+		budget := now.Add(time.Duration(config.Batch.BudgetMs) * time.Millisecond)
+		if !hasDeadline || budget.Before(deadline) {
+			deadline, hasDeadline = budget, true
+		}
+	}
+	if !hasDeadline {
+		return nil
+	}
+	return &batchSchedule{deadline: deadline, remaining: total, concurrency: config.Batch.concurrency(), now: time.Now}
+}
+
+// The share of the budget for the item starting now
+func (schedule *batchSchedule) itemBudget() time.Duration {
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+	waves := 1
+	if schedule.remaining > 0 {
+		waves = (schedule.remaining + schedule.concurrency - 1) / schedule.concurrency
+		schedule.remaining--
+	}
+	left := schedule.deadline.Sub(schedule.now())
+	if left < 0 {
+		return 0
+	}
+	return left / time.Duration(waves)
+}
+
+// How long a call to the provider should take
+func (provider Provider) expectedLatency() time.Duration {
+	if snapshot := provider.stats.snapshot(0); snapshot.Calls > 0 {
+		return snapshot.P95
+	}
+	return provider.timeout()
+}
+
+// Gives the item its deadline, narrowing it to one provider when there isn't time for them all. The warning is for
+// the item's response.
+func (config *Config) scheduleItem(ctx context.Context, schedule *batchSchedule, validationRequest *BankAccountValidationRequest) (context.Context, context.CancelFunc, string) {
+	if schedule == nil {
+		return ctx, func() {}, ""
+	}
+	budget := schedule.itemBudget()
+	providers := config.selectedProviders(ctx, validationRequest)
+	if len(providers) > 1 {
+		quickest, quickestLatency, needed := providers[0], providers[0].expectedLatency(), time.Duration(0)
+		for _, provider := range providers {
+			latency := provider.expectedLatency()
+			if latency > needed {
+				needed = latency
+			}
+			if latency < quickestLatency {
+				quickest, quickestLatency = provider, latency
+			}
+		}
+		if needed > budget {
+			config.telemetry.count("ItemsNarrowed", 1)
+			validationRequest.Providers = &[]string{quickest.Name}
+			itemCtx, cancel := context.WithTimeout(ctx, budget)
+			return itemCtx, cancel, fmt.Sprintf("only %s was called, there wasn't time for all %d providers", quickest.Name, len(providers))
+		}
+	}
+	itemCtx, cancel := context.WithTimeout(ctx, budget)
+	return itemCtx, cancel, ""
+}
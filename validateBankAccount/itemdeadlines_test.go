@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_batchSchedule_itemBudget(t *testing.T) {
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	schedule := &batchSchedule{deadline: now.Add(10 * time.Second), remaining: 4, concurrency: 2, now: func() time.Time { return now }}
+	// 4 items 2 at a time is two waves, then the last 2 are one
+	want := []time.Duration{5 * time.Second, 5 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, budget := range want {
+		if got := schedule.itemBudget(); got != budget {
+			t.Errorf("item %d budget = %v, want %v", i, got, budget)
+		}
+	}
+	now = now.Add(11 * time.Second)
+	if got := schedule.itemBudget(); got != 0 {
+		t.Errorf("budget past the deadline = %v, want 0", got)
+	}
+}
+
+func TestConfig_newBatchSchedule(t *testing.T) {
+	if schedule := (&Config{}).newBatchSchedule(context.Background(), 10); schedule != nil {
+		t.Error("newBatchSchedule() scheduled without a deadline or budget")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	schedule := (&Config{}).newBatchSchedule(ctx, 10)
+	if schedule == nil || !schedule.deadline.Equal(deadline.Add(-deadlineMarginMs*time.Millisecond)) {
+		t.Errorf("newBatchSchedule() = %+v, want the invocation's deadline less the margin", schedule)
+	}
+	// A shorter budget wins
+	schedule = (&Config{Batch: BatchConfig{BudgetMs: 2000}}).newBatchSchedule(ctx, 10)
+	if schedule == nil || schedule.deadline.After(time.Now().Add(2*time.Second)) {
+		t.Errorf("newBatchSchedule() = %+v, want the budget", schedule)
+	}
+}
+
+func TestConfig_scheduleItem(t *testing.T) {
+	quick := newProviderStats(time.Minute)
+	quick.record(50*time.Millisecond, "")
+	config := &Config{Providers: []Provider{
+		{Name: "provider1", Type: ProviderTypeSimulated, TimeoutMs: 3000},
+		{Name: "provider2", Type: ProviderTypeSimulated, stats: quick},
+	}}
+	tests := []struct {
+		name          string
+		budget        time.Duration
+		wantProviders *[]string
+		wantWarning   string
+	}{
+		{"enoughTime", 5 * time.Second, nil, ""},
+		{"short", time.Second, &[]string{"provider2"}, "only provider2 was called, there wasn't time for all 2 providers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := &batchSchedule{deadline: time.Now().Add(tt.budget), remaining: 1, concurrency: 1, now: time.Now}
+			accountNumber := "12345670"
+			validationRequest := &BankAccountValidationRequest{AccountNumber: &accountNumber}
+			ctx, cancel, warning := config.scheduleItem(context.Background(), schedule, validationRequest)
+			defer cancel()
+			if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+				t.Error("scheduleItem() didn't give the item a deadline")
+			}
+			if warning != tt.wantWarning || (tt.wantProviders == nil) != (validationRequest.Providers == nil) ||
+				(tt.wantProviders != nil && (*validationRequest.Providers)[0] != (*tt.wantProviders)[0]) {
+				t.Errorf("scheduleItem() = %v, %q, want %v, %q", validationRequest.Providers, warning, tt.wantProviders, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestConfig_BatchHandler_narrowsWhenShort(t *testing.T) {
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}, {Name: "provider2", Type: ProviderTypeSimulated, TimeoutMs: 3000}},
+		Batch:     BatchConfig{BudgetMs: 2000, Concurrency: 1},
+	}
+	body := "{\"requests\": [{\"accountNumber\": \"12345670\"}, {\"accountNumber\": \"12345672\"}]}"
+	response, _ := config.Router(context.Background(), Request{HTTPMethod: "POST", Path: "/validate-batch", Body: body})
+
+	// Two waves of a 2s budget is about 1s each, not enough for provider2's 3s
+	for _, result := range parseBatchResults(t, response.Body) {
+		if result.Response == nil || len(result.Response.Result) != 1 || result.Response.Result[0].Provider != "provider1" || len(result.Response.Warnings) != 1 {
+			t.Errorf("batch result %d = %+v, want only provider1 with a warning", result.Index, result.Response)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/*
+  Kafka mode. Validation requests arrive as messages on an input topic and results go to an output topic, keyed the
+  same as the request so callers can match them up.
+
+  MODE=kafka is the Lambda MSK trigger: Lambda does the consuming and hands us batches. MODE=kafka-consumer is a
+  long running consumer group for running outside Lambda. Both produce through a Kafka REST proxy (Confluent REST v2)
+  since we don't carry a Kafka client, and the standalone consumer reads through it too.
+
+  Delivery is at least once. Results are produced before the batch is acknowledged (the trigger returning without an
+  error, or the consumer committing offsets), so a failure part way through means the batch is redelivered and some
+  results are produced twice. The REST proxy's consumer has already moved past a batch it handed us, so when
+  producing or committing fails the consumer seeks back to the start of the batch, and if it can't it's deleted and
+  a new one picks up from the last commit. A message that isn't a valid request gets an error result rather than
+  being retried.
+
+    kafka:
+      restProxyUrl: http://kafka-rest.internal:8082
+      outputTopic: account-validation-results
+      concurrency: 10                           # requests validated at once, default 10
+      inputTopic: account-validation-requests   # kafka-consumer only
+      consumerGroup: account-validator          # kafka-consumer only
+*/
+
+type KafkaConfig struct {
+	RestProxyURL  string `yaml:"restProxyUrl"`
+	OutputTopic   string `yaml:"outputTopic"`
+	Concurrency   int    `yaml:"concurrency"`
+	InputTopic    string `yaml:"inputTopic"`
+	ConsumerGroup string `yaml:"consumerGroup"`
+}
+
+const (
+	defaultKafkaConcurrency = 10
+	kafkaJSONContentType    = "application/vnd.kafka.json.v2+json"
+	kafkaBinaryContentType  = "application/vnd.kafka.binary.v2+json"
+	kafkaPollInterval       = time.Second
+)
+
+// A request read off the input topic
+type kafkaMessage struct {
+	Topic     string
+	Partition int64
+	Offset    int64
+	Key       []byte
+	Value     []byte
+}
+
+// What we produce to the output topic
+type KafkaResult struct {
+	Response *BankAccountValidationResponse `json:"response,omitempty"`
+	Error    string                         `json:"error,omitempty"`
+}
+
+type kafkaRecord struct {
+	Key   *string     `json:"key,omitempty"`
+	Value KafkaResult `json:"value"`
+}
+
+func (kafka KafkaConfig) concurrency() int {
+	if kafka.Concurrency <= 0 {
+		return defaultKafkaConcurrency
+	}
+	return kafka.Concurrency
+}
+
+// Validates every message, at most concurrency at a time. Results are in the same order as the messages.
+func (config *Config) processKafkaMessages(ctx context.Context, messages []kafkaMessage) []kafkaRecord {
+	records := make([]kafkaRecord, len(messages))
+	slots := make(chan struct{}, config.Kafka.concurrency())
+	var wg sync.WaitGroup
+	for i, message := range messages {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, message kafkaMessage) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			records[i] = config.processKafkaMessage(ctx, message)
+		}(i, message)
+	}
+	wg.Wait()
+	return records
+}
+
+func (config *Config) processKafkaMessage(ctx context.Context, message kafkaMessage) kafkaRecord {
+	record := kafkaRecord{}
+	if message.Key != nil {
+		key := string(message.Key)
+		record.Key = &key
+	}
+	validationRequest, errorMessage, err := decodeRequest(Request{Body: string(message.Value)})
+	if err != nil {
+		log.Printf("bad request at %s/%d/%d: %v", message.Topic, message.Partition, message.Offset, err)
+		record.Value.Error = errorMessage
+		return record
+	}
+	response := config.validate(ctx, Request{}, validationRequest, nil)
+	record.Value.Response = &response
+	return record
+}
+
+// Talks to the Kafka REST proxy
+type kafkaRestClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newKafkaRestClient(baseURL string) *kafkaRestClient {
+	return &kafkaRestClient{baseURL: baseURL, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (rest *kafkaRestClient) do(ctx context.Context, method, target, contentType, accept string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := marshalJSON(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+	response, err := rest.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("kafka rest proxy %s %s: %s %s", method, target, response.Status, data)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// Produces records to a topic, failing if the proxy reports an error for any of them
+func (rest *kafkaRestClient) produce(ctx context.Context, topic string, records []kafkaRecord) error {
+	var response struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	target := rest.baseURL + "/topics/" + url.PathEscape(topic)
+	if err := rest.do(ctx, "POST", target, kafkaJSONContentType, kafkaJSONContentType, map[string]interface{}{"records": records}, &response); err != nil {
+		return err
+	}
+	for _, offset := range response.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("producing to %s failed: %s", topic, offset.Error)
+		}
+	}
+	return nil
+}
+
+// Lambda handler for the MSK trigger. Returning an error makes Lambda redeliver the batch.
+func (config *Config) KafkaHandler(ctx context.Context, event events.KafkaEvent) error {
+	if config.Kafka.RestProxyURL == "" || config.Kafka.OutputTopic == "" {
+		return errors.New("kafka restProxyUrl and outputTopic are required")
+	}
+	messages := []kafkaMessage{}
+	for _, records := range event.Records {
+		for _, record := range records {
+			message := kafkaMessage{Topic: record.Topic, Partition: record.Partition, Offset: record.Offset}
+			if record.Key != "" {
+				key, err := base64.StdEncoding.DecodeString(record.Key)
+				if err != nil {
+					log.Printf("undecodable key at %s/%d/%d: %v", record.Topic, record.Partition, record.Offset, err)
+				}
+				message.Key = key
+			}
+			value, err := base64.StdEncoding.DecodeString(record.Value)
+			if err != nil {
+				log.Printf("undecodable value at %s/%d/%d: %v", record.Topic, record.Partition, record.Offset, err)
+			}
+			message.Value = value
+			messages = append(messages, message)
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	results := config.processKafkaMessages(ctx, messages)
+	return newKafkaRestClient(config.Kafka.RestProxyURL).produce(ctx, config.Kafka.OutputTopic, results)
+}
+
+// A consumer instance on the REST proxy
+type kafkaConsumer struct {
+	rest    *kafkaRestClient
+	baseURI string
+}
+
+func (rest *kafkaRestClient) newConsumer(ctx context.Context, group, topic, name string) (*kafkaConsumer, error) {
+	var created struct {
+		BaseURI string `json:"base_uri"`
+	}
+	settings := map[string]string{
+		"name":               name,
+		"format":             "binary",
+		"auto.offset.reset":  "earliest",
+		"auto.commit.enable": "false",
+	}
+	if err := rest.do(ctx, "POST", rest.baseURL+"/consumers/"+url.PathEscape(group), kafkaJSONContentType, "", settings, &created); err != nil {
+		return nil, err
+	}
+	consumer := &kafkaConsumer{rest: rest, baseURI: created.BaseURI}
+	if err := rest.do(ctx, "POST", consumer.baseURI+"/subscription", kafkaJSONContentType, "", map[string][]string{"topics": {topic}}, nil); err != nil {
+		consumer.close()
+		return nil, err
+	}
+	return consumer, nil
+}
+
+func (consumer *kafkaConsumer) poll(ctx context.Context) ([]kafkaMessage, error) {
+	var records []struct {
+		Topic     string `json:"topic"`
+		Key       []byte `json:"key"`
+		Value     []byte `json:"value"`
+		Partition int64  `json:"partition"`
+		Offset    int64  `json:"offset"`
+	}
+	if err := consumer.rest.do(ctx, "GET", consumer.baseURI+"/records", "", kafkaBinaryContentType, nil, &records); err != nil {
+		return nil, err
+	}
+	messages := make([]kafkaMessage, 0, len(records))
+	for _, record := range records {
+		messages = append(messages, kafkaMessage{Topic: record.Topic, Partition: record.Partition, Offset: record.Offset, Key: record.Key, Value: record.Value})
+	}
+	return messages, nil
+}
+
+// The highest offset of the messages on each partition, or the lowest
+func partitionOffsets(messages []kafkaMessage, highest bool) []map[string]interface{} {
+	type partitionKey struct {
+		topic     string
+		partition int64
+	}
+	picked := map[partitionKey]int64{}
+	for _, message := range messages {
+		key := partitionKey{message.Topic, message.Partition}
+		if offset, exists := picked[key]; !exists || (message.Offset > offset) == highest {
+			picked[key] = message.Offset
+		}
+	}
+	offsets := []map[string]interface{}{}
+	for key, offset := range picked {
+		offsets = append(offsets, map[string]interface{}{"topic": key.topic, "partition": key.partition, "offset": offset})
+	}
+	return offsets
+}
+
+// Commits the highest offset we've handled on each partition
+func (consumer *kafkaConsumer) commit(ctx context.Context, messages []kafkaMessage) error {
+	offsets := partitionOffsets(messages, true)
+	return consumer.rest.do(ctx, "POST", consumer.baseURI+"/offsets", kafkaJSONContentType, "", map[string]interface{}{"offsets": offsets}, nil)
+}
+
+// Moves the consumer back to the first of the messages on each partition, so the next poll reads them again
+func (consumer *kafkaConsumer) seek(ctx context.Context, messages []kafkaMessage) error {
+	offsets := partitionOffsets(messages, false)
+	return consumer.rest.do(ctx, "POST", consumer.baseURI+"/positions", kafkaJSONContentType, "", map[string]interface{}{"offsets": offsets}, nil)
+}
+
+func (consumer *kafkaConsumer) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := consumer.rest.do(ctx, "DELETE", consumer.baseURI, kafkaJSONContentType, "", nil, nil); err != nil {
+		log.Print(err)
+	}
+}
+
+// The consumer couldn't be put back to the start of a batch it didn't finish, so it has to be replaced
+var errKafkaConsumerLost = errors.New("kafka consumer couldn't seek back to an unfinished batch")
+
+// One poll, validate, produce, commit cycle. Nothing is committed unless everything was produced, and a batch that
+// isn't committed is read again.
+func (config *Config) consumeOnce(ctx context.Context, consumer *kafkaConsumer) (int, error) {
+	messages, err := consumer.poll(ctx)
+	if err != nil || len(messages) == 0 {
+		return 0, err
+	}
+	results := config.processKafkaMessages(ctx, messages)
+	err = consumer.rest.produce(ctx, config.Kafka.OutputTopic, results)
+	if err == nil {
+		err = consumer.commit(ctx, messages)
+	}
+	if err == nil {
+		return len(messages), nil
+	}
+	if seekErr := consumer.seek(ctx, messages); seekErr != nil {
+		return 0, fmt.Errorf("%w: %v (%v)", errKafkaConsumerLost, err, seekErr)
+	}
+	return 0, err
+}
+
+// Runs the standalone consumer group until the context is cancelled
+func (config *Config) consumeKafka(ctx context.Context, name string) error {
+	kafka := config.Kafka
+	if kafka.RestProxyURL == "" || kafka.OutputTopic == "" || kafka.InputTopic == "" || kafka.ConsumerGroup == "" {
+		return errors.New("kafka restProxyUrl, outputTopic, inputTopic and consumerGroup are required")
+	}
+	rest := newKafkaRestClient(kafka.RestProxyURL)
+	consumer, err := rest.newConsumer(ctx, kafka.ConsumerGroup, kafka.InputTopic, name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if consumer != nil {
+			consumer.close()
+		}
+	}()
+	log.Printf("consuming %s as %s in group %s", kafka.InputTopic, name, kafka.ConsumerGroup)
+	for {
+		count := 0
+		if consumer == nil {
+			// A new consumer starts from the group's last commit, which is before the unfinished batch
+			consumer, err = rest.newConsumer(ctx, kafka.ConsumerGroup, kafka.InputTopic, name)
+		}
+		if consumer != nil {
+			count, err = config.consumeOnce(ctx, consumer)
+		}
+		if err != nil {
+			log.Print(err)
+		}
+		if errors.Is(err, errKafkaConsumerLost) {
+			consumer.close()
+			consumer = nil
+		}
+		if count > 0 && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(kafkaPollInterval):
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Just enough of the Confluent REST proxy v2 api
+type fakeRestProxy struct {
+	mu        sync.Mutex
+	produced  []map[string]interface{}
+	records   string
+	committed []map[string]interface{}
+	failTopic bool
+	// Produces to fail before they start working
+	failProduces int
+	// What the last poll got, which a seek hands out again
+	served    string
+	positions []map[string]interface{}
+	failSeek  bool
+}
+
+func (proxy *fakeRestProxy) handler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxy.mu.Lock()
+		defer proxy.mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/topics/"):
+			if proxy.failProduces > 0 {
+				proxy.failProduces--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if proxy.failTopic {
+				w.Write([]byte("{\"offsets\":[{\"error_code\":50301,\"error\":\"leader not available\"}]}"))
+				return
+			}
+			var produce struct {
+				Records []map[string]interface{} `json:"records"`
+			}
+			json.Unmarshal(body, &produce)
+			proxy.produced = append(proxy.produced, produce.Records...)
+			w.Write([]byte("{\"offsets\":[{\"partition\":0,\"offset\":1}]}"))
+		case r.Method == "POST" && r.URL.Path == "/consumers/account-validator":
+			w.Write([]byte("{\"instance_id\":\"c1\",\"base_uri\":\"http://" + r.Host + "/consumers/account-validator/instances/c1\"}"))
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/records"):
+			w.Write([]byte(proxy.records))
+			proxy.served, proxy.records = proxy.records, "[]"
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/offsets"):
+			var commit struct {
+				Offsets []map[string]interface{} `json:"offsets"`
+			}
+			json.Unmarshal(body, &commit)
+			proxy.committed = append(proxy.committed, commit.Offsets...)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/positions"):
+			if proxy.failSeek {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			var seek struct {
+				Offsets []map[string]interface{} `json:"offsets"`
+			}
+			json.Unmarshal(body, &seek)
+			proxy.positions = append(proxy.positions, seek.Offsets...)
+			proxy.records = proxy.served
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasSuffix(r.URL.Path, "/subscription"), r.Method == "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func TestConfig_KafkaHandler(t *testing.T) {
+	proxy := &fakeRestProxy{}
+	server := httptest.NewServer(proxy.handler(t))
+	defer server.Close()
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		Kafka:     KafkaConfig{RestProxyURL: server.URL, OutputTopic: "results", Concurrency: 2},
+	}
+	event := events.KafkaEvent{Records: map[string][]events.KafkaRecord{"requests-0": {
+		{Topic: "requests", Partition: 0, Offset: 10, Key: b64("req-1"), Value: b64("{\"accountNumber\": \"12345670\"}")},
+		{Topic: "requests", Partition: 0, Offset: 11, Key: b64("req-2"), Value: b64("{\"accountNumber\": \"12345671\"}")},
+		{Topic: "requests", Partition: 0, Offset: 12, Key: b64("req-3"), Value: b64("not json")},
+	}}}
+	if err := config.KafkaHandler(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(proxy.produced)
+	want := "[{\"key\":\"req-1\",\"value\":{\"response\":{\"result\":[{\"isValid\":true,\"provider\":\"provider1\"}]}}}," +
+		"{\"key\":\"req-2\",\"value\":{\"response\":{\"result\":[{\"isValid\":false,\"provider\":\"provider1\"}]}}}," +
+		"{\"key\":\"req-3\",\"value\":{\"error\":\"invalid json payload\"}}]"
+	if string(got) != want {
+		t.Errorf("produced %s, want %s", got, want)
+	}
+
+	proxy.failTopic = true
+	if err := config.KafkaHandler(context.Background(), event); err == nil {
+		t.Errorf("KafkaHandler() should fail so the batch is redelivered when producing fails")
+	}
+}
+
+func TestConfig_consumeOnce(t *testing.T) {
+	proxy := &fakeRestProxy{records: "[" +
+		"{\"topic\":\"requests\",\"key\":\"" + b64("req-1") + "\",\"value\":\"" + b64("{\"accountNumber\": \"12345670\"}") + "\",\"partition\":0,\"offset\":4}," +
+		"{\"topic\":\"requests\",\"key\":null,\"value\":\"" + b64("{\"accountNumber\": \"12345670\"}") + "\",\"partition\":0,\"offset\":5}]"}
+	server := httptest.NewServer(proxy.handler(t))
+	defer server.Close()
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		Kafka:     KafkaConfig{RestProxyURL: server.URL, OutputTopic: "results", InputTopic: "requests", ConsumerGroup: "account-validator"},
+	}
+	consumer, err := newKafkaRestClient(server.URL).newConsumer(context.Background(), "account-validator", "requests", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.close()
+
+	count, err := config.consumeOnce(context.Background(), consumer)
+	if err != nil || count != 2 {
+		t.Fatalf("consumeOnce() = %v, %v", count, err)
+	}
+	if len(proxy.produced) != 2 {
+		t.Errorf("expected 2 results produced, got %v", proxy.produced)
+	}
+	if want := []map[string]interface{}{{"topic": "requests", "partition": float64(0), "offset": float64(5)}}; !reflect.DeepEqual(proxy.committed, want) {
+		t.Errorf("committed %v, want %v", proxy.committed, want)
+	}
+
+	// Nothing left
+	if count, err := config.consumeOnce(context.Background(), consumer); count != 0 || err != nil {
+		t.Errorf("consumeOnce() = %v, %v, want nothing to do", count, err)
+	}
+}
+
+// A batch that couldn't be produced is read again rather than skipped by the next commit
+func TestConfig_consumeOnce_produceFails(t *testing.T) {
+	proxy := &fakeRestProxy{failProduces: 1, records: "[" +
+		"{\"topic\":\"requests\",\"key\":null,\"value\":\"" + b64("{\"accountNumber\": \"12345670\"}") + "\",\"partition\":0,\"offset\":4}," +
+		"{\"topic\":\"requests\",\"key\":null,\"value\":\"" + b64("{\"accountNumber\": \"12345672\"}") + "\",\"partition\":0,\"offset\":5}]"}
+	server := httptest.NewServer(proxy.handler(t))
+	defer server.Close()
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		Kafka:     KafkaConfig{RestProxyURL: server.URL, OutputTopic: "results", InputTopic: "requests", ConsumerGroup: "account-validator"},
+	}
+	consumer, err := newKafkaRestClient(server.URL).newConsumer(context.Background(), "account-validator", "requests", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.close()
+
+	if count, err := config.consumeOnce(context.Background(), consumer); err == nil || count != 0 {
+		t.Fatalf("consumeOnce() = %v, %v, want the produce to fail", count, err)
+	}
+	if want := []map[string]interface{}{{"topic": "requests", "partition": float64(0), "offset": float64(4)}}; !reflect.DeepEqual(proxy.positions, want) || len(proxy.committed) != 0 {
+		t.Errorf("positions %v, committed %v, want a seek back to %v and no commit", proxy.positions, proxy.committed, want)
+	}
+	if count, err := config.consumeOnce(context.Background(), consumer); err != nil || count != 2 {
+		t.Fatalf("consumeOnce() = %v, %v, want the batch again", count, err)
+	}
+	if want := []map[string]interface{}{{"topic": "requests", "partition": float64(0), "offset": float64(5)}}; len(proxy.produced) != 2 || !reflect.DeepEqual(proxy.committed, want) {
+		t.Errorf("produced %v, committed %v", proxy.produced, proxy.committed)
+	}
+
+	// When it can't seek the consumer has to be replaced
+	proxy.records, proxy.failProduces, proxy.failSeek = proxy.served, 1, true
+	if _, err := config.consumeOnce(context.Background(), consumer); !errors.Is(err, errKafkaConsumerLost) {
+		t.Errorf("consumeOnce() = %v, want errKafkaConsumerLost", err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// The KMS calls we make, narrowed for tests
+type kmsAPI interface {
+	Sign(ctx context.Context, params *kms.SignInput, optFns ...func(*kms.Options)) (*kms.SignOutput, error)
+}
+
+type kmsClient struct {
+	api kmsAPI
+}
+
+func newKMSClient(ctx context.Context) (*kmsClient, error) {
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Signing is on the request path, so a slow KMS fails fast rather than holding the response
+	return &kmsClient{api: kms.NewFromConfig(cfg, func(o *kms.Options) {
+		o.HTTPClient = awshttp.NewBuildableClient().WithTimeout(2 * time.Second)
+	})}, nil
+}
+
+// Signs a sha256 digest with an asymmetric key, the signature comes back as KMS gives it
+func (client *kmsClient) sign(ctx context.Context, keyID, algorithm string, digest []byte) ([]byte, error) {
+	output, err := client.api.Sign(ctx, &kms.SignInput{
+		KeyId:            aws.String(keyID),
+		Message:          digest,
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: types.SigningAlgorithmSpec(algorithm),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Signature, nil
+}
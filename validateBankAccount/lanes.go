@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+/*
+  Priority lanes. Interactive callers and bulk jobs share the function, so each request is put in a lane and each lane
+  has its own concurrency pool, deadline and provider set. A bulk job filling its pool queues behind itself rather
+  than in front of the interactive traffic.
+
+    priority:
+      default: interactive     # lane for requests that don't ask for one
+      lanes:
+        interactive:
+          deadlineMs: 800      # whole request, including time queued for the pool
+          maxConcurrent: 100   # validations in the lane at once, 0 for no limit
+        bulk:
+          deadlineMs: 30000
+          maxConcurrent: 5
+          providers:           # only these providers, 0 or missing for all of them
+          - provider2
+          shed: true           # turned away when the container is overloaded, see shedding.go
+
+  A request picks its lane with "priority" in the body or the X-Priority header, the body winning. A priority that
+  isn't a lane gets the default lane. If a request's deadline passes while it's queued every provider is reported as
+  a timeout. Provider calls are still capped at the provider's timeout each. Without a priority section nothing
+  changes.
+*/
+
+const priorityHeader = "X-Priority"
+
+type PriorityConfig struct {
+	Default string           `yaml:"default"`
+	Lanes   map[string]*Lane `yaml:"lanes"`
+}
+
+type Lane struct {
+	DeadlineMs    int      `yaml:"deadlineMs"`
+	MaxConcurrent int      `yaml:"maxConcurrent"`
+	Providers     []string `yaml:"providers"`
+	Shed          bool     `yaml:"shed"`
+
+	slots chan struct{}
+}
+
+func (priority PriorityConfig) validate(providers []Provider) error {
+	if len(priority.Lanes) == 0 {
+		return nil
+	}
+	if _, exists := priority.Lanes[priority.Default]; !exists {
+		return fmt.Errorf("priority default %q isn't a lane", priority.Default)
+	}
+	names := map[string]bool{}
+	for _, provider := range providers {
+		names[provider.Name] = true
+	}
+	for name, lane := range priority.Lanes {
+		if lane == nil {
+			return fmt.Errorf("priority lane %s is empty", name)
+		}
+		if lane.DeadlineMs < 0 || lane.MaxConcurrent < 0 {
+			return fmt.Errorf("priority lane %s has a negative limit", name)
+		}
+		for _, provider := range lane.Providers {
+			if !names[provider] {
+				return fmt.Errorf("priority lane %s has unknown provider %s", name, provider)
+			}
+		}
+	}
+	return nil
+}
+
+// Creates each lane's pool, which lives as long as the container
+func (priority PriorityConfig) setup() {
+	for _, lane := range priority.Lanes {
+		if lane.MaxConcurrent > 0 {
+			lane.slots = make(chan struct{}, lane.MaxConcurrent)
+		}
+	}
+}
+
+// Every lane name, sorted
+func (priority PriorityConfig) names() []string {
+	names := []string{}
+	for name := range priority.Lanes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The lane a request runs in, nil when there are no lanes
+func (priority PriorityConfig) lane(request Request, validationRequest *BankAccountValidationRequest) *Lane {
+	if len(priority.Lanes) == 0 {
+		return nil
+	}
+	name := ""
+	if validationRequest.Priority != nil {
+		name = *validationRequest.Priority
+	} else {
+		name = requestHeader(request.Headers, priorityHeader)
+	}
+	if lane, exists := priority.Lanes[name]; exists {
+		return lane
+	}
+	return priority.Lanes[priority.Default]
+}
+
+// A nil lane has no deadline
+func (lane *Lane) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if lane == nil || lane.DeadlineMs == 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(lane.DeadlineMs)*time.Millisecond)
+}
+
+// Narrows the providers down to the lane's
+func (lane *Lane) restrict(providers []Provider) []Provider {
+	if lane == nil || len(lane.Providers) == 0 {
+		return providers
+	}
+	allowed := map[string]bool{}
+	for _, name := range lane.Providers {
+		allowed[name] = true
+	}
+	restricted := []Provider{}
+	for _, provider := range providers {
+		if allowed[provider.Name] {
+			restricted = append(restricted, provider)
+		}
+	}
+	return restricted
+}
+
+// Waits for room in the lane's pool. The release func must be called when the validation is done.
+func (lane *Lane) acquire(ctx context.Context) (func(), bool) {
+	if lane == nil || lane.slots == nil {
+		return func() {}, true
+	}
+	select {
+	case lane.slots <- struct{}{}:
+		return func() { <-lane.slots }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// What we answer when the deadline passed before the lane had room
+func queueTimeout(providers []Provider) BankAccountValidationResponse {
+	results := make([]BankAccountValidationResult, 0, len(providers))
+	for _, provider := range providers {
+		results = append(results, BankAccountValidationResult{Provider: provider.Name, Error: ProviderErrorTimeout})
+	}
+	return BankAccountValidationResponse{Result: results}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func testLanesConfig() *Config {
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}, {Name: "provider2", Type: ProviderTypeSimulated}},
+		Priority: PriorityConfig{
+			Default: "interactive",
+			Lanes: map[string]*Lane{
+				"interactive": {DeadlineMs: 800, MaxConcurrent: 1},
+				"bulk":        {DeadlineMs: 20, MaxConcurrent: 1, Providers: []string{"provider2"}},
+			},
+		},
+	}
+	config.Priority.setup()
+	return config
+}
+
+func TestPriorityConfig_validate(t *testing.T) {
+	providers := []Provider{{Name: "provider1"}}
+	tests := []struct {
+		name     string
+		priority PriorityConfig
+		wantErr  bool
+	}{
+		{name: "none", priority: PriorityConfig{}},
+		{name: "ok", priority: PriorityConfig{Default: "a", Lanes: map[string]*Lane{"a": {Providers: []string{"provider1"}}}}},
+		{name: "missingDefault", priority: PriorityConfig{Default: "b", Lanes: map[string]*Lane{"a": {}}}, wantErr: true},
+		{name: "unknownProvider", priority: PriorityConfig{Default: "a", Lanes: map[string]*Lane{"a": {Providers: []string{"provider9"}}}}, wantErr: true},
+		{name: "negative", priority: PriorityConfig{Default: "a", Lanes: map[string]*Lane{"a": {MaxConcurrent: -1}}}, wantErr: true},
+		{name: "empty", priority: PriorityConfig{Default: "a", Lanes: map[string]*Lane{"a": nil}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.priority.validate(providers); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPriorityConfig_lane(t *testing.T) {
+	priority := testLanesConfig().Priority
+	bulk, interactive := "bulk", "interactive"
+	unknown := "urgent"
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		priority *string
+		want     *Lane
+	}{
+		{name: "default", want: priority.Lanes[interactive]},
+		{name: "body", priority: &bulk, want: priority.Lanes[bulk]},
+		{name: "header", headers: map[string]string{"x-priority": "bulk"}, want: priority.Lanes[bulk]},
+		{name: "bodyWins", headers: map[string]string{"X-Priority": "bulk"}, priority: &interactive, want: priority.Lanes[interactive]},
+		{name: "unknown", priority: &unknown, want: priority.Lanes[interactive]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := priority.lane(Request{Headers: tt.headers}, &BankAccountValidationRequest{Priority: tt.priority})
+			if got != tt.want {
+				t.Errorf("lane() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if (PriorityConfig{}).lane(Request{}, &BankAccountValidationRequest{}) != nil {
+		t.Errorf("no lanes should mean no lane")
+	}
+}
+
+func TestConfig_validate_lanes(t *testing.T) {
+	config := testLanesConfig()
+	accountNumber, bulk := "12345670", "bulk"
+	validate := func(priority *string) BankAccountValidationResponse {
+		return config.validate(context.Background(), Request{}, &BankAccountValidationRequest{AccountNumber: &accountNumber, Priority: priority}, nil)
+	}
+
+	// Bulk only gets the cheaper provider
+	want := []BankAccountValidationResult{{Provider: "provider2", IsValid: true}}
+	if got := validate(&bulk).Result; !reflect.DeepEqual(got, want) {
+		t.Errorf("bulk results = %+v, want %+v", got, want)
+	}
+
+	// With bulk's pool full a bulk request times out in the queue, interactive has its own pool
+	config.Priority.Lanes[bulk].slots <- struct{}{}
+	want = []BankAccountValidationResult{{Provider: "provider2", Error: ProviderErrorTimeout}}
+	if got := validate(&bulk).Result; !reflect.DeepEqual(got, want) {
+		t.Errorf("queued bulk results = %+v, want %+v", got, want)
+	}
+	want = []BankAccountValidationResult{{Provider: "provider1", IsValid: true}, {Provider: "provider2", IsValid: true}}
+	if got := validate(nil).Result; !reflect.DeepEqual(got, want) {
+		t.Errorf("interactive results = %+v, want %+v", got, want)
+	}
+	if len(config.Priority.Lanes["interactive"].slots) != 0 {
+		t.Errorf("the interactive slot wasn't released")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+  Local checks. Plenty of the accounts we're sent could never be valid, a typo in an IBAN or a Belgian account whose
+  check digits don't add up, and there's no need to pay a provider to tell us so. With
+
+    localChecks:
+      checks: [format, modulus, ibanChecksum]
+      skipRemote: true
+
+  the checks run before any provider is called and come back as local results (see bic.go):
+
+    local:format          letters and digits only, 4 to 34 of them, and an IBAN the right length for its country
+    local:modulus         the domestic check digits, for Belgian and Spanish accounts (or IBANs)
+    local:iban_checksum   the IBAN's mod 97 check digits, when the account number is an IBAN
+
+  A check that doesn't apply to the account isn't in the result. Without skipRemote the providers are called anyway
+  and the checks are only there to look at, which is how to see what they'd catch before turning it on. With it, a
+  failed check means no provider is called at all: the result is just the local checks, with a warning saying which
+  one failed, the aggregate is invalid and so is the outcome for audit records, verdicts and receipts. Nothing is
+  billed. Test accounts are always answered as configured.
+*/
+
+const (
+	LocalCheckFormat       = "format"
+	LocalCheckModulus      = "modulus"
+	LocalCheckIBANChecksum = "ibanChecksum"
+
+	LocalFormat       = "local:format"
+	LocalModulus      = "local:modulus"
+	LocalIBANChecksum = "local:iban_checksum"
+)
+
+var localCheckProviders = map[string]string{
+	LocalCheckFormat:       LocalFormat,
+	LocalCheckModulus:      LocalModulus,
+	LocalCheckIBANChecksum: LocalIBANChecksum,
+}
+
+// The length of an IBAN in each country that has them, from the SWIFT registry
+var ibanLengths = map[string]int{
+	"AD": 24, "AE": 23, "AL": 28, "AT": 20, "AZ": 28, "BA": 20, "BE": 16, "BG": 22, "BH": 22, "BR": 29, "BY": 28,
+	"CH": 21, "CR": 22, "CY": 28, "CZ": 24, "DE": 22, "DK": 18, "DO": 28, "EE": 20, "EG": 29, "ES": 24, "FI": 18,
+	"FO": 18, "FR": 27, "GB": 22, "GE": 22, "GI": 23, "GL": 18, "GR": 27, "GT": 28, "HR": 21, "HU": 28, "IE": 22,
+	"IL": 23, "IQ": 23, "IS": 26, "IT": 27, "JO": 30, "KW": 30, "KZ": 20, "LB": 28, "LC": 32, "LI": 21, "LT": 20,
+	"LU": 20, "LV": 21, "MC": 27, "MD": 24, "ME": 22, "MK": 19, "MR": 27, "MT": 31, "MU": 30, "NL": 18, "NO": 15,
+	"PK": 24, "PL": 28, "PS": 29, "PT": 25, "QA": 29, "RO": 24, "RS": 22, "SA": 24, "SC": 31, "SE": 24, "SI": 19,
+	"SK": 24, "SM": 27, "ST": 25, "SV": 28, "TL": 23, "TN": 24, "TR": 26, "UA": 29, "VA": 22, "VG": 24, "XK": 20,
+}
+
+type LocalChecksConfig struct {
+	Checks     []string `yaml:"checks"`
+	SkipRemote bool     `yaml:"skipRemote"`
+}
+
+func (localChecks LocalChecksConfig) validate() error {
+	for _, check := range localChecks.Checks {
+		if _, known := localCheckProviders[check]; !known {
+			return fmt.Errorf("localChecks: %s isn't format, modulus or ibanChecksum", check)
+		}
+	}
+	return nil
+}
+
+func (localChecks LocalChecksConfig) enabled(check string) bool {
+	for _, configured := range localChecks.Checks {
+		if configured == check {
+			return true
+		}
+	}
+	return false
+}
+
+// The results of the configured checks that apply to the account
+func (localChecks LocalChecksConfig) run(validationRequest *BankAccountValidationRequest) []BankAccountValidationResult {
+	if len(localChecks.Checks) == 0 {
+		return nil
+	}
+	account := strings.ToUpper(domesticDigits(*validationRequest.AccountNumber))
+	_, isIBAN := ibanCountry(account)
+	results := []BankAccountValidationResult{}
+	if localChecks.enabled(LocalCheckFormat) {
+		results = append(results, BankAccountValidationResult{Provider: LocalFormat, IsValid: wellFormedAccount(account), Local: true})
+	}
+	if localChecks.enabled(LocalCheckModulus) {
+		if valid, applies := domesticCheckDigits(validationRequest, account); applies {
+			results = append(results, BankAccountValidationResult{Provider: LocalModulus, IsValid: valid, Local: true})
+		}
+	}
+	if localChecks.enabled(LocalCheckIBANChecksum) && isIBAN && alphanumeric(account) {
+		results = append(results, BankAccountValidationResult{Provider: LocalIBANChecksum, IsValid: ibanMod97(account[4:]+account[:4]) == 1, Local: true})
+	}
+	return results
+}
+
+func alphanumeric(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if !isUpperLetter(value[i]) && !isDigit(value[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether the account number (upper case, spaces and dashes out) could be one at all
+func wellFormedAccount(account string) bool {
+	if len(account) < 4 || len(account) > 34 || !alphanumeric(account) {
+		return false
+	}
+	if country, isIBAN := ibanCountry(account); isIBAN {
+		if length, known := ibanLengths[country]; known {
+			return len(account) == length
+		}
+	}
+	return true
+}
+
+// Whether the account's domestic check digits are right, and whether we know how to check them for its country
+func domesticCheckDigits(validationRequest *BankAccountValidationRequest, account string) (bool, bool) {
+	country, domestic := "", account
+	if code, isIBAN := ibanCountry(account); isIBAN {
+		country, domestic = code, account[4:]
+	} else if validationRequest.Country != nil {
+		country = strings.ToUpper(strings.TrimSpace(*validationRequest.Country))
+	}
+	switch country {
+	case "BE":
+		if !allDigits(domestic, 12, 12) {
+			return false, true
+		}
+		return belgianCheckDigits(domestic), true
+	case "ES":
+		if !allDigits(domestic, 20, 20) {
+			return false, true
+		}
+		return spanishCheckDigits(domestic), true
+	}
+	return false, false
+}
+
+// The last two digits are the first ten mod 97, or 97 when that's 0
+func belgianCheckDigits(account string) bool {
+	remainder := ibanMod97(account[:10])
+	if remainder == 0 {
+		remainder = 97
+	}
+	return fmt.Sprintf("%02d", remainder) == account[10:]
+}
+
+// The CCC is bank (4), branch (4), two check digits and the account (10). The first check digit covers the bank and
+// branch, the second the account.
+func spanishCheckDigits(ccc string) bool {
+	return spanishCheckDigit("00"+ccc[:8]) == ccc[8] && spanishCheckDigit(ccc[10:]) == ccc[9]
+}
+
+func spanishCheckDigit(digits string) byte {
+	weights := []int{1, 2, 4, 8, 5, 10, 9, 7, 3, 6}
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		sum += int(digits[i]-'0') * weights[i]
+	}
+	check := 11 - sum%11
+	switch check {
+	case 11:
+		check = 0
+	case 10:
+		check = 1
+	}
+	return byte('0' + check)
+}
+
+// The configured check that failed, empty when they all passed
+func failedLocalCheck(results []BankAccountValidationResult) string {
+	for _, result := range results {
+		if !result.IsValid {
+			return result.Provider
+		}
+	}
+	return ""
+}
+
+// The local check that stopped the providers being called, empty when they were
+func remoteSkippedBy(results []BankAccountValidationResult) string {
+	failed := ""
+	for _, result := range results {
+		if !result.Local {
+			return ""
+		}
+		switch result.Provider {
+		case LocalFormat, LocalModulus, LocalIBANChecksum:
+			if !result.IsValid && failed == "" {
+				failed = result.Provider
+			}
+		}
+	}
+	return failed
+}
+
+// The response for an account that failed a local check, with nothing sent to the providers
+func (config *Config) skipRemote(checks []BankAccountValidationResult, failed string) BankAccountValidationResponse {
+	config.telemetry.count("RemoteCallsSkipped", 1)
+	return BankAccountValidationResponse{
+		Result:   checks,
+		Warnings: []string{fmt.Sprintf("no providers were called, the account failed %s", failed)},
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLocalChecksConfig_run(t *testing.T) {
+	all := LocalChecksConfig{Checks: []string{LocalCheckFormat, LocalCheckModulus, LocalCheckIBANChecksum}}
+	request := func(accountNumber, country string) *BankAccountValidationRequest {
+		validationRequest := &BankAccountValidationRequest{AccountNumber: &accountNumber}
+		if country != "" {
+			validationRequest.Country = &country
+		}
+		return validationRequest
+	}
+	local := func(provider string, valid bool) BankAccountValidationResult {
+		return BankAccountValidationResult{Provider: provider, IsValid: valid, Local: true}
+	}
+	tests := []struct {
+		name    string
+		checks  LocalChecksConfig
+		request *BankAccountValidationRequest
+		want    []BankAccountValidationResult
+	}{
+		{"none", LocalChecksConfig{}, request("12345678", ""), nil},
+		{"domestic", all, request("12345678", ""), []BankAccountValidationResult{local(LocalFormat, true)}},
+		{"badCharacters", all, request("1234/5678", ""), []BankAccountValidationResult{local(LocalFormat, false)}},
+		{"tooShort", all, request("123", ""), []BankAccountValidationResult{local(LocalFormat, false)}},
+		{"iban", all, request("DE89 3704 0044 0532 0130 00", ""), []BankAccountValidationResult{local(LocalFormat, true), local(LocalIBANChecksum, true)}},
+		{"ibanTypo", all, request("DE89370400440532013001", ""), []BankAccountValidationResult{local(LocalFormat, true), local(LocalIBANChecksum, false)}},
+		{"ibanLength", all, request("DE8937040044053201300", ""), []BankAccountValidationResult{local(LocalFormat, false), local(LocalIBANChecksum, false)}},
+		{"belgianIBAN", all, request("BE68539007547034", ""), []BankAccountValidationResult{local(LocalFormat, true), local(LocalModulus, true), local(LocalIBANChecksum, true)}},
+		{"belgian", all, request("539-0075470-34", "be"), []BankAccountValidationResult{local(LocalFormat, true), local(LocalModulus, true)}},
+		{"belgianTypo", all, request("539007547035", "BE"), []BankAccountValidationResult{local(LocalFormat, true), local(LocalModulus, false)}},
+		{"spanishIBAN", all, request("ES9121000418450200051332", ""), []BankAccountValidationResult{local(LocalFormat, true), local(LocalModulus, true), local(LocalIBANChecksum, true)}},
+		{"spanishTypo", all, request("21000418460200051332", "ES"), []BankAccountValidationResult{local(LocalFormat, true), local(LocalModulus, false)}},
+		{"spanishShort", all, request("2100041845020005133", "ES"), []BankAccountValidationResult{local(LocalFormat, true), local(LocalModulus, false)}},
+		{"onlyChecksum", LocalChecksConfig{Checks: []string{LocalCheckIBANChecksum}}, request("12345678", ""), []BankAccountValidationResult{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.checks.run(tt.request); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("run() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalChecksConfig_validate(t *testing.T) {
+	if err := (LocalChecksConfig{Checks: []string{LocalCheckFormat, LocalCheckModulus}}).validate(); err != nil {
+		t.Errorf("validate() = %v", err)
+	}
+	if err := (LocalChecksConfig{Checks: []string{"luhn"}}).validate(); err == nil {
+		t.Errorf("validate() accepted an unknown check")
+	}
+}
+
+func TestConfig_skipRemote(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		skipRemote bool
+		account    string
+		wantCalls  int32
+		want       string
+	}{
+		{"passes", true, "DE89370400440532013000", 1,
+			"{\"result\":[{\"provider\":\"provider1\",\"isValid\":true},{\"provider\":\"local:format\",\"isValid\":true,\"local\":true}," +
+				"{\"provider\":\"local:iban_checksum\",\"isValid\":true,\"local\":true}],\"aggregate\":{\"strategy\":\"all\",\"isValid\":true}}"},
+		{"fails", true, "DE89370400440532013001", 0,
+			"{\"result\":[{\"provider\":\"local:format\",\"isValid\":true,\"local\":true},{\"provider\":\"local:iban_checksum\",\"isValid\":false,\"local\":true}]," +
+				"\"aggregate\":{\"strategy\":\"all\",\"isValid\":false},\"warnings\":[\"no providers were called, the account failed local:iban_checksum\"]}"},
+		{"reportOnly", false, "DE89370400440532013001", 1,
+			"{\"result\":[{\"provider\":\"provider1\",\"isValid\":true},{\"provider\":\"local:format\",\"isValid\":true,\"local\":true}," +
+				"{\"provider\":\"local:iban_checksum\",\"isValid\":false,\"local\":true}],\"aggregate\":{\"strategy\":\"all\",\"isValid\":true}}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&calls, 0)
+			config := &Config{
+				Providers:    []Provider{{Name: "provider1", URL: server.URL}},
+				MinProviders: 1,
+				LocalChecks:  LocalChecksConfig{Checks: []string{LocalCheckFormat, LocalCheckIBANChecksum}, SkipRemote: tt.skipRemote},
+			}
+			response, err := config.Handler(context.Background(), Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"" + tt.account + "\", \"strategy\": \"all\"}"})
+			if err != nil || response.StatusCode != 200 || response.Body != tt.want {
+				t.Errorf("Handler() = %d %s, want %s", response.StatusCode, response.Body, tt.want)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("provider called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func Test_outcomeSkippedRemote(t *testing.T) {
+	response := BankAccountValidationResponse{Result: []BankAccountValidationResult{{Provider: LocalModulus, Local: true}}}
+	if got := outcome(&BankAccountValidationRequest{}, response); got != ResultStatusInvalid {
+		t.Errorf("outcome() = %s, want %s", got, ResultStatusInvalid)
+	}
+}
@@ -17,18 +17,22 @@ package main
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	yaml "gopkg.in/yaml.v2"
+	"github.com/aws/aws-lambda-go/lambdaurl"
 )
 
 /*
@@ -36,34 +40,223 @@ import (
 */
 
 type Config struct {
-	Providers []Provider
+	Version         int    `yaml:"version"`
+	Revision        string `yaml:"revision"`
+	UpdatedAt       string `yaml:"updatedAt"`
+	Providers       []Provider
+	Groups          []ProviderGroup        `yaml:"groups"`
+	TestAccounts    []TestAccount          `yaml:"testAccounts"`
+	CircuitBreaker  BreakerConfig          `yaml:"circuitBreaker"`
+	Alerting        AlertingConfig         `yaml:"alerting"`
+	DNS             DNSConfig              `yaml:"dns"`
+	TLS             TLSConfig              `yaml:"tls"`
+	Tracing         TracingConfig          `yaml:"tracing"`
+	Secrets         SecretsConfig          `yaml:"secrets"`
+	Kafka           KafkaConfig            `yaml:"kafka"`
+	Batch           BatchConfig            `yaml:"batch"`
+	Priority        PriorityConfig         `yaml:"priority"`
+	Shedding        SheddingConfig         `yaml:"shedding"`
+	Encoding        EncodingConfig         `yaml:"encoding"`
+	Caching         CachingConfig          `yaml:"caching"`
+	Masking         MaskingConfig          `yaml:"masking"`
+	Security        SecurityConfig         `yaml:"security"`
+	PayloadRules    PayloadRulesConfig     `yaml:"payloadRules"`
+	Authorization   AuthorizationConfig    `yaml:"authorization"`
+	ResponseFilters []ResponseFilter       `yaml:"responseFilters"`
+	UsageReport     UsageReportConfig      `yaml:"usageReport"`
+	FeedbackExport  FeedbackExportConfig   `yaml:"feedbackExport"`
+	Billing         BillingConfig          `yaml:"billing"`
+	Costs           CostsConfig            `yaml:"costs"`
+	ResponseSigning *ResponseSigningConfig `yaml:"responseSigning"`
+	Receipts        *ReceiptsConfig        `yaml:"receipts"`
+	Feedback        *FeedbackConfig        `yaml:"feedback"`
+	NoProviders     string                 `yaml:"noProviders"`
+	MinProviders    int                    `yaml:"minProviders"`
+	FanOut          FanOutConfig           `yaml:"fanOut"`
+	Capture         CaptureConfig          `yaml:"capture"`
+	LocalChecks     LocalChecksConfig      `yaml:"localChecks"`
+	Debug           bool                   `yaml:"debug"`
+	Init            InitConfig             `yaml:"init"`
+	// See admin.go
+	Admin *AdminConfig `yaml:"admin"`
+	// See configcanary.go
+	ConfigCanary *ConfigCanaryConfig `yaml:"configCanary"`
+	// See ratelimit.go
+	RateLimit *RateLimitConfig `yaml:"rateLimit"`
+	// See admission.go
+	Admission []AdmissionPolicy `yaml:"admission"`
+	// See accounthash.go
+	AccountHashing AccountHashingConfig `yaml:"accountHashing"`
+	// See responsetemplates.go
+	ResponseTemplates []ResponseTemplate `yaml:"responseTemplates"`
+	// See quota.go
+	Quotas *QuotaConfig `yaml:"quotas"`
+	// See webhooks.go
+	Webhooks    []*WebhookConfig  `yaml:"webhooks"`
+	BodyLogging BodyLoggingConfig `yaml:"bodyLogging"`
+
+	store        Store
+	statusStore  StatusStore
+	metadata     *ResponseMetadata
+	websocket    connectionPoster
+	snsResults   *snsResultPublisher
+	verdicts     VerdictStore
+	events       eventPublisher
+	telemetry    *telemetry
+	weights      map[string]float64
+	etags        *etagCache
+	security     *securityLog
+	allowlist    []netip.Prefix
+	payloadRules []payloadRule
+	replays      *replayCache
+	admission    []admissionRule
+	// Visible fields by caller
+	responseFields    map[string]map[string]bool
+	responseTemplates map[string]*template.Template
+	responseSigner    *jwsSigner
+	slaRecorder       *slaRecorder
+	feedback          FeedbackStore
+	feedbackWeights   *feedbackWeights
+	captureObjects    *s3Client
+	bodyLogger        *bodyLogger
+	rateLimiter       *rateLimiter
+	quotaUsage        *quotaUsage
+	webhookDispatcher *webhookDispatcher
+	outbox            *outboxDispatcher
+	timingLog         io.Writer
+	adminAudit        io.Writer
 }
 
 type Provider struct {
-	Name string
-	URL  string
+	Name            string
+	Aliases         []string `yaml:"aliases"`
+	Tags            []string `yaml:"tags"`
+	AccountTypes    []string `yaml:"accountTypes"`
+	Currencies      []string `yaml:"currencies"`
+	Type            string
+	URL             string
+	RequestTemplate string            `yaml:"requestTemplate"`
+	ValidField      string            `yaml:"validField"`
+	ValidValues     []string          `yaml:"validValues"`
+	ReasonField     string            `yaml:"reasonField"`
+	Reasons         map[string]string `yaml:"reasons"`
+	HealthURL       string            `yaml:"healthUrl"`
+	Endpoints       []string          `yaml:"endpoints"`
+	ReprobeSeconds  int               `yaml:"reprobeSeconds"`
+	Pins            []string          `yaml:"pins"`
+	Countries       []string          `yaml:"countries"`
+	Headers         map[string]string `yaml:"headers"`
+	UserAgent       string            `yaml:"userAgent"`
+	CallIDHeader    string            `yaml:"callIdHeader"`
+	Signing         *SigningConfig    `yaml:"signing"`
+	TimeoutMs       int               `yaml:"timeoutMs"`
+	Retries         int               `yaml:"retries"`
+	Weight          float64           `yaml:"weight"`
+	// See authoritative.go
+	Authoritative bool `yaml:"authoritative"`
+	// What a call costs us, for usage reports
+	UnitCost float64        `yaml:"unitCost"`
+	Enabled  *bool          `yaml:"enabled"`
+	Rollout  *RolloutConfig `yaml:"rollout"`
+	SEPA     *SEPAConfig    `yaml:"sepa"`
+	SLA      *SLAConfig     `yaml:"sla"`
+	// See quota.go
+	DailyQuota int64 `yaml:"dailyQuota"`
+	// For the type's adapter, see adapters.go
+	Options map[string]interface{} `yaml:"options"`
+	// See script.go
+	Script *ScriptConfig `yaml:"script"`
+
+	template  *template.Template
+	breaker   *circuitBreaker
+	stats     *providerStats
+	alerter   *providerAlerter
+	endpoints *endpointSelector
+	rollout   *rolloutStats
+	sepa      *sepaDirectory
+	sla       *slaRecorder
+	quota     *quotaUsage
+	script    *responseScript
 }
 
 type BankAccountValidationRequest struct {
 	AccountNumber *string   `json:"accountNumber"`
 	Providers     *[]string `json:"providers"`
+	Strategy      *string   `json:"strategy"`
+	Priority      *string   `json:"priority"`
+	// Echoed back, see clientreference.go
+	ClientReference *string `json:"clientReference"`
+	// See accounttypes.go
+	AccountType *string `json:"accountType"`
+	Currency    *string `json:"currency"`
+	// Checked locally, see bic.go
+	BIC *string `json:"bic"`
+	// For building the IBAN, see iban.go
+	Country  *string `json:"country"`
+	BankCode *string `json:"bankCode"`
+	// Raises the config's minProviders, see minproviders.go
+	MinProviders *int `json:"minProviders"`
 }
 
 type BankAccountValidationResult struct {
 	Provider string `json:"provider"`
 	IsValid  bool   `json:"isValid"`
+	Error    string `json:"error,omitempty"`
+	// A check we made ourselves rather than a provider's answer, see bic.go
+	Local bool `json:"local,omitempty"`
+	// What a sepa provider found, see sepa.go
+	Reachability *Reachability `json:"reachability,omitempty"`
+	// Sent to the provider in its callIdHeader, see callids.go
+	CallID string `json:"callId,omitempty"`
+	// Why the provider said so, see reasons.go
+	Reason    string `json:"reason,omitempty"`
+	RawReason string `json:"rawReason,omitempty"`
 }
 
+// Providers are guaranteed to answer within a second
+const providerTimeout = 1 * time.Second
+
+// Why a provider didn't give us an answer. Surfaced on the result so callers can tell
+// a genuine "invalid" apart from a provider that fell over.
+const (
+	ProviderErrorRequest  = "request_failed"
+	ProviderErrorTimeout  = "timeout"
+	ProviderErrorResponse = "invalid_response"
+)
+
 type BankAccountValidationResponse struct {
-	Result []BankAccountValidationResult `json:"result"`
+	Result    []BankAccountValidationResult `json:"result"`
+	Aggregate *AggregateResult              `json:"aggregate,omitempty"`
+	Metadata  *ResponseMetadata             `json:"metadata,omitempty"`
+	// Only set when the verdict changed, see verdicts.go
+	PreviousResult string     `json:"previousResult,omitempty"`
+	ChangedAt      *time.Time `json:"changedAt,omitempty"`
+	// The request's clientReference
+	ClientReference string `json:"clientReference,omitempty"`
+	// Things the caller should fix that didn't stop the validation, like asking for a provider that doesn't exist
+	Warnings []string `json:"warnings,omitempty"`
+	// Only with costs.inResponses, see costs.go
+	EstimatedCost *CostEstimate `json:"estimatedCost,omitempty"`
+	// Only with responseSigning, see responsesigning.go
+	Signature *ResponseSignature `json:"signature,omitempty"`
+	// Only with receipts, see receipts.go
+	Receipt string `json:"receipt,omitempty"`
+	// Only when there was no provider to call, see noproviders.go
+	NoProvidersMatched bool     `json:"noProvidersMatched,omitempty"`
+	UnknownProviders   []string `json:"unknownProviders,omitempty"`
+	// Fewer providers answered than minProviders, see minproviders.go
+	Partial bool `json:"partial,omitempty"`
 }
 
 type DataProviderRequest struct {
 	AccountNumber string `json:"accountNumber"`
+	AccountType   string `json:"accountType,omitempty"`
+	Currency      string `json:"currency,omitempty"`
 }
 
 type DataProviderResponse struct {
-	IsValid bool `json:"isValid"`
+	IsValid bool   `json:"isValid"`
+	Reason  string `json:"reason,omitempty"`
 }
 
 // Response is of type APIGatewayProxyResponse as we are using the AWS Lambda Proxy Request functionality
@@ -72,50 +265,226 @@ type Request events.APIGatewayProxyRequest
 
 // Handler is our lambda handler invoked by the `lambda.Start` function call
 func (config *Config) Handler(ctx context.Context, request Request) (Response, error) {
-	var buf bytes.Buffer
-
 	// Get and validate the request
+	parsed := timerFrom(ctx).stage(StageParse)
 	validationRequest, errorResponse := unmarshalRequest(request)
+	parsed()
 	if errorResponse != nil {
+		config.security.schemaViolation(ctx, request)
 		return *errorResponse, nil
 	}
+	if response := config.enforceEntitlement(ctx, request, validationRequest); response != nil {
+		return *response, nil
+	}
+	if response := config.enforceAdmission(ctx, request, validationRequest); response != nil {
+		return *response, nil
+	}
+	profile := responseProfile(request.Headers)
+	condition := requestHeader(request.Headers, "If-None-Match")
+	key := cacheKey(validationRequest, profile, config.cacheCaller(request))
+	if config.etags != nil {
+		lookedUp := timerFrom(ctx).stage(StageCacheLookup)
+		etag, matches := config.etags.fresh(ctx, key, condition)
+		lookedUp()
+		if matches {
+			headers := config.Caching.headers(key, BankAccountValidationResponse{})
+			headers["ETag"] = etag
+			headers["Vary"] = "Accept"
+			return notModified(headers), nil
+		}
+	}
+	if response := config.rejectNoProviders(ctx, validationRequest); response != nil {
+		return *response, nil
+	}
+	if response := config.rejectFanOut(request, config.selectedProviders(ctx, validationRequest)); response != nil {
+		return *response, nil
+	}
+	if shedResponse := config.shed(request, validationRequest); shedResponse != nil {
+		return *shedResponse, nil
+	}
 
-	// Create the response
-	var response BankAccountValidationResponse = checkProviders(
-		*validationRequest.AccountNumber,
-		providersToCall(config.Providers, validationRequest.Providers))
+	response := config.validate(ctx, request, validationRequest, nil)
 
-	// Send the response
-	body, err := json.Marshal(response)
+	// Send the response, as much of it as the caller gets to see
+	status := validationStatus(response)
+	response = config.signResponse(ctx, validationRequest, filterResponse(config.visibleFields(request), response))
+	serialized := timerFrom(ctx).stage(StageSerialization)
+	body, contentType, err := config.marshalFor(request, profile, response)
+	serialized()
 	if err != nil {
 		return Response{StatusCode: 404}, err
 	}
-	json.HTMLEscape(&buf, body)
 	resp := Response{
-		StatusCode:      200,
+		StatusCode:      status,
 		IsBase64Encoded: false,
-		Body:            buf.String(),
+		Body:            body,
 		Headers: map[string]string{
-			"Content-Type": "application/json",
+			"Content-Type": contentType,
+			"Vary":         "Accept",
 		},
 	}
+	headers := config.Caching.headers(key, response)
+	for name, value := range headers {
+		resp.Headers[name] = value
+	}
+	if etag, exists := headers["ETag"]; exists {
+		if headers["Cache-Control"] != "no-store" {
+			config.etags.store(ctx, key, etag)
+		}
+		if etagMatches(condition, etag) {
+			return notModified(resp.Headers), nil
+		}
+	}
 	return resp, nil
 }
 
-func providersToCall(providers []Provider, filter *[]string) []Provider {
+// Runs a validation request. If onResult is given it is called with each provider's result as it arrives, for the
+// streaming modes.
+func (config *Config) validate(ctx context.Context, request Request, validationRequest *BankAccountValidationRequest, onResult func(BankAccountValidationResult)) BankAccountValidationResponse {
+	start := time.Now()
+	ctx = config.withOutbox(ctx)
+	details := validationRequest.accountDetails()
+	providers, fanOutWarning := config.capFanOut(request, config.selectedProviders(ctx, validationRequest))
+	checks := config.LocalChecks.run(validationRequest)
+
+	// Create the response, test accounts never reach the providers
+	var response BankAccountValidationResponse
+	testAccount, isTestAccount := config.testAccount(*validationRequest.AccountNumber)
+	if isTestAccount {
+		response = testAccount.response(providers)
+		for _, result := range response.Result {
+			if onResult != nil {
+				onResult(result)
+			}
+		}
+		config.checkAnswers(&response, validationRequest)
+	} else {
+		lane := config.Priority.lane(request, validationRequest)
+		providers = lane.restrict(providers)
+
+		// Look up the last verdict from these providers while they're being called
+		key := verdictKey(accountHash(*validationRequest.AccountNumber), validationRequest.Strategy, providers)
+		var previousVerdict func() (*Verdict, error)
+		if config.verdicts != nil {
+			previousVerdict = config.lookupVerdict(ctx, key.String())
+		}
+		ctx = withTraceHeaders(ctx, config.Tracing.traceHeaders(request))
+		ctx = withAccountDetails(ctx, details)
+		ctx = config.withBilling(ctx, request)
+		ctx = config.withCapture(ctx, request)
+		defer captureFrom(ctx).wait()
+
+		// The lane's deadline covers queueing for its pool and the provider calls
+		laneCtx, cancel := lane.withDeadline(ctx)
+		defer cancel()
+		if failed := failedLocalCheck(checks); failed != "" && config.LocalChecks.SkipRemote {
+			// Not worth paying a provider to tell us what we already know
+			response = config.skipRemote(checks, failed)
+			for _, result := range checks {
+				if onResult != nil {
+					onResult(result)
+				}
+			}
+			checks = nil
+		} else if release, admitted := lane.acquire(laneCtx); admitted {
+			var results <-chan BankAccountValidationResult
+			callCtx, cancelCalls := context.WithCancel(laneCtx)
+			if isSequential(validationRequest.Strategy) {
+				results = checkProvidersSequential(callCtx, *validationRequest.AccountNumber, providers, max(config.minProviders(validationRequest), 1))
+			} else {
+				results = shortCircuit(checkProvidersAsync(callCtx, *validationRequest.AccountNumber, providers), providers, cancelCalls)
+			}
+			response = aggregateResults(providers, notify(results, onResult))
+			cancelCalls()
+			release()
+		} else {
+			response = queueTimeout(providers)
+			for _, result := range response.Result {
+				if onResult != nil {
+					onResult(result)
+				}
+			}
+		}
+		config.checkAnswers(&response, validationRequest)
+		if previousVerdict != nil {
+			if previous, err := previousVerdict(); err != nil {
+				log.Printf("unable to read the previous verdict: %v", err)
+			} else {
+				config.diffVerdict(ctx, key, previous, outcome(validationRequest, response), &response, time.Now())
+			}
+		}
+	}
+
+	// Checks we make ourselves, they never count towards the aggregate
+	for _, result := range append(checks, bicChecks(validationRequest)...) {
+		response.Result = append(response.Result, result)
+		if onResult != nil {
+			onResult(result)
+		}
+	}
+
+	config.countUnknownReasons(response.Result)
+
+	// Too few answers aren't a verdict
+	aggregated := timerFrom(ctx).stage(StageAggregation)
+	if !response.Partial {
+		response.Aggregate = aggregateGroups(validationRequest.Strategy, response.Result, config.currentWeights(), config.Groups)
+		if response.Aggregate != nil && config.authoritativeInvalid(response.Result) != "" {
+			response.Aggregate.IsValid = false
+		}
+	}
+	aggregated()
+	response.Metadata = config.metadata
+	if validationRequest.ClientReference != nil {
+		response.ClientReference = *validationRequest.ClientReference
+	}
+	for _, selector := range unknownProviders(config.Providers, validationRequest.Providers) {
+		response.Warnings = append(response.Warnings, selectorWarning(selector))
+	}
+	if fanOutWarning != "" {
+		response.Warnings = append(response.Warnings, fanOutWarning)
+	}
+	config.flagNoProviders(&response, validationRequest, providers)
+	if iban, err := constructIBAN(validationRequest); err != nil {
+		response.Warnings = append(response.Warnings, err.Error())
+	} else if iban != "" {
+		response.Metadata = withIBAN(response.Metadata, iban)
+	}
+	if masked := config.Masking.mask(*validationRequest.AccountNumber); masked != "" && response.Metadata != nil {
+		response.Metadata = withMaskedAccount(response.Metadata, masked)
+	}
+	if config.Debug && timerFrom(ctx).cold() && response.Metadata != nil {
+		response.Metadata = withColdStart(response.Metadata)
+	}
+	if warnings := config.quotaWarnings(request, response.Result); warnings != nil {
+		response.Metadata = withQuotaWarnings(response.Metadata, warnings)
+	}
+	response.EstimatedCost = config.responseCost(response, isTestAccount)
+	response.Receipt = config.issueReceipt(ctx, validationRequest, response)
+	config.queueWebhooks(ctx, request, validationRequest, response)
+	config.recordValidation(ctx, request, validationRequest, response, isTestAccount, time.Since(start))
+	return response
+}
+
+// Serialises the validation response into the body sent back to API Gateway
+func marshalResponse(response BankAccountValidationResponse) (string, error) {
+	return encodeJSON(response)
+}
+
+func providersToCall(ctx context.Context, providers []Provider, filter *[]string) []Provider {
 	if filter == nil {
-		return providers
+		return enabledProvidersFor(ctx, providers)
 	}
 	// Could do this once instead of on every request
-	confMap := map[string]Provider{}
-	for _, value := range providers {
-		confMap[value.Name] = value
-	}
+	index := providerIndex(providers)
+	called := map[int]bool{}
 	filteredProviders := []Provider{}
-	for _, providerName := range *filter {
-		providerConfig, exists := confMap[providerName]
-		if exists {
-			filteredProviders = append(filteredProviders, providerConfig)
+	for _, selector := range *filter {
+		for _, i := range selectProviders(providers, index, selector) {
+			if !called[i] && !providers[i].disabledFor(ctx) {
+				called[i] = true
+				filteredProviders = append(filteredProviders, providers[i])
+			}
 		}
 	}
 	return filteredProviders
@@ -123,28 +492,81 @@ func providersToCall(providers []Provider, filter *[]string) []Provider {
 
 // Deserialises and validate request
 func unmarshalRequest(request Request) (*BankAccountValidationRequest, *Response) {
+	validationRequest, message, err := decodeRequest(request)
+	if err != nil {
+		return nil, handleError(err, message)
+	}
+	return validationRequest, nil
+}
+
+// Does the work for unmarshalRequest, the message is what we tell the caller when it fails
+func decodeRequest(request Request) (*BankAccountValidationRequest, string, error) {
 	var validationRequest *BankAccountValidationRequest
 
-	if err := json.Unmarshal([]byte(request.Body), &validationRequest); err != nil {
-		return nil, handleError(err, "invalid json payload")
+	// Account numbers don't go in URLs, where every log on the way keeps them
+	if request.HTTPMethod == "GET" {
+		message := "validations are POSTed, the account number doesn't belong in the URL"
+		return nil, message, errors.New(message)
+	}
+	if err := decodeJSON(request.Body, &validationRequest); err != nil {
+		return nil, decodeMessage(err), err
 	}
 
-	if validationRequest.AccountNumber == nil {
+	if validationRequest == nil || validationRequest.AccountNumber == nil {
 		message := "account number missing from payload"
-		return nil, handleError(errors.New(message), message)
+		return nil, message, errors.New(message)
 	}
 
-	return validationRequest, nil
+	if err := rejectCardNumber(*validationRequest.AccountNumber); err != nil {
+		return nil, err.Error(), err
+	}
+
+	if validationRequest.Strategy != nil {
+		if err := validateStrategy(*validationRequest.Strategy); err != nil {
+			return nil, err.Error(), err
+		}
+	}
+
+	if validationRequest.ClientReference != nil {
+		if err := validateClientReference(*validationRequest.ClientReference); err != nil {
+			return nil, err.Error(), err
+		}
+	}
+
+	if validationRequest.AccountType != nil {
+		if err := validateAccountType(*validationRequest.AccountType); err != nil {
+			return nil, err.Error(), err
+		}
+	}
+
+	if validationRequest.Currency != nil {
+		if err := validateCurrency(*validationRequest.Currency); err != nil {
+			return nil, err.Error(), err
+		}
+	}
+
+	if err := validateMinProviders(validationRequest.MinProviders); err != nil {
+		return nil, err.Error(), err
+	}
+
+	return validationRequest, "", nil
 }
 
 // Fire off sync calls to the providers
-func checkProviders(accountNumber string, providers []Provider) BankAccountValidationResponse {
-	channel := make(chan BankAccountValidationResult)
+func checkProviders(ctx context.Context, accountNumber string, providers []Provider) BankAccountValidationResponse {
+	return aggregateResults(providers, checkProvidersAsync(ctx, accountNumber, providers))
+}
+
+// Calls the providers in parallel on the worker pool, results come out of the channel as they arrive and it's closed
+// after the last one
+func checkProvidersAsync(ctx context.Context, accountNumber string, providers []Provider) <-chan BankAccountValidationResult {
+	// Buffered so a worker never waits on the reader, which could be stuck waiting for a worker
+	channel := make(chan BankAccountValidationResult, len(providers))
 	var wg sync.WaitGroup
 
 	for _, provider := range providers {
 		wg.Add(1)
-		go checkProvider(accountNumber, provider, channel, &wg)
+		providerPool.submit(ctx, func() { checkProvider(ctx, accountNumber, provider, channel, &wg) })
 	}
 
 	// little bit lazy to have this annomymous and call itself.
@@ -154,82 +576,189 @@ func checkProviders(accountNumber string, providers []Provider) BankAccountValid
 		close(channel)
 	}()
 
-	// An endless loop that just waits for results to come in through the channel
-	// I am almost sure there is a nicer way to do this syntatically, but time is
-	// short
-	results := []BankAccountValidationResult{}
+	return channel
+}
+
+// Passes results through, calling onResult with each on the way
+func notify(results <-chan BankAccountValidationResult, onResult func(BankAccountValidationResult)) <-chan BankAccountValidationResult {
+	if onResult == nil {
+		return results
+	}
+	notified := make(chan BankAccountValidationResult)
+	go func() {
+		defer close(notified)
+		for result := range results {
+			onResult(result)
+			notified <- result
+		}
+	}()
+	return notified
+}
+
+// Waits for results to come in through the channel and puts them back in the
+// order the providers were asked for, so the response doesn't depend on who
+// answered first.
+func aggregateResults(providers []Provider, channel <-chan BankAccountValidationResult) BankAccountValidationResponse {
+	byProvider := make(map[string]BankAccountValidationResult, len(providers))
 	for result := range channel {
-		results = append(results, result)
+		byProvider[result.Provider] = result
+	}
+	results := make([]BankAccountValidationResult, 0, len(providers))
+	for _, provider := range providers {
+		if result, exists := byProvider[provider.Name]; exists {
+			results = append(results, result)
+		}
 	}
 	return BankAccountValidationResponse{Result: results}
 }
 
 // Function to check a provider.
-func checkProvider(accountNumber string, provider Provider, c chan BankAccountValidationResult, wg *sync.WaitGroup) {
+func checkProvider(ctx context.Context, accountNumber string, provider Provider, c chan BankAccountValidationResult, wg *sync.WaitGroup) {
 	defer (*wg).Done()
+	if provider.Type == ProviderTypeSimulated {
+		c <- simulateProvider(accountNumber, provider)
+		return
+	}
+	if provider.Type == ProviderTypeSEPA {
+		c <- provider.sepa.check(provider.Name, accountDetailsFrom(ctx).BIC)
+		return
+	}
+	// Another attempt after a failure, up to the provider's retries, while the breaker lets us
+	result := BankAccountValidationResult{Provider: provider.Name, Error: ProviderErrorCircuitOpen}
+	for attempt := 0; attempt <= provider.Retries && provider.breaker.allowFor(ctx); attempt++ {
+		url, next := provider.target(accountNumber)
+		start := time.Now()
+		inFlightCalls.Add(1)
+		if adapter, exists := adapterFor(provider.Type); exists {
+			result = callAdapter(ctx, adapter, accountNumber, provider, url)
+		} else {
+			result = callProvider(ctx, accountNumber, provider, url)
+		}
+		inFlightCalls.Add(-1)
+		latency := time.Since(start)
+		timerFrom(ctx).providerCall(provider.Name, attempt, latency, result.Error)
+		if result.Error != "" && errors.Is(ctx.Err(), context.Canceled) {
+			// Called off, it says nothing about the provider
+			result.Error = ProviderErrorCancelled
+			break
+		}
+		if chargeable(provider, result) {
+			bill(ctx, provider)
+		}
+		provider.breaker.record(result.Error == "")
+		provider.endpoints.record(url, latency, result.Error != "")
+		provider.stats.record(latency, result.Error)
+		provider.rollout.record(next, latency, result.Error)
+		provider.sla.record(provider, latency, result.Error)
+		provider.countQuota()
+		provider.alerter.check(provider.stats)
+		configCanary.record(ctx, result.Error != "")
+		if !retryable(result.Error) || ctx.Err() != nil {
+			break
+		}
+	}
+	c <- result
+}
+
+// Makes the http call to the provider and works out the verdict
+func callProvider(ctx context.Context, accountNumber string, provider Provider, url string) BankAccountValidationResult {
 	defaultResponse := BankAccountValidationResult{
 		IsValid:  false,
 		Provider: provider.Name,
 	}
 	client := http.Client{
-    Timeout: 1 * time.Second,
+		Timeout:   provider.timeout(),
+		Transport: captureFrom(ctx).transport(providerTransport, provider),
 	}
 
 	// Make the http call
-	values := map[string]string{"accountNumber": accountNumber}
-	json_data, err := json.Marshal(values)
+	details := accountDetailsFrom(ctx)
+	json_data, err := provider.requestBody(TemplateData{AccountNumber: accountNumber, AccountType: details.AccountType, Currency: details.Currency})
 	if err != nil {
 		log.Print(err)
-		c <- defaultResponse
-		return
+		defaultResponse.Error = ProviderErrorRequest
+		return defaultResponse
 	}
 
-	response, err := client.Post(provider.URL, "application/json", bytes.NewBuffer(json_data)) // TODO POST with the right payload
+	request, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(json_data))
 	if err != nil {
 		log.Print(err)
-		c <- defaultResponse
-		return
+		defaultResponse.Error = ProviderErrorRequest
+		return defaultResponse
 	}
+	provider.setHeaders(request)
+	setTraceHeaders(ctx, request)
+	defaultResponse.CallID = provider.setCallID(request)
+	request.Header.Set("Content-Type", "application/json")
+	if err := provider.Signing.sign(request, json_data, time.Now()); err != nil {
+		log.Print(err)
+		defaultResponse.Error = ProviderErrorRequest
+		return defaultResponse
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		log.Print(err)
+		defaultResponse.Error = requestError(err)
+		return defaultResponse
+	}
+	defer response.Body.Close()
 
 	// Parse the response
 	bodyBytes, err := io.ReadAll(response.Body)
 	if err != nil {
 		log.Print(err)
-		c <- defaultResponse
-		return
+		defaultResponse.Error = requestError(err)
+		return defaultResponse
 	}
 
-	// Parse the json into a struct
-	var providerResponse *DataProviderResponse
-	if err := json.Unmarshal(bodyBytes, &providerResponse); err != nil {
+	// Pull the verdict out of the json
+	isValid, raw, err := provider.readVerdict(ctx, bodyBytes, response.StatusCode)
+	if err != nil {
 		log.Print(err)
-		c <- defaultResponse
-		return
+		defaultResponse.Error = ProviderErrorResponse
+		return defaultResponse
 	}
 
-	// Send the result to the channel
-	c <- BankAccountValidationResult{
-		IsValid:  providerResponse.IsValid,
-		Provider: provider.Name,
+	reason, rawReason := "", ""
+	if !isValid {
+		reason, rawReason = provider.normalizeReason(raw)
+	}
+	return BankAccountValidationResult{
+		IsValid:   isValid,
+		Provider:  provider.Name,
+		CallID:    defaultResponse.CallID,
+		Reason:    reason,
+		RawReason: rawReason,
+	}
+}
+
+// Maps a transport error onto the error code we report for the provider
+func requestError(err error) string {
+	if isTLSViolation(err) {
+		return ProviderErrorSecurity
 	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ProviderErrorTimeout
+	}
+	return ProviderErrorRequest
 }
 
 // Generic error handling response builder
 func handleError(err error, message string) *Response {
 	log.Print(err)
-	var buf bytes.Buffer
-	body, err := json.Marshal(map[string]interface{}{
+	body, err := encodeJSON(map[string]interface{}{
 		"error": message,
 	})
 	if err != nil {
 		log.Print("Unable to serialise error message")
 		log.Print(err)
 	}
-	json.HTMLEscape(&buf, body)
 	return &Response{
 		StatusCode:      500,
 		IsBase64Encoded: false,
-		Body:            buf.String(),
+		Body:            body,
 		Headers: map[string]string{
 			"Content-Type": "application/json",
 		},
@@ -247,20 +776,112 @@ func readConfig() (*Config, *Response) {
 	if !exists {
 		return nil, handleError(nil, "ENVVAR PROVIDERS is required")
 	}
-	var config *Config
-	err := yaml.Unmarshal([]byte(providerYaml), &config)
+	data, err := applyOverlay([]byte(providerYaml), os.Getenv("ENVIRONMENT"))
+	if err != nil {
+		return nil, handleError(err, "ENVVAR PROVIDERS is invalid yaml")
+	}
+	config, err := parseConfig(data)
 	if err != nil || config == nil {
 		return nil, handleError(nil, "ENVVAR PROVIDERS is invalid yaml")
 	}
+	if err := config.applyOverrides(os.LookupEnv); err != nil {
+		return nil, handleError(err, "provider override is invalid: "+err.Error())
+	}
+	if err := config.compile(); err != nil {
+		return nil, handleError(err, "ENVVAR PROVIDERS is invalid: "+err.Error())
+	}
+	config.setupProviders()
+	config.setupMetadata(providerYaml)
 	return config, nil
 }
 
 func main() {
+	if err := loadAdapterPlugins(); err != nil {
+		log.Fatal(err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:], os.Stdout))
+	}
+	loaded := containerInit.phase(InitPhaseConfigLoad)
 	config, err := readConfig()
+	loaded()
 	if err != nil {
+		if addr, exists := os.LookupEnv("SERVER_ADDR"); exists {
+			log.Fatal(serve(addr, httpHandler(func(ctx context.Context, request Request) (Response, error) { return err.OnlyErrors(), nil })))
+		}
 		lambda.Start(err.OnlyErrors)
-	} else {
-		log.Println(config)
-		lambda.Start(config.Handler)
+		return
+	}
+	log.Println(config)
+	config.setupEncoding()
+	config.setupStore(context.Background())
+	config.setupCaching()
+	config.setupTransport()
+	setupWorkerPool()
+	config.setupConfigCanary()
+	config.runInit(context.Background(), config.initSteps()...)
+	if err := config.setupAccountHashing(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	config.setupTimings()
+	config.setupAdmin()
+	config.telemetry.start()
+	if provisionedConcurrency() {
+		warmed := containerInit.phase(InitPhaseWarmUp)
+		config.warm(context.Background())
+		warmed()
+	}
+	config.recordInit(containerInit)
+
+	if addr, exists := os.LookupEnv("SERVER_ADDR"); exists {
+		log.Fatal(serve(addr, config.streamingHandler()))
+	}
+	switch os.Getenv("MODE") {
+	case "probe":
+		config.startLambda(config.ProbeHandler)
+	case "usage-report":
+		config.startLambda(config.UsageReportHandler)
+	case "feedback-export":
+		config.startLambda(config.FeedbackExportHandler)
+	case "outbox":
+		config.startLambda(config.OutboxHandler)
+	case "websocket":
+		config.startLambda(config.WebsocketHandler)
+	case "stream":
+		lambdaurl.Start(config.telemetry.afterServing(config.streamingHandler()))
+	case "kafka":
+		config.startLambda(config.KafkaHandler)
+	case "sns":
+		config.startLambda(config.SNSHandler)
+	case "kafka-consumer":
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		name, _ := os.Hostname()
+		err := config.consumeKafka(ctx, name)
+		config.telemetry.flush(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		config.startLambda(config.LambdaHandler)
+	}
+}
+
+// Connects to the provider status table if there is one and seeds the breakers from it
+func (config *Config) setupStatusStore(ctx context.Context) {
+	table, exists := os.LookupEnv("STATUS_TABLE")
+	if !exists {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	store, err := newDynamoStatusStore(ctx, table)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	config.statusStore = store
+	if err := config.seedBreakers(ctx); err != nil {
+		log.Printf("unable to seed circuit breakers: %v", err)
 	}
 }
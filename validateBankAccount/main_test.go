@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
 	"testing"
@@ -102,7 +106,7 @@ func Test_unmarshalRequest(t *testing.T) {
 		{name: "missingAccount",
 			args: args{
 				request: Request{
-					Body: "{\"david\": \"12345678\"}",
+					Body: "{\"providers\": [\"provider1\"]}",
 				},
 			},
 			want: nil,
@@ -115,6 +119,38 @@ func Test_unmarshalRequest(t *testing.T) {
 				},
 			},
 		},
+		{name: "unknownField",
+			args: args{
+				request: Request{
+					Body: "{\"david\": \"12345678\"}",
+				},
+			},
+			want: nil,
+			want1: &Response{
+				StatusCode:      500,
+				IsBase64Encoded: false,
+				Body:            "{\"error\":\"unknown field \\\"david\\\" in payload\"}",
+				Headers: map[string]string{
+					"Content-Type": "application/json",
+				},
+			},
+		},
+		{name: "trailingData",
+			args: args{
+				request: Request{
+					Body: "{\"accountNumber\": \"12345678\"} {}",
+				},
+			},
+			want: nil,
+			want1: &Response{
+				StatusCode:      500,
+				IsBase64Encoded: false,
+				Body:            "{\"error\":\"invalid json payload\"}",
+				Headers: map[string]string{
+					"Content-Type": "application/json",
+				},
+			},
+		},
 		{name: "invalidJson",
 			args: args{
 				request: Request{
@@ -281,7 +317,7 @@ func Test_providersToCall(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := providersToCall(tt.args.providers, tt.args.filter); !reflect.DeepEqual(got, tt.want) {
+			if got := providersToCall(context.Background(), tt.args.providers, tt.args.filter); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("providersToCall() = %v, want %v", got, tt.want)
 			}
 		})
@@ -308,19 +344,60 @@ func Test_checkProviders(t *testing.T) {
 			},
 			want: BankAccountValidationResponse{
 				Result: []BankAccountValidationResult{
-					{Provider: "provider2", IsValid: false},
-					{Provider: "provider1", IsValid: false},
+					{Provider: "provider1", IsValid: false, Error: ProviderErrorRequest},
+					{Provider: "provider2", IsValid: false, Error: ProviderErrorRequest},
 				},
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := checkProviders(tt.args.accountNumber, tt.args.providers); !reflect.DeepEqual(got, tt.want) {
+			if got := checkProviders(context.Background(), tt.args.accountNumber, tt.args.providers); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("checkProviders() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-// TODO implement http mocks (although likely to do this as an E2E test)
+func Test_checkProviders_httpMock(t *testing.T) {
+	valid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer valid.Close()
+	garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>oops</html>"))
+	}))
+	defer garbage.Close()
+
+	got := checkProviders(context.Background(), "12345678", []Provider{
+		{Name: "valid", URL: valid.URL},
+		{Name: "garbage", URL: garbage.URL},
+	})
+	want := BankAccountValidationResponse{
+		Result: []BankAccountValidationResult{
+			{Provider: "valid", IsValid: true},
+			{Provider: "garbage", IsValid: false, Error: ProviderErrorResponse},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkProviders() = %v, want %v", got, want)
+	}
+}
+
+func Test_requestError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "timeout", err: &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, want: ProviderErrorTimeout},
+		{name: "other", err: errors.New("connection refused"), want: ProviderErrorRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestError(tt.err); got != tt.want {
+				t.Errorf("requestError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
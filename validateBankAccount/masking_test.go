@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMaskingConfig_mask(t *testing.T) {
+	tests := []struct {
+		name          string
+		masking       MaskingConfig
+		accountNumber string
+		want          string
+	}{
+		{"default", MaskingConfig{}, "12345678", "****5678"},
+		{"spaced", MaskingConfig{}, "1234 5678", "****5678"},
+		{"last2", MaskingConfig{Strategy: MaskingLast2}, "12345678", "****78"},
+		{"first2last4", MaskingConfig{Strategy: MaskingFirst2Last4}, "DE89370400440532013000", "DE****3000"},
+		{"preserveLength", MaskingConfig{PreserveLength: true}, "12345678", "****5678"},
+		{"preserveLengthLong", MaskingConfig{Strategy: MaskingFirst2Last4, PreserveLength: true}, "GB29NWBK60161331926819", "GB****************6819"},
+		{"short", MaskingConfig{}, "123456", "****456"},
+		{"shortFirst2Last4", MaskingConfig{Strategy: MaskingFirst2Last4}, "123456", "1****56"},
+		{"tiny", MaskingConfig{}, "1", "****"},
+		{"omit", MaskingConfig{Strategy: MaskingOmit}, "12345678", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.masking.mask(tt.accountNumber); got != tt.want {
+				t.Errorf("mask(%s) = %s, want %s", tt.accountNumber, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskingConfig_validate(t *testing.T) {
+	if err := (MaskingConfig{Strategy: "first6last4"}).validate(); err == nil {
+		t.Error("validate() should reject an unknown strategy")
+	}
+	if err := (MaskingConfig{Strategy: MaskingOmit}).validate(); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+}
+
+func TestConfig_validate_masking(t *testing.T) {
+	sink := &fakeAuditSink{}
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		Masking:   MaskingConfig{Strategy: MaskingLast2},
+		metadata:  &ResponseMetadata{ConfigHash: "9f86d081884c"},
+		telemetry: newTelemetry(sink, nil),
+	}
+	accountNumber := "12345670"
+	response := config.validate(context.Background(), Request{}, &BankAccountValidationRequest{AccountNumber: &accountNumber}, nil)
+	if response.Metadata.AccountNumber != "****70" || config.metadata.AccountNumber != "" {
+		t.Errorf("metadata = %+v, config metadata = %+v", response.Metadata, config.metadata)
+	}
+	if audit := config.telemetry.audit; len(audit) != 1 || audit[0].AccountMasked != "****70" {
+		t.Errorf("audit = %+v", audit)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+/*
+  POST /validate-matrix checks every account against every provider in one call, for reconciliation jobs that would
+  otherwise make thousands of single calls. The providers, strategy and minProviders are for the whole matrix, an
+  account has its details and nothing else:
+
+    {"accounts": [{"accountNumber": "12345678"}, {"accountNumber": "87654321", "currency": "EUR"}],
+     "providers": ["provider1", "provider2"], "strategy": "all"}
+
+  The response is one row per account, in the order they were sent, with a cell for each provider column:
+
+    {"providers": ["provider1", "provider2"],
+     "accounts": [
+       {"index": 0, "outcome": "valid", "aggregate": {"strategy": "all", "isValid": true},
+        "cells": [{"provider": "provider1", "status": "valid"}, {"provider": "provider2", "status": "valid"}]},
+       {"index": 1, "outcome": "error", "aggregate": {"strategy": "all", "isValid": false},
+        "cells": [{"provider": "provider1", "status": "error", "error": "timeout"}, {"provider": "provider2", "status": "notCalled"}]}]}
+
+  A cell is valid, invalid, error, or notCalled when the provider wasn't asked about that account, because it doesn't
+  handle the account's type or currency or the fan-out cap left it out. The columns are the providers the caller
+  can use, so asking for one we don't have puts it in unknownProviders instead. An account that can't be validated
+  at all gets an error on its row, like a batch item. The rows are validated batch.concurrency at a time, each with
+  its own deadline (see itemdeadlines.go), and there can be as many as a batch's maxRequests. Response filters apply
+  to each row, hiding the results hides the cells and hiding the aggregate hides the outcome too. Rows aren't
+  signed.
+*/
+
+const (
+	CellStatusNotCalled = "notCalled"
+	matrixMissing       = "accounts missing from payload"
+)
+
+var errMatrixAccountOptions = errors.New("providers, strategy and minProviders are for the whole matrix, not an account")
+
+type MatrixRequest struct {
+	Accounts     []json.RawMessage `json:"accounts"`
+	Providers    *[]string         `json:"providers"`
+	Strategy     *string           `json:"strategy"`
+	MinProviders *int              `json:"minProviders"`
+}
+
+type MatrixResponse struct {
+	Providers        []string    `json:"providers"`
+	Accounts         []MatrixRow `json:"accounts"`
+	UnknownProviders []string    `json:"unknownProviders,omitempty"`
+}
+
+type MatrixRow struct {
+	Index           int              `json:"index"`
+	ClientReference string           `json:"clientReference,omitempty"`
+	Outcome         string           `json:"outcome,omitempty"`
+	Aggregate       *AggregateResult `json:"aggregate,omitempty"`
+	Cells           []MatrixCell     `json:"cells,omitempty"`
+	Partial         bool             `json:"partial,omitempty"`
+	Warnings        []string         `json:"warnings,omitempty"`
+	Error           string           `json:"error,omitempty"`
+}
+
+type MatrixCell struct {
+	Provider string `json:"provider"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func decodeMatrix(body string, max int) (*MatrixRequest, string, error) {
+	var matrix MatrixRequest
+	if err := decodeJSON(body, &matrix); err != nil {
+		return nil, decodeMessage(err), err
+	}
+	if len(matrix.Accounts) == 0 {
+		return nil, matrixMissing, errors.New(matrixMissing)
+	}
+	if len(matrix.Accounts) > max {
+		message := fmt.Sprintf("too many accounts, the most in one matrix is %d", max)
+		return nil, message, errors.New(message)
+	}
+	if matrix.Strategy != nil {
+		if err := validateStrategy(*matrix.Strategy); err != nil {
+			return nil, err.Error(), err
+		}
+	}
+	if err := validateMinProviders(matrix.MinProviders); err != nil {
+		return nil, err.Error(), err
+	}
+	return &matrix, "", nil
+}
+
+// The account's validation request, with the matrix's providers and strategy
+func (matrix *MatrixRequest) account(body json.RawMessage) (*BankAccountValidationRequest, string, error) {
+	validationRequest, message, err := decodeRequest(Request{Body: string(body)})
+	if err != nil {
+		return nil, message, err
+	}
+	if validationRequest.Providers != nil || validationRequest.Strategy != nil || validationRequest.MinProviders != nil {
+		return nil, errMatrixAccountOptions.Error(), errMatrixAccountOptions
+	}
+	validationRequest.Providers = matrix.Providers
+	validationRequest.Strategy = matrix.Strategy
+	validationRequest.MinProviders = matrix.MinProviders
+	return validationRequest, "", nil
+}
+
+// A cell for each column, from the account's (filtered) response
+func matrixCells(columns []string, response BankAccountValidationResponse) []MatrixCell {
+	results := map[string]BankAccountValidationResult{}
+	for _, result := range response.Result {
+		if !result.Local {
+			results[result.Provider] = result
+		}
+	}
+	cells := make([]MatrixCell, 0, len(columns))
+	for _, provider := range columns {
+		result, called := results[provider]
+		cell := MatrixCell{Provider: provider, Status: ResultStatusInvalid}
+		switch {
+		case !called:
+			cell.Status = CellStatusNotCalled
+		case result.Error != "":
+			cell.Status, cell.Error = ResultStatusError, result.Error
+		case result.IsValid:
+			cell.Status = ResultStatusValid
+		default:
+			cell.Reason = result.Reason
+		}
+		cells = append(cells, cell)
+	}
+	return cells
+}
+
+func (config *Config) matrixRow(ctx context.Context, request Request, matrix *MatrixRequest, columns []string, schedule *batchSchedule, index int) MatrixRow {
+	row := MatrixRow{Index: index}
+	validationRequest, message, err := matrix.account(matrix.Accounts[index])
+	if err != nil {
+		log.Printf("bad account at index %d: %v", index, err)
+		row.Error = message
+		return row
+	}
+	if message := config.itemError(ctx, request, validationRequest); message != "" {
+		row.Error = message
+		return row
+	}
+	fields := config.visibleFields(request)
+	itemCtx, cancel, warning := config.scheduleItem(ctx, schedule, validationRequest)
+	unfiltered := config.validate(itemCtx, request, validationRequest, nil)
+	cancel()
+	if warning != "" {
+		unfiltered.Warnings = append(unfiltered.Warnings, warning)
+	}
+	response := filterResponse(fields, unfiltered)
+	row.ClientReference = response.ClientReference
+	row.Aggregate = response.Aggregate
+	row.Partial = response.Partial
+	row.Warnings = response.Warnings
+	if fields == nil || fields[ResponseFieldAggregate] {
+		row.Outcome = outcome(validationRequest, unfiltered)
+	}
+	if fields == nil || fields[ResponseFieldResult] {
+		row.Cells = matrixCells(columns, response)
+	}
+	return row
+}
+
+// Validates every account, batch.concurrency at a time
+func (config *Config) validateMatrix(ctx context.Context, request Request, matrix *MatrixRequest) MatrixResponse {
+	columns := []string{}
+	for _, provider := range entitlementFrom(ctx).restrict(providersToCall(ctx, config.Providers, matrix.Providers)) {
+		columns = append(columns, provider.Name)
+	}
+	response := MatrixResponse{
+		Providers:        columns,
+		Accounts:         make([]MatrixRow, len(matrix.Accounts)),
+		UnknownProviders: unknownProviders(config.Providers, matrix.Providers),
+	}
+	schedule := config.newBatchSchedule(ctx, len(matrix.Accounts))
+	slots := make(chan struct{}, config.Batch.concurrency())
+	var wg sync.WaitGroup
+	for i := range matrix.Accounts {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			response.Accounts[i] = config.matrixRow(ctx, request, matrix, columns, schedule, i)
+		}(i)
+	}
+	wg.Wait()
+	return response
+}
+
+// Handler for POST /validate-matrix
+func (config *Config) MatrixHandler(ctx context.Context, request Request) (Response, error) {
+	parsed := timerFrom(ctx).stage(StageParse)
+	matrix, message, err := decodeMatrix(request.Body, entitlementFrom(ctx).batchLimit(config.Batch.maxRequests()))
+	parsed()
+	if err != nil {
+		return *handleError(err, message), nil
+	}
+	if shedResponse := config.shed(request, &BankAccountValidationRequest{}); shedResponse != nil {
+		return *shedResponse, nil
+	}
+	return jsonResponse(200, config.validateMatrix(withBatchSize(ctx, len(matrix.Accounts)), request, matrix))
+}
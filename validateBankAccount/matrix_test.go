@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConfig_MatrixHandler(t *testing.T) {
+	config := &Config{Providers: []Provider{
+		{Name: "provider1", Type: ProviderTypeSimulated},
+		{Name: "provider2", Type: ProviderTypeSimulated, Currencies: []string{"GBP"}},
+	}}
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"matrix", "{\"accounts\": [{\"accountNumber\": \"12345670\"}, {\"accountNumber\": \"12345670\", \"currency\": \"EUR\"}, " +
+			"{\"accountNumber\": \"12348888\", \"clientReference\": \"inv-3\"}, {\"accountNumber\": \"12345671\", \"strategy\": \"any\"}], " +
+			"\"providers\": [\"provider1\", \"provider2\", \"provider9\"], \"strategy\": \"all\"}", 200,
+			"{\"providers\":[\"provider1\",\"provider2\"],\"accounts\":[" +
+				"{\"index\":0,\"outcome\":\"valid\",\"aggregate\":{\"strategy\":\"all\",\"isValid\":true},\"cells\":[{\"provider\":\"provider1\",\"status\":\"valid\"},{\"provider\":\"provider2\",\"status\":\"valid\"}],\"warnings\":[\"unknown provider provider9\"]}," +
+				"{\"index\":1,\"outcome\":\"valid\",\"aggregate\":{\"strategy\":\"all\",\"isValid\":true},\"cells\":[{\"provider\":\"provider1\",\"status\":\"valid\"},{\"provider\":\"provider2\",\"status\":\"notCalled\"}],\"warnings\":[\"unknown provider provider9\"]}," +
+				"{\"index\":2,\"clientReference\":\"inv-3\",\"outcome\":\"error\",\"aggregate\":{\"strategy\":\"all\",\"isValid\":false},\"cells\":[{\"provider\":\"provider1\",\"status\":\"error\",\"error\":\"invalid_response\"},{\"provider\":\"provider2\",\"status\":\"error\",\"error\":\"invalid_response\"}],\"warnings\":[\"unknown provider provider9\"]}," +
+				"{\"index\":3,\"error\":\"providers, strategy and minProviders are for the whole matrix, not an account\"}]," +
+				"\"unknownProviders\":[\"provider9\"]}"},
+		{"noAccounts", "{\"providers\": [\"provider1\"]}", 500, "{\"error\":\"accounts missing from payload\"}"},
+		{"badStrategy", "{\"accounts\": [{\"accountNumber\": \"12345670\"}], \"strategy\": \"most\"}", 500, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := config.Router(context.Background(), Request{HTTPMethod: "POST", Path: "/validate-matrix", Body: tt.body})
+			if err != nil || response.StatusCode != tt.wantStatus || (tt.wantBody != "" && response.Body != tt.wantBody) {
+				t.Errorf("Router() = %d %s, want %d %s", response.StatusCode, response.Body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestConfig_MatrixHandler_tooManyAccounts(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}, Batch: BatchConfig{MaxRequests: 1}}
+	body := "{\"accounts\": [{\"accountNumber\": \"12345670\"}, {\"accountNumber\": \"12345672\"}]}"
+	response, _ := config.MatrixHandler(context.Background(), Request{HTTPMethod: "POST", Body: body})
+	if response.StatusCode != 500 || response.Body != "{\"error\":\"too many accounts, the most in one matrix is 1\"}" {
+		t.Errorf("MatrixHandler() = %d %s", response.StatusCode, response.Body)
+	}
+}
+
+func Test_matrixCells_hidesLocalChecks(t *testing.T) {
+	response := BankAccountValidationResponse{Result: []BankAccountValidationResult{
+		{Provider: "bic", Local: true, Error: "invalid_bic"},
+		{Provider: "provider1", IsValid: false},
+	}}
+	cells := matrixCells([]string{"provider1"}, response)
+	if len(cells) != 1 || cells[0] != (MatrixCell{Provider: "provider1", Status: ResultStatusInvalid}) {
+		t.Errorf("matrixCells() = %+v", cells)
+	}
+}
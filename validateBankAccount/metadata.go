@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+)
+
+/*
+  Which deployment and configuration answered a request. When results look wrong the first question is always
+  "which config was that?", so every response carries the Lambda function version, a hash of the PROVIDERS yaml and
+  the revision and updatedAt the config declares about itself:
+
+    revision: "42"
+    updatedAt: 2024-06-01T09:00:00Z
+    providers:
+    - ...
+
+  gives
+
+    "metadata": {"functionVersion": "17", "configHash": "9f86d081884c", "configRevision": "42", "configUpdatedAt": "2024-06-01T09:00:00Z"}
+*/
+
+type ResponseMetadata struct {
+	FunctionVersion string `json:"functionVersion,omitempty"`
+	ConfigHash      string `json:"configHash"`
+	ConfigRevision  string `json:"configRevision,omitempty"`
+	ConfigUpdatedAt string `json:"configUpdatedAt,omitempty"`
+	// Built from the request's domestic details, see iban.go
+	IBAN string `json:"iban,omitempty"`
+	// Masked, see masking.go
+	AccountNumber string `json:"accountNumber,omitempty"`
+	// With debug on, see coldstart.go
+	ColdStart bool `json:"coldStart,omitempty"`
+	// See quota.go
+	QuotaWarnings []QuotaWarning `json:"quotaWarnings,omitempty"`
+}
+
+// The config's metadata is shared so per request values go on a copy rather than being written to it
+func copyMetadata(metadata *ResponseMetadata) *ResponseMetadata {
+	copied := ResponseMetadata{}
+	if metadata != nil {
+		copied = *metadata
+	}
+	return &copied
+}
+
+// Enough of the hash to tell configs apart
+const configHashLength = 12
+
+func configHash(raw string) string {
+	hash := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(hash[:])[:configHashLength]
+}
+
+// Works out the metadata once at load, raw is the yaml the config came from
+func (config *Config) setupMetadata(raw string) {
+	config.metadata = &ResponseMetadata{
+		FunctionVersion: os.Getenv("AWS_LAMBDA_FUNCTION_VERSION"),
+		ConfigHash:      configHash(raw),
+		ConfigRevision:  config.Revision,
+		ConfigUpdatedAt: config.UpdatedAt,
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReadConfig_metadata(t *testing.T) {
+	raw := "revision: \"42\"\nupdatedAt: 2024-06-01T09:00:00Z\nproviders:\n- name: provider1\n  type: simulated\n"
+	os.Setenv("PROVIDERS", raw)
+	defer os.Unsetenv("PROVIDERS")
+	t.Setenv("AWS_LAMBDA_FUNCTION_VERSION", "17")
+
+	config, errorResponse := readConfig()
+	if errorResponse != nil {
+		t.Fatal(errorResponse.Body)
+	}
+	want := ResponseMetadata{FunctionVersion: "17", ConfigHash: configHash(raw), ConfigRevision: "42", ConfigUpdatedAt: "2024-06-01T09:00:00Z"}
+	if !reflect.DeepEqual(*config.metadata, want) {
+		t.Errorf("metadata = %+v, want %+v", *config.metadata, want)
+	}
+	if len(config.metadata.ConfigHash) != configHashLength || configHash(raw) == configHash(raw+" ") {
+		t.Errorf("configHash() should be %d characters and change with the config", configHashLength)
+	}
+
+	response, err := config.Handler(context.Background(), Request{Body: "{\"accountNumber\": \"12345670\"}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBody := "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}],\"metadata\":{\"functionVersion\":\"17\",\"configHash\":\"" + configHash(raw) + "\",\"configRevision\":\"42\",\"configUpdatedAt\":\"2024-06-01T09:00:00Z\",\"accountNumber\":\"****5670\"}}"
+	if response.Body != wantBody {
+		t.Errorf("Handler() body = %v, want %v", response.Body, wantBody)
+	}
+}
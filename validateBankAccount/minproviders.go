@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+/*
+  Minimum providers. One provider answering while the rest timed out isn't much of a verdict, so a response only
+  counts when enough providers actually answered:
+
+    minProviders: 2
+
+  A request can ask for more with "minProviders": 3, never fewer, asking for fewer than the config gets the config's.
+  A response with fewer answers than that comes back as a 424 with "partial": true, the results that did come in and
+  a warning, but no aggregate, so nobody takes it for a verdict. It's an error outcome as far as audit records,
+  verdicts and receipts are concerned. Local checks (see bic.go) don't count as answers, and an invalid from an
+  authoritative provider (see authoritative.go) or a failed local check that kept the providers from being called
+  (see localchecks.go) is enough on its own. Batch items and the other routes get the flag without the status.
+*/
+
+// The answers the request needs, 0 when any will do
+func (config *Config) minProviders(validationRequest *BankAccountValidationRequest) int {
+	if validationRequest.MinProviders != nil && *validationRequest.MinProviders > config.MinProviders {
+		return *validationRequest.MinProviders
+	}
+	return config.MinProviders
+}
+
+func validateMinProviders(min *int) error {
+	if min != nil && *min < 1 {
+		return errors.New("minProviders should be at least 1")
+	}
+	return nil
+}
+
+// Marks the response partial when too few providers answered
+func (config *Config) checkAnswers(response *BankAccountValidationResponse, validationRequest *BankAccountValidationRequest) {
+	required := config.minProviders(validationRequest)
+	answered := 0
+	for _, result := range response.Result {
+		if !result.Local && result.Error == "" {
+			answered++
+		}
+	}
+	if answered >= required || config.authoritativeInvalid(response.Result) != "" || remoteSkippedBy(response.Result) != "" {
+		return
+	}
+	response.Partial = true
+	response.Warnings = append(response.Warnings, fmt.Sprintf("only %d of the %d providers required answered", answered, required))
+	config.telemetry.count("PartialResponses", 1)
+}
+
+// The status for a validation response
+func validationStatus(response BankAccountValidationResponse) int {
+	if response.Partial {
+		return http.StatusFailedDependency
+	}
+	return http.StatusOK
+}
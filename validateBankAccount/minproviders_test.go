@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConfig_minProviders(t *testing.T) {
+	providers := []Provider{
+		{Name: "provider1", Type: ProviderTypeSimulated},
+		{Name: "provider2", Type: ProviderTypeSimulated},
+	}
+	tests := []struct {
+		name       string
+		min        int
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"enough", 2, "{\"accountNumber\": \"12345670\", \"strategy\": \"all\"}", 200,
+			"{\"result\":[{\"provider\":\"provider1\",\"isValid\":true},{\"provider\":\"provider2\",\"isValid\":true}],\"aggregate\":{\"strategy\":\"all\",\"isValid\":true}}"},
+		{"tooFew", 2, "{\"accountNumber\": \"12345670\", \"strategy\": \"all\", \"providers\": [\"provider1\"]}", 424,
+			"{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}],\"warnings\":[\"only 1 of the 2 providers required answered\"],\"partial\":true}"},
+		{"raised", 1, "{\"accountNumber\": \"12345670\", \"providers\": [\"provider1\"], \"minProviders\": 2}", 424,
+			"{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}],\"warnings\":[\"only 1 of the 2 providers required answered\"],\"partial\":true}"},
+		// Asking for fewer than the config gets the config's
+		{"notLowered", 2, "{\"accountNumber\": \"12345670\", \"providers\": [\"provider1\"], \"minProviders\": 1}", 424,
+			"{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}],\"warnings\":[\"only 1 of the 2 providers required answered\"],\"partial\":true}"},
+		{"badOverride", 0, "{\"accountNumber\": \"12345670\", \"minProviders\": 0}", 500, "{\"error\":\"minProviders should be at least 1\"}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Providers: providers, MinProviders: tt.min}
+			response, err := config.Handler(context.Background(), Request{HTTPMethod: "POST", Body: tt.body})
+			if err != nil || response.StatusCode != tt.wantStatus || (tt.wantBody != "" && response.Body != tt.wantBody) {
+				t.Errorf("Handler() = %d %s, want %d %s", response.StatusCode, response.Body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_outcome_partial(t *testing.T) {
+	response := BankAccountValidationResponse{Result: []BankAccountValidationResult{{Provider: "provider1", IsValid: true}}, Partial: true}
+	if got := outcome(&BankAccountValidationRequest{}, response); got != ResultStatusError {
+		t.Errorf("outcome() = %s, want %s", got, ResultStatusError)
+	}
+}
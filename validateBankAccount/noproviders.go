@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+/*
+  When no provider is left to call (the request named ones we don't have, none of them handle the account's type or
+  currency, or the caller isn't entitled to them) there's no answer to give, and {"result": []} reads like "valid
+  nowhere". noProviders picks what happens instead:
+
+    noProviders: flag     # a 200 with "noProvidersMatched": true and the names we didn't recognise, the default
+    noProviders: reject   # a 422 {"error": "no matching providers", "unknownProviders": [...]}
+
+    {"result": [], "noProvidersMatched": true, "unknownProviders": ["provider9"], "warnings": ["unknown provider provider9"]}
+
+  Test accounts count the same as real ones, they answer for the providers that would have been called. Rejecting
+  applies to the validate and batch routes, where a batch item gets the error instead of a response, the other
+  routes flag.
+*/
+
+const (
+	NoProvidersFlag   = "flag"
+	NoProvidersReject = "reject"
+)
+
+var errNoProviders = errors.New("no matching providers")
+
+type NoProvidersError struct {
+	Error            string   `json:"error"`
+	UnknownProviders []string `json:"unknownProviders,omitempty"`
+}
+
+func validateNoProviders(mode string) error {
+	if mode != "" && mode != NoProvidersFlag && mode != NoProvidersReject {
+		return fmt.Errorf("noProviders %s isn't flag or reject", mode)
+	}
+	return nil
+}
+
+// The providers the request will be sent to
+func (config *Config) selectedProviders(ctx context.Context, validationRequest *BankAccountValidationRequest) []Provider {
+	details := validationRequest.accountDetails()
+	return entitlementFrom(ctx).restrict(supportingProviders(providersToCall(ctx, config.Providers, validationRequest.Providers), details))
+}
+
+// Sets the flag on a response that had nobody to ask
+func (config *Config) flagNoProviders(response *BankAccountValidationResponse, validationRequest *BankAccountValidationRequest, providers []Provider) {
+	if len(providers) > 0 {
+		return
+	}
+	response.NoProvidersMatched = true
+	response.UnknownProviders = unknownProviders(config.Providers, validationRequest.Providers)
+}
+
+// The error when the request would be rejected for having no providers, nil when it can go ahead
+func (config *Config) noProvidersError(ctx context.Context, validationRequest *BankAccountValidationRequest) *NoProvidersError {
+	if config.NoProviders != NoProvidersReject || len(config.selectedProviders(ctx, validationRequest)) > 0 {
+		return nil
+	}
+	return &NoProvidersError{Error: errNoProviders.Error(), UnknownProviders: unknownProviders(config.Providers, validationRequest.Providers)}
+}
+
+// The 422 for a request with no providers to call, nil when it can go ahead
+func (config *Config) rejectNoProviders(ctx context.Context, validationRequest *BankAccountValidationRequest) *Response {
+	rejection := config.noProvidersError(ctx, validationRequest)
+	if rejection == nil {
+		return nil
+	}
+	config.telemetry.count("NoProvidersMatched", 1)
+	response, _ := jsonResponse(http.StatusUnprocessableEntity, rejection)
+	return &response
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestConfig_noProviders(t *testing.T) {
+	providers := []Provider{{Name: "provider1", Type: ProviderTypeSimulated, Currencies: []string{"GBP"}}}
+	tests := []struct {
+		name       string
+		mode       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"flagged", "", "{\"accountNumber\": \"12345670\", \"providers\": [\"provider9\"]}", 200,
+			"{\"result\":[],\"warnings\":[\"unknown provider provider9\"],\"noProvidersMatched\":true,\"unknownProviders\":[\"provider9\"]}"},
+		{"rejected", NoProvidersReject, "{\"accountNumber\": \"12345670\", \"providers\": [\"provider9\", \"provider1\"]}", 200, ""},
+		{"rejectedUnknown", NoProvidersReject, "{\"accountNumber\": \"12345670\", \"providers\": [\"provider9\"]}", 422,
+			"{\"error\":\"no matching providers\",\"unknownProviders\":[\"provider9\"]}"},
+		// The account's currency rules everybody out, nothing's unknown
+		{"rejectedCurrency", NoProvidersReject, "{\"accountNumber\": \"12345670\", \"currency\": \"EUR\"}", 422, "{\"error\":\"no matching providers\"}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Providers: providers, NoProviders: tt.mode}
+			response, err := config.Handler(context.Background(), Request{HTTPMethod: "POST", Body: tt.body})
+			if err != nil || response.StatusCode != tt.wantStatus || (tt.wantBody != "" && response.Body != tt.wantBody) {
+				t.Errorf("Handler() = %d %s, want %d %s", response.StatusCode, response.Body, tt.wantStatus, tt.wantBody)
+			}
+			if tt.wantStatus == 200 && tt.mode == NoProvidersReject && strings.Contains(response.Body, "noProvidersMatched") {
+				t.Errorf("Handler() = %s, want providers called", response.Body)
+			}
+		})
+	}
+}
+
+func TestConfig_noProviders_batch(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}, NoProviders: NoProvidersReject}
+	body := "{\"requests\": [{\"accountNumber\": \"12345670\"}, {\"accountNumber\": \"12345670\", \"providers\": [\"provider9\"]}]}"
+	response, err := config.BatchHandler(context.Background(), Request{Body: body})
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("BatchHandler() = %d %s", response.StatusCode, response.Body)
+	}
+	if !strings.Contains(response.Body, "{\"index\":1,\"error\":\"no matching providers\"}") || strings.Count(response.Body, "\"error\"") != 1 {
+		t.Errorf("BatchHandler() = %s, want the second item rejected", response.Body)
+	}
+}
+
+func Test_validateNoProviders(t *testing.T) {
+	for mode, wantErr := range map[string]bool{"": false, NoProvidersFlag: false, NoProvidersReject: false, "ignore": true} {
+		if err := validateNoProviders(mode); (err != nil) != wantErr {
+			t.Errorf("validateNoProviders(%q) error = %v, wantErr %v", mode, err, wantErr)
+		}
+	}
+}
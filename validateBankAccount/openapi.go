@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+/*
+  GET /openapi.json describes the routes the function serves, built from the route table in router.go so it can't
+  drift from what's actually routed. It lists the paths, their methods and path parameters rather than the bodies,
+  the README and /capabilities cover those.
+*/
+
+type OpenAPIDocument struct {
+	OpenAPI string                                 `json:"openapi"`
+	Info    OpenAPIInfo                            `json:"info"`
+	Paths   map[string]map[string]OpenAPIOperation `json:"paths"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIOperation struct {
+	Summary    string                     `json:"summary"`
+	Parameters []OpenAPIParameter         `json:"parameters,omitempty"`
+	Responses  map[string]OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIParameter struct {
+	Name     string            `json:"name"`
+	In       string            `json:"in"`
+	Required bool              `json:"required"`
+	Schema   map[string]string `json:"schema"`
+}
+
+type OpenAPIResponse struct {
+	Description string `json:"description"`
+}
+
+func openAPIDocument(routes []route) OpenAPIDocument {
+	document := OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    OpenAPIInfo{Title: "accountvalidator", Version: "1"},
+		Paths:   map[string]map[string]OpenAPIOperation{},
+	}
+	for _, route := range routes {
+		operation := OpenAPIOperation{
+			Summary:   route.summary,
+			Responses: map[string]OpenAPIResponse{"200": {Description: "OK"}},
+		}
+		for _, part := range strings.Split(route.path, "/") {
+			if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+				operation.Parameters = append(operation.Parameters, OpenAPIParameter{
+					Name: strings.Trim(part, "{}"), In: "path", Required: true, Schema: map[string]string{"type": "string"},
+				})
+			}
+		}
+		if document.Paths[route.path] == nil {
+			document.Paths[route.path] = map[string]OpenAPIOperation{}
+		}
+		document.Paths[route.path][strings.ToLower(route.method)] = operation
+	}
+	return document
+}
+
+// Handler for GET /openapi.json
+func (config *Config) OpenAPIHandler(ctx context.Context, request Request) (Response, error) {
+	return jsonResponse(200, openAPIDocument(routeTable()))
+}
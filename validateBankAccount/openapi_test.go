@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_openAPIDocument(t *testing.T) {
+	document := openAPIDocument(routeTable())
+	sla := document.Paths["/providers/{name}/sla"]["get"]
+	wantParameters := []OpenAPIParameter{{Name: "name", In: "path", Required: true, Schema: map[string]string{"type": "string"}}}
+	if !reflect.DeepEqual(sla.Parameters, wantParameters) {
+		t.Errorf("sla parameters = %+v, want %+v", sla.Parameters, wantParameters)
+	}
+	application := document.Paths["/application"]
+	if _, exists := application["post"]; !exists {
+		t.Errorf("/application has no post")
+	}
+	if _, exists := application["get"]; exists {
+		t.Errorf("/application has a get, account numbers don't go in the URL")
+	}
+	// Every route is documented
+	operations := 0
+	for _, methods := range document.Paths {
+		operations += len(methods)
+	}
+	if operations != len(routeTable()) {
+		t.Errorf("%d operations documented for %d routes", operations, len(routeTable()))
+	}
+}
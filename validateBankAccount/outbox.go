@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+/*
+  Outbox (OUTBOX_TABLE). What a validation sends on, AccountValidityChanged on the bus and webhook deliveries, is
+  otherwise sent once from the container and lost if that fails. With an outbox those events are instead written to
+  the outbox table in a TransactWriteItems with the validation's audit record, so both are stored or neither is
+  (records without events are still batched), and only deleted once they've been delivered:
+
+    id (S, hash key) | kind (S, event or webhook) | target (S, the detail type or the webhook's name) | body (S) |
+    createdAt (S, RFC3339Nano) | attempts (N) | nextAttemptAt (N, unix seconds)
+
+  Right after the write the container sends what it wrote, so the usual case is as quick as without an outbox.
+  Anything that fails, or that a container never got to, is left for the dispatcher (MODE=outbox, every minute),
+  which sends whatever is due. It claims each event before sending it by bumping attempts and pushing nextAttemptAt
+  back by the same backoff as webhooks, so two dispatchers don't both take it and one that dies mid send leaves it to
+  be tried again. Delivery is at least once, a subscriber can see an event twice.
+
+  Bus events are retried until they go through, the OutboxRetries metric says when that's happening. Webhook
+  deliveries give up after the webhook's maxAttempts of the dispatcher's and go to the dead letter queue, and ones for
+  a webhook that's since been removed are dropped. If the audit write itself fails its events are sent directly, as
+  they would be without an outbox.
+
+  Results published by the SNS trigger (snstrigger.go) don't need one: a failed publish fails the invocation and SNS
+  tries it again.
+*/
+
+const (
+	OutboxKindEvent   = "event"
+	OutboxKindWebhook = "webhook"
+	// How long the container that wrote an event has to send it before the dispatcher will
+	outboxGrace = time.Minute
+	// Most events one dispatcher run takes, and how many it sends at once
+	maxOutboxDispatch = 500
+	outboxSenders     = 10
+)
+
+type OutboxEvent struct {
+	ID   string
+	Kind string
+	// The event's detail type, or the webhook's name
+	Target        string
+	Body          string
+	CreatedAt     time.Time
+	Attempts      int
+	NextAttemptAt time.Time
+}
+
+// Events are written by the AuditSink, alongside their audit records
+type OutboxStore interface {
+	// Up to limit events that are due by now
+	DueEvents(ctx context.Context, now time.Time, limit int) ([]OutboxEvent, error)
+	// Takes the event for an attempt and puts its next one off until retryAt. False if it was taken or delivered since
+	// it was read.
+	ClaimEvent(ctx context.Context, event OutboxEvent, retryAt time.Time) (bool, error)
+	DeleteEvent(ctx context.Context, id string) error
+}
+
+type outboxDispatcher struct {
+	store OutboxStore
+	now   func() time.Time
+}
+
+// The target doesn't exist any more, there's no point trying again
+var errUndeliverable = errors.New("undeliverable")
+
+type outboxKey struct{}
+
+// The events a validation has emitted, until they're put on its audit record
+type pendingEvents struct {
+	mu     sync.Mutex
+	events []OutboxEvent
+}
+
+// Collects what the validation emits for the outbox, when there is one
+func (config *Config) withOutbox(ctx context.Context) context.Context {
+	if config.outbox == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, outboxKey{}, &pendingEvents{})
+}
+
+func outboxFrom(ctx context.Context) *pendingEvents {
+	pending, _ := ctx.Value(outboxKey{}).(*pendingEvents)
+	return pending
+}
+
+func (pending *pendingEvents) add(event OutboxEvent) {
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+	pending.events = append(pending.events, event)
+}
+
+// Everything emitted so far, stamped with when it was written. A nil pendingEvents has nothing.
+func (pending *pendingEvents) take(now time.Time) []OutboxEvent {
+	if pending == nil {
+		return nil
+	}
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+	events := pending.events
+	pending.events = nil
+	for i := range events {
+		events[i].CreatedAt = now
+		events[i].NextAttemptAt = now.Add(outboxGrace)
+	}
+	return events
+}
+
+// Puts an event on the bus, through the outbox when the validation has one
+func (config *Config) emit(ctx context.Context, detailType string, detail interface{}) {
+	if config.events == nil {
+		return
+	}
+	if pending := outboxFrom(ctx); pending != nil {
+		body, err := marshalJSON(detail)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		pending.add(OutboxEvent{ID: newCallID(), Kind: OutboxKindEvent, Target: detailType, Body: string(body)})
+		return
+	}
+	if err := config.events.PutEvent(ctx, detailType, detail); err != nil {
+		log.Print(err)
+	}
+}
+
+func (config *Config) webhook(name string) *WebhookConfig {
+	for _, webhook := range config.Webhooks {
+		if webhook.Name == name {
+			return webhook
+		}
+	}
+	return nil
+}
+
+func (config *Config) sendOutboxEvent(ctx context.Context, event OutboxEvent) error {
+	switch event.Kind {
+	case OutboxKindEvent:
+		if config.events == nil {
+			return errors.New("there's no event bus")
+		}
+		return config.events.PutEvent(ctx, event.Target, json.RawMessage(event.Body))
+	case OutboxKindWebhook:
+		webhook := config.webhook(event.Target)
+		if webhook == nil || config.webhookDispatcher == nil {
+			return fmt.Errorf("webhook %s: %w", event.Target, errUndeliverable)
+		}
+		return config.webhookDispatcher.send(ctx, &webhookDelivery{webhook: webhook, id: event.ID, body: []byte(event.Body)})
+	}
+	return fmt.Errorf("outbox event kind %s: %w", event.Kind, errUndeliverable)
+}
+
+// Sends the events, a few at a time, calling done with how each went
+func (config *Config) sendOutboxEvents(ctx context.Context, events []OutboxEvent, done func(event OutboxEvent, err error)) {
+	var wg sync.WaitGroup
+	senders := make(chan struct{}, outboxSenders)
+	for _, event := range events {
+		wg.Add(1)
+		senders <- struct{}{}
+		go func(event OutboxEvent) {
+			defer wg.Done()
+			defer func() { <-senders }()
+			done(event, config.sendOutboxEvent(ctx, event))
+		}(event)
+	}
+	wg.Wait()
+}
+
+// Called by the telemetry once records have been written. Their events are sent and deleted from the outbox, what
+// fails is left to the dispatcher, unless the write failed and there's nothing to leave it in.
+func (config *Config) sendWritten(ctx context.Context, records []AuditRecord, writeErr error) {
+	events := []OutboxEvent{}
+	for _, record := range records {
+		events = append(events, record.Events...)
+	}
+	config.sendOutboxEvents(ctx, events, func(event OutboxEvent, err error) {
+		switch {
+		case writeErr != nil && err != nil:
+			log.Printf("dropped %s %s %s, it isn't in the outbox: %v", event.Kind, event.Target, event.ID, err)
+		case writeErr != nil:
+		case err == nil || errors.Is(err, errUndeliverable):
+			if err := config.outbox.store.DeleteEvent(ctx, event.ID); err != nil {
+				log.Printf("unable to delete %s from the outbox, it'll be sent again: %v", event.ID, err)
+			}
+		default:
+			log.Printf("unable to send %s %s %s, leaving it to the outbox dispatcher: %v", event.Kind, event.Target, event.ID, err)
+		}
+	})
+}
+
+// Sends what's due, giving up on webhook deliveries that have run out of attempts
+func (config *Config) dispatchOutbox(ctx context.Context) error {
+	now := config.outbox.now()
+	due, err := config.outbox.store.DueEvents(ctx, now, maxOutboxDispatch)
+	if err != nil {
+		return err
+	}
+	claimed := []OutboxEvent{}
+	for _, event := range due {
+		taken, err := config.outbox.store.ClaimEvent(ctx, event, now.Add(retryDelay(event.Attempts+1)))
+		if err != nil {
+			log.Printf("unable to claim %s: %v", event.ID, err)
+			continue
+		}
+		if taken {
+			event.Attempts++
+			claimed = append(claimed, event)
+		}
+	}
+	config.sendOutboxEvents(ctx, claimed, func(event OutboxEvent, err error) {
+		if err != nil && !errors.Is(err, errUndeliverable) {
+			webhook := config.webhook(event.Target)
+			if event.Kind != OutboxKindWebhook || event.Attempts < webhook.maxAttempts() {
+				config.telemetry.count("OutboxRetries", 1)
+				log.Printf("unable to send %s %s %s, attempt %d: %v", event.Kind, event.Target, event.ID, event.Attempts, err)
+				return
+			}
+			config.webhookDispatcher.deadLetter(ctx, &webhookDelivery{webhook: webhook, id: event.ID, body: []byte(event.Body), attempts: event.Attempts, lastErr: err.Error()})
+		} else if err != nil {
+			log.Printf("dropped %s %s %s: %v", event.Kind, event.Target, event.ID, err)
+		}
+		if err := config.outbox.store.DeleteEvent(ctx, event.ID); err != nil {
+			log.Printf("unable to delete %s from the outbox, it'll be sent again: %v", event.ID, err)
+		}
+	})
+	return nil
+}
+
+// Lambda handler for the dispatcher's schedule
+func (config *Config) OutboxHandler(ctx context.Context, event events.CloudWatchEvent) error {
+	if config.outbox == nil {
+		return errors.New("ENVVAR OUTBOX_TABLE and AUDIT_TABLE are required for the outbox dispatcher")
+	}
+	return config.dispatchOutbox(ctx)
+}
+
+type dynamoOutboxAPI interface {
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+type dynamoOutboxStore struct {
+	client dynamoOutboxAPI
+	table  string
+}
+
+func outboxItem(event OutboxEvent) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"id":            &types.AttributeValueMemberS{Value: event.ID},
+		"kind":          &types.AttributeValueMemberS{Value: event.Kind},
+		"target":        &types.AttributeValueMemberS{Value: event.Target},
+		"body":          &types.AttributeValueMemberS{Value: event.Body},
+		"createdAt":     &types.AttributeValueMemberS{Value: event.CreatedAt.UTC().Format(time.RFC3339Nano)},
+		"attempts":      &types.AttributeValueMemberN{Value: strconv.Itoa(event.Attempts)},
+		"nextAttemptAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(event.NextAttemptAt.Unix(), 10)},
+	}
+}
+
+func outboxEventFromItem(item map[string]types.AttributeValue) OutboxEvent {
+	event := OutboxEvent{}
+	if v, ok := item["id"].(*types.AttributeValueMemberS); ok {
+		event.ID = v.Value
+	}
+	if v, ok := item["kind"].(*types.AttributeValueMemberS); ok {
+		event.Kind = v.Value
+	}
+	if v, ok := item["target"].(*types.AttributeValueMemberS); ok {
+		event.Target = v.Value
+	}
+	if v, ok := item["body"].(*types.AttributeValueMemberS); ok {
+		event.Body = v.Value
+	}
+	if v, ok := item["createdAt"].(*types.AttributeValueMemberS); ok {
+		event.CreatedAt, _ = time.Parse(time.RFC3339Nano, v.Value)
+	}
+	if v, ok := item["attempts"].(*types.AttributeValueMemberN); ok {
+		event.Attempts, _ = strconv.Atoi(v.Value)
+	}
+	if v, ok := item["nextAttemptAt"].(*types.AttributeValueMemberN); ok {
+		seconds, _ := strconv.ParseInt(v.Value, 10, 64)
+		event.NextAttemptAt = time.Unix(seconds, 0)
+	}
+	return event
+}
+
+// Delivered events are deleted, so the table only holds what's in flight and a scan is fine
+func (store *dynamoOutboxStore) DueEvents(ctx context.Context, now time.Time, limit int) ([]OutboxEvent, error) {
+	due := []OutboxEvent{}
+	paginator := dynamodb.NewScanPaginator(store.client, &dynamodb.ScanInput{
+		TableName:                 aws.String(store.table),
+		FilterExpression:          aws.String("nextAttemptAt <= :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)}},
+	})
+	for paginator.HasMorePages() && len(due) < limit {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			if len(due) < limit {
+				due = append(due, outboxEventFromItem(item))
+			}
+		}
+	}
+	return due, nil
+}
+
+func (store *dynamoOutboxStore) ClaimEvent(ctx context.Context, event OutboxEvent, retryAt time.Time) (bool, error) {
+	_, err := store.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(store.table),
+		Key:                 map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: event.ID}},
+		UpdateExpression:    aws.String("SET attempts = :attempts, nextAttemptAt = :retryAt"),
+		ConditionExpression: aws.String("attempts = :seen"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":attempts": &types.AttributeValueMemberN{Value: strconv.Itoa(event.Attempts + 1)},
+			":retryAt":  &types.AttributeValueMemberN{Value: strconv.FormatInt(retryAt.Unix(), 10)},
+			":seen":     &types.AttributeValueMemberN{Value: strconv.Itoa(event.Attempts)},
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (store *dynamoOutboxStore) DeleteEvent(ctx context.Context, id string) error {
+	_, err := store.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(store.table),
+		Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+	})
+	return err
+}
+
+// Writes events to the outbox with the audit records, which needs both tables
+func (config *Config) setupOutbox(ctx context.Context) {
+	table, exists := os.LookupEnv("OUTBOX_TABLE")
+	if !exists {
+		return
+	}
+	if config.telemetry == nil {
+		return
+	}
+	var sink *dynamoAuditSink
+	if parts, ok := config.store.(*storeParts); ok {
+		sink, _ = parts.AuditSink.(*dynamoAuditSink)
+	}
+	if sink == nil {
+		log.Print("the outbox is written with the audit records, it needs AUDIT_TABLE")
+		return
+	}
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		log.Printf("unable to connect to the outbox, sending events directly: %v", err)
+		return
+	}
+	sink.outboxTable = table
+	config.outbox = &outboxDispatcher{store: &dynamoOutboxStore{client: dynamodb.NewFromConfig(cfg), table: table}, now: time.Now}
+	config.telemetry.written = config.sendWritten
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The audit table and the outbox, written together
+type fakeOutbox struct {
+	mu     sync.Mutex
+	events map[string]OutboxEvent
+}
+
+func (outbox *fakeOutbox) WriteAudit(ctx context.Context, records []AuditRecord) error {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	for _, record := range records {
+		for _, event := range record.Events {
+			outbox.events[event.ID] = event
+		}
+	}
+	return nil
+}
+
+func (outbox *fakeOutbox) DueEvents(ctx context.Context, now time.Time, limit int) ([]OutboxEvent, error) {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	due := []OutboxEvent{}
+	for _, event := range outbox.events {
+		if !event.NextAttemptAt.After(now) && len(due) < limit {
+			due = append(due, event)
+		}
+	}
+	return due, nil
+}
+
+func (outbox *fakeOutbox) ClaimEvent(ctx context.Context, event OutboxEvent, retryAt time.Time) (bool, error) {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	current, exists := outbox.events[event.ID]
+	if !exists || current.Attempts != event.Attempts {
+		return false, nil
+	}
+	current.Attempts++
+	current.NextAttemptAt = retryAt
+	outbox.events[event.ID] = current
+	return true, nil
+}
+
+func (outbox *fakeOutbox) DeleteEvent(ctx context.Context, id string) error {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	delete(outbox.events, id)
+	return nil
+}
+
+func (outbox *fakeOutbox) pending() []OutboxEvent {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	events := []OutboxEvent{}
+	for _, event := range outbox.events {
+		events = append(events, event)
+	}
+	return events
+}
+
+type flakyBus struct {
+	mu      sync.Mutex
+	failing bool
+	details []string
+}
+
+func (bus *flakyBus) PutEvent(ctx context.Context, detailType string, detail interface{}) error {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if bus.failing {
+		return errors.New("throttled")
+	}
+	data, _ := json.Marshal(detail)
+	bus.details = append(bus.details, detailType+" "+string(data))
+	return nil
+}
+
+func TestConfig_dispatchOutbox(t *testing.T) {
+	outbox := &fakeOutbox{events: map[string]OutboxEvent{}}
+	bus := &flakyBus{}
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		verdicts:  &fakeVerdicts{verdicts: map[string]Verdict{}},
+		events:    bus,
+		telemetry: newTelemetry(outbox, io.Discard),
+		outbox:    &outboxDispatcher{store: outbox, now: func() time.Time { return now }},
+	}
+	config.telemetry.now = func() time.Time { return now }
+	config.telemetry.written = config.sendWritten
+	change := func(accountNumber string) {
+		config.verdicts.(*fakeVerdicts).verdicts[verdictKey(accountHash(accountNumber), nil, config.Providers).String()] = Verdict{Result: ResultStatusInvalid}
+		config.validate(context.Background(), Request{}, &BankAccountValidationRequest{AccountNumber: &accountNumber}, nil)
+		config.telemetry.flush(context.Background())
+	}
+
+	// Sent straight after the write, and gone from the outbox
+	change("12345670")
+	if len(bus.details) != 1 || len(outbox.pending()) != 0 {
+		t.Fatalf("sent %v leaving %v, want it sent and deleted", bus.details, outbox.pending())
+	}
+
+	// One that can't be sent is left for the dispatcher, which waits for the container to have had its chance
+	bus.failing = true
+	change("12345672")
+	if len(outbox.pending()) != 1 {
+		t.Fatalf("left %v in the outbox, want the event", outbox.pending())
+	}
+	if err := config.dispatchOutbox(context.Background()); err != nil || outbox.pending()[0].Attempts != 0 {
+		t.Errorf("dispatchOutbox() in the grace period = %v, %v", err, outbox.pending())
+	}
+	now = now.Add(outboxGrace)
+	config.dispatchOutbox(context.Background())
+	if pending := outbox.pending(); len(pending) != 1 || pending[0].Attempts != 1 || !pending[0].NextAttemptAt.Equal(now.Add(time.Second)) {
+		t.Errorf("after a failed dispatch left %v, want a retry in a second", pending)
+	}
+
+	// Bus events keep going until they're delivered
+	bus.failing = false
+	now = now.Add(time.Second)
+	config.dispatchOutbox(context.Background())
+	if len(bus.details) != 2 || len(outbox.pending()) != 0 {
+		t.Errorf("after dispatching sent %v leaving %v", bus.details, outbox.pending())
+	}
+	var event AccountValidityChangedEvent
+	if err := json.Unmarshal(bytes.TrimPrefix([]byte(bus.details[1]), []byte(AccountValidityChanged+" ")), &event); err != nil || event.AccountHash != accountHash("12345672") || event.PreviousResult != ResultStatusInvalid {
+		t.Errorf("dispatched %s, %v", bus.details[1], err)
+	}
+}
+
+func TestConfig_dispatchOutbox_webhooks(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	outbox := &fakeOutbox{events: map[string]OutboxEvent{}}
+	dlq := &recordingDLQ{}
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		Webhooks: []*WebhookConfig{
+			{Name: "ledger", URL: server.URL, MaxAttempts: 1, Signing: &SigningConfig{Primary: &SigningKey{ID: "ledger-2024", Secret: "shh"}}},
+		},
+		webhookDispatcher: newWebhookDispatcher(),
+		telemetry:         newTelemetry(outbox, io.Discard),
+		outbox:            &outboxDispatcher{store: outbox, now: func() time.Time { return now }},
+	}
+	config.webhookDispatcher.dlq, config.webhookDispatcher.dlqURL = dlq, "https://sqs.eu-west-1.amazonaws.com/123456789012/webhooks-dlq"
+	config.telemetry.now = func() time.Time { return now }
+	config.telemetry.written = config.sendWritten
+
+	// Deliveries go through the outbox rather than the webhook queue
+	accountNumber := "12345670"
+	config.validate(context.Background(), Request{}, &BankAccountValidationRequest{AccountNumber: &accountNumber}, nil)
+	config.telemetry.flush(context.Background())
+	if calls != 1 || len(config.webhookDispatcher.queue) != 0 || len(outbox.pending()) != 1 {
+		t.Fatalf("%d calls, %d queued, %v in the outbox, want 1 call and the delivery in the outbox", calls, len(config.webhookDispatcher.queue), outbox.pending())
+	}
+
+	// The dispatcher's attempt is its last
+	now = now.Add(outboxGrace)
+	config.dispatchOutbox(context.Background())
+	if calls != 2 || len(outbox.pending()) != 0 || len(dlq.messages) != 1 {
+		t.Errorf("%d calls, %v in the outbox, dead lettered %v", calls, outbox.pending(), dlq.messages)
+	}
+
+	// Deliveries for a webhook that's gone are dropped
+	outbox.events["gone"] = OutboxEvent{ID: "gone", Kind: OutboxKindWebhook, Target: "removed", NextAttemptAt: now}
+	config.dispatchOutbox(context.Background())
+	if len(outbox.pending()) != 0 || calls != 2 {
+		t.Errorf("kept %v after %d calls", outbox.pending(), calls)
+	}
+}
+
+// The audit and outbox tables. Batches always leave their last item unprocessed, transactions fail when told to.
+type fakeAuditTables struct {
+	items         map[string][]map[string]types.AttributeValue
+	transactFails bool
+}
+
+func (db *fakeAuditTables) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	output := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]types.WriteRequest{}}
+	for table, requests := range params.RequestItems {
+		last := len(requests) - 1
+		for _, request := range requests[:last] {
+			db.items[table] = append(db.items[table], request.PutRequest.Item)
+		}
+		output.UnprocessedItems[table] = requests[last:]
+	}
+	return output, nil
+}
+
+func (db *fakeAuditTables) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	if db.transactFails {
+		return nil, &types.TransactionCanceledException{}
+	}
+	for _, item := range params.TransactItems {
+		db.items[*item.Put.TableName] = append(db.items[*item.Put.TableName], item.Put.Item)
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func Test_dynamoAuditSink_WriteAudit_outbox(t *testing.T) {
+	records := []AuditRecord{
+		{AccountHash: "a", Events: []OutboxEvent{{ID: "1", Kind: OutboxKindEvent}, {ID: "2", Kind: OutboxKindWebhook}}},
+		{AccountHash: "b", Events: []OutboxEvent{{ID: "3", Kind: OutboxKindEvent}}},
+	}
+	db := &fakeAuditTables{items: map[string][]map[string]types.AttributeValue{}}
+	if err := (&dynamoAuditSink{client: db, table: "audit", outboxTable: "outbox"}).WriteAudit(context.Background(), records); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing went in a batch, which would have left one of them behind
+	if len(db.items["audit"]) != 2 || len(db.items["outbox"]) != 3 {
+		t.Errorf("wrote %d records with %d events, want 2 with 3", len(db.items["audit"]), len(db.items["outbox"]))
+	}
+
+	db = &fakeAuditTables{items: map[string][]map[string]types.AttributeValue{}, transactFails: true}
+	if err := (&dynamoAuditSink{client: db, table: "audit", outboxTable: "outbox"}).WriteAudit(context.Background(), records); err == nil {
+		t.Error("WriteAudit() should fail when the transaction does")
+	}
+	if len(db.items["audit"]) != 0 || len(db.items["outbox"]) != 0 {
+		t.Errorf("wrote %d records with %d events, want neither", len(db.items["audit"]), len(db.items["outbox"]))
+	}
+
+	// Records without events are still batched
+	db = &fakeAuditTables{items: map[string][]map[string]types.AttributeValue{}}
+	if err := (&dynamoAuditSink{client: db, table: "audit", outboxTable: "outbox"}).WriteAudit(context.Background(), []AuditRecord{{AccountHash: "a"}, {AccountHash: "b"}}); err == nil || len(db.items["audit"]) != 1 {
+		t.Errorf("WriteAudit() = %v with %d records written, want the unprocessed one to fail it", err, len(db.items["audit"]))
+	}
+}
+
+type fakeOutboxDynamoDB struct {
+	dynamoOutboxAPI
+	attempts string
+}
+
+func (db *fakeOutboxDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if params.ExpressionAttributeValues[":seen"].(*types.AttributeValueMemberN).Value != db.attempts {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	db.attempts = params.ExpressionAttributeValues[":attempts"].(*types.AttributeValueMemberN).Value
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func Test_dynamoOutboxStore_ClaimEvent(t *testing.T) {
+	store := &dynamoOutboxStore{client: &fakeOutboxDynamoDB{attempts: "0"}, table: "outbox"}
+	event := OutboxEvent{ID: "1"}
+	if claimed, err := store.ClaimEvent(context.Background(), event, time.Now()); !claimed || err != nil {
+		t.Errorf("ClaimEvent() = %v, %v, want it claimed", claimed, err)
+	}
+	// Someone else got there first
+	if claimed, err := store.ClaimEvent(context.Background(), event, time.Now()); claimed || err != nil {
+		t.Errorf("ClaimEvent() a second time = %v, %v, want it not claimed", claimed, err)
+	}
+}
+
+func Test_outboxItem(t *testing.T) {
+	event := OutboxEvent{ID: "1", Kind: OutboxKindEvent, Target: AccountValidityChanged, Body: "{}", CreatedAt: time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC), Attempts: 2, NextAttemptAt: time.Unix(1717232460, 0)}
+	if got := outboxEventFromItem(outboxItem(event)); !got.CreatedAt.Equal(event.CreatedAt) || !got.NextAttemptAt.Equal(event.NextAttemptAt) {
+		t.Errorf("outboxEventFromItem() = %+v, want %+v", got, event)
+	} else if got.CreatedAt, got.NextAttemptAt = event.CreatedAt, event.NextAttemptAt; got != event {
+		t.Errorf("outboxEventFromItem() = %+v, want %+v", got, event)
+	}
+}
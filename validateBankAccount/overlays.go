@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+/*
+  Environment overlays. The config holds the defaults once, plus an environments section with just what's different
+  in each environment, and the one named by ENVIRONMENT (set to the stage by serverless.yml) is merged over the
+  defaults at load:
+
+    providers:
+    - name: provider1
+      url: https://sandbox.provider1.com/validate
+      timeoutMs: 800
+    - name: provider2
+      url: https://sandbox.provider2.com/validate
+    environments:
+      prod:
+        providers:
+        - name: provider1
+          url: https://provider1.com/validate
+          signing:
+            primary: {id: prod, secretId: accountvalidator/provider1}
+        circuitBreaker:
+          failureThreshold: 10
+
+  Maps are merged key by key, anything else in the overlay replaces the default. Lists of entries with a name, like
+  providers, are merged entry by entry on the name, so prod above keeps provider2 and provider1's timeoutMs and only
+  changes provider1's url and signing. A name that isn't in the defaults is added. Works the same with version 2
+  provider blocks. Without ENVIRONMENT, or for an environment that isn't listed, the defaults are used as they are.
+*/
+
+const environmentsKey = "environments"
+
+// Merges the environment's overlay into the config yaml and drops the environments section
+func applyOverlay(data []byte, environment string) ([]byte, error) {
+	var document yaml.MapSlice
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	base := yaml.MapSlice{}
+	var overlay yaml.MapSlice
+	found := false
+	for _, item := range document {
+		if item.Key != environmentsKey {
+			base = append(base, item)
+			continue
+		}
+		found = true
+		environments, ok := item.Value.(yaml.MapSlice)
+		if !ok && item.Value != nil {
+			return nil, fmt.Errorf("%s should map environment names to overrides", environmentsKey)
+		}
+		for _, entry := range environments {
+			if entry.Key != environment || environment == "" {
+				continue
+			}
+			if overlay, ok = entry.Value.(yaml.MapSlice); !ok && entry.Value != nil {
+				return nil, fmt.Errorf("%s.%s should be a map of overrides", environmentsKey, environment)
+			}
+		}
+	}
+	if !found {
+		return data, nil
+	}
+	return yaml.Marshal(mergeMaps(base, overlay))
+}
+
+func mergeMaps(base, overlay yaml.MapSlice) yaml.MapSlice {
+	merged := append(yaml.MapSlice{}, base...)
+	for _, item := range overlay {
+		i := indexOf(merged, item.Key)
+		if i < 0 {
+			merged = append(merged, item)
+			continue
+		}
+		merged[i].Value = mergeValues(merged[i].Value, item.Value)
+	}
+	return merged
+}
+
+func mergeValues(base, overlay interface{}) interface{} {
+	if baseMap, ok := base.(yaml.MapSlice); ok {
+		if overlayMap, ok := overlay.(yaml.MapSlice); ok {
+			return mergeMaps(baseMap, overlayMap)
+		}
+	}
+	if baseList, ok := base.([]interface{}); ok {
+		if overlayList, ok := overlay.([]interface{}); ok && named(baseList) && named(overlayList) {
+			return mergeNamed(baseList, overlayList)
+		}
+	}
+	return overlay
+}
+
+// Merges lists of named entries on their names, in the order of the base with new names at the end
+func mergeNamed(base, overlay []interface{}) []interface{} {
+	merged := append([]interface{}{}, base...)
+	for _, entry := range overlay {
+		name := entryName(entry)
+		i := 0
+		for i < len(merged) && entryName(merged[i]) != name {
+			i++
+		}
+		if i == len(merged) {
+			merged = append(merged, entry)
+			continue
+		}
+		merged[i] = mergeMaps(merged[i].(yaml.MapSlice), entry.(yaml.MapSlice))
+	}
+	return merged
+}
+
+// Whether every entry in the list is a map with a name
+func named(list []interface{}) bool {
+	if len(list) == 0 {
+		return false
+	}
+	for _, entry := range list {
+		if entryName(entry) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func entryName(entry interface{}) interface{} {
+	fields, ok := entry.(yaml.MapSlice)
+	if !ok {
+		return nil
+	}
+	if i := indexOf(fields, "name"); i >= 0 {
+		return fields[i].Value
+	}
+	return nil
+}
+
+func indexOf(fields yaml.MapSlice, key interface{}) int {
+	for i, item := range fields {
+		if item.Key == key {
+			return i
+		}
+	}
+	return -1
+}
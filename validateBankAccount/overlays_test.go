@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testOverlayConfig = `
+providers:
+- name: provider1
+  url: https://sandbox.provider1.com/validate
+  timeoutMs: 800
+- name: provider2
+  url: https://sandbox.provider2.com/validate
+circuitBreaker:
+  failureThreshold: 5
+  cooldownSeconds: 30
+testAccounts:
+- accountNumber: "00000001"
+  isValid: true
+environments:
+  prod:
+    providers:
+    - name: provider1
+      url: https://provider1.com/validate
+    - name: provider3
+      url: https://provider3.com/validate
+    circuitBreaker:
+      failureThreshold: 10
+    testAccounts: []
+`
+
+func Test_applyOverlay(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment string
+		want        []Provider
+		wantBreaker BreakerConfig
+		wantTests   int
+	}{
+		{
+			name: "noEnvironment",
+			want: []Provider{
+				{Name: "provider1", URL: "https://sandbox.provider1.com/validate", TimeoutMs: 800},
+				{Name: "provider2", URL: "https://sandbox.provider2.com/validate"},
+			},
+			wantBreaker: BreakerConfig{FailureThreshold: 5, CooldownSeconds: 30},
+			wantTests:   1,
+		},
+		{
+			name:        "unlisted",
+			environment: "staging",
+			want: []Provider{
+				{Name: "provider1", URL: "https://sandbox.provider1.com/validate", TimeoutMs: 800},
+				{Name: "provider2", URL: "https://sandbox.provider2.com/validate"},
+			},
+			wantBreaker: BreakerConfig{FailureThreshold: 5, CooldownSeconds: 30},
+			wantTests:   1,
+		},
+		{
+			name:        "prod",
+			environment: "prod",
+			want: []Provider{
+				{Name: "provider1", URL: "https://provider1.com/validate", TimeoutMs: 800},
+				{Name: "provider2", URL: "https://sandbox.provider2.com/validate"},
+				{Name: "provider3", URL: "https://provider3.com/validate"},
+			},
+			wantBreaker: BreakerConfig{FailureThreshold: 10, CooldownSeconds: 30},
+			wantTests:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := applyOverlay([]byte(testOverlayConfig), tt.environment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := parseConfig(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config.Providers, tt.want) {
+				t.Errorf("providers = %+v, want %+v", config.Providers, tt.want)
+			}
+			if config.CircuitBreaker != tt.wantBreaker || len(config.TestAccounts) != tt.wantTests {
+				t.Errorf("circuitBreaker = %+v with %d test accounts, want %+v with %d", config.CircuitBreaker, len(config.TestAccounts), tt.wantBreaker, tt.wantTests)
+			}
+		})
+	}
+}
+
+func Test_applyOverlay_version2(t *testing.T) {
+	base := "version: 2\nproviders:\n- name: provider1\n  endpoints: [https://sandbox]\n  health: {url: https://sandbox/health}\n" +
+		"environments:\n  prod:\n    providers:\n    - name: provider1\n      endpoints: [https://prod]\n"
+	data, err := applyOverlay([]byte(base), "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := parseConfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.Providers[0]; got.URL != "https://prod" || got.HealthURL != "https://sandbox/health" {
+		t.Errorf("provider = %+v, want the prod url and the default health url", got)
+	}
+}
+
+func Test_applyOverlay_errors(t *testing.T) {
+	for _, yaml := range []string{"environments: [prod]", "environments:\n  prod: [a]"} {
+		if _, err := applyOverlay([]byte(yaml), "prod"); err == nil {
+			t.Errorf("applyOverlay(%q) should fail", yaml)
+		}
+	}
+	// Without an environments section the yaml is left alone, empty included
+	if data, err := applyOverlay([]byte(""), "prod"); err != nil || len(data) != 0 {
+		t.Errorf("applyOverlay() = %q, %v", data, err)
+	}
+}
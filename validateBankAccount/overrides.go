@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+/*
+  Per-provider overrides from environment variables, for emergency cutovers without editing the whole PROVIDERS
+  blob. They're applied at load, after any environment overlay, so changing one in the Lambda console takes effect
+  on the next cold start:
+
+    PROVIDER_PROVIDER1_URL=https://dr.provider1.com/validate   # replaces the url, and any extra endpoints
+    PROVIDER_PROVIDER1_TIMEOUT_MS=2000
+    PROVIDER_PROVIDER2_ENABLED=false                            # never called until it's set back
+
+  The name is the provider's name upper cased, with anything that isn't a letter or a digit turned into an
+  underscore, so bureau-uk is PROVIDER_BUREAU_UK_URL. A value that doesn't parse fails the load rather than being
+  ignored, we'd rather know an override didn't take.
+
+  A provider can also be switched off in the config with enabled: false. A disabled provider is still known, so
+  lanes can still name it, but it isn't called, isn't listed in the capabilities and is dropped from requests that
+  ask for it. On call can also switch one off at runtime, see toggles.go.
+*/
+
+// The PROVIDER_<NAME>_ prefix for a provider's overrides
+func overridePrefix(name string) string {
+	var prefix strings.Builder
+	prefix.WriteString("PROVIDER_")
+	for _, r := range strings.ToUpper(name) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			prefix.WriteRune(r)
+		} else {
+			prefix.WriteByte('_')
+		}
+	}
+	prefix.WriteByte('_')
+	return prefix.String()
+}
+
+// Applies any overrides lookup has for the providers, lookup is os.LookupEnv outside of tests
+func (config *Config) applyOverrides(lookup func(string) (string, bool)) error {
+	for i := range config.Providers {
+		provider := &config.Providers[i]
+		prefix := overridePrefix(provider.Name)
+		if url, exists := lookup(prefix + "URL"); exists {
+			log.Printf("provider %s url overridden to %s", provider.Name, url)
+			provider.URL = url
+			provider.Endpoints = nil
+		}
+		if value, exists := lookup(prefix + "TIMEOUT_MS"); exists {
+			timeout, err := strconv.Atoi(value)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("%sTIMEOUT_MS should be a positive number of milliseconds, got %q", prefix, value)
+			}
+			log.Printf("provider %s timeout overridden to %dms", provider.Name, timeout)
+			provider.TimeoutMs = timeout
+		}
+		if value, exists := lookup(prefix + "ENABLED"); exists {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%sENABLED should be true or false, got %q", prefix, value)
+			}
+			log.Printf("provider %s enabled overridden to %v", provider.Name, enabled)
+			provider.Enabled = &enabled
+		}
+	}
+	return nil
+}
+
+// Off in the config or toggled off at runtime, see toggles.go
+func (provider Provider) disabled() bool {
+	return provider.disabledFor(context.Background())
+}
+
+// Off for the request, which sees toggles that are still on canary when it's on the canary, see configcanary.go
+func (provider Provider) disabledFor(ctx context.Context) bool {
+	return (provider.Enabled != nil && !*provider.Enabled) || toggledOff(ctx, provider.Name)
+}
+
+// The providers that aren't disabled, the same slice when none are
+func enabledProviders(providers []Provider) []Provider {
+	return enabledProvidersFor(context.Background(), providers)
+}
+
+func enabledProvidersFor(ctx context.Context, providers []Provider) []Provider {
+	for i, provider := range providers {
+		if !provider.disabledFor(ctx) {
+			continue
+		}
+		enabled := append([]Provider{}, providers[:i]...)
+		for _, provider := range providers[i+1:] {
+			if !provider.disabledFor(ctx) {
+				enabled = append(enabled, provider)
+			}
+		}
+		return enabled
+	}
+	return providers
+}
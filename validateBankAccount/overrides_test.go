@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func Test_overridePrefix(t *testing.T) {
+	for name, want := range map[string]string{"provider1": "PROVIDER_PROVIDER1_", "bureau-uk": "PROVIDER_BUREAU_UK_", "Acme Ltd.": "PROVIDER_ACME_LTD__"} {
+		if got := overridePrefix(name); got != want {
+			t.Errorf("overridePrefix(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestConfig_applyOverrides(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    []Provider
+		wantErr bool
+	}{
+		{
+			name: "none",
+			want: []Provider{
+				{Name: "provider1", URL: "https://provider1.com", Endpoints: []string{"https://us.provider1.com"}},
+				{Name: "bureau-uk", URL: "https://bureau.com"},
+			},
+		},
+		{
+			name: "cutover",
+			env: map[string]string{
+				"PROVIDER_PROVIDER1_URL":        "https://dr.provider1.com",
+				"PROVIDER_PROVIDER1_TIMEOUT_MS": "2500",
+				"PROVIDER_BUREAU_UK_ENABLED":    "false",
+			},
+			want: []Provider{
+				{Name: "provider1", URL: "https://dr.provider1.com", TimeoutMs: 2500},
+				{Name: "bureau-uk", URL: "https://bureau.com", Enabled: &disabled},
+			},
+		},
+		{name: "badTimeout", env: map[string]string{"PROVIDER_PROVIDER1_TIMEOUT_MS": "2s"}, wantErr: true},
+		{name: "badEnabled", env: map[string]string{"PROVIDER_BUREAU_UK_ENABLED": "nope"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Providers: []Provider{
+				{Name: "provider1", URL: "https://provider1.com", Endpoints: []string{"https://us.provider1.com"}},
+				{Name: "bureau-uk", URL: "https://bureau.com"},
+			}}
+			err := config.applyOverrides(func(name string) (string, bool) {
+				value, exists := tt.env[name]
+				return value, exists
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyOverrides() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(config.Providers, tt.want) {
+				t.Errorf("providers = %+v, want %+v", config.Providers, tt.want)
+			}
+		})
+	}
+}
+
+func Test_providersToCall_disabled(t *testing.T) {
+	disabled := false
+	providers := []Provider{{Name: "provider1"}, {Name: "provider2", Enabled: &disabled}, {Name: "provider3"}}
+	if got := providerNames(providersToCall(context.Background(), providers, nil)); !reflect.DeepEqual(got, []string{"provider1", "provider3"}) {
+		t.Errorf("providersToCall() = %v", got)
+	}
+	if got := providerNames(providersToCall(context.Background(), providers, &[]string{"provider2", "provider3"})); !reflect.DeepEqual(got, []string{"provider3"}) {
+		t.Errorf("providersToCall() with a filter = %v", got)
+	}
+}
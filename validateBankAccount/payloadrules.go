@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+  Payload rules. Bodies that are obviously junk are turned away before we try to parse them, so they never get near
+  a provider or fill the logs with parse errors:
+
+    payloadRules:
+      rules: [script, sql, longString, invalidUtf8]   # any of the built in rules, none by default
+      maxStringLength: 1024                           # for longString, default 1024
+      patterns:                                       # more rules, a regexp each
+        shellCommand: '(?i)\$\(|`'
+
+    script        html script tags and javascript: urls
+    sql           the usual injection fragments, union select, drop table, ' or 1=1 and friends
+    longString    a json string longer than maxStringLength, no account number or provider name is that long
+    invalidUtf8   a body that isn't utf-8
+
+  A rejected request gets a 400 {"error": "request rejected"}, the rule that caught it goes in the log and the
+  PayloadRejected<Rule> metric, and it counts towards the caller's schema_violation_flood (see security.go). Batch
+  bodies are streamed so they're only checked when they come through API Gateway.
+*/
+
+const (
+	PayloadRuleScript      = "script"
+	PayloadRuleSQL         = "sql"
+	PayloadRuleLongString  = "longString"
+	PayloadRuleInvalidUTF8 = "invalidUtf8"
+)
+
+const defaultMaxStringLength = 1024
+
+var (
+	scriptPattern = regexp.MustCompile(`(?i)<\s*/?\s*script|javascript\s*:|\bon(error|load)\s*=`)
+	sqlPattern    = regexp.MustCompile(`(?i)\bunion\s+(all\s+)?select\b|\bdrop\s+table\b|\binsert\s+into\b|\bdelete\s+from\b|` +
+		`'\s*or\s+'?\d+'?\s*=\s*'?\d+|;\s*--|\bxp_cmdshell\b|\bsleep\s*\(\s*\d+\s*\)`)
+)
+
+type PayloadRulesConfig struct {
+	Rules           []string          `yaml:"rules"`
+	MaxStringLength int               `yaml:"maxStringLength"`
+	Patterns        map[string]string `yaml:"patterns"`
+}
+
+// A compiled rule, true when the body breaks it
+type payloadRule struct {
+	name   string
+	breaks func(body string) bool
+}
+
+// Compiles the rules once at load, none when nothing is configured
+func (rules PayloadRulesConfig) compile() ([]payloadRule, error) {
+	if rules.MaxStringLength < 0 {
+		return nil, fmt.Errorf("payloadRules maxStringLength can't be negative")
+	}
+	maxLength := rules.MaxStringLength
+	if maxLength == 0 {
+		maxLength = defaultMaxStringLength
+	}
+	compiled := []payloadRule{}
+	for _, name := range rules.Rules {
+		switch name {
+		case PayloadRuleScript:
+			compiled = append(compiled, payloadRule{name, scriptPattern.MatchString})
+		case PayloadRuleSQL:
+			compiled = append(compiled, payloadRule{name, sqlPattern.MatchString})
+		case PayloadRuleLongString:
+			compiled = append(compiled, payloadRule{name, func(body string) bool { return longestString(body) > maxLength }})
+		case PayloadRuleInvalidUTF8:
+			compiled = append(compiled, payloadRule{name, func(body string) bool { return !utf8.ValidString(body) }})
+		default:
+			return nil, fmt.Errorf("unknown payload rule %s", name)
+		}
+	}
+	// Sorted so the rules run in the same order every time
+	names := make([]string, 0, len(rules.Patterns))
+	for name := range rules.Patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pattern, err := regexp.Compile(rules.Patterns[name])
+		if err != nil {
+			return nil, fmt.Errorf("payload rule %s has an invalid pattern: %w", name, err)
+		}
+		compiled = append(compiled, payloadRule{name, pattern.MatchString})
+	}
+	if len(compiled) == 0 {
+		return nil, nil
+	}
+	return compiled, nil
+}
+
+// The length of the longest json string in the body, found without parsing it
+func longestString(body string) int {
+	longest, start, inString, escaped := 0, 0, false, false
+	for i := 0; i < len(body); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && body[i] == '\\':
+			escaped = true
+		case body[i] == '"' && inString:
+			inString = false
+			if i-start > longest {
+				longest = i - start
+			}
+		case body[i] == '"':
+			inString, start = true, i+1
+		}
+	}
+	// An unterminated string runs to the end
+	if inString && len(body)-start > longest {
+		longest = len(body) - start
+	}
+	return longest
+}
+
+// The first rule the body breaks
+func (config *Config) brokenPayloadRule(body string) (string, bool) {
+	for _, rule := range config.payloadRules {
+		if rule.breaks(body) {
+			return rule.name, true
+		}
+	}
+	return "", false
+}
+
+// The 400 for a body that breaks a rule, nil when it can go ahead
+func (config *Config) inspectPayload(ctx context.Context, request Request) *Response {
+	if len(config.payloadRules) == 0 || request.Body == "" {
+		return nil
+	}
+	rule, broken := config.brokenPayloadRule(request.Body)
+	if !broken {
+		return nil
+	}
+	log.Printf("payload rejected by rule %s", rule)
+	config.telemetry.count("PayloadRejected"+strings.ToUpper(rule[:1])+rule[1:], 1)
+	config.security.schemaViolation(ctx, request)
+	response, _ := jsonResponse(http.StatusBadRequest, map[string]string{"error": "request rejected"})
+	return &response
+}
+
+// The payload rules for the streaming modes. Batch bodies are left alone, they can be far too big to hold.
+func (config *Config) payloadInspected(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(config.payloadRules) == 0 || isBatchRequest(r.Method, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		request, err := toRequest(r)
+		if err != nil {
+			writeResponse(w, *handleError(err, "unable to read request"))
+			return
+		}
+		if response := config.inspectPayload(r.Context(), request); response != nil {
+			writeResponse(w, *response)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader([]byte(request.Body)))
+		next.ServeHTTP(w, r)
+	})
+}
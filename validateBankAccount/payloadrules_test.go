@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_longestString(t *testing.T) {
+	tests := []struct {
+		body string
+		want int
+	}{
+		{"{\"accountNumber\": \"12345678\"}", 13},
+		{"{\"a\": \"say \\\"hi\\\"\"}", 10},
+		{"{\"a\": \"unterminated", 12},
+		{"[]", 0},
+	}
+	for _, tt := range tests {
+		if got := longestString(tt.body); got != tt.want {
+			t.Errorf("longestString(%s) = %d, want %d", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestConfig_brokenPayloadRule(t *testing.T) {
+	rules, err := PayloadRulesConfig{
+		Rules:           []string{PayloadRuleScript, PayloadRuleSQL, PayloadRuleLongString, PayloadRuleInvalidUTF8},
+		MaxStringLength: 32,
+		Patterns:        map[string]string{"shellCommand": "\\$\\("},
+	}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{payloadRules: rules}
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"clean", "{\"accountNumber\": \"12345678\", \"clientReference\": \"O'Brien or 2\"}", ""},
+		{"script", "{\"accountNumber\": \"<SCRIPT>alert(1)</script>\"}", PayloadRuleScript},
+		{"javascriptURL", "{\"clientReference\": \"javascript:alert(1)\"}", PayloadRuleScript},
+		{"unionSelect", "{\"accountNumber\": \"1 UNION SELECT password FROM users\"}", PayloadRuleSQL},
+		{"orOneEqualsOne", "{\"accountNumber\": \"' or 1=1\"}", PayloadRuleSQL},
+		{"long", "{\"accountNumber\": \"" + strings.Repeat("1", 33) + "\"}", PayloadRuleLongString},
+		{"invalidUtf8", "{\"accountNumber\": \"\xff\xfe\"}", PayloadRuleInvalidUTF8},
+		{"pattern", "{\"accountNumber\": \"$(reboot)\"}", "shellCommand"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := config.brokenPayloadRule(tt.body); got != tt.want {
+				t.Errorf("brokenPayloadRule() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPayloadRulesConfig_compile(t *testing.T) {
+	if rules, err := (PayloadRulesConfig{}).compile(); rules != nil || err != nil {
+		t.Errorf("compile() = %v, %v, want no rules", rules, err)
+	}
+	if _, err := (PayloadRulesConfig{Rules: []string{"xss"}}).compile(); err == nil {
+		t.Error("compile() should reject an unknown rule")
+	}
+	if _, err := (PayloadRulesConfig{Patterns: map[string]string{"bad": "("}}).compile(); err == nil {
+		t.Error("compile() should reject an invalid pattern")
+	}
+}
+
+func TestConfig_Router_payloadRules(t *testing.T) {
+	rules, _ := PayloadRulesConfig{Rules: []string{PayloadRuleScript}}.compile()
+	sink := &fakeAuditSink{}
+	config := &Config{
+		Providers:    []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		payloadRules: rules,
+		telemetry:    newTelemetry(sink, nil),
+	}
+	response, err := config.Router(context.Background(), Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"<script>\"}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 400 || response.Body != "{\"error\":\"request rejected\"}" {
+		t.Errorf("Router() = %d %s, want a 400", response.StatusCode, response.Body)
+	}
+	if got := config.telemetry.counts["PayloadRejectedScript"]; got != 1 {
+		t.Errorf("PayloadRejectedScript = %v, want 1", got)
+	}
+}
+
+func TestConfig_streamingHandler_payloadRules(t *testing.T) {
+	rules, _ := PayloadRulesConfig{Rules: []string{PayloadRuleSQL}}.compile()
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}, payloadRules: rules}
+	server := httptest.NewServer(config.streamingHandler())
+	defer server.Close()
+
+	for body, want := range map[string]int{
+		"{\"accountNumber\": \"1; DROP TABLE accounts\"}": 400,
+		"{\"accountNumber\": \"12345670\"}":               200,
+	} {
+		response, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != want {
+			t.Errorf("%s: status = %d, want %d", body, response.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/*
+  Scheduled provider probe. Runs every minute (MODE=probe), hits each provider's healthUrl and writes the outcome to
+  the status table. When a validation container cold starts it reads the table and opens the breaker for anything
+  the probe saw down recently, rather than learning about the outage by timing out on real requests.
+*/
+
+// How old a probe result can be before a cold container ignores it
+const probeStaleAfter = 3 * time.Minute
+
+func probeProvider(ctx context.Context, provider Provider) ProviderStatus {
+	status := ProviderStatus{Provider: provider.Name, CheckedAt: time.Now()}
+	client := http.Client{Timeout: providerTimeout, Transport: providerTransport}
+	request, err := http.NewRequestWithContext(ctx, "GET", provider.HealthURL, nil)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	provider.setHeaders(request)
+	response, err := client.Do(request)
+	status.LatencyMs = time.Since(status.CheckedAt).Milliseconds()
+	if err != nil {
+		status.Error = requestError(err)
+		return status
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		status.Error = response.Status
+		return status
+	}
+	status.Up = true
+	return status
+}
+
+// Lambda handler for the CloudWatch schedule
+func (config *Config) ProbeHandler(ctx context.Context, event events.CloudWatchEvent) error {
+	if config.statusStore == nil {
+		return errors.New("ENVVAR STATUS_TABLE is required to probe providers")
+	}
+	statuses := make(chan ProviderStatus)
+	var wg sync.WaitGroup
+	for _, provider := range config.Providers {
+		if provider.HealthURL == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(provider Provider) {
+			defer wg.Done()
+			statuses <- probeProvider(ctx, provider)
+		}(provider)
+	}
+	go func() {
+		wg.Wait()
+		close(statuses)
+	}()
+
+	var failed error
+	for status := range statuses {
+		if !status.Up {
+			log.Printf("provider %s is down: %s", status.Provider, status.Error)
+		}
+		if err := config.statusStore.PutStatus(ctx, status); err != nil {
+			log.Print(err)
+			failed = err
+		}
+	}
+	return failed
+}
+
+// Opens the breaker for providers the probe saw down recently
+func (config *Config) seedBreakers(ctx context.Context) error {
+	if config.statusStore == nil {
+		return nil
+	}
+	statuses, err := config.statusStore.Statuses(ctx)
+	if err != nil {
+		return err
+	}
+	down := map[string]ProviderStatus{}
+	for _, status := range statuses {
+		if !status.Up && time.Since(status.CheckedAt) < probeStaleAfter {
+			down[status.Provider] = status
+		}
+	}
+	for _, provider := range config.Providers {
+		if status, exists := down[provider.Name]; exists {
+			log.Printf("provider %s was down at %s, starting with its breaker open", provider.Name, status.CheckedAt)
+			provider.breaker.openSince(status.CheckedAt)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type memoryStatusStore struct {
+	mu       sync.Mutex
+	statuses map[string]ProviderStatus
+}
+
+func (store *memoryStatusStore) PutStatus(ctx context.Context, status ProviderStatus) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.statuses == nil {
+		store.statuses = map[string]ProviderStatus{}
+	}
+	store.statuses[status.Provider] = status
+	return nil
+}
+
+func (store *memoryStatusStore) Statuses(ctx context.Context) ([]ProviderStatus, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	statuses := []ProviderStatus{}
+	for _, status := range store.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Provider < statuses[j].Provider })
+	return statuses, nil
+}
+
+func TestConfig_ProbeHandler(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer unhealthy.Close()
+
+	store := &memoryStatusStore{}
+	config := &Config{
+		Providers: []Provider{
+			{Name: "provider1", HealthURL: healthy.URL},
+			{Name: "provider2", HealthURL: unhealthy.URL},
+			{Name: "provider3"},
+		},
+		statusStore: store,
+	}
+	if err := config.ProbeHandler(context.Background(), events.CloudWatchEvent{}); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, _ := store.Statuses(context.Background())
+	if len(statuses) != 2 {
+		t.Fatalf("expected providers without a healthUrl to be skipped, got %v", statuses)
+	}
+	if !statuses[0].Up || statuses[0].Provider != "provider1" {
+		t.Errorf("provider1 should be up, got %v", statuses[0])
+	}
+	if statuses[1].Up || statuses[1].Error != "503 Service Unavailable" {
+		t.Errorf("provider2 should be down, got %v", statuses[1])
+	}
+}
+
+func TestConfig_ProbeHandler_noStore(t *testing.T) {
+	config := &Config{}
+	if err := config.ProbeHandler(context.Background(), events.CloudWatchEvent{}); err == nil {
+		t.Errorf("ProbeHandler() should fail without a status store")
+	}
+}
+
+func TestConfig_seedBreakers(t *testing.T) {
+	store := &memoryStatusStore{}
+	store.PutStatus(context.Background(), ProviderStatus{Provider: "down", CheckedAt: time.Now()})
+	store.PutStatus(context.Background(), ProviderStatus{Provider: "stale", CheckedAt: time.Now().Add(-time.Hour)})
+	store.PutStatus(context.Background(), ProviderStatus{Provider: "up", Up: true, CheckedAt: time.Now()})
+
+	config := &Config{
+		Providers:   []Provider{{Name: "down"}, {Name: "stale"}, {Name: "up"}},
+		statusStore: store,
+	}
+	config.setupProviders()
+	if err := config.seedBreakers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, provider := range config.Providers {
+		want := provider.Name != "down"
+		if got := provider.breaker.allow(); got != want {
+			t.Errorf("%s breaker allow() = %v, want %v", provider.Name, got, want)
+		}
+	}
+}
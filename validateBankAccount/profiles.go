@@ -0,0 +1,145 @@
+package main
+
+import (
+	"mime"
+	"strings"
+	"time"
+)
+
+/*
+  Response profiles. Existing clients get the original {"result": [...]} shape. New clients can ask for the v2
+  envelope with
+
+    Accept: application/json; profile=v2
+
+  which gives each provider an explicit status and adds a summary, so nobody has to infer "errored" from isValid
+  being false:
+
+    {"version": "v2", "results": [{"provider": "provider1", "status": "valid"}, {"provider": "provider2", "status": "error", "error": "timeout"}],
+     "summary": {"called": 2, "answered": 1, "valid": 1}}
+
+  Anything we don't recognise gets v1.
+*/
+
+const (
+	ProfileV1 = "v1"
+	ProfileV2 = "v2"
+)
+
+// Provider statuses in the v2 profile
+const (
+	ResultStatusValid   = "valid"
+	ResultStatusInvalid = "invalid"
+	ResultStatusError   = "error"
+)
+
+type ValidationResponseV2 struct {
+	Version            string             `json:"version"`
+	Results            []ProviderResultV2 `json:"results"`
+	Summary            ResultSummary      `json:"summary"`
+	Aggregate          *AggregateResult   `json:"aggregate,omitempty"`
+	Metadata           *ResponseMetadata  `json:"metadata,omitempty"`
+	PreviousResult     string             `json:"previousResult,omitempty"`
+	ChangedAt          *time.Time         `json:"changedAt,omitempty"`
+	ClientReference    string             `json:"clientReference,omitempty"`
+	Warnings           []string           `json:"warnings,omitempty"`
+	EstimatedCost      *CostEstimate      `json:"estimatedCost,omitempty"`
+	Signature          *ResponseSignature `json:"signature,omitempty"`
+	Receipt            string             `json:"receipt,omitempty"`
+	NoProvidersMatched bool               `json:"noProvidersMatched,omitempty"`
+	UnknownProviders   []string           `json:"unknownProviders,omitempty"`
+	Partial            bool               `json:"partial,omitempty"`
+}
+
+type ProviderResultV2 struct {
+	Provider  string `json:"provider"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Local     bool   `json:"local,omitempty"`
+	CallID    string `json:"callId,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	RawReason string `json:"rawReason,omitempty"`
+}
+
+type ResultSummary struct {
+	Called   int `json:"called"`
+	Answered int `json:"answered"`
+	Valid    int `json:"valid"`
+}
+
+// Picks the profile out of the Accept header, API Gateway doesn't normalise header case
+func responseProfile(headers map[string]string) string {
+	for _, accepted := range strings.Split(requestHeader(headers, "Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if (mediaType == "application/json" || mediaType == "*/*") && params["profile"] == ProfileV2 {
+			return ProfileV2
+		}
+	}
+	return ProfileV1
+}
+
+func toV2(response BankAccountValidationResponse) ValidationResponseV2 {
+	v2 := ValidationResponseV2{
+		Version:            ProfileV2,
+		Results:            make([]ProviderResultV2, 0, len(response.Result)),
+		Summary:            ResultSummary{},
+		Aggregate:          response.Aggregate,
+		Metadata:           response.Metadata,
+		PreviousResult:     response.PreviousResult,
+		ChangedAt:          response.ChangedAt,
+		ClientReference:    response.ClientReference,
+		Warnings:           response.Warnings,
+		EstimatedCost:      response.EstimatedCost,
+		Signature:          response.Signature,
+		Receipt:            response.Receipt,
+		NoProvidersMatched: response.NoProvidersMatched,
+		UnknownProviders:   response.UnknownProviders,
+		Partial:            response.Partial,
+	}
+	for _, result := range response.Result {
+		v2Result := ProviderResultV2{
+			Provider:  result.Provider,
+			Status:    ResultStatusInvalid,
+			Local:     result.Local,
+			CallID:    result.CallID,
+			Reason:    result.Reason,
+			RawReason: result.RawReason,
+		}
+		switch {
+		case result.Error != "":
+			v2Result.Status = ResultStatusError
+			v2Result.Error = result.Error
+		case result.IsValid:
+			v2Result.Status = ResultStatusValid
+		}
+		v2.Results = append(v2.Results, v2Result)
+		// The summary is about the providers
+		if result.Local {
+			continue
+		}
+		v2.Summary.Called++
+		if result.Error == "" {
+			v2.Summary.Answered++
+		}
+		if v2Result.Status == ResultStatusValid {
+			v2.Summary.Valid++
+		}
+	}
+	return v2
+}
+
+// Serialises the response in the requested profile, returning the body and its content type
+func marshalProfile(profile string, response BankAccountValidationResponse) (string, string, error) {
+	if profile != ProfileV2 {
+		body, err := marshalResponse(response)
+		return body, "application/json", err
+	}
+	body, err := encodeJSON(toV2(response))
+	if err != nil {
+		return "", "", err
+	}
+	return body, "application/json; profile=v2", nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func Test_responseProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "none", headers: nil, want: ProfileV1},
+		{name: "plainJson", headers: map[string]string{"Accept": "application/json"}, want: ProfileV1},
+		{name: "v2", headers: map[string]string{"Accept": "application/json; profile=v2"}, want: ProfileV2},
+		{name: "lowercaseHeader", headers: map[string]string{"accept": "application/json;profile=v2"}, want: ProfileV2},
+		{name: "quoted", headers: map[string]string{"Accept": "application/json; profile=\"v2\""}, want: ProfileV2},
+		{name: "list", headers: map[string]string{"Accept": "text/html, application/json; profile=v2"}, want: ProfileV2},
+		{name: "unknownProfile", headers: map[string]string{"Accept": "application/json; profile=v9"}, want: ProfileV1},
+		{name: "otherType", headers: map[string]string{"Accept": "text/xml; profile=v2"}, want: ProfileV1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := responseProfile(tt.headers); got != tt.want {
+				t.Errorf("responseProfile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_toV2(t *testing.T) {
+	response := BankAccountValidationResponse{Result: []BankAccountValidationResult{
+		{Provider: "provider1", IsValid: true},
+		{Provider: "provider2", IsValid: false},
+		{Provider: "provider3", Error: ProviderErrorTimeout},
+	}}
+	want := ValidationResponseV2{
+		Version: ProfileV2,
+		Results: []ProviderResultV2{
+			{Provider: "provider1", Status: ResultStatusValid},
+			{Provider: "provider2", Status: ResultStatusInvalid},
+			{Provider: "provider3", Status: ResultStatusError, Error: ProviderErrorTimeout},
+		},
+		Summary: ResultSummary{Called: 3, Answered: 2, Valid: 1},
+	}
+	if got := toV2(response); !reflect.DeepEqual(got, want) {
+		t.Errorf("toV2() = %+v, want %+v", got, want)
+	}
+}
+
+func TestConfig_Handler_profiles(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}}
+	tests := []struct {
+		name            string
+		accept          string
+		wantContentType string
+		wantBody        string
+	}{
+		{name: "v1",
+			wantContentType: "application/json",
+			wantBody:        "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}]}",
+		},
+		{name: "v2",
+			accept:          "application/json; profile=v2",
+			wantContentType: "application/json; profile=v2",
+			wantBody:        "{\"version\":\"v2\",\"results\":[{\"provider\":\"provider1\",\"status\":\"valid\"}],\"summary\":{\"called\":1,\"answered\":1,\"valid\":1}}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := Request{Body: "{\"accountNumber\": \"12345670\"}", Headers: map[string]string{"Accept": tt.accept}}
+			got, err := config.Handler(context.Background(), request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Headers["Content-Type"] != tt.wantContentType || got.Body != tt.wantBody {
+				t.Errorf("Handler() = %v %v, want %v %v", got.Headers["Content-Type"], got.Body, tt.wantContentType, tt.wantBody)
+			}
+		})
+	}
+}
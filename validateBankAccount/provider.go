@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/*
+  Per provider request templates and response extraction. Partners don't all speak {"accountNumber": ...} /
+  {"isValid": ...}, so each provider can say how to build its request body and where to find the verdict in
+  what comes back:
+
+    - name: provider3
+      url: https://provider3.com/check
+      requestTemplate: '{"account": {"number": {{json .AccountNumber}}}}'
+      validField: data.status
+      validValues: ["VALID", "OPEN"]
+*/
+
+// Provider types, an empty type means http. Anything else is an adapter's, see adapters.go.
+const (
+	ProviderTypeHTTP      = "http"
+	ProviderTypeSimulated = "simulated"
+	ProviderTypeSEPA      = "sepa"
+)
+
+const defaultValidField = "isValid"
+
+// What a request template gets to work with
+type TemplateData struct {
+	AccountNumber string
+	AccountType   string
+	Currency      string
+}
+
+var templateFuncs = template.FuncMap{
+	// Always use json to put values in the template so they are quoted and escaped properly
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parseRequestTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// Parses templates once at config load so a bad one is caught before any traffic arrives
+func (config *Config) compile() error {
+	if err := config.validateVersion(); err != nil {
+		return err
+	}
+	if err := config.validateTLS(); err != nil {
+		return err
+	}
+	if err := config.Tracing.validate(); err != nil {
+		return err
+	}
+	if err := config.AccountHashing.validate(); err != nil {
+		return err
+	}
+	if err := config.Priority.validate(config.Providers); err != nil {
+		return err
+	}
+	if err := config.Shedding.validate(); err != nil {
+		return err
+	}
+	if err := config.Masking.validate(); err != nil {
+		return err
+	}
+	if err := config.Security.validate(); err != nil {
+		return err
+	}
+	if err := config.ResponseSigning.validate(); err != nil {
+		return err
+	}
+	if err := config.Receipts.validate(); err != nil {
+		return err
+	}
+	if err := config.Feedback.validate(); err != nil {
+		return err
+	}
+	if err := validateNoProviders(config.NoProviders); err != nil {
+		return err
+	}
+	if err := config.FanOut.validate(); err != nil {
+		return err
+	}
+	if err := config.Capture.validate(); err != nil {
+		return err
+	}
+	if err := config.BodyLogging.validate(); err != nil {
+		return err
+	}
+	if err := config.LocalChecks.validate(); err != nil {
+		return err
+	}
+	if err := config.Init.validate(); err != nil {
+		return err
+	}
+	if err := config.Admin.validate(); err != nil {
+		return err
+	}
+	if err := config.ConfigCanary.validate(); err != nil {
+		return err
+	}
+	if err := config.RateLimit.validate(); err != nil {
+		return err
+	}
+	if err := config.Quotas.validate(); err != nil {
+		return err
+	}
+	if err := validateWebhooks(config.Webhooks); err != nil {
+		return err
+	}
+	if config.MinProviders < 0 {
+		return fmt.Errorf("minProviders can't be negative")
+	}
+	allowlist, err := parseAllowlist(config.Security.Allowlist)
+	if err != nil {
+		return err
+	}
+	config.allowlist = allowlist
+	if config.Security.RequestSignatures != nil {
+		config.replays = newReplayCache()
+	}
+	if config.payloadRules, err = config.PayloadRules.compile(); err != nil {
+		return err
+	}
+	if config.admission, err = compileAdmission(config.Admission); err != nil {
+		return err
+	}
+	if err := validateProviderNames(config.Providers); err != nil {
+		return err
+	}
+	if err := validateGroups(config.Groups, config.Providers); err != nil {
+		return err
+	}
+	if err := config.Authorization.validate(config.Providers); err != nil {
+		return err
+	}
+	if config.responseFields, err = compileResponseFilters(config.ResponseFilters); err != nil {
+		return err
+	}
+	if config.responseTemplates, err = compileResponseTemplates(config.ResponseTemplates); err != nil {
+		return err
+	}
+	if config.UsageReport.Location != "" {
+		if _, err := parseS3Location(config.UsageReport.Location); err != nil {
+			return fmt.Errorf("usageReport location: %w", err)
+		}
+	}
+	if config.FeedbackExport.Location != "" {
+		if _, err := parseS3Location(config.FeedbackExport.Location); err != nil {
+			return fmt.Errorf("feedbackExport location: %w", err)
+		}
+	}
+	config.weights = config.providerWeights()
+	for i := range config.Providers {
+		provider := &config.Providers[i]
+		switch provider.Type {
+		case "", ProviderTypeHTTP, ProviderTypeSimulated:
+		case ProviderTypeSEPA:
+			if err := provider.SEPA.validate(); err != nil {
+				return fmt.Errorf("provider %s: %w", provider.Name, err)
+			}
+		default:
+			adapter, exists := adapterFor(provider.Type)
+			if !exists {
+				return fmt.Errorf("provider %s has unknown type %s", provider.Name, provider.Type)
+			}
+			if err := adapter.validate(*provider); err != nil {
+				return err
+			}
+		}
+		if err := provider.validateHeaders(); err != nil {
+			return err
+		}
+		if err := provider.validateCallIDHeader(); err != nil {
+			return err
+		}
+		if err := provider.validateLimits(); err != nil {
+			return err
+		}
+		if err := provider.validateReasons(); err != nil {
+			return err
+		}
+		if provider.UnitCost < 0 {
+			return fmt.Errorf("provider %s unitCost can't be negative", provider.Name)
+		}
+		if err := provider.SLA.validate(); err != nil {
+			return fmt.Errorf("provider %s: %w", provider.Name, err)
+		}
+		if err := provider.validateAccountSupport(); err != nil {
+			return err
+		}
+		if err := provider.Signing.validate(); err != nil {
+			return fmt.Errorf("provider %s: %w", provider.Name, err)
+		}
+		if err := provider.Rollout.validate(); err != nil {
+			return fmt.Errorf("provider %s: %w", provider.Name, err)
+		}
+		if provider.script, err = provider.Script.compile(); err != nil {
+			return fmt.Errorf("provider %s: %w", provider.Name, err)
+		}
+		if provider.RequestTemplate == "" {
+			continue
+		}
+		tmpl, err := parseRequestTemplate(provider.Name, provider.RequestTemplate)
+		if err != nil {
+			return fmt.Errorf("provider %s has an invalid requestTemplate: %w", provider.Name, err)
+		}
+		provider.template = tmpl
+	}
+	return nil
+}
+
+// Builds the body we POST to the provider
+func (provider Provider) requestBody(data TemplateData) ([]byte, error) {
+	if provider.RequestTemplate == "" {
+		return json.Marshal(provider.defaultRequest(data))
+	}
+	tmpl := provider.template
+	if tmpl == nil {
+		var err error
+		if tmpl, err = parseRequestTemplate(provider.Name, provider.RequestTemplate); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("requestTemplate for %s did not produce valid json", provider.Name)
+	}
+	return buf.Bytes(), nil
+}
+
+// Pulls the verdict out of the provider's response body
+func (provider Provider) extractValidity(body []byte) (bool, error) {
+	var parsed interface{}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return false, err
+	}
+	field := provider.ValidField
+	if field == "" {
+		field = defaultValidField
+	}
+	value, err := lookupField(parsed, field)
+	if err != nil {
+		return false, err
+	}
+	switch typed := value.(type) {
+	case bool:
+		return typed, nil
+	case string:
+		for _, validValue := range provider.ValidValues {
+			if strings.EqualFold(typed, validValue) {
+				return true, nil
+			}
+		}
+		if len(provider.ValidValues) == 0 {
+			return false, fmt.Errorf("field %s is a string but no validValues are configured", field)
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("field %s is %T, expected a boolean or string", field, value)
+}
+
+// Walks a dot separated path (data.result.valid) through decoded json
+func lookupField(document interface{}, path string) (interface{}, error) {
+	current := document
+	for _, key := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("field %s not found in response", path)
+		}
+		if current, ok = object[key]; !ok {
+			return nil, fmt.Errorf("field %s not found in response", path)
+		}
+	}
+	return current, nil
+}
+
+// Gives every provider its own runtime state, which lives as long as the container
+func (config *Config) setupProviders() {
+	config.Priority.setup()
+	for i := range config.Providers {
+		config.Providers[i].breaker = newCircuitBreaker(config.CircuitBreaker)
+		config.Providers[i].breaker.provider = config.Providers[i].Name
+		config.Providers[i].stats = newProviderStats(config.Alerting.window())
+		if config.Providers[i].Rollout != nil {
+			config.Providers[i].rollout = newRolloutStats(config.Alerting.window())
+		}
+		if urls := config.Providers[i].endpointURLs(); len(urls) > 1 {
+			config.Providers[i].endpoints = newEndpointSelector(urls, time.Duration(config.Providers[i].ReprobeSeconds)*time.Second)
+		}
+	}
+}
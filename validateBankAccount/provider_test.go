@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProvider_requestBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		provider      Provider
+		accountNumber string
+		want          string
+		wantErr       bool
+	}{
+		{name: "default",
+			provider:      Provider{Name: "provider1"},
+			accountNumber: "12345678",
+			want:          "{\"accountNumber\":\"12345678\"}",
+		},
+		{name: "template",
+			provider:      Provider{Name: "provider3", RequestTemplate: "{\"account\": {\"number\": {{json .AccountNumber}}}}"},
+			accountNumber: "12345678",
+			want:          "{\"account\": {\"number\": \"12345678\"}}",
+		},
+		{name: "templateEscapes",
+			provider:      Provider{Name: "provider3", RequestTemplate: "{\"account\": {{json .AccountNumber}}}"},
+			accountNumber: "1234\"5678",
+			want:          "{\"account\": \"1234\\\"5678\"}",
+		},
+		{name: "templateNotJson",
+			provider:      Provider{Name: "provider3", RequestTemplate: "{\"account\": {{.AccountNumber}}x}"},
+			accountNumber: "12345678",
+			wantErr:       true,
+		},
+		{name: "templateUnknownField",
+			provider:      Provider{Name: "provider3", RequestTemplate: "{\"account\": {{json .SortCode}}}"},
+			accountNumber: "12345678",
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.provider.requestBody(TemplateData{AccountNumber: tt.accountNumber})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("requestBody() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("requestBody() = %v, want %v", string(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestProvider_extractValidity(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		body     string
+		want     bool
+		wantErr  bool
+	}{
+		{name: "default", provider: Provider{}, body: "{\"isValid\": true}", want: true},
+		{name: "defaultFalse", provider: Provider{}, body: "{\"isValid\": false}", want: false},
+		{name: "missing", provider: Provider{}, body: "{\"valid\": true}", wantErr: true},
+		{name: "null", provider: Provider{}, body: "null", wantErr: true},
+		{name: "notJson", provider: Provider{}, body: "<html>", wantErr: true},
+		{name: "nested", provider: Provider{ValidField: "data.result.valid"}, body: "{\"data\": {\"result\": {\"valid\": true}}}", want: true},
+		{name: "validValues", provider: Provider{ValidField: "status", ValidValues: []string{"VALID", "OPEN"}}, body: "{\"status\": \"open\"}", want: true},
+		{name: "validValuesNoMatch", provider: Provider{ValidField: "status", ValidValues: []string{"VALID"}}, body: "{\"status\": \"CLOSED\"}", want: false},
+		{name: "stringWithoutValidValues", provider: Provider{ValidField: "status"}, body: "{\"status\": \"VALID\"}", wantErr: true},
+		{name: "wrongType", provider: Provider{}, body: "{\"isValid\": 1}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.provider.extractValidity([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractValidity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("extractValidity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_compile(t *testing.T) {
+	config := &Config{Providers: []Provider{
+		{Name: "provider1"},
+		{Name: "provider2", RequestTemplate: "{\"account\": {{json .AccountNumber}}}"},
+	}}
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if config.Providers[0].template != nil || config.Providers[1].template == nil {
+		t.Errorf("compile() should only compile providers with a template")
+	}
+
+	config = &Config{Providers: []Provider{{Name: "broken", RequestTemplate: "{{json .AccountNumber"}}}
+	if err := config.compile(); err == nil {
+		t.Errorf("compile() should fail for an unparseable template")
+	}
+
+	config = &Config{Providers: []Provider{{Name: "sim", Type: ProviderTypeSimulated}, {Name: "soap", Type: "soap"}}}
+	if err := config.compile(); err == nil {
+		t.Errorf("compile() should fail for an unknown provider type")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+/*
+  Provider names and aliases. Every provider needs its own name, a config that defines one twice fails to load
+  rather than one quietly replacing the other. A provider can also answer to other names, for when it's renamed or a
+  client team knows it by something else:
+
+    - name: bureau-uk
+      aliases: [provider1, ukbureau]
+
+  A request can use the name or any alias and the results always use the name. Names are matched ignoring case and
+  surrounding spaces, since client teams send "Provider1", "provider1" and " provider1 " and all mean the same one,
+  so two providers can't have names that only differ in case.
+
+  Providers can also be picked by tag or by a glob on their names and aliases, so callers don't hard code names that
+  change between environments. Tags come from the config:
+
+    - name: bureau-uk
+      tags: [uk, cheap]
+
+    {"accountNumber": "12345678", "providers": ["tag:uk", "bureau-*"]}
+
+  Each entry adds the providers it matches, in config order, so ["tag:uk", "tag:cheap"] is every provider that's
+  either. Asking for the same provider more than once calls it once. Entries that don't match any provider are
+  skipped, and the response says so instead of leaving the caller to wonder why there's no result for them:
+
+    {"result": [...], "warnings": ["unknown provider provider9", "no provider matches tag:fr"]}
+*/
+
+const tagPrefix = "tag:"
+
+// What a name is matched on
+func providerKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Every name and alias, by providerKey, to the index of the provider it means
+func providerIndex(providers []Provider) map[string]int {
+	index := map[string]int{}
+	for i, provider := range providers {
+		index[providerKey(provider.Name)] = i
+		for _, alias := range provider.Aliases {
+			index[providerKey(alias)] = i
+		}
+	}
+	return index
+}
+
+// Indexes of the providers a filter entry picks, a name or alias, tag:<tag> or a glob
+func selectProviders(providers []Provider, index map[string]int, selector string) []int {
+	key := providerKey(selector)
+	if tag, isTag := strings.CutPrefix(key, tagPrefix); isTag {
+		selected := []int{}
+		for i, provider := range providers {
+			for _, providerTag := range provider.Tags {
+				if providerKey(providerTag) == strings.TrimSpace(tag) {
+					selected = append(selected, i)
+					break
+				}
+			}
+		}
+		return selected
+	}
+	if strings.ContainsAny(key, "*?[") {
+		selected := []int{}
+		for i, provider := range providers {
+			for _, name := range append([]string{provider.Name}, provider.Aliases...) {
+				// A malformed pattern matches nothing
+				if matched, _ := path.Match(key, providerKey(name)); matched {
+					selected = append(selected, i)
+					break
+				}
+			}
+		}
+		return selected
+	}
+	if i, exists := index[key]; exists {
+		return []int{i}
+	}
+	return nil
+}
+
+// The warning for a filter entry that matched nothing
+func selectorWarning(selector string) string {
+	key := providerKey(selector)
+	if strings.HasPrefix(key, tagPrefix) || strings.ContainsAny(key, "*?[") {
+		return fmt.Sprintf("no provider matches %s", selector)
+	}
+	return fmt.Sprintf("unknown provider %s", selector)
+}
+
+// Every tag the providers have, lower cased and sorted
+func providerTags(providers []Provider) []string {
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, provider := range providers {
+		for _, tag := range provider.Tags {
+			if tag = providerKey(tag); !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Fails if a name or alias means more than one provider
+func validateProviderNames(providers []Provider) error {
+	owners := map[string]string{}
+	for _, provider := range providers {
+		if owner, exists := owners[providerKey(provider.Name)]; exists {
+			return fmt.Errorf("provider %s is defined more than once, as %s and %s", provider.Name, owner, provider.Name)
+		}
+		owners[providerKey(provider.Name)] = provider.Name
+	}
+	for _, provider := range providers {
+		for _, alias := range provider.Aliases {
+			if owner, exists := owners[providerKey(alias)]; exists && owner != provider.Name {
+				return fmt.Errorf("alias %s of provider %s already means %s", alias, provider.Name, owner)
+			}
+			owners[providerKey(alias)] = provider.Name
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func providerNames(providers []Provider) []string {
+	got := []string{}
+	for _, provider := range providers {
+		got = append(got, provider.Name)
+	}
+	return got
+}
+
+func Test_validateProviderNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers []Provider
+		wantErr   bool
+	}{
+		{"unique", []Provider{{Name: "provider1", Aliases: []string{"p1", "provider1"}}, {Name: "provider2"}}, false},
+		{"duplicate", []Provider{{Name: "provider1"}, {Name: "provider2"}, {Name: "provider1"}}, true},
+		{"aliasIsAName", []Provider{{Name: "provider1", Aliases: []string{"provider2"}}, {Name: "provider2"}}, true},
+		{"sharedAlias", []Provider{{Name: "provider1", Aliases: []string{"p"}}, {Name: "provider2", Aliases: []string{"p"}}}, true},
+		{"differentCase", []Provider{{Name: "provider1"}, {Name: "Provider1"}}, true},
+		{"aliasDifferentCase", []Provider{{Name: "provider1", Aliases: []string{"P2"}}, {Name: "provider2", Aliases: []string{"p2"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateProviderNames(tt.providers); (err != nil) != tt.wantErr {
+				t.Errorf("validateProviderNames() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_providersToCall_aliases(t *testing.T) {
+	providers := []Provider{{Name: "bureau-uk", Aliases: []string{"provider1"}}, {Name: "provider2"}}
+	got := providerNames(providersToCall(context.Background(), providers, &[]string{"provider1", "provider2", "bureau-uk", "provider9"}))
+	if !reflect.DeepEqual(got, []string{"bureau-uk", "provider2"}) {
+		t.Errorf("providersToCall() = %v", got)
+	}
+	if got := unknownProviders(providers, &[]string{"provider1", "provider9"}); !reflect.DeepEqual(got, []string{"provider9"}) {
+		t.Errorf("unknownProviders() = %v", got)
+	}
+}
+
+func TestConfig_Handler_unknownProviders(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Aliases: []string{"p1"}, Type: ProviderTypeSimulated}}}
+	response, err := config.Handler(context.Background(), Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\", \"providers\": [\"p1\", \"provider9\"]}"})
+	want := "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}],\"warnings\":[\"unknown provider provider9\"]}"
+	if err != nil || response.Body != want {
+		t.Errorf("Handler() = %v, %v, want %v", response.Body, err, want)
+	}
+}
+
+func Test_providersToCall_normalised(t *testing.T) {
+	providers := []Provider{{Name: "provider1"}, {Name: "Bureau-UK", Aliases: []string{"ukBureau"}}}
+	got := providerNames(providersToCall(context.Background(), providers, &[]string{" Provider1 ", "UKBUREAU", "provider1"}))
+	if !reflect.DeepEqual(got, []string{"provider1", "Bureau-UK"}) {
+		t.Errorf("providersToCall() = %v", got)
+	}
+	if got := unknownProviders(providers, &[]string{"PROVIDER1", " bureau-uk", "provider 1"}); !reflect.DeepEqual(got, []string{"provider 1"}) {
+		t.Errorf("unknownProviders() = %v", got)
+	}
+}
+
+func Test_providersToCall_selectors(t *testing.T) {
+	providers := []Provider{
+		{Name: "bureau-uk", Tags: []string{"UK", "cheap"}},
+		{Name: "bureau-ie", Aliases: []string{"irish"}, Tags: []string{"ie"}},
+		{Name: "premium", Tags: []string{"uk"}},
+	}
+	tests := []struct {
+		name        string
+		filter      []string
+		want        []string
+		wantUnknown []string
+	}{
+		{"tag", []string{"tag:uk"}, []string{"bureau-uk", "premium"}, nil},
+		{"tags", []string{"tag:ie", " TAG:Cheap"}, []string{"bureau-ie", "bureau-uk"}, nil},
+		{"glob", []string{"Bureau-*"}, []string{"bureau-uk", "bureau-ie"}, nil},
+		{"aliasGlob", []string{"ir?sh"}, []string{"bureau-ie"}, nil},
+		{"overlapping", []string{"tag:uk", "bureau-*", "premium"}, []string{"bureau-uk", "premium", "bureau-ie"}, nil},
+		{"nothing", []string{"tag:fr", "x*", "[", "provider9"}, []string{}, []string{"tag:fr", "x*", "[", "provider9"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := providerNames(providersToCall(context.Background(), providers, &tt.filter)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("providersToCall() = %v, want %v", got, tt.want)
+			}
+			if got := unknownProviders(providers, &tt.filter); !reflect.DeepEqual(got, tt.wantUnknown) {
+				t.Errorf("unknownProviders() = %v, want %v", got, tt.wantUnknown)
+			}
+		})
+	}
+	if got := selectorWarning("tag:fr"); got != "no provider matches tag:fr" {
+		t.Errorf("selectorWarning() = %v", got)
+	}
+	if got := providerTags(providers); !reflect.DeepEqual(got, []string{"cheap", "ie", "uk"}) {
+		t.Errorf("providerTags() = %v", got)
+	}
+}
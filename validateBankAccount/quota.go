@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+  Daily quotas. A caller can be held to so many requests a day, and a provider's quota with the partner can be
+  written down so we know how close we are to it:
+
+    quotas:
+      warnAtPercent: 80   # default 80
+      callers:
+        arn:aws:iam::123456789012:user/ci: 50000
+    providers:
+      - name: provider2
+        dailyQuota: 100000
+
+  Days are UTC. A caller over its quota gets a 429 with Retry-After until midnight and the QuotaExhausted metric is
+  counted. A provider's quota is the partner's to enforce, we only count our calls against it, retries included.
+  Once a caller or a provider that's part of the answer has used warnAtPercent of its quota the response metadata
+  says so, and the QuotaWarnings metric is counted, so there's time to do something before the rejections start:
+
+    "metadata": {..., "quotaWarnings": [{"kind": "provider", "name": "provider2", "used": 81234, "quota": 100000,
+                                         "percentUsed": 81.23}]}
+
+  Usage is counted in memory and added to the counters in RATE_LIMIT_TABLE (see ratelimit.go) when the telemetry is
+  flushed, so what a container knows is everyone's usage as of its last flush plus its own since. Without the table
+  each container counts on its own.
+*/
+
+const (
+	defaultQuotaWarnAtPercent = 80
+	quotaDay                  = 24 * time.Hour
+	QuotaKindCaller           = "caller"
+	QuotaKindProvider         = "provider"
+)
+
+type QuotaConfig struct {
+	WarnAtPercent float64          `yaml:"warnAtPercent"`
+	Callers       map[string]int64 `yaml:"callers"`
+}
+
+type QuotaWarning struct {
+	Kind        string  `json:"kind"`
+	Name        string  `json:"name"`
+	Used        int64   `json:"used"`
+	Quota       int64   `json:"quota"`
+	PercentUsed float64 `json:"percentUsed"`
+}
+
+func (quotas *QuotaConfig) validate() error {
+	if quotas == nil {
+		return nil
+	}
+	if quotas.WarnAtPercent < 0 || quotas.WarnAtPercent > 100 {
+		return errors.New("quotas warnAtPercent should be between 0 and 100")
+	}
+	for caller, quota := range quotas.Callers {
+		if quota < 0 {
+			return fmt.Errorf("quota for %s can't be negative", caller)
+		}
+	}
+	return nil
+}
+
+func (quotas *QuotaConfig) warnAt() float64 {
+	if quotas == nil || quotas.WarnAtPercent == 0 {
+		return defaultQuotaWarnAtPercent
+	}
+	return quotas.WarnAtPercent
+}
+
+// The caller's quota, 0 for none
+func (quotas *QuotaConfig) caller(caller string) int64 {
+	if quotas == nil {
+		return 0
+	}
+	return quotas.Callers[caller]
+}
+
+// Today's usage by key, everyone's as of the last flush and ours since
+type quotaUsage struct {
+	mu sync.Mutex
+	// Where usage is shared, nil when it isn't and it's never flushed
+	counter windowCounter
+	day     time.Time
+	flushed map[string]int64
+	pending map[string]int64
+	now     func() time.Time
+}
+
+func newQuotaUsage(counter windowCounter) *quotaUsage {
+	return &quotaUsage{counter: counter, flushed: map[string]int64{}, pending: map[string]int64{}, now: time.Now}
+}
+
+func quotaKey(kind, name string) string {
+	return "quota#" + kind + "#" + name
+}
+
+// Starts the counts again on a new day, the caller holds mu
+func (usage *quotaUsage) today() time.Time {
+	day := usage.now().UTC().Truncate(quotaDay)
+	if !day.Equal(usage.day) {
+		usage.day = day
+		usage.flushed, usage.pending = map[string]int64{}, map[string]int64{}
+	}
+	return day
+}
+
+// Counts one against the key, returning its usage with it. A nil quotaUsage counts nothing.
+func (usage *quotaUsage) add(key string) int64 {
+	if usage == nil {
+		return 0
+	}
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	usage.today()
+	usage.pending[key]++
+	return usage.flushed[key] + usage.pending[key]
+}
+
+func (usage *quotaUsage) used(key string) int64 {
+	if usage == nil {
+		return 0
+	}
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	usage.today()
+	return usage.flushed[key] + usage.pending[key]
+}
+
+// Adds what's been counted to the table, which tells us everyone's usage. Counts that can't be added wait for the
+// next flush.
+func (usage *quotaUsage) flush(ctx context.Context) {
+	usage.mu.Lock()
+	day := usage.today()
+	pending := usage.pending
+	usage.pending = map[string]int64{}
+	usage.mu.Unlock()
+	for key, count := range pending {
+		total, err := usage.counter.add(ctx, key, day, quotaDay, count)
+		usage.mu.Lock()
+		if !usage.day.Equal(day) {
+			usage.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			usage.pending[key] += count
+			log.Printf("unable to add the quota usage for %s, keeping it for the next flush: %v", key, err)
+		} else {
+			usage.flushed[key] = total
+		}
+		usage.mu.Unlock()
+	}
+}
+
+// Counts the request against the caller's daily quota, turning it away with a 429 once it's used up
+func (config *Config) enforceQuota(request Request) *Response {
+	caller := callerIdentity(request)
+	quota := config.Quotas.caller(caller)
+	if quota == 0 || config.quotaUsage.add(quotaKey(QuotaKindCaller, caller)) <= quota {
+		return nil
+	}
+	config.telemetry.count("QuotaExhausted", 1)
+	response, _ := jsonResponse(http.StatusTooManyRequests, map[string]string{"error": "daily quota exhausted"})
+	now := config.quotaUsage.now().UTC()
+	response.Headers["Retry-After"] = strconv.Itoa(int(now.Truncate(quotaDay).Add(quotaDay).Sub(now).Seconds()) + 1)
+	return &response
+}
+
+// Counts a call against the provider's daily quota, when it has one
+func (provider Provider) countQuota() {
+	if provider.DailyQuota > 0 {
+		provider.quota.add(quotaKey(QuotaKindProvider, provider.Name))
+	}
+}
+
+// The caller and the providers in the results that are past warnAtPercent of their quota
+func (config *Config) quotaWarnings(request Request, results []BankAccountValidationResult) []QuotaWarning {
+	if config.quotaUsage == nil {
+		return nil
+	}
+	warnings := []QuotaWarning{}
+	warn := func(kind, name string, quota int64) {
+		used := config.quotaUsage.used(quotaKey(kind, name))
+		if quota == 0 || percent(used, quota) < config.Quotas.warnAt() {
+			return
+		}
+		warnings = append(warnings, QuotaWarning{Kind: kind, Name: name, Used: used, Quota: quota, PercentUsed: roundPercent(percent(used, quota))})
+	}
+	caller := callerIdentity(request)
+	warn(QuotaKindCaller, caller, config.Quotas.caller(caller))
+	index := providerIndex(config.Providers)
+	seen := map[string]bool{}
+	for _, result := range results {
+		if i, exists := index[providerKey(result.Provider)]; exists && !seen[result.Provider] {
+			seen[result.Provider] = true
+			warn(QuotaKindProvider, config.Providers[i].Name, config.Providers[i].DailyQuota)
+		}
+	}
+	if len(warnings) == 0 {
+		return nil
+	}
+	config.telemetry.count("QuotaWarnings", 1)
+	return warnings
+}
+
+// The warnings for the response's metadata
+func withQuotaWarnings(metadata *ResponseMetadata, warnings []QuotaWarning) *ResponseMetadata {
+	copied := copyMetadata(metadata)
+	copied.QuotaWarnings = warnings
+	return copied
+}
+
+// Anything with a quota is counted, shared through the rate limit table when there is one
+func (config *Config) setupQuotas(ctx context.Context) {
+	quotas := config.Quotas != nil
+	for _, provider := range config.Providers {
+		quotas = quotas || provider.DailyQuota > 0
+	}
+	if !quotas {
+		return
+	}
+	usage := newQuotaUsage(nil)
+	if config.store != nil {
+		usage.counter = config.store
+		config.telemetry.onFlush(usage.flush)
+	}
+	config.quotaUsage = usage
+	for i := range config.Providers {
+		config.Providers[i].quota = usage
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQuotaConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		quotas  *QuotaConfig
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"quotas", &QuotaConfig{WarnAtPercent: 90, Callers: map[string]int64{"ci": 50000}}, false},
+		{"warn over 100", &QuotaConfig{WarnAtPercent: 120}, true},
+		{"negative quota", &QuotaConfig{Callers: map[string]int64{"ci": -1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.quotas.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_quotaUsage(t *testing.T) {
+	// Two containers sharing a table
+	table := newLocalCounter()
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	ours, theirs := newQuotaUsage(table), newQuotaUsage(table)
+	ours.now, theirs.now = clock, clock
+
+	ours.add("quota#caller#ci")
+	theirs.add("quota#caller#ci")
+	theirs.add("quota#caller#ci")
+	if got := ours.used("quota#caller#ci"); got != 1 {
+		t.Errorf("used() before a flush = %d, want our own 1", got)
+	}
+	theirs.flush(context.Background())
+	ours.flush(context.Background())
+	if got := ours.add("quota#caller#ci"); got != 4 {
+		t.Errorf("add() after the flushes = %d, want everyone's 4", got)
+	}
+
+	// A failed flush keeps the counts for the next one
+	ours.counter = failingCounter{}
+	ours.flush(context.Background())
+	if got := ours.used("quota#caller#ci"); got != 4 {
+		t.Errorf("used() after a failed flush = %d, want 4", got)
+	}
+
+	// A new day starts again
+	now = now.Add(15 * time.Hour)
+	if got := ours.used("quota#caller#ci"); got != 0 {
+		t.Errorf("used() the next day = %d, want 0", got)
+	}
+}
+
+func TestConfig_Router_quotas(t *testing.T) {
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated, DailyQuota: 4}, {Name: "provider2", Type: ProviderTypeSimulated}},
+		Quotas:    &QuotaConfig{WarnAtPercent: 50, Callers: map[string]int64{"arn:aws:iam::123456789012:user/ci": 3}},
+	}
+	config.setupQuotas(context.Background())
+	config.quotaUsage.now = func() time.Time { return time.Date(2024, 6, 1, 23, 59, 0, 0, time.UTC) }
+	validate := func() Response {
+		request := Request{HTTPMethod: "POST", Path: "/application", Body: "{\"accountNumber\": \"12345670\"}"}
+		request.RequestContext.Identity.UserArn = "arn:aws:iam::123456789012:user/ci"
+		got, err := config.Router(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	// Simulated providers aren't called, so provider1's usage is whatever we've counted
+	if got := validate(); got.StatusCode != 200 || got.Body != "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true},{\"provider\":\"provider2\",\"isValid\":true}]}" {
+		t.Errorf("Router() below every warning = %d %s", got.StatusCode, got.Body)
+	}
+	config.Providers[0].countQuota()
+	config.Providers[0].countQuota()
+	want := "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true},{\"provider\":\"provider2\",\"isValid\":true}]," +
+		"\"metadata\":{\"configHash\":\"\",\"quotaWarnings\":[{\"kind\":\"caller\",\"name\":\"arn:aws:iam::123456789012:user/ci\",\"used\":2,\"quota\":3,\"percentUsed\":66.67}," +
+		"{\"kind\":\"provider\",\"name\":\"provider1\",\"used\":2,\"quota\":4,\"percentUsed\":50}]}}"
+	if got := validate(); got.StatusCode != 200 || got.Body != want {
+		t.Errorf("Router() past the warnings = %d %s, want %s", got.StatusCode, got.Body, want)
+	}
+	validate()
+	got := validate()
+	if got.StatusCode != 429 || got.Headers["Retry-After"] != "61" {
+		t.Errorf("Router() over the quota = %d %v %s, want a 429", got.StatusCode, got.Headers, got.Body)
+	}
+}
+
+func TestConfig_quotaWarnings_none(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", DailyQuota: 10}}}
+	if got := config.quotaWarnings(Request{}, []BankAccountValidationResult{{Provider: "provider1"}}); !reflect.DeepEqual(got, []QuotaWarning(nil)) {
+		t.Errorf("quotaWarnings() without any usage counted = %v", got)
+	}
+}
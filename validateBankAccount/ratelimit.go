@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+/*
+  Per caller rate limits. Each caller (see callerIdentity in security.go) gets so many requests a minute, counted in
+  fixed windows that start on the minute:
+
+    rateLimit:
+      requestsPerMinute: 600   # every caller's limit
+      callers:                 # callers with a limit of their own, 0 for none
+        arn:aws:iam::123456789012:user/ci: 60
+
+  Counts go to the store (see store.go). With RATE_LIMIT_TABLE set every container counts into the same item, so the
+  limit is the caller's across the function:
+
+    key (S, hash key, caller#window) | count (N) | expiresAt (N, TTL)
+
+  Without it each container counts on its own. A request over the limit gets a 429 with Retry-After, and the first
+  of them in a window raises a rate_limited security event. Every response to a counted request says where the
+  caller stands, so a well behaved client can slow down before it's turned away:
+
+    X-RateLimit-Limit: 600          # requests a window
+    X-RateLimit-Remaining: 412      # what's left of this window
+    X-RateLimit-Reset: 1717232460   # unix time the window ends
+
+  Admin routes aren't limited. A table we can't reach lets the request through, without the headers.
+*/
+
+const (
+	rateLimitWindow  = time.Minute
+	rateLimitTimeout = 200 * time.Millisecond
+	// Past this a container's own counts sweep out the windows that are over before counting a new key
+	maxCountedKeys = 10000
+)
+
+type RateLimitConfig struct {
+	RequestsPerMinute int            `yaml:"requestsPerMinute"`
+	Callers           map[string]int `yaml:"callers"`
+}
+
+func (limits *RateLimitConfig) validate() error {
+	if limits == nil {
+		return nil
+	}
+	if limits.RequestsPerMinute < 0 {
+		return errors.New("rateLimit requestsPerMinute can't be negative")
+	}
+	for caller, limit := range limits.Callers {
+		if limit < 0 {
+			return fmt.Errorf("rateLimit for %s can't be negative", caller)
+		}
+	}
+	return nil
+}
+
+// The caller's requests a window, 0 for no limit
+func (limits *RateLimitConfig) limit(caller string) int {
+	if limit, exists := limits.Callers[caller]; exists {
+		return limit
+	}
+	return limits.RequestsPerMinute
+}
+
+// Counts in fixed windows
+type windowCounter interface {
+	// Adds n to the key's count in the window starting at start, returning the count with it
+	add(ctx context.Context, key string, start time.Time, length time.Duration, n int64) (int64, error)
+}
+
+type countedWindow struct {
+	start time.Time
+	end   time.Time
+	count int64
+}
+
+// The container's own counts
+type localCounter struct {
+	mu      sync.Mutex
+	windows map[string]*countedWindow
+}
+
+func newLocalCounter() *localCounter {
+	return &localCounter{windows: map[string]*countedWindow{}}
+}
+
+func (counter *localCounter) add(ctx context.Context, key string, start time.Time, length time.Duration, n int64) (int64, error) {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	window, exists := counter.windows[key]
+	if !exists && len(counter.windows) >= maxCountedKeys {
+		for key, window := range counter.windows {
+			if !window.end.After(start) {
+				delete(counter.windows, key)
+			}
+		}
+	}
+	if !exists || !window.start.Equal(start) {
+		window = &countedWindow{start: start, end: start.Add(length)}
+		counter.windows[key] = window
+	}
+	window.count += n
+	return window.count, nil
+}
+
+type counterDynamoAPI interface {
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// Counts shared by every container, an item a key and window that expires with the window
+type dynamoCounter struct {
+	client counterDynamoAPI
+	table  string
+}
+
+func (counter *dynamoCounter) add(ctx context.Context, key string, start time.Time, length time.Duration, n int64) (int64, error) {
+	output, err := counter.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(counter.table),
+		Key: map[string]types.AttributeValue{
+			"key": &types.AttributeValueMemberS{Value: key + "#" + strconv.FormatInt(start.Unix(), 10)},
+		},
+		UpdateExpression: aws.String("ADD #count :n SET expiresAt = :expiresAt"),
+		// count is a reserved word
+		ExpressionAttributeNames: map[string]string{"#count": "count"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":n":         &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)},
+			":expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(start.Add(length).Unix(), 10)},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return 0, err
+	}
+	count, _ := output.Attributes["count"].(*types.AttributeValueMemberN)
+	if count == nil {
+		return 0, errors.New("no count in the updated item")
+	}
+	return strconv.ParseInt(count.Value, 10, 64)
+}
+
+// Where the caller stands in the current window
+type RateLimitStatus struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+type rateLimiter struct {
+	limits  RateLimitConfig
+	counter windowCounter
+	now     func() time.Time
+}
+
+// Counts the request against the caller's limit, returning where the caller stands and how many requests it's made
+// this window with this one. nil when the caller has no limit or we couldn't count it.
+func (limiter *rateLimiter) take(ctx context.Context, caller string) (*RateLimitStatus, int) {
+	limit := limiter.limits.limit(caller)
+	if limit == 0 {
+		return nil, 0
+	}
+	start := limiter.now().Truncate(rateLimitWindow)
+	ctx, cancel := context.WithTimeout(ctx, rateLimitTimeout)
+	defer cancel()
+	count, err := limiter.counter.add(ctx, caller, start, rateLimitWindow, 1)
+	if err != nil {
+		log.Printf("unable to count a request against %s's rate limit, letting it through: %v", caller, err)
+		return nil, 0
+	}
+	return &RateLimitStatus{Limit: limit, Remaining: max(limit-int(count), 0), Reset: start.Add(rateLimitWindow)}, int(count)
+}
+
+type rateLimitKey struct{}
+
+// The caller's standing for the response headers
+func rateLimitFrom(ctx context.Context) *RateLimitStatus {
+	status, _ := ctx.Value(rateLimitKey{}).(*RateLimitStatus)
+	return status
+}
+
+// Counts the request, turning it away with a 429 when the caller's over its limit
+func (config *Config) enforceRateLimit(ctx context.Context, request Request) (context.Context, *Response) {
+	if config.rateLimiter == nil {
+		return ctx, nil
+	}
+	caller := callerIdentity(request)
+	status, count := config.rateLimiter.take(ctx, caller)
+	if status == nil {
+		return ctx, nil
+	}
+	ctx = context.WithValue(ctx, rateLimitKey{}, status)
+	if count <= status.Limit {
+		return ctx, nil
+	}
+	config.telemetry.count("RateLimited", 1)
+	// Once a window, however many more it sends
+	if count == status.Limit+1 {
+		config.security.report(ctx, request, SecurityRateLimited, fmt.Sprintf("over %d requests a minute", status.Limit))
+	}
+	response, _ := jsonResponse(http.StatusTooManyRequests, map[string]string{"error": "rate limit exceeded"})
+	response.Headers["Retry-After"] = strconv.Itoa(int(status.Reset.Sub(config.rateLimiter.now()).Seconds()) + 1)
+	return ctx, &response
+}
+
+// Adds where the caller stands to the response, when the request was counted
+func withRateLimitHeaders(ctx context.Context, response Response) Response {
+	status := rateLimitFrom(ctx)
+	if status == nil {
+		return response
+	}
+	headers := map[string]string{}
+	for name, value := range response.Headers {
+		headers[name] = value
+	}
+	headers["X-RateLimit-Limit"] = strconv.Itoa(status.Limit)
+	headers["X-RateLimit-Remaining"] = strconv.Itoa(status.Remaining)
+	headers["X-RateLimit-Reset"] = strconv.FormatInt(status.Reset.Unix(), 10)
+	response.Headers = headers
+	return response
+}
+
+func (config *Config) setupRateLimits(ctx context.Context) {
+	if config.RateLimit == nil {
+		return
+	}
+	var counter windowCounter = newLocalCounter()
+	if config.store != nil {
+		counter = config.store
+	}
+	config.rateLimiter = &rateLimiter{limits: *config.RateLimit, counter: counter, now: time.Now}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  *RateLimitConfig
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"limit", &RateLimitConfig{RequestsPerMinute: 600, Callers: map[string]int{"ci": 60, "batch": 0}}, false},
+		{"negative limit", &RateLimitConfig{RequestsPerMinute: -1}, true},
+		{"negative caller", &RateLimitConfig{Callers: map[string]int{"ci": -1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.limits.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_localCounter(t *testing.T) {
+	counter := newLocalCounter()
+	start := time.Unix(600, 0)
+	for want := int64(1); want <= 3; want++ {
+		if got, _ := counter.add(context.Background(), "ci", start, time.Minute, 1); got != want {
+			t.Errorf("add() = %d, want %d", got, want)
+		}
+	}
+	if got, _ := counter.add(context.Background(), "other", start, time.Minute, 1); got != 1 {
+		t.Errorf("another key's add() = %d, want 1", got)
+	}
+	if got, _ := counter.add(context.Background(), "ci", start.Add(time.Minute), time.Minute, 1); got != 1 {
+		t.Errorf("next window's add() = %d, want 1", got)
+	}
+}
+
+type failingCounter struct{}
+
+func (failingCounter) add(ctx context.Context, key string, start time.Time, length time.Duration, n int64) (int64, error) {
+	return 0, errors.New("throttled")
+}
+
+func TestConfig_Router_rateLimit(t *testing.T) {
+	events := &bytes.Buffer{}
+	now := time.Unix(1717232430, 0)
+	config := &Config{
+		Providers:   []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		RateLimit:   &RateLimitConfig{RequestsPerMinute: 2, Callers: map[string]int{"arn:aws:iam::123456789012:user/batch": 0}},
+		security:    newSecurityLog(SecurityConfig{}, nil, events),
+		rateLimiter: &rateLimiter{counter: newLocalCounter(), now: func() time.Time { return now }},
+	}
+	config.rateLimiter.limits = *config.RateLimit
+	request := func(caller string) Response {
+		request := Request{HTTPMethod: "GET", Path: "/capabilities"}
+		request.RequestContext.Identity.UserArn = caller
+		got, err := config.Router(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	for _, remaining := range []string{"1", "0"} {
+		got := request("arn:aws:iam::123456789012:user/ci")
+		if got.StatusCode != 200 || got.Headers["X-RateLimit-Limit"] != "2" || got.Headers["X-RateLimit-Remaining"] != remaining || got.Headers["X-RateLimit-Reset"] != "1717232460" {
+			t.Errorf("Router() = %d %v, want 200 with %s remaining", got.StatusCode, got.Headers, remaining)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		got := request("arn:aws:iam::123456789012:user/ci")
+		if got.StatusCode != 429 || got.Headers["Retry-After"] != "31" || got.Headers["X-RateLimit-Remaining"] != "0" {
+			t.Errorf("Router() over the limit = %d %v, want a 429", got.StatusCode, got.Headers)
+		}
+	}
+	if reported := strings.Count(events.String(), `"type":"rate_limited"`); reported != 1 {
+		t.Errorf("reported %d rate_limited events, want 1: %s", reported, events)
+	}
+
+	// A caller without a limit has no headers, nor does one we can't count
+	if got := request("arn:aws:iam::123456789012:user/batch"); got.StatusCode != 200 || got.Headers["X-RateLimit-Limit"] != "" {
+		t.Errorf("Router() for an unlimited caller = %d %v", got.StatusCode, got.Headers)
+	}
+	config.rateLimiter.counter = failingCounter{}
+	if got := request("arn:aws:iam::123456789012:user/ci"); got.StatusCode != 200 || got.Headers["X-RateLimit-Limit"] != "" {
+		t.Errorf("Router() when the count fails = %d %v", got.StatusCode, got.Headers)
+	}
+
+	// The next window starts again
+	config.rateLimiter.counter = newLocalCounter()
+	now = now.Add(time.Minute)
+	if got := request("arn:aws:iam::123456789012:user/ci"); got.StatusCode != 200 || got.Headers["X-RateLimit-Remaining"] != "1" {
+		t.Errorf("Router() in the next window = %d %v", got.StatusCode, got.Headers)
+	}
+}
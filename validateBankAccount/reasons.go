@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+/*
+  Reason codes. Some providers say why an account is invalid, and "closed" is worth a lot more to a payments team
+  than false. Where the provider puts it is configured like the verdict, a dot path into its response (default
+  reason):
+
+    - name: provider1
+      url: https://provider1.com/v1/api/account/validate
+      reasonField: result.statusCode
+
+  and the result carries it as one of our codes, whatever the provider called it:
+
+    {"result": [{"provider": "provider1", "isValid": false, "reason": "ACCOUNT_CLOSED"}]}
+
+    ACCOUNT_CLOSED   the account existed but has been closed
+    NOT_FOUND        there's no such account
+    BLOCKED          the account exists but can't take payments, frozen or suspended
+
+  The usual spellings (closed, account-closed, no_such_account, frozen...) are recognised, in any case. Providers with
+  codes of their own get a dictionary, checked before the usual spellings and also in any case:
+
+    - name: provider2
+      url: https://provider2.com/v2/api/account/validate
+      reasonField: returnCode
+      reasons:
+        R02: ACCOUNT_CLOSED
+        R03: NOT_FOUND
+        R16: BLOCKED
+
+  A reason we still don't recognise comes back as the provider gave it, under rawReason, and is counted in the
+  UnknownReasonCodes metric and logged with the provider's name so it can be added to the dictionary. One the
+  response doesn't have leaves the result without either. Valid answers and errors never have a reason.
+*/
+
+const defaultReasonField = "reason"
+
+const (
+	ReasonAccountClosed = "ACCOUNT_CLOSED"
+	ReasonNotFound      = "NOT_FOUND"
+	ReasonBlocked       = "BLOCKED"
+)
+
+// What providers call our reasons, upper case with underscores
+var reasonAliases = map[string]string{
+	"ACCOUNT_CLOSED":    ReasonAccountClosed,
+	"CLOSED":            ReasonAccountClosed,
+	"CLOSED_ACCOUNT":    ReasonAccountClosed,
+	"NOT_FOUND":         ReasonNotFound,
+	"ACCOUNT_NOT_FOUND": ReasonNotFound,
+	"NO_SUCH_ACCOUNT":   ReasonNotFound,
+	"UNKNOWN_ACCOUNT":   ReasonNotFound,
+	"BLOCKED":           ReasonBlocked,
+	"ACCOUNT_BLOCKED":   ReasonBlocked,
+	"FROZEN":            ReasonBlocked,
+	"SUSPENDED":         ReasonBlocked,
+}
+
+// The reason the provider gave, as it gave it, empty when there isn't one
+func (provider Provider) extractReason(body []byte) string {
+	var parsed interface{}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return ""
+	}
+	field := provider.ReasonField
+	if field == "" {
+		field = defaultReasonField
+	}
+	value, err := lookupField(parsed, field)
+	if err != nil {
+		return ""
+	}
+	switch typed := value.(type) {
+	case string:
+		return strings.TrimSpace(typed)
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	}
+	return ""
+}
+
+var reasonCodes = map[string]bool{ReasonAccountClosed: true, ReasonNotFound: true, ReasonBlocked: true}
+
+func reasonKey(raw string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", " ", "_").Replace(raw))
+}
+
+// Our code for a reason from the usual spellings, empty when we don't recognise it
+func normalizeReason(raw string) string {
+	return reasonAliases[reasonKey(raw)]
+}
+
+// Our code for the provider's reason, or the reason as it is when neither its dictionary nor the usual spellings
+// have it
+func (provider Provider) normalizeReason(raw string) (string, string) {
+	if raw == "" {
+		return "", ""
+	}
+	for code, reason := range provider.Reasons {
+		if reasonKey(code) == reasonKey(raw) {
+			return reason, ""
+		}
+	}
+	if reason := normalizeReason(raw); reason != "" {
+		return reason, ""
+	}
+	return "", raw
+}
+
+func (provider Provider) validateReasons() error {
+	for code, reason := range provider.Reasons {
+		if !reasonCodes[reason] {
+			return fmt.Errorf("provider %s maps reason %s to %s, which isn't ACCOUNT_CLOSED, NOT_FOUND or BLOCKED", provider.Name, code, reason)
+		}
+	}
+	return nil
+}
+
+// Counts the reasons the dictionaries are missing
+func (config *Config) countUnknownReasons(results []BankAccountValidationResult) {
+	for _, result := range results {
+		if result.RawReason != "" {
+			log.Printf("provider %s gave reason %q, which isn't in its reasons", result.Provider, result.RawReason)
+			config.telemetry.count("UnknownReasonCodes", 1)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProvider_extractReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		body     string
+		want     string
+	}{
+		{"default", Provider{}, `{"isValid": false, "reason": "closed"}`, "closed"},
+		{"nested", Provider{ReasonField: "result.statusCode"}, `{"result": {"statusCode": " NO_SUCH_ACCOUNT "}}`, "NO_SUCH_ACCOUNT"},
+		{"number", Provider{ReasonField: "code"}, `{"code": 404}`, "404"},
+		{"missing", Provider{}, `{"isValid": true}`, ""},
+		{"object", Provider{}, `{"reason": {"code": "closed"}}`, ""},
+		{"notJSON", Provider{}, `closed`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.provider.extractReason([]byte(tt.body)); got != tt.want {
+				t.Errorf("extractReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_normalizeReason(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"ACCOUNT_CLOSED", ReasonAccountClosed},
+		{"account-closed", ReasonAccountClosed},
+		{"Closed", ReasonAccountClosed},
+		{"no such account", ReasonNotFound},
+		{"NOT_FOUND", ReasonNotFound},
+		{"frozen", ReasonBlocked},
+		{"BLOCKED", ReasonBlocked},
+		{"R03", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeReason(tt.raw); got != tt.want {
+			t.Errorf("normalizeReason(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func Test_callProviderReason(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "REJECTED", "detail": {"why": "account closed"}}`))
+	}))
+	defer server.Close()
+
+	provider := Provider{Name: "provider1", URL: server.URL, ValidField: "status", ValidValues: []string{"OK"}, ReasonField: "detail.why"}
+	result := callProvider(context.Background(), "12345678", provider, provider.URL)
+	if result.Error != "" || result.IsValid || result.Reason != ReasonAccountClosed {
+		t.Errorf("callProvider() = %+v, want invalid with reason %s", result, ReasonAccountClosed)
+	}
+}
+
+func TestProvider_normalizeReason(t *testing.T) {
+	provider := Provider{Name: "provider2", Reasons: map[string]string{"R02": ReasonAccountClosed, "r03": ReasonNotFound, "CLOSED": ReasonBlocked}}
+	tests := []struct {
+		raw           string
+		wantReason    string
+		wantRawReason string
+	}{
+		{"R02", ReasonAccountClosed, ""},
+		{"R03", ReasonNotFound, ""},
+		{"closed", ReasonBlocked, ""},
+		{"frozen", ReasonBlocked, ""},
+		{"R99", "", "R99"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if reason, rawReason := provider.normalizeReason(tt.raw); reason != tt.wantReason || rawReason != tt.wantRawReason {
+			t.Errorf("normalizeReason(%q) = %q, %q, want %q, %q", tt.raw, reason, rawReason, tt.wantReason, tt.wantRawReason)
+		}
+	}
+}
+
+func TestProvider_validateReasons(t *testing.T) {
+	if err := (Provider{Name: "provider2", Reasons: map[string]string{"R02": ReasonAccountClosed}}).validateReasons(); err != nil {
+		t.Errorf("validateReasons() = %v", err)
+	}
+	if err := (Provider{Name: "provider2", Reasons: map[string]string{"R02": "closed"}}).validateReasons(); err == nil {
+		t.Errorf("validateReasons() accepted a reason that isn't one of ours")
+	}
+}
+
+func TestConfig_unknownReasons(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"isValid": false, "returnCode": "R99"}`))
+	}))
+	defer server.Close()
+
+	config := &Config{Providers: []Provider{{Name: "provider2", URL: server.URL, ReasonField: "returnCode", Reasons: map[string]string{"R02": ReasonAccountClosed}}}}
+	config.telemetry = newTelemetry(nil, &bytes.Buffer{})
+	response, err := config.Handler(context.Background(), Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345678\"}"})
+	want := "{\"result\":[{\"provider\":\"provider2\",\"isValid\":false,\"rawReason\":\"R99\"}]}"
+	if err != nil || response.Body != want {
+		t.Errorf("Handler() = %s, want %s", response.Body, want)
+	}
+	if got := config.telemetry.counts["UnknownReasonCodes"]; got != 1 {
+		t.Errorf("UnknownReasonCodes = %v, want 1", got)
+	}
+}
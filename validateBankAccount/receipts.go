@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+/*
+  Validation receipts. A service that has already validated an account can hand the receipt to the next one, which
+  checks it with us instead of paying for the providers again:
+
+    receipts:
+      ttlSeconds: 86400        # how long a receipt is good for, default a day
+      keys:                    # the first signs, they all verify, so a new key goes first and the old one stays
+        - id: "2024-06"        # until its receipts have expired
+          secretId: accountvalidator/receipts
+
+  Every answered validation (not one where no provider answered) gets "receipt" in the response, a JWT signed
+  HS256 with the key:
+
+    {"accountHash": "5e88...", "verdict": "valid", "providers": ["provider1", "provider2"], "iat": 1717232400, "exp": 1717318800}
+
+  POST /receipts/verify {"receipt": "eyJ...", "accountNumber": "12345678"} answers {"valid": true, "receipt": {...}}
+  when the receipt is ours, unexpired and, if an account number is given, for that account, and {"valid": false,
+  "error": "..."} otherwise. The account number is optional so a service that only has the receipt can still read
+  the verdict.
+*/
+
+const defaultReceiptTTL = 24 * time.Hour
+
+var (
+	errReceiptMalformed = errors.New("receipt is malformed")
+	errReceiptSignature = errors.New("receipt signature doesn't match")
+	errReceiptExpired   = errors.New("receipt has expired")
+	errReceiptAccount   = errors.New("receipt is for a different account")
+)
+
+type ReceiptsConfig struct {
+	TTLSeconds int           `yaml:"ttlSeconds"`
+	Keys       []*SigningKey `yaml:"keys"`
+}
+
+type Receipt struct {
+	AccountHash string   `json:"accountHash"`
+	Verdict     string   `json:"verdict"`
+	Providers   []string `json:"providers"`
+	IssuedAt    int64    `json:"iat"`
+	ExpiresAt   int64    `json:"exp"`
+}
+
+type ReceiptVerifyRequest struct {
+	Receipt       string  `json:"receipt"`
+	AccountNumber *string `json:"accountNumber"`
+}
+
+type ReceiptVerifyResult struct {
+	Valid   bool     `json:"valid"`
+	Error   string   `json:"error,omitempty"`
+	Receipt *Receipt `json:"receipt,omitempty"`
+}
+
+func (receipts *ReceiptsConfig) validate() error {
+	if receipts == nil {
+		return nil
+	}
+	if receipts.TTLSeconds < 0 {
+		return errors.New("receipts ttlSeconds can't be negative")
+	}
+	if len(receipts.Keys) == 0 {
+		return errors.New("receipts needs at least one key")
+	}
+	ids := map[string]bool{}
+	for _, key := range receipts.Keys {
+		if err := key.validate(); err != nil {
+			return err
+		}
+		if ids[key.ID] {
+			return fmt.Errorf("receipts has two keys with the id %s", key.ID)
+		}
+		ids[key.ID] = true
+	}
+	return nil
+}
+
+func (receipts *ReceiptsConfig) ttl() time.Duration {
+	if receipts.TTLSeconds == 0 {
+		return defaultReceiptTTL
+	}
+	return time.Duration(receipts.TTLSeconds) * time.Second
+}
+
+func receiptMAC(secret, input string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(input))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Signs the receipt with the first key
+func (receipts *ReceiptsConfig) issue(ctx context.Context, receipt Receipt) (string, error) {
+	key := receipts.Keys[0]
+	secret, err := key.secret(ctx)
+	if err != nil {
+		return "", err
+	}
+	header, err := marshalJSON(map[string]string{"alg": "HS256", "typ": "JWT", "kid": key.ID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := marshalJSON(receipt)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return input + "." + receiptMAC(secret, input), nil
+}
+
+// Checks the token was signed by one of our keys and hasn't expired, returning what it says
+func (receipts *ReceiptsConfig) verify(ctx context.Context, token string, now time.Time) (*Receipt, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errReceiptMalformed
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errReceiptMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil || header.Alg != "HS256" {
+		return nil, errReceiptMalformed
+	}
+	input := parts[0] + "." + parts[1]
+	matched := false
+	for _, key := range receipts.Keys {
+		if key.ID != header.Kid {
+			continue
+		}
+		secret, err := key.secret(ctx)
+		if err != nil {
+			return nil, err
+		}
+		matched = hmac.Equal([]byte(receiptMAC(secret, input)), []byte(parts[2]))
+		break
+	}
+	if !matched {
+		return nil, errReceiptSignature
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errReceiptMalformed
+	}
+	receipt := &Receipt{}
+	if err := json.Unmarshal(claims, receipt); err != nil {
+		return nil, errReceiptMalformed
+	}
+	if now.Unix() >= receipt.ExpiresAt {
+		return nil, errReceiptExpired
+	}
+	return receipt, nil
+}
+
+// The receipt for a response, empty when receipts are off or nobody answered
+func (config *Config) issueReceipt(ctx context.Context, validationRequest *BankAccountValidationRequest, response BankAccountValidationResponse) string {
+	if config.Receipts == nil {
+		return ""
+	}
+	verdict := outcome(validationRequest, response)
+	if verdict == ResultStatusError {
+		return ""
+	}
+	now := time.Now()
+	receipt := Receipt{
+		AccountHash: accountHash(*validationRequest.AccountNumber),
+		Verdict:     verdict,
+		Providers:   []string{},
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(config.Receipts.ttl()).Unix(),
+	}
+	for _, result := range response.Result {
+		if !result.Local && result.Error == "" {
+			receipt.Providers = append(receipt.Providers, result.Provider)
+		}
+	}
+	token, err := config.Receipts.issue(ctx, receipt)
+	if err != nil {
+		log.Printf("unable to issue a receipt: %v", err)
+		return ""
+	}
+	return token
+}
+
+func (config *Config) verifyReceipt(ctx context.Context, request Request, now time.Time) ReceiptVerifyResult {
+	if config.Receipts == nil {
+		return ReceiptVerifyResult{Error: "receipts aren't enabled"}
+	}
+	verifyRequest := ReceiptVerifyRequest{}
+	if err := decodeJSON(request.Body, &verifyRequest); err != nil {
+		return ReceiptVerifyResult{Error: decodeMessage(err)}
+	}
+	receipt, err := config.Receipts.verify(ctx, verifyRequest.Receipt, now)
+	if err != nil {
+		return ReceiptVerifyResult{Error: err.Error()}
+	}
+	if verifyRequest.AccountNumber != nil && accountHash(*verifyRequest.AccountNumber) != receipt.AccountHash {
+		return ReceiptVerifyResult{Error: errReceiptAccount.Error()}
+	}
+	return ReceiptVerifyResult{Valid: true, Receipt: receipt}
+}
+
+// Handler for POST /receipts/verify, always a 200 with valid saying whether the receipt holds
+func (config *Config) ReceiptVerifyHandler(ctx context.Context, request Request) (Response, error) {
+	return jsonResponse(200, config.verifyReceipt(ctx, request, time.Now()))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func receiptConfig() *Config {
+	return &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		Receipts: &ReceiptsConfig{Keys: []*SigningKey{
+			{ID: "2024-07", Secret: "new-secret"},
+			{ID: "2024-06", Secret: "old-secret"},
+		}},
+	}
+}
+
+func TestConfig_receipts(t *testing.T) {
+	config := receiptConfig()
+	response, err := config.Handler(context.Background(), Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\"}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body BankAccountValidationResponse
+	if err := json.Unmarshal([]byte(response.Body), &body); err != nil || body.Receipt == "" {
+		t.Fatalf("Handler() = %s, want a receipt", response.Body)
+	}
+
+	// Signed with an old key that's still listed
+	old := &ReceiptsConfig{Keys: []*SigningKey{config.Receipts.Keys[1]}}
+	oldReceipt, _ := old.issue(context.Background(), Receipt{AccountHash: accountHash("12345670"), Verdict: ResultStatusValid, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	expired, _ := config.Receipts.issue(context.Background(), Receipt{AccountHash: accountHash("12345670"), ExpiresAt: 1})
+	parts := strings.Split(body.Receipt, ".")
+	forged := parts[0] + "." + parts[1] + ".c2lnbmF0dXJl"
+
+	tests := []struct {
+		name      string
+		body      string
+		wantValid bool
+		wantError string
+	}{
+		{"valid", "{\"receipt\": \"" + body.Receipt + "\", \"accountNumber\": \"12345670\"}", true, ""},
+		{"noAccount", "{\"receipt\": \"" + body.Receipt + "\"}", true, ""},
+		{"oldKey", "{\"receipt\": \"" + oldReceipt + "\"}", true, ""},
+		{"otherAccount", "{\"receipt\": \"" + body.Receipt + "\", \"accountNumber\": \"87654321\"}", false, "receipt is for a different account"},
+		{"forged", "{\"receipt\": \"" + forged + "\"}", false, "receipt signature doesn't match"},
+		{"expired", "{\"receipt\": \"" + expired + "\"}", false, "receipt has expired"},
+		{"malformed", "{\"receipt\": \"nope\"}", false, "receipt is malformed"},
+		{"badJson", "{\"receipts\": \"nope\"}", false, "unknown field \"receipts\" in payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := config.verifyReceipt(context.Background(), Request{Body: tt.body}, time.Now())
+			if got.Valid != tt.wantValid || got.Error != tt.wantError {
+				t.Errorf("verifyReceipt() = %+v, want %v %q", got, tt.wantValid, tt.wantError)
+			}
+		})
+	}
+
+	got := config.verifyReceipt(context.Background(), Request{Body: "{\"receipt\": \"" + body.Receipt + "\"}"}, time.Now())
+	if got.Receipt == nil || got.Receipt.Verdict != ResultStatusValid || !reflect.DeepEqual(got.Receipt.Providers, []string{"provider1"}) {
+		t.Errorf("verifyReceipt() receipt = %+v", got.Receipt)
+	}
+}
+
+func TestConfig_issueReceipt_unanswered(t *testing.T) {
+	config := receiptConfig()
+	accountNumber := "12345670"
+	response := BankAccountValidationResponse{Result: []BankAccountValidationResult{{Provider: "provider1", Error: ProviderErrorTimeout}}}
+	if receipt := config.issueReceipt(context.Background(), &BankAccountValidationRequest{AccountNumber: &accountNumber}, response); receipt != "" {
+		t.Errorf("issueReceipt() = %s, want none when nobody answered", receipt)
+	}
+}
+
+func TestReceiptsConfig_validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		receipts *ReceiptsConfig
+		wantErr  bool
+	}{
+		{"none", nil, false},
+		{"valid", &ReceiptsConfig{Keys: []*SigningKey{{ID: "a", Secret: "secret"}}}, false},
+		{"noKeys", &ReceiptsConfig{}, true},
+		{"duplicate", &ReceiptsConfig{Keys: []*SigningKey{{ID: "a"}, {ID: "a"}}}, true},
+		{"negativeTTL", &ReceiptsConfig{TTLSeconds: -1, Keys: []*SigningKey{{ID: "a"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.receipts.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
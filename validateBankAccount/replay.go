@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+  Signed requests. Partner facing deployments can insist every request is signed with an HMAC-SHA256, hex encoded,
+  over the timestamp, method, path, query string and body, one per line:
+
+    <unix timestamp>\n<method>\n<path>\n<query string>\n<body>
+
+  The query string is its parameters sorted by name and URL encoded, as url.Values.Encode writes them, since API
+  Gateway doesn't pass on the one that was sent. It's empty when there are none. Signing the body alone, like we sign
+  ours to providers (see signing.go), would let a signed request be replayed to another route or with other query
+  parameters.
+
+    X-Signature: 5d41402abc4b2a76b9719d911017c592...
+    X-Timestamp: 1717232400
+    X-Signature-Key-Id: partner-a      # optional, otherwise every key is tried
+
+    security:
+      requestSignatures:
+        windowSeconds: 300             # how far the timestamp can be from our clock, default 300
+        keys:
+          - id: partner-a
+            secretEnv: PARTNER_A_KEY
+          - id: partner-b
+            secretId: accountvalidator/partner-b
+            secretKey: hmacKey
+
+  A request without a good signature, with a timestamp outside the window or with a signature we've already seen
+  inside the window gets a 401 and an auth_failure security event (see security.go). Seen signatures are remembered
+  in the store (see store.go), so every container sees them with RATE_LIMIT_TABLE set. Without it they're remembered
+  per container, and a replay that lands on another container within the window gets through, the window keeps that
+  short. Batch streams through the function URL are authenticated by IAM instead.
+*/
+
+const (
+	requestTimestampHeader = "X-Timestamp"
+	defaultSignatureWindow = 5 * time.Minute
+)
+
+var (
+	errUnsigned          = errors.New("request isn't signed")
+	errStaleSignature    = errors.New("request timestamp is outside the window")
+	errBadSignature      = errors.New("request signature doesn't match")
+	errReplayedSignature = errors.New("request signature has been seen before")
+)
+
+type RequestSignaturesConfig struct {
+	WindowSeconds int           `yaml:"windowSeconds"`
+	Keys          []*SigningKey `yaml:"keys"`
+}
+
+func (signatures *RequestSignaturesConfig) validate() error {
+	if signatures == nil {
+		return nil
+	}
+	if signatures.WindowSeconds < 0 {
+		return errors.New("requestSignatures windowSeconds can't be negative")
+	}
+	if len(signatures.Keys) == 0 {
+		return errors.New("requestSignatures needs at least one key")
+	}
+	ids := map[string]bool{}
+	for _, key := range signatures.Keys {
+		if err := key.validate(); err != nil {
+			return err
+		}
+		if ids[key.ID] {
+			return fmt.Errorf("requestSignatures has two keys with the id %s", key.ID)
+		}
+		ids[key.ID] = true
+	}
+	return nil
+}
+
+func (signatures *RequestSignaturesConfig) window() time.Duration {
+	if signatures.WindowSeconds == 0 {
+		return defaultSignatureWindow
+	}
+	return time.Duration(signatures.WindowSeconds) * time.Second
+}
+
+// Signatures seen inside the window, in the store's idempotency keys (see store.go)
+type replayCache struct {
+	backend IdempotencyStore
+	now     func() time.Time
+}
+
+// Keeps the signatures in memory until setupStore gives it a store
+func newReplayCache() *replayCache {
+	return &replayCache{backend: newMemoryKeys(), now: time.Now}
+}
+
+// Remembers the signature until expires, false if it was already remembered. When the store can't say the request
+// goes ahead, the timestamp window still applies.
+func (cache *replayCache) remember(ctx context.Context, signature string, expires time.Time) bool {
+	remembered, err := cache.backend.Remember(ctx, "signature#"+signature, cache.now(), expires)
+	if err != nil {
+		log.Printf("unable to check the signature for replays: %v", err)
+		return true
+	}
+	return remembered
+}
+
+// Looks a header up whatever its case, API Gateway doesn't normalise header case
+func requestHeader(headers map[string]string, name string) string {
+	for header, value := range headers {
+		if strings.EqualFold(header, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// The signature of the request as the caller should have made it
+func requestSignature(secret string, timestamp int64, request Request) string {
+	query := url.Values{}
+	for name, value := range request.QueryStringParameters {
+		query.Set(name, value)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "\n" + request.HTTPMethod + "\n" + request.Path + "\n" + query.Encode() + "\n"))
+	mac.Write([]byte(request.Body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks the request's signature, nil when it's good
+func (config *Config) verifySignature(ctx context.Context, request Request) error {
+	signatures := config.Security.RequestSignatures
+	given := requestHeader(request.Headers, defaultSignatureHeader)
+	timestampHeader := requestHeader(request.Headers, requestTimestampHeader)
+	if given == "" || timestampHeader == "" {
+		return errUnsigned
+	}
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return errStaleSignature
+	}
+	now := config.replays.now()
+	signedAt := time.Unix(timestamp, 0)
+	window := signatures.window()
+	if signedAt.Before(now.Add(-window)) || signedAt.After(now.Add(window)) {
+		return errStaleSignature
+	}
+	keyID := requestHeader(request.Headers, signatureKeyIDHeader)
+	matched := false
+	for _, key := range signatures.Keys {
+		if keyID != "" && key.ID != keyID {
+			continue
+		}
+		secret, err := key.secret(ctx)
+		if err != nil || secret == "" {
+			continue
+		}
+		if hmac.Equal([]byte(requestSignature(secret, timestamp, request)), []byte(strings.ToLower(given))) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return errBadSignature
+	}
+	// Nothing signed before now-window gets this far, so it can be forgotten once that's passed
+	if !config.replays.remember(ctx, strings.ToLower(given), signedAt.Add(window)) {
+		return errReplayedSignature
+	}
+	return nil
+}
+
+// The 401 for a request without a good signature, nil when it can go ahead or signatures aren't required
+func (config *Config) enforceSignature(ctx context.Context, request Request) *Response {
+	if config.Security.RequestSignatures == nil || ctx.Value(signatureVerifiedKey{}) != nil {
+		return nil
+	}
+	err := config.verifySignature(ctx, request)
+	if err == nil {
+		return nil
+	}
+	config.security.report(ctx, request, SecurityAuthFailure, err.Error())
+	response, _ := jsonResponse(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	return &response
+}
+
+type signatureVerifiedKey struct{}
+
+// Signatures for the streaming modes, batch streams are left to IAM
+func (config *Config) signatureVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.Security.RequestSignatures == nil || isBatchRequest(r.Method, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		request, err := toRequest(r)
+		if err != nil {
+			writeResponse(w, *handleError(err, "unable to read request"))
+			return
+		}
+		if response := config.enforceSignature(r.Context(), request); response != nil {
+			writeResponse(w, *response)
+			return
+		}
+		// Checked once, the router would see it as a replay
+		r.Body = io.NopCloser(bytes.NewReader([]byte(request.Body)))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signatureVerifiedKey{}, true)))
+	})
+}
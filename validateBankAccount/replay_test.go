@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signedConfig(now time.Time) *Config {
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		Security: SecurityConfig{RequestSignatures: &RequestSignaturesConfig{Keys: []*SigningKey{
+			{ID: "partner-a", Secret: "secret-a"},
+			{ID: "partner-b", Secret: "secret-b"},
+		}}},
+		replays: newReplayCache(),
+	}
+	config.replays.now = func() time.Time { return now }
+	return config
+}
+
+func signedRequest(secret, keyID string, signedAt time.Time, body string) Request {
+	request := Request{HTTPMethod: "POST", Path: "/application", Body: body}
+	request.Headers = map[string]string{
+		"x-signature": requestSignature(secret, signedAt.Unix(), request),
+		"X-Timestamp": strconv.FormatInt(signedAt.Unix(), 10),
+	}
+	if keyID != "" {
+		request.Headers[signatureKeyIDHeader] = keyID
+	}
+	return request
+}
+
+func TestConfig_verifySignature(t *testing.T) {
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	body := "{\"accountNumber\": \"12345670\"}"
+	tampered := signedRequest("secret-a", "", now, body)
+	tampered.Body = "{\"accountNumber\": \"12345671\"}"
+	rerouted := signedRequest("secret-a", "", now, body)
+	rerouted.Path = "/validate-matrix"
+	requeried := signedRequest("secret-a", "", now, body)
+	requeried.QueryStringParameters = map[string]string{"profile": "v2"}
+	queried := Request{HTTPMethod: "GET", Path: "/providers", QueryStringParameters: map[string]string{"strategy": "all", "providers": "provider1"}}
+	queried.Headers = map[string]string{
+		"X-Signature": requestSignature("secret-a", now.Unix(), queried),
+		"X-Timestamp": strconv.FormatInt(now.Unix(), 10),
+	}
+	tests := []struct {
+		name    string
+		request Request
+		want    error
+	}{
+		{"signed", signedRequest("secret-a", "", now, body), nil},
+		{"secondKey", signedRequest("secret-b", "", now.Add(-time.Minute), body), nil},
+		{"keyID", signedRequest("secret-b", "partner-b", now, body), nil},
+		{"wrongKeyID", signedRequest("secret-b", "partner-a", now, body), errBadSignature},
+		{"unknownSecret", signedRequest("secret-c", "", now, body), errBadSignature},
+		{"tampered", tampered, errBadSignature},
+		{"rerouted", rerouted, errBadSignature},
+		{"requeried", requeried, errBadSignature},
+		{"query", queried, nil},
+		{"stale", signedRequest("secret-a", "", now.Add(-6*time.Minute), body), errStaleSignature},
+		{"future", signedRequest("secret-a", "", now.Add(6*time.Minute), body), errStaleSignature},
+		{"unsigned", Request{HTTPMethod: "POST", Body: body}, errUnsigned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := signedConfig(now).verifySignature(context.Background(), tt.request); err != tt.want {
+				t.Errorf("verifySignature() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_requestSignature(t *testing.T) {
+	request := Request{HTTPMethod: "GET", Path: "/providers", QueryStringParameters: map[string]string{"strategy": "all", "providers": "provider1"}}
+	mac := hmac.New(sha256.New, []byte("secret-a"))
+	mac.Write([]byte("1717232400\nGET\n/providers\nproviders=provider1&strategy=all\n"))
+	if got, want := requestSignature("secret-a", 1717232400, request), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("requestSignature() = %s, want %s", got, want)
+	}
+}
+
+func TestConfig_Router_replay(t *testing.T) {
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	config := signedConfig(now)
+	out := &bytes.Buffer{}
+	config.security = newSecurityLog(SecurityConfig{}, nil, out)
+	request := signedRequest("secret-a", "partner-a", now, "{\"accountNumber\": \"12345670\"}")
+
+	if response, _ := config.Router(context.Background(), request); response.StatusCode != 200 {
+		t.Fatalf("Router() = %d %s, want a 200", response.StatusCode, response.Body)
+	}
+	response, _ := config.Router(context.Background(), request)
+	if response.StatusCode != 401 || response.Body != "{\"error\":\"request signature has been seen before\"}" {
+		t.Errorf("replayed Router() = %d %s, want a 401", response.StatusCode, response.Body)
+	}
+	if !strings.Contains(out.String(), "\"type\":\"auth_failure\"") {
+		t.Errorf("log = %q, want an auth_failure event", out.String())
+	}
+
+	// Once the window has passed the timestamp is stale, so forgetting the signature is safe
+	config.replays.now = func() time.Time { return now.Add(6 * time.Minute) }
+	if !config.replays.remember(context.Background(), "other", now.Add(time.Minute)) {
+		t.Error("remember() should take a new signature")
+	}
+	if response, _ := config.Router(context.Background(), request); response.Body != "{\"error\":\"request timestamp is outside the window\"}" {
+		t.Errorf("late Router() = %s", response.Body)
+	}
+}
+
+func TestConfig_streamingHandler_signatures(t *testing.T) {
+	config := signedConfig(time.Now())
+	server := httptest.NewServer(config.streamingHandler())
+	defer server.Close()
+
+	body := "{\"accountNumber\": \"12345670\"}"
+	signed := signedRequest("secret-a", "", time.Now(), body)
+	for _, want := range []int{200, 401} {
+		request, _ := http.NewRequest("POST", server.URL+"/application", strings.NewReader(body))
+		for name, value := range signed.Headers {
+			request.Header.Set(name, value)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != want {
+			t.Errorf("status = %d, want %d", response.StatusCode, want)
+		}
+	}
+}
+
+func TestRequestSignaturesConfig_validate(t *testing.T) {
+	tests := []struct {
+		name       string
+		signatures *RequestSignaturesConfig
+		wantErr    bool
+	}{
+		{"none", nil, false},
+		{"valid", &RequestSignaturesConfig{Keys: []*SigningKey{{ID: "a", Secret: "s"}}}, false},
+		{"noKeys", &RequestSignaturesConfig{}, true},
+		{"duplicateIDs", &RequestSignaturesConfig{Keys: []*SigningKey{{ID: "a", Secret: "s"}, {ID: "a", Secret: "t"}}}, true},
+		{"noSecret", &RequestSignaturesConfig{Keys: []*SigningKey{{ID: "a"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.signatures.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
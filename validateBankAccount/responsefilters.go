@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+  Response filters. Some callers shouldn't see everything we know, a reporting integration only needs the aggregate
+  boolean, not which providers said what. Filters name the callers (as in security events, see callerIdentity) and
+  the response fields they get:
+
+    responseFilters:
+      - callers: [client-42, "arn:aws:iam::123456789012:user/reporting"]
+        fields: [aggregate, clientReference]
+
+  Fields are the top level response fields, result, aggregate, metadata, previousResult (with changedAt),
+  clientReference, warnings, estimatedCost, receipt, noProvidersMatched (with unknownProviders) and partial.
+  Anything not listed is left out when the response is serialised, a hidden result comes back as an empty list so
+  the shape doesn't change. Callers without a filter see everything. It applies to the validate, batch, graphql and websocket routes, the streaming modes aren't behind API
+  Gateway and have no caller to filter on. Audit records and verdicts are written from the full response either way.
+*/
+
+const (
+	ResponseFieldResult          = "result"
+	ResponseFieldAggregate       = "aggregate"
+	ResponseFieldMetadata        = "metadata"
+	ResponseFieldPreviousResult  = "previousResult"
+	ResponseFieldClientReference = "clientReference"
+	ResponseFieldWarnings        = "warnings"
+	ResponseFieldEstimatedCost   = "estimatedCost"
+	ResponseFieldReceipt         = "receipt"
+	ResponseFieldNoProviders     = "noProvidersMatched"
+	ResponseFieldPartial         = "partial"
+)
+
+var responseFields = map[string]bool{
+	ResponseFieldResult:          true,
+	ResponseFieldAggregate:       true,
+	ResponseFieldMetadata:        true,
+	ResponseFieldPreviousResult:  true,
+	ResponseFieldClientReference: true,
+	ResponseFieldWarnings:        true,
+	ResponseFieldEstimatedCost:   true,
+	ResponseFieldReceipt:         true,
+	ResponseFieldNoProviders:     true,
+	ResponseFieldPartial:         true,
+}
+
+type ResponseFilter struct {
+	Callers []string `yaml:"callers"`
+	Fields  []string `yaml:"fields"`
+}
+
+// The fields each filtered caller sees, built once at load
+func compileResponseFilters(filters []ResponseFilter) (map[string]map[string]bool, error) {
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	visible := map[string]map[string]bool{}
+	for _, filter := range filters {
+		if len(filter.Callers) == 0 {
+			return nil, errors.New("responseFilters need at least one caller")
+		}
+		fields := map[string]bool{}
+		for _, field := range filter.Fields {
+			if !responseFields[field] {
+				return nil, fmt.Errorf("responseFilters has unknown field %s", field)
+			}
+			fields[field] = true
+		}
+		for _, caller := range filter.Callers {
+			if _, exists := visible[caller]; exists {
+				return nil, fmt.Errorf("responseFilters has two filters for %s", caller)
+			}
+			visible[caller] = fields
+		}
+	}
+	return visible, nil
+}
+
+// The fields the caller sees, nil when it sees everything
+func (config *Config) visibleFields(request Request) map[string]bool {
+	if config.responseFields == nil {
+		return nil
+	}
+	return config.responseFields[callerIdentity(request)]
+}
+
+// The response as the caller is allowed to see it
+func filterResponse(fields map[string]bool, response BankAccountValidationResponse) BankAccountValidationResponse {
+	if fields == nil {
+		return response
+	}
+	if !fields[ResponseFieldResult] {
+		response.Result = []BankAccountValidationResult{}
+	}
+	if !fields[ResponseFieldAggregate] {
+		response.Aggregate = nil
+	}
+	if !fields[ResponseFieldMetadata] {
+		response.Metadata = nil
+	}
+	if !fields[ResponseFieldPreviousResult] {
+		response.PreviousResult, response.ChangedAt = "", nil
+	}
+	if !fields[ResponseFieldClientReference] {
+		response.ClientReference = ""
+	}
+	if !fields[ResponseFieldWarnings] {
+		response.Warnings = nil
+	}
+	if !fields[ResponseFieldEstimatedCost] {
+		response.EstimatedCost = nil
+	}
+	if !fields[ResponseFieldReceipt] {
+		response.Receipt = ""
+	}
+	if !fields[ResponseFieldNoProviders] {
+		response.NoProvidersMatched, response.UnknownProviders = false, nil
+	}
+	if !fields[ResponseFieldPartial] {
+		response.Partial = false
+	}
+	return response
+}
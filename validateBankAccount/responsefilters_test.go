@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func Test_compileResponseFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []ResponseFilter
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"valid", []ResponseFilter{{Callers: []string{"client-42"}, Fields: []string{"aggregate"}}}, false},
+		{"nothingVisible", []ResponseFilter{{Callers: []string{"client-42"}}}, false},
+		{"noCallers", []ResponseFilter{{Fields: []string{"aggregate"}}}, true},
+		{"unknownField", []ResponseFilter{{Callers: []string{"client-42"}, Fields: []string{"isValid"}}}, true},
+		{"twice", []ResponseFilter{{Callers: []string{"client-42"}}, {Callers: []string{"client-42"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileResponseFilters(tt.filters); (err != nil) != tt.wantErr {
+				t.Errorf("compileResponseFilters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_Router_responseFilters(t *testing.T) {
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		ResponseFilters: []ResponseFilter{
+			{Callers: []string{"client-42"}, Fields: []string{"aggregate"}},
+		},
+	}
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+	filtered := Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\", \"strategy\": \"all\", \"clientReference\": \"ref-1\"}"}
+	filtered.RequestContext.Authorizer = map[string]interface{}{"principalId": "client-42"}
+	everyone := filtered
+	everyone.RequestContext.Authorizer = map[string]interface{}{"principalId": "client-7"}
+	batch := filtered
+	batch.Path = "/validate-batch"
+	batch.Body = "{\"requests\": [{\"accountNumber\": \"12345670\", \"strategy\": \"all\"}]}"
+
+	tests := []struct {
+		name     string
+		request  Request
+		wantBody string
+	}{
+		{"filtered", filtered, "{\"result\":[],\"aggregate\":{\"strategy\":\"all\",\"isValid\":true}}"},
+		{"unfiltered", everyone, "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}],\"aggregate\":{\"strategy\":\"all\",\"isValid\":true},\"clientReference\":\"ref-1\"}"},
+		{"batch", batch, "{\"index\":0,\"response\":{\"result\":[],\"aggregate\":{\"strategy\":\"all\",\"isValid\":true}}}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := config.Router(context.Background(), tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Body != tt.wantBody {
+				t.Errorf("Router() = %s, want %s", response.Body, tt.wantBody)
+			}
+		})
+	}
+
+	// The v2 profile is built from what's left
+	filtered.Headers = map[string]string{"Accept": "application/json; profile=v2"}
+	response, _ := config.Router(context.Background(), filtered)
+	if !strings.Contains(response.Body, "\"results\":[]") {
+		t.Errorf("Router() = %s, want no results", response.Body)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+)
+
+/*
+  Response signing. Systems that store our verdict can check later that it's what we said, the response is signed
+  as a JWS with an asymmetric KMS key and the token goes in the response:
+
+    responseSigning:
+      keyId: arn:aws:kms:eu-west-1:123456789012:key/1234abcd-...   # a SIGN_VERIFY key
+      algorithm: ES256   # ES256 for an ECC_NIST_P256 key or RS256 for an RSA one, default ES256
+      kid: "2024-06"     # the kid in the JWS header, default the keyId
+
+    {"result": [...], "aggregate": {...}, "signature": {"kid": "2024-06", "jws": "eyJhbGciOiJFUzI1NiIsImtpZCI6..."}}
+
+  The JWS payload is the rest of the response as it was sent, in the v1 shape whichever profile was asked for, with
+  the account's hash (see accounthash.go) and the time it was signed added, so the token on its own is the record and
+  can't be passed off as the verdict on another account or a later check:
+
+    {"result": [...], "aggregate": {...}, "accountHash": "9f2c...", "iat": 1717232400}
+
+  Verify it with the key's public half (kms get-public-key), use its payload rather than the response around it and
+  check accountHash against the account it's offered for. What's signed is what the caller saw, response filters (see
+  responsefilters.go) apply first. It covers the validate and batch routes. A response that couldn't be signed goes
+  out without a signature and with a warning, rather than not at all.
+
+  Every signature is a KMS Sign call, and each request in a batch is signed on its own as its line is written, so a
+  batch of 1000 is 1000 calls. Asymmetric Sign has its own request quota per account and region (300 a second for
+  ECC keys and 500 for RSA ones by default), shared with anything else using those keys. Raise it before turning
+  signing on for callers that send big batches, or their responses will come back throttled, unsigned and warned.
+*/
+
+const (
+	JWSAlgorithmES256 = "ES256"
+	JWSAlgorithmRS256 = "RS256"
+)
+
+// The KMS signing algorithm for each JWS one
+var kmsSigningAlgorithms = map[string]string{
+	JWSAlgorithmES256: "ECDSA_SHA_256",
+	JWSAlgorithmRS256: "RSASSA_PKCS1_V1_5_SHA_256",
+}
+
+type ResponseSigningConfig struct {
+	KeyID     string `yaml:"keyId"`
+	Algorithm string `yaml:"algorithm"`
+	Kid       string `yaml:"kid"`
+}
+
+type ResponseSignature struct {
+	Kid string `json:"kid"`
+	JWS string `json:"jws"`
+}
+
+func (signing *ResponseSigningConfig) validate() error {
+	if signing == nil {
+		return nil
+	}
+	if signing.KeyID == "" {
+		return errors.New("responseSigning needs a keyId")
+	}
+	if _, exists := kmsSigningAlgorithms[signing.algorithm()]; !exists {
+		return fmt.Errorf("responseSigning algorithm %s isn't ES256 or RS256", signing.Algorithm)
+	}
+	return nil
+}
+
+func (signing *ResponseSigningConfig) algorithm() string {
+	if signing.Algorithm == "" {
+		return JWSAlgorithmES256
+	}
+	return signing.Algorithm
+}
+
+func (signing *ResponseSigningConfig) kid() string {
+	if signing.Kid == "" {
+		return signing.KeyID
+	}
+	return signing.Kid
+}
+
+// Makes compact JWS tokens, signDigest returns the signature in JWS form
+type jwsSigner struct {
+	algorithm  string
+	kid        string
+	signDigest func(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+func newKMSJWSSigner(client *kmsClient, signing *ResponseSigningConfig) *jwsSigner {
+	algorithm := signing.algorithm()
+	return &jwsSigner{
+		algorithm: algorithm,
+		kid:       signing.kid(),
+		signDigest: func(ctx context.Context, digest []byte) ([]byte, error) {
+			signature, err := client.sign(ctx, signing.KeyID, kmsSigningAlgorithms[algorithm], digest)
+			if err != nil || algorithm != JWSAlgorithmES256 {
+				return signature, err
+			}
+			return ecdsaJWSSignature(signature, 32)
+		},
+	}
+}
+
+// KMS gives ECDSA signatures DER encoded, JWS wants r and s side by side at the curve's size
+func ecdsaJWSSignature(der []byte, size int) ([]byte, error) {
+	var signature struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &signature); err != nil {
+		return nil, fmt.Errorf("unable to read the ecdsa signature: %w", err)
+	}
+	raw := make([]byte, 2*size)
+	signature.R.FillBytes(raw[:size])
+	signature.S.FillBytes(raw[size:])
+	return raw, nil
+}
+
+// A compact JWS of the payload
+func (signer *jwsSigner) token(ctx context.Context, payload []byte) (string, error) {
+	header, err := marshalJSON(map[string]string{"alg": signer.algorithm, "kid": signer.kid})
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := signer.signDigest(ctx, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// What the token says, the response and which account it was about when
+type signedResponse struct {
+	BankAccountValidationResponse
+	AccountHash string `json:"accountHash"`
+	IssuedAt    int64  `json:"iat"`
+}
+
+// Adds the signature to the response as it'll be sent
+func (config *Config) signResponse(ctx context.Context, validationRequest *BankAccountValidationRequest, response BankAccountValidationResponse) BankAccountValidationResponse {
+	if config.responseSigner == nil {
+		return response
+	}
+	response.Signature = nil
+	payload, err := marshalJSON(signedResponse{
+		BankAccountValidationResponse: response,
+		AccountHash:                   accountHash(*validationRequest.AccountNumber),
+		IssuedAt:                      time.Now().Unix(),
+	})
+	if err == nil {
+		var token string
+		if token, err = config.responseSigner.token(ctx, payload); err == nil {
+			response.Signature = &ResponseSignature{Kid: config.responseSigner.kid, JWS: token}
+			return response
+		}
+	}
+	log.Printf("unable to sign the response: %v", err)
+	config.telemetry.count("ResponseSigningFailed", 1)
+	response.Warnings = append(response.Warnings, "the response couldn't be signed")
+	return response
+}
+
+// Connects to KMS when responses are to be signed
+func (config *Config) setupResponseSigning(ctx context.Context) {
+	if config.ResponseSigning == nil {
+		return
+	}
+	client, err := newKMSClient(ctx)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	config.responseSigner = newKMSJWSSigner(client, config.ResponseSigning)
+}
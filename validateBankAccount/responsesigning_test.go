@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// A signer with a local key standing in for KMS
+func testJWSSigner(t *testing.T) (*jwsSigner, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &jwsSigner{
+		algorithm: JWSAlgorithmES256,
+		kid:       "2024-06",
+		signDigest: func(ctx context.Context, digest []byte) ([]byte, error) {
+			der, err := ecdsa.SignASN1(rand.Reader, key, digest)
+			if err != nil {
+				return nil, err
+			}
+			return ecdsaJWSSignature(der, 32)
+		},
+	}, key
+}
+
+// Checks an ES256 token against the key, returning its payload
+func verifyES256(t *testing.T, token string, key *ecdsa.PublicKey) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("%s isn't a compact JWS", token)
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if len(signature) != 64 || !ecdsa.Verify(key, digest[:], r, s) {
+		t.Fatalf("%s has a bad signature", token)
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	if string(header) != "{\"alg\":\"ES256\",\"kid\":\"2024-06\"}" {
+		t.Errorf("header = %s", header)
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	return string(payload)
+}
+
+func TestConfig_Handler_responseSigning(t *testing.T) {
+	signer, key := testJWSSigner(t)
+	config := &Config{
+		Providers:      []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		responseSigner: signer,
+	}
+	response, err := config.Handler(context.Background(), Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\"}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body BankAccountValidationResponse
+	if err := json.Unmarshal([]byte(response.Body), &body); err != nil || body.Signature == nil || body.Signature.Kid != "2024-06" {
+		t.Fatalf("Handler() = %s, want a signature", response.Body)
+	}
+	payload := verifyES256(t, body.Signature.JWS, &key.PublicKey)
+	var signed struct {
+		Result      []BankAccountValidationResult `json:"result"`
+		AccountHash string                        `json:"accountHash"`
+		IssuedAt    int64                         `json:"iat"`
+	}
+	if err := json.Unmarshal([]byte(payload), &signed); err != nil || len(signed.Result) != 1 || signed.AccountHash != accountHash("12345670") ||
+		time.Since(time.Unix(signed.IssuedAt, 0)) > time.Minute {
+		t.Errorf("payload = %s, want the result with the account hash and when it was signed", payload)
+	}
+
+	// Still answered when KMS is down
+	config.responseSigner.signDigest = func(ctx context.Context, digest []byte) ([]byte, error) { return nil, errors.New("throttled") }
+	response, _ = config.Handler(context.Background(), Request{HTTPMethod: "POST", Body: "{\"accountNumber\": \"12345670\"}"})
+	if want := "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}],\"warnings\":[\"the response couldn't be signed\"]}"; response.Body != want {
+		t.Errorf("Handler() = %s, want %s", response.Body, want)
+	}
+}
+
+func Test_kmsClient_sign(t *testing.T) {
+	var input map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &input)
+		if r.Header.Get("X-Amz-Target") != "TrentService.Sign" || !strings.Contains(r.Header.Get("Authorization"), "/kms/aws4_request") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("{\"KeyId\": \"key-1\", \"Signature\": \"c2lnbmF0dXJl\"}"))
+	}))
+	defer server.Close()
+	client := &kmsClient{api: kms.New(kms.Options{
+		Region:       "eu-west-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		BaseEndpoint: aws.String(server.URL),
+	})}
+	signature, err := client.sign(context.Background(), "key-1", "ECDSA_SHA_256", []byte("digest"))
+	if err != nil || string(signature) != "signature" {
+		t.Errorf("sign() = %q, %v", signature, err)
+	}
+	if input["MessageType"] != "DIGEST" || input["Message"] != base64.StdEncoding.EncodeToString([]byte("digest")) {
+		t.Errorf("input = %v", input)
+	}
+}
+
+func TestResponseSigningConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		signing *ResponseSigningConfig
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"default", &ResponseSigningConfig{KeyID: "alias/responses"}, false},
+		{"rsa", &ResponseSigningConfig{KeyID: "alias/responses", Algorithm: JWSAlgorithmRS256}, false},
+		{"noKey", &ResponseSigningConfig{}, true},
+		{"hmac", &ResponseSigningConfig{KeyID: "alias/responses", Algorithm: "HS256"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.signing.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
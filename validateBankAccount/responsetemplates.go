@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"text/template"
+)
+
+/*
+  Response templates. A legacy consumer that wants its own field names can get them from us rather than from a proxy
+  in front of us. Templates name the callers (as in security events, see callerIdentity) and how their response is
+  written, with the same json function as request templates (see provider.go):
+
+    responseTemplates:
+      - callers: [legacy-crm]
+        template: |
+          {"valid": {{with .aggregate}}{{json .isValid}}{{else}}false{{end}},
+           "ref": {{json .clientReference}},
+           "checks": [{{range $i, $r := .result}}{{if $i}},{{end}}
+             {"source": {{json $r.provider}}, "ok": {{json $r.isValid}}}{{end}}]}
+
+  The template sees the response as everyone else gets it, after response filters and signing, with the field
+  names of the JSON. A field that isn't in the response comes out as null, and anything under it needs a with or if
+  around it since there's nothing to look in. What it writes has to be JSON, and is sent as application/json whatever
+  profile was asked for. It only applies to the validate route, batch, matrix, graphql and websocket responses keep
+  their shape. The response signature is over the canonical response, so a template that passes it on can't be
+  checked against what it writes.
+*/
+
+type ResponseTemplate struct {
+	Callers  []string `yaml:"callers"`
+	Template string   `yaml:"template"`
+}
+
+// Each templated caller's template, parsed once at load
+func compileResponseTemplates(templates []ResponseTemplate) (map[string]*template.Template, error) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	parsed := map[string]*template.Template{}
+	for i, responseTemplate := range templates {
+		if len(responseTemplate.Callers) == 0 {
+			return nil, errors.New("responseTemplates need at least one caller")
+		}
+		if responseTemplate.Template == "" {
+			return nil, fmt.Errorf("responseTemplates for %s needs a template", responseTemplate.Callers[0])
+		}
+		tmpl, err := template.New(fmt.Sprintf("responseTemplates[%d]", i)).Funcs(templateFuncs).Option("missingkey=zero").Parse(responseTemplate.Template)
+		if err != nil {
+			return nil, fmt.Errorf("responseTemplates for %s: %w", responseTemplate.Callers[0], err)
+		}
+		for _, caller := range responseTemplate.Callers {
+			if _, exists := parsed[caller]; exists {
+				return nil, fmt.Errorf("responseTemplates has two templates for %s", caller)
+			}
+			parsed[caller] = tmpl
+		}
+	}
+	return parsed, nil
+}
+
+// The response body and its content type, from the caller's template when it has one
+func (config *Config) marshalFor(request Request, profile string, response BankAccountValidationResponse) (string, string, error) {
+	tmpl := config.responseTemplates[callerIdentity(request)]
+	if tmpl == nil {
+		return marshalProfile(profile, response)
+	}
+	canonical, err := marshalResponse(response)
+	if err != nil {
+		return "", "", err
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(canonical), &data); err != nil {
+		return "", "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return "", "", fmt.Errorf("the response template for %s did not produce valid json", callerIdentity(request))
+	}
+	return buf.String(), "application/json", nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_compileResponseTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates []ResponseTemplate
+		wantErr   bool
+	}{
+		{"none", nil, false},
+		{"valid", []ResponseTemplate{{Callers: []string{"legacy-crm"}, Template: `{"valid": {{json .aggregate.isValid}}}`}}, false},
+		{"noCallers", []ResponseTemplate{{Template: `{}`}}, true},
+		{"noTemplate", []ResponseTemplate{{Callers: []string{"legacy-crm"}}}, true},
+		{"badTemplate", []ResponseTemplate{{Callers: []string{"legacy-crm"}, Template: `{"valid": {{json .aggregate.isValid}`}}, true},
+		{"twice", []ResponseTemplate{{Callers: []string{"legacy-crm"}, Template: `{}`}, {Callers: []string{"legacy-crm"}, Template: `{}`}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileResponseTemplates(tt.templates); (err != nil) != tt.wantErr {
+				t.Errorf("compileResponseTemplates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_Router_responseTemplates(t *testing.T) {
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		ResponseTemplates: []ResponseTemplate{
+			{Callers: []string{"legacy-crm"}, Template: `{"valid": {{with .aggregate}}{{json .isValid}}{{else}}null{{end}}, "ref": {{json .clientReference}}, ` +
+				`"checks": [{{range $i, $r := .result}}{{if $i}},{{end}}{"source": {{json $r.provider}}, "ok": {{json $r.isValid}}}{{end}}]}`},
+			{Callers: []string{"broken"}, Template: `{"valid": {{.aggregate.isValid}}`},
+		},
+		ResponseFilters: []ResponseFilter{
+			{Callers: []string{"broken"}, Fields: []string{"aggregate"}},
+		},
+	}
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+	request := func(caller, body string) Request {
+		request := Request{HTTPMethod: "POST", Path: "/application", Body: body}
+		request.RequestContext.Authorizer = map[string]interface{}{"principalId": caller}
+		return request
+	}
+	tests := []struct {
+		name     string
+		request  Request
+		wantBody string
+		wantErr  bool
+	}{
+		{"templated", request("legacy-crm", `{"accountNumber": "12345670", "strategy": "all", "clientReference": "ref-1"}`), `{"valid": true, "ref": "ref-1", "checks": [{"source": "provider1", "ok": true}]}`, false},
+		{"missing fields", request("legacy-crm", `{"accountNumber": "12345671"}`), `{"valid": null, "ref": null, "checks": [{"source": "provider1", "ok": false}]}`, false},
+		{"canonical", request("client-7", `{"accountNumber": "12345670"}`), `{"result":[{"provider":"provider1","isValid":true}]}`, false},
+		{"not json", request("broken", `{"accountNumber": "12345670", "strategy": "all"}`), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := config.Router(context.Background(), tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Router() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (response.Body != tt.wantBody || response.Headers["Content-Type"] != "application/json") {
+				t.Errorf("Router() = %s %v, want %s", response.Body, response.Headers, tt.wantBody)
+			}
+		})
+	}
+}
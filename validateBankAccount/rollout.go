@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+)
+
+/*
+  Blue/green cutover to a partner's new endpoint. A provider with a rollout sends percent of its calls to the next url
+  and the rest to its usual one:
+
+    - name: provider1
+      url: https://provider1.com/v1/validate
+      rollout:
+        url: https://provider1.com/v2/validate
+        percent: 10      # 0 to 100
+
+  The split is on a hash of the account number, so an account always goes the same way and a bad answer can be
+  reproduced. Calls to each side are counted separately and GET /rollouts compares their error rates and latency
+  over the stats window:
+
+    {"rollouts":[{"provider":"provider1","percent":10,
+      "current":{"url":"https://provider1.com/v1/validate","calls":90,"errors":1,"errorRate":0.011,"p50Ms":80,"p95Ms":140},
+      "next":{"url":"https://provider1.com/v2/validate","calls":10,"errors":0,"errorRate":0,"p50Ms":95,"p95Ms":150}}]}
+
+  Turn percent up as confidence grows. To finish, make the next url the provider's url and drop the rollout. The
+  breaker is shared by both sides. Extra endpoints keep being picked by latency for the current side only.
+*/
+
+type RolloutConfig struct {
+	URL     string `yaml:"url"`
+	Percent int    `yaml:"percent"`
+}
+
+// Call stats for each side of a rollout, for the life of the container
+type rolloutStats struct {
+	current *providerStats
+	next    *providerStats
+}
+
+type RolloutSide struct {
+	URL       string  `json:"url"`
+	Calls     int     `json:"calls"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"errorRate"`
+	P50Ms     int64   `json:"p50Ms"`
+	P95Ms     int64   `json:"p95Ms"`
+}
+
+type RolloutReport struct {
+	Provider string      `json:"provider"`
+	Percent  int         `json:"percent"`
+	Current  RolloutSide `json:"current"`
+	Next     RolloutSide `json:"next"`
+}
+
+func (rollout *RolloutConfig) validate() error {
+	if rollout == nil {
+		return nil
+	}
+	if rollout.URL == "" {
+		return fmt.Errorf("rollout is missing a url")
+	}
+	if rollout.Percent < 0 || rollout.Percent > 100 {
+		return fmt.Errorf("rollout percent %d should be between 0 and 100", rollout.Percent)
+	}
+	return nil
+}
+
+// Whether the account's calls go to the next url
+func (rollout *RolloutConfig) takes(accountNumber string) bool {
+	if rollout == nil || rollout.Percent == 0 {
+		return false
+	}
+	hash := sha256.Sum256([]byte(accountNumber))
+	return binary.BigEndian.Uint64(hash[:8])%100 < uint64(rollout.Percent)
+}
+
+// The url for a call about the account, and whether it's the rollout's next url
+func (provider Provider) target(accountNumber string) (string, bool) {
+	if provider.Rollout.takes(accountNumber) {
+		return provider.Rollout.URL, true
+	}
+	return provider.endpoint(), false
+}
+
+func newRolloutStats(window time.Duration) *rolloutStats {
+	return &rolloutStats{current: newProviderStats(window), next: newProviderStats(window)}
+}
+
+// A nil rollout ignores everything
+func (stats *rolloutStats) record(next bool, latency time.Duration, errorCode string) {
+	if stats == nil {
+		return
+	}
+	if next {
+		stats.next.record(latency, errorCode)
+	} else {
+		stats.current.record(latency, errorCode)
+	}
+}
+
+func rolloutSide(url string, stats *providerStats) RolloutSide {
+	snapshot := stats.snapshot(0)
+	return RolloutSide{
+		URL:       url,
+		Calls:     snapshot.Calls,
+		Errors:    snapshot.Errors,
+		ErrorRate: snapshot.ErrorRate,
+		P50Ms:     snapshot.P50.Milliseconds(),
+		P95Ms:     snapshot.P95.Milliseconds(),
+	}
+}
+
+func (config *Config) rollouts() []RolloutReport {
+	reports := []RolloutReport{}
+	for _, provider := range config.Providers {
+		if provider.Rollout == nil {
+			continue
+		}
+		report := RolloutReport{Provider: provider.Name, Percent: provider.Rollout.Percent}
+		if provider.rollout != nil {
+			report.Current = rolloutSide(provider.URL, provider.rollout.current)
+			report.Next = rolloutSide(provider.Rollout.URL, provider.rollout.next)
+		} else {
+			report.Current = RolloutSide{URL: provider.URL}
+			report.Next = RolloutSide{URL: provider.Rollout.URL}
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Provider < reports[j].Provider })
+	return reports
+}
+
+// Handler for GET /rollouts
+func (config *Config) RolloutsHandler(ctx context.Context, request Request) (Response, error) {
+	return jsonResponse(200, map[string][]RolloutReport{"rollouts": config.rollouts()})
+}
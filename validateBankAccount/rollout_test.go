@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRolloutConfig_takes(t *testing.T) {
+	for _, percent := range []int{0, 10, 50, 100} {
+		rollout := &RolloutConfig{URL: "https://next", Percent: percent}
+		taken := 0
+		for i := 0; i < 10000; i++ {
+			account := fmt.Sprintf("%08d", i)
+			if rollout.takes(account) {
+				taken++
+			}
+			if rollout.takes(account) != rollout.takes(account) {
+				t.Fatalf("account %s went both ways", account)
+			}
+		}
+		// Within a couple of percent of what was asked for
+		if got := taken / 100; got < percent-2 || got > percent+2 {
+			t.Errorf("percent %d sent %d%% to the next url", percent, got)
+		}
+	}
+	var none *RolloutConfig
+	if none.takes("12345678") {
+		t.Error("no rollout should never take a call")
+	}
+}
+
+func TestConfig_rollouts(t *testing.T) {
+	newServer := func(body string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	current := newServer("{\"isValid\": true}")
+	next := newServer("not json")
+
+	config := &Config{Providers: []Provider{
+		{Name: "provider1", URL: current.URL, Rollout: &RolloutConfig{URL: next.URL, Percent: 50}},
+		{Name: "provider2", Type: ProviderTypeSimulated},
+	}}
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+	config.setupProviders()
+	for i := 0; i < 40; i++ {
+		account := fmt.Sprintf("%08d", i)
+		result := checkProviders(context.Background(), account, config.Providers[:1]).Result[0]
+		// The next url answers with junk so we can tell which side took the call
+		if wantNext := config.Providers[0].Rollout.takes(account); wantNext != (result.Error == ProviderErrorResponse) {
+			t.Errorf("account %s: result %+v, expected next url %v", account, result, wantNext)
+		}
+	}
+
+	response, _ := config.Router(context.Background(), Request{HTTPMethod: "GET", Path: "/rollouts"})
+	var body struct {
+		Rollouts []RolloutReport `json:"rollouts"`
+	}
+	if err := json.Unmarshal([]byte(response.Body), &body); err != nil || len(body.Rollouts) != 1 {
+		t.Fatalf("GET /rollouts = %v, %v", response.Body, err)
+	}
+	report := body.Rollouts[0]
+	if report.Current.Calls+report.Next.Calls != 40 || report.Current.Errors != 0 || report.Next.Errors != report.Next.Calls || report.Next.ErrorRate != 1 {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+func TestRolloutConfig_validate(t *testing.T) {
+	for _, rollout := range []*RolloutConfig{{Percent: 10}, {URL: "https://next", Percent: 101}, {URL: "https://next", Percent: -1}} {
+		if rollout.validate() == nil {
+			t.Errorf("validate(%+v) should fail", rollout)
+		}
+	}
+}
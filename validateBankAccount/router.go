@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+/*
+  Everything sits behind one function, so the Lambda entry point picks the handler from the method and path. Anything
+  that isn't a known route is a validation request, which is what the function has always done.
+
+  The routes are the table below. A request is matched on its API Gateway resource when it has one, so a route's
+  path parameters come from API Gateway, and on its path otherwise, which is what a {proxy+} integration or a
+  function URL gives us. Paths are matched on their last segments so a stage prefix (/dev/capabilities) doesn't
+  matter, and a {name} segment matches anything and ends up in PathParameters. Adding an endpoint is a line here and
+  nothing in serverless.yml, the {proxy+} event sends everything to us. GET /openapi.json is built from the same
+  table. Routes under /admin/ skip the data plane's checks for their own, see admin.go.
+*/
+
+type route struct {
+	method  string
+	path    string
+	summary string
+	handler func(config *Config, ctx context.Context, request Request) (Response, error)
+}
+
+// A func rather than a var as the openapi handler reads it
+func routeTable() []route {
+	return []route{
+		{"POST", "/application", "Validates a bank account", (*Config).Handler},
+		{"POST", "/validate-batch", "Validates a batch of bank accounts", (*Config).BatchHandler},
+		{"POST", "/validate-matrix", "Validates bank accounts against every provider", (*Config).MatrixHandler},
+		{"POST", "/validate-request", "Checks a validation request without calling any provider", (*Config).DryRunHandler},
+		{"POST", "/graphql", "Validations over GraphQL", (*Config).GraphQLHandler},
+		{"GET", "/capabilities", "What this deployment supports", (*Config).CapabilitiesHandler},
+		{"GET", "/rollouts", "The rollouts in progress", (*Config).RolloutsHandler},
+		{"GET", "/health", "Whether the function can validate anything", (*Config).HealthHandler},
+		{"GET", "/providers", "The providers and their circuit breakers", (*Config).ProvidersHandler},
+		{"GET", "/providers/{name}/sla", "A provider's availability over the last 30 days", (*Config).SLAHandler},
+		{"POST", "/receipts/verify", "Verifies a validation receipt", (*Config).ReceiptVerifyHandler},
+		{"POST", "/feedback", "Reports a validation that turned out wrong", (*Config).FeedbackHandler},
+		{"DELETE", "/admin/cache", "Empties this container's caches", (*Config).CacheHandler},
+		{"POST", "/admin/providers/{name}/disable", "Takes a provider out of rotation in every container", (*Config).DisableProviderHandler},
+		{"POST", "/admin/providers/{name}/enable", "Puts a disabled provider back to what the config says", (*Config).EnableProviderHandler},
+		{"GET", "/admin/circuits", "Every provider's circuit breaker in this container", (*Config).CircuitsHandler},
+		{"POST", "/admin/providers/{name}/circuit/open", "Forces a provider's circuit breaker open in every container", (*Config).OpenCircuitHandler},
+		{"POST", "/admin/providers/{name}/circuit/close", "Forces a provider's circuit breaker closed in every container", (*Config).CloseCircuitHandler},
+		{"DELETE", "/admin/providers/{name}/circuit", "Hands a provider's circuit breaker back to its errors", (*Config).ReleaseCircuitHandler},
+		{"GET", "/admin/config/history", "Every runtime config change, newest first", (*Config).ConfigHistoryHandler},
+		{"POST", "/admin/config/rollback/{revision}", "Puts the runtime config back how it was after a revision", (*Config).ConfigRollbackHandler},
+		{"GET", "/openapi.json", "This document", (*Config).OpenAPIHandler},
+	}
+}
+
+// The path parameters if the path's last segments match the pattern's
+func matchPath(pattern, path string) (map[string]string, bool) {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(pathParts) < len(patternParts) {
+		return nil, false
+	}
+	pathParts = pathParts[len(pathParts)-len(patternParts):]
+	params := map[string]string{}
+	for i, part := range patternParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			params[strings.Trim(part, "{}")] = pathParts[i]
+		} else if part != pathParts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// The route for the request, nil for the default, and the request with the route's path parameters
+func (config *Config) match(request Request) (*route, Request) {
+	path := request.Path
+	byResource := request.Resource != "" && !strings.Contains(request.Resource, "+}")
+	if byResource {
+		path = request.Resource
+	}
+	routes := routeTable()
+	for i := range routes {
+		if routes[i].method != request.HTTPMethod {
+			continue
+		}
+		params, matched := matchPath(routes[i].path, path)
+		if !matched {
+			continue
+		}
+		if !byResource && len(params) > 0 {
+			for name, value := range request.PathParameters {
+				if _, exists := params[name]; !exists {
+					params[name] = value
+				}
+			}
+			request.PathParameters = params
+		}
+		return &routes[i], request
+	}
+	return nil, request
+}
+
+func (config *Config) Router(ctx context.Context, request Request) (Response, error) {
+	ctx, timer := withTimer(ctx)
+	timer.coldStart = config.coldStart()
+	response, err := config.route(ctx, request)
+	config.logTimings(ctx, request, timer, response.StatusCode)
+	return response, err
+}
+
+// The checks every request goes through before its handler, a response when it's turned away
+func (config *Config) admit(ctx context.Context, request Request) (context.Context, *Response) {
+	if response := config.enforceAllowlist(ctx, request); response != nil {
+		return ctx, response
+	}
+	if response := config.enforceSignature(ctx, request); response != nil {
+		return ctx, response
+	}
+	return config.admitCaller(ctx, request)
+}
+
+// What admit checks after the allowlist and signatures: the caller's entitlement, limits and payload
+func (config *Config) admitCaller(ctx context.Context, request Request) (context.Context, *Response) {
+	ctx, denied := config.authorize(ctx, request)
+	if denied != nil {
+		return ctx, denied
+	}
+	ctx, limited := config.enforceRateLimit(ctx, request)
+	if limited != nil {
+		return ctx, limited
+	}
+	if exhausted := config.enforceQuota(request); exhausted != nil {
+		return ctx, exhausted
+	}
+	return ctx, config.inspectPayload(ctx, request)
+}
+
+func (config *Config) route(ctx context.Context, request Request) (Response, error) {
+	request, err := decodeBody(request)
+	if err != nil {
+		return *handleError(err, "body is marked base64 encoded but isn't valid base64"), nil
+	}
+	route, request := config.match(request)
+	if route != nil && route.admin() {
+		return config.serveAdmin(ctx, request, route)
+	}
+	// Never a validation, whatever the data plane would make of it
+	if route == nil && strings.Contains(request.Path+"/", adminPathPrefix) {
+		return jsonResponse(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	ctx = withConfigCanary(ctx, request)
+	// First, so the requests we turn away are logged too
+	config.logRequestBody(ctx, request)
+	authorized := timerFrom(ctx).stage(StageAuth)
+	ctx, rejected := config.admit(ctx, request)
+	authorized()
+	if rejected != nil {
+		return withRateLimitHeaders(ctx, *rejected), nil
+	}
+	var response Response
+	if route == nil {
+		response, err = config.Handler(ctx, request)
+	} else {
+		response, err = route.handler(config, ctx, request)
+	}
+	return withRateLimitHeaders(ctx, response), err
+}
+
+// Json body response for anything that isn't a validation response
+func jsonResponse(statusCode int, value interface{}) (Response, error) {
+	body, err := encodeJSON(value)
+	if err != nil {
+		return *handleError(err, "unable to serialise response"), nil
+	}
+	return Response{
+		StatusCode:      statusCode,
+		IsBase64Encoded: false,
+		Body:            body,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_matchPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		pattern     string
+		path        string
+		want        map[string]string
+		wantMatched bool
+	}{
+		{"exact", "/capabilities", "/capabilities", map[string]string{}, true},
+		{"stagePrefix", "/capabilities", "/dev/capabilities", map[string]string{}, true},
+		{"param", "/providers/{name}/sla", "/dev/providers/provider1/sla", map[string]string{"name": "provider1"}, true},
+		{"shorter", "/providers/{name}/sla", "/provider1/sla", nil, false},
+		{"otherEnd", "/providers", "/providers/provider1/sla", nil, false},
+		{"different", "/health", "/healthz", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, matched := matchPath(tt.pattern, tt.path)
+			if matched != tt.wantMatched || (matched && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("matchPath() = %v, %v, want %v, %v", got, matched, tt.want, tt.wantMatched)
+			}
+		})
+	}
+}
+
+func TestConfig_match(t *testing.T) {
+	config := &Config{}
+	tests := []struct {
+		name       string
+		request    Request
+		wantPath   string
+		wantParams map[string]string
+	}{
+		{name: "path", request: Request{HTTPMethod: "GET", Path: "/dev/health"}, wantPath: "/health"},
+		{name: "proxy", request: Request{HTTPMethod: "GET", Resource: "/{proxy+}", Path: "/providers/provider1/sla", PathParameters: map[string]string{"proxy": "providers/provider1/sla"}},
+			wantPath: "/providers/{name}/sla", wantParams: map[string]string{"name": "provider1", "proxy": "providers/provider1/sla"}},
+		{name: "resource", request: Request{HTTPMethod: "GET", Resource: "/providers/{name}/sla", Path: "/dev/providers/provider1/sla", PathParameters: map[string]string{"name": "provider1"}},
+			wantPath: "/providers/{name}/sla", wantParams: map[string]string{"name": "provider1"}},
+		{name: "wrongMethod", request: Request{HTTPMethod: "POST", Path: "/capabilities"}},
+		{name: "unknown", request: Request{HTTPMethod: "POST", Path: "/dev/anything"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, request := config.match(tt.request)
+			if tt.wantPath == "" {
+				if got != nil {
+					t.Errorf("match() = %s %s, want the default", got.method, got.path)
+				}
+				return
+			}
+			if got == nil || got.path != tt.wantPath {
+				t.Fatalf("match() = %+v, want %s", got, tt.wantPath)
+			}
+			if !reflect.DeepEqual(request.PathParameters, tt.wantParams) {
+				t.Errorf("match() path parameters = %v, want %v", request.PathParameters, tt.wantParams)
+			}
+		})
+	}
+}
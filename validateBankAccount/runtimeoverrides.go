@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+/*
+  Runtime overrides, per provider settings on call changes through the admin API that every container picks up
+  within seconds: provider toggles (toggles.go) and forced circuit breakers (breakercontrols.go). Each kind has its
+  own table with an item for every provider it overrides, and clearing an override deletes the item:
+
+    provider (S, hash key) | value (S) | setBy (S) | setAt (S, RFC3339)
+
+  Containers read the table at init and again in the background once what they have is more than 5 seconds old.
+  The container that made the change has it straight away. A failed read keeps the overrides we have. Every change
+  is recorded in the config history, see confighistory.go. With a configCanary, a change goes to a share of requests
+  before it goes to everyone, see configcanary.go.
+*/
+
+const (
+	overrideRefresh      = 5 * time.Second
+	overrideFetchTimeout = time.Second
+)
+
+const (
+	OverrideKindToggles  = "toggles"
+	OverrideKindBreakers = "breakers"
+)
+
+type OverrideStore interface {
+	Set(ctx context.Context, provider, value, by string, at time.Time) error
+	Clear(ctx context.Context, provider string) error
+	// The overridden providers' values, by name
+	All(ctx context.Context) (map[string]string, error)
+}
+
+type overrideDynamoAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+type dynamoOverrideStore struct {
+	client overrideDynamoAPI
+	table  string
+}
+
+func (store *dynamoOverrideStore) Set(ctx context.Context, provider, value, by string, at time.Time) error {
+	_, err := store.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(store.table),
+		Item: map[string]types.AttributeValue{
+			"provider": &types.AttributeValueMemberS{Value: provider},
+			"value":    &types.AttributeValueMemberS{Value: value},
+			"setBy":    &types.AttributeValueMemberS{Value: by},
+			"setAt":    &types.AttributeValueMemberS{Value: at.UTC().Format(time.RFC3339)},
+		},
+	})
+	return err
+}
+
+func (store *dynamoOverrideStore) Clear(ctx context.Context, provider string) error {
+	_, err := store.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(store.table),
+		Key:       map[string]types.AttributeValue{"provider": &types.AttributeValueMemberS{Value: provider}},
+	})
+	return err
+}
+
+// Only overridden providers have an item, so a scan is fine
+func (store *dynamoOverrideStore) All(ctx context.Context) (map[string]string, error) {
+	values := map[string]string{}
+	paginator := dynamodb.NewScanPaginator(store.client, &dynamodb.ScanInput{TableName: aws.String(store.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			provider, _ := item["provider"].(*types.AttributeValueMemberS)
+			value, _ := item["value"].(*types.AttributeValueMemberS)
+			if provider != nil && value != nil {
+				values[provider.Value] = value.Value
+			}
+		}
+	}
+	return values, nil
+}
+
+// The overrides this container knows about, read again in the background every refresh like the feedback weights are
+type runtimeOverrides struct {
+	mu     sync.Mutex
+	kind   string
+	store  OverrideStore
+	values map[string]string
+	// Changes on canary, the value the canary's requests see by provider, empty when it's cleared
+	pending    map[string]string
+	loadedAt   time.Time
+	refreshing bool
+	now        func() time.Time
+}
+
+func newRuntimeOverrides(kind string, store OverrideStore) *runtimeOverrides {
+	return &runtimeOverrides{kind: kind, store: store, values: map[string]string{}, now: time.Now}
+}
+
+// The provider's override, empty when it hasn't one. A nil runtimeOverrides never has one.
+func (overrides *runtimeOverrides) get(provider string) string {
+	return overrides.valueFor(context.Background(), provider)
+}
+
+// The provider's override as the request sees it, a pending one when it's on the canary
+func (overrides *runtimeOverrides) valueFor(ctx context.Context, provider string) string {
+	if overrides == nil {
+		return ""
+	}
+	overrides.mu.Lock()
+	defer overrides.mu.Unlock()
+	if !overrides.refreshing && overrides.now().Sub(overrides.loadedAt) >= overrideRefresh {
+		overrides.refreshing = true
+		go overrides.reload(context.Background())
+	}
+	if value, pending := overrides.pending[provider]; pending && onConfigCanary(ctx) {
+		return value
+	}
+	return overrides.values[provider]
+}
+
+// Failures keep the overrides we have until the next refresh
+func (overrides *runtimeOverrides) reload(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, overrideFetchTimeout)
+	defer cancel()
+	values, err := overrides.store.All(ctx)
+	overrides.mu.Lock()
+	first := overrides.loadedAt.IsZero()
+	overrides.refreshing = false
+	overrides.loadedAt = overrides.now()
+	if err != nil {
+		overrides.mu.Unlock()
+		log.Printf("unable to read the %s overrides, keeping the ones we have: %v", overrides.kind, err)
+		return
+	}
+	if configCanary == nil || first {
+		overrides.values, overrides.pending = values, nil
+		overrides.mu.Unlock()
+		return
+	}
+	// What differs from what we have goes on canary, anything already there with the same value carries on
+	pending := map[string]string{}
+	started := false
+	for provider := range union(overrides.values, values) {
+		if values[provider] == overrides.values[provider] {
+			continue
+		}
+		if existing, exists := overrides.pending[provider]; !exists || existing != values[provider] {
+			started = true
+		}
+		pending[provider] = values[provider]
+	}
+	overrides.pending = pending
+	overrides.mu.Unlock()
+	// A rollback that couldn't be written leaves its change pending, which goes round again
+	if started || (len(pending) > 0 && configCanary.idle()) {
+		configCanary.begin()
+	}
+	configCanary.settle()
+}
+
+func union(a, b map[string]string) map[string]bool {
+	keys := map[string]bool{}
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+	return keys
+}
+
+// Writes the override, an empty value clears it, and takes it here without waiting for the next read, on canary
+// first when there's a configCanary. The change goes in the config history with detail saying why, if there's more
+// to say than who made it.
+func (overrides *runtimeOverrides) set(ctx context.Context, provider, value, by, detail string) error {
+	overrides.mu.Lock()
+	old, pending := overrides.pending[provider]
+	if !pending {
+		old = overrides.values[provider]
+	}
+	overrides.mu.Unlock()
+	var err error
+	if value == "" {
+		err = overrides.store.Clear(ctx, provider)
+	} else {
+		err = overrides.store.Set(ctx, provider, value, by, overrides.now())
+	}
+	if err != nil {
+		return err
+	}
+	overrides.mu.Lock()
+	canaried := configCanary != nil && value != overrides.values[provider]
+	if canaried {
+		overrides.pending = withValue(overrides.pending, provider, value, true)
+	} else {
+		overrides.values = withValue(overrides.values, provider, value, false)
+		overrides.pending = withoutKey(overrides.pending, provider)
+	}
+	overrides.mu.Unlock()
+	if canaried {
+		configCanary.begin()
+	}
+	configHistory.record(ctx, ConfigChange{
+		Kind:      overrides.kind,
+		Provider:  provider,
+		OldValue:  old,
+		NewValue:  value,
+		ChangedBy: by,
+		ChangedAt: overrides.now(),
+		Detail:    detail,
+	})
+	return nil
+}
+
+// A copy with the provider's value set, or deleted when it's empty and empty values aren't kept
+func withValue(values map[string]string, provider, value string, keepEmpty bool) map[string]string {
+	copied := withoutKey(values, provider)
+	if value != "" || keepEmpty {
+		copied[provider] = value
+	}
+	return copied
+}
+
+func withoutKey(values map[string]string, provider string) map[string]string {
+	copied := map[string]string{}
+	for name, existing := range values {
+		if name != provider {
+			copied[name] = existing
+		}
+	}
+	return copied
+}
+
+// Gives everyone what's on canary
+func (overrides *runtimeOverrides) promote() {
+	if overrides == nil {
+		return
+	}
+	overrides.mu.Lock()
+	defer overrides.mu.Unlock()
+	for provider, value := range overrides.pending {
+		overrides.values = withValue(overrides.values, provider, value, false)
+	}
+	overrides.pending = nil
+}
+
+// Writes back what everyone else has over what's on canary
+func (overrides *runtimeOverrides) rollBack(ctx context.Context, detail string) {
+	if overrides == nil {
+		return
+	}
+	overrides.mu.Lock()
+	applied := map[string]string{}
+	for provider := range overrides.pending {
+		applied[provider] = overrides.values[provider]
+	}
+	overrides.mu.Unlock()
+	for provider, value := range applied {
+		if err := overrides.set(ctx, provider, value, configCanaryCaller, detail); err != nil {
+			log.Printf("unable to roll back the %s override for %s: %v", overrides.kind, provider, err)
+		}
+	}
+}
+
+// Connects to the table in the environment variable if it's set and reads it, nil when it isn't
+func setupRuntimeOverrides(ctx context.Context, kind, env string) *runtimeOverrides {
+	table, exists := os.LookupEnv(env)
+	if !exists {
+		return nil
+	}
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	overrides := newRuntimeOverrides(kind, &dynamoOverrideStore{client: dynamodb.NewFromConfig(cfg), table: table})
+	overrides.reload(ctx)
+	return overrides
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeOverrides struct {
+	mu     sync.Mutex
+	values map[string]string
+	setBy  map[string]string
+	err    error
+}
+
+func newFakeOverrides(values map[string]string) *fakeOverrides {
+	return &fakeOverrides{values: values, setBy: map[string]string{}}
+}
+
+func (store *fakeOverrides) Set(ctx context.Context, provider, value, by string, at time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.err != nil {
+		return store.err
+	}
+	store.values[provider], store.setBy[provider] = value, by
+	return nil
+}
+
+func (store *fakeOverrides) Clear(ctx context.Context, provider string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.err != nil {
+		return store.err
+	}
+	delete(store.values, provider)
+	return nil
+}
+
+func (store *fakeOverrides) All(ctx context.Context) (map[string]string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.err != nil {
+		return nil, store.err
+	}
+	values := map[string]string{}
+	for provider, value := range store.values {
+		values[provider] = value
+	}
+	return values, nil
+}
+
+// Overrides that have just been read, so nothing's reread in the background
+func freshOverrides(store OverrideStore) *runtimeOverrides {
+	overrides := newRuntimeOverrides(OverrideKindToggles, store)
+	overrides.now = func() time.Time { return time.Unix(0, 0) }
+	overrides.loadedAt = overrides.now()
+	return overrides
+}
+
+func Test_runtimeOverrides(t *testing.T) {
+	store := newFakeOverrides(map[string]string{"provider2": "disabled"})
+	now := time.Unix(0, 0)
+	overrides := newRuntimeOverrides(OverrideKindToggles, store)
+	overrides.now = func() time.Time { return now }
+	overrides.reload(context.Background())
+	if overrides.get("provider2") != "disabled" || overrides.get("provider1") != "" {
+		t.Fatalf("values = %v, want provider2 disabled", overrides.values)
+	}
+
+	// Another container clears it, we notice once ours is stale
+	store.Clear(context.Background(), "provider2")
+	if overrides.get("provider2") != "disabled" {
+		t.Errorf("provider2 cleared before the refresh")
+	}
+	now = now.Add(overrideRefresh)
+	overrides.reload(context.Background())
+	if overrides.get("provider2") != "" {
+		t.Errorf("provider2 still overridden after the refresh")
+	}
+
+	// Set here, it's taken straight away
+	if err := overrides.set(context.Background(), "provider1", "disabled", "key:oncall", ""); err != nil || overrides.get("provider1") != "disabled" || store.setBy["provider1"] != "key:oncall" {
+		t.Errorf("set() = %v, values %v, stored %v", err, overrides.values, store.values)
+	}
+
+	// A failed read keeps what we have, and a failed write changes nothing
+	store.err = errors.New("throttled")
+	now = now.Add(overrideRefresh)
+	overrides.reload(context.Background())
+	if overrides.get("provider1") != "disabled" {
+		t.Errorf("lost provider1's override to a failed read")
+	}
+	if err := overrides.set(context.Background(), "provider1", "", "key:oncall", ""); err == nil || overrides.get("provider1") != "disabled" {
+		t.Errorf("set() = %v, values %v after a failed write", err, overrides.values)
+	}
+
+	var none *runtimeOverrides
+	if none.get("provider1") != "" {
+		t.Errorf("a nil runtimeOverrides has an override")
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+/*
+  Config schema versions. Version 1, the default, is the flat provider list we started with and is still accepted as
+  is:
+
+    providers:
+    - name: provider1
+      url: https://provider1.com/v1/api/account/validate
+      healthUrl: https://provider1.com/v1/health
+
+  Version 2 groups each provider's settings into blocks, so a provider with auth, templates and several endpoints
+  stays readable:
+
+    version: 2
+    providers:
+    - name: provider1
+      type: http                 # http or simulated, default http
+      endpoints:                 # the first is the primary, the rest are picked by latency, see endpoints.go
+      - https://eu.provider1.com/validate
+      - https://us.provider1.com/validate
+      timeoutMs: 800             # per call, default 1000
+      retries: 1                 # extra attempts after a failed or timed out call, default 0
+      weight: 2                  # counts double in the majority strategy, default 1
+      unitCost: 0.05             # what a call costs, for usage reports, see usage.go
+      enabled: true              # false and it is never called, see overrides.go
+      rollout:                   # gradual cutover to a new url, see rollout.go
+        url: https://eu.provider1.com/v2/validate
+        percent: 10
+      sla:                       # targets for GET /providers/{name}/sla, see sla.go
+        successRate: 99.5
+        latencyMs: 800
+      health:
+        url: https://provider1.com/v1/health
+        reprobeSeconds: 30
+      auth:
+        signing:                 # see signing.go
+          primary:
+            id: "2024-06"
+            secretId: accountvalidator/provider1
+      headers:
+        X-Partner-Id: "1234"
+      userAgent: accountvalidator/1.0 (partner 1234)
+      callIdHeader: X-Correlation-Id
+      request:
+        template: '{"sortCode": {{ json .AccountNumber }}}'
+      response:
+        validField: status
+        validValues: [OK]
+        reasonField: statusReason
+      capabilities:
+        countries: [GB, IE]
+      tls:
+        pins: [...]
+
+  Everything outside providers is the same in both versions. A version 2 provider block is checked strictly, so a
+  misspelt key fails at load rather than being ignored. Both load into the same Provider, so moving a config over is
+  just a matter of regrouping the keys, and the timeoutMs, retries and weight settings work in version 1 too.
+*/
+
+const latestConfigVersion = 2
+
+type ProviderBlock struct {
+	Name          string            `yaml:"name"`
+	Aliases       []string          `yaml:"aliases"`
+	Tags          []string          `yaml:"tags"`
+	Type          string            `yaml:"type"`
+	Endpoints     []string          `yaml:"endpoints"`
+	TimeoutMs     int               `yaml:"timeoutMs"`
+	Retries       int               `yaml:"retries"`
+	Weight        float64           `yaml:"weight"`
+	Authoritative bool              `yaml:"authoritative"`
+	UnitCost      float64           `yaml:"unitCost"`
+	Enabled       *bool             `yaml:"enabled"`
+	Rollout       *RolloutConfig    `yaml:"rollout"`
+	SEPA          *SEPAConfig       `yaml:"sepa"`
+	SLA           *SLAConfig        `yaml:"sla"`
+	Health        HealthBlock       `yaml:"health"`
+	Auth          AuthBlock         `yaml:"auth"`
+	Headers       map[string]string `yaml:"headers"`
+	UserAgent     string            `yaml:"userAgent"`
+	CallIDHeader  string            `yaml:"callIdHeader"`
+	Request       RequestBlock      `yaml:"request"`
+	Response      ResponseBlock     `yaml:"response"`
+	Capabilities  CapabilitiesBlock `yaml:"capabilities"`
+	TLS           ProviderTLSBlock  `yaml:"tls"`
+}
+
+type HealthBlock struct {
+	URL            string `yaml:"url"`
+	ReprobeSeconds int    `yaml:"reprobeSeconds"`
+}
+
+type AuthBlock struct {
+	Signing *SigningConfig `yaml:"signing"`
+}
+
+type RequestBlock struct {
+	Template string `yaml:"template"`
+}
+
+type ResponseBlock struct {
+	ValidField  string            `yaml:"validField"`
+	ValidValues []string          `yaml:"validValues"`
+	ReasonField string            `yaml:"reasonField"`
+	Reasons     map[string]string `yaml:"reasons"`
+}
+
+type CapabilitiesBlock struct {
+	Countries    []string `yaml:"countries"`
+	AccountTypes []string `yaml:"accountTypes"`
+	Currencies   []string `yaml:"currencies"`
+}
+
+type ProviderTLSBlock struct {
+	Pins []string `yaml:"pins"`
+}
+
+// The provider the block describes
+func (block ProviderBlock) provider() Provider {
+	provider := Provider{
+		Name:            block.Name,
+		Aliases:         block.Aliases,
+		Tags:            block.Tags,
+		Type:            block.Type,
+		TimeoutMs:       block.TimeoutMs,
+		Retries:         block.Retries,
+		Weight:          block.Weight,
+		Authoritative:   block.Authoritative,
+		UnitCost:        block.UnitCost,
+		Enabled:         block.Enabled,
+		Rollout:         block.Rollout,
+		SEPA:            block.SEPA,
+		SLA:             block.SLA,
+		HealthURL:       block.Health.URL,
+		ReprobeSeconds:  block.Health.ReprobeSeconds,
+		Signing:         block.Auth.Signing,
+		Headers:         block.Headers,
+		UserAgent:       block.UserAgent,
+		CallIDHeader:    block.CallIDHeader,
+		RequestTemplate: block.Request.Template,
+		ValidField:      block.Response.ValidField,
+		ValidValues:     block.Response.ValidValues,
+		ReasonField:     block.Response.ReasonField,
+		Reasons:         block.Response.Reasons,
+		Countries:       block.Capabilities.Countries,
+		AccountTypes:    block.Capabilities.AccountTypes,
+		Currencies:      block.Capabilities.Currencies,
+		Pins:            block.TLS.Pins,
+	}
+	if len(block.Endpoints) > 0 {
+		provider.URL = block.Endpoints[0]
+		provider.Endpoints = block.Endpoints[1:]
+	}
+	return provider
+}
+
+// Parses the config in whichever version it declares. Empty yaml gives a nil config.
+func parseConfig(data []byte) (*Config, error) {
+	var header struct {
+		Version int `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	if header.Version != 2 {
+		var config *Config
+		err := yaml.Unmarshal(data, &config)
+		return config, err
+	}
+
+	// Providers are decoded as blocks, everything else as it is in version 1
+	var document yaml.MapSlice
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	rest := yaml.MapSlice{}
+	var providers interface{}
+	for _, item := range document {
+		if item.Key == "providers" {
+			providers = item.Value
+		} else {
+			rest = append(rest, item)
+		}
+	}
+	var config *Config
+	if err := remarshal(rest, &config, false); err != nil {
+		return nil, err
+	}
+	var blocks []ProviderBlock
+	if err := remarshal(providers, &blocks, true); err != nil {
+		return nil, fmt.Errorf("providers: %w", err)
+	}
+	for _, block := range blocks {
+		config.Providers = append(config.Providers, block.provider())
+	}
+	return config, nil
+}
+
+// Decodes part of an already parsed document into out
+func remarshal(value interface{}, out interface{}, strict bool) error {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if strict {
+		return yaml.UnmarshalStrict(data, out)
+	}
+	return yaml.Unmarshal(data, out)
+}
+
+func (config *Config) validateVersion() error {
+	if config.Version < 0 || config.Version > latestConfigVersion {
+		return fmt.Errorf("unsupported config version %d, the latest is %d", config.Version, latestConfigVersion)
+	}
+	return nil
+}
+
+// Checks the per call settings, called from compile
+func (provider Provider) validateLimits() error {
+	if provider.TimeoutMs < 0 || provider.Retries < 0 || provider.Weight < 0 {
+		return fmt.Errorf("provider %s has a negative timeoutMs, retries or weight", provider.Name)
+	}
+	return nil
+}
+
+// How long a call to the provider can take
+func (provider Provider) timeout() time.Duration {
+	if provider.TimeoutMs == 0 {
+		return providerTimeout
+	}
+	return time.Duration(provider.TimeoutMs) * time.Millisecond
+}
+
+// The longest any provider call can take
+func (config *Config) longestTimeout() time.Duration {
+	if len(config.Providers) == 0 {
+		return providerTimeout
+	}
+	longest := time.Duration(0)
+	for _, provider := range config.Providers {
+		if provider.timeout() > longest {
+			longest = provider.timeout()
+		}
+	}
+	return longest
+}
+
+// Weights for the providers that have one, nil when none do
+func (config *Config) providerWeights() map[string]float64 {
+	var weights map[string]float64
+	for _, provider := range config.Providers {
+		if provider.Weight == 0 {
+			continue
+		}
+		if weights == nil {
+			weights = map[string]float64{}
+		}
+		weights[provider.Name] = provider.Weight
+	}
+	return weights
+}
+
+// Whether a failed call is worth another attempt. A bad response or a TLS violation won't get better by asking again.
+func retryable(providerError string) bool {
+	return providerError == ProviderErrorRequest || providerError == ProviderErrorTimeout
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testConfigV1 = `
+revision: r1
+providers:
+- name: provider1
+  url: https://eu.provider1.com/validate
+  endpoints:
+  - https://us.provider1.com/validate
+  healthUrl: https://provider1.com/health
+  reprobeSeconds: 30
+  timeoutMs: 800
+  retries: 1
+  weight: 2
+  headers:
+    X-Partner-Id: "1234"
+  userAgent: accountvalidator/1.0
+  requestTemplate: '{"sortCode": {{ json .AccountNumber }}}'
+  validField: status
+  validValues: [OK]
+  countries: [GB]
+  pins: ["AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="]
+  signing:
+    primary:
+      id: k1
+      secret: s
+- name: provider2
+  type: simulated
+`
+
+const testConfigV2 = `
+version: 2
+revision: r1
+providers:
+- name: provider1
+  endpoints:
+  - https://eu.provider1.com/validate
+  - https://us.provider1.com/validate
+  timeoutMs: 800
+  retries: 1
+  weight: 2
+  health:
+    url: https://provider1.com/health
+    reprobeSeconds: 30
+  auth:
+    signing:
+      primary:
+        id: k1
+        secret: s
+  headers:
+    X-Partner-Id: "1234"
+  userAgent: accountvalidator/1.0
+  request:
+    template: '{"sortCode": {{ json .AccountNumber }}}'
+  response:
+    validField: status
+    validValues: [OK]
+  capabilities:
+    countries: [GB]
+  tls:
+    pins: ["AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="]
+- name: provider2
+  type: simulated
+`
+
+// Both versions describe the same config
+func Test_parseConfig_versions(t *testing.T) {
+	v1, err := parseConfig([]byte(testConfigV1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := parseConfig([]byte(testConfigV2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.Revision != "r1" || v2.Revision != "r1" || v2.Version != 2 {
+		t.Errorf("top level settings not read, got %v and %v", v1.Revision, v2.Revision)
+	}
+	// Block providers have an empty rather than a missing list of extra endpoints when there's only one
+	v2.Providers[1].Endpoints = nil
+	if !reflect.DeepEqual(v1.Providers, v2.Providers) {
+		t.Errorf("parseConfig() v2 providers = %+v, want %+v", v2.Providers, v1.Providers)
+	}
+	if err := v2.compile(); err != nil {
+		t.Errorf("compile() = %v", err)
+	}
+}
+
+func Test_parseConfig_errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		yaml      string
+		wantParse string
+		wantErr   string
+	}{
+		{name: "misspeltBlockKey", yaml: "version: 2\nproviders:\n- name: p\n  endpoint: [https://p]\n", wantParse: "field endpoint not found"},
+		{name: "unknownVersion", yaml: "version: 3\nproviders:\n- name: p\n", wantErr: "unsupported config version 3"},
+		{name: "negativeRetries", yaml: "providers:\n- name: p\n  retries: -1\n", wantErr: "provider p has a negative timeoutMs, retries or weight"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseConfig([]byte(tt.yaml))
+			if tt.wantParse != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantParse) {
+					t.Errorf("parseConfig() = %v, want %v", err, tt.wantParse)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := config.compile(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("compile() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_checkProviders_retries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first call is dropped, as if the connection reset
+		if calls.Add(1) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		retries   int
+		want      BankAccountValidationResult
+		wantCalls int32
+	}{
+		{name: "noRetries", want: BankAccountValidationResult{Provider: "provider1", Error: ProviderErrorRequest}, wantCalls: 1},
+		{name: "retried", retries: 2, want: BankAccountValidationResult{Provider: "provider1", IsValid: true}, wantCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			providers := []Provider{{Name: "provider1", URL: server.URL, Retries: tt.retries}}
+			got := checkProviders(context.Background(), "12345678", providers)
+			if !reflect.DeepEqual(got.Result, []BankAccountValidationResult{tt.want}) || calls.Load() != tt.wantCalls {
+				t.Errorf("checkProviders() = %+v after %d calls, want %+v after %d", got.Result, calls.Load(), tt.want, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestConfig_longestTimeout(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "a", TimeoutMs: 300}, {Name: "b", TimeoutMs: 2500}}}
+	if got := config.longestTimeout().Milliseconds(); got != 2500 {
+		t.Errorf("longestTimeout() = %v, want 2500", got)
+	}
+}
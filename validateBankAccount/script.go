@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+)
+
+/*
+  Response scripts. When a partner's schema shifts a little, or the verdict is spread over several fields, a
+  provider can work it out with CEL expressions (https://github.com/google/cel-spec) instead of validField and
+  reasonField, and the change is a config change rather than a release:
+
+    - name: provider3
+      url: https://provider3.com/check
+      script:
+        accept: 'status == 200 && has(body.data)'   # anything else is response_invalid, optional
+        valid: 'body.data.status in ["VALID", "OPEN"] && body.data.score >= 0.8'
+        reason: 'body.data.closed ? "ACCOUNT_CLOSED" : string(body.data.code)'   # optional
+
+  Each expression sees body, the parsed JSON of the response, and status, its HTTP status. accept and valid have to
+  come out true or false and reason a string, which goes through the provider's reasons like any other. Without
+  valid the verdict comes from validField as usual, and without reason from reasonField. Numbers compare across
+  types, so body.code == 3 works even though JSON numbers are doubles.
+
+  Expressions are compiled when the config loads, so a typo fails the deploy rather than the first request. One
+  that fails on a response, a missing field say, makes the result response_invalid. Each evaluation is limited to
+  scriptCostLimit, so a runaway comprehension can't hold up the validation.
+*/
+
+const scriptCostLimit = 10000
+
+type ScriptConfig struct {
+	Accept string `yaml:"accept"`
+	Valid  string `yaml:"valid"`
+	Reason string `yaml:"reason"`
+}
+
+// What the provider's script compiles to, nil programs for the expressions it doesn't have
+type responseScript struct {
+	accept cel.Program
+	valid  cel.Program
+	reason cel.Program
+}
+
+var errNotAccepted = errors.New("the script didn't accept the response")
+
+// Compiles the expression into a program that has to come out as want
+func compileExpression(env *cel.Env, text string, want *cel.Type) (cel.Program, error) {
+	if text == "" {
+		return nil, nil
+	}
+	ast, issues := env.Compile(text)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if output := ast.OutputType(); !output.IsExactType(want) && !output.IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("%s comes out as %s, not %s", text, output, want)
+	}
+	return env.Program(ast, cel.CostLimit(scriptCostLimit))
+}
+
+// Evaluates a program compiled by compileExpression to come out as a bool
+func evalBool(ctx context.Context, program cel.Program, vars map[string]interface{}) (bool, error) {
+	out, _, err := program.ContextEval(ctx, vars)
+	if err != nil {
+		return false, err
+	}
+	value, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("came out as %v, not true or false", out)
+	}
+	return value, nil
+}
+
+func evalString(ctx context.Context, program cel.Program, vars map[string]interface{}) (string, error) {
+	out, _, err := program.ContextEval(ctx, vars)
+	if err != nil {
+		return "", err
+	}
+	value, ok := out.Value().(string)
+	if !ok {
+		return "", fmt.Errorf("came out as %v, not a string", out)
+	}
+	return value, nil
+}
+
+func (script *ScriptConfig) compile() (*responseScript, error) {
+	if script == nil {
+		return nil, nil
+	}
+	if script.Accept == "" && script.Valid == "" && script.Reason == "" {
+		return nil, errors.New("script needs at least one of accept, valid and reason")
+	}
+	env, err := cel.NewEnv(
+		cel.Variable("body", cel.DynType),
+		cel.Variable("status", cel.IntType),
+		cel.CrossTypeNumericComparisons(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	compiled := &responseScript{}
+	if compiled.accept, err = compileExpression(env, script.Accept, cel.BoolType); err != nil {
+		return nil, fmt.Errorf("script accept: %w", err)
+	}
+	if compiled.valid, err = compileExpression(env, script.Valid, cel.BoolType); err != nil {
+		return nil, fmt.Errorf("script valid: %w", err)
+	}
+	if compiled.reason, err = compileExpression(env, script.Reason, cel.StringType); err != nil {
+		return nil, fmt.Errorf("script reason: %w", err)
+	}
+	return compiled, nil
+}
+
+// The verdict and raw reason for the provider's response, from its script where it has one and its fields otherwise
+func (provider Provider) readVerdict(ctx context.Context, body []byte, status int) (bool, string, error) {
+	script := provider.script
+	if script == nil {
+		isValid, err := provider.extractValidity(body)
+		if err != nil || isValid {
+			return isValid, "", err
+		}
+		return false, provider.extractReason(body), nil
+	}
+	var parsed interface{}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return false, "", err
+	}
+	vars := map[string]interface{}{"body": parsed, "status": status}
+	if script.accept != nil {
+		accepted, err := evalBool(ctx, script.accept, vars)
+		if err != nil {
+			return false, "", fmt.Errorf("script accept: %w", err)
+		}
+		if !accepted {
+			return false, "", errNotAccepted
+		}
+	}
+	var isValid bool
+	var err error
+	if script.valid != nil {
+		if isValid, err = evalBool(ctx, script.valid, vars); err != nil {
+			return false, "", fmt.Errorf("script valid: %w", err)
+		}
+	} else if isValid, err = provider.extractValidity(body); err != nil {
+		return false, "", err
+	}
+	if isValid {
+		return true, "", nil
+	}
+	if script.reason == nil {
+		return false, provider.extractReason(body), nil
+	}
+	reason, err := evalString(ctx, script.reason, vars)
+	if err != nil {
+		return false, "", fmt.Errorf("script reason: %w", err)
+	}
+	return false, reason, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestScriptConfig_compile(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  *ScriptConfig
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"valid", &ScriptConfig{Valid: `body.status == "OPEN"`}, false},
+		{"everything", &ScriptConfig{Accept: "status == 200", Valid: "body.score >= 0.8", Reason: "string(body.code)"}, false},
+		{"dyn", &ScriptConfig{Valid: "body.isValid"}, false},
+		{"empty", &ScriptConfig{}, true},
+		{"syntax", &ScriptConfig{Valid: `body.status ==`}, true},
+		{"unknown variable", &ScriptConfig{Valid: `response.status == "OPEN"`}, true},
+		{"not a bool", &ScriptConfig{Valid: `"OPEN"`}, true},
+		{"not a string", &ScriptConfig{Reason: "status"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.script.compile(); (err != nil) != tt.wantErr {
+				t.Errorf("compile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProvider_readVerdict(t *testing.T) {
+	script := func(config ScriptConfig) *responseScript {
+		compiled, err := config.compile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return compiled
+	}
+	derived := script(ScriptConfig{
+		Accept: "status == 200",
+		Valid:  `body.data.status in ["VALID", "OPEN"] && body.data.score >= 0.8`,
+		Reason: `body.data.closed ? "ACCOUNT_CLOSED" : string(body.data.code)`,
+	})
+	tests := []struct {
+		name       string
+		provider   Provider
+		body       string
+		status     int
+		wantValid  bool
+		wantReason string
+		wantErr    bool
+	}{
+		{"fields", Provider{}, `{"isValid": false, "reason": "closed"}`, 200, false, "closed", false},
+		{"derived", Provider{script: derived}, `{"data": {"status": "OPEN", "score": 0.9}}`, 200, true, "", false},
+		{"low score", Provider{script: derived}, `{"data": {"status": "OPEN", "score": 0.2, "closed": false, "code": 7}}`, 200, false, "7", false},
+		{"closed", Provider{script: derived}, `{"data": {"status": "SHUT", "score": 1, "closed": true}}`, 200, false, "ACCOUNT_CLOSED", false},
+		{"not accepted", Provider{script: derived}, `{"data": {"status": "OPEN", "score": 0.9}}`, 202, false, "", true},
+		{"missing field", Provider{script: derived}, `{"status": "OPEN"}`, 200, false, "", true},
+		{"not json", Provider{script: derived}, `OPEN`, 200, false, "", true},
+		{"int compares with double", Provider{script: script(ScriptConfig{Valid: "body.code == 3"})}, `{"code": 3}`, 200, true, "", false},
+		{"reason from the field", Provider{script: script(ScriptConfig{Valid: "body.score > 1"})}, `{"score": 0, "reason": "blocked"}`, 200, false, "blocked", false},
+		{"valid from the field", Provider{script: script(ScriptConfig{Accept: "has(body.isValid)"})}, `{"isValid": true}`, 200, true, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isValid, reason, err := tt.provider.readVerdict(context.Background(), []byte(tt.body), tt.status)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readVerdict() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if isValid != tt.wantValid || reason != tt.wantReason {
+				t.Errorf("readVerdict() = %v, %q, want %v, %q", isValid, reason, tt.wantValid, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestConfig_Router_script(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"account": {"state": "open", "flags": ["frozen"]}}`))
+	}))
+	defer server.Close()
+	config := &Config{Providers: []Provider{{
+		Name:   "provider1",
+		URL:    server.URL,
+		Script: &ScriptConfig{Valid: `body.account.state == "open" && !("frozen" in body.account.flags)`, Reason: `"blocked"`},
+	}}}
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+	request := Request{HTTPMethod: "POST", Path: "/application", Body: `{"accountNumber": "12345678"}`}
+	if got, err := config.Router(context.Background(), request); err != nil || !strings.Contains(got.Body, `"isValid":false,"reason":"BLOCKED"`) {
+		t.Errorf("Router() = %s, %v, want it blocked", got.Body, err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+/*
+  Provider credentials from Secrets Manager. A signing key can name a secretId (and a secretKey if the secret is a
+  JSON object) instead of carrying the secret itself. Secrets are fetched during init and then rechecked every
+  refreshSeconds: a DescribeSecret tells us which version is AWSCURRENT and we only fetch the value again when that
+  changes. The recheck happens in the background, calls keep using the cached value in the meantime, so a rotation
+  reaches warm containers within the window rather than when they recycle.
+
+    secrets:
+      refreshSeconds: 300
+    providers:
+    - name: provider2
+      signing:
+        primary:
+          id: "2024-06"
+          secretId: accountvalidator/provider2
+          secretKey: hmacKey
+*/
+
+type SecretsConfig struct {
+	RefreshSeconds int `yaml:"refreshSeconds"`
+}
+
+const (
+	defaultSecretRefresh = 5 * time.Minute
+	secretFetchTimeout   = 2 * time.Second
+	currentVersionStage  = "AWSCURRENT"
+)
+
+type secretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+	DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error)
+}
+
+type cachedSecret struct {
+	value      string
+	versionID  string
+	checkedAt  time.Time
+	refreshing bool
+}
+
+type secretCache struct {
+	mu      sync.Mutex
+	client  secretsManagerAPI
+	entries map[string]*cachedSecret
+	refresh time.Duration
+	now     func() time.Time
+}
+
+// Set up at init by setupSecrets when any provider uses Secrets Manager
+var providerSecrets *secretCache
+
+func newSecretCache(client secretsManagerAPI, config SecretsConfig) *secretCache {
+	cache := &secretCache{
+		client:  client,
+		entries: map[string]*cachedSecret{},
+		refresh: time.Duration(config.RefreshSeconds) * time.Second,
+		now:     time.Now,
+	}
+	if cache.refresh <= 0 {
+		cache.refresh = defaultSecretRefresh
+	}
+	return cache
+}
+
+func (cache *secretCache) fetch(ctx context.Context, secretID string) (*cachedSecret, error) {
+	output, err := cache.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(secretID),
+		VersionStage: aws.String(currentVersionStage),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cachedSecret{
+		value:     aws.ToString(output.SecretString),
+		versionID: aws.ToString(output.VersionId),
+		checkedAt: cache.now(),
+	}, nil
+}
+
+// The current value of a secret. Fetches it if we've never seen it, otherwise returns what we have and kicks off a
+// background recheck when it's due.
+func (cache *secretCache) get(ctx context.Context, secretID string) (string, error) {
+	cache.mu.Lock()
+	entry, exists := cache.entries[secretID]
+	if exists {
+		value := entry.value
+		if !entry.refreshing && cache.now().Sub(entry.checkedAt) >= cache.refresh {
+			entry.refreshing = true
+			go cache.recheck(secretID)
+		}
+		cache.mu.Unlock()
+		return value, nil
+	}
+	cache.mu.Unlock()
+
+	fetched, err := cache.fetch(ctx, secretID)
+	if err != nil {
+		return "", fmt.Errorf("unable to fetch secret %s: %w", secretID, err)
+	}
+	cache.mu.Lock()
+	cache.entries[secretID] = fetched
+	cache.mu.Unlock()
+	return fetched.value, nil
+}
+
+// Refetches the secret if its AWSCURRENT version has moved on. Failures keep the old value until the next window.
+func (cache *secretCache) recheck(secretID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretFetchTimeout)
+	defer cancel()
+
+	cache.mu.Lock()
+	entry := *cache.entries[secretID]
+	cache.mu.Unlock()
+
+	updated := &entry
+	updated.checkedAt = cache.now()
+	described, err := cache.client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(secretID)})
+	if err != nil {
+		log.Printf("unable to check secret %s for rotation, keeping the cached value: %v", secretID, err)
+	} else if current := currentVersion(described.VersionIdsToStages); current != "" && current != entry.versionID {
+		fetched, err := cache.fetch(ctx, secretID)
+		if err != nil {
+			log.Printf("secret %s rotated to %s but fetching it failed, keeping the cached value: %v", secretID, current, err)
+		} else {
+			log.Printf("secret %s rotated from %s to %s", secretID, entry.versionID, fetched.versionID)
+			updated = fetched
+		}
+	}
+
+	updated.refreshing = false
+	cache.mu.Lock()
+	cache.entries[secretID] = updated
+	cache.mu.Unlock()
+}
+
+func currentVersion(versions map[string][]string) string {
+	for versionID, stages := range versions {
+		for _, stage := range stages {
+			if stage == currentVersionStage {
+				return versionID
+			}
+		}
+	}
+	return ""
+}
+
+// Pulls a single key out of a JSON secret, or the whole thing if there is no key
+func secretField(value, key string) (string, error) {
+	if key == "" {
+		return value, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a json object so has no key %s", key)
+	}
+	field, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret has no string key %s", key)
+	}
+	return field, nil
+}
+
+// Every secret a provider needs from Secrets Manager
+func (config *Config) secretIDs() []string {
+	seen := map[string]bool{}
+	ids := []string{}
+	keys := []*SigningKey{}
+	for _, provider := range config.Providers {
+		if provider.Signing != nil {
+			keys = append(keys, provider.Signing.Primary, provider.Signing.Secondary)
+		}
+	}
+	if config.Security.RequestSignatures != nil {
+		keys = append(keys, config.Security.RequestSignatures.Keys...)
+	}
+	if config.Receipts != nil {
+		keys = append(keys, config.Receipts.Keys...)
+	}
+	if config.Admin != nil {
+		keys = append(keys, config.Admin.Keys...)
+	}
+	keys = append(keys, config.AccountHashing.Key)
+	for _, webhook := range config.Webhooks {
+		if webhook.Signing != nil {
+			keys = append(keys, webhook.Signing.Primary, webhook.Signing.Secondary)
+		}
+	}
+	for _, key := range keys {
+		if key != nil && key.SecretID != "" && !seen[key.SecretID] {
+			seen[key.SecretID] = true
+			ids = append(ids, key.SecretID)
+		}
+	}
+	return ids
+}
+
+// Creates the secret cache and fetches everything up front so the first request doesn't wait on Secrets Manager
+func (config *Config) setupSecrets(ctx context.Context) {
+	ids := config.secretIDs()
+	if len(ids) == 0 {
+		return
+	}
+	if port, exists := os.LookupEnv("CACHE_EXTENSION_PORT"); exists {
+		providerSecrets = newSecretCache(newExtensionSecrets(port), config.Secrets)
+		providerSecrets.warm(ctx, ids)
+		return
+	}
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	providerSecrets = newSecretCache(secretsmanager.NewFromConfig(cfg), config.Secrets)
+	providerSecrets.warm(ctx, ids)
+}
+
+func (cache *secretCache) warm(ctx context.Context, ids []string) {
+	ctx, cancel := context.WithTimeout(ctx, secretFetchTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if _, err := cache.get(ctx, id); err != nil {
+				log.Print(err)
+			}
+		}(id)
+	}
+	wg.Wait()
+}
+
+// Forgets every secret, each is fetched again the next time it's needed
+func (cache *secretCache) clear() {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries = map[string]*cachedSecret{}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+type fakeSecretsManager struct {
+	value       string
+	versionID   string
+	fail        bool
+	gets        int
+	describes   int
+	describeErr error
+}
+
+func (client *fakeSecretsManager) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	client.gets++
+	if client.fail {
+		return nil, errors.New("AccessDeniedException")
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(client.value), VersionId: aws.String(client.versionID)}, nil
+}
+
+func (client *fakeSecretsManager) DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+	client.describes++
+	if client.describeErr != nil {
+		return nil, client.describeErr
+	}
+	return &secretsmanager.DescribeSecretOutput{VersionIdsToStages: map[string][]string{
+		client.versionID: {"AWSCURRENT"},
+		"previous":       {"AWSPREVIOUS"},
+	}}, nil
+}
+
+func Test_secretCache_rotation(t *testing.T) {
+	client := &fakeSecretsManager{value: "old-key", versionID: "v1"}
+	cache := newSecretCache(client, SecretsConfig{RefreshSeconds: 60})
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if got, err := cache.get(context.Background(), "provider2"); err != nil || got != "old-key" {
+			t.Fatalf("get() = %v, %v", got, err)
+		}
+	}
+	if client.gets != 1 {
+		t.Errorf("expected one fetch inside the refresh window, got %d", client.gets)
+	}
+
+	// Nothing changed, the recheck shouldn't fetch the value again
+	now = now.Add(61 * time.Second)
+	cache.recheck("provider2")
+	if client.describes != 1 || client.gets != 1 {
+		t.Errorf("recheck() describes = %d, gets = %d, want 1, 1", client.describes, client.gets)
+	}
+
+	// Rotated
+	client.value, client.versionID = "new-key", "v2"
+	now = now.Add(61 * time.Second)
+	cache.recheck("provider2")
+	if got, _ := cache.get(context.Background(), "provider2"); got != "new-key" {
+		t.Errorf("get() = %v, want the rotated secret", got)
+	}
+
+	// Secrets Manager falls over, keep what we have
+	client.describeErr = errors.New("ThrottlingException")
+	now = now.Add(61 * time.Second)
+	cache.recheck("provider2")
+	if got, _ := cache.get(context.Background(), "provider2"); got != "new-key" {
+		t.Errorf("get() = %v, want the cached secret when the recheck fails", got)
+	}
+}
+
+func Test_secretCache_getFails(t *testing.T) {
+	cache := newSecretCache(&fakeSecretsManager{fail: true}, SecretsConfig{})
+	if _, err := cache.get(context.Background(), "provider2"); err == nil {
+		t.Errorf("get() should fail when the secret can't be fetched")
+	}
+}
+
+func Test_secretField(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", value: "abc", want: "abc"},
+		{name: "json", value: "{\"hmacKey\": \"abc\"}", key: "hmacKey", want: "abc"},
+		{name: "missingKey", value: "{\"other\": \"abc\"}", key: "hmacKey", wantErr: true},
+		{name: "notJson", value: "abc", key: "hmacKey", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := secretField(tt.value, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("secretField() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("secretField() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_secretIDs(t *testing.T) {
+	config := &Config{Providers: []Provider{
+		{Name: "provider1"},
+		{Name: "provider2", Signing: &SigningConfig{
+			Primary:   &SigningKey{ID: "new", SecretID: "accountvalidator/provider2"},
+			Secondary: &SigningKey{ID: "old", SecretEnv: "PROVIDER2_KEY"},
+		}},
+		{Name: "provider3", Signing: &SigningConfig{Primary: &SigningKey{ID: "a", SecretID: "accountvalidator/provider2"}}},
+	}}
+	if got, want := config.secretIDs(), []string{"accountvalidator/provider2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("secretIDs() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+  Security events. Anything that looks like someone probing us is written as a structured event, one JSON line on
+  stdout and a SecurityEvent on the EventBridge bus in EVENT_BUS_NAME, which a rule copies into the security log
+  group so it isn't lost in the application logs. The line is written straight away, the events are buffered and put
+  on the bus with the telemetry flush so a request we're rejecting isn't held up talking to EventBridge:
+
+    {"securityEvent": {"type": "schema_violation_flood", "caller": "arn:aws:iam::123456789012:user/ci",
+                       "sourceIp": "203.0.113.7", "requestId": "c6af9ac6-...", "detail": "20 bad requests in 1m0s",
+                       "at": "2024-06-01T09:00:00Z"}}
+
+  type is one of
+
+    auth_failure             a caller we couldn't authenticate
+    rate_limited             a caller over its limit
+    denylisted               a caller on a denylist
+    not_allowlisted          a source IP outside the allowlist
+    schema_violation_flood   one caller sending floodThreshold bad requests within floodWindowSeconds, reported once
+                             a window
+
+  The caller is the authorizer's principalId, the IAM user ARN, the API key id or the AWS caller, whichever API
+  Gateway gives us first, and the source IP is API Gateway's too. Floods are counted per caller, anonymous callers per
+  source IP so one noisy client doesn't hide the others. The checks that raise the other types report
+  through config.security.report.
+
+    security:
+      floodThreshold: 20        # default 20
+      floodWindowSeconds: 60    # default 60
+
+  Reporting never fails a request, a bus that's down is logged, as are events dropped because maxPendingSecurityEvents
+  were already waiting for the flush.
+*/
+
+const SecurityEventDetailType = "SecurityEvent"
+
+const (
+	SecurityAuthFailure = "auth_failure"
+	SecurityRateLimited = "rate_limited"
+	SecurityDenylisted  = "denylisted"
+	// See allowlist.go
+	SecurityNotAllowlisted = "not_allowlisted"
+	SecuritySchemaFlood    = "schema_violation_flood"
+)
+
+const (
+	defaultFloodThreshold = 20
+	defaultFloodWindow    = time.Minute
+	// Past this we stop tracking new callers until the old windows are swept
+	maxTrackedCallers = 10000
+	// Past this events are dropped until the next flush
+	maxPendingSecurityEvents = 1000
+	securityPublishTimeout   = 2 * time.Second
+)
+
+type SecurityConfig struct {
+	FloodThreshold     int      `yaml:"floodThreshold"`
+	FloodWindowSeconds int      `yaml:"floodWindowSeconds"`
+	Allowlist          []string `yaml:"allowlist"`
+	// See replay.go
+	RequestSignatures *RequestSignaturesConfig `yaml:"requestSignatures"`
+}
+
+type SecurityEvent struct {
+	Type      string    `json:"type"`
+	Caller    string    `json:"caller"`
+	SourceIP  string    `json:"sourceIp,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+func (security SecurityConfig) validate() error {
+	if security.FloodThreshold < 0 || security.FloodWindowSeconds < 0 {
+		return errors.New("security flood limits can't be negative")
+	}
+	return security.RequestSignatures.validate()
+}
+
+// Who API Gateway says is calling, anonymous when it doesn't know
+func callerIdentity(request Request) string {
+	if principal, ok := request.RequestContext.Authorizer["principalId"].(string); ok && principal != "" {
+		return principal
+	}
+	identity := request.RequestContext.Identity
+	for _, caller := range []string{identity.UserArn, identity.APIKeyID, identity.Caller} {
+		if caller != "" {
+			return caller
+		}
+	}
+	return "anonymous"
+}
+
+// Who floods are counted against, anonymous callers by source IP
+func floodKey(request Request) string {
+	caller := callerIdentity(request)
+	if sourceIP := request.RequestContext.Identity.SourceIP; caller == "anonymous" && sourceIP != "" {
+		return caller + "/" + sourceIP
+	}
+	return caller
+}
+
+// Bad requests from one caller in the current window
+type violationWindow struct {
+	start    time.Time
+	count    int
+	reported bool
+}
+
+// Where security events go, for the life of the container
+type securityLog struct {
+	mu         sync.Mutex
+	violations map[string]*violationWindow
+	threshold  int
+	window     time.Duration
+	events     eventPublisher
+	pending    []interface{}
+	dropped    int
+	out        io.Writer
+	now        func() time.Time
+}
+
+func newSecurityLog(config SecurityConfig, events eventPublisher, out io.Writer) *securityLog {
+	security := &securityLog{
+		violations: map[string]*violationWindow{},
+		threshold:  config.FloodThreshold,
+		window:     time.Duration(config.FloodWindowSeconds) * time.Second,
+		events:     events,
+		out:        out,
+		now:        time.Now,
+	}
+	if security.threshold == 0 {
+		security.threshold = defaultFloodThreshold
+	}
+	if security.window == 0 {
+		security.window = defaultFloodWindow
+	}
+	return security
+}
+
+// Writes the event out, buffering it for the next flush. A nil securityLog ignores everything.
+func (security *securityLog) report(ctx context.Context, request Request, eventType, detail string) {
+	if security == nil {
+		return
+	}
+	event := SecurityEvent{
+		Type:      eventType,
+		Caller:    callerIdentity(request),
+		SourceIP:  request.RequestContext.Identity.SourceIP,
+		RequestID: request.RequestContext.RequestID,
+		Detail:    detail,
+		At:        security.now().UTC(),
+	}
+	if line, err := marshalJSON(map[string]SecurityEvent{"securityEvent": event}); err != nil {
+		log.Print(err)
+	} else {
+		fmt.Fprintln(security.out, string(line))
+	}
+	if security.events == nil {
+		return
+	}
+	security.mu.Lock()
+	defer security.mu.Unlock()
+	if len(security.pending) >= maxPendingSecurityEvents {
+		security.dropped++
+		return
+	}
+	security.pending = append(security.pending, event)
+}
+
+// Puts the events reported since the last flush on the bus
+func (security *securityLog) flush(ctx context.Context) {
+	security.mu.Lock()
+	pending, dropped := security.pending, security.dropped
+	security.pending, security.dropped = nil, 0
+	security.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("dropped %d security events over the %d waiting for the flush", dropped, maxPendingSecurityEvents)
+	}
+	if len(pending) == 0 {
+		return
+	}
+	publishCtx, cancel := context.WithTimeout(ctx, securityPublishTimeout)
+	defer cancel()
+	if batch, ok := security.events.(batchEventPublisher); ok {
+		if err := batch.PutEvents(publishCtx, SecurityEventDetailType, pending); err != nil {
+			log.Printf("dropped %d security events: %v", len(pending), err)
+		}
+		return
+	}
+	for _, event := range pending {
+		if err := security.events.PutEvent(publishCtx, SecurityEventDetailType, event); err != nil {
+			log.Printf("dropped a security event: %v", err)
+		}
+	}
+}
+
+// Counts a request that didn't decode against its caller, or its source IP when it's anonymous, reporting a flood the first time the caller reaches the
+// threshold in a window
+func (security *securityLog) schemaViolation(ctx context.Context, request Request) {
+	if security == nil {
+		return
+	}
+	caller := floodKey(request)
+	now := security.now()
+	security.mu.Lock()
+	window, exists := security.violations[caller]
+	if !exists && len(security.violations) >= maxTrackedCallers {
+		security.sweep(now)
+		if len(security.violations) >= maxTrackedCallers {
+			security.mu.Unlock()
+			return
+		}
+	}
+	if !exists || now.Sub(window.start) >= security.window {
+		window = &violationWindow{start: now}
+		security.violations[caller] = window
+	}
+	window.count++
+	count := window.count
+	flood := count >= security.threshold && !window.reported
+	if flood {
+		window.reported = true
+	}
+	security.mu.Unlock()
+	if flood {
+		security.report(ctx, request, SecuritySchemaFlood, fmt.Sprintf("%d bad requests in %v", count, security.window))
+	}
+}
+
+// Drops callers whose window is over, the caller holds mu
+func (security *securityLog) sweep(now time.Time) {
+	for caller, window := range security.violations {
+		if now.Sub(window.start) >= security.window {
+			delete(security.violations, caller)
+		}
+	}
+}
+
+// Security events go out on the bus set up by setupVerdicts with the telemetry flush, so this comes after both
+func (config *Config) setupSecurity() {
+	config.security = newSecurityLog(config.Security, config.events, os.Stdout)
+	if config.events != nil {
+		config.telemetry.onFlush(config.security.flush)
+	}
+}
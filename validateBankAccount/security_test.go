@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSecurityEvents struct {
+	detailTypes []string
+	events      []SecurityEvent
+}
+
+func (bus *fakeSecurityEvents) PutEvent(ctx context.Context, detailType string, detail interface{}) error {
+	bus.detailTypes = append(bus.detailTypes, detailType)
+	bus.events = append(bus.events, detail.(SecurityEvent))
+	return nil
+}
+
+func securityRequest(caller, sourceIP string) Request {
+	request := Request{HTTPMethod: "POST", Body: "{\"accountNumber\": 12345670}"}
+	request.RequestContext.Identity.UserArn = caller
+	request.RequestContext.Identity.SourceIP = sourceIP
+	request.RequestContext.RequestID = "req-1"
+	return request
+}
+
+func Test_callerIdentity(t *testing.T) {
+	authorized := Request{}
+	authorized.RequestContext.Authorizer = map[string]interface{}{"principalId": "client-42"}
+	authorized.RequestContext.Identity.UserArn = "arn:aws:iam::123456789012:user/ci"
+	apiKey := Request{}
+	apiKey.RequestContext.Identity.APIKeyID = "key-1"
+	tests := []struct {
+		name    string
+		request Request
+		want    string
+	}{
+		{"authorizer", authorized, "client-42"},
+		{"user", securityRequest("arn:aws:iam::123456789012:user/ci", ""), "arn:aws:iam::123456789012:user/ci"},
+		{"apiKey", apiKey, "key-1"},
+		{"anonymous", Request{}, "anonymous"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := callerIdentity(tt.request); got != tt.want {
+				t.Errorf("callerIdentity() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_securityLog_schemaViolation(t *testing.T) {
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	bus := &fakeSecurityEvents{}
+	out := &bytes.Buffer{}
+	security := newSecurityLog(SecurityConfig{FloodThreshold: 3, FloodWindowSeconds: 60}, bus, out)
+	security.now = func() time.Time { return now }
+
+	prober := securityRequest("arn:aws:iam::123456789012:user/prober", "203.0.113.7")
+	for i := 0; i < 5; i++ {
+		security.schemaViolation(context.Background(), prober)
+	}
+	// Someone else's mistakes are counted separately
+	security.schemaViolation(context.Background(), securityRequest("arn:aws:iam::123456789012:user/ci", "198.51.100.1"))
+	// Nothing goes on the bus until the flush
+	if len(bus.events) != 0 {
+		t.Errorf("%d events before the flush, want 0", len(bus.events))
+	}
+	security.flush(context.Background())
+
+	want := []SecurityEvent{{
+		Type:      SecuritySchemaFlood,
+		Caller:    "arn:aws:iam::123456789012:user/prober",
+		SourceIP:  "203.0.113.7",
+		RequestID: "req-1",
+		Detail:    "3 bad requests in 1m0s",
+		At:        now,
+	}}
+	if !reflect.DeepEqual(bus.events, want) || !reflect.DeepEqual(bus.detailTypes, []string{SecurityEventDetailType}) {
+		t.Errorf("events = %+v, want %+v", bus.events, want)
+	}
+	wantLine := "{\"securityEvent\":{\"type\":\"schema_violation_flood\",\"caller\":\"arn:aws:iam::123456789012:user/prober\"," +
+		"\"sourceIp\":\"203.0.113.7\",\"requestId\":\"req-1\",\"detail\":\"3 bad requests in 1m0s\",\"at\":\"2024-06-01T09:00:00Z\"}}\n"
+	if out.String() != wantLine {
+		t.Errorf("log = %q, want %q", out.String(), wantLine)
+	}
+
+	// A new window can flood again
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		security.schemaViolation(context.Background(), prober)
+	}
+	security.flush(context.Background())
+	if len(bus.events) != 2 {
+		t.Errorf("%d events, want 2", len(bus.events))
+	}
+}
+
+func Test_securityLog_schemaViolation_anonymous(t *testing.T) {
+	bus := &fakeSecurityEvents{}
+	security := newSecurityLog(SecurityConfig{FloodThreshold: 2}, bus, &bytes.Buffer{})
+
+	// One noisy client doesn't use up everyone else's allowance
+	for i := 0; i < 2; i++ {
+		security.schemaViolation(context.Background(), securityRequest("", "203.0.113.7"))
+	}
+	security.schemaViolation(context.Background(), securityRequest("", "198.51.100.1"))
+	security.flush(context.Background())
+	if len(bus.events) != 1 || bus.events[0].SourceIP != "203.0.113.7" {
+		t.Errorf("events = %+v, want one flood from 203.0.113.7", bus.events)
+	}
+}
+
+type fakeBatchSecurityEvents struct {
+	fakeSecurityEvents
+	batches int
+}
+
+func (bus *fakeBatchSecurityEvents) PutEvents(ctx context.Context, detailType string, details []interface{}) error {
+	bus.batches++
+	for _, detail := range details {
+		bus.PutEvent(ctx, detailType, detail)
+	}
+	return nil
+}
+
+func Test_securityLog_flush(t *testing.T) {
+	bus := &fakeBatchSecurityEvents{}
+	security := newSecurityLog(SecurityConfig{}, bus, &bytes.Buffer{})
+	for i := 0; i < maxPendingSecurityEvents+5; i++ {
+		security.report(context.Background(), Request{}, SecurityRateLimited, "")
+	}
+	security.flush(context.Background())
+	if bus.batches != 1 || len(bus.events) != maxPendingSecurityEvents {
+		t.Errorf("%d events in %d batches, want %d in 1", len(bus.events), bus.batches, maxPendingSecurityEvents)
+	}
+	// The buffer starts again after a flush
+	security.report(context.Background(), Request{}, SecurityRateLimited, "")
+	security.flush(context.Background())
+	if len(bus.events) != maxPendingSecurityEvents+1 {
+		t.Errorf("%d events, want %d", len(bus.events), maxPendingSecurityEvents+1)
+	}
+}
+
+func Test_securityLog_nil(t *testing.T) {
+	var security *securityLog
+	security.schemaViolation(context.Background(), Request{})
+	security.report(context.Background(), Request{}, SecurityDenylisted, "")
+}
+
+func TestConfig_Handler_schemaViolationFlood(t *testing.T) {
+	out := &bytes.Buffer{}
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		security:  newSecurityLog(SecurityConfig{FloodThreshold: 2}, nil, out),
+	}
+	request := securityRequest("arn:aws:iam::123456789012:user/prober", "203.0.113.7")
+	for i := 0; i < 2; i++ {
+		if _, err := config.Handler(context.Background(), request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.Contains(out.String(), "\"type\":\"schema_violation_flood\"") {
+		t.Errorf("log = %q, want a flood event", out.String())
+	}
+}
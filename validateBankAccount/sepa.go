@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+  SEPA reachability. A provider of type sepa doesn't call anyone, it looks the request's bic up in a copy of the EBA
+  reachability data we keep in S3 and says whether the bank can be reached for SEPA credit transfers (SCT) and direct
+  debits (SDD):
+
+    - name: sepa
+      type: sepa
+      sepa:
+        dataset: s3://accountvalidator-data/sepa/reachability.csv
+        refreshMinutes: 60   # default 60
+        scheme: sct          # sct or sdd, the one isValid is about, default sct
+
+  The dataset is a csv with a header row naming at least bic, sct and sdd columns:
+
+    bic,sct,sdd
+    COBADEFFXXX,true,true
+    NWBKGB2LXXX,true,false
+
+  It's loaded when the container starts and reloaded in the background once it's older than refreshMinutes, a failed
+  reload keeps the copy we have. An 8 character bic means the head office, XXX, and a branch that isn't listed falls
+  back to its head office. The result carries both schemes:
+
+    {"provider": "sepa", "isValid": true, "reachability": {"sct": true, "sdd": false}}
+
+  A request without a bic gets missing_bic, and request_failed until the dataset has loaded once.
+*/
+
+const (
+	SchemeSCT = "sct"
+	SchemeSDD = "sdd"
+)
+
+// The request has nothing for a sepa provider to look up
+const ProviderErrorMissingBIC = "missing_bic"
+
+const defaultSEPARefresh = 60 * time.Minute
+
+type SEPAConfig struct {
+	Dataset        string `yaml:"dataset"`
+	RefreshMinutes int    `yaml:"refreshMinutes"`
+	Scheme         string `yaml:"scheme"`
+}
+
+type Reachability struct {
+	SCT bool `json:"sct"`
+	SDD bool `json:"sdd"`
+}
+
+func (sepa *SEPAConfig) validate() error {
+	if sepa == nil {
+		return fmt.Errorf("sepa providers need a sepa dataset")
+	}
+	if _, err := parseS3Location(sepa.Dataset); err != nil {
+		return err
+	}
+	if sepa.Scheme != "" && sepa.Scheme != SchemeSCT && sepa.Scheme != SchemeSDD {
+		return fmt.Errorf("sepa scheme %s should be %s or %s", sepa.Scheme, SchemeSCT, SchemeSDD)
+	}
+	if sepa.RefreshMinutes < 0 {
+		return fmt.Errorf("sepa refreshMinutes can't be negative")
+	}
+	return nil
+}
+
+// The reachability dataset for one provider, for the life of the container
+type sepaDirectory struct {
+	mu         sync.Mutex
+	banks      map[string]Reachability
+	loadedAt   time.Time
+	refreshing bool
+	refresh    time.Duration
+	scheme     string
+	fetch      func(ctx context.Context) ([]byte, error)
+	now        func() time.Time
+}
+
+func newSEPADirectory(config SEPAConfig, fetch func(ctx context.Context) ([]byte, error)) *sepaDirectory {
+	directory := &sepaDirectory{
+		refresh: time.Duration(config.RefreshMinutes) * time.Minute,
+		scheme:  config.Scheme,
+		fetch:   fetch,
+		now:     time.Now,
+	}
+	if directory.refresh <= 0 {
+		directory.refresh = defaultSEPARefresh
+	}
+	if directory.scheme == "" {
+		directory.scheme = SchemeSCT
+	}
+	return directory
+}
+
+// Parses the reachability csv, keyed on the 11 character bic
+func parseReachability(data []byte) (map[string]Reachability, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reachability dataset has no header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"bic", SchemeSCT, SchemeSDD} {
+		if _, exists := columns[name]; !exists {
+			return nil, fmt.Errorf("reachability dataset is missing the %s column", name)
+		}
+	}
+	banks := map[string]Reachability{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return banks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		sct, sctErr := strconv.ParseBool(record[columns[SchemeSCT]])
+		sdd, sddErr := strconv.ParseBool(record[columns[SchemeSDD]])
+		if sctErr != nil || sddErr != nil {
+			return nil, fmt.Errorf("reachability dataset line %d should have true or false for sct and sdd", line)
+		}
+		banks[headOffice(record[columns["bic"]])] = Reachability{SCT: sct, SDD: sdd}
+	}
+}
+
+// 8 character bics are the head office
+func headOffice(bic string) string {
+	bic = strings.ToUpper(strings.TrimSpace(bic))
+	if len(bic) == 8 {
+		return bic + "XXX"
+	}
+	return bic
+}
+
+// Fetches and parses the dataset, keeping what we have if that fails
+func (directory *sepaDirectory) load(ctx context.Context) error {
+	data, err := directory.fetch(ctx)
+	if err == nil {
+		var banks map[string]Reachability
+		if banks, err = parseReachability(data); err == nil {
+			directory.mu.Lock()
+			directory.banks, directory.loadedAt = banks, directory.now()
+			directory.mu.Unlock()
+		}
+	}
+	directory.mu.Lock()
+	directory.refreshing = false
+	directory.mu.Unlock()
+	return err
+}
+
+// Looks the bic up, starting a reload in the background if the dataset is stale. False until it has loaded once.
+func (directory *sepaDirectory) lookup(bic string) (Reachability, bool) {
+	directory.mu.Lock()
+	defer directory.mu.Unlock()
+	if directory.now().Sub(directory.loadedAt) >= directory.refresh && !directory.refreshing {
+		directory.refreshing = true
+		go func() {
+			if err := directory.load(context.Background()); err != nil {
+				log.Printf("unable to reload the sepa reachability dataset: %v", err)
+			}
+		}()
+	}
+	if directory.banks == nil {
+		return Reachability{}, false
+	}
+	bic = headOffice(bic)
+	if reachability, exists := directory.banks[bic]; exists {
+		return reachability, true
+	}
+	if len(bic) == 11 {
+		return directory.banks[bic[:8]+"XXX"], true
+	}
+	return Reachability{}, true
+}
+
+// The sepa provider's result for the bic
+func (directory *sepaDirectory) check(name, bic string) BankAccountValidationResult {
+	result := BankAccountValidationResult{Provider: name}
+	if bic == "" {
+		result.Error = ProviderErrorMissingBIC
+		return result
+	}
+	if directory == nil {
+		result.Error = ProviderErrorRequest
+		return result
+	}
+	reachability, loaded := directory.lookup(bic)
+	if !loaded {
+		result.Error = ProviderErrorRequest
+		return result
+	}
+	result.Reachability = &reachability
+	result.IsValid = reachability.SCT
+	if directory.scheme == SchemeSDD {
+		result.IsValid = reachability.SDD
+	}
+	return result
+}
+
+// Loads the dataset for every sepa provider. One that fails to load answers request_failed until a reload works.
+func (config *Config) setupSEPA(ctx context.Context) {
+	var client *s3Client
+	for i := range config.Providers {
+		provider := &config.Providers[i]
+		if provider.Type != ProviderTypeSEPA {
+			continue
+		}
+		if client == nil {
+			var err error
+			if client, err = newS3Client(ctx); err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		location, _ := parseS3Location(provider.SEPA.Dataset)
+		provider.sepa = newSEPADirectory(*provider.SEPA, func(ctx context.Context) ([]byte, error) {
+			return client.getObject(ctx, location)
+		})
+		if err := provider.sepa.load(ctx); err != nil {
+			log.Printf("unable to load the sepa reachability dataset for %s: %v", provider.Name, err)
+		}
+	}
+}
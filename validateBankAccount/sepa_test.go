@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const testReachability = `bic,sct,sdd
+COBADEFFXXX,true,true
+NWBKGB2L,true,false
+DEUTDEFF500,false,false
+`
+
+func loadedDirectory(t *testing.T, scheme string) *sepaDirectory {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	directory := newSEPADirectory(SEPAConfig{Scheme: scheme}, func(ctx context.Context) ([]byte, error) {
+		return []byte(testReachability), nil
+	})
+	directory.now = func() time.Time { return now }
+	if err := directory.load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return directory
+}
+
+func Test_parseReachability(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]Reachability
+		wantErr bool
+	}{
+		{name: "dataset", data: testReachability, want: map[string]Reachability{
+			"COBADEFFXXX": {SCT: true, SDD: true},
+			"NWBKGB2LXXX": {SCT: true},
+			"DEUTDEFF500": {},
+		}},
+		{name: "columnsInAnyOrder", data: "SDD, name, BIC, SCT\nfalse, Bank, cobadeffxxx, true\n", want: map[string]Reachability{
+			"COBADEFFXXX": {SCT: true},
+		}},
+		{name: "empty", data: "", wantErr: true},
+		{name: "missingColumn", data: "bic,sct\nCOBADEFFXXX,true\n", wantErr: true},
+		{name: "notBool", data: "bic,sct,sdd\nCOBADEFFXXX,yes,no\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseReachability([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReachability() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseReachability() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_sepaDirectory_check(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme string
+		bic    string
+		want   BankAccountValidationResult
+	}{
+		{name: "reachable", bic: "COBADEFFXXX", want: BankAccountValidationResult{Provider: "sepa", IsValid: true, Reachability: &Reachability{SCT: true, SDD: true}}},
+		{name: "headOffice", bic: "COBADEFF", want: BankAccountValidationResult{Provider: "sepa", IsValid: true, Reachability: &Reachability{SCT: true, SDD: true}}},
+		{name: "branchFallsBack", bic: "NWBKGB2L123", want: BankAccountValidationResult{Provider: "sepa", IsValid: true, Reachability: &Reachability{SCT: true}}},
+		{name: "sddOnly", scheme: SchemeSDD, bic: "NWBKGB2L", want: BankAccountValidationResult{Provider: "sepa", Reachability: &Reachability{SCT: true}}},
+		{name: "listedUnreachable", bic: "DEUTDEFF500", want: BankAccountValidationResult{Provider: "sepa", Reachability: &Reachability{}}},
+		{name: "unlisted", bic: "BARCGB22", want: BankAccountValidationResult{Provider: "sepa", Reachability: &Reachability{}}},
+		{name: "missingBIC", bic: "", want: BankAccountValidationResult{Provider: "sepa", Error: ProviderErrorMissingBIC}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loadedDirectory(t, tt.scheme).check("sepa", tt.bic); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("check() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_sepaDirectory_notLoaded(t *testing.T) {
+	var directory *sepaDirectory
+	want := BankAccountValidationResult{Provider: "sepa", Error: ProviderErrorRequest}
+	if got := directory.check("sepa", "COBADEFFXXX"); !reflect.DeepEqual(got, want) {
+		t.Errorf("nil check() = %+v, want %+v", got, want)
+	}
+
+	failing := newSEPADirectory(SEPAConfig{}, func(ctx context.Context) ([]byte, error) {
+		return nil, errors.New("access denied")
+	})
+	if err := failing.load(context.Background()); err == nil {
+		t.Error("load() should fail")
+	}
+	if got := failing.check("sepa", "COBADEFFXXX"); !reflect.DeepEqual(got, want) {
+		t.Errorf("unloaded check() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_sepaDirectory_refresh(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	dataset := make(chan string, 2)
+	dataset <- testReachability
+	dataset <- "bic,sct,sdd\nCOBADEFFXXX,false,false\n"
+	fetched := make(chan struct{}, 2)
+	directory := newSEPADirectory(SEPAConfig{RefreshMinutes: 10}, func(ctx context.Context) ([]byte, error) {
+		defer func() { fetched <- struct{}{} }()
+		return []byte(<-dataset), nil
+	})
+	directory.now = func() time.Time { return now }
+	if err := directory.load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-fetched
+
+	if got, _ := directory.lookup("COBADEFFXXX"); !got.SCT {
+		t.Fatalf("lookup() before refresh = %+v", got)
+	}
+	now = now.Add(10 * time.Minute)
+	// Stale lookups answer from the copy we have while the reload runs
+	if got, _ := directory.lookup("COBADEFFXXX"); !got.SCT {
+		t.Fatalf("stale lookup() = %+v", got)
+	}
+	<-fetched
+	for i := 0; i < 100; i++ {
+		if got, _ := directory.lookup("COBADEFFXXX"); !got.SCT {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("lookup() should see the reloaded dataset")
+}
+
+func TestSEPAConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *SEPAConfig
+		wantErr bool
+	}{
+		{name: "valid", config: &SEPAConfig{Dataset: "s3://bucket/sepa/reachability.csv", Scheme: SchemeSDD}},
+		{name: "missing", config: nil, wantErr: true},
+		{name: "notS3", config: &SEPAConfig{Dataset: "https://example.com/reachability.csv"}, wantErr: true},
+		{name: "noKey", config: &SEPAConfig{Dataset: "s3://bucket"}, wantErr: true},
+		{name: "unknownScheme", config: &SEPAConfig{Dataset: "s3://bucket/key", Scheme: "instant"}, wantErr: true},
+		{name: "negativeRefresh", config: &SEPAConfig{Dataset: "s3://bucket/key", RefreshMinutes: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_checkProviders_sepa(t *testing.T) {
+	provider := Provider{Name: "sepa", Type: ProviderTypeSEPA, sepa: loadedDirectory(t, "")}
+	ctx := withAccountDetails(context.Background(), AccountDetails{BIC: "COBADEFFXXX"})
+	got := checkProviders(ctx, "DE89370400440532013000", []Provider{provider})
+	want := BankAccountValidationResponse{Result: []BankAccountValidationResult{
+		{Provider: "sepa", IsValid: true, Reachability: &Reachability{SCT: true, SDD: true}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkProviders() = %+v, want %+v", got, want)
+	}
+}
+
+// An S3 client for a test server, with the bucket in the path
+func testS3Client(endpoint string) *s3Client {
+	return &s3Client{api: s3.New(s3.Options{
+		Region:       "eu-west-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		BaseEndpoint: aws.String(endpoint),
+		UsePathStyle: true,
+	})}
+}
+
+func Test_s3Client_getObject(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bucket/sepa/reachability.csv" || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256") || r.Header.Get("X-Amz-Content-Sha256") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(testReachability))
+	}))
+	defer server.Close()
+
+	client := testS3Client(server.URL)
+	got, err := client.getObject(context.Background(), s3Location{Bucket: "bucket", Key: "sepa/reachability.csv"})
+	if err != nil || string(got) != testReachability {
+		t.Errorf("getObject() = %q, %v", got, err)
+	}
+	if _, err := client.getObject(context.Background(), s3Location{Bucket: "bucket", Key: "missing.csv"}); err == nil {
+		t.Error("getObject() should fail on a 403")
+	}
+}
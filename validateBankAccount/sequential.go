@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+  Sequential strategy. Some callers pay per provider call and would rather wait than fan out, so with
+
+    {"accountNumber": "12345678", "providers": ["provider2", "provider1"], "strategy": "sequential"}
+
+  the providers are a preference list, called one at a time in that order until one gives a definitive answer (valid
+  or invalid, not an error). Only the providers actually called show up in the result. Without providers it's the
+  order they're configured in. With minProviders it keeps going until that many have answered, and the aggregate is
+  valid when every answer it stopped on said valid. Groups and weights don't apply, and the fan-out cap still counts
+  every provider on the list.
+*/
+
+const StrategySequential = "sequential"
+
+func isSequential(strategy *string) bool {
+	return strategy != nil && *strategy == StrategySequential
+}
+
+// Calls the providers one after another until answers of them have answered, closing the channel after the last
+func checkProvidersSequential(ctx context.Context, accountNumber string, providers []Provider, answers int) <-chan BankAccountValidationResult {
+	channel := make(chan BankAccountValidationResult, len(providers))
+	go func() {
+		defer close(channel)
+		answered := 0
+		for _, provider := range providers {
+			if answered >= answers || ctx.Err() != nil {
+				return
+			}
+			// Through the pool like any other call, waiting for each before the next
+			single := make(chan BankAccountValidationResult, 1)
+			var wg sync.WaitGroup
+			wg.Add(1)
+			providerPool.submit(ctx, func() { checkProvider(ctx, accountNumber, provider, single, &wg) })
+			wg.Wait()
+			result := <-single
+			if result.Error == "" {
+				answered++
+			}
+			channel <- result
+		}
+	}()
+	return channel
+}
+
+// Valid when every answer the sequence stopped on was
+func sequentialVerdict(results []BankAccountValidationResult) bool {
+	answers := 0
+	for _, result := range results {
+		if result.Local || result.Error != "" {
+			continue
+		}
+		if !result.IsValid {
+			return false
+		}
+		answers++
+	}
+	return answers > 0
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConfig_sequential(t *testing.T) {
+	providers := []Provider{
+		{Name: "provider1", Type: ProviderTypeSimulated},
+		{Name: "provider2", Type: ProviderTypeSimulated},
+		{Name: "provider3", Type: ProviderTypeSimulated},
+	}
+	tests := []struct {
+		name     string
+		min      int
+		body     string
+		wantBody string
+	}{
+		{"firstAnswers", 0, "{\"accountNumber\": \"12345670\", \"providers\": [\"provider2\", \"provider1\"], \"strategy\": \"sequential\"}",
+			"{\"result\":[{\"provider\":\"provider2\",\"isValid\":true}],\"aggregate\":{\"strategy\":\"sequential\",\"isValid\":true}}"},
+		{"configOrder", 0, "{\"accountNumber\": \"12345671\", \"strategy\": \"sequential\"}",
+			"{\"result\":[{\"provider\":\"provider1\",\"isValid\":false}],\"aggregate\":{\"strategy\":\"sequential\",\"isValid\":false}}"},
+		// Errors aren't answers, so it keeps going to the end
+		{"allError", 0, "{\"accountNumber\": \"12349999\", \"providers\": [\"provider1\", \"provider3\"], \"strategy\": \"sequential\"}",
+			"{\"result\":[{\"provider\":\"provider1\",\"isValid\":false,\"error\":\"request_failed\"},{\"provider\":\"provider3\",\"isValid\":false,\"error\":\"request_failed\"}],\"aggregate\":{\"strategy\":\"sequential\",\"isValid\":false}}"},
+		{"minProviders", 2, "{\"accountNumber\": \"12345670\", \"strategy\": \"sequential\"}",
+			"{\"result\":[{\"provider\":\"provider1\",\"isValid\":true},{\"provider\":\"provider2\",\"isValid\":true}],\"aggregate\":{\"strategy\":\"sequential\",\"isValid\":true}}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Providers: providers, MinProviders: tt.min}
+			response, err := config.Handler(context.Background(), Request{HTTPMethod: "POST", Body: tt.body})
+			if err != nil || response.Body != tt.wantBody {
+				t.Errorf("Handler() = %d %s, want %s", response.StatusCode, response.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_sequentialVerdict(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []BankAccountValidationResult
+		want    bool
+	}{
+		{"none", nil, false},
+		{"errorThenValid", []BankAccountValidationResult{{Provider: "provider1", Error: ProviderErrorTimeout}, {Provider: "provider2", IsValid: true}}, true},
+		{"disagree", []BankAccountValidationResult{{Provider: "provider1", IsValid: true}, {Provider: "provider2"}}, false},
+		{"localIgnored", []BankAccountValidationResult{{Provider: "provider1", IsValid: true}, {Provider: "bic", Local: true}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sequentialVerdict(tt.results); got != tt.want {
+				t.Errorf("sequentialVerdict() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambdaurl"
+)
+
+/*
+  Standalone HTTP server mode. Set SERVER_ADDR (e.g. ":8080") and the binary serves the same handler over plain
+  HTTP instead of waiting for Lambda invocations. Handy for local testing and for pointing the load tester at
+  something that isn't API Gateway. With STORE=memory (see store.go) it doesn't need AWS at all.
+*/
+
+type HandlerFunc func(ctx context.Context, request Request) (Response, error)
+
+// Translates the http request into the API Gateway proxy request our handler expects
+func toRequest(r *http.Request) (Request, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return Request{}, err
+	}
+	request := requestHead(r)
+	request.Body = string(body)
+	return request, nil
+}
+
+// Everything but the body
+func requestHead(r *http.Request) Request {
+	headers := map[string]string{}
+	for name, values := range r.Header {
+		headers[name] = strings.Join(values, ",")
+	}
+	query := map[string]string{}
+	for name, values := range r.URL.Query() {
+		query[name] = values[0]
+	}
+	sourceIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sourceIP = r.RemoteAddr
+	}
+	// Function URL requests have no RemoteAddr, the caller's address is in the event
+	if event, exists := lambdaurl.RequestFromContext(r.Context()); exists && event.RequestContext.HTTP.SourceIP != "" {
+		sourceIP = event.RequestContext.HTTP.SourceIP
+	}
+	request := Request{
+		Path:                  r.URL.Path,
+		HTTPMethod:            r.Method,
+		Headers:               headers,
+		QueryStringParameters: query,
+	}
+	request.RequestContext.Identity.SourceIP = sourceIP
+	return request
+}
+
+// Writes the handler's response back out as a plain http response
+func writeResponse(w http.ResponseWriter, response Response) {
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	for name, values := range response.MultiValueHeaders {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	body := []byte(response.Body)
+	if response.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			log.Print(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body = decoded
+	}
+	w.WriteHeader(response.StatusCode)
+	w.Write(body)
+}
+
+// Everything the function does over plain http, with the streaming responses API Gateway can't do
+func (config *Config) streamingHandler() http.Handler {
+	handler := config.batchStream(config.eventStream(httpHandler(config.Router)))
+	// The checks the router makes, for the requests that don't reach it
+	handler = config.allowlisted(config.signatureVerified(config.payloadInspected(handler)))
+	return unrestricted(handler)
+}
+
+func httpHandler(handler HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request, err := toRequest(r)
+		if err != nil {
+			writeResponse(w, *handleError(err, "unable to read request"))
+			return
+		}
+		response, err := handler(r.Context(), request)
+		if err != nil {
+			log.Print(err)
+			writeResponse(w, *handleError(err, "internal error"))
+			return
+		}
+		writeResponse(w, response)
+	})
+}
+
+func serve(addr string, handler http.Handler) error {
+	log.Printf("listening on %s", addr)
+	return http.ListenAndServe(addr, handler)
+}
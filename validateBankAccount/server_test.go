@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_toRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "/application?debug=true", strings.NewReader("{\"accountNumber\": \"12345678\"}"))
+	r.Header.Set("Content-Type", "application/json")
+	r.RemoteAddr = "10.0.0.1:1234"
+
+	got, err := toRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Request{
+		Path:                  "/application",
+		HTTPMethod:            "POST",
+		Headers:               map[string]string{"Content-Type": "application/json"},
+		QueryStringParameters: map[string]string{"debug": "true"},
+		Body:                  "{\"accountNumber\": \"12345678\"}",
+	}
+	want.RequestContext.Identity.SourceIP = "10.0.0.1"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("toRequest() = %v, want %v", got, want)
+	}
+}
+
+func Test_httpHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    HandlerFunc
+		wantStatus int
+		wantBody   string
+		wantType   string
+	}{
+		{name: "ok",
+			handler: func(ctx context.Context, request Request) (Response, error) {
+				return Response{
+					StatusCode: 200,
+					Body:       request.Body,
+					Headers:    map[string]string{"Content-Type": "application/json"},
+				}, nil
+			},
+			wantStatus: 200,
+			wantBody:   "{}",
+			wantType:   "application/json",
+		},
+		{name: "base64",
+			handler: func(ctx context.Context, request Request) (Response, error) {
+				return Response{StatusCode: 200, Body: "e30=", IsBase64Encoded: true}, nil
+			},
+			wantStatus: 200,
+			wantBody:   "{}",
+		},
+		{name: "handlerError",
+			handler: func(ctx context.Context, request Request) (Response, error) {
+				return Response{}, errors.New("boom")
+			},
+			wantStatus: 500,
+			wantBody:   "{\"error\":\"internal error\"}",
+			wantType:   "application/json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			httpHandler(tt.handler).ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("{}")))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("body = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			if w.Header().Get("Content-Type") != tt.wantType {
+				t.Errorf("content type = %v, want %v", w.Header().Get("Content-Type"), tt.wantType)
+			}
+		})
+	}
+}
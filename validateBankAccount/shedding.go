@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"runtime/metrics"
+	"strconv"
+	"sync/atomic"
+)
+
+/*
+  Load shedding. When the container is already making too many provider calls, or its heap is too big, requests in a
+  lane marked shed get a 503 with Retry-After straight away rather than queueing until everything misses its SLA.
+  Lanes without shed are always let in.
+
+    shedding:
+      maxInFlightCalls: 200   # provider calls in flight across the container, 0 for no limit
+      maxHeapMb: 400          # live heap, 0 for no limit
+      retryAfterSeconds: 5    # default 1
+    priority:
+      lanes:
+        bulk:
+          shed: true
+
+  Applies to validation and batch requests over HTTP. Shed requests are counted in the ShedInFlight and ShedMemory
+  metrics.
+*/
+
+type SheddingConfig struct {
+	MaxInFlightCalls  int64 `yaml:"maxInFlightCalls"`
+	MaxHeapMB         int64 `yaml:"maxHeapMb"`
+	RetryAfterSeconds int   `yaml:"retryAfterSeconds"`
+}
+
+const (
+	defaultRetryAfterSeconds = 1
+	ShedReasonInFlight       = "InFlight"
+	ShedReasonMemory         = "Memory"
+)
+
+// Provider calls currently in flight, across every request in the container
+var inFlightCalls atomic.Int64
+
+// Live heap in bytes, a var so tests can fake it
+var heapBytes = func() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+func (shedding SheddingConfig) retryAfter() int {
+	if shedding.RetryAfterSeconds <= 0 {
+		return defaultRetryAfterSeconds
+	}
+	return shedding.RetryAfterSeconds
+}
+
+// Why the container is overloaded, or nothing if it isn't
+func (shedding SheddingConfig) overloaded() string {
+	if shedding.MaxInFlightCalls > 0 && inFlightCalls.Load() >= shedding.MaxInFlightCalls {
+		return ShedReasonInFlight
+	}
+	if shedding.MaxHeapMB > 0 && heapBytes() >= uint64(shedding.MaxHeapMB)<<20 {
+		return ShedReasonMemory
+	}
+	return ""
+}
+
+// The 503 for a request that should be shed, nil when it can go ahead
+func (config *Config) shed(request Request, validationRequest *BankAccountValidationRequest) *Response {
+	lane := config.Priority.lane(request, validationRequest)
+	if lane == nil || !lane.Shed {
+		return nil
+	}
+	reason := config.Shedding.overloaded()
+	if reason == "" {
+		return nil
+	}
+	config.telemetry.count("Shed"+reason, 1)
+	response := handleError(errors.New("shedding load: "+reason), "overloaded, retry later")
+	response.StatusCode = 503
+	response.Headers["Retry-After"] = strconv.Itoa(config.Shedding.retryAfter())
+	return response
+}
+
+func (shedding SheddingConfig) validate() error {
+	if shedding.MaxInFlightCalls < 0 || shedding.MaxHeapMB < 0 {
+		return errors.New("shedding limits can't be negative")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func testSheddingConfig() *Config {
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		Priority: PriorityConfig{
+			Default: "interactive",
+			Lanes: map[string]*Lane{
+				"interactive": {},
+				"bulk":        {Shed: true},
+			},
+		},
+		Shedding: SheddingConfig{MaxInFlightCalls: 2, MaxHeapMB: 100, RetryAfterSeconds: 5},
+	}
+	config.telemetry = newTelemetry(nil, &bytes.Buffer{})
+	return config
+}
+
+func TestConfig_Handler_shedding(t *testing.T) {
+	config := testSheddingConfig()
+	heap := uint64(0)
+	realHeapBytes := heapBytes
+	heapBytes = func() uint64 { return heap }
+	defer func() { heapBytes = realHeapBytes }()
+
+	tests := []struct {
+		name       string
+		inFlight   int64
+		heapMB     uint64
+		priority   string
+		wantStatus int
+	}{
+		{name: "quiet", priority: "bulk", wantStatus: 200},
+		{name: "busyInteractive", inFlight: 2, heapMB: 200, priority: "interactive", wantStatus: 200},
+		{name: "busyBulk", inFlight: 2, priority: "bulk", wantStatus: 503},
+		{name: "bigHeapBulk", heapMB: 100, priority: "bulk", wantStatus: 503},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inFlightCalls.Store(tt.inFlight)
+			defer inFlightCalls.Store(0)
+			heap = tt.heapMB << 20
+			request := Request{
+				HTTPMethod: "POST",
+				Path:       "/application",
+				Headers:    map[string]string{"X-Priority": tt.priority},
+				Body:       "{\"accountNumber\": \"12345670\"}",
+			}
+			response, err := config.Router(context.Background(), request)
+			if err != nil || response.StatusCode != tt.wantStatus {
+				t.Fatalf("Router() = %v %v, %v, want %v", response.StatusCode, response.Body, err, tt.wantStatus)
+			}
+			if tt.wantStatus == 503 && (response.Headers["Retry-After"] != "5" || response.Body != "{\"error\":\"overloaded, retry later\"}") {
+				t.Errorf("shed response = %+v", response)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	config.telemetry.out = &out
+	config.telemetry.flush(context.Background())
+	if !strings.Contains(out.String(), "\"ShedInFlight\":1") || !strings.Contains(out.String(), "\"ShedMemory\":1") {
+		t.Errorf("expected the shed counters in the metrics, got %v", out.String())
+	}
+}
+
+func TestConfig_BatchHandler_shedding(t *testing.T) {
+	config := testSheddingConfig()
+	inFlightCalls.Store(2)
+	defer inFlightCalls.Store(0)
+	request := Request{Headers: map[string]string{"X-Priority": "bulk"}, Body: testBatch}
+	if response, _ := config.BatchHandler(context.Background(), request); response.StatusCode != 503 {
+		t.Errorf("BatchHandler() = %v, want 503", response.StatusCode)
+	}
+}
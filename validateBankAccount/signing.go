@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+  HMAC request signing. Providers that want signed requests get an HMAC-SHA256 of "<unix timestamp>.<body>", hex
+  encoded, in the signature header along with the timestamp and the id of the key used, so they can tell which key to
+  check against.
+
+  Rotating a shared secret means agreeing a cutover time with the partner: the new key goes in as primary with
+  activeFrom set to that time and the old one moves to secondary. We sign with the secondary until activeFrom and the
+  primary after it, so nothing needs deploying at the cutover itself. Once it has passed the secondary can be dropped.
+
+    - name: provider2
+      url: https://provider2.com/v2/api/account/validate
+      signing:
+        header: X-Signature       # default X-Signature
+        primary:
+          id: "2024-06"
+          secretEnv: PROVIDER2_KEY_2024_06
+          activeFrom: 2024-06-01T09:00:00Z
+        secondary:
+          id: "2024-01"
+          secretEnv: PROVIDER2_KEY_2024_01
+*/
+
+const (
+	defaultSignatureHeader = "X-Signature"
+	signatureKeyIDHeader   = "X-Signature-Key-Id"
+	signatureTimeHeader    = "X-Signature-Timestamp"
+)
+
+type SigningConfig struct {
+	Header    string      `yaml:"header"`
+	Primary   *SigningKey `yaml:"primary"`
+	Secondary *SigningKey `yaml:"secondary"`
+}
+
+type SigningKey struct {
+	ID         string    `yaml:"id"`
+	Secret     string    `yaml:"secret"`
+	SecretEnv  string    `yaml:"secretEnv"`
+	SecretID   string    `yaml:"secretId"`
+	SecretKey  string    `yaml:"secretKey"`
+	ActiveFrom time.Time `yaml:"activeFrom"`
+}
+
+func (key *SigningKey) secret(ctx context.Context) (string, error) {
+	if key.SecretID != "" {
+		if providerSecrets == nil {
+			return "", fmt.Errorf("signing key %s uses Secrets Manager but it isn't set up", key.ID)
+		}
+		value, err := providerSecrets.get(ctx, key.SecretID)
+		if err != nil {
+			return "", err
+		}
+		return secretField(value, key.SecretKey)
+	}
+	if key.SecretEnv == "" {
+		return key.Secret, nil
+	}
+	secret, exists := os.LookupEnv(key.SecretEnv)
+	if !exists {
+		return "", fmt.Errorf("ENVVAR %s is required for signing key %s", key.SecretEnv, key.ID)
+	}
+	return secret, nil
+}
+
+func (key *SigningKey) validate() error {
+	if key.ID == "" {
+		return errors.New("signing key is missing an id")
+	}
+	sources := 0
+	for _, source := range []string{key.Secret, key.SecretEnv, key.SecretID} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("signing key %s should have only one of secret, secretEnv and secretId", key.ID)
+	}
+	// Secrets Manager is only read once we're up
+	if key.SecretID != "" {
+		return nil
+	}
+	secret, err := key.secret(context.Background())
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return fmt.Errorf("signing key %s has no secret", key.ID)
+	}
+	return nil
+}
+
+// Checks the keys are usable, called from compile
+func (signing *SigningConfig) validate() error {
+	if signing == nil {
+		return nil
+	}
+	if signing.Primary == nil {
+		return errors.New("signing needs a primary key")
+	}
+	for _, key := range []*SigningKey{signing.Primary, signing.Secondary} {
+		if key == nil {
+			continue
+		}
+		if err := key.validate(); err != nil {
+			return err
+		}
+	}
+	if signing.Secondary != nil && signing.Secondary.ID == signing.Primary.ID {
+		return fmt.Errorf("signing keys must have different ids, both are %s", signing.Primary.ID)
+	}
+	return nil
+}
+
+// The key to sign with at the given time
+func (signing *SigningConfig) activeKey(now time.Time) (*SigningKey, error) {
+	if signing.Primary.ActiveFrom.IsZero() || !now.Before(signing.Primary.ActiveFrom) {
+		return signing.Primary, nil
+	}
+	if signing.Secondary == nil {
+		return nil, fmt.Errorf("signing key %s isn't active until %s and there is no secondary", signing.Primary.ID, signing.Primary.ActiveFrom)
+	}
+	return signing.Secondary, nil
+}
+
+func signature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Adds the signature headers to an outbound request, a no-op for providers that don't sign
+func (signing *SigningConfig) sign(request *http.Request, body []byte, now time.Time) error {
+	if signing == nil {
+		return nil
+	}
+	key, err := signing.activeKey(now)
+	if err != nil {
+		return err
+	}
+	secret, err := key.secret(request.Context())
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return fmt.Errorf("signing key %s has an empty secret", key.ID)
+	}
+	header := signing.Header
+	if header == "" {
+		header = defaultSignatureHeader
+	}
+	request.Header.Set(header, signature(secret, now.Unix(), body))
+	request.Header.Set(signatureKeyIDHeader, key.ID)
+	request.Header.Set(signatureTimeHeader, strconv.FormatInt(now.Unix(), 10))
+	return nil
+}
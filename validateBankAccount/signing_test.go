@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestSigningConfig_activeKey(t *testing.T) {
+	cutover := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	signing := &SigningConfig{
+		Primary:   &SigningKey{ID: "new", Secret: "new-secret", ActiveFrom: cutover},
+		Secondary: &SigningKey{ID: "old", Secret: "old-secret"},
+	}
+	tests := []struct {
+		name    string
+		signing *SigningConfig
+		now     time.Time
+		want    string
+		wantErr bool
+	}{
+		{name: "beforeCutover", signing: signing, now: cutover.Add(-time.Second), want: "old"},
+		{name: "atCutover", signing: signing, now: cutover, want: "new"},
+		{name: "afterCutover", signing: signing, now: cutover.Add(time.Hour), want: "new"},
+		{name: "noCutover", signing: &SigningConfig{Primary: &SigningKey{ID: "only", Secret: "s"}}, now: cutover, want: "only"},
+		{name: "notActiveNoSecondary", signing: &SigningConfig{Primary: signing.Primary}, now: cutover.Add(-time.Second), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.signing.activeKey(tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("activeKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.ID != tt.want {
+				t.Errorf("activeKey() = %v, want %v", got.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestSigningConfig_validate(t *testing.T) {
+	t.Setenv("TEST_SIGNING_KEY", "from-env")
+	tests := []struct {
+		name    string
+		signing *SigningConfig
+		wantErr bool
+	}{
+		{name: "none", signing: nil},
+		{name: "inline", signing: &SigningConfig{Primary: &SigningKey{ID: "a", Secret: "s"}}},
+		{name: "env", signing: &SigningConfig{Primary: &SigningKey{ID: "a", SecretEnv: "TEST_SIGNING_KEY"}}},
+		{name: "missingEnv", signing: &SigningConfig{Primary: &SigningKey{ID: "a", SecretEnv: "TEST_SIGNING_KEY_MISSING"}}, wantErr: true},
+		{name: "noPrimary", signing: &SigningConfig{Secondary: &SigningKey{ID: "a", Secret: "s"}}, wantErr: true},
+		{name: "noSecret", signing: &SigningConfig{Primary: &SigningKey{ID: "a"}}, wantErr: true},
+		{name: "noID", signing: &SigningConfig{Primary: &SigningKey{Secret: "s"}}, wantErr: true},
+		{name: "sameID", signing: &SigningConfig{Primary: &SigningKey{ID: "a", Secret: "s"}, Secondary: &SigningKey{ID: "a", Secret: "t"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.signing.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSigningConfig_yaml(t *testing.T) {
+	var provider Provider
+	err := yaml.Unmarshal([]byte("name: provider2\nsigning:\n  primary:\n    id: \"2024-06\"\n    secret: s\n    activeFrom: 2024-06-01T09:00:00Z\n"), &provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC); !provider.Signing.Primary.ActiveFrom.Equal(want) {
+		t.Errorf("activeFrom = %v, want %v", provider.Signing.Primary.ActiveFrom, want)
+	}
+}
+
+func Test_callProvider_signed(t *testing.T) {
+	var got http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer server.Close()
+
+	provider := Provider{Name: "provider2", URL: server.URL, Signing: &SigningConfig{Primary: &SigningKey{ID: "k1", Secret: "secret"}}}
+	if result := callProvider(context.Background(), "12345678", provider, provider.URL); result.Error != "" {
+		t.Fatalf("callProvider() error = %v", result.Error)
+	}
+	timestamp, err := strconv.ParseInt(got.Get(signatureTimeHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("timestamp header = %q", got.Get(signatureTimeHeader))
+	}
+	if got.Get(signatureKeyIDHeader) != "k1" {
+		t.Errorf("key id header = %q, want k1", got.Get(signatureKeyIDHeader))
+	}
+	if want := signature("secret", timestamp, body); got.Get(defaultSignatureHeader) != want {
+		t.Errorf("signature = %q, want %q", got.Get(defaultSignatureHeader), want)
+	}
+}
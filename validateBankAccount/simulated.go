@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+/*
+  Simulated providers answer without leaving the Lambda, so QA can drive every branch in staging without partner
+  sandboxes:
+
+    - name: bureau-sim
+      type: simulated
+
+  The verdict is derived from the account number: a trailing even digit is valid, odd is invalid. Account numbers
+  ending in one of the magic suffixes below fail the way a real provider would instead.
+*/
+
+var simulatedFailures = map[string]string{
+	"0000": ProviderErrorTimeout,  // waits out the provider timeout first
+	"9999": ProviderErrorRequest,  // connection refused, DNS failure etc.
+	"8888": ProviderErrorResponse, // provider answered with something we can't parse
+}
+
+// How long a simulated timeout takes, overridden in tests
+var simulatedTimeout = providerTimeout
+
+func simulateProvider(accountNumber string, provider Provider) BankAccountValidationResult {
+	result := BankAccountValidationResult{Provider: provider.Name}
+	for suffix, failure := range simulatedFailures {
+		if strings.HasSuffix(accountNumber, suffix) {
+			if failure == ProviderErrorTimeout {
+				time.Sleep(simulatedTimeout)
+			}
+			result.Error = failure
+			return result
+		}
+	}
+	if accountNumber == "" {
+		return result
+	}
+	last := accountNumber[len(accountNumber)-1]
+	result.IsValid = last >= '0' && last <= '9' && (last-'0')%2 == 0
+	return result
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_simulateProvider(t *testing.T) {
+	simulatedTimeout = time.Millisecond
+	defer func() { simulatedTimeout = providerTimeout }()
+
+	provider := Provider{Name: "sim", Type: ProviderTypeSimulated}
+	tests := []struct {
+		name          string
+		accountNumber string
+		want          BankAccountValidationResult
+	}{
+		{name: "even", accountNumber: "12345678", want: BankAccountValidationResult{Provider: "sim", IsValid: true}},
+		{name: "odd", accountNumber: "12345677", want: BankAccountValidationResult{Provider: "sim", IsValid: false}},
+		{name: "notDigit", accountNumber: "1234567X", want: BankAccountValidationResult{Provider: "sim", IsValid: false}},
+		{name: "empty", accountNumber: "", want: BankAccountValidationResult{Provider: "sim", IsValid: false}},
+		{name: "timeout", accountNumber: "12340000", want: BankAccountValidationResult{Provider: "sim", Error: ProviderErrorTimeout}},
+		{name: "requestFailed", accountNumber: "12349999", want: BankAccountValidationResult{Provider: "sim", Error: ProviderErrorRequest}},
+		{name: "invalidResponse", accountNumber: "12348888", want: BankAccountValidationResult{Provider: "sim", Error: ProviderErrorResponse}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := simulateProvider(tt.accountNumber, provider); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("simulateProvider() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkProviders_simulated(t *testing.T) {
+	got := checkProviders(context.Background(), "12345678", []Provider{
+		{Name: "sim1", Type: ProviderTypeSimulated},
+		{Name: "sim2", Type: ProviderTypeSimulated},
+	})
+	want := BankAccountValidationResponse{
+		Result: []BankAccountValidationResult{
+			{Provider: "sim1", IsValid: true},
+			{Provider: "sim2", IsValid: true},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkProviders() = %v, want %v", got, want)
+	}
+}
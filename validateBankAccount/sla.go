@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+/*
+  Provider SLAs. Partners promise a success rate and a latency, and for QBRs we need to show how they did against
+  it over more than the life of a container:
+
+    providers:
+      - name: provider1
+        sla:
+          successRate: 99.5     # percent of calls answered
+          latencyMs: 800
+          latencyPercent: 95    # percent of calls answered within latencyMs, default 95
+
+  Every call we make (each retry included, calls the breaker stopped aren't made) is counted into an hourly bucket
+  in memory, and the buckets are added to the DynamoDB table in SLA_TABLE when the telemetry is flushed:
+
+    provider (S, hash key) | hour (S, 2006-01-02T15, range key) | calls (N) | answered (N) | fast (N) | expiresAt (N, ttl)
+
+  GET /providers/{name}/sla reads the table and reports the last 24 hours, 7 days and 30 days:
+
+    {"provider": "provider1", "sla": {"successRate": 99.5, "latencyMs": 800, "latencyPercent": 95},
+     "windows": [{"window": "24h", "calls": 1200, "successRate": 99.75, "withinLatency": 96.5, "compliance": 95.83, "met": true}, ...]}
+
+  compliance is the percentage of hours with calls that met both targets, met is whether the window as a whole did.
+  A provider without an sla is still counted and reported, just without targets.
+*/
+
+const (
+	defaultSLALatencyPercent = 95
+	slaHourFormat            = "2006-01-02T15"
+	// Buckets outlive the longest window with some to spare
+	slaRetention = 90 * 24 * time.Hour
+)
+
+// The windows GET /providers/{name}/sla reports
+var slaWindows = []struct {
+	name   string
+	length time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+type SLAConfig struct {
+	SuccessRate    float64 `yaml:"successRate" json:"successRate"`
+	LatencyMs      int     `yaml:"latencyMs" json:"latencyMs"`
+	LatencyPercent float64 `yaml:"latencyPercent" json:"latencyPercent"`
+}
+
+func (sla *SLAConfig) validate() error {
+	if sla == nil {
+		return nil
+	}
+	if sla.SuccessRate < 0 || sla.SuccessRate > 100 || sla.LatencyPercent < 0 || sla.LatencyPercent > 100 {
+		return errors.New("sla percentages should be between 0 and 100")
+	}
+	if sla.LatencyMs < 0 {
+		return errors.New("sla latencyMs can't be negative")
+	}
+	return nil
+}
+
+func (sla *SLAConfig) latencyPercent() float64 {
+	if sla.LatencyPercent == 0 {
+		return defaultSLALatencyPercent
+	}
+	return sla.LatencyPercent
+}
+
+// Calls in one hour
+type slaCounts struct {
+	Calls    int64
+	Answered int64
+	// Answered within the sla's latencyMs, or answered at all without one
+	Fast int64
+}
+
+func (counts *slaCounts) add(other slaCounts) {
+	counts.Calls += other.Calls
+	counts.Answered += other.Answered
+	counts.Fast += other.Fast
+}
+
+// Whether the counts meet the sla, true without one
+func (sla *SLAConfig) met(counts slaCounts) bool {
+	if sla == nil || counts.Calls == 0 {
+		return true
+	}
+	return percent(counts.Answered, counts.Calls) >= sla.SuccessRate && percent(counts.Fast, counts.Calls) >= sla.latencyPercent()
+}
+
+func percent(part, whole int64) float64 {
+	if whole == 0 {
+		return 100
+	}
+	return float64(part) * 100 / float64(whole)
+}
+
+type slaKey struct {
+	provider string
+	hour     string
+}
+
+// Counts calls by provider and hour until they're flushed
+type slaRecorder struct {
+	mu      sync.Mutex
+	pending map[slaKey]*slaCounts
+	store   SLAStore
+	now     func() time.Time
+}
+
+func newSLARecorder(store SLAStore) *slaRecorder {
+	return &slaRecorder{pending: map[slaKey]*slaCounts{}, store: store, now: time.Now}
+}
+
+// A nil recorder ignores everything
+func (recorder *slaRecorder) record(provider Provider, latency time.Duration, errorCode string) {
+	if recorder == nil {
+		return
+	}
+	call := slaCounts{Calls: 1}
+	if errorCode == "" {
+		call.Answered = 1
+		if provider.SLA == nil || provider.SLA.LatencyMs == 0 || latency <= time.Duration(provider.SLA.LatencyMs)*time.Millisecond {
+			call.Fast = 1
+		}
+	}
+	key := slaKey{provider.Name, recorder.now().UTC().Format(slaHourFormat)}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.pending[key] == nil {
+		recorder.pending[key] = &slaCounts{}
+	}
+	recorder.pending[key].add(call)
+}
+
+// Adds what's been counted to the table
+func (recorder *slaRecorder) flush(ctx context.Context) {
+	recorder.mu.Lock()
+	pending := recorder.pending
+	recorder.pending = map[slaKey]*slaCounts{}
+	recorder.mu.Unlock()
+	for key, counts := range pending {
+		if err := recorder.store.AddSLACounts(ctx, key.provider, key.hour, *counts); err != nil {
+			log.Printf("dropped sla counts for %s: %v", key.provider, err)
+		}
+	}
+}
+
+type SLAStore interface {
+	AddSLACounts(ctx context.Context, provider, hour string, counts slaCounts) error
+	// The provider's hourly counts from the hour onwards, by hour
+	SLACounts(ctx context.Context, provider, from string) (map[string]slaCounts, error)
+}
+
+type slaDynamoAPI interface {
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+type dynamoSLAStore struct {
+	client slaDynamoAPI
+	table  string
+	now    func() time.Time
+}
+
+// Adds rather than puts, every container counts into the same buckets
+func (store *dynamoSLAStore) AddSLACounts(ctx context.Context, provider, hour string, counts slaCounts) error {
+	_, err := store.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(store.table),
+		Key: map[string]types.AttributeValue{
+			"provider": &types.AttributeValueMemberS{Value: provider},
+			"hour":     &types.AttributeValueMemberS{Value: hour},
+		},
+		UpdateExpression: aws.String("ADD calls :calls, answered :answered, fast :fast SET expiresAt = :expiresAt"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":calls":     &types.AttributeValueMemberN{Value: strconv.FormatInt(counts.Calls, 10)},
+			":answered":  &types.AttributeValueMemberN{Value: strconv.FormatInt(counts.Answered, 10)},
+			":fast":      &types.AttributeValueMemberN{Value: strconv.FormatInt(counts.Fast, 10)},
+			":expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(store.now().Add(slaRetention).Unix(), 10)},
+		},
+	})
+	return err
+}
+
+func (store *dynamoSLAStore) SLACounts(ctx context.Context, provider, from string) (map[string]slaCounts, error) {
+	hours := map[string]slaCounts{}
+	paginator := dynamodb.NewQueryPaginator(store.client, &dynamodb.QueryInput{
+		TableName: aws.String(store.table),
+		// hour is a reserved word
+		KeyConditionExpression:   aws.String("provider = :provider AND #hour >= :from"),
+		ExpressionAttributeNames: map[string]string{"#hour": "hour"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":provider": &types.AttributeValueMemberS{Value: provider},
+			":from":     &types.AttributeValueMemberS{Value: from},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			hour, _ := item["hour"].(*types.AttributeValueMemberS)
+			if hour == nil {
+				continue
+			}
+			counts := slaCounts{}
+			for name, field := range map[string]*int64{"calls": &counts.Calls, "answered": &counts.Answered, "fast": &counts.Fast} {
+				if value, ok := item[name].(*types.AttributeValueMemberN); ok {
+					*field, _ = strconv.ParseInt(value.Value, 10, 64)
+				}
+			}
+			hours[hour.Value] = counts
+		}
+	}
+	return hours, nil
+}
+
+type SLAWindow struct {
+	Window        string  `json:"window"`
+	Calls         int64   `json:"calls"`
+	SuccessRate   float64 `json:"successRate"`
+	WithinLatency float64 `json:"withinLatency"`
+	// Percentage of the hours with calls that met the sla
+	Compliance float64 `json:"compliance"`
+	Met        bool    `json:"met"`
+}
+
+type SLAReport struct {
+	Provider string      `json:"provider"`
+	SLA      *SLAConfig  `json:"sla,omitempty"`
+	Windows  []SLAWindow `json:"windows"`
+}
+
+// Two decimal places is plenty for a QBR
+func roundPercent(value float64) float64 {
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(value, 'f', 2, 64), 64)
+	return rounded
+}
+
+// Sums the hourly counts up into each window
+func slaReport(provider Provider, hours map[string]slaCounts, now time.Time) SLAReport {
+	report := SLAReport{Provider: provider.Name, SLA: provider.SLA, Windows: []SLAWindow{}}
+	names := make([]string, 0, len(hours))
+	for hour := range hours {
+		names = append(names, hour)
+	}
+	sort.Strings(names)
+	for _, window := range slaWindows {
+		from := now.UTC().Add(-window.length).Format(slaHourFormat)
+		total := slaCounts{}
+		active, met := 0, 0
+		for _, hour := range names {
+			if hour < from {
+				continue
+			}
+			counts := hours[hour]
+			total.add(counts)
+			if counts.Calls == 0 {
+				continue
+			}
+			active++
+			if provider.SLA.met(counts) {
+				met++
+			}
+		}
+		report.Windows = append(report.Windows, SLAWindow{
+			Window:        window.name,
+			Calls:         total.Calls,
+			SuccessRate:   roundPercent(percent(total.Answered, total.Calls)),
+			WithinLatency: roundPercent(percent(total.Fast, total.Calls)),
+			Compliance:    roundPercent(percent(int64(met), int64(active))),
+			Met:           provider.SLA.met(total),
+		})
+	}
+	return report
+}
+
+// The provider name in /providers/{name}/sla
+func slaPathProvider(path string) (string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 3 || parts[len(parts)-1] != "sla" || parts[len(parts)-3] != "providers" {
+		return "", false
+	}
+	return parts[len(parts)-2], true
+}
+
+// Handler for GET /providers/{name}/sla
+func (config *Config) SLAHandler(ctx context.Context, request Request) (Response, error) {
+	name, _ := slaPathProvider(request.Path)
+	if pathName, exists := request.PathParameters["name"]; exists {
+		name = pathName
+	}
+	i, exists := providerIndex(config.Providers)[providerKey(name)]
+	if !exists {
+		return jsonResponse(404, map[string]string{"error": fmt.Sprintf("unknown provider %s", name)})
+	}
+	if config.slaRecorder == nil {
+		return jsonResponse(503, map[string]string{"error": "sla tracking isn't set up"})
+	}
+	now := config.slaRecorder.now()
+	from := now.UTC().Add(-slaWindows[len(slaWindows)-1].length).Format(slaHourFormat)
+	hours, err := config.slaRecorder.store.SLACounts(ctx, config.Providers[i].Name, from)
+	if err != nil {
+		return *handleError(err, "unable to read the sla table"), nil
+	}
+	return jsonResponse(200, slaReport(config.Providers[i], hours, now))
+}
+
+// Counts provider calls into SLA_TABLE if there is one
+func (config *Config) setupSLA(ctx context.Context) {
+	table, exists := os.LookupEnv("SLA_TABLE")
+	if !exists {
+		return
+	}
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	config.slaRecorder = newSLARecorder(&dynamoSLAStore{client: dynamodb.NewFromConfig(cfg), table: table, now: time.Now})
+	config.telemetry.onFlush(config.slaRecorder.flush)
+	for i := range config.Providers {
+		config.Providers[i].sla = config.slaRecorder
+	}
+}
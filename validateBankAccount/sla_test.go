@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeSLAStore struct {
+	hours map[string]map[string]slaCounts
+}
+
+func (store *fakeSLAStore) AddSLACounts(ctx context.Context, provider, hour string, counts slaCounts) error {
+	if store.hours[provider] == nil {
+		store.hours[provider] = map[string]slaCounts{}
+	}
+	total := store.hours[provider][hour]
+	total.add(counts)
+	store.hours[provider][hour] = total
+	return nil
+}
+
+func (store *fakeSLAStore) SLACounts(ctx context.Context, provider, from string) (map[string]slaCounts, error) {
+	hours := map[string]slaCounts{}
+	for hour, counts := range store.hours[provider] {
+		if hour >= from {
+			hours[hour] = counts
+		}
+	}
+	return hours, nil
+}
+
+func TestSLARecorder(t *testing.T) {
+	store := &fakeSLAStore{hours: map[string]map[string]slaCounts{}}
+	recorder := newSLARecorder(store)
+	recorder.now = func() time.Time { return time.Date(2024, 6, 1, 10, 30, 0, 0, time.UTC) }
+	provider := Provider{Name: "provider1", SLA: &SLAConfig{SuccessRate: 99, LatencyMs: 500}}
+
+	recorder.record(provider, 100*time.Millisecond, "")
+	recorder.record(provider, 900*time.Millisecond, "")
+	recorder.record(provider, 100*time.Millisecond, ProviderErrorTimeout)
+	recorder.record(Provider{Name: "provider2"}, 900*time.Millisecond, "")
+	recorder.flush(context.Background())
+	recorder.record(provider, 100*time.Millisecond, "")
+	recorder.flush(context.Background())
+
+	want := map[string]map[string]slaCounts{
+		"provider1": {"2024-06-01T10": {Calls: 4, Answered: 3, Fast: 2}},
+		"provider2": {"2024-06-01T10": {Calls: 1, Answered: 1, Fast: 1}},
+	}
+	if !reflect.DeepEqual(store.hours, want) {
+		t.Errorf("flush() = %v, want %v", store.hours, want)
+	}
+
+	// Nothing left to flush
+	recorder.flush(context.Background())
+	if !reflect.DeepEqual(store.hours, want) {
+		t.Errorf("flush() again = %v, want %v", store.hours, want)
+	}
+}
+
+func TestSLAReport(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	provider := Provider{Name: "provider1", SLA: &SLAConfig{SuccessRate: 99, LatencyMs: 500, LatencyPercent: 90}}
+	hours := map[string]slaCounts{
+		"2024-06-30T11": {Calls: 100, Answered: 100, Fast: 95},
+		"2024-06-30T10": {Calls: 100, Answered: 100, Fast: 80},
+		"2024-06-25T10": {Calls: 200, Answered: 190, Fast: 190},
+		"2024-06-10T10": {Calls: 100, Answered: 100, Fast: 100},
+		"2024-05-01T10": {Calls: 100, Answered: 0, Fast: 0},
+	}
+	want := SLAReport{Provider: "provider1", SLA: provider.SLA, Windows: []SLAWindow{
+		{Window: "24h", Calls: 200, SuccessRate: 100, WithinLatency: 87.5, Compliance: 50, Met: false},
+		{Window: "7d", Calls: 400, SuccessRate: 97.5, WithinLatency: 91.25, Compliance: 33.33, Met: false},
+		{Window: "30d", Calls: 500, SuccessRate: 98, WithinLatency: 93, Compliance: 50, Met: false},
+	}}
+	if got := slaReport(provider, hours, now); !reflect.DeepEqual(got, want) {
+		t.Errorf("slaReport() = %+v, want %+v", got, want)
+	}
+
+	// Without an sla there's nothing to miss
+	got := slaReport(Provider{Name: "provider2"}, map[string]slaCounts{}, now)
+	for _, window := range got.Windows {
+		if window.Calls != 0 || window.SuccessRate != 100 || window.Compliance != 100 || !window.Met {
+			t.Errorf("slaReport() without calls = %+v", window)
+		}
+	}
+}
+
+func TestConfig_SLAHandler(t *testing.T) {
+	store := &fakeSLAStore{hours: map[string]map[string]slaCounts{}}
+	config := &Config{Providers: []Provider{{Name: "provider1", SLA: &SLAConfig{SuccessRate: 99}}}}
+	config.slaRecorder = newSLARecorder(store)
+	config.Providers[0].sla = config.slaRecorder
+	config.Providers[0].sla.record(config.Providers[0], time.Millisecond, "")
+	config.slaRecorder.flush(context.Background())
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"found", "/providers/provider1/sla", 200},
+		{"caseInsensitive", "/dev/providers/Provider1/sla", 200},
+		{"unknown", "/providers/provider9/sla", 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := config.Router(context.Background(), Request{HTTPMethod: "GET", Path: tt.path})
+			if err != nil || response.StatusCode != tt.wantStatus {
+				t.Fatalf("Router() = %d %s, want %d", response.StatusCode, response.Body, tt.wantStatus)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+			var report SLAReport
+			if err := json.Unmarshal([]byte(response.Body), &report); err != nil || report.Windows[0].Calls != 1 {
+				t.Errorf("Router() = %s, want one call in the last 24h", response.Body)
+			}
+		})
+	}
+
+	config.slaRecorder = nil
+	if response, _ := config.SLAHandler(context.Background(), Request{Path: "/providers/provider1/sla"}); response.StatusCode != 503 {
+		t.Errorf("SLAHandler() without a table = %d, want 503", response.StatusCode)
+	}
+}
+
+func TestSLAConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		sla     *SLAConfig
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"valid", &SLAConfig{SuccessRate: 99.5, LatencyMs: 800, LatencyPercent: 95}, false},
+		{"overHundred", &SLAConfig{SuccessRate: 101}, true},
+		{"negativeLatency", &SLAConfig{LatencyMs: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.sla.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+/*
+  SNS trigger (MODE=sns) for teams already standardised on SNS. Publish a validation request to the request topic
+  and the result is published to the topic in RESULT_TOPIC_ARN, with message attributes subscribers can filter on
+  without parsing the body:
+
+    accountHash       the keyed hash of the account number (see accounthash.go), so the raw number isn't in the attributes
+    outcome           valid, invalid or error. Uses the request's strategy, or any when it doesn't have one
+    requestMessageId  the SNS message id of the request
+    clientReference   the request's clientReference, when it had one
+
+  The body is the normal response, or {"error": "..."} for a request that doesn't parse. If publishing fails the
+  invocation fails and Lambda retries it, so results are delivered at least once.
+*/
+
+type snsResultPublisher struct {
+	client   snsAPI
+	topicArn string
+}
+
+// The outcome attribute for a validation response
+func outcome(validationRequest *BankAccountValidationRequest, response BankAccountValidationResponse) string {
+	if response.Partial {
+		return ResultStatusError
+	}
+	verdict := response.Aggregate
+	if verdict == nil {
+		strategy := StrategyAny
+		if validationRequest.Strategy != nil {
+			strategy = *validationRequest.Strategy
+		}
+		verdict = aggregate(&strategy, response.Result)
+	}
+	if verdict.IsValid {
+		return ResultStatusValid
+	}
+	if remoteSkippedBy(response.Result) != "" {
+		return ResultStatusInvalid
+	}
+	for _, result := range response.Result {
+		if result.Error == "" && !result.Local {
+			return ResultStatusInvalid
+		}
+	}
+	// Nobody answered
+	return ResultStatusError
+}
+
+func stringAttribute(value string) types.MessageAttributeValue {
+	return types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+}
+
+func (config *Config) handleSNSRecord(ctx context.Context, record events.SNSEventRecord) error {
+	attributes := map[string]types.MessageAttributeValue{
+		"requestMessageId": stringAttribute(record.SNS.MessageID),
+	}
+	var body interface{}
+	validationRequest, message, err := decodeRequest(Request{Body: record.SNS.Message})
+	if err != nil {
+		log.Printf("bad request in sns message %s: %v", record.SNS.MessageID, err)
+		attributes["outcome"] = stringAttribute(ResultStatusError)
+		body = map[string]string{"error": message}
+	} else {
+		response := config.validate(ctx, Request{}, validationRequest, nil)
+		attributes["accountHash"] = stringAttribute(accountHash(*validationRequest.AccountNumber))
+		attributes["outcome"] = stringAttribute(outcome(validationRequest, response))
+		if response.ClientReference != "" {
+			attributes["clientReference"] = stringAttribute(response.ClientReference)
+		}
+		body = response
+	}
+	data, err := marshalJSON(body)
+	if err != nil {
+		return err
+	}
+	_, err = config.snsResults.client.Publish(ctx, &sns.PublishInput{
+		TopicArn:          aws.String(config.snsResults.topicArn),
+		Message:           aws.String(string(data)),
+		MessageAttributes: attributes,
+	})
+	return err
+}
+
+// Lambda handler for the SNS trigger
+func (config *Config) SNSHandler(ctx context.Context, event events.SNSEvent) error {
+	if config.snsResults == nil {
+		return errors.New("ENVVAR RESULT_TOPIC_ARN is required for the sns trigger")
+	}
+	var failed error
+	for _, record := range event.Records {
+		if err := config.handleSNSRecord(ctx, record); err != nil {
+			log.Print(err)
+			failed = err
+		}
+	}
+	return failed
+}
+
+// Connects to the result topic if there is one
+func (config *Config) setupSNSResults(ctx context.Context) {
+	topicArn, exists := os.LookupEnv("RESULT_TOPIC_ARN")
+	if !exists {
+		return
+	}
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	config.snsResults = &snsResultPublisher{client: sns.NewFromConfig(cfg), topicArn: topicArn}
+}
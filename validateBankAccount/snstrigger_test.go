@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestConfig_SNSHandler(t *testing.T) {
+	client := &fakeSNS{}
+	config := &Config{
+		Providers:  []Provider{{Name: "provider1", Type: ProviderTypeSimulated}, {Name: "provider2", Type: ProviderTypeSimulated}},
+		snsResults: &snsResultPublisher{client: client, topicArn: "arn:aws:sns:eu-west-1:123456789012:results"},
+	}
+	records := []struct {
+		message     string
+		wantOutcome string
+		wantBody    string
+	}{
+		{message: "{\"accountNumber\": \"12345670\"}", wantOutcome: ResultStatusValid, wantBody: "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true},{\"provider\":\"provider2\",\"isValid\":true}]}"},
+		{message: "{\"accountNumber\": \"12345671\"}", wantOutcome: ResultStatusInvalid},
+		{message: "{\"accountNumber\": \"12349999\"}", wantOutcome: ResultStatusError},
+		{message: "{}", wantOutcome: ResultStatusError, wantBody: "{\"error\":\"account number missing from payload\"}"},
+	}
+	event := events.SNSEvent{}
+	for i, record := range records {
+		snsRecord := events.SNSEventRecord{}
+		snsRecord.SNS.MessageID = string(rune('a' + i))
+		snsRecord.SNS.Message = record.message
+		event.Records = append(event.Records, snsRecord)
+	}
+	if err := config.SNSHandler(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.published) != len(records) {
+		t.Fatalf("expected %d results, got %d", len(records), len(client.published))
+	}
+	for i, record := range records {
+		published := client.published[i]
+		if got := *published.MessageAttributes["outcome"].StringValue; got != record.wantOutcome {
+			t.Errorf("%d: outcome = %v, want %v", i, got, record.wantOutcome)
+		}
+		if got := *published.MessageAttributes["requestMessageId"].StringValue; got != string(rune('a'+i)) {
+			t.Errorf("%d: requestMessageId = %v", i, got)
+		}
+		if record.wantBody != "" && *published.Message != record.wantBody {
+			t.Errorf("%d: message = %v, want %v", i, *published.Message, record.wantBody)
+		}
+	}
+	if got := *client.published[0].MessageAttributes["accountHash"].StringValue; got != accountHash("12345670") || len(got) != 64 {
+		t.Errorf("accountHash = %v", got)
+	}
+	if _, exists := client.published[3].MessageAttributes["accountHash"]; exists {
+		t.Errorf("a request without an account number shouldn't have an accountHash")
+	}
+}
+
+func TestConfig_SNSHandler_noTopic(t *testing.T) {
+	if err := (&Config{}).SNSHandler(context.Background(), events.SNSEvent{}); err == nil {
+		t.Errorf("SNSHandler() should fail without RESULT_TOPIC_ARN")
+	}
+}
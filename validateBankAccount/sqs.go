@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// The SQS calls we make, narrowed for tests
+type sqsAPI interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// A deadLetterQueue on SQS
+type sqsClient struct {
+	api sqsAPI
+}
+
+func newSQSClient(ctx context.Context) (*sqsClient, error) {
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sqsClient{api: sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+		o.HTTPClient = awshttp.NewBuildableClient().WithTimeout(2 * time.Second)
+	})}, nil
+}
+
+func (client *sqsClient) SendMessage(ctx context.Context, queueURL, body string) error {
+	_, err := client.api.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueURL),
+		MessageBody: aws.String(body),
+	})
+	return err
+}
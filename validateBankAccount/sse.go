@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+/*
+  Server-sent events in the standalone server mode. A validation request with Accept: text/event-stream gets each
+  provider's result as its own event the moment it arrives and then an aggregate event with the whole response,
+  which is what the internal dashboards use to show providers racing:
+
+    event: result
+    data: {"provider":"provider2","isValid":true}
+
+    event: result
+    data: {"provider":"provider1","isValid":false}
+
+    event: aggregate
+    data: {"result":[...]}
+
+  Only requests the router would send to the validation handler are streamed, every other route (batches, matrix,
+  graphql and so on) answers as usual whatever it accepts. A streamed validation goes through the router's checks
+  and the handler's (allowlist, rate limits, quotas, payload rules, entitlement, admission, no providers, fan-out and
+  load shedding) before anything is written, and one that's turned away, or doesn't parse, gets a single error
+  event. The aggregate is the response the caller would have got from the handler, filtered and signed.
+*/
+
+func acceptsEventStream(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		if strings.HasPrefix(strings.TrimSpace(accepted), "text/event-stream") {
+			return true
+		}
+	}
+	return false
+}
+
+func writeEvent(w http.ResponseWriter, event string, value interface{}) {
+	data, err := marshalJSON(value)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Wraps the server's handler, taking over validation requests that want an event stream
+func (config *Config) eventStream(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || !acceptsEventStream(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		request, err := toRequest(r)
+		if err == nil {
+			request, err = decodeBody(request)
+		}
+		if err != nil {
+			writeResponse(w, *handleError(err, "unable to read request"))
+			return
+		}
+		if route, _ := config.match(request); !validationRoute(route, request) {
+			r.Body = io.NopCloser(bytes.NewReader([]byte(request.Body)))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		ctx := withConfigCanary(r.Context(), request)
+		config.logRequestBody(ctx, request)
+		ctx, validationRequest, rejected := config.admitStream(ctx, request)
+		if rejected != nil {
+			writeEvent(w, "error", map[string]string{"error": rejectionMessage(*rejected)})
+			return
+		}
+		fields := config.visibleFields(request)
+		var onResult func(BankAccountValidationResult)
+		if fields == nil || fields[ResponseFieldResult] {
+			onResult = func(result BankAccountValidationResult) {
+				writeEvent(w, "result", result)
+			}
+		}
+		response := config.validate(ctx, request, validationRequest, onResult)
+		writeEvent(w, "aggregate", config.signResponse(ctx, validationRequest, filterResponse(fields, response)))
+	})
+}
+
+// Whether the router would send the request to the validation handler
+func validationRoute(route *route, request Request) bool {
+	if route == nil {
+		// Never a validation, see route
+		return !strings.Contains(request.Path+"/", adminPathPrefix)
+	}
+	return route.path == "/application"
+}
+
+// The checks the router and the validation handler make before calling anyone, the response when it's turned away
+func (config *Config) admitStream(ctx context.Context, request Request) (context.Context, *BankAccountValidationRequest, *Response) {
+	ctx, rejected := config.admit(ctx, request)
+	if rejected != nil {
+		return ctx, nil, rejected
+	}
+	validationRequest, rejected := unmarshalRequest(request)
+	if rejected != nil {
+		config.security.schemaViolation(ctx, request)
+		return ctx, nil, rejected
+	}
+	if rejected := config.enforceEntitlement(ctx, request, validationRequest); rejected != nil {
+		return ctx, nil, rejected
+	}
+	if rejected := config.enforceAdmission(ctx, request, validationRequest); rejected != nil {
+		return ctx, nil, rejected
+	}
+	if rejected := config.rejectNoProviders(ctx, validationRequest); rejected != nil {
+		return ctx, nil, rejected
+	}
+	if rejected := config.rejectFanOut(request, config.selectedProviders(ctx, validationRequest)); rejected != nil {
+		return ctx, nil, rejected
+	}
+	return ctx, validationRequest, config.shed(request, validationRequest)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConfig_eventStream(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}, {Name: "provider2", Type: ProviderTypeSimulated}}}
+	handler := config.eventStream(httpHandler(config.Router))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/application", strings.NewReader("{\"accountNumber\": \"12345670\", \"providers\": [\"provider1\"]}"))
+	r.Header.Set("Accept", "text/event-stream")
+	handler.ServeHTTP(w, r)
+
+	if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %v", got)
+	}
+	want := "event: result\ndata: {\"provider\":\"provider1\",\"isValid\":true}\n\n" +
+		"event: aggregate\ndata: {\"result\":[{\"provider\":\"provider1\",\"isValid\":true}]}\n\n"
+	if w.Body.String() != want {
+		t.Errorf("body = %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestConfig_eventStream_passthrough(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}}
+	handler := config.eventStream(httpHandler(config.Router))
+	tests := []struct {
+		name     string
+		request  *http.Request
+		accept   string
+		wantBody string
+	}{
+		{name: "json",
+			request:  httptest.NewRequest("POST", "/application", strings.NewReader("{\"accountNumber\": \"12345670\"}")),
+			wantBody: "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}]}",
+		},
+		{name: "badRequest",
+			request:  httptest.NewRequest("POST", "/application", strings.NewReader("{}")),
+			accept:   "text/event-stream",
+			wantBody: "event: error\ndata: {\"error\":\"account number missing from payload\"}\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.request.Header.Set("Accept", tt.accept)
+			handler.ServeHTTP(w, tt.request)
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestConfig_eventStream_routes(t *testing.T) {
+	config := &Config{
+		Providers:   []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		NoProviders: NoProvidersReject,
+		Batch:       BatchConfig{MaxRequests: 10},
+	}
+	handler := config.eventStream(httpHandler(config.Router))
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStream bool
+		want       string
+	}{
+		{"validation", "/application", `{"accountNumber": "12345670"}`, true, "event: aggregate"},
+		{"default", "/", `{"accountNumber": "12345670"}`, true, "event: aggregate"},
+		{"noProviders", "/application", `{"accountNumber": "12345670", "providers": ["provider9"]}`, true, "event: error\ndata: {\"error\":\"no matching providers\"}"},
+		{"batch", "/validate-batch", `{"requests": [{"accountNumber": "12345670"}]}`, false, `"index":0`},
+		{"graphql", "/graphql", `{"query": "{ capabilities { strategies } }"}`, false, `"data"`},
+		{"feedback", "/feedback", `{}`, false, `"error"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Accept", "text/event-stream")
+			handler.ServeHTTP(w, r)
+			if streamed := w.Header().Get("Content-Type") == "text/event-stream"; streamed != tt.wantStream || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("%s got %q %q, want streamed %v with %q", tt.path, w.Header().Get("Content-Type"), w.Body.String(), tt.wantStream, tt.want)
+			}
+		})
+	}
+}
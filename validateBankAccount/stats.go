@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+  Rolling per provider call stats. Every call we make is recorded with its latency and error (if any); anything older
+  than the window is dropped. Like the breakers these live for the life of the container.
+*/
+
+const (
+	defaultStatsWindow = 60 * time.Second
+	// Keeps memory bounded if a provider is hammered
+	maxStatsSamples = 1000
+)
+
+type callSample struct {
+	At      time.Time
+	Latency time.Duration
+	Error   string
+}
+
+type StatsSnapshot struct {
+	Calls        int
+	Errors       int
+	ErrorRate    float64
+	P50          time.Duration
+	P95          time.Duration
+	Max          time.Duration
+	RecentErrors []callSample
+}
+
+type providerStats struct {
+	mu      sync.Mutex
+	window  time.Duration
+	samples []callSample
+	now     func() time.Time
+}
+
+func newProviderStats(window time.Duration) *providerStats {
+	if window <= 0 {
+		window = defaultStatsWindow
+	}
+	return &providerStats{window: window, now: time.Now}
+}
+
+// A nil stats ignores everything
+func (stats *providerStats) record(latency time.Duration, errorCode string) {
+	if stats == nil {
+		return
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.samples = append(stats.samples, callSample{At: stats.now(), Latency: latency, Error: errorCode})
+	stats.prune()
+}
+
+// Drops samples outside the window, must hold the lock
+func (stats *providerStats) prune() {
+	cutoff := stats.now().Add(-stats.window)
+	keep := 0
+	for keep < len(stats.samples) && stats.samples[keep].At.Before(cutoff) {
+		keep++
+	}
+	if over := len(stats.samples) - keep - maxStatsSamples; over > 0 {
+		keep += over
+	}
+	stats.samples = stats.samples[keep:]
+}
+
+// The calls and errors in the window, without the copying and sorting of a snapshot
+func (stats *providerStats) counts() (int, int) {
+	if stats == nil {
+		return 0, 0
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.prune()
+	errors := 0
+	for _, sample := range stats.samples {
+		if sample.Error != "" {
+			errors++
+		}
+	}
+	return len(stats.samples), errors
+}
+
+func (stats *providerStats) snapshot(recentErrors int) StatsSnapshot {
+	if stats == nil {
+		return StatsSnapshot{}
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.prune()
+
+	snapshot := StatsSnapshot{Calls: len(stats.samples)}
+	latencies := make([]time.Duration, 0, len(stats.samples))
+	for i := len(stats.samples) - 1; i >= 0; i-- {
+		sample := stats.samples[i]
+		latencies = append(latencies, sample.Latency)
+		if sample.Error != "" {
+			snapshot.Errors++
+			if len(snapshot.RecentErrors) < recentErrors {
+				snapshot.RecentErrors = append(snapshot.RecentErrors, sample)
+			}
+		}
+	}
+	if snapshot.Calls == 0 {
+		return snapshot
+	}
+	snapshot.ErrorRate = float64(snapshot.Errors) / float64(snapshot.Calls)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	snapshot.P50 = latencies[(len(latencies)-1)*50/100]
+	snapshot.P95 = latencies[(len(latencies)-1)*95/100]
+	snapshot.Max = latencies[len(latencies)-1]
+	return snapshot
+}
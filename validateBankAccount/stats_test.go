@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestStats(window time.Duration) (*providerStats, *time.Time) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	stats := newProviderStats(window)
+	stats.now = func() time.Time { return now }
+	return stats, &now
+}
+
+func Test_providerStats_snapshot(t *testing.T) {
+	stats, now := newTestStats(time.Minute)
+	stats.record(10*time.Millisecond, "")
+	stats.record(20*time.Millisecond, "")
+	*now = now.Add(time.Second)
+	stats.record(1000*time.Millisecond, ProviderErrorTimeout)
+	stats.record(30*time.Millisecond, ProviderErrorResponse)
+
+	got := stats.snapshot(1)
+	if got.Calls != 4 || got.Errors != 2 || got.ErrorRate != 0.5 {
+		t.Errorf("snapshot() counts = %d/%d/%v, want 4/2/0.5", got.Calls, got.Errors, got.ErrorRate)
+	}
+	if got.P50 != 20*time.Millisecond || got.P95 != 30*time.Millisecond || got.Max != time.Second {
+		t.Errorf("snapshot() latencies = %v/%v/%v", got.P50, got.P95, got.Max)
+	}
+	if len(got.RecentErrors) != 1 || got.RecentErrors[0].Error != ProviderErrorResponse {
+		t.Errorf("snapshot() recent errors = %v, want the most recent only", got.RecentErrors)
+	}
+}
+
+func Test_providerStats_window(t *testing.T) {
+	stats, now := newTestStats(time.Minute)
+	stats.record(time.Millisecond, ProviderErrorTimeout)
+	*now = now.Add(2 * time.Minute)
+	stats.record(time.Millisecond, "")
+	if got := stats.snapshot(5); got.Calls != 1 || got.Errors != 0 {
+		t.Errorf("snapshot() = %+v, want old samples dropped", got)
+	}
+}
+
+func Test_providerStats_bounded(t *testing.T) {
+	stats, _ := newTestStats(time.Minute)
+	for i := 0; i < maxStatsSamples+10; i++ {
+		stats.record(time.Millisecond, "")
+	}
+	if got := stats.snapshot(0).Calls; got != maxStatsSamples {
+		t.Errorf("snapshot().Calls = %d, want %d", got, maxStatsSamples)
+	}
+}
+
+func Test_providerStats_nil(t *testing.T) {
+	var stats *providerStats
+	stats.record(time.Millisecond, "")
+	if got := stats.snapshot(5); got.Calls != 0 {
+		t.Errorf("snapshot() = %+v, want empty", got)
+	}
+}
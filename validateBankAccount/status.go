@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+/*
+  Provider status table, written by the scheduled probe and read by cold containers. One item per provider:
+
+    provider (S, hash key) | up (BOOL) | checkedAt (S, RFC3339) | latencyMs (N) | error (S)
+*/
+
+type ProviderStatus struct {
+	Provider  string
+	Up        bool
+	CheckedAt time.Time
+	LatencyMs int64
+	Error     string
+}
+
+type StatusStore interface {
+	PutStatus(ctx context.Context, status ProviderStatus) error
+	Statuses(ctx context.Context) ([]ProviderStatus, error)
+}
+
+type dynamoDBAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+type dynamoStatusStore struct {
+	client dynamoDBAPI
+	table  string
+}
+
+func newDynamoStatusStore(ctx context.Context, table string) (*dynamoStatusStore, error) {
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamoStatusStore{client: dynamodb.NewFromConfig(cfg), table: table}, nil
+}
+
+func (store *dynamoStatusStore) PutStatus(ctx context.Context, status ProviderStatus) error {
+	item := map[string]types.AttributeValue{
+		"provider":  &types.AttributeValueMemberS{Value: status.Provider},
+		"up":        &types.AttributeValueMemberBOOL{Value: status.Up},
+		"checkedAt": &types.AttributeValueMemberS{Value: status.CheckedAt.UTC().Format(time.RFC3339)},
+		"latencyMs": &types.AttributeValueMemberN{Value: strconv.FormatInt(status.LatencyMs, 10)},
+	}
+	if status.Error != "" {
+		item["error"] = &types.AttributeValueMemberS{Value: status.Error}
+	}
+	_, err := store.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(store.table), Item: item})
+	return err
+}
+
+// The table only ever holds one item per provider so a scan is fine
+func (store *dynamoStatusStore) Statuses(ctx context.Context) ([]ProviderStatus, error) {
+	statuses := []ProviderStatus{}
+	paginator := dynamodb.NewScanPaginator(store.client, &dynamodb.ScanInput{TableName: aws.String(store.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			statuses = append(statuses, statusFromItem(item))
+		}
+	}
+	return statuses, nil
+}
+
+func statusFromItem(item map[string]types.AttributeValue) ProviderStatus {
+	status := ProviderStatus{}
+	if v, ok := item["provider"].(*types.AttributeValueMemberS); ok {
+		status.Provider = v.Value
+	}
+	if v, ok := item["up"].(*types.AttributeValueMemberBOOL); ok {
+		status.Up = v.Value
+	}
+	if v, ok := item["checkedAt"].(*types.AttributeValueMemberS); ok {
+		status.CheckedAt, _ = time.Parse(time.RFC3339, v.Value)
+	}
+	if v, ok := item["latencyMs"].(*types.AttributeValueMemberN); ok {
+		status.LatencyMs, _ = strconv.ParseInt(v.Value, 10, 64)
+	}
+	if v, ok := item["error"].(*types.AttributeValueMemberS); ok {
+		status.Error = v.Value
+	}
+	return status
+}
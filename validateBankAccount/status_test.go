@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Just enough DynamoDB to round trip items
+type fakeDynamoDB struct {
+	items []map[string]types.AttributeValue
+}
+
+func (db *fakeDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	db.items = append(db.items, params.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (db *fakeDynamoDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return &dynamodb.ScanOutput{Items: db.items}, nil
+}
+
+func Test_dynamoStatusStore(t *testing.T) {
+	store := &dynamoStatusStore{client: &fakeDynamoDB{}, table: "status"}
+	want := []ProviderStatus{
+		{Provider: "provider1", Up: true, CheckedAt: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC), LatencyMs: 42},
+		{Provider: "provider2", Up: false, CheckedAt: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC), LatencyMs: 1000, Error: "timeout"},
+	}
+	for _, status := range want {
+		if err := store.PutStatus(context.Background(), status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := store.Statuses(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Statuses() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+/*
+  Storage. What outlives a request goes through one Store, in four parts:
+
+    - audit records (telemetry.go)
+    - the cache, ETags handed out for the 304 shortcut (caching.go)
+    - idempotency, keys that may only be acted on once, like request signatures (replay.go)
+    - counts in fixed windows, for rate limits and quotas (ratelimit.go, quota.go)
+
+  DynamoDB is the default. Audit records go to AUDIT_TABLE, and remembered keys and counts to RATE_LIMIT_TABLE as
+  items that expire with their TTL:
+
+    key (S, hash key, once#<key> or <key>#<window start>) | expiresAt (N, TTL) | count (N, counts only)
+
+  ETags stay in each container's memory either way, sharing them would cost a write per validation for a shortcut
+  that's only worth having while it's cheap. A part whose table isn't set is kept in memory too, which is what each
+  of them did before there was a Store.
+
+  The in-memory store keeps everything in the container, bounded, and is what tests use. STORE=memory picks it even
+  when the tables are set, so the standalone server (server.go) can run without an AWS account:
+
+    STORE=memory SERVER_ADDR=:8080 ./validateBankAccount
+
+  STORE=sqlite does the same but keeps everything in a file, so it survives a restart (see store_sqlite.go).
+*/
+
+const (
+	// Most audit records the in-memory store keeps, the oldest go first
+	maxMemoryAudit = 10000
+	// Past this the in-memory store sweeps out the expired keys
+	maxRememberedKeys = 100000
+	defaultSQLitePath = "accountvalidator.db"
+)
+
+type Store interface {
+	AuditSink
+	CacheStore
+	IdempotencyStore
+	windowCounter
+}
+
+type CacheStore interface {
+	// The ETag stored for the key, empty if there isn't one or it had expired by now
+	ETag(ctx context.Context, key string, now time.Time) (string, error)
+	PutETag(ctx context.Context, key, etag string, now, expires time.Time) error
+	ClearETags(ctx context.Context) error
+}
+
+type IdempotencyStore interface {
+	// Remembers the key until expires, false if it was already remembered and hadn't expired by now
+	Remember(ctx context.Context, key string, now, expires time.Time) (bool, error)
+}
+
+// A Store put together from parts, each in DynamoDB or memory
+type storeParts struct {
+	AuditSink
+	CacheStore
+	IdempotencyStore
+	windowCounter
+}
+
+func newMemoryStore() *storeParts {
+	return &storeParts{
+		AuditSink:        &memoryAudit{},
+		CacheStore:       newMemoryETags(),
+		IdempotencyStore: newMemoryKeys(),
+		windowCounter:    newLocalCounter(),
+	}
+}
+
+type memoryAudit struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (audit *memoryAudit) WriteAudit(ctx context.Context, records []AuditRecord) error {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	audit.records = append(audit.records, records...)
+	if over := len(audit.records) - maxMemoryAudit; over > 0 {
+		audit.records = append([]AuditRecord(nil), audit.records[over:]...)
+	}
+	return nil
+}
+
+type etagEntry struct {
+	etag    string
+	expires time.Time
+}
+
+type memoryETags struct {
+	mu      sync.Mutex
+	entries map[string]etagEntry
+}
+
+func newMemoryETags() *memoryETags {
+	return &memoryETags{entries: map[string]etagEntry{}}
+}
+
+func (etags *memoryETags) ETag(ctx context.Context, key string, now time.Time) (string, error) {
+	etags.mu.Lock()
+	defer etags.mu.Unlock()
+	entry, exists := etags.entries[key]
+	if !exists {
+		return "", nil
+	}
+	if !now.Before(entry.expires) {
+		delete(etags.entries, key)
+		return "", nil
+	}
+	return entry.etag, nil
+}
+
+// When full the expired ones are swept out, and if none were it isn't stored
+func (etags *memoryETags) PutETag(ctx context.Context, key, etag string, now, expires time.Time) error {
+	etags.mu.Lock()
+	defer etags.mu.Unlock()
+	if _, exists := etags.entries[key]; !exists && len(etags.entries) >= maxETags {
+		for other, entry := range etags.entries {
+			if !now.Before(entry.expires) {
+				delete(etags.entries, other)
+			}
+		}
+		if len(etags.entries) >= maxETags {
+			return nil
+		}
+	}
+	etags.entries[key] = etagEntry{etag: etag, expires: expires}
+	return nil
+}
+
+func (etags *memoryETags) ClearETags(ctx context.Context) error {
+	etags.mu.Lock()
+	defer etags.mu.Unlock()
+	etags.entries = map[string]etagEntry{}
+	return nil
+}
+
+type memoryKeys struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newMemoryKeys() *memoryKeys {
+	return &memoryKeys{seen: map[string]time.Time{}}
+}
+
+func (keys *memoryKeys) Remember(ctx context.Context, key string, now, expires time.Time) (bool, error) {
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	if until, exists := keys.seen[key]; exists && now.Before(until) {
+		return false, nil
+	}
+	if len(keys.seen) >= maxRememberedKeys {
+		for seen, until := range keys.seen {
+			if !now.Before(until) {
+				delete(keys.seen, seen)
+			}
+		}
+	}
+	keys.seen[key] = expires
+	return true, nil
+}
+
+type keysDynamoAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// Keys remembered by every container, in the rate limit table
+type dynamoKeys struct {
+	client keysDynamoAPI
+	table  string
+}
+
+func (keys *dynamoKeys) Remember(ctx context.Context, key string, now, expires time.Time) (bool, error) {
+	_, err := keys.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(keys.table),
+		Item: map[string]types.AttributeValue{
+			"key":       &types.AttributeValueMemberS{Value: "once#" + key},
+			"expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(expires.Unix(), 10)},
+		},
+		// The TTL can take a while to delete what's expired, so an expired item doesn't count
+		ConditionExpression:      aws.String("attribute_not_exists(#key) OR expiresAt <= :now"),
+		ExpressionAttributeNames: map[string]string{"#key": "key"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Picks each part of the store, DynamoDB where its table is set unless STORE says otherwise
+func (config *Config) setupStore(ctx context.Context) {
+	store := newMemoryStore()
+	config.store = store
+	defer func() {
+		if config.replays != nil {
+			config.replays.backend = store.IdempotencyStore
+		}
+	}()
+	switch os.Getenv("STORE") {
+	case "memory":
+		return
+	case "sqlite":
+		path := os.Getenv("STORE_PATH")
+		if path == "" {
+			path = defaultSQLitePath
+		}
+		sqlite, err := openSQLiteStore(path)
+		if err != nil {
+			log.Printf("unable to open the sqlite store, keeping everything in memory: %v", err)
+			return
+		}
+		store.AuditSink, store.CacheStore, store.IdempotencyStore, store.windowCounter = sqlite, sqlite, sqlite, sqlite
+		return
+	}
+	auditTable, audit := os.LookupEnv("AUDIT_TABLE")
+	keysTable, keys := os.LookupEnv("RATE_LIMIT_TABLE")
+	if !audit && !keys {
+		return
+	}
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		log.Printf("unable to connect to DynamoDB, keeping everything in memory: %v", err)
+		return
+	}
+	client := dynamodb.NewFromConfig(cfg)
+	if audit {
+		store.AuditSink = &dynamoAuditSink{client: client, table: auditTable}
+	}
+	if keys {
+		store.IdempotencyStore = &dynamoKeys{client: client, table: keysTable}
+		store.windowCounter = &dynamoCounter{client: client, table: keysTable}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !sqlite
+
+package main
+
+import "errors"
+
+// The SQLite store needs cgo, so it's only built with the sqlite tag (see store_sqlite.go)
+func openSQLiteStore(path string) (Store, error) {
+	return nil, errors.New("this binary was built without the sqlite tag")
+}
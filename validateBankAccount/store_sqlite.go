@@ -0,0 +1,141 @@
+//go:build sqlite
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+/*
+  SQLite store, for running the standalone server with persistence but without DynamoDB. It needs cgo, so it's only
+  in binaries built with the sqlite tag, which the Lambda ones aren't:
+
+    make build-server
+    STORE=sqlite STORE_PATH=/var/lib/accountvalidator.db SERVER_ADDR=:8080 ./bin/server
+
+  STORE_PATH defaults to accountvalidator.db in the working directory. Everything goes in the one file, ETags
+  included since a write costs nothing here, and what's expired is swept out at most every sweepEvery. Audit records
+  are kept until someone deletes them, with the whole record as JSON next to the columns worth querying:
+
+    sqlite3 accountvalidator.db "select validated_at, outcome from audit where account_hash = '...'"
+
+  One process to a file. SQLite's locking keeps two from corrupting it, but they'd keep each other waiting.
+*/
+
+const sweepEvery = time.Hour
+
+const sqliteSchema = `
+create table if not exists audit (
+	account_hash text not null,
+	validated_at text not null,
+	request_id text,
+	outcome text,
+	record text not null
+);
+create index if not exists audit_account_hash on audit (account_hash, validated_at);
+create table if not exists etags (key text primary key, etag text not null, expires_at integer not null);
+create table if not exists once (key text primary key, expires_at integer not null);
+create table if not exists counts (key text primary key, count integer not null, expires_at integer not null);
+`
+
+type sqliteStore struct {
+	db        *sql.DB
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func openSQLiteStore(path string) (Store, error) {
+	// Writers wait for each other rather than failing with SQLITE_BUSY
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	store := &sqliteStore{db: db}
+	store.sweep(context.Background(), time.Now())
+	return store, nil
+}
+
+func (store *sqliteStore) WriteAudit(ctx context.Context, records []AuditRecord) error {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "insert into audit (account_hash, validated_at, request_id, outcome, record) values (?, ?, ?, ?, ?)",
+			record.AccountHash, record.ValidatedAt.UTC().Format(time.RFC3339Nano), record.RequestID, record.Outcome, string(data)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (store *sqliteStore) ETag(ctx context.Context, key string, now time.Time) (string, error) {
+	var etag string
+	err := store.db.QueryRowContext(ctx, "select etag from etags where key = ? and expires_at > ?", key, now.UnixNano()).Scan(&etag)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return etag, err
+}
+
+func (store *sqliteStore) PutETag(ctx context.Context, key, etag string, now, expires time.Time) error {
+	store.sweep(ctx, now)
+	_, err := store.db.ExecContext(ctx, "insert into etags (key, etag, expires_at) values (?, ?, ?) on conflict (key) do update set etag = excluded.etag, expires_at = excluded.expires_at",
+		key, etag, expires.UnixNano())
+	return err
+}
+
+func (store *sqliteStore) ClearETags(ctx context.Context) error {
+	_, err := store.db.ExecContext(ctx, "delete from etags")
+	return err
+}
+
+func (store *sqliteStore) Remember(ctx context.Context, key string, now, expires time.Time) (bool, error) {
+	store.sweep(ctx, now)
+	// Nothing changes when the key is there and hasn't expired
+	result, err := store.db.ExecContext(ctx, "insert into once (key, expires_at) values (?, ?) on conflict (key) do update set expires_at = excluded.expires_at where once.expires_at <= ?",
+		key, expires.UnixNano(), now.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	changed, err := result.RowsAffected()
+	return changed == 1, err
+}
+
+func (store *sqliteStore) add(ctx context.Context, key string, start time.Time, length time.Duration, n int64) (int64, error) {
+	end := start.Add(length)
+	store.sweep(ctx, start)
+	var count int64
+	err := store.db.QueryRowContext(ctx, "insert into counts (key, count, expires_at) values (?, ?, ?) on conflict (key) do update set count = count + excluded.count returning count",
+		key+"#"+start.UTC().Format(time.RFC3339), n, end.UnixNano()).Scan(&count)
+	return count, err
+}
+
+// Deletes what's expired, when it's been long enough since the last time
+func (store *sqliteStore) sweep(ctx context.Context, now time.Time) {
+	store.mu.Lock()
+	if now.Sub(store.lastSweep) < sweepEvery {
+		store.mu.Unlock()
+		return
+	}
+	store.lastSweep = now
+	store.mu.Unlock()
+	for _, table := range []string{"etags", "once", "counts"} {
+		store.db.ExecContext(ctx, "delete from "+table+" where expires_at <= ?", now.UnixNano())
+	}
+}
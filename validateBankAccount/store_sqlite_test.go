@@ -0,0 +1,86 @@
+//go:build sqlite
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_sqliteStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	path := filepath.Join(t.TempDir(), "accountvalidator.db")
+	store, err := openSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.PutETag(ctx, "key", `"abc"`, now, now.Add(time.Minute))
+	if etag, err := store.ETag(ctx, "key", now.Add(59*time.Second)); etag != `"abc"` || err != nil {
+		t.Errorf("ETag() = %v, %v, want the stored etag", etag, err)
+	}
+	if etag, err := store.ETag(ctx, "key", now.Add(time.Minute)); etag != "" || err != nil {
+		t.Errorf("ETag() once expired = %v, %v", etag, err)
+	}
+	store.ClearETags(ctx)
+	if etag, _ := store.ETag(ctx, "key", now); etag != "" {
+		t.Errorf("ETag() once cleared = %v", etag)
+	}
+
+	if remembered, err := store.Remember(ctx, "once", now, now.Add(time.Minute)); !remembered || err != nil {
+		t.Errorf("Remember() = %v, %v, want it remembered", remembered, err)
+	}
+	if remembered, _ := store.Remember(ctx, "once", now.Add(30*time.Second), now.Add(time.Minute)); remembered {
+		t.Error("Remember() should refuse a key it already has")
+	}
+	if remembered, _ := store.Remember(ctx, "once", now.Add(time.Minute), now.Add(2*time.Minute)); !remembered {
+		t.Error("Remember() should take a key again once it's expired")
+	}
+
+	store.add(ctx, "caller", now, time.Minute, 2)
+	if count, err := store.add(ctx, "caller", now, time.Minute, 3); count != 5 || err != nil {
+		t.Errorf("add() = %d, %v, want 5", count, err)
+	}
+	if count, _ := store.add(ctx, "caller", now.Add(time.Minute), time.Minute, 1); count != 1 {
+		t.Errorf("add() in the next window = %d, want 1", count)
+	}
+
+	if err := store.WriteAudit(ctx, []AuditRecord{{AccountHash: "a", ValidatedAt: now, Outcome: ResultStatusValid, Verdicts: map[string]bool{"provider1": true}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Still there once the server restarts
+	store.(*sqliteStore).db.Close()
+	if store, err = openSQLiteStore(path); err != nil {
+		t.Fatal(err)
+	}
+	if remembered, _ := store.Remember(ctx, "once", now.Add(90*time.Second), now.Add(2*time.Minute)); remembered {
+		t.Error("Remember() forgot the key on reopening")
+	}
+	var data string
+	if err := store.(*sqliteStore).db.QueryRow("select record from audit where account_hash = 'a'").Scan(&data); err != nil {
+		t.Fatal(err)
+	}
+	var record AuditRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil || !record.Verdicts["provider1"] || !record.ValidatedAt.Equal(now) {
+		t.Errorf("audit record = %s, %v", data, err)
+	}
+}
+
+func TestConfig_setupStore_sqlite(t *testing.T) {
+	t.Setenv("STORE", "sqlite")
+	t.Setenv("STORE_PATH", filepath.Join(t.TempDir(), "accountvalidator.db"))
+	t.Setenv("AUDIT_TABLE", "audit")
+	config := &Config{}
+	config.setupStore(context.Background())
+	parts := config.store.(*storeParts)
+	for _, part := range []interface{}{parts.AuditSink, parts.CacheStore, parts.IdempotencyStore, parts.windowCounter} {
+		if _, ok := part.(*sqliteStore); !ok {
+			t.Errorf("part is a %T, want the sqlite store", part)
+		}
+	}
+}
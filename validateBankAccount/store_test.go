@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func Test_memoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1717232400, 0)
+	store := newMemoryStore()
+
+	store.PutETag(ctx, "key", `"abc"`, now, now.Add(time.Minute))
+	if etag, err := store.ETag(ctx, "key", now.Add(59*time.Second)); etag != `"abc"` || err != nil {
+		t.Errorf("ETag() = %v, %v, want the stored etag", etag, err)
+	}
+	if etag, _ := store.ETag(ctx, "key", now.Add(time.Minute)); etag != "" {
+		t.Errorf("ETag() once expired = %v", etag)
+	}
+
+	if remembered, _ := store.Remember(ctx, "once", now, now.Add(time.Minute)); !remembered {
+		t.Error("Remember() should take a new key")
+	}
+	if remembered, _ := store.Remember(ctx, "once", now.Add(30*time.Second), now.Add(time.Minute)); remembered {
+		t.Error("Remember() should refuse a key it already has")
+	}
+	if remembered, _ := store.Remember(ctx, "once", now.Add(time.Minute), now.Add(2*time.Minute)); !remembered {
+		t.Error("Remember() should take a key again once it's expired")
+	}
+
+	if count, _ := store.add(ctx, "caller", now, time.Minute, 2); count != 2 {
+		t.Errorf("add() = %d, want 2", count)
+	}
+
+	records := make([]AuditRecord, maxMemoryAudit)
+	store.WriteAudit(ctx, records)
+	store.WriteAudit(ctx, []AuditRecord{{AccountHash: "newest"}})
+	audit := store.AuditSink.(*memoryAudit)
+	if len(audit.records) != maxMemoryAudit || audit.records[maxMemoryAudit-1].AccountHash != "newest" {
+		t.Errorf("kept %d audit records, want the newest %d", len(audit.records), maxMemoryAudit)
+	}
+}
+
+type fakeKeysDynamoDB struct {
+	expiresAt map[string]string
+}
+
+func (db *fakeKeysDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	key := params.Item["key"].(*types.AttributeValueMemberS).Value
+	if expiresAt, exists := db.expiresAt[key]; exists && expiresAt > params.ExpressionAttributeValues[":now"].(*types.AttributeValueMemberN).Value {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	if db.expiresAt == nil {
+		return nil, errors.New("unreachable")
+	}
+	db.expiresAt[key] = params.Item["expiresAt"].(*types.AttributeValueMemberN).Value
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func Test_dynamoKeys_Remember(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1717232400, 0)
+	keys := &dynamoKeys{client: &fakeKeysDynamoDB{expiresAt: map[string]string{}}, table: "counts"}
+	if remembered, err := keys.Remember(ctx, "signature#abc", now, now.Add(time.Minute)); !remembered || err != nil {
+		t.Errorf("Remember() = %v, %v, want it remembered", remembered, err)
+	}
+	if remembered, err := keys.Remember(ctx, "signature#abc", now, now.Add(time.Minute)); remembered || err != nil {
+		t.Errorf("Remember() a second time = %v, %v, want it refused", remembered, err)
+	}
+	// Expired but not yet deleted by the TTL
+	if remembered, err := keys.Remember(ctx, "signature#abc", now.Add(time.Minute), now.Add(2*time.Minute)); !remembered || err != nil {
+		t.Errorf("Remember() once expired = %v, %v, want it remembered", remembered, err)
+	}
+	keys.client = &fakeKeysDynamoDB{}
+	if _, err := keys.Remember(ctx, "signature#abc", now, now.Add(time.Minute)); err == nil {
+		t.Error("Remember() should pass on the table's error")
+	}
+}
+
+func TestConfig_setupStore(t *testing.T) {
+	t.Setenv("AUDIT_TABLE", "audit")
+	t.Setenv("RATE_LIMIT_TABLE", "counts")
+	t.Setenv("AWS_REGION", "eu-west-1")
+
+	config := &Config{replays: newReplayCache()}
+	config.setupStore(context.Background())
+	parts := config.store.(*storeParts)
+	if _, ok := parts.AuditSink.(*dynamoAuditSink); !ok {
+		t.Errorf("audit records go to %T, want the audit table", parts.AuditSink)
+	}
+	if _, ok := parts.windowCounter.(*dynamoCounter); !ok {
+		t.Errorf("counts go to %T, want the rate limit table", parts.windowCounter)
+	}
+	if _, ok := parts.CacheStore.(*memoryETags); !ok {
+		t.Errorf("etags go to %T, want memory", parts.CacheStore)
+	}
+	if config.replays.backend != parts.IdempotencyStore {
+		t.Error("signatures aren't remembered in the store")
+	}
+
+	// Nothing leaves the container
+	t.Setenv("STORE", "memory")
+	config.setupStore(context.Background())
+	parts = config.store.(*storeParts)
+	if _, ok := parts.AuditSink.(*memoryAudit); !ok {
+		t.Errorf("audit records go to %T, want memory", parts.AuditSink)
+	}
+	if _, ok := parts.IdempotencyStore.(*memoryKeys); !ok {
+		t.Errorf("signatures go to %T, want memory", parts.IdempotencyStore)
+	}
+}
+
+func TestConfig_Router_sharedReplays(t *testing.T) {
+	now := time.Now()
+	store := newMemoryStore()
+	first, second := signedConfig(now), signedConfig(now)
+	first.replays.backend, second.replays.backend = store, store
+	request := signedRequest("secret-a", "partner-a", now, `{"accountNumber": "12345670"}`)
+	request.Path = "/application"
+	if response, _ := first.Router(context.Background(), request); response.StatusCode != 200 {
+		t.Fatalf("Router() = %d %s", response.StatusCode, response.Body)
+	}
+	// Another container sharing the store sees the replay
+	if response, _ := second.Router(context.Background(), request); response.StatusCode != 401 {
+		t.Errorf("replayed Router() = %d %s, want a 401", response.StatusCode, response.Body)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import "fmt"
+
+/*
+  Aggregation strategies. By default the response is just each provider's answer and the caller makes up their own
+  mind. A request can instead ask for an overall verdict:
+
+    {"accountNumber": "12345678", "strategy": "majority"}
+
+    any         at least one provider said valid
+    all         every provider answered and said valid
+    majority    more than half of the providers called said valid
+    sequential  the providers are called one at a time until one answers, see sequential.go
+
+  A provider that errored never counts as saying valid. Providers with a weight in the config count that many times
+  towards the majority, so a provider of weight 2 outvotes one of weight 1. Providers in a group vote as one, see
+  groups.go.
+*/
+
+const (
+	StrategyAny      = "any"
+	StrategyAll      = "all"
+	StrategyMajority = "majority"
+)
+
+// In the order we document them
+var strategies = []string{StrategyAny, StrategyAll, StrategyMajority, StrategySequential}
+
+type AggregateResult struct {
+	Strategy string `json:"strategy"`
+	IsValid  bool   `json:"isValid"`
+	// How each provider group settled, see groups.go
+	Groups []GroupResult `json:"groups,omitempty"`
+}
+
+func validateStrategy(strategy string) error {
+	for _, known := range strategies {
+		if strategy == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown strategy %s", strategy)
+}
+
+// The overall verdict for a set of results, nil when the caller didn't ask for one
+func aggregate(strategy *string, results []BankAccountValidationResult) *AggregateResult {
+	return aggregateWeighted(strategy, results, nil)
+}
+
+// As aggregate, with the majority weighted by provider. Providers missing from weights count once.
+func aggregateWeighted(strategy *string, results []BankAccountValidationResult, weights map[string]float64) *AggregateResult {
+	if strategy == nil {
+		return nil
+	}
+	valid, answers := 0, 0
+	validWeight, totalWeight := 0.0, 0.0
+	for _, result := range results {
+		if result.Local {
+			continue
+		}
+		answers++
+		weight, exists := weights[result.Provider]
+		if !exists {
+			weight = 1
+		}
+		totalWeight += weight
+		if result.Error == "" && result.IsValid {
+			valid++
+			validWeight += weight
+		}
+	}
+	aggregate := &AggregateResult{Strategy: *strategy}
+	switch *strategy {
+	case StrategyAny:
+		aggregate.IsValid = valid > 0
+	case StrategyAll:
+		aggregate.IsValid = answers > 0 && valid == answers
+	case StrategyMajority:
+		aggregate.IsValid = validWeight*2 > totalWeight
+	case StrategySequential:
+		aggregate.IsValid = sequentialVerdict(results)
+	}
+	return aggregate
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_aggregate(t *testing.T) {
+	valid := BankAccountValidationResult{Provider: "p", IsValid: true}
+	invalid := BankAccountValidationResult{Provider: "p", IsValid: false}
+	failed := BankAccountValidationResult{Provider: "p", Error: ProviderErrorTimeout}
+	strategy := func(s string) *string { return &s }
+
+	tests := []struct {
+		name     string
+		strategy *string
+		results  []BankAccountValidationResult
+		want     *AggregateResult
+	}{
+		{name: "none", strategy: nil, results: []BankAccountValidationResult{valid}, want: nil},
+		{name: "anyValid", strategy: strategy(StrategyAny), results: []BankAccountValidationResult{invalid, valid}, want: &AggregateResult{Strategy: StrategyAny, IsValid: true}},
+		{name: "anyInvalid", strategy: strategy(StrategyAny), results: []BankAccountValidationResult{invalid, failed}, want: &AggregateResult{Strategy: StrategyAny, IsValid: false}},
+		{name: "allValid", strategy: strategy(StrategyAll), results: []BankAccountValidationResult{valid, valid}, want: &AggregateResult{Strategy: StrategyAll, IsValid: true}},
+		{name: "allWithFailure", strategy: strategy(StrategyAll), results: []BankAccountValidationResult{valid, failed}, want: &AggregateResult{Strategy: StrategyAll, IsValid: false}},
+		{name: "allEmpty", strategy: strategy(StrategyAll), results: []BankAccountValidationResult{}, want: &AggregateResult{Strategy: StrategyAll, IsValid: false}},
+		{name: "majority", strategy: strategy(StrategyMajority), results: []BankAccountValidationResult{valid, valid, invalid}, want: &AggregateResult{Strategy: StrategyMajority, IsValid: true}},
+		{name: "majorityTie", strategy: strategy(StrategyMajority), results: []BankAccountValidationResult{valid, failed}, want: &AggregateResult{Strategy: StrategyMajority, IsValid: false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := aggregate(tt.strategy, tt.results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("aggregate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_unmarshalRequest_strategy(t *testing.T) {
+	if _, errorResponse := unmarshalRequest(Request{Body: "{\"accountNumber\": \"12345678\", \"strategy\": \"majority\"}"}); errorResponse != nil {
+		t.Errorf("unmarshalRequest() = %v, want a known strategy accepted", errorResponse.Body)
+	}
+	_, errorResponse := unmarshalRequest(Request{Body: "{\"accountNumber\": \"12345678\", \"strategy\": \"most\"}"})
+	if errorResponse == nil || errorResponse.Body != "{\"error\":\"unknown strategy most\"}" {
+		t.Errorf("unmarshalRequest() = %v, want an unknown strategy error", errorResponse)
+	}
+}
+
+func Test_aggregateWeighted(t *testing.T) {
+	majority := StrategyMajority
+	results := []BankAccountValidationResult{
+		{Provider: "bureau", IsValid: true},
+		{Provider: "provider1", IsValid: false},
+		{Provider: "provider2", Error: ProviderErrorTimeout},
+	}
+	tests := []struct {
+		name    string
+		weights map[string]float64
+		want    bool
+	}{
+		{name: "unweighted", want: false},
+		{name: "outvotes", weights: map[string]float64{"bureau": 3}, want: true},
+		{name: "tie", weights: map[string]float64{"bureau": 2}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := aggregateWeighted(&majority, results, tt.weights); got.IsValid != tt.want {
+				t.Errorf("aggregateWeighted() = %v, want %v", got.IsValid, tt.want)
+			}
+		})
+	}
+}
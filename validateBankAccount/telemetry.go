@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+/*
+  Audit records and metrics. Every validation adds an audit record and a few metrics to an in memory buffer instead
+  of paying for a DynamoDB or CloudWatch round trip while the caller waits. The buffer is flushed:
+
+    - in Lambda, by an internal extension once the handler has returned. Lambda sends the response as soon as the
+      handler is done but doesn't finish the invocation until the extension says so, so the flush happens off the
+      latency critical path. Registering the extension also gets us a SIGTERM before the container is shut down,
+      which flushes whatever is left.
+    - everywhere else, every flushInterval.
+
+  Audit records go to the DynamoDB table in AUDIT_TABLE, 25 to a BatchWriteItem (ones with outbox events in a
+  transaction with them, see outbox.go):
+
+    accountHash (S, hash key) | validatedAt (S, RFC3339Nano, range key) | requestId (S) | providers (SS) |
+    outcome (S) | durationMs (N) | testAccount (BOOL) | clientReference (S, when the request had one) |
+    accountMasked (S, unless masking is omit) | caller (S) | verdicts (M of BOOL, by provider that answered) |
+    callIds (M of S, by provider, see callids.go)
+
+  requestId is API Gateway's, the x-amzn-RequestId the caller got back, with an index on it for feedback.
+
+  Metrics are written to stdout in CloudWatch embedded metric format, one line per flush, so CloudWatch picks them up
+  from the logs without an API call. A flush that fails is logged and its records are dropped, telemetry never fails
+  a validation.
+*/
+
+const (
+	metricsNamespace   = "AccountValidator"
+	flushInterval      = time.Second
+	flushTimeout       = 2 * time.Second
+	auditBatchSize     = 25
+	maxMetricValues    = 100 // embedded metric format's limit per metric per line
+	flushExtensionName = "accountvalidator-flush"
+)
+
+type AuditRecord struct {
+	AccountHash     string
+	ValidatedAt     time.Time
+	RequestID       string
+	Providers       []string
+	Outcome         string
+	DurationMs      int64
+	TestAccount     bool
+	ClientReference string
+	AccountMasked   string
+	// Who asked, as in security events
+	Caller string
+	// What each provider that answered said, for feedback, see feedback.go
+	Verdicts map[string]bool
+	// The id sent to each provider that has a callIdHeader, see callids.go
+	CallIDs map[string]string
+	// What the validation sent on, written to the outbox with the record, see outbox.go
+	Events []OutboxEvent
+	// Only once someone has told us the real outcome, never written by WriteAudit
+	Feedback *AuditFeedback
+}
+
+type AuditSink interface {
+	WriteAudit(ctx context.Context, records []AuditRecord) error
+}
+
+type metric struct {
+	unit   string
+	values []float64
+}
+
+type telemetry struct {
+	mu      sync.Mutex
+	audit   []AuditRecord
+	counts  map[string]float64
+	metrics map[string]*metric
+	sink    AuditSink
+	out     io.Writer
+	now     func() time.Time
+	// Signalled when a Lambda handler returns, see flushExtension
+	invoked chan struct{}
+	// Billing events waiting for the flush, see billing.go
+	billing []BillingEvent
+	events  eventPublisher
+	// Anything else that batches up until the flush, like sla counts
+	flushers []func(ctx context.Context)
+	// Called with each batch of audit records once it's been written, see outbox.go
+	written func(ctx context.Context, records []AuditRecord, err error)
+}
+
+func newTelemetry(sink AuditSink, out io.Writer) *telemetry {
+	return &telemetry{
+		counts:  map[string]float64{},
+		metrics: map[string]*metric{},
+		sink:    sink,
+		out:     out,
+		now:     time.Now,
+		invoked: make(chan struct{}, 1),
+	}
+}
+
+// Has flush run as well when the telemetry flushes
+func (telemetry *telemetry) onFlush(flush func(ctx context.Context)) {
+	if telemetry == nil {
+		return
+	}
+	telemetry.flushers = append(telemetry.flushers, flush)
+}
+
+// A nil telemetry ignores everything
+func (telemetry *telemetry) record(record AuditRecord) {
+	if telemetry == nil {
+		return
+	}
+	telemetry.mu.Lock()
+	defer telemetry.mu.Unlock()
+	telemetry.audit = append(telemetry.audit, record)
+}
+
+// Adds to a counter, sent as its total for the flush
+func (telemetry *telemetry) count(name string, value float64) {
+	if telemetry == nil {
+		return
+	}
+	telemetry.mu.Lock()
+	defer telemetry.mu.Unlock()
+	telemetry.counts[name] += value
+}
+
+// Records a value of a distribution, every value is sent
+func (telemetry *telemetry) observe(name string, value float64, unit string) {
+	if telemetry == nil {
+		return
+	}
+	telemetry.mu.Lock()
+	defer telemetry.mu.Unlock()
+	if telemetry.metrics[name] == nil {
+		telemetry.metrics[name] = &metric{unit: unit}
+	}
+	telemetry.metrics[name].values = append(telemetry.metrics[name].values, value)
+}
+
+// Sends everything buffered so far
+func (telemetry *telemetry) flush(ctx context.Context) {
+	if telemetry == nil {
+		return
+	}
+	telemetry.mu.Lock()
+	audit, counts, metrics, billing := telemetry.audit, telemetry.counts, telemetry.metrics, telemetry.billing
+	telemetry.audit, telemetry.counts, telemetry.metrics, telemetry.billing = nil, map[string]float64{}, map[string]*metric{}, nil
+	telemetry.mu.Unlock()
+
+	telemetry.writeMetrics(counts, metrics)
+	telemetry.publishBilling(ctx, billing)
+	for _, flush := range telemetry.flushers {
+		flush(ctx)
+	}
+	if telemetry.sink == nil || len(audit) == 0 {
+		return
+	}
+	for start := 0; start < len(audit); start += auditBatchSize {
+		end := start + auditBatchSize
+		if end > len(audit) {
+			end = len(audit)
+		}
+		err := telemetry.sink.WriteAudit(ctx, audit[start:end])
+		if err != nil {
+			log.Printf("dropped %d audit records: %v", end-start, err)
+		}
+		if telemetry.written != nil {
+			telemetry.written(ctx, audit[start:end], err)
+		}
+	}
+}
+
+// One embedded metric format line, or a few if a distribution has more values than a line can hold
+func (telemetry *telemetry) writeMetrics(counts map[string]float64, metrics map[string]*metric) {
+	if len(counts) == 0 && len(metrics) == 0 {
+		return
+	}
+	names := []string{}
+	for name := range counts {
+		names = append(names, name)
+	}
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for chunk := 0; ; chunk++ {
+		line := map[string]interface{}{"Service": "accountvalidator"}
+		definitions := []map[string]string{}
+		for _, name := range names {
+			if count, exists := counts[name]; exists && chunk == 0 {
+				line[name] = count
+				definitions = append(definitions, map[string]string{"Name": name, "Unit": "Count"})
+			}
+			if metric, exists := metrics[name]; exists && chunk*maxMetricValues < len(metric.values) {
+				end := (chunk + 1) * maxMetricValues
+				if end > len(metric.values) {
+					end = len(metric.values)
+				}
+				line[name] = metric.values[chunk*maxMetricValues : end]
+				definitions = append(definitions, map[string]string{"Name": name, "Unit": metric.unit})
+			}
+		}
+		if len(definitions) == 0 {
+			return
+		}
+		line["_aws"] = map[string]interface{}{
+			"Timestamp": telemetry.now().UnixMilli(),
+			"CloudWatchMetrics": []map[string]interface{}{{
+				"Namespace":  metricsNamespace,
+				"Dimensions": [][]string{{"Service"}},
+				"Metrics":    definitions,
+			}},
+		}
+		data, err := marshalJSON(line)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		fmt.Fprintln(telemetry.out, string(data))
+	}
+}
+
+// API Gateway's id for the request, the x-amzn-RequestId the caller gets back, or Lambda's when there isn't one
+func requestID(ctx context.Context, request Request) string {
+	if request.RequestContext.RequestID != "" {
+		return request.RequestContext.RequestID
+	}
+	if lambdaContext, ok := lambdacontext.FromContext(ctx); ok {
+		return lambdaContext.AwsRequestID
+	}
+	return ""
+}
+
+// Records a finished validation
+func (config *Config) recordValidation(ctx context.Context, request Request, validationRequest *BankAccountValidationRequest, response BankAccountValidationResponse, testAccount bool, duration time.Duration) {
+	if config.telemetry == nil {
+		return
+	}
+	record := AuditRecord{
+		AccountHash:   accountHash(*validationRequest.AccountNumber),
+		ValidatedAt:   config.telemetry.now().UTC(),
+		Outcome:       outcome(validationRequest, response),
+		DurationMs:    duration.Milliseconds(),
+		TestAccount:   testAccount,
+		Providers:     []string{},
+		AccountMasked: config.Masking.mask(*validationRequest.AccountNumber),
+		Caller:        callerIdentity(request),
+		RequestID:     requestID(ctx, request),
+		Verdicts:      map[string]bool{},
+	}
+	if validationRequest.ClientReference != nil {
+		record.ClientReference = *validationRequest.ClientReference
+	}
+	record.Events = outboxFrom(ctx).take(record.ValidatedAt)
+	errored := 0
+	for _, result := range response.Result {
+		if result.Local {
+			continue
+		}
+		record.Providers = append(record.Providers, result.Provider)
+		if result.CallID != "" {
+			if record.CallIDs == nil {
+				record.CallIDs = map[string]string{}
+			}
+			record.CallIDs[result.Provider] = result.CallID
+		}
+		if result.Error != "" {
+			errored++
+		} else {
+			record.Verdicts[result.Provider] = result.IsValid
+		}
+	}
+	config.telemetry.record(record)
+	config.telemetry.count("Validations", 1)
+	config.telemetry.count("ProviderErrors", float64(errored))
+	if record.Outcome == ResultStatusError {
+		config.telemetry.count("Unanswered", 1)
+	}
+	config.telemetry.observe("ValidationLatency", float64(duration.Milliseconds()), "Milliseconds")
+}
+
+type auditDynamoAPI interface {
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+type dynamoAuditSink struct {
+	client auditDynamoAPI
+	table  string
+	// Where the records' events go, see outbox.go
+	outboxTable string
+}
+
+func (sink *dynamoAuditSink) WriteAudit(ctx context.Context, records []AuditRecord) error {
+	// Records on their own are batched. One with events is written in a transaction with them, a batch can leave
+	// either behind in its unprocessed items.
+	batch := []types.WriteRequest{}
+	transactions := [][]types.TransactWriteItem{}
+	for _, record := range records {
+		item := map[string]types.AttributeValue{
+			"accountHash": &types.AttributeValueMemberS{Value: record.AccountHash},
+			"validatedAt": &types.AttributeValueMemberS{Value: record.ValidatedAt.Format(time.RFC3339Nano)},
+			"outcome":     &types.AttributeValueMemberS{Value: record.Outcome},
+			"durationMs":  &types.AttributeValueMemberN{Value: strconv.FormatInt(record.DurationMs, 10)},
+			"testAccount": &types.AttributeValueMemberBOOL{Value: record.TestAccount},
+		}
+		if record.RequestID != "" {
+			item["requestId"] = &types.AttributeValueMemberS{Value: record.RequestID}
+		}
+		if record.ClientReference != "" {
+			item["clientReference"] = &types.AttributeValueMemberS{Value: record.ClientReference}
+		}
+		if record.AccountMasked != "" {
+			item["accountMasked"] = &types.AttributeValueMemberS{Value: record.AccountMasked}
+		}
+		if record.Caller != "" {
+			item["caller"] = &types.AttributeValueMemberS{Value: record.Caller}
+		}
+		// DynamoDB doesn't allow empty sets
+		if len(record.Providers) > 0 {
+			item["providers"] = &types.AttributeValueMemberSS{Value: record.Providers}
+		}
+		if len(record.Verdicts) > 0 {
+			verdicts := map[string]types.AttributeValue{}
+			for provider, isValid := range record.Verdicts {
+				verdicts[provider] = &types.AttributeValueMemberBOOL{Value: isValid}
+			}
+			item["verdicts"] = &types.AttributeValueMemberM{Value: verdicts}
+		}
+		if len(record.CallIDs) > 0 {
+			callIDs := map[string]types.AttributeValue{}
+			for provider, id := range record.CallIDs {
+				callIDs[provider] = &types.AttributeValueMemberS{Value: id}
+			}
+			item["callIds"] = &types.AttributeValueMemberM{Value: callIDs}
+		}
+		if sink.outboxTable == "" || len(record.Events) == 0 {
+			batch = append(batch, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+			continue
+		}
+		transaction := []types.TransactWriteItem{{Put: &types.Put{TableName: aws.String(sink.table), Item: item}}}
+		for _, event := range record.Events {
+			transaction = append(transaction, types.TransactWriteItem{Put: &types.Put{TableName: aws.String(sink.outboxTable), Item: outboxItem(event)}})
+		}
+		transactions = append(transactions, transaction)
+	}
+	for start := 0; start < len(batch); start += auditBatchSize {
+		end := start + auditBatchSize
+		if end > len(batch) {
+			end = len(batch)
+		}
+		if err := sink.write(ctx, map[string][]types.WriteRequest{sink.table: batch[start:end]}); err != nil {
+			return err
+		}
+	}
+	for _, transaction := range transactions {
+		if _, err := sink.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: transaction}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sink *dynamoAuditSink) write(ctx context.Context, requests map[string][]types.WriteRequest) error {
+	// Retry whatever was throttled once, then give up on it
+	for attempt := 0; attempt < 2 && len(requests) > 0; attempt++ {
+		output, err := sink.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: requests})
+		if err != nil {
+			return err
+		}
+		requests = output.UnprocessedItems
+	}
+	unprocessed := 0
+	for _, table := range requests {
+		unprocessed += len(table)
+	}
+	if unprocessed > 0 {
+		return fmt.Errorf("%d unprocessed", unprocessed)
+	}
+	return nil
+}
+
+// Wraps a Lambda handler so the flush extension knows when it has returned
+type flushingHandler struct {
+	handler   lambda.Handler
+	telemetry *telemetry
+}
+
+func (handler flushingHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	defer handler.telemetry.invocationDone()
+	return handler.handler.Invoke(ctx, payload)
+}
+
+func (telemetry *telemetry) invocationDone() {
+	if telemetry == nil {
+		return
+	}
+	select {
+	case telemetry.invoked <- struct{}{}:
+	default:
+	}
+}
+
+// Wraps a streaming handler, which carries on writing after the Lambda handler has returned
+func (telemetry *telemetry) afterServing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer telemetry.invocationDone()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Internal Lambda extension that flushes after each invocation
+type flushExtension struct {
+	baseURL string
+	id      string
+	client  *http.Client
+}
+
+// Has to happen during init, before the runtime asks for its first invocation
+func registerFlushExtension(runtimeAPI string) (*flushExtension, error) {
+	extension := &flushExtension{baseURL: "http://" + runtimeAPI + "/2020-01-01/extension/", client: &http.Client{}}
+	request, err := http.NewRequest("POST", extension.baseURL+"register", bytes.NewReader([]byte("{\"events\":[\"INVOKE\"]}")))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Lambda-Extension-Name", flushExtensionName)
+	response, err := extension.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registering the flush extension failed: %s", response.Status)
+	}
+	extension.id = response.Header.Get("Lambda-Extension-Identifier")
+	return extension, nil
+}
+
+// Blocks until the next invocation starts
+func (extension *flushExtension) next() error {
+	request, err := http.NewRequest("GET", extension.baseURL+"event/next", nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Lambda-Extension-Identifier", extension.id)
+	response, err := extension.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode != http.StatusOK {
+		return errors.New("extension event/next failed: " + response.Status)
+	}
+	return nil
+}
+
+// For every invocation, waits for the handler to return and then flushes before letting the invocation finish
+func (extension *flushExtension) run(telemetry *telemetry) {
+	for {
+		if err := extension.next(); err != nil {
+			log.Printf("flush extension stopped: %v", err)
+			return
+		}
+		<-telemetry.invoked
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		telemetry.flush(ctx)
+		cancel()
+	}
+}
+
+// Starts flushing: after each invocation and on SIGTERM in Lambda, on a timer otherwise
+func (telemetry *telemetry) start() {
+	runtimeAPI, exists := os.LookupEnv("AWS_LAMBDA_RUNTIME_API")
+	if !exists {
+		go func() {
+			for range time.Tick(flushInterval) {
+				telemetry.flush(context.Background())
+			}
+		}()
+		return
+	}
+	extension, err := registerFlushExtension(runtimeAPI)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	go extension.run(telemetry)
+	// Lambda allows 500ms between SIGTERM and SIGKILL
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	go func() {
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+		telemetry.flush(ctx)
+		cancel()
+		os.Exit(0)
+	}()
+}
+
+// Creates the telemetry buffer, writing audit records to AUDIT_TABLE if there is one
+func (config *Config) setupTelemetry(ctx context.Context) {
+	var sink AuditSink
+	if config.store != nil {
+		sink = config.store
+	}
+	config.telemetry = newTelemetry(sink, os.Stdout)
+	config.telemetry.events = config.events
+}
+
+// Starts a Lambda handler, telling the flush extension each time it returns
+func (config *Config) startLambda(handler interface{}) {
+	lambda.StartHandler(flushingHandler{handler: lambda.NewHandler(handler), telemetry: config.telemetry})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type fakeAuditSink struct {
+	mu      sync.Mutex
+	batches [][]AuditRecord
+}
+
+func (sink *fakeAuditSink) WriteAudit(ctx context.Context, records []AuditRecord) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.batches = append(sink.batches, records)
+	return nil
+}
+
+func (sink *fakeAuditSink) records() int {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	count := 0
+	for _, batch := range sink.batches {
+		count += len(batch)
+	}
+	return count
+}
+
+// Parses embedded metric format lines into name -> value
+func parseMetricLines(t *testing.T, out string) []map[string]interface{} {
+	t.Helper()
+	lines := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var parsed map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &parsed); err != nil {
+			t.Fatalf("bad metric line %q: %v", line, err)
+		}
+		lines = append(lines, parsed)
+	}
+	return lines
+}
+
+func TestConfig_validate_telemetry(t *testing.T) {
+	sink := &fakeAuditSink{}
+	var out bytes.Buffer
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}, {Name: "provider2", Type: ProviderTypeSimulated}},
+		telemetry: newTelemetry(sink, &out),
+	}
+	for _, accountNumber := range []string{"12345670", "12349999"} {
+		accountNumber := accountNumber
+		config.validate(context.Background(), Request{}, &BankAccountValidationRequest{AccountNumber: &accountNumber}, nil)
+	}
+	if sink.records() != 0 || out.Len() != 0 {
+		t.Fatalf("nothing should be sent before a flush")
+	}
+
+	config.telemetry.flush(context.Background())
+	if len(sink.batches) != 1 || len(sink.batches[0]) != 2 {
+		t.Fatalf("expected one batch of 2 audit records, got %+v", sink.batches)
+	}
+	first := sink.batches[0][0]
+	if first.AccountHash != accountHash("12345670") || first.Outcome != ResultStatusValid || len(first.Providers) != 2 {
+		t.Errorf("audit record = %+v", first)
+	}
+	if sink.batches[0][1].Outcome != ResultStatusError {
+		t.Errorf("audit record = %+v", sink.batches[0][1])
+	}
+
+	lines := parseMetricLines(t, out.String())
+	if len(lines) != 1 {
+		t.Fatalf("expected one metric line, got %v", out.String())
+	}
+	want := map[string]float64{"Validations": 2, "ProviderErrors": 2, "Unanswered": 1}
+	for name, value := range want {
+		if lines[0][name] != value {
+			t.Errorf("%s = %v, want %v", name, lines[0][name], value)
+		}
+	}
+	if latency, ok := lines[0]["ValidationLatency"].([]interface{}); !ok || len(latency) != 2 {
+		t.Errorf("ValidationLatency = %v, want 2 values", lines[0]["ValidationLatency"])
+	}
+	if _, ok := lines[0]["_aws"]; !ok {
+		t.Errorf("missing the _aws metadata: %v", lines[0])
+	}
+
+	// Flushing again has nothing to send
+	out.Reset()
+	config.telemetry.flush(context.Background())
+	if len(sink.batches) != 1 || out.Len() != 0 {
+		t.Errorf("a second flush shouldn't send anything")
+	}
+}
+
+func Test_telemetry_flush_batches(t *testing.T) {
+	sink := &fakeAuditSink{}
+	var out bytes.Buffer
+	telemetry := newTelemetry(sink, &out)
+	for i := 0; i < 130; i++ {
+		telemetry.record(AuditRecord{AccountHash: "hash"})
+		telemetry.observe("ValidationLatency", float64(i), "Milliseconds")
+	}
+	telemetry.flush(context.Background())
+
+	sizes := []int{}
+	for _, batch := range sink.batches {
+		sizes = append(sizes, len(batch))
+	}
+	if len(sizes) != 6 || sizes[0] != auditBatchSize || sizes[5] != 5 {
+		t.Errorf("batch sizes = %v, want five of %d and one of 5", sizes, auditBatchSize)
+	}
+	lines := parseMetricLines(t, out.String())
+	if len(lines) != 2 || len(lines[0]["ValidationLatency"].([]interface{})) != 100 || len(lines[1]["ValidationLatency"].([]interface{})) != 30 {
+		t.Errorf("expected the latencies split over two lines, got %v", out.String())
+	}
+}
+
+type fakeBatchWriter struct {
+	auditDynamoAPI
+	calls       int
+	unprocessed int
+}
+
+func (db *fakeBatchWriter) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	db.calls++
+	output := &dynamodb.BatchWriteItemOutput{}
+	for table, requests := range params.RequestItems {
+		if db.unprocessed > 0 && len(requests) >= db.unprocessed {
+			output.UnprocessedItems = map[string][]types.WriteRequest{table: requests[:db.unprocessed]}
+		}
+	}
+	return output, nil
+}
+
+func Test_dynamoAuditSink_WriteAudit(t *testing.T) {
+	records := []AuditRecord{{AccountHash: "a", Providers: []string{"provider1"}}, {AccountHash: "b"}}
+
+	db := &fakeBatchWriter{}
+	if err := (&dynamoAuditSink{client: db, table: "audit"}).WriteAudit(context.Background(), records); err != nil || db.calls != 1 {
+		t.Errorf("WriteAudit() = %v after %d calls", err, db.calls)
+	}
+
+	// Unprocessed items are retried once
+	db = &fakeBatchWriter{unprocessed: 1}
+	if err := (&dynamoAuditSink{client: db, table: "audit"}).WriteAudit(context.Background(), records); err == nil || db.calls != 2 {
+		t.Errorf("WriteAudit() = %v after %d calls, want an error after 2", err, db.calls)
+	}
+}
+
+// Emulates the Lambda extensions API: one invocation, then an error to stop the extension
+func TestFlushExtension(t *testing.T) {
+	var mu sync.Mutex
+	nexts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2020-01-01/extension/register":
+			if r.Header.Get("Lambda-Extension-Name") != flushExtensionName {
+				w.WriteHeader(400)
+				return
+			}
+			w.Header().Set("Lambda-Extension-Identifier", "ext-1")
+		case "/2020-01-01/extension/event/next":
+			mu.Lock()
+			defer mu.Unlock()
+			nexts++
+			if r.Header.Get("Lambda-Extension-Identifier") != "ext-1" || nexts > 1 {
+				w.WriteHeader(500)
+				return
+			}
+			w.Write([]byte("{\"eventType\": \"INVOKE\"}"))
+		}
+	}))
+	defer server.Close()
+
+	extension, err := registerFlushExtension(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &fakeAuditSink{}
+	telemetry := newTelemetry(sink, &bytes.Buffer{})
+	telemetry.record(AuditRecord{AccountHash: "hash"})
+
+	stopped := make(chan struct{})
+	go func() {
+		extension.run(telemetry)
+		close(stopped)
+	}()
+
+	// The handler returning lets the extension flush and finish the invocation
+	handler := flushingHandler{handler: lambda.NewHandler(func() error { return nil }), telemetry: telemetry}
+	if _, err := handler.Invoke(context.Background(), []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("extension didn't finish the invocation")
+	}
+	if sink.records() != 1 {
+		t.Errorf("expected the audit record to be flushed after the invocation")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestTestAccount_response(t *testing.T) {
+	providers := []Provider{{Name: "provider1"}, {Name: "provider2"}}
+	tests := []struct {
+		name        string
+		testAccount TestAccount
+		want        BankAccountValidationResponse
+	}{
+		{name: "valid",
+			testAccount: TestAccount{AccountNumber: "00000001", IsValid: true},
+			want: BankAccountValidationResponse{Result: []BankAccountValidationResult{
+				{Provider: "provider1", IsValid: true},
+				{Provider: "provider2", IsValid: true},
+			}},
+		},
+		{name: "override",
+			testAccount: TestAccount{AccountNumber: "00000002", IsValid: true, Providers: map[string]bool{"provider2": false}},
+			want: BankAccountValidationResponse{Result: []BankAccountValidationResult{
+				{Provider: "provider1", IsValid: true},
+				{Provider: "provider2", IsValid: false},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.testAccount.response(providers); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("response() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_testAccountSkipsProviders(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Write([]byte("{\"isValid\": false}"))
+	}))
+	defer server.Close()
+
+	config := &Config{
+		Providers:    []Provider{{Name: "provider1", URL: server.URL}},
+		TestAccounts: []TestAccount{{AccountNumber: "00000001", IsValid: true}},
+	}
+	got, err := config.Handler(context.Background(), Request{Body: "{\"accountNumber\": \"00000001\"}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}]}"; got.Body != want {
+		t.Errorf("Handler() body = %v, want %v", got.Body, want)
+	}
+	if called {
+		t.Errorf("provider was called for a test account")
+	}
+
+	if _, err := config.Handler(context.Background(), Request{Body: "{\"accountNumber\": \"12345678\"}"}); err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Errorf("provider was not called for a real account")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+  Request timings. When a request misses its SLA we want to know where the time went without turning tracing on,
+  so every request writes one JSON line on stdout when it's done:
+
+    {"timings": {"requestId": "c6af9ac6-...", "method": "POST", "path": "/validate", "status": 200, "totalMs": 412.5,
+                 "stages": {"auth": 0.4, "parse": 0.1, "cacheLookup": 0.02, "aggregation": 0.05, "serialization": 0.2},
+                 "providers": [{"provider": "provider1", "attempt": 0, "ms": 398.1},
+                               {"provider": "provider2", "attempt": 0, "ms": 1000.2, "error": "timeout"}]}}
+
+  auth is everything Router checks before picking a handler (allowlist, signatures, authorization and payload rules),
+  parse is decoding the request, cacheLookup the ETag check, aggregation working out the verdict once the answers
+  are in and serialization writing the response body. There's an entry in providers for every attempt at every
+  HTTP call, retries included, so a slow provider shows up even when its retry saved the day. A stage the request
+  didn't get to is left out, and a batch or matrix adds up its items' stages and lists all their calls. Whatever's
+  left of totalMs is queueing and the other bits in between.
+*/
+
+const (
+	StageAuth          = "auth"
+	StageParse         = "parse"
+	StageCacheLookup   = "cacheLookup"
+	StageAggregation   = "aggregation"
+	StageSerialization = "serialization"
+)
+
+type RequestTimings struct {
+	RequestID string             `json:"requestId,omitempty"`
+	Method    string             `json:"method"`
+	Path      string             `json:"path"`
+	Status    int                `json:"status"`
+	TotalMs   float64            `json:"totalMs"`
+	Stages    map[string]float64 `json:"stages"`
+	Providers []ProviderTiming   `json:"providers,omitempty"`
+	// See coldstart.go
+	ColdStart bool `json:"coldStart,omitempty"`
+}
+
+type ProviderTiming struct {
+	Provider string  `json:"provider"`
+	Attempt  int     `json:"attempt"`
+	Ms       float64 `json:"ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+type requestTimer struct {
+	mu        sync.Mutex
+	start     time.Time
+	stages    map[string]time.Duration
+	providers []ProviderTiming
+	coldStart bool
+}
+
+type timerKey struct{}
+
+func withTimer(ctx context.Context) (context.Context, *requestTimer) {
+	timer := &requestTimer{start: time.Now(), stages: map[string]time.Duration{}}
+	return context.WithValue(ctx, timerKey{}, timer), timer
+}
+
+// The request's timer, nil outside of Router, which ignores everything
+func timerFrom(ctx context.Context) *requestTimer {
+	timer, _ := ctx.Value(timerKey{}).(*requestTimer)
+	return timer
+}
+
+// Whether the request is the container's cold start
+func (timer *requestTimer) cold() bool {
+	return timer != nil && timer.coldStart
+}
+
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration.Microseconds()) / 1000
+}
+
+// Starts timing a stage, the time counts once the returned func is called
+func (timer *requestTimer) stage(name string) func() {
+	if timer == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		timer.mu.Lock()
+		defer timer.mu.Unlock()
+		timer.stages[name] += time.Since(start)
+	}
+}
+
+func (timer *requestTimer) providerCall(provider string, attempt int, latency time.Duration, callError string) {
+	if timer == nil {
+		return
+	}
+	timer.mu.Lock()
+	defer timer.mu.Unlock()
+	timer.providers = append(timer.providers, ProviderTiming{Provider: provider, Attempt: attempt, Ms: milliseconds(latency), Error: callError})
+}
+
+func (timer *requestTimer) timings(ctx context.Context, request Request, status int) RequestTimings {
+	timer.mu.Lock()
+	defer timer.mu.Unlock()
+	timings := RequestTimings{
+		RequestID: requestID(ctx, request),
+		Method:    request.HTTPMethod,
+		Path:      request.Path,
+		Status:    status,
+		TotalMs:   milliseconds(time.Since(timer.start)),
+		Stages:    map[string]float64{},
+		Providers: append([]ProviderTiming(nil), timer.providers...),
+		ColdStart: timer.coldStart,
+	}
+	for name, duration := range timer.stages {
+		timings.Stages[name] = milliseconds(duration)
+	}
+	return timings
+}
+
+// Writes the request's timings out, nothing without a timing log
+func (config *Config) logTimings(ctx context.Context, request Request, timer *requestTimer, status int) {
+	if config.timingLog == nil {
+		return
+	}
+	line, err := marshalJSON(map[string]RequestTimings{"timings": timer.timings(ctx, request, status)})
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	fmt.Fprintln(config.timingLog, string(line))
+}
+
+func (config *Config) setupTimings() {
+	config.timingLog = os.Stdout
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_logTimings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	config := &Config{Providers: []Provider{
+		{Name: "provider1", URL: server.URL},
+		{Name: "provider2", URL: "http://127.0.0.1:1", Retries: 1},
+	}, timingLog: &out}
+	request := Request{HTTPMethod: "POST", Path: "/validate", Body: "{\"accountNumber\": \"12345678\", \"strategy\": \"any\"}"}
+	request.RequestContext.RequestID = "c6af9ac6"
+	if response, err := config.Router(context.Background(), request); err != nil || response.StatusCode != 200 {
+		t.Fatalf("Router() = %d %s, %v", response.StatusCode, response.Body, err)
+	}
+
+	if lines := strings.Count(out.String(), "\n"); lines != 1 {
+		t.Fatalf("logged %d lines, want 1: %s", lines, out.String())
+	}
+	var logged struct {
+		Timings RequestTimings `json:"timings"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &logged); err != nil {
+		t.Fatalf("timings aren't json: %v", err)
+	}
+	timings := logged.Timings
+	if timings.RequestID != "c6af9ac6" || timings.Method != "POST" || timings.Path != "/validate" || timings.Status != 200 {
+		t.Errorf("timings = %+v, want the request's details", timings)
+	}
+	for _, stage := range []string{StageAuth, StageParse, StageAggregation, StageSerialization} {
+		if _, exists := timings.Stages[stage]; !exists {
+			t.Errorf("stages = %v, want %s", timings.Stages, stage)
+		}
+	}
+	if _, exists := timings.Stages[StageCacheLookup]; exists {
+		t.Errorf("stages = %v, there's no cache to look in", timings.Stages)
+	}
+	calls := map[string]int{}
+	for _, call := range timings.Providers {
+		calls[call.Provider]++
+		if call.Provider == "provider1" && (call.Ms < 20 || call.Error != "") {
+			t.Errorf("provider1 call = %+v, want at least 20ms and no error", call)
+		}
+		if call.Provider == "provider2" && call.Error != ProviderErrorRequest {
+			t.Errorf("provider2 call = %+v, want %s", call, ProviderErrorRequest)
+		}
+	}
+	if calls["provider1"] != 1 || calls["provider2"] != 2 {
+		t.Errorf("provider calls = %v, want one for provider1 and two for provider2", calls)
+	}
+	if timings.TotalMs < 20 {
+		t.Errorf("totalMs = %v, want at least the provider call", timings.TotalMs)
+	}
+}
+
+func Test_requestTimerNil(t *testing.T) {
+	// Outside of Router there's no timer and nothing is recorded
+	timer := timerFrom(context.Background())
+	timer.stage(StageParse)()
+	timer.providerCall("provider1", 0, time.Second, "")
+	if timer != nil {
+		t.Errorf("timerFrom() = %v, want nil", timer)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+/*
+  TLS policy for provider calls. Everything we call must meet minVersion and (for TLS 1.2) negotiate one of the
+  approved cipher suites; Go doesn't allow the TLS 1.3 suites to be restricted, they are all approved. Providers can
+  also pin the SHA-256 of their certificate's SubjectPublicKeyInfo, base64 encoded. Any violation fails the call with
+  tls_policy_violation rather than a generic request failure so it stands out.
+
+    tls:
+      minVersion: "1.2"
+      cipherSuites:
+      - TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+      - TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+    providers:
+    - name: provider1
+      url: https://provider1.com/v1/api/account/validate
+      pins: ["47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="]
+*/
+
+const ProviderErrorSecurity = "tls_policy_violation"
+
+type TLSConfig struct {
+	MinVersion   string   `yaml:"minVersion"`
+	CipherSuites []string `yaml:"cipherSuites"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Returned when a connection doesn't meet the policy
+type tlsPolicyError struct {
+	reason string
+}
+
+func (err *tlsPolicyError) Error() string {
+	return "tls policy violation: " + err.reason
+}
+
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	known := map[string]uint16{}
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+	ids := []uint16{}
+	for _, name := range names {
+		id, exists := known[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func validatePin(pin string) error {
+	decoded, err := base64.StdEncoding.DecodeString(pin)
+	if err != nil || len(decoded) != sha256.Size {
+		return fmt.Errorf("pin %s is not a base64 sha256 hash", pin)
+	}
+	return nil
+}
+
+// Checks the tls section and every provider's pins, called from compile
+func (config *Config) validateTLS() error {
+	if config.TLS.MinVersion != "" {
+		if _, exists := tlsVersions[config.TLS.MinVersion]; !exists {
+			return fmt.Errorf("tls minVersion %s is not one of 1.0, 1.1, 1.2, 1.3", config.TLS.MinVersion)
+		}
+	}
+	if _, err := cipherSuiteIDs(config.TLS.CipherSuites); err != nil {
+		return err
+	}
+	for _, provider := range config.Providers {
+		for _, pin := range provider.Pins {
+			if err := validatePin(pin); err != nil {
+				return fmt.Errorf("provider %s: %w", provider.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Pins by hostname, since that's what we know at handshake time
+func (config *Config) pinsByHost() map[string]map[string]bool {
+	pins := map[string]map[string]bool{}
+	for _, provider := range config.Providers {
+		if len(provider.Pins) == 0 {
+			continue
+		}
+		for _, raw := range provider.endpointURLs() {
+			parsed, err := url.Parse(raw)
+			if err != nil {
+				continue
+			}
+			if pins[parsed.Hostname()] == nil {
+				pins[parsed.Hostname()] = map[string]bool{}
+			}
+			for _, pin := range provider.Pins {
+				pins[parsed.Hostname()][pin] = true
+			}
+		}
+	}
+	return pins
+}
+
+// The client tls config every provider call uses. Assumes validateTLS has passed.
+//
+// The version and cipher policy is enforced after the handshake rather than by restricting what we offer, so a
+// provider that can't meet it fails with our own error (and a useful reason) instead of an opaque handshake failure.
+func (config *Config) tlsClientConfig() *tls.Config {
+	minVersion := uint16(tls.VersionTLS12)
+	if version, exists := tlsVersions[config.TLS.MinVersion]; exists {
+		minVersion = version
+	}
+	tlsConfig := &tls.Config{MinVersion: minVersion}
+	if minVersion > tls.VersionTLS12 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+	approved := map[uint16]bool{}
+	if ids, _ := cipherSuiteIDs(config.TLS.CipherSuites); len(ids) > 0 {
+		// Offer everything Go considers secure plus anything approved that it doesn't
+		offered := map[uint16]bool{}
+		for _, suite := range tls.CipherSuites() {
+			offered[suite.ID] = true
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, suite.ID)
+		}
+		for _, id := range ids {
+			approved[id] = true
+			if !offered[id] {
+				offered[id] = true
+				tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+			}
+		}
+	}
+	pins := config.pinsByHost()
+
+	// Runs after normal certificate verification
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if state.Version < minVersion {
+			return &tlsPolicyError{reason: fmt.Sprintf("%s negotiated %s", state.ServerName, tls.VersionName(state.Version))}
+		}
+		if state.Version < tls.VersionTLS13 && len(approved) > 0 && !approved[state.CipherSuite] {
+			return &tlsPolicyError{reason: fmt.Sprintf("%s negotiated %s", state.ServerName, tls.CipherSuiteName(state.CipherSuite))}
+		}
+		hostPins, pinned := pins[state.ServerName]
+		if !pinned {
+			return nil
+		}
+		for _, cert := range state.PeerCertificates {
+			hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if hostPins[base64.StdEncoding.EncodeToString(hash[:])] {
+				return nil
+			}
+		}
+		return &tlsPolicyError{reason: fmt.Sprintf("%s certificate does not match any pin", state.ServerName)}
+	}
+	return tlsConfig
+}
+
+// Whether a transport error is the TLS layer refusing the connection
+func isTLSViolation(err error) bool {
+	var policyErr *tlsPolicyError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	return errors.As(err, &policyErr) || errors.As(err, &alertErr) || errors.As(err, &verifyErr)
+}
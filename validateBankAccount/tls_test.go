@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func spkiPin(cert []byte) string {
+	hash := sha256.Sum256(cert)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// The test server's certificate is for example.com, point that at the server
+func testProviderURL(server *httptest.Server) string {
+	return strings.Replace(server.URL, "127.0.0.1", "example.com", 1)
+}
+
+// Calls the provider through a transport using the config's tls policy, trusting the test server's certificate
+func callWithPolicy(t *testing.T, config *Config, server *httptest.Server) BankAccountValidationResult {
+	tlsConfig := config.tlsClientConfig()
+	tlsConfig.RootCAs = server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	cache, _ := newTestDNSCache(&fakeResolver{answers: map[string][]string{"example.com": {"127.0.0.1"}}})
+	transport := &http.Transport{TLSClientConfig: tlsConfig, DialContext: cache.dialContext(&net.Dialer{})}
+	providerTransport = transport
+	defer func() { providerTransport = http.DefaultTransport }()
+	return callProvider(context.Background(), "12345678", config.Providers[0], config.Providers[0].URL)
+}
+
+func newTLSProvider(t *testing.T, configure func(*tls.Config)) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	server.TLS = &tls.Config{}
+	if configure != nil {
+		configure(server.TLS)
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_tlsPolicy_pins(t *testing.T) {
+	server := newTLSProvider(t, nil)
+	goodPin := spkiPin(server.Certificate().RawSubjectPublicKeyInfo)
+	badPin := spkiPin([]byte("not the key"))
+
+	tests := []struct {
+		name      string
+		pins      []string
+		wantError string
+	}{
+		{name: "unpinned", pins: nil, wantError: ""},
+		{name: "pinned", pins: []string{badPin, goodPin}, wantError: ""},
+		{name: "wrongPin", pins: []string{badPin}, wantError: ProviderErrorSecurity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Providers: []Provider{{Name: "provider1", URL: testProviderURL(server), Pins: tt.pins}}}
+			if err := config.validateTLS(); err != nil {
+				t.Fatal(err)
+			}
+			if got := callWithPolicy(t, config, server); got.Error != tt.wantError {
+				t.Errorf("callProvider() error = %q, want %q", got.Error, tt.wantError)
+			}
+		})
+	}
+}
+
+func Test_tlsPolicy_minVersion(t *testing.T) {
+	server := newTLSProvider(t, func(c *tls.Config) { c.MaxVersion = tls.VersionTLS12 })
+	config := &Config{
+		TLS:       TLSConfig{MinVersion: "1.3"},
+		Providers: []Provider{{Name: "provider1", URL: testProviderURL(server)}},
+	}
+	if got := callWithPolicy(t, config, server); got.Error != ProviderErrorSecurity {
+		t.Errorf("callProvider() error = %q, want %q", got.Error, ProviderErrorSecurity)
+	}
+}
+
+func Test_tlsPolicy_cipherSuites(t *testing.T) {
+	server := newTLSProvider(t, func(c *tls.Config) {
+		c.MaxVersion = tls.VersionTLS12
+		c.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256}
+	})
+	config := &Config{
+		TLS:       TLSConfig{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}},
+		Providers: []Provider{{Name: "provider1", URL: testProviderURL(server)}},
+	}
+	if got := callWithPolicy(t, config, server); got.Error != ProviderErrorSecurity {
+		t.Errorf("callProvider() error = %q, want %q", got.Error, ProviderErrorSecurity)
+	}
+}
+
+func TestConfig_validateTLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{name: "empty", config: &Config{}},
+		{name: "valid", config: &Config{TLS: TLSConfig{MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}}},
+		{name: "badVersion", config: &Config{TLS: TLSConfig{MinVersion: "2.0"}}, wantErr: true},
+		{name: "badSuite", config: &Config{TLS: TLSConfig{CipherSuites: []string{"TLS_MADE_UP"}}}, wantErr: true},
+		{name: "badPin", config: &Config{Providers: []Provider{{Name: "provider1", Pins: []string{"abc"}}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validateTLS(); (err != nil) != tt.wantErr {
+				t.Errorf("validateTLS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
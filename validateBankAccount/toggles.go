@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+/*
+  Provider toggles. On call can pull a provider out of rotation without a config deploy:
+
+    POST /admin/providers/provider2/disable
+    POST /admin/providers/provider2/enable
+
+  Both are admin routes (see admin.go). Disabling is a runtime override (see runtimeoverrides.go) in the table in
+  TOGGLES_TABLE, so every container has it within seconds, and enabling clears it, so the provider goes back to
+  whatever the config says. A provider switched off with enabled: false stays off when it's enabled here. A disabled
+  provider is treated exactly like one with enabled: false, see overrides.go.
+*/
+
+const toggleDisabled = "disabled"
+
+// Set up at init by setupToggles when there's a toggles table, nil means nothing's ever toggled
+var runtimeToggles *runtimeOverrides
+
+func (config *Config) setupToggles(ctx context.Context) {
+	runtimeToggles = setupRuntimeOverrides(ctx, OverrideKindToggles, "TOGGLES_TABLE")
+}
+
+// Whether the provider's been disabled at runtime, as the request sees it
+func toggledOff(ctx context.Context, provider string) bool {
+	return runtimeToggles.valueFor(ctx, provider) == toggleDisabled
+}
+
+// Handler for POST /admin/providers/{name}/disable
+func (config *Config) DisableProviderHandler(ctx context.Context, request Request) (Response, error) {
+	return config.toggleProvider(ctx, request, toggleDisabled)
+}
+
+// Handler for POST /admin/providers/{name}/enable
+func (config *Config) EnableProviderHandler(ctx context.Context, request Request) (Response, error) {
+	return config.toggleProvider(ctx, request, "")
+}
+
+func (config *Config) toggleProvider(ctx context.Context, request Request, value string) (Response, error) {
+	provider, response := config.pathProvider(request)
+	if response != nil {
+		return *response, nil
+	}
+	if runtimeToggles == nil {
+		return jsonResponse(http.StatusServiceUnavailable, map[string]string{"error": "provider toggles aren't set up"})
+	}
+	if err := runtimeToggles.set(ctx, provider.Name, value, adminCallerFrom(ctx), ""); err != nil {
+		return *handleError(err, "unable to save the toggle"), nil
+	}
+	log.Printf("provider %s enabled set to %v by %s", provider.Name, value == "", adminCallerFrom(ctx))
+	return jsonResponse(http.StatusOK, provider.summary())
+}
+
+// The provider named in the path, or the 404 when there isn't one
+func (config *Config) pathProvider(request Request) (Provider, *Response) {
+	name := request.PathParameters["name"]
+	i, exists := providerIndex(config.Providers)[providerKey(name)]
+	if !exists {
+		response, _ := jsonResponse(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown provider %s", name)})
+		return Provider{}, &response
+	}
+	return config.Providers[i], nil
+}
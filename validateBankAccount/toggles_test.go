@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestConfig_Router_toggleProvider(t *testing.T) {
+	store := newFakeOverrides(map[string]string{})
+	runtimeToggles = freshOverrides(store)
+	defer func() { runtimeToggles = nil }()
+	config := &Config{
+		Providers:  []Provider{{Name: "provider1", Type: ProviderTypeSimulated}, {Name: "provider2", Type: ProviderTypeSimulated}},
+		Admin:      &AdminConfig{Keys: []*SigningKey{{ID: "oncall", Secret: "admin-secret"}}},
+		adminAudit: &bytes.Buffer{},
+	}
+	toggle := func(path string) Response {
+		response, err := config.Router(context.Background(), adminRequest("POST", path, "", "admin-secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	validate := func() string {
+		response, _ := config.Router(context.Background(), Request{HTTPMethod: "POST", Path: "/application", Body: "{\"accountNumber\": \"12345670\"}"})
+		return response.Body
+	}
+
+	if got := toggle("/admin/providers/Provider2/disable"); got.StatusCode != 200 || got.Body != `{"name":"provider2","type":"simulated","enabled":false,"circuit":"closed"}` {
+		t.Errorf("disable = %d %s", got.StatusCode, got.Body)
+	}
+	if store.values["provider2"] != toggleDisabled || store.setBy["provider2"] != "key:oncall" {
+		t.Errorf("stored toggles = %v by %v, want provider2 disabled by key:oncall", store.values, store.setBy)
+	}
+	if got, want := validate(), "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}]}"; got != want {
+		t.Errorf("validation with provider2 disabled = %s, want %s", got, want)
+	}
+	if got := toggle("/admin/providers/provider2/enable"); got.StatusCode != 200 || len(store.values) != 0 {
+		t.Errorf("enable = %d %s, stored %v", got.StatusCode, got.Body, store.values)
+	}
+	if got := toggle("/admin/providers/provider9/disable"); got.StatusCode != 404 {
+		t.Errorf("unknown provider = %d %s, want 404", got.StatusCode, got.Body)
+	}
+	store.err = errors.New("throttled")
+	if got := toggle("/admin/providers/provider1/disable"); got.StatusCode != 500 || toggledOff(context.Background(), "provider1") {
+		t.Errorf("failed disable = %d %s, want 500 and still enabled", got.StatusCode, got.Body)
+	}
+}
+
+func TestConfig_toggleProvider_notSetUp(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1"}}}
+	got, _ := config.DisableProviderHandler(context.Background(), Request{PathParameters: map[string]string{"name": "provider1"}})
+	if got.StatusCode != 503 {
+		t.Errorf("DisableProviderHandler() = %d %s, want 503", got.StatusCode, got.Body)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+  Trace and correlation header propagation. Whatever tracing headers the caller sent us are copied onto every
+  provider call so a partner can find our requests in their logs during an incident. The mapping is incoming header
+  name to outbound header name; without one we pass X-Amzn-Trace-Id, traceparent and X-Correlation-Id through as is.
+
+    tracing:
+      propagate:
+        X-Amzn-Trace-Id: X-Amzn-Trace-Id
+        traceparent: traceparent
+        X-Correlation-Id: X-Request-Id    # provider2 calls it something else
+*/
+
+type TracingConfig struct {
+	Propagate map[string]string `yaml:"propagate"`
+}
+
+var defaultTracePropagation = map[string]string{
+	"X-Amzn-Trace-Id":  "X-Amzn-Trace-Id",
+	"traceparent":      "traceparent",
+	"X-Correlation-Id": "X-Correlation-Id",
+}
+
+type traceHeadersKey struct{}
+
+func (tracing TracingConfig) propagation() map[string]string {
+	if len(tracing.Propagate) == 0 {
+		return defaultTracePropagation
+	}
+	return tracing.Propagate
+}
+
+func (tracing TracingConfig) validate() error {
+	for incoming, outbound := range tracing.Propagate {
+		for _, name := range []string{incoming, outbound} {
+			if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+				return fmt.Errorf("tracing propagate has an invalid header name %q", name)
+			}
+			if reservedHeaders[http.CanonicalHeaderKey(name)] {
+				return fmt.Errorf("tracing can't propagate the %s header", http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return nil
+}
+
+// Picks the headers to propagate out of the incoming request. API Gateway doesn't normalise header case.
+func (tracing TracingConfig) traceHeaders(request Request) http.Header {
+	headers := http.Header{}
+	for incoming, outbound := range tracing.propagation() {
+		if value := requestHeader(request.Headers, incoming); value != "" {
+			headers.Set(outbound, value)
+		}
+	}
+	return headers
+}
+
+func withTraceHeaders(ctx context.Context, headers http.Header) context.Context {
+	return context.WithValue(ctx, traceHeadersKey{}, headers)
+}
+
+// Adds any trace headers carried by the context to an outbound request
+func setTraceHeaders(ctx context.Context, request *http.Request) {
+	headers, _ := ctx.Value(traceHeadersKey{}).(http.Header)
+	for name, values := range headers {
+		request.Header[name] = values
+	}
+}
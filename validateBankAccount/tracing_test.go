@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestTracingConfig_traceHeaders(t *testing.T) {
+	request := Request{Headers: map[string]string{
+		"x-amzn-trace-id":  "Root=1-5759e988-bd862e3fe1be46a994272793",
+		"Traceparent":      "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"X-Correlation-Id": "abc-123",
+		"Authorization":    "Bearer secret",
+	}}
+	tests := []struct {
+		name    string
+		tracing TracingConfig
+		want    http.Header
+	}{
+		{name: "default",
+			tracing: TracingConfig{},
+			want: http.Header{
+				"X-Amzn-Trace-Id":  {"Root=1-5759e988-bd862e3fe1be46a994272793"},
+				"Traceparent":      {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+				"X-Correlation-Id": {"abc-123"},
+			},
+		},
+		{name: "mapped",
+			tracing: TracingConfig{Propagate: map[string]string{"X-Correlation-Id": "X-Request-Id"}},
+			want:    http.Header{"X-Request-Id": {"abc-123"}},
+		},
+		{name: "missing",
+			tracing: TracingConfig{Propagate: map[string]string{"X-B3-TraceId": "X-B3-TraceId"}},
+			want:    http.Header{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tracing.traceHeaders(request); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("traceHeaders() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTracingConfig_validate(t *testing.T) {
+	if err := (TracingConfig{Propagate: map[string]string{"X-Correlation-Id": "X-Request-Id"}}).validate(); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+	if err := (TracingConfig{Propagate: map[string]string{"X-Correlation-Id": "Host"}}).validate(); err == nil {
+		t.Errorf("validate() should refuse to overwrite Host")
+	}
+	if err := (TracingConfig{Propagate: map[string]string{"X Correlation": "X-Request-Id"}}).validate(); err == nil {
+		t.Errorf("validate() should refuse an invalid header name")
+	}
+}
+
+func TestConfig_Handler_propagatesTraceHeaders(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte("{\"isValid\": true}"))
+	}))
+	defer server.Close()
+
+	config := &Config{Providers: []Provider{{Name: "provider1", URL: server.URL}}}
+	request := Request{
+		Body:    "{\"accountNumber\": \"12345678\"}",
+		Headers: map[string]string{"x-correlation-id": "abc-123", "traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+	}
+	if _, err := config.Handler(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("X-Correlation-Id") != "abc-123" || got.Get("traceparent") != "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01" {
+		t.Errorf("provider got headers %v, want the trace headers propagated", got)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+/*
+  Concurrency from the function's memory size. Lambda gives a function CPU in proportion to its memory, a full vCPU
+  at 1769MB, so a worker pool and connection pool that suit 2GB leave a 128MB function thrashing and ones that suit
+  128MB leave 2GB idle. Both are sized from AWS_LAMBDA_FUNCTION_MEMORY_SIZE instead:
+
+    memory   workers   idle connections per host
+    128MB    16        4
+    512MB    18        4
+    1769MB   64        10
+    3008MB   108       18
+    10240MB  256       42
+
+  That's 64 workers per vCPU, never fewer than 16 or more than 256, and an idle connection per host for every six
+  workers, at least 4. PROVIDER_WORKERS still wins when it's set (see workers.go) and the connections follow it.
+  Outside of Lambda, where there's no memory size, it's the old 64 workers and 10 connections.
+*/
+
+const (
+	fullVCPUMemoryMB    = 1769
+	workersPerVCPU      = 64
+	minProviderWorkers  = 16
+	maxProviderWorkers  = 256
+	workersPerIdleConn  = 6
+	minIdleConnsPerHost = 4
+)
+
+// The function's memory in MB, 0 when we're not in Lambda
+func functionMemoryMB() int {
+	memory, err := strconv.Atoi(os.Getenv("AWS_LAMBDA_FUNCTION_MEMORY_SIZE"))
+	if err != nil || memory < 0 {
+		return 0
+	}
+	return memory
+}
+
+// How many workers the memory size is worth
+func tunedWorkers(memoryMB int) int {
+	if memoryMB == 0 {
+		return defaultProviderWorkers
+	}
+	workers := memoryMB * workersPerVCPU / fullVCPUMemoryMB
+	if workers < minProviderWorkers {
+		return minProviderWorkers
+	}
+	if workers > maxProviderWorkers {
+		return maxProviderWorkers
+	}
+	return workers
+}
+
+// Enough idle connections to keep the workers' calls to one host from redialling. Without a pool it's what the pool
+// would have been.
+func idleConnsPerHost() int {
+	workers, _ := providerWorkers()
+	if workers == 0 {
+		workers = tunedWorkers(functionMemoryMB())
+	}
+	if workers/workersPerIdleConn < minIdleConnsPerHost {
+		return minIdleConnsPerHost
+	}
+	return workers / workersPerIdleConn
+}
+
+// The worker pool's size, PROVIDER_WORKERS or else what the memory size is worth, and false when PROVIDER_WORKERS
+// is set to something we can't use
+func providerWorkers() (int, bool) {
+	if value, exists := os.LookupEnv("PROVIDER_WORKERS"); exists {
+		parsed, err := strconv.Atoi(value)
+		if err == nil && parsed >= 0 {
+			return parsed, true
+		}
+		return tunedWorkers(functionMemoryMB()), false
+	}
+	return tunedWorkers(functionMemoryMB()), true
+}
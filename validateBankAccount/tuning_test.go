@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func Test_tunedWorkers(t *testing.T) {
+	tests := []struct {
+		memoryMB int
+		want     int
+	}{
+		{0, 64},
+		{128, 16},
+		{512, 18},
+		{1769, 64},
+		{2048, 74},
+		{3008, 108},
+		{10240, 256},
+	}
+	for _, tt := range tests {
+		if got := tunedWorkers(tt.memoryMB); got != tt.want {
+			t.Errorf("tunedWorkers(%d) = %d, want %d", tt.memoryMB, got, tt.want)
+		}
+	}
+}
+
+func Test_idleConnsPerHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		memory   string
+		workers  string
+		want     int
+		wantPool int
+		wantOK   bool
+	}{
+		{"outsideLambda", "", "", 10, 64, true},
+		{"small", "128", "", 4, 16, true},
+		{"large", "3008", "", 18, 108, true},
+		{"workersSet", "128", "120", 20, 120, true},
+		{"noPool", "3008", "0", 18, 0, true},
+		{"badWorkers", "1769", "lots", 10, 64, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_LAMBDA_FUNCTION_MEMORY_SIZE", tt.memory)
+			if tt.workers != "" {
+				t.Setenv("PROVIDER_WORKERS", tt.workers)
+			}
+			if got := idleConnsPerHost(); got != tt.want {
+				t.Errorf("idleConnsPerHost() = %d, want %d", got, tt.want)
+			}
+			if got, ok := providerWorkers(); got != tt.wantPool || ok != tt.wantOK {
+				t.Errorf("providerWorkers() = %d, %v, want %d, %v", got, ok, tt.wantPool, tt.wantOK)
+			}
+		})
+	}
+}
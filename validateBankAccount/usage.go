@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+/*
+  Daily usage report. Shortly after midnight UTC (MODE=usage-report) the audit table is read for the day before and
+  summed up per caller and provider for finance and partner management:
+
+    usageReport:
+      location: s3://accountvalidator-data/usage   # each day goes to <location>/<yyyy-mm-dd>.csv
+
+    date,caller,provider,calls,valid,invalid,errors,validRate,p50Ms,p95Ms,p99Ms,cost
+    2024-06-01,client-42,provider1,1200,1130,58,12,0.9417,180,420,800,60.0000
+
+  A call is a validation that reached the provider, the outcome and latency are the validation's since the audit
+  table doesn't keep each provider's, and the cost is calls times the provider's unitCost. Test accounts never reach
+  a provider so they aren't counted. It's CSV rather than Parquet, Athena and the spreadsheets finance use read it
+  as it is.
+*/
+
+type UsageReportConfig struct {
+	Location string `yaml:"location"`
+}
+
+// One caller's use of one provider on one day
+type usageRow struct {
+	Date     string
+	Caller   string
+	Provider string
+	Calls    int
+	Valid    int
+	Invalid  int
+	Errors   int
+	P50Ms    int64
+	P95Ms    int64
+	P99Ms    int64
+	Cost     float64
+}
+
+type auditScanAPI interface {
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+// Reads the audit records validated in [from, to)
+func scanAudit(ctx context.Context, client auditScanAPI, table string, from, to time.Time) ([]AuditRecord, error) {
+	return scanAuditBetween(ctx, client, table, "validatedAt", from, to)
+}
+
+// Reads the audit records with an RFC3339 timestamp attribute in [from, to)
+func scanAuditBetween(ctx context.Context, client auditScanAPI, table, attribute string, from, to time.Time) ([]AuditRecord, error) {
+	records := []AuditRecord{}
+	var startKey map[string]types.AttributeValue
+	for {
+		output, err := client.Scan(ctx, &dynamodb.ScanInput{
+			TableName:        aws.String(table),
+			FilterExpression: aws.String(attribute + " >= :from AND " + attribute + " < :to"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":from": &types.AttributeValueMemberS{Value: from.Format(time.RFC3339Nano)},
+				":to":   &types.AttributeValueMemberS{Value: to.Format(time.RFC3339Nano)},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range output.Items {
+			records = append(records, auditFromItem(item))
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			return records, nil
+		}
+		startKey = output.LastEvaluatedKey
+	}
+}
+
+// The audit record an item was written from, with its feedback if it has any
+func auditFromItem(item map[string]types.AttributeValue) AuditRecord {
+	record := AuditRecord{}
+	if value, ok := item["accountHash"].(*types.AttributeValueMemberS); ok {
+		record.AccountHash = value.Value
+	}
+	if value, ok := item["requestId"].(*types.AttributeValueMemberS); ok {
+		record.RequestID = value.Value
+	}
+	if value, ok := item["clientReference"].(*types.AttributeValueMemberS); ok {
+		record.ClientReference = value.Value
+	}
+	if value, ok := item["accountMasked"].(*types.AttributeValueMemberS); ok {
+		record.AccountMasked = value.Value
+	}
+	if value, ok := item["verdicts"].(*types.AttributeValueMemberM); ok {
+		record.Verdicts = map[string]bool{}
+		for provider, verdict := range value.Value {
+			if isValid, ok := verdict.(*types.AttributeValueMemberBOOL); ok {
+				record.Verdicts[provider] = isValid.Value
+			}
+		}
+	}
+	if value, ok := item["actualOutcome"].(*types.AttributeValueMemberS); ok {
+		record.Feedback = &AuditFeedback{ActualOutcome: value.Value}
+		if reason, ok := item["feedbackReason"].(*types.AttributeValueMemberS); ok {
+			record.Feedback.Reason = reason.Value
+		}
+		if caller, ok := item["feedbackCaller"].(*types.AttributeValueMemberS); ok {
+			record.Feedback.Caller = caller.Value
+		}
+		if at, ok := item["feedbackAt"].(*types.AttributeValueMemberS); ok {
+			record.Feedback.At, _ = time.Parse(time.RFC3339Nano, at.Value)
+		}
+	}
+	if value, ok := item["validatedAt"].(*types.AttributeValueMemberS); ok {
+		record.ValidatedAt, _ = time.Parse(time.RFC3339Nano, value.Value)
+	}
+	if value, ok := item["outcome"].(*types.AttributeValueMemberS); ok {
+		record.Outcome = value.Value
+	}
+	if value, ok := item["durationMs"].(*types.AttributeValueMemberN); ok {
+		record.DurationMs, _ = strconv.ParseInt(value.Value, 10, 64)
+	}
+	if value, ok := item["testAccount"].(*types.AttributeValueMemberBOOL); ok {
+		record.TestAccount = value.Value
+	}
+	if value, ok := item["providers"].(*types.AttributeValueMemberSS); ok {
+		record.Providers = value.Value
+	}
+	if value, ok := item["caller"].(*types.AttributeValueMemberS); ok {
+		record.Caller = value.Value
+	}
+	return record
+}
+
+// The nearest rank percentile of sorted durations
+func percentileMs(sorted []int64, percentile float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Sums the day's records up by caller and provider
+func aggregateUsage(date string, records []AuditRecord, unitCosts map[string]float64) []usageRow {
+	type usageKey struct{ caller, provider string }
+	rows := map[usageKey]*usageRow{}
+	durations := map[usageKey][]int64{}
+	for _, record := range records {
+		if record.TestAccount {
+			continue
+		}
+		caller := record.Caller
+		if caller == "" {
+			caller = "anonymous"
+		}
+		for _, provider := range record.Providers {
+			key := usageKey{caller, provider}
+			row, exists := rows[key]
+			if !exists {
+				row = &usageRow{Date: date, Caller: caller, Provider: provider}
+				rows[key] = row
+			}
+			row.Calls++
+			switch record.Outcome {
+			case ResultStatusValid:
+				row.Valid++
+			case ResultStatusInvalid:
+				row.Invalid++
+			default:
+				row.Errors++
+			}
+			durations[key] = append(durations[key], record.DurationMs)
+		}
+	}
+	report := make([]usageRow, 0, len(rows))
+	for key, row := range rows {
+		sorted := durations[key]
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		row.P50Ms, row.P95Ms, row.P99Ms = percentileMs(sorted, 50), percentileMs(sorted, 95), percentileMs(sorted, 99)
+		row.Cost = float64(row.Calls) * unitCosts[row.Provider]
+		report = append(report, *row)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Caller != report[j].Caller {
+			return report[i].Caller < report[j].Caller
+		}
+		return report[i].Provider < report[j].Provider
+	})
+	return report
+}
+
+func usageCSV(rows []usageRow) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	writer.Write([]string{"date", "caller", "provider", "calls", "valid", "invalid", "errors", "validRate", "p50Ms", "p95Ms", "p99Ms", "cost"})
+	for _, row := range rows {
+		validRate := 0.0
+		if answered := row.Valid + row.Invalid; answered > 0 {
+			validRate = float64(row.Valid) / float64(answered)
+		}
+		writer.Write([]string{
+			row.Date, row.Caller, row.Provider,
+			strconv.Itoa(row.Calls), strconv.Itoa(row.Valid), strconv.Itoa(row.Invalid), strconv.Itoa(row.Errors),
+			strconv.FormatFloat(validRate, 'f', 4, 64),
+			strconv.FormatInt(row.P50Ms, 10), strconv.FormatInt(row.P95Ms, 10), strconv.FormatInt(row.P99Ms, 10),
+			strconv.FormatFloat(row.Cost, 'f', 4, 64),
+		})
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+func (config *Config) unitCosts() map[string]float64 {
+	costs := map[string]float64{}
+	for _, provider := range config.Providers {
+		costs[provider.Name] = provider.UnitCost
+	}
+	return costs
+}
+
+// Builds the report for the day before the event and writes it to S3
+func (config *Config) writeUsageReport(ctx context.Context, audit auditScanAPI, table string, objects *s3Client, eventTime time.Time) error {
+	location, err := parseS3Location(config.UsageReport.Location)
+	if err != nil {
+		return err
+	}
+	to := time.Date(eventTime.Year(), eventTime.Month(), eventTime.Day(), 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, 0, -1)
+	records, err := scanAudit(ctx, audit, table, from, to)
+	if err != nil {
+		return fmt.Errorf("unable to read the audit table: %w", err)
+	}
+	date := from.Format("2006-01-02")
+	report, err := usageCSV(aggregateUsage(date, records, config.unitCosts()))
+	if err != nil {
+		return err
+	}
+	location.Key = strings.TrimSuffix(location.Key, "/") + "/" + date + ".csv"
+	return objects.putObject(ctx, location, report, "text/csv")
+}
+
+// Lambda handler for the daily schedule
+func (config *Config) UsageReportHandler(ctx context.Context, event events.CloudWatchEvent) error {
+	table, exists := os.LookupEnv("AUDIT_TABLE")
+	if !exists || config.UsageReport.Location == "" {
+		return errors.New("ENVVAR AUDIT_TABLE and usageReport.location are required for the usage report")
+	}
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		return err
+	}
+	objects, err := newS3Client(ctx)
+	if err != nil {
+		return err
+	}
+	return config.writeUsageReport(ctx, dynamodb.NewFromConfig(cfg), table, objects, event.Time.UTC())
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Hands out the items a page at a time
+type fakeAuditScanner struct {
+	pages [][]map[string]types.AttributeValue
+	calls int
+}
+
+func (db *fakeAuditScanner) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	output := &dynamodb.ScanOutput{Items: db.pages[db.calls]}
+	db.calls++
+	if db.calls < len(db.pages) {
+		output.LastEvaluatedKey = map[string]types.AttributeValue{"page": &types.AttributeValueMemberN{Value: strconv.Itoa(db.calls)}}
+	}
+	return output, nil
+}
+
+func auditItem(caller, outcome string, durationMs int, providers ...string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"validatedAt": &types.AttributeValueMemberS{Value: "2024-06-01T10:00:00Z"},
+		"outcome":     &types.AttributeValueMemberS{Value: outcome},
+		"durationMs":  &types.AttributeValueMemberN{Value: strconv.Itoa(durationMs)},
+		"testAccount": &types.AttributeValueMemberBOOL{Value: false},
+		"providers":   &types.AttributeValueMemberSS{Value: providers},
+		"caller":      &types.AttributeValueMemberS{Value: caller},
+	}
+}
+
+func Test_aggregateUsage(t *testing.T) {
+	records := []AuditRecord{
+		{Caller: "client-42", Outcome: ResultStatusValid, DurationMs: 100, Providers: []string{"provider1", "provider2"}},
+		{Caller: "client-42", Outcome: ResultStatusInvalid, DurationMs: 300, Providers: []string{"provider1"}},
+		{Caller: "client-42", Outcome: ResultStatusError, DurationMs: 900, Providers: []string{"provider1"}},
+		{Outcome: ResultStatusValid, DurationMs: 50, Providers: []string{"provider1"}},
+		{Caller: "client-42", Outcome: ResultStatusValid, TestAccount: true, Providers: []string{}},
+	}
+	got := aggregateUsage("2024-06-01", records, map[string]float64{"provider1": 0.5})
+	want := []usageRow{
+		{Date: "2024-06-01", Caller: "anonymous", Provider: "provider1", Calls: 1, Valid: 1, P50Ms: 50, P95Ms: 50, P99Ms: 50, Cost: 0.5},
+		{Date: "2024-06-01", Caller: "client-42", Provider: "provider1", Calls: 3, Valid: 1, Invalid: 1, Errors: 1, P50Ms: 300, P95Ms: 900, P99Ms: 900, Cost: 1.5},
+		{Date: "2024-06-01", Caller: "client-42", Provider: "provider2", Calls: 1, Valid: 1, P50Ms: 100, P95Ms: 100, P99Ms: 100},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("aggregateUsage() = %+v, want %+v", got, want)
+	}
+}
+
+func TestConfig_writeUsageReport(t *testing.T) {
+	var uploaded, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.Header.Get("Content-Type") != "text/csv" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		uploaded, path = string(body), r.URL.Path
+	}))
+	defer server.Close()
+	objects := testS3Client(server.URL)
+	audit := &fakeAuditScanner{pages: [][]map[string]types.AttributeValue{
+		{auditItem("client-42", ResultStatusValid, 120, "provider1")},
+		{auditItem("client-42", ResultStatusInvalid, 80, "provider1")},
+	}}
+	config := &Config{
+		Providers:   []Provider{{Name: "provider1", UnitCost: 0.5}},
+		UsageReport: UsageReportConfig{Location: "s3://accountvalidator-data/usage/"},
+	}
+
+	if err := config.writeUsageReport(context.Background(), audit, "audit", objects, time.Date(2024, 6, 2, 0, 15, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	want := "date,caller,provider,calls,valid,invalid,errors,validRate,p50Ms,p95Ms,p99Ms,cost\n" +
+		"2024-06-01,client-42,provider1,2,1,1,0,0.5000,80,120,120,1.0000\n"
+	if uploaded != want || path != "/accountvalidator-data/usage/2024-06-01.csv" || audit.calls != 2 {
+		t.Errorf("uploaded %q to %s after %d scans, want %q", uploaded, path, audit.calls, want)
+	}
+}
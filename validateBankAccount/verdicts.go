@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+/*
+  Verdict table (VERDICT_TABLE), the last verdict we gave for each account. When a new verdict differs from the
+  stored one the response says what it was and when it changed, and an AccountValidityChanged event goes to the
+  EventBridge bus in EVENT_BUS_NAME so downstream systems can react to an account going bad:
+
+    {"result": [...], "previousResult": "valid", "changedAt": "2023-01-01T12:00:00Z"}
+
+  What the verdict is depends on who was asked and how their answers were combined, so there's one item per account,
+  strategy (any when the request doesn't have one) and set of providers called. Otherwise a caller asking one provider
+  after another asked a different one would see a change that's only a difference of opinion. The key is the account
+  hash (see accounthash.go), so the table never holds raw numbers, with the strategy and a hash of the provider names:
+
+    accountHash (S, hash key, <account hash>#<strategy>#<providers hash>) | result (S, valid or invalid) |
+      changedAt (S, RFC3339)
+
+  Nobody answering isn't a verdict and test accounts are never recorded. The lookup runs alongside
+  the provider calls, and a failing table or bus is logged without failing the validation.
+*/
+
+const AccountValidityChanged = "AccountValidityChanged"
+
+type Verdict struct {
+	Result    string
+	ChangedAt time.Time
+}
+
+// What a verdict is kept under, the account and how it was validated
+type VerdictKey struct {
+	AccountHash string
+	Strategy    string
+	Providers   []string
+}
+
+func verdictKey(accountHash string, strategy *string, providers []Provider) VerdictKey {
+	key := VerdictKey{AccountHash: accountHash, Strategy: StrategyAny, Providers: make([]string, len(providers))}
+	if strategy != nil {
+		key.Strategy = *strategy
+	}
+	for i, provider := range providers {
+		key.Providers[i] = provider.Name
+	}
+	sort.Strings(key.Providers)
+	return key
+}
+
+// The table's key, the provider names are hashed so that a long list doesn't go over DynamoDB's key size
+func (key VerdictKey) String() string {
+	hash := sha256.Sum256([]byte(strings.Join(key.Providers, "\x00")))
+	return key.AccountHash + "#" + key.Strategy + "#" + hex.EncodeToString(hash[:8])
+}
+
+// Detail of the AccountValidityChanged event
+type AccountValidityChangedEvent struct {
+	AccountHash       string    `json:"accountHash"`
+	Strategy          string    `json:"strategy"`
+	Providers         []string  `json:"providers"`
+	Result            string    `json:"result"`
+	PreviousResult    string    `json:"previousResult"`
+	ChangedAt         time.Time `json:"changedAt"`
+	PreviousChangedAt time.Time `json:"previousChangedAt"`
+}
+
+// Someone else recorded a different verdict since we looked
+var errVerdictConflict = errors.New("verdict changed since it was read")
+
+type VerdictStore interface {
+	// Keyed on VerdictKey.String
+	Verdict(ctx context.Context, key string) (*Verdict, error)
+	// Replaces previous, which is nil when there wasn't one. Fails with errVerdictConflict if it has moved on.
+	PutVerdict(ctx context.Context, key string, verdict Verdict, previous *Verdict) error
+}
+
+type dynamoVerdictAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+type dynamoVerdictStore struct {
+	client dynamoVerdictAPI
+	table  string
+}
+
+func newDynamoVerdictStore(ctx context.Context, table string) (*dynamoVerdictStore, error) {
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamoVerdictStore{client: dynamodb.NewFromConfig(cfg), table: table}, nil
+}
+
+func (store *dynamoVerdictStore) Verdict(ctx context.Context, key string) (*Verdict, error) {
+	output, err := store.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(store.table),
+		Key:       map[string]types.AttributeValue{"accountHash": &types.AttributeValueMemberS{Value: key}},
+	})
+	if err != nil || output.Item == nil {
+		return nil, err
+	}
+	verdict := &Verdict{}
+	if result, ok := output.Item["result"].(*types.AttributeValueMemberS); ok {
+		verdict.Result = result.Value
+	}
+	if changedAt, ok := output.Item["changedAt"].(*types.AttributeValueMemberS); ok {
+		verdict.ChangedAt, _ = time.Parse(time.RFC3339, changedAt.Value)
+	}
+	return verdict, nil
+}
+
+// The condition stops two containers that saw the same change both announcing it
+func (store *dynamoVerdictStore) PutVerdict(ctx context.Context, key string, verdict Verdict, previous *Verdict) error {
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(store.table),
+		Item: map[string]types.AttributeValue{
+			"accountHash": &types.AttributeValueMemberS{Value: key},
+			"result":      &types.AttributeValueMemberS{Value: verdict.Result},
+			"changedAt":   &types.AttributeValueMemberS{Value: verdict.ChangedAt.UTC().Format(time.RFC3339)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(accountHash)"),
+	}
+	if previous != nil {
+		input.ConditionExpression = aws.String("#result = :previous")
+		input.ExpressionAttributeNames = map[string]string{"#result": "result"}
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{":previous": &types.AttributeValueMemberS{Value: previous.Result}}
+	}
+	_, err := store.client.PutItem(ctx, input)
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return errVerdictConflict
+	}
+	return err
+}
+
+// Puts events on a bus
+type eventPublisher interface {
+	PutEvent(ctx context.Context, detailType string, detail interface{}) error
+}
+
+// The most entries one PutEvents request can hold
+const maxEventBridgeEntries = 10
+
+// PutEvents through signedAWSClient, see aws.go
+type eventBridgeBus struct {
+	api      *signedAWSClient
+	endpoint string
+	busName  string
+}
+
+func newEventBridgeBus(ctx context.Context, busName string) (*eventBridgeBus, error) {
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &eventBridgeBus{
+		api:      newSignedAWSClient(cfg, "events", 2*time.Second),
+		endpoint: "https://events." + cfg.Region + ".amazonaws.com",
+		busName:  busName,
+	}, nil
+}
+
+func (bus *eventBridgeBus) PutEvent(ctx context.Context, detailType string, detail interface{}) error {
+	return bus.PutEvents(ctx, detailType, []interface{}{detail})
+}
+
+// Puts events of one type on the bus, as many to a request as PutEvents allows
+func (bus *eventBridgeBus) PutEvents(ctx context.Context, detailType string, details []interface{}) error {
+	for start := 0; start < len(details); start += maxEventBridgeEntries {
+		end := start + maxEventBridgeEntries
+		if end > len(details) {
+			end = len(details)
+		}
+		if err := bus.putEntries(ctx, detailType, details[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bus *eventBridgeBus) putEntries(ctx context.Context, detailType string, details []interface{}) error {
+	entries := make([]map[string]string, 0, len(details))
+	for _, detail := range details {
+		detailJSON, err := marshalJSON(detail)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, map[string]string{
+			"EventBusName": bus.busName,
+			"Source":       "accountvalidator",
+			"DetailType":   detailType,
+			"Detail":       string(detailJSON),
+		})
+	}
+	var output struct {
+		FailedEntryCount int
+		Entries          []struct {
+			ErrorCode    string
+			ErrorMessage string
+		}
+	}
+	if err := bus.api.callJSON(ctx, bus.endpoint, "AWSEvents.PutEvents", "1.1", map[string]interface{}{"Entries": entries}, &output); err != nil {
+		return fmt.Errorf("putting %s event failed: %w", detailType, err)
+	}
+	// A 200 can still have failed entries
+	if output.FailedEntryCount > 0 {
+		for _, entry := range output.Entries {
+			if entry.ErrorCode != "" {
+				return fmt.Errorf("putting %s event failed: %s %s", detailType, entry.ErrorCode, entry.ErrorMessage)
+			}
+		}
+		return fmt.Errorf("putting %s event failed", detailType)
+	}
+	return nil
+}
+
+// Starts looking up the stored verdict, the returned func waits for it
+func (config *Config) lookupVerdict(ctx context.Context, key string) func() (*Verdict, error) {
+	type lookup struct {
+		verdict *Verdict
+		err     error
+	}
+	done := make(chan lookup, 1)
+	go func() {
+		verdict, err := config.verdicts.Verdict(ctx, key)
+		done <- lookup{verdict, err}
+	}()
+	return func() (*Verdict, error) {
+		result := <-done
+		return result.verdict, result.err
+	}
+}
+
+// Records the new verdict if it changed, and when it changed from an earlier one says so in the response and on the bus
+func (config *Config) diffVerdict(ctx context.Context, key VerdictKey, previous *Verdict, result string, response *BankAccountValidationResponse, now time.Time) {
+	if result == ResultStatusError || (previous != nil && previous.Result == result) {
+		return
+	}
+	verdict := Verdict{Result: result, ChangedAt: now.UTC().Truncate(time.Second)}
+	if err := config.verdicts.PutVerdict(ctx, key.String(), verdict, previous); err != nil {
+		log.Printf("unable to record verdict: %v", err)
+		return
+	}
+	if previous == nil {
+		return
+	}
+	response.PreviousResult = previous.Result
+	response.ChangedAt = &verdict.ChangedAt
+	config.emit(ctx, AccountValidityChanged, AccountValidityChangedEvent{
+		AccountHash:       key.AccountHash,
+		Strategy:          key.Strategy,
+		Providers:         key.Providers,
+		Result:            result,
+		PreviousResult:    previous.Result,
+		ChangedAt:         verdict.ChangedAt,
+		PreviousChangedAt: previous.ChangedAt,
+	})
+}
+
+// Connects to the verdict table and event bus if there are any
+func (config *Config) setupVerdicts(ctx context.Context) {
+	if table, exists := os.LookupEnv("VERDICT_TABLE"); exists {
+		store, err := newDynamoVerdictStore(ctx, table)
+		if err != nil {
+			log.Print(err)
+		} else {
+			config.verdicts = store
+		}
+	}
+	if busName, exists := os.LookupEnv("EVENT_BUS_NAME"); exists {
+		bus, err := newEventBridgeBus(ctx, busName)
+		if err != nil {
+			log.Print(err)
+		} else {
+			config.events = bus
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type fakeVerdicts struct {
+	mu       sync.Mutex
+	verdicts map[string]Verdict
+}
+
+func (store *fakeVerdicts) Verdict(ctx context.Context, accountHash string) (*Verdict, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if verdict, exists := store.verdicts[accountHash]; exists {
+		return &verdict, nil
+	}
+	return nil, nil
+}
+
+func (store *fakeVerdicts) PutVerdict(ctx context.Context, accountHash string, verdict Verdict, previous *Verdict) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	current, exists := store.verdicts[accountHash]
+	if exists != (previous != nil) || (exists && current.Result != previous.Result) {
+		return errVerdictConflict
+	}
+	store.verdicts[accountHash] = verdict
+	return nil
+}
+
+type fakeEvents struct {
+	events []AccountValidityChangedEvent
+}
+
+func (bus *fakeEvents) PutEvent(ctx context.Context, detailType string, detail interface{}) error {
+	bus.events = append(bus.events, detail.(AccountValidityChangedEvent))
+	return nil
+}
+
+func TestConfig_validate_verdicts(t *testing.T) {
+	earlier := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	keyFor := func(accountNumber string) string {
+		return verdictKey(accountHash(accountNumber), nil, []Provider{{Name: "provider1"}}).String()
+	}
+	store := &fakeVerdicts{verdicts: map[string]Verdict{
+		keyFor("12345670"): {Result: ResultStatusInvalid, ChangedAt: earlier},
+	}}
+	bus := &fakeEvents{}
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		verdicts:  store,
+		events:    bus,
+	}
+	validate := func(accountNumber string) BankAccountValidationResponse {
+		return config.validate(context.Background(), Request{}, &BankAccountValidationRequest{AccountNumber: &accountNumber}, nil)
+	}
+
+	// Was invalid, now valid
+	response := validate("12345670")
+	if response.PreviousResult != ResultStatusInvalid || response.ChangedAt == nil {
+		t.Fatalf("expected the change in the response, got %+v", response)
+	}
+	want := []AccountValidityChangedEvent{{
+		AccountHash:       accountHash("12345670"),
+		Strategy:          StrategyAny,
+		Providers:         []string{"provider1"},
+		Result:            ResultStatusValid,
+		PreviousResult:    ResultStatusInvalid,
+		ChangedAt:         *response.ChangedAt,
+		PreviousChangedAt: earlier,
+	}}
+	if !reflect.DeepEqual(bus.events, want) {
+		t.Errorf("events = %+v, want %+v", bus.events, want)
+	}
+	if store.verdicts[keyFor("12345670")].Result != ResultStatusValid {
+		t.Errorf("the new verdict wasn't recorded")
+	}
+
+	// Same again isn't a change
+	if response := validate("12345670"); response.PreviousResult != "" || response.ChangedAt != nil || len(bus.events) != 1 {
+		t.Errorf("an unchanged verdict shouldn't be reported, got %+v", response)
+	}
+
+	// First sighting is recorded but isn't a change
+	if response := validate("12345671"); response.PreviousResult != "" || len(bus.events) != 1 {
+		t.Errorf("a first verdict shouldn't be reported, got %+v", response)
+	}
+	if store.verdicts[keyFor("12345671")].Result != ResultStatusInvalid {
+		t.Errorf("the first verdict wasn't recorded")
+	}
+
+	// Nobody answering isn't a verdict
+	validate("12349999")
+	if _, exists := store.verdicts[keyFor("12349999")]; exists {
+		t.Errorf("an errored validation shouldn't be recorded")
+	}
+}
+
+func TestConfig_validate_verdictsPerProviders(t *testing.T) {
+	store := &fakeVerdicts{verdicts: map[string]Verdict{}}
+	bus := &fakeEvents{}
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}, {Name: "down", URL: "http://127.0.0.1:1"}},
+		verdicts:  store,
+		events:    bus,
+	}
+	validate := func(strategy string, providers ...string) BankAccountValidationResponse {
+		accountNumber := "12345670"
+		return config.validate(context.Background(), Request{}, &BankAccountValidationRequest{AccountNumber: &accountNumber, Strategy: &strategy, Providers: &providers}, nil)
+	}
+
+	// Asking another way is a verdict of its own, not a change to this one
+	validate(StrategyAll, "provider1")
+	if response := validate(StrategyAny, "provider1", "down"); response.PreviousResult != "" || len(bus.events) != 0 {
+		t.Errorf("another strategy and providers shouldn't be a change, got %+v and %+v", response, bus.events)
+	}
+	if len(store.verdicts) != 2 {
+		t.Errorf("verdicts = %+v, want one for each way of asking", store.verdicts)
+	}
+}
+
+func Test_verdictKey(t *testing.T) {
+	all := StrategyAll
+	base := verdictKey("hash", nil, []Provider{{Name: "b"}, {Name: "a"}})
+	if base.String() != verdictKey("hash", nil, []Provider{{Name: "a"}, {Name: "b"}}).String() {
+		t.Error("the order providers are called in shouldn't change the key")
+	}
+	if !strings.HasPrefix(base.String(), "hash#any#") {
+		t.Errorf("String() = %s, want the account hash and strategy first", base)
+	}
+	for name, key := range map[string]VerdictKey{
+		"account":   verdictKey("other", nil, []Provider{{Name: "a"}, {Name: "b"}}),
+		"strategy":  verdictKey("hash", &all, []Provider{{Name: "a"}, {Name: "b"}}),
+		"providers": verdictKey("hash", nil, []Provider{{Name: "a"}}),
+	} {
+		if key.String() == base.String() {
+			t.Errorf("changing the %s should change the key", name)
+		}
+	}
+}
+
+func TestConfig_diffVerdict_conflict(t *testing.T) {
+	store := &fakeVerdicts{verdicts: map[string]Verdict{VerdictKey{AccountHash: "hash", Strategy: StrategyAny}.String(): {Result: ResultStatusValid}}}
+	bus := &fakeEvents{}
+	config := &Config{verdicts: store, events: bus}
+	response := BankAccountValidationResponse{}
+
+	// We read invalid but someone has since recorded valid
+	config.diffVerdict(context.Background(), VerdictKey{AccountHash: "hash", Strategy: StrategyAny}, &Verdict{Result: ResultStatusInvalid}, ResultStatusValid, &response, time.Now())
+	if response.PreviousResult != "" || len(bus.events) != 0 {
+		t.Errorf("a conflicting write shouldn't be announced, got %+v and %+v", response, bus.events)
+	}
+}
+
+// Just enough DynamoDB for one item per key and the conditions the verdict store uses
+type fakeVerdictDynamoDB struct {
+	items map[string]map[string]types.AttributeValue
+}
+
+func (db *fakeVerdictDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	key := params.Key["accountHash"].(*types.AttributeValueMemberS).Value
+	return &dynamodb.GetItemOutput{Item: db.items[key]}, nil
+}
+
+func (db *fakeVerdictDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	key := params.Item["accountHash"].(*types.AttributeValueMemberS).Value
+	current, exists := db.items[key]
+	if previous, conditional := params.ExpressionAttributeValues[":previous"]; conditional {
+		if !exists || current["result"].(*types.AttributeValueMemberS).Value != previous.(*types.AttributeValueMemberS).Value {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	} else if exists {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	db.items[key] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func Test_dynamoVerdictStore(t *testing.T) {
+	store := &dynamoVerdictStore{client: &fakeVerdictDynamoDB{items: map[string]map[string]types.AttributeValue{}}, table: "verdicts"}
+	ctx := context.Background()
+	if verdict, err := store.Verdict(ctx, "hash"); verdict != nil || err != nil {
+		t.Fatalf("Verdict() = %v, %v, want nothing", verdict, err)
+	}
+
+	first := Verdict{Result: ResultStatusValid, ChangedAt: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)}
+	if err := store.PutVerdict(ctx, "hash", first, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutVerdict(ctx, "hash", first, nil); err != errVerdictConflict {
+		t.Errorf("a second first verdict should conflict, got %v", err)
+	}
+	if verdict, err := store.Verdict(ctx, "hash"); err != nil || !reflect.DeepEqual(*verdict, first) {
+		t.Errorf("Verdict() = %v, %v, want %v", verdict, err, first)
+	}
+
+	second := Verdict{Result: ResultStatusInvalid, ChangedAt: time.Date(2023, 1, 2, 12, 0, 0, 0, time.UTC)}
+	if err := store.PutVerdict(ctx, "hash", second, &Verdict{Result: ResultStatusInvalid}); err != errVerdictConflict {
+		t.Errorf("replacing the wrong verdict should conflict, got %v", err)
+	}
+	if err := store.PutVerdict(ctx, "hash", second, &first); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_eventBridgeBus_PutEvent(t *testing.T) {
+	var got *http.Request
+	var body map[string][]map[string]string
+	requests := 0
+	reply := `{"FailedEntryCount": 0, "Entries": [{"EventId": "1"}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		requests++
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		w.Write([]byte(reply))
+	}))
+	defer server.Close()
+
+	bus := &eventBridgeBus{api: testAWSClient("events"), endpoint: server.URL, busName: "default"}
+	if err := bus.PutEvent(context.Background(), AccountValidityChanged, map[string]string{"result": "valid"}); err != nil {
+		t.Fatal(err)
+	}
+	if target := got.Header.Get("X-Amz-Target"); target != "AWSEvents.PutEvents" {
+		t.Errorf("X-Amz-Target = %v", target)
+	}
+	if auth := got.Header.Get("Authorization"); !strings.Contains(auth, "eu-west-1/events/aws4_request") {
+		t.Errorf("Authorization = %v, want a SigV4 signature for events", auth)
+	}
+	want := map[string]string{"EventBusName": "default", "Source": "accountvalidator", "DetailType": AccountValidityChanged, "Detail": "{\"result\":\"valid\"}"}
+	if len(body["Entries"]) != 1 || !reflect.DeepEqual(body["Entries"][0], want) {
+		t.Errorf("entries = %v, want %v", body["Entries"], want)
+	}
+
+	reply = `{"FailedEntryCount": 1, "Entries": [{"ErrorCode": "InternalFailure", "ErrorMessage": "try again"}]}`
+	if err := bus.PutEvent(context.Background(), AccountValidityChanged, map[string]string{}); err == nil || !strings.Contains(err.Error(), "InternalFailure") {
+		t.Errorf("expected the failed entry as an error, got %v", err)
+	}
+
+	// PutEvents takes ten at a time
+	reply, requests = `{"FailedEntryCount": 0}`, 0
+	if err := bus.PutEvents(context.Background(), BillingEventDetailType, make([]interface{}, 12)); err != nil || requests != 2 || len(body["Entries"]) != 2 {
+		t.Errorf("PutEvents() = %v after %d requests, the last with %d entries", err, requests, len(body["Entries"]))
+	}
+}
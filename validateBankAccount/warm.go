@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+  Warm path. Provisioned concurrency containers are initialised ahead of traffic, and scheduled warmers keep on demand
+  containers alive, so both are a chance to get the slow parts of a first request out of the way without doing one:
+
+    - secrets are fetched, or rechecked if they're stale
+    - provider hostnames are resolved and a TLS session is established with every endpoint, so the first real call
+      resumes it instead of paying for a full handshake
+    - the breakers are reseeded from the provider status table
+
+  Config itself comes from the environment, which can't change under a running container. A warm invocation never
+  reaches the providers or the verdict table, so it doesn't count against provider quotas or show up anywhere a real
+  validation would. An invocation is a warmer when its payload has "warmer": true or comes from
+  serverless-plugin-warmup, and provisioned concurrency is spotted from AWS_LAMBDA_INITIALIZATION_TYPE at init.
+*/
+
+const (
+	warmTimeout       = 3 * time.Second
+	warmerPluginEvent = "serverless-plugin-warmup"
+)
+
+// What the Lambda entry point is invoked with, an API Gateway request or a warmer's payload
+type LambdaEvent struct {
+	Request
+	Source string `json:"source"`
+	Warmer bool   `json:"warmer"`
+}
+
+func (event LambdaEvent) isWarmer() bool {
+	return event.Warmer || event.Source == warmerPluginEvent
+}
+
+func provisionedConcurrency() bool {
+	return os.Getenv("AWS_LAMBDA_INITIALIZATION_TYPE") == "provisioned-concurrency"
+}
+
+// Lambda entry point for the API, answering warmers without going near the router
+func (config *Config) LambdaHandler(ctx context.Context, event LambdaEvent) (Response, error) {
+	if event.isWarmer() {
+		// Nobody was waiting on it
+		containerInit.takeColdStart()
+		config.warm(ctx)
+		return jsonResponse(200, map[string]bool{"warmed": true})
+	}
+	return config.Router(ctx, event.Request)
+}
+
+// Does everything on the warm path in parallel, giving up after warmTimeout
+func (config *Config) warm(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, warmTimeout)
+	defer cancel()
+	start := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		if providerSecrets != nil {
+			providerSecrets.warm(ctx, config.secretIDs())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		config.preconnect(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		if err := config.seedBreakers(ctx); err != nil {
+			log.Printf("unable to seed circuit breakers: %v", err)
+		}
+	}()
+	wg.Wait()
+	log.Printf("warmed in %s", time.Since(start))
+}
+
+// Establishes a TLS session with every provider endpoint
+func (config *Config) preconnect(ctx context.Context) {
+	transport, ok := providerTransport.(*http.Transport)
+	if !ok || transport.DialContext == nil {
+		return
+	}
+	seen := map[string]bool{}
+	var wg sync.WaitGroup
+	for _, provider := range config.Providers {
+		for _, raw := range provider.endpointURLs() {
+			parsed, err := url.Parse(raw)
+			if err != nil || parsed.Scheme != "https" || seen[parsed.Host] {
+				continue
+			}
+			seen[parsed.Host] = true
+			wg.Add(1)
+			go func(target *url.URL) {
+				defer wg.Done()
+				if err := handshake(ctx, transport, target); err != nil {
+					log.Printf("unable to preconnect to %s: %v", target.Host, err)
+				}
+			}(parsed)
+		}
+	}
+	wg.Wait()
+}
+
+func handshake(ctx context.Context, transport *http.Transport, target *url.URL) error {
+	port := target.Port()
+	if port == "" {
+		port = "443"
+	}
+	conn, err := transport.DialContext(ctx, "tcp", net.JoinHostPort(target.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tlsConfig := transport.TLSClientConfig.Clone()
+	tlsConfig.ServerName = target.Hostname()
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	// TLS 1.3 session tickets arrive after the handshake, so give the server a moment to send one
+	tlsConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	tlsConn.Read(make([]byte, 1))
+	return nil
+}
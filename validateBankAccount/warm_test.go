@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLambdaEvent_isWarmer(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    bool
+	}{
+		{name: "warmer", payload: "{\"warmer\": true}", want: true},
+		{name: "plugin", payload: "{\"source\": \"serverless-plugin-warmup\"}", want: true},
+		{name: "apiGateway", payload: "{\"httpMethod\": \"POST\", \"path\": \"/application\", \"body\": \"{}\"}", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var event LambdaEvent
+			if err := json.Unmarshal([]byte(tt.payload), &event); err != nil {
+				t.Fatal(err)
+			}
+			if got := event.isWarmer(); got != tt.want {
+				t.Errorf("isWarmer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_LambdaHandler_routes(t *testing.T) {
+	config := &Config{Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}}}
+	event := LambdaEvent{Request: Request{HTTPMethod: "POST", Path: "/application", Body: "{\"accountNumber\": \"12345670\"}"}}
+	response, err := config.LambdaHandler(context.Background(), event)
+	if err != nil || response.Body != "{\"result\":[{\"provider\":\"provider1\",\"isValid\":true}]}" {
+		t.Errorf("LambdaHandler() = %v, %v", response, err)
+	}
+}
+
+type recordingSessionCache struct {
+	mu   sync.Mutex
+	puts int
+	tls.ClientSessionCache
+}
+
+func (cache *recordingSessionCache) Put(key string, session *tls.ClientSessionState) {
+	cache.mu.Lock()
+	cache.puts++
+	cache.mu.Unlock()
+	cache.ClientSessionCache.Put(key, session)
+}
+
+// A warmer sets up a TLS session with the provider but never calls it
+func TestConfig_LambdaHandler_warmer(t *testing.T) {
+	var calls int32
+	server := newTLSProvider(t, nil)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	})
+	defer server.Close()
+
+	sessions := &recordingSessionCache{ClientSessionCache: tls.NewLRUClientSessionCache(0)}
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.ClientSessionCache = sessions
+	cache, _ := newTestDNSCache(&fakeResolver{answers: map[string][]string{"example.com": {"127.0.0.1"}}})
+	providerTransport = &http.Transport{TLSClientConfig: tlsConfig, DialContext: cache.dialContext(&net.Dialer{})}
+	defer func() { providerTransport = http.DefaultTransport }()
+
+	config := &Config{Providers: []Provider{{Name: "provider1", URL: testProviderURL(server) + "/validate"}}}
+	response, err := config.LambdaHandler(context.Background(), LambdaEvent{Warmer: true})
+	if err != nil || response.StatusCode != 200 || response.Body != "{\"warmed\":true}" {
+		t.Errorf("LambdaHandler() = %v, %v", response, err)
+	}
+	if calls != 0 {
+		t.Errorf("a warmer shouldn't call the provider, got %d calls", calls)
+	}
+	if sessions.puts == 0 {
+		t.Errorf("expected a TLS session with the provider")
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+  Validation webhooks. Downstream systems that want every outcome can subscribe instead of polling the audit table:
+
+    webhooks:
+      - name: ledger
+        url: https://ledger.example.com/hooks/validations
+        events: [invalid, changed]   # valid, invalid, error and changed (the verdict changed), everything when empty
+        maxAttempts: 5               # default 5
+        signing:                     # see signing.go, keys rotate the same way
+          primary:
+            id: ledger-2024
+            secretId: accountvalidator/webhooks
+            secretKey: ledger
+
+  Every validation whose outcome a subscriber wants is POSTed to it as
+
+    {"id": "0b6f3c52-...", "outcome": "invalid", "changed": true, "accountHash": "...",
+     "clientReference": "...", "requestId": "c6af9ac6-...", "validatedAt": "2024-06-01T09:00:00Z", "response": {...}}
+
+  signed the way we sign requests to providers, and with the id in X-Webhook-Id. The id is the same on every attempt
+  so a subscriber can tell a retry from a new delivery.
+
+  Nothing is sent while the caller waits. Deliveries are queued in memory and sent when the telemetry flushes, after
+  the invocation. A subscriber that doesn't answer with a 2xx is tried again at the first flush after a backoff
+  (1s, 2s, 4s, ... up to 5 minutes), and once maxAttempts have failed the delivery goes to the SQS queue in
+  WEBHOOK_DLQ_URL with the last error, or is logged and dropped without one. Deliveries still queued when a container
+  is shut down are lost, unless they go through the outbox (see outbox.go).
+*/
+
+const (
+	defaultWebhookAttempts = 5
+	webhookBackoff         = time.Second
+	maxWebhookBackoff      = 5 * time.Minute
+	webhookTimeout         = time.Second
+	// Past this new deliveries are dropped until the queue drains
+	maxQueuedWebhooks   = 10000
+	WebhookEventChanged = "changed"
+)
+
+type WebhookConfig struct {
+	Name        string         `yaml:"name"`
+	URL         string         `yaml:"url"`
+	Events      []string       `yaml:"events"`
+	MaxAttempts int            `yaml:"maxAttempts"`
+	Signing     *SigningConfig `yaml:"signing"`
+}
+
+type WebhookPayload struct {
+	ID              string                        `json:"id"`
+	Outcome         string                        `json:"outcome"`
+	Changed         bool                          `json:"changed,omitempty"`
+	AccountHash     string                        `json:"accountHash"`
+	ClientReference string                        `json:"clientReference,omitempty"`
+	RequestID       string                        `json:"requestId,omitempty"`
+	ValidatedAt     time.Time                     `json:"validatedAt"`
+	Response        BankAccountValidationResponse `json:"response"`
+}
+
+func validateWebhooks(webhooks []*WebhookConfig) error {
+	names := map[string]bool{}
+	for _, webhook := range webhooks {
+		if webhook == nil || webhook.Name == "" {
+			return errors.New("webhook is missing a name")
+		}
+		if names[webhook.Name] {
+			return fmt.Errorf("webhook %s is defined twice", webhook.Name)
+		}
+		names[webhook.Name] = true
+		if parsed, err := url.Parse(webhook.URL); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("webhook %s needs an https url", webhook.Name)
+		}
+		for _, event := range webhook.Events {
+			switch event {
+			case ResultStatusValid, ResultStatusInvalid, ResultStatusError, WebhookEventChanged:
+			default:
+				return fmt.Errorf("webhook %s has an unknown event %s", webhook.Name, event)
+			}
+		}
+		if webhook.MaxAttempts < 0 {
+			return fmt.Errorf("webhook %s maxAttempts can't be negative", webhook.Name)
+		}
+		if webhook.Signing == nil {
+			return fmt.Errorf("webhook %s needs signing keys", webhook.Name)
+		}
+		if err := webhook.Signing.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether the subscriber wants the outcome
+func (webhook *WebhookConfig) wants(outcome string, changed bool) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, event := range webhook.Events {
+		if event == outcome || (event == WebhookEventChanged && changed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (webhook *WebhookConfig) maxAttempts() int {
+	if webhook.MaxAttempts == 0 {
+		return defaultWebhookAttempts
+	}
+	return webhook.MaxAttempts
+}
+
+type webhookDelivery struct {
+	webhook  *WebhookConfig
+	id       string
+	body     []byte
+	attempts int
+	due      time.Time
+	lastErr  string
+}
+
+// Where deliveries go once they've run out of attempts
+type deadLetterQueue interface {
+	SendMessage(ctx context.Context, queueURL, body string) error
+}
+
+type webhookDispatcher struct {
+	mu     sync.Mutex
+	queue  []*webhookDelivery
+	client *http.Client
+	dlq    deadLetterQueue
+	dlqURL string
+	now    func() time.Time
+}
+
+func newWebhookDispatcher() *webhookDispatcher {
+	return &webhookDispatcher{client: &http.Client{Timeout: webhookTimeout}, now: time.Now}
+}
+
+// Queues the validation for every subscriber that wants it, or puts it in the outbox when the validation has one. A
+// nil webhookDispatcher sends nothing.
+func (config *Config) queueWebhooks(ctx context.Context, request Request, validationRequest *BankAccountValidationRequest, response BankAccountValidationResponse) {
+	dispatcher := config.webhookDispatcher
+	if dispatcher == nil {
+		return
+	}
+	payload := WebhookPayload{
+		Outcome:     outcome(validationRequest, response),
+		Changed:     response.PreviousResult != "",
+		AccountHash: accountHash(*validationRequest.AccountNumber),
+		RequestID:   requestID(ctx, request),
+		ValidatedAt: dispatcher.now().UTC(),
+		Response:    response,
+	}
+	if validationRequest.ClientReference != nil {
+		payload.ClientReference = *validationRequest.ClientReference
+	}
+	pending := outboxFrom(ctx)
+	deliveries := []*webhookDelivery{}
+	for _, webhook := range config.Webhooks {
+		if !webhook.wants(payload.Outcome, payload.Changed) {
+			continue
+		}
+		payload.ID = newCallID()
+		body, err := marshalJSON(payload)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if pending != nil {
+			pending.add(OutboxEvent{ID: payload.ID, Kind: OutboxKindWebhook, Target: webhook.Name, Body: string(body)})
+			continue
+		}
+		deliveries = append(deliveries, &webhookDelivery{webhook: webhook, id: payload.ID, body: body, due: payload.ValidatedAt})
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	if len(dispatcher.queue)+len(deliveries) > maxQueuedWebhooks {
+		log.Printf("webhook queue is full, dropped %d deliveries", len(deliveries))
+		return
+	}
+	dispatcher.queue = append(dispatcher.queue, deliveries...)
+}
+
+// Signs and POSTs the delivery, an error for anything but a 2xx
+func (dispatcher *webhookDispatcher) send(ctx context.Context, delivery *webhookDelivery) error {
+	request, err := http.NewRequestWithContext(ctx, "POST", delivery.webhook.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Webhook-Id", delivery.id)
+	if err := delivery.webhook.Signing.sign(request, delivery.body, dispatcher.now()); err != nil {
+		return err
+	}
+	response, err := dispatcher.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered %s", delivery.webhook.Name, response.Status)
+	}
+	return nil
+}
+
+func retryDelay(attempts int) time.Duration {
+	delay := webhookBackoff << (attempts - 1)
+	if delay > maxWebhookBackoff || delay <= 0 {
+		return maxWebhookBackoff
+	}
+	return delay
+}
+
+// Sends every delivery that's due, in parallel, requeueing the failures and dead lettering the ones out of attempts
+func (dispatcher *webhookDispatcher) flush(ctx context.Context) {
+	now := dispatcher.now()
+	dispatcher.mu.Lock()
+	due, waiting := []*webhookDelivery{}, []*webhookDelivery{}
+	for _, delivery := range dispatcher.queue {
+		if delivery.due.After(now) {
+			waiting = append(waiting, delivery)
+		} else {
+			due = append(due, delivery)
+		}
+	}
+	dispatcher.queue = waiting
+	dispatcher.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, delivery := range due {
+		wg.Add(1)
+		go func(delivery *webhookDelivery) {
+			defer wg.Done()
+			err := dispatcher.send(ctx, delivery)
+			if err == nil {
+				return
+			}
+			delivery.attempts++
+			delivery.lastErr = err.Error()
+			if delivery.attempts >= delivery.webhook.maxAttempts() {
+				dispatcher.deadLetter(ctx, delivery)
+				return
+			}
+			delivery.due = dispatcher.now().Add(retryDelay(delivery.attempts))
+			dispatcher.mu.Lock()
+			dispatcher.queue = append(dispatcher.queue, delivery)
+			dispatcher.mu.Unlock()
+		}(delivery)
+	}
+	wg.Wait()
+}
+
+func (dispatcher *webhookDispatcher) deadLetter(ctx context.Context, delivery *webhookDelivery) {
+	if dispatcher.dlq == nil {
+		log.Printf("dropped webhook delivery %s after %d attempts: %s", delivery.id, delivery.attempts, delivery.lastErr)
+		return
+	}
+	message, err := marshalJSON(map[string]interface{}{
+		"webhook":  delivery.webhook.Name,
+		"id":       delivery.id,
+		"attempts": delivery.attempts,
+		"error":    delivery.lastErr,
+		"body":     string(delivery.body),
+	})
+	if err == nil {
+		err = dispatcher.dlq.SendMessage(ctx, dispatcher.dlqURL, string(message))
+	}
+	if err != nil {
+		log.Printf("dropped webhook delivery %s, unable to dead letter it: %v", delivery.id, err)
+	}
+}
+
+// Queues deliveries when there are subscribers, sending them with the telemetry
+func (config *Config) setupWebhooks(ctx context.Context) {
+	if len(config.Webhooks) == 0 {
+		return
+	}
+	dispatcher := newWebhookDispatcher()
+	if queueURL, exists := os.LookupEnv("WEBHOOK_DLQ_URL"); exists {
+		client, err := newSQSClient(ctx)
+		if err != nil {
+			log.Printf("unable to connect to the webhook dead letter queue: %v", err)
+		} else {
+			dispatcher.dlq, dispatcher.dlqURL = client, queueURL
+		}
+	}
+	config.webhookDispatcher = dispatcher
+	config.telemetry.onFlush(dispatcher.flush)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+func Test_validateWebhooks(t *testing.T) {
+	signing := &SigningConfig{Primary: &SigningKey{ID: "ledger-2024", Secret: "shh"}}
+	tests := []struct {
+		name     string
+		webhooks []*WebhookConfig
+		wantErr  bool
+	}{
+		{"none", nil, false},
+		{"webhook", []*WebhookConfig{{Name: "ledger", URL: "https://ledger.example.com/hooks", Events: []string{"invalid", "changed"}, Signing: signing}}, false},
+		{"no name", []*WebhookConfig{{URL: "https://ledger.example.com/hooks", Signing: signing}}, true},
+		{"twice", []*WebhookConfig{{Name: "ledger", URL: "https://ledger.example.com/hooks", Signing: signing}, {Name: "ledger", URL: "https://ledger.example.com/other", Signing: signing}}, true},
+		{"http", []*WebhookConfig{{Name: "ledger", URL: "http://ledger.example.com/hooks", Signing: signing}}, true},
+		{"unknown event", []*WebhookConfig{{Name: "ledger", URL: "https://ledger.example.com/hooks", Events: []string{"maybe"}, Signing: signing}}, true},
+		{"negative attempts", []*WebhookConfig{{Name: "ledger", URL: "https://ledger.example.com/hooks", MaxAttempts: -1, Signing: signing}}, true},
+		{"unsigned", []*WebhookConfig{{Name: "ledger", URL: "https://ledger.example.com/hooks"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateWebhooks(tt.webhooks); (err != nil) != tt.wantErr {
+				t.Errorf("validateWebhooks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookConfig_wants(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  []string
+		outcome string
+		changed bool
+		want    bool
+	}{
+		{"everything", nil, ResultStatusValid, false, true},
+		{"outcome", []string{"invalid"}, ResultStatusInvalid, false, true},
+		{"other outcome", []string{"invalid"}, ResultStatusValid, false, false},
+		{"changed", []string{"changed"}, ResultStatusValid, true, true},
+		{"unchanged", []string{"changed"}, ResultStatusValid, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhook := &WebhookConfig{Events: tt.events}
+			if got := webhook.wants(tt.outcome, tt.changed); got != tt.want {
+				t.Errorf("wants() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type recordingDLQ struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (dlq *recordingDLQ) SendMessage(ctx context.Context, queueURL, body string) error {
+	dlq.mu.Lock()
+	defer dlq.mu.Unlock()
+	if queueURL != "https://sqs.eu-west-1.amazonaws.com/123456789012/webhooks-dlq" {
+		return errors.New("wrong queue")
+	}
+	dlq.messages = append(dlq.messages, body)
+	return nil
+}
+
+func TestConfig_Router_webhooks(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string][]WebhookPayload{}
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(signatureTimeHeader), 10, 64)
+		if r.Header.Get(defaultSignatureHeader) != signature("shh", timestamp, body) || r.Header.Get(signatureKeyIDHeader) != "ledger-2024" {
+			t.Errorf("badly signed webhook %v", r.Header)
+		}
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil || r.Header.Get("X-Webhook-Id") != payload.ID {
+			t.Errorf("bad webhook %s %v", body, err)
+		}
+		hook := strings.TrimPrefix(r.URL.Path, "/")
+		received[hook] = append(received[hook], payload)
+	}))
+	defer server.Close()
+	signing := &SigningConfig{Primary: &SigningKey{ID: "ledger-2024", Secret: "shh"}}
+	dlq := &recordingDLQ{}
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}},
+		Webhooks: []*WebhookConfig{
+			{Name: "everything", URL: server.URL + "/everything", MaxAttempts: 2, Signing: signing},
+			{Name: "invalid", URL: server.URL + "/invalid", Events: []string{"invalid"}, Signing: signing},
+		},
+		webhookDispatcher: newWebhookDispatcher(),
+	}
+	config.webhookDispatcher.now = func() time.Time { return now }
+	config.webhookDispatcher.dlq, config.webhookDispatcher.dlqURL = dlq, "https://sqs.eu-west-1.amazonaws.com/123456789012/webhooks-dlq"
+	validate := func() {
+		request := Request{HTTPMethod: "POST", Path: "/application", Body: "{\"accountNumber\": \"12345670\", \"clientReference\": \"inv-1\"}"}
+		request.RequestContext.RequestID = "c6af9ac6"
+		if got, err := config.Router(context.Background(), request); err != nil || got.StatusCode != 200 {
+			t.Fatalf("Router() = %d %v", got.StatusCode, err)
+		}
+	}
+
+	// Only the subscriber that wants valid outcomes hears about one
+	validate()
+	config.webhookDispatcher.flush(context.Background())
+	if len(received["everything"]) != 1 || len(received["invalid"]) != 0 {
+		t.Fatalf("received %v, want one delivery to everything", received)
+	}
+	got := received["everything"][0]
+	if got.Outcome != ResultStatusValid || got.ClientReference != "inv-1" || got.RequestID != "c6af9ac6" || got.AccountHash != accountHash("12345670") || !got.ValidatedAt.Equal(now) {
+		t.Errorf("delivered %+v", got)
+	}
+
+	// A failure waits out the backoff, then runs out of attempts
+	failing = true
+	validate()
+	config.webhookDispatcher.flush(context.Background())
+	config.webhookDispatcher.flush(context.Background())
+	if len(config.webhookDispatcher.queue) != 1 || len(dlq.messages) != 0 {
+		t.Fatalf("after one failure queued %d and dead lettered %v, want 1 queued", len(config.webhookDispatcher.queue), dlq.messages)
+	}
+	now = now.Add(time.Second)
+	config.webhookDispatcher.flush(context.Background())
+	if len(config.webhookDispatcher.queue) != 0 || len(dlq.messages) != 1 || !strings.Contains(dlq.messages[0], `"webhook":"everything"`) || !strings.Contains(dlq.messages[0], "503") {
+		t.Errorf("after two failures queued %d and dead lettered %v", len(config.webhookDispatcher.queue), dlq.messages)
+	}
+}
+
+func Test_retryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 20: 5 * time.Minute, 80: 5 * time.Minute} {
+		if got := retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func Test_sqsClient_SendMessage(t *testing.T) {
+	var input map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &input)
+		if r.Header.Get("X-Amz-Target") != "AmazonSQS.SendMessage" || !strings.Contains(r.Header.Get("Authorization"), "/sqs/aws4_request") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// The SDK checks the digest of the body it sent
+		sum := md5.Sum([]byte(input["MessageBody"]))
+		w.Write([]byte(`{"MessageId": "1", "MD5OfMessageBody": "` + hex.EncodeToString(sum[:]) + `"}`))
+	}))
+	defer server.Close()
+	client := &sqsClient{api: sqs.New(sqs.Options{
+		Region:       "eu-west-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		BaseEndpoint: aws.String(server.URL),
+	})}
+	if err := client.SendMessage(context.Background(), "https://sqs.eu-west-1.amazonaws.com/123456789012/webhooks-dlq", `{"id":"d-1"}`); err != nil {
+		t.Fatal(err)
+	}
+	if input["QueueUrl"] != "https://sqs.eu-west-1.amazonaws.com/123456789012/webhooks-dlq" || input["MessageBody"] != `{"id":"d-1"}` {
+		t.Errorf("input = %v", input)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/*
+  API Gateway WebSocket mode (MODE=websocket). The client sends a normal validation request as a message and gets
+  each provider's verdict pushed back as it arrives, then a final aggregate message with the whole response, so a UI
+  can show results progressively instead of waiting for the slowest provider:
+
+    > {"accountNumber": "12345678", "strategy": "any"}
+    < {"type": "result", "result": {"provider": "provider2", "isValid": true}}
+    < {"type": "result", "result": {"provider": "provider1", "isValid": false}}
+    < {"type": "aggregate", "response": {"result": [...], "aggregate": {"strategy": "any", "isValid": true}}}
+
+  A request that doesn't parse gets {"type": "error", "error": "..."}, and so does one the router would turn away.
+  The WebSocket API is behind API Gateway with the $connect authorizer's context on every message, so messages get
+  the allowlist, authorization, rate limits, quotas, payload rules, admission policies and the noProviders and fanOut
+  rejections like any validation. Messages don't carry headers, so request signatures can only be checked on
+  $connect, by whatever authorizes the connection. Replies go back through the API Gateway management API, signed
+  with the function's own credentials, with one client per stage endpoint kept for the life of the container.
+*/
+
+const (
+	WebsocketMessageResult    = "result"
+	WebsocketMessageAggregate = "aggregate"
+	WebsocketMessageError     = "error"
+)
+
+type WebsocketMessage struct {
+	Type     string                         `json:"type"`
+	Result   *BankAccountValidationResult   `json:"result,omitempty"`
+	Response *BankAccountValidationResponse `json:"response,omitempty"`
+	Error    string                         `json:"error,omitempty"`
+}
+
+// Sends a message to a connected client
+type connectionPoster interface {
+	PostToConnection(ctx context.Context, connectionID string, data []byte) error
+}
+
+// Posts through the API Gateway management API, with signedAWSClient (see aws.go)
+type apiGatewayConnections struct {
+	api      *signedAWSClient
+	endpoint string
+}
+
+// One client per stage endpoint for the container, rather than one per message
+var websocketConnections struct {
+	mu         sync.Mutex
+	byEndpoint map[string]*apiGatewayConnections
+}
+
+func newAPIGatewayConnections(ctx context.Context, endpoint string) (*apiGatewayConnections, error) {
+	websocketConnections.mu.Lock()
+	defer websocketConnections.mu.Unlock()
+	if connections, exists := websocketConnections.byEndpoint[endpoint]; exists {
+		return connections, nil
+	}
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	connections := &apiGatewayConnections{api: newSignedAWSClient(cfg, "execute-api", 2*time.Second), endpoint: endpoint}
+	if websocketConnections.byEndpoint == nil {
+		websocketConnections.byEndpoint = map[string]*apiGatewayConnections{}
+	}
+	websocketConnections.byEndpoint[endpoint] = connections
+	return connections, nil
+}
+
+func (connections *apiGatewayConnections) PostToConnection(ctx context.Context, connectionID string, data []byte) error {
+	if _, err := connections.api.send(ctx, "POST", connections.endpoint+"/@connections/"+url.PathEscape(connectionID), nil, data); err != nil {
+		return fmt.Errorf("posting to connection %s failed: %w", connectionID, err)
+	}
+	return nil
+}
+
+func postMessage(ctx context.Context, poster connectionPoster, connectionID string, message WebsocketMessage) {
+	data, err := marshalJSON(message)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if err := poster.PostToConnection(ctx, connectionID, data); err != nil {
+		log.Print(err)
+	}
+}
+
+// Lambda handler for the WebSocket API's $connect, $disconnect and $default routes
+func (config *Config) WebsocketHandler(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (Response, error) {
+	switch request.RequestContext.RouteKey {
+	case "$connect", "$disconnect":
+		return Response{StatusCode: 200}, nil
+	}
+
+	poster := config.websocket
+	if poster == nil {
+		endpoint := "https://" + request.RequestContext.DomainName + "/" + request.RequestContext.Stage
+		connections, err := newAPIGatewayConnections(ctx, endpoint)
+		if err != nil {
+			return *handleError(err, "unable to reply on the websocket"), nil
+		}
+		poster = connections
+	}
+	connectionID := request.RequestContext.ConnectionID
+
+	caller := Request{HTTPMethod: "POST", Headers: request.Headers, Body: request.Body}
+	caller.RequestContext.RequestID = request.RequestContext.RequestID
+	caller.RequestContext.Identity = request.RequestContext.Identity
+	caller.RequestContext.Authorizer, _ = request.RequestContext.Authorizer.(map[string]interface{})
+	if rejected := config.enforceAllowlist(ctx, caller); rejected != nil {
+		postMessage(ctx, poster, connectionID, WebsocketMessage{Type: WebsocketMessageError, Error: rejectionMessage(*rejected)})
+		return Response{StatusCode: 200}, nil
+	}
+	ctx, rejected := config.admitCaller(ctx, caller)
+	if rejected != nil {
+		postMessage(ctx, poster, connectionID, WebsocketMessage{Type: WebsocketMessageError, Error: rejectionMessage(*rejected)})
+		return Response{StatusCode: 200}, nil
+	}
+	validationRequest, message, err := decodeRequest(caller)
+	if err != nil {
+		log.Print(err)
+		postMessage(ctx, poster, connectionID, WebsocketMessage{Type: WebsocketMessageError, Error: message})
+		return Response{StatusCode: 200}, nil
+	}
+	if message := config.itemError(ctx, caller, validationRequest); message != "" {
+		postMessage(ctx, poster, connectionID, WebsocketMessage{Type: WebsocketMessageError, Error: message})
+		return Response{StatusCode: 200}, nil
+	}
+
+	fields := config.visibleFields(caller)
+	onResult := func(result BankAccountValidationResult) {
+		postMessage(ctx, poster, connectionID, WebsocketMessage{Type: WebsocketMessageResult, Result: &result})
+	}
+	// Callers that can't see the results don't get them one at a time either
+	if fields != nil && !fields[ResponseFieldResult] {
+		onResult = nil
+	}
+	response := filterResponse(fields, config.validate(ctx, caller, validationRequest, onResult))
+	postMessage(ctx, poster, connectionID, WebsocketMessage{Type: WebsocketMessageAggregate, Response: &response})
+	return Response{StatusCode: 200}, nil
+}
+
+// The error in a response the router would have sent, for the client to see
+func rejectionMessage(response Response) string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(response.Body), &body); err != nil || body.Error == "" {
+		return http.StatusText(response.StatusCode)
+	}
+	return body.Error
+}
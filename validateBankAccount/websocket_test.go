@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type fakeConnections struct {
+	mu       sync.Mutex
+	messages []WebsocketMessage
+}
+
+func (connections *fakeConnections) PostToConnection(ctx context.Context, connectionID string, data []byte) error {
+	var message WebsocketMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return err
+	}
+	connections.mu.Lock()
+	defer connections.mu.Unlock()
+	connections.messages = append(connections.messages, message)
+	return nil
+}
+
+func TestConfig_WebsocketHandler(t *testing.T) {
+	connections := &fakeConnections{}
+	config := &Config{
+		Providers: []Provider{{Name: "provider1", Type: ProviderTypeSimulated}, {Name: "provider2", Type: ProviderTypeSimulated}},
+		websocket: connections,
+	}
+	request := events.APIGatewayWebsocketProxyRequest{Body: "{\"accountNumber\": \"12345670\", \"strategy\": \"all\"}"}
+	request.RequestContext.RouteKey = "$default"
+	request.RequestContext.ConnectionID = "L0SM9cOFvHcCIhw="
+
+	if response, err := config.WebsocketHandler(context.Background(), request); err != nil || response.StatusCode != 200 {
+		t.Fatalf("WebsocketHandler() = %v, %v", response, err)
+	}
+	if len(connections.messages) != 3 {
+		t.Fatalf("expected 2 results and an aggregate, got %+v", connections.messages)
+	}
+	seen := map[string]bool{}
+	for _, message := range connections.messages[:2] {
+		if message.Type != WebsocketMessageResult || message.Result == nil {
+			t.Fatalf("expected a result message, got %+v", message)
+		}
+		seen[message.Result.Provider] = true
+	}
+	if !seen["provider1"] || !seen["provider2"] {
+		t.Errorf("expected a result for each provider, got %+v", connections.messages[:2])
+	}
+	last := connections.messages[2]
+	if last.Type != WebsocketMessageAggregate || last.Response == nil || last.Response.Aggregate == nil || !last.Response.Aggregate.IsValid {
+		t.Errorf("expected a valid aggregate last, got %+v", last)
+	}
+}
+
+func TestConfig_WebsocketHandler_badRequest(t *testing.T) {
+	connections := &fakeConnections{}
+	config := &Config{websocket: connections}
+	request := events.APIGatewayWebsocketProxyRequest{Body: "{}"}
+	request.RequestContext.RouteKey = "$default"
+
+	config.WebsocketHandler(context.Background(), request)
+	want := WebsocketMessage{Type: WebsocketMessageError, Error: "account number missing from payload"}
+	if len(connections.messages) != 1 || connections.messages[0] != want {
+		t.Errorf("messages = %+v, want %+v", connections.messages, want)
+	}
+
+	request.RequestContext.RouteKey = "$connect"
+	if response, _ := config.WebsocketHandler(context.Background(), request); response.StatusCode != 200 || len(connections.messages) != 1 {
+		t.Errorf("$connect should just be accepted")
+	}
+}
+
+func TestConfig_WebsocketHandler_admission(t *testing.T) {
+	connections := &fakeConnections{}
+	config := admittedConfig(t, AdmissionPolicy{Name: "everything", Rule: `!has(request.strategy) || request.strategy != "all" || "validate/all" in caller.scopes`, Message: "strategy all needs validate/all"})
+	config.websocket = connections
+	message := func(scope, body string) events.APIGatewayWebsocketProxyRequest {
+		request := events.APIGatewayWebsocketProxyRequest{Body: body}
+		request.RequestContext.RouteKey = "$default"
+		request.RequestContext.Authorizer = scopedRequest(scope, body).RequestContext.Authorizer
+		return request
+	}
+
+	config.WebsocketHandler(context.Background(), message("validate/basic", `{"accountNumber": "12345670", "strategy": "all"}`))
+	want := WebsocketMessage{Type: WebsocketMessageError, Error: "strategy all needs validate/all"}
+	if len(connections.messages) != 1 || connections.messages[0] != want {
+		t.Fatalf("messages = %+v, want %+v", connections.messages, want)
+	}
+
+	connections.messages = nil
+	config.WebsocketHandler(context.Background(), message("validate/basic validate/all", `{"accountNumber": "12345670", "strategy": "all"}`))
+	if len(connections.messages) != 2 || connections.messages[1].Type != WebsocketMessageAggregate {
+		t.Errorf("messages = %+v, want a result and an aggregate", connections.messages)
+	}
+}
+
+func Test_apiGatewayConnections_PostToConnection(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer server.Close()
+
+	connections := &apiGatewayConnections{api: testAWSClient("execute-api"), endpoint: server.URL + "/dev"}
+	if err := connections.PostToConnection(context.Background(), "abc=", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if got.URL.Path != "/dev/@connections/abc=" {
+		t.Errorf("path = %v", got.URL.Path)
+	}
+	if auth := got.Header.Get("Authorization"); !strings.Contains(auth, "eu-west-1/execute-api/aws4_request") {
+		t.Errorf("Authorization = %v, want a SigV4 signature for execute-api", auth)
+	}
+}
+
+func Test_newAPIGatewayConnections(t *testing.T) {
+	t.Setenv("AWS_REGION", "eu-west-1")
+	first, err := newAPIGatewayConnections(context.Background(), "https://abc.execute-api.eu-west-1.amazonaws.com/dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := newAPIGatewayConnections(context.Background(), "https://abc.execute-api.eu-west-1.amazonaws.com/dev")
+	other, _ := newAPIGatewayConnections(context.Background(), "https://abc.execute-api.eu-west-1.amazonaws.com/prod")
+	if again != first || other == first {
+		t.Error("newAPIGatewayConnections() should keep one client per endpoint")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+)
+
+/*
+  Worker pool for provider calls. Rather than a goroutine per provider per request, calls are handed to a fixed set
+  of workers started once per container and reused by every invocation while it's warm. With batches fanning out to
+  thousands of calls this keeps the scheduler from churning through short lived goroutines, and it caps how many
+  calls the container makes at once.
+
+  PROVIDER_WORKERS sets the size, otherwise it's worked out from the function's memory size (see tuning.go). 0 turns
+  the pool off and goes back to a goroutine per call. A call waiting for a worker gives up waiting when its
+  request's deadline passes and runs anyway, failing straight away.
+*/
+
+// Outside of Lambda
+const defaultProviderWorkers = 64
+
+type workerPool struct {
+	jobs chan func()
+}
+
+// Shared by every request in the container, nil means a goroutine per call
+var providerPool *workerPool
+
+func newWorkerPool(size int) *workerPool {
+	pool := &workerPool{jobs: make(chan func())}
+	for i := 0; i < size; i++ {
+		go func() {
+			for job := range pool.jobs {
+				job()
+			}
+		}()
+	}
+	return pool
+}
+
+// Runs the job on the next free worker
+func (pool *workerPool) submit(ctx context.Context, job func()) {
+	if pool == nil {
+		go job()
+		return
+	}
+	select {
+	case pool.jobs <- job:
+	case <-ctx.Done():
+		go job()
+	}
+}
+
+func setupWorkerPool() {
+	size, ok := providerWorkers()
+	if !ok {
+		log.Printf("ignoring PROVIDER_WORKERS %q", os.Getenv("PROVIDER_WORKERS"))
+	}
+	if size > 0 {
+		providerPool = newWorkerPool(size)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_workerPool_submit(t *testing.T) {
+	pool := newWorkerPool(2)
+	var running, most int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		pool.submit(context.Background(), func() {
+			defer wg.Done()
+			now := atomic.AddInt32(&running, 1)
+			for {
+				seen := atomic.LoadInt32(&most)
+				if now <= seen || atomic.CompareAndSwapInt32(&most, seen, now) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+	}
+	wg.Wait()
+	if most > 2 {
+		t.Errorf("%d jobs ran at once on a pool of 2", most)
+	}
+}
+
+// A job whose deadline passes while every worker is busy still runs
+func Test_workerPool_submit_deadline(t *testing.T) {
+	pool := newWorkerPool(1)
+	block := make(chan struct{})
+	defer close(block)
+	pool.submit(context.Background(), func() { <-block })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ran := make(chan struct{})
+	pool.submit(ctx, func() { close(ran) })
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("the job never ran")
+	}
+}
+
+// More providers than workers mustn't deadlock, with or without a streaming reader
+func Test_checkProvidersAsync_pool(t *testing.T) {
+	providerPool = newWorkerPool(1)
+	defer func() { providerPool = nil }()
+	providers := []Provider{
+		{Name: "provider1", Type: ProviderTypeSimulated},
+		{Name: "provider2", Type: ProviderTypeSimulated},
+		{Name: "provider3", Type: ProviderTypeSimulated},
+	}
+	want := BankAccountValidationResponse{Result: []BankAccountValidationResult{
+		{Provider: "provider1", IsValid: true}, {Provider: "provider2", IsValid: true}, {Provider: "provider3", IsValid: true},
+	}}
+	if got := checkProviders(context.Background(), "12345670", providers); !reflect.DeepEqual(got, want) {
+		t.Errorf("checkProviders() = %+v, want %+v", got, want)
+	}
+	notified := 0
+	results := notify(checkProvidersAsync(context.Background(), "12345670", providers), func(BankAccountValidationResult) { notified++ })
+	if got := aggregateResults(providers, results); !reflect.DeepEqual(got, want) || notified != 3 {
+		t.Errorf("aggregateResults() = %+v after %d notifications", got, notified)
+	}
+}